@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// benchConfig holds the flags of the bench subcommand.
+type benchConfig struct {
+	target      string
+	model       string
+	endpoint    string
+	prompt      string
+	promptFile  string
+	requests    int
+	duration    time.Duration
+	concurrency int
+	stream      bool
+	numPredict  int
+	timeout     time.Duration
+	jsonOut     bool
+}
+
+// benchResult is the outcome of one request.
+type benchResult struct {
+	latency          time.Duration
+	ttft             time.Duration
+	completionTokens int64
+	promptTokens     int64
+	err              string
+}
+
+type latencyStats struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// benchReport is the summary printed at the end of a run. Durations are in
+// seconds.
+type benchReport struct {
+	Target           string         `json:"target"`
+	Model            string         `json:"model"`
+	Endpoint         string         `json:"endpoint"`
+	Stream           bool           `json:"stream"`
+	Concurrency      int            `json:"concurrency"`
+	Requests         int            `json:"requests"`
+	Errors           int            `json:"errors"`
+	ErrorKinds       map[string]int `json:"error_kinds,omitempty"`
+	WallSeconds      float64        `json:"wall_seconds"`
+	RequestsPerSec   float64        `json:"requests_per_second"`
+	Latency          latencyStats   `json:"latency_seconds"`
+	TTFT             latencyStats   `json:"ttft_seconds"`
+	PromptTokens     int64          `json:"prompt_tokens"`
+	CompletionTokens int64          `json:"completion_tokens"`
+	// TokensPerSec is completion tokens over wall time (aggregate throughput);
+	// PerRequestTokensPerSec is the median generation speed after TTFT.
+	TokensPerSec           float64 `json:"tokens_per_second"`
+	PerRequestTokensPerSec float64 `json:"per_request_tokens_per_second"`
+}
+
+// runBench implements `ollama-proxy-metrics bench`. It returns the process
+// exit code: 0 on success, 1 when requests failed, 2 on usage errors.
+func runBench(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	c := benchConfig{}
+	fs.StringVar(&c.target, "url", "http://127.0.0.1:8080", "base URL of the proxy or Ollama server to benchmark")
+	fs.StringVar(&c.model, "model", "", "model to request (required)")
+	fs.StringVar(&c.endpoint, "endpoint", "generate", "generate or chat")
+	fs.StringVar(&c.prompt, "prompt", "Why is the sky blue?", "prompt text")
+	fs.StringVar(&c.promptFile, "prompt-file", "", "read the prompt from this file instead of -prompt")
+	fs.IntVar(&c.requests, "n", 10, "number of requests to send (ignored with -duration)")
+	fs.DurationVar(&c.duration, "duration", 0, "run for this long instead of a fixed -n, e.g. 60s")
+	fs.IntVar(&c.concurrency, "concurrency", 1, "requests in flight at once")
+	fs.BoolVar(&c.stream, "stream", true, "request streaming responses")
+	fs.IntVar(&c.numPredict, "num-predict", 0, "options.num_predict to send; 0 leaves it to the model")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Minute, "per-request timeout")
+	fs.BoolVar(&c.jsonOut, "json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if c.model == "" {
+		fmt.Fprintln(stderr, "bench: -model is required")
+		return 2
+	}
+	if c.endpoint != "generate" && c.endpoint != "chat" {
+		fmt.Fprintf(stderr, "bench: -endpoint must be generate or chat, got %q\n", c.endpoint)
+		return 2
+	}
+	if c.concurrency < 1 || (c.duration <= 0 && c.requests < 1) {
+		fmt.Fprintln(stderr, "bench: -concurrency and -n must be at least 1")
+		return 2
+	}
+	if c.promptFile != "" {
+		b, err := os.ReadFile(c.promptFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "bench: %v\n", err)
+			return 2
+		}
+		c.prompt = string(b)
+	}
+	if _, err := url.Parse(c.target); err != nil {
+		fmt.Fprintf(stderr, "bench: invalid -url: %v\n", err)
+		return 2
+	}
+
+	rep := benchmark(context.Background(), c)
+	if c.jsonOut {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(rep)
+	} else {
+		printBenchReport(stdout, rep)
+	}
+	if rep.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// benchmark runs the configured load and summarizes it.
+func benchmark(ctx context.Context, c benchConfig) benchReport {
+	body := benchBody(c)
+	endpoint := strings.TrimRight(c.target, "/") + "/api/" + c.endpoint
+	client := &http.Client{Timeout: c.timeout}
+
+	if c.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.duration)
+		defer cancel()
+	}
+
+	// With -duration, workers keep taking tickets until the deadline; the
+	// requests still in flight at that point are allowed to finish.
+	tickets := make(chan struct{})
+	go func() {
+		defer close(tickets)
+		for i := 0; c.duration > 0 || i < c.requests; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case tickets <- struct{}{}:
+			}
+		}
+	}()
+
+	var (
+		mu      sync.Mutex
+		results []benchResult
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	for range c.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range tickets {
+				res := benchOne(client, endpoint, body, c.stream)
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return summarize(c, results, time.Since(start))
+}
+
+func benchBody(c benchConfig) []byte {
+	req := map[string]any{"model": c.model, "stream": c.stream}
+	if c.endpoint == "chat" {
+		req["messages"] = []map[string]string{{"role": "user", "content": c.prompt}}
+	} else {
+		req["prompt"] = c.prompt
+	}
+	if c.numPredict > 0 {
+		req["options"] = map[string]any{"num_predict": c.numPredict}
+	}
+	b, _ := json.Marshal(req)
+	return b
+}
+
+// benchOne sends one request and parses the response with the proxy's own
+// chunk accounting.
+func benchOne(client *http.Client, endpoint string, body []byte, stream bool) benchResult {
+	start := time.Now()
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return benchResult{latency: time.Since(start), err: errorKind(err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return benchResult{latency: time.Since(start), err: fmt.Sprintf("http_%d", resp.StatusCode)}
+	}
+
+	var stats proxy.ChunkStats
+	var ttft time.Duration
+	if stream {
+		br := bufio.NewReader(resp.Body)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				if ttft == 0 {
+					ttft = time.Since(start)
+				}
+				stats.Observe(line)
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					return benchResult{latency: time.Since(start), err: errorKind(err)}
+				}
+				break
+			}
+		}
+	} else {
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return benchResult{latency: time.Since(start), err: errorKind(err)}
+		}
+		stats.Observe(b)
+	}
+	latency := time.Since(start)
+	if ttft == 0 {
+		ttft = latency
+	}
+	res := benchResult{latency: latency, ttft: ttft, promptTokens: stats.PromptTokens, completionTokens: stats.CompletionTokens}
+	if !stats.Done {
+		res.err = "incomplete_response"
+	}
+	return res
+}
+
+// errorKind maps transport errors to a small set of report keys.
+func errorKind(err error) string {
+	var ne interface{ Timeout() bool }
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	return "transport"
+}
+
+func summarize(c benchConfig, results []benchResult, wall time.Duration) benchReport {
+	rep := benchReport{
+		Target:      c.target,
+		Model:       c.model,
+		Endpoint:    "/api/" + c.endpoint,
+		Stream:      c.stream,
+		Concurrency: c.concurrency,
+		Requests:    len(results),
+		WallSeconds: wall.Seconds(),
+	}
+	var latencies, ttfts, speeds []float64
+	for _, r := range results {
+		if r.err != "" {
+			rep.Errors++
+			if rep.ErrorKinds == nil {
+				rep.ErrorKinds = map[string]int{}
+			}
+			rep.ErrorKinds[r.err]++
+			continue
+		}
+		latencies = append(latencies, r.latency.Seconds())
+		ttfts = append(ttfts, r.ttft.Seconds())
+		rep.PromptTokens += r.promptTokens
+		rep.CompletionTokens += r.completionTokens
+		if gen := (r.latency - r.ttft).Seconds(); c.stream && gen > 0 && r.completionTokens > 1 {
+			// The first token arrives at TTFT; the rest are generated after it.
+			speeds = append(speeds, float64(r.completionTokens-1)/gen)
+		}
+	}
+	rep.Latency = describe(latencies)
+	rep.TTFT = describe(ttfts)
+	if wall > 0 {
+		rep.RequestsPerSec = float64(len(results)) / wall.Seconds()
+		rep.TokensPerSec = float64(rep.CompletionTokens) / wall.Seconds()
+	}
+	if len(speeds) > 0 {
+		sort.Float64s(speeds)
+		rep.PerRequestTokensPerSec = percentile(speeds, 0.5)
+	}
+	return rep
+}
+
+func describe(v []float64) latencyStats {
+	if len(v) == 0 {
+		return latencyStats{}
+	}
+	sort.Float64s(v)
+	var sum float64
+	for _, x := range v {
+		sum += x
+	}
+	return latencyStats{
+		Min:  v[0],
+		Mean: sum / float64(len(v)),
+		P50:  percentile(v, 0.50),
+		P90:  percentile(v, 0.90),
+		P99:  percentile(v, 0.99),
+		Max:  v[len(v)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile p (0..1) of sorted v.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(float64(len(sorted))*p+0.999999) - 1
+	i = max(0, min(i, len(sorted)-1))
+	return sorted[i]
+}
+
+func printBenchReport(w io.Writer, r benchReport) {
+	fmt.Fprintf(w, "target       %s%s  model=%s  stream=%t  concurrency=%d\n",
+		r.Target, r.Endpoint, r.Model, r.Stream, r.Concurrency)
+	fmt.Fprintf(w, "requests     %d in %.2fs (%.2f req/s), %d errors\n",
+		r.Requests, r.WallSeconds, r.RequestsPerSec, r.Errors)
+	kinds := make([]string, 0, len(r.ErrorKinds))
+	for k := range r.ErrorKinds {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		fmt.Fprintf(w, "  %-18s %d\n", k, r.ErrorKinds[k])
+	}
+	for _, row := range []struct {
+		name string
+		s    latencyStats
+	}{{"latency", r.Latency}, {"ttft", r.TTFT}} {
+		fmt.Fprintf(w, "%-12s min %.3fs  mean %.3fs  p50 %.3fs  p90 %.3fs  p99 %.3fs  max %.3fs\n",
+			row.name, row.s.Min, row.s.Mean, row.s.P50, row.s.P90, row.s.P99, row.s.Max)
+	}
+	fmt.Fprintf(w, "tokens       %d prompt, %d completion, %.1f tok/s aggregate, %.1f tok/s per request (median)\n",
+		r.PromptTokens, r.CompletionTokens, r.TokensPerSec, r.PerRequestTokensPerSec)
+}
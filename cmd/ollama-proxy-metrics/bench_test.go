@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/mock"
+)
+
+func runBenchJSON(t *testing.T, args ...string) (benchReport, int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code := runBench(append(args, "-json"), &out, &errOut)
+	var rep benchReport
+	if code != 2 {
+		if err := json.Unmarshal(out.Bytes(), &rep); err != nil {
+			t.Fatalf("report is not JSON: %v\n%s", err, out.String())
+		}
+	}
+	return rep, code
+}
+
+func TestBench_FixedCount(t *testing.T) {
+	up := httptest.NewServer(mock.New(mock.Options{PromptTokens: 3, CompletionTokens: 4}))
+	defer up.Close()
+
+	for _, stream := range []string{"true", "false"} {
+		rep, code := runBenchJSON(t, "-url", up.URL, "-model", "m", "-n", "6", "-concurrency", "3", "-stream="+stream)
+		if code != 0 || rep.Requests != 6 || rep.Errors != 0 {
+			t.Fatalf("stream=%s: code=%d report=%+v", stream, code, rep)
+		}
+		if rep.CompletionTokens != 24 || rep.PromptTokens != 18 {
+			t.Errorf("stream=%s: expected 24 completion / 18 prompt tokens, got %d / %d",
+				stream, rep.CompletionTokens, rep.PromptTokens)
+		}
+		if rep.Latency.P50 <= 0 || rep.TTFT.Max > rep.Latency.Max {
+			t.Errorf("stream=%s: implausible latency stats: %+v ttft %+v", stream, rep.Latency, rep.TTFT)
+		}
+	}
+}
+
+func TestBench_Duration(t *testing.T) {
+	up := httptest.NewServer(mock.New(mock.Options{CompletionTokens: 2, TokensPerSecond: 200}))
+	defer up.Close()
+
+	start := time.Now()
+	rep, code := runBenchJSON(t, "-url", up.URL, "-model", "m", "-endpoint", "chat", "-duration", "100ms", "-concurrency", "2")
+	if code != 0 || rep.Requests < 2 {
+		t.Fatalf("code=%d report=%+v", code, rep)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("duration mode ran for %s", d)
+	}
+}
+
+func TestBench_CountsErrors(t *testing.T) {
+	var n atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1)%2 == 0 {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"done":true,"eval_count":1}` + "\n"))
+	}))
+	defer up.Close()
+
+	rep, code := runBenchJSON(t, "-url", up.URL, "-model", "m", "-n", "4")
+	if code != 1 || rep.Errors != 2 || rep.ErrorKinds["http_500"] != 2 {
+		t.Errorf("expected 2 http_500 errors and exit 1, got code=%d %+v", code, rep)
+	}
+}
+
+func TestBench_RequiresModel(t *testing.T) {
+	if _, code := runBenchJSON(t); code != 2 {
+		t.Errorf("expected usage error without -model, got %d", code)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	v := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if p := percentile(v, 0.5); p != 5 {
+		t.Errorf("p50 = %v, want 5", p)
+	}
+	if p := percentile(v, 0.99); p != 10 {
+		t.Errorf("p99 = %v, want 10", p)
+	}
+}
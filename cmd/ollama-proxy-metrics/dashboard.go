@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Grafana dashboard model, reduced to the fields the generated dashboard
+// uses. Grafana fills in defaults for everything else on import.
+type grafanaDashboard struct {
+	UID           string            `json:"uid"`
+	Title         string            `json:"title"`
+	Tags          []string          `json:"tags"`
+	Timezone      string            `json:"timezone"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          grafanaTimeRange  `json:"time"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []grafanaPanel    `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label"`
+	Type       string      `json:"type"`
+	Query      string      `json:"query"`
+	Datasource *grafanaRef `json:"datasource,omitempty"`
+	Multi      bool        `json:"multi,omitempty"`
+	IncludeAll bool        `json:"includeAll,omitempty"`
+	AllValue   string      `json:"allValue,omitempty"`
+	Refresh    int         `json:"refresh,omitempty"` // 2 = on time range change
+}
+
+type grafanaRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaPanel struct {
+	ID          int             `json:"id"`
+	Type        string          `json:"type"`
+	Title       string          `json:"title"`
+	Description string          `json:"description,omitempty"`
+	Datasource  grafanaRef      `json:"datasource"`
+	GridPos     grafanaGridPos  `json:"gridPos"`
+	FieldConfig grafanaFieldCfg `json:"fieldConfig"`
+	Targets     []grafanaTarget `json:"targets"`
+}
+
+type grafanaGridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type grafanaFieldCfg struct {
+	Defaults struct {
+		Unit string `json:"unit,omitempty"`
+	} `json:"defaults"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+}
+
+// dashboardPanel is one panel before layout: a title, a unit and its queries
+// as legend → PromQL pairs.
+type dashboardPanel struct {
+	kind, title, desc, unit string
+	queries                 [][2]string
+}
+
+// promDatasource refers to the dashboard's datasource variable.
+var promDatasource = grafanaRef{Type: "prometheus", UID: "${datasource}"}
+
+// buildDashboard returns the dashboard for a proxy whose metrics are prefixed
+// with ns.
+func buildDashboard(ns, title, uid string) grafanaDashboard {
+	m := func(name string) string { return ns + "_" + name }
+	const rate = "[$__rate_interval]"
+	sel := `{model=~"$model",endpoint=~"$endpoint"}`
+	errSel := `{model=~"$model",endpoint=~"$endpoint",status=~"5..|timeout"}`
+	quantile := func(q, name, by, s string) string {
+		return fmt.Sprintf("histogram_quantile(%s, sum by (%s) (rate(%s_bucket%s%s)))", q, by, m(name), s, rate)
+	}
+
+	panels := []dashboardPanel{
+		{kind: "timeseries", title: "Request rate", unit: "reqps", queries: [][2]string{
+			{"{{endpoint}} {{status}}", fmt.Sprintf("sum by (endpoint, status) (rate(%s%s%s))", m("requests_total"), sel, rate)},
+		}},
+		{kind: "timeseries", title: "Error ratio (5xx)", unit: "percentunit",
+			desc: "origin=proxy are failures the proxy answered itself (unreachable or timed-out upstream, rejections).",
+			queries: [][2]string{
+				{"{{origin}}", fmt.Sprintf("sum by (origin) (rate(%s%s%s)) / ignoring (origin) group_left sum(rate(%s%s%s))",
+					m("requests_total"), errSel, rate, m("requests_total"), sel, rate)},
+			}},
+		{kind: "timeseries", title: "Latency percentiles", unit: "s", queries: [][2]string{
+			{"p50", quantile("0.5", "request_duration_seconds", "le", sel)},
+			{"p95", quantile("0.95", "request_duration_seconds", "le", sel)},
+			{"p99", quantile("0.99", "request_duration_seconds", "le", sel)},
+		}},
+		{kind: "timeseries", title: "p95 latency by model", unit: "s", queries: [][2]string{
+			{"{{model}}", quantile("0.95", "request_duration_seconds", "le, model", sel)},
+		}},
+		{kind: "timeseries", title: "Failure latency (p95) by status class", unit: "s",
+			desc: "How long failed requests took: a 5xx after 90s is a different problem from one after 50ms.",
+			queries: [][2]string{
+				{"{{status_class}}", quantile("0.95", "error_duration_seconds", "le, status_class", sel)},
+			}},
+		{kind: "timeseries", title: "Token throughput", unit: "short",
+			desc: "Tokens per second as reported by Ollama.",
+			queries: [][2]string{
+				{"completion {{model}}", fmt.Sprintf("sum by (model) (rate(%s%s%s))", m("completion_tokens_total"), sel, rate)},
+				{"prompt {{model}}", fmt.Sprintf("sum by (model) (rate(%s%s%s))", m("prompt_tokens_total"), sel, rate)},
+			}},
+		{kind: "timeseries", title: "Token cost by upstream", unit: "short",
+			desc: "Spend per second by the -token-prices table; unpriced upstreams are free.",
+			queries: [][2]string{
+				{"{{upstream}}", fmt.Sprintf("sum by (upstream) (rate(%s%s%s))", m("token_cost_total"), sel, rate)},
+			}},
+		{kind: "timeseries", title: "Time to first token (p95)", unit: "s",
+			desc: "Until the first streamed chunk; until the whole body for stream=false.",
+			queries: [][2]string{
+				{"{{model}}", quantile("0.95", "time_to_first_token_seconds", "le, model", sel)},
+			}},
+		{kind: "timeseries", title: "Time to first token (canary, p95)", unit: "s",
+			desc: "Measured by the -canary-models probes.",
+			queries: [][2]string{
+				{"{{model}}", quantile("0.95", "canary_ttft_seconds", "le, model", `{model=~"$model"}`)},
+			}},
+		{kind: "timeseries", title: "Generation throughput (median, tok/s)", unit: "short",
+			desc: "eval_count over eval_duration as Ollama reported them.",
+			queries: [][2]string{
+				{"{{model}}", quantile("0.5", "generation_tokens_per_second", "le, model", `{model=~"$model"}`)},
+			}},
+		{kind: "timeseries", title: "In-flight requests", unit: "short", queries: [][2]string{
+			{"{{model}}", fmt.Sprintf("sum by (model) (%s%s)", m("inflight_requests"), sel)},
+		}},
+		{kind: "timeseries", title: "Queue wait (p95)", unit: "s",
+			desc: "Time requests waited for a -max-concurrent-per-model slot.",
+			queries: [][2]string{
+				{"{{model}} {{priority}}", quantile("0.95", "queue_wait_seconds", "le, model, priority", `{model=~"$model"}`)},
+			}},
+		{kind: "timeseries", title: "Tokens used this minute", unit: "short", queries: [][2]string{
+			{"{{tenant}}", fmt.Sprintf("sum by (tenant) (%s)", m("tpm_used_tokens"))},
+		}},
+		{kind: "table", title: "Upstream", queries: [][2]string{
+			{"{{url}}", fmt.Sprintf("max by (url) (%s)", m("upstream_info"))},
+		}},
+		{kind: "timeseries", title: "Upstream health", unit: "short",
+			desc: "Canary failures, proxy-answered 502/504/timeout and malformed stream lines per interval.",
+			queries: [][2]string{
+				{"canary failures {{model}}", fmt.Sprintf(`sum by (model) (increase(%s{model=~"$model"}%s))`, m("canary_failures_total"), rate)},
+				{"{{status}} from proxy", fmt.Sprintf(`sum by (status) (increase(%s{model=~"$model",endpoint=~"$endpoint",origin="proxy",status=~"502|504|timeout"}%s))`, m("requests_total"), rate)},
+				{"malformed lines", fmt.Sprintf("sum(increase(%s%s%s))", m("malformed_chunks_total"), sel, rate)},
+			}},
+		{kind: "timeseries", title: "Policy rejections", unit: "short", queries: [][2]string{
+			{"{{reason}}", fmt.Sprintf("sum by (reason) (increase(%s%s)) > 0", m("policy_rejections_total"), rate)},
+		}},
+	}
+
+	d := grafanaDashboard{
+		UID:           uid,
+		Title:         title,
+		Tags:          []string{"ollama", "ollama-proxy-metrics"},
+		Timezone:      "browser",
+		SchemaVersion: 39,
+		Refresh:       "30s",
+		Time:          grafanaTimeRange{From: "now-6h", To: "now"},
+	}
+	labelVar := func(name, query string) grafanaVariable {
+		return grafanaVariable{
+			Name: name, Label: name, Type: "query", Query: query, Datasource: &promDatasource,
+			Multi: true, IncludeAll: true, AllValue: ".*", Refresh: 2,
+		}
+	}
+	d.Templating.List = []grafanaVariable{
+		{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		labelVar("model", fmt.Sprintf("label_values(%s, model)", m("requests_total"))),
+		labelVar("endpoint", fmt.Sprintf(`label_values(%s{model=~"$model"}, endpoint)`, m("requests_total"))),
+	}
+	for i, p := range panels {
+		gp := grafanaPanel{
+			ID: i + 1, Type: p.kind, Title: p.title, Description: p.desc, Datasource: promDatasource,
+			GridPos: grafanaGridPos{X: (i % 2) * 12, Y: (i / 2) * 8, W: 12, H: 8},
+		}
+		gp.FieldConfig.Defaults.Unit = p.unit
+		for j, q := range p.queries {
+			gp.Targets = append(gp.Targets, grafanaTarget{RefID: string(rune('A' + j)), LegendFormat: q[0], Expr: q[1]})
+		}
+		d.Panels = append(d.Panels, gp)
+	}
+	return d
+}
+
+// runDashboard implements `ollama-proxy-metrics dashboard`. It accepts the
+// proxy's own flags and environment, so the queries match the metrics a
+// proxy started with the same settings exports. It returns the process exit
+// code: 0 on success, 2 on usage errors.
+func runDashboard(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("dashboard", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	o := registerFlags(fs)
+	title := fs.String("title", "Ollama proxy", "dashboard title")
+	uid := fs.String("uid", "ollama-proxy", "dashboard UID; keep it stable so re-imports replace the dashboard")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !metricsNamespaceRE.MatchString(o.metricsNS) {
+		fmt.Fprintf(stderr, "dashboard: -metrics-namespace %q is not a valid metric name prefix\n", o.metricsNS)
+		return 2
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(buildDashboard(o.metricsNS, *title, *uid))
+	return 0
+}
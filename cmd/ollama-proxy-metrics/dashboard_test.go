@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// describeRegisterer records the names of the metrics registered through it.
+type describeRegisterer struct{ names map[string]bool }
+
+var fqNameRE = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+func (d *describeRegisterer) Register(c prometheus.Collector) error {
+	ch := make(chan *prometheus.Desc, 16)
+	go func() { c.Describe(ch); close(ch) }()
+	for desc := range ch {
+		if m := fqNameRE.FindStringSubmatch(desc.String()); m != nil {
+			d.names[m[1]] = true
+		}
+	}
+	return nil
+}
+
+func (d *describeRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		_ = d.Register(c)
+	}
+}
+
+func (d *describeRegisterer) Unregister(prometheus.Collector) bool { return false }
+
+// registeredMetricNames returns the names proxy.NewMetricsNamespace exports.
+func registeredMetricNames(ns string) map[string]bool {
+	d := &describeRegisterer{names: map[string]bool{}}
+	proxy.NewMetricsNamespace(d, ns)
+	return d.names
+}
+
+// checkMetricRefs fails for every ns-prefixed metric in expr that the proxy
+// does not export.
+func checkMetricRefs(t *testing.T, ns, expr string, names map[string]bool) {
+	t.Helper()
+	for _, ref := range regexp.MustCompile(`\b`+ns+`_[a-z_]+`).FindAllString(expr, -1) {
+		base := ref
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if trimmed, ok := strings.CutSuffix(ref, suffix); ok && names[trimmed] {
+				base = trimmed
+			}
+		}
+		if !names[base] {
+			t.Errorf("%s is not exported by the proxy (in %s)", ref, expr)
+		}
+	}
+}
+
+func runDashboardJSON(t *testing.T, args ...string) grafanaDashboard {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	if code := runDashboard(args, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	var d grafanaDashboard
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatalf("output is not JSON: %v", err)
+	}
+	return d
+}
+
+func TestDashboard_QueriesUseExportedMetrics(t *testing.T) {
+	for _, ns := range []string{proxy.DefaultMetricsNamespace, "llm_gateway"} {
+		d := runDashboardJSON(t, "-metrics-namespace", ns)
+		names := registeredMetricNames(ns)
+		if len(d.Panels) == 0 {
+			t.Fatal("expected panels")
+		}
+		for _, p := range d.Panels {
+			if len(p.Targets) == 0 {
+				t.Errorf("panel %q has no queries", p.Title)
+			}
+			for _, tg := range p.Targets {
+				if !strings.Contains(tg.Expr, ns+"_") {
+					t.Errorf("panel %q: query does not use namespace %s: %s", p.Title, ns, tg.Expr)
+				}
+				checkMetricRefs(t, ns, tg.Expr, names)
+			}
+		}
+		for _, v := range d.Templating.List[1:] {
+			checkMetricRefs(t, ns, v.Query, names)
+		}
+	}
+}
+
+func TestDashboard_Variables(t *testing.T) {
+	d := runDashboardJSON(t)
+	var got []string
+	for _, v := range d.Templating.List {
+		got = append(got, v.Name)
+	}
+	if strings.Join(got, ",") != "datasource,model,endpoint" {
+		t.Errorf("unexpected variables %v", got)
+	}
+	for _, p := range d.Panels {
+		if p.Datasource.UID != "${datasource}" {
+			t.Errorf("panel %q does not use the datasource variable", p.Title)
+		}
+	}
+}
+
+func TestDashboard_BadNamespace(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := runDashboard([]string{"-metrics-namespace", "a-b"}, &out, &errOut); code != 2 {
+		t.Errorf("expected exit 2, got %d", code)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// registerRuntimeCollectors adds the Go runtime, with the runtime/metrics
+// set, and the process to reg, so heap, goroutines and GC pauses show up in
+// /metrics next to the proxy's own families.
+func registerRuntimeCollectors(reg prometheus.Registerer) {
+	reg.MustRegister(
+		collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsAll)),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// debugMux returns the handlers of the -debug-addr listener: the
+// net/http/pprof profiles under /debug/pprof/ and a runtime summary at
+// /debug/runtime. They are never mounted on the proxy's own mux.
+func debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/runtime", serveRuntime)
+	return mux
+}
+
+// runtimeStats is the body of GET /debug/runtime.
+type runtimeStats struct {
+	Goroutines          int        `json:"goroutines"`
+	GOMAXPROCS          int        `json:"gomaxprocs"`
+	HeapAllocBytes      uint64     `json:"heap_alloc_bytes"`
+	HeapInuseBytes      uint64     `json:"heap_inuse_bytes"`
+	HeapSysBytes        uint64     `json:"heap_sys_bytes"`
+	HeapObjects         uint64     `json:"heap_objects"`
+	NextGCBytes         uint64     `json:"next_gc_bytes"`
+	NumGC               uint32     `json:"num_gc"`
+	GCPauseTotalSeconds float64    `json:"gc_pause_total_seconds"`
+	LastGCPauseSeconds  float64    `json:"last_gc_pause_seconds"`
+	LastGC              *time.Time `json:"last_gc,omitempty"`
+	GCCPUFraction       float64    `json:"gc_cpu_fraction"`
+}
+
+// readRuntimeStats samples the runtime. ReadMemStats stops the world
+// briefly, which is why it is only served on the debug listener.
+func readRuntimeStats() runtimeStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	st := runtimeStats{
+		Goroutines:          runtime.NumGoroutine(),
+		GOMAXPROCS:          runtime.GOMAXPROCS(0),
+		HeapAllocBytes:      ms.HeapAlloc,
+		HeapInuseBytes:      ms.HeapInuse,
+		HeapSysBytes:        ms.HeapSys,
+		HeapObjects:         ms.HeapObjects,
+		NextGCBytes:         ms.NextGC,
+		NumGC:               ms.NumGC,
+		GCPauseTotalSeconds: time.Duration(ms.PauseTotalNs).Seconds(),
+		GCCPUFraction:       ms.GCCPUFraction,
+	}
+	if ms.NumGC > 0 {
+		st.LastGCPauseSeconds = time.Duration(ms.PauseNs[(ms.NumGC+255)%256]).Seconds()
+		last := time.Unix(0, int64(ms.LastGC)).UTC()
+		st.LastGC = &last
+	}
+	return st
+}
+
+func serveRuntime(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(readRuntimeStats())
+}
+
+// serveDebug serves debugMux on ln, a listener apart from the proxy's so
+// profiles are never reachable on the public port. The returned stop shuts
+// it down within shutdownCloseTimeout, letting a profile being taken finish
+// first when it can.
+func serveDebug(ln net.Listener, logger *slog.Logger) (stop func()) {
+	srv := &http.Server{Handler: debugMux(), ReadHeaderTimeout: 10 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("debug listener stopped", "addr", ln.Addr().String(), "error", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownCloseTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			_ = srv.Close()
+		}
+		<-done
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+func TestServeDebug(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := serveDebug(ln, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	base := "http://" + ln.Addr().String()
+
+	resp, err := http.Get(base + "/debug/runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st runtimeStats
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	_ = resp.Body.Close()
+	if err != nil || st.Goroutines == 0 || st.HeapAllocBytes == 0 || st.GOMAXPROCS == 0 {
+		t.Errorf("expected runtime stats, got %+v, %v", st, err)
+	}
+
+	resp, err = http.Get(base + "/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "goroutine profile:") {
+		t.Errorf("expected a goroutine profile, got %d %.100s", resp.StatusCode, body)
+	}
+
+	stop()
+	if _, err := http.Get(base + "/debug/runtime"); err == nil {
+		t.Error("expected the debug listener closed after stop")
+	}
+}
+
+func TestDebugNotOnProxyPort(t *testing.T) {
+	store, err := db.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close() }()
+	u, _ := url.Parse("http://127.0.0.1:1")
+	reg := prometheus.NewRegistry()
+	h := proxy.New(u, store, slog.New(slog.NewTextHandler(io.Discard, nil)), proxy.NewMetrics(reg), proxy.Config{})
+	defer func() { _ = h.Close() }()
+	mux := http.NewServeMux()
+	for _, rt := range routes(testOptions(t), reg, h, store) {
+		mux.Handle(rt.Pattern, rt.handler)
+	}
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine", "/debug/runtime"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if strings.Contains(rr.Body.String(), "goroutine") {
+			t.Errorf("expected %s not served by the proxy's routes, got %d %.100s", path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestRegisterRuntimeCollectors(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	registerRuntimeCollectors(reg)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"go_goroutines": false, "go_gc_duration_seconds": false, "go_memstats_heap_alloc_bytes": false, "go_sched_gomaxprocs_threads": false}
+	for _, f := range families {
+		if _, ok := want[f.GetName()]; ok {
+			want[f.GetName()] = true
+		}
+	}
+	for name, found := range want {
+		if !found {
+			t.Errorf("expected %s gathered", name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// description is what `describe` prints: everything a proxy started with
+// the same flags can export.
+type description struct {
+	Metrics         []proxy.MetricDescription `json:"metrics"`
+	Endpoints       []route                   `json:"endpoints"`
+	ResponseHeaders []proxy.HeaderDescription `json:"response_headers"`
+}
+
+// buildDescription describes a proxy with o from the code that builds the
+// proxy itself: the metrics are registered by the constructor, the
+// endpoints are the routes main mounts and the headers depend on the same
+// proxy.Config.
+func buildDescription(o *options) (*description, error) {
+	cfg, err := o.proxyConfig()
+	if err != nil {
+		return nil, err
+	}
+	metricsOpts, err := o.metricsOptions()
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := proxy.DescribeMetrics(metricsOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &description{
+		Metrics:         metrics,
+		Endpoints:       routes(o, nil, nil, nil),
+		ResponseHeaders: proxy.ResponseHeaders(cfg),
+	}, nil
+}
+
+// runDescribe implements `ollama-proxy-metrics describe`. Like dashboard
+// and rules it accepts the proxy's own flags and environment. It returns
+// the process exit code: 0 on success, 1 when the description cannot be
+// built, 2 on usage errors.
+func runDescribe(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("describe", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	o := registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !metricsNamespaceRE.MatchString(o.metricsNS) {
+		fmt.Fprintf(stderr, "describe: -metrics-namespace %q is not a valid metric name prefix\n", o.metricsNS)
+		return 2
+	}
+	d, err := buildDescription(o)
+	if err != nil {
+		fmt.Fprintf(stderr, "describe: %v\n", err)
+		return 1
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(d)
+	return 0
+}
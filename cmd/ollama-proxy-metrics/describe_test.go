@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDescribe(t *testing.T) {
+	run := func(args ...string) (*description, int) {
+		t.Helper()
+		var out, errOut bytes.Buffer
+		code := runDescribe(args, &out, &errOut)
+		if code != 0 {
+			return nil, code
+		}
+		var d description
+		if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+			t.Fatalf("describe printed invalid JSON: %v", err)
+		}
+		return &d, code
+	}
+	patterns := func(d *description) map[string]string {
+		out := map[string]string{}
+		for _, e := range d.Endpoints {
+			out[e.Pattern] = e.Auth
+		}
+		return out
+	}
+
+	d, _ := run("-metrics-namespace", "gw")
+	for _, m := range d.Metrics {
+		if !strings.HasPrefix(m.Name, "gw_") {
+			t.Errorf("expected the namespace on every metric, got %s", m.Name)
+		}
+	}
+	if got := patterns(d); got["/api/"] != "none" || got["/metrics"] != "none" {
+		t.Errorf("expected the proxy and metrics endpoints, got %v", got)
+	}
+	if _, ok := patterns(d)["GET /admin/upstream"]; ok {
+		t.Error("expected no admin endpoints without -admin-token")
+	}
+
+	d, _ = run("-metrics-username", "prom", "-metrics-password", "secret")
+	if got := patterns(d)["/metrics"]; got != "metrics-basic" {
+		t.Errorf("expected /metrics behind basic auth, got %q", got)
+	}
+	d, _ = run("-metrics-token", "0123456789abcdef")
+	if got := patterns(d)["/metrics"]; got != "metrics-bearer" {
+		t.Errorf("expected /metrics behind the token, got %q", got)
+	}
+	keys := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keys, []byte("web:0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d, _ = run("-api-keys-file", keys)
+	if got := patterns(d); got["/api/"] != "api-key" || got["/v1/"] != "api-key" {
+		t.Errorf("expected the proxied endpoints behind API keys, got %v", got)
+	}
+
+	d, _ = run("-admin-token", "secret", "-server-timing")
+	if got := patterns(d)["GET /admin/upstream"]; got != "admin-token" {
+		t.Errorf("expected the admin endpoints behind the token, got %q", got)
+	}
+	found := false
+	for _, hd := range d.ResponseHeaders {
+		found = found || hd.Name == "Server-Timing"
+	}
+	if !found {
+		t.Errorf("expected Server-Timing with -server-timing, got %+v", d.ResponseHeaders)
+	}
+
+	if _, code := run("-duration-buckets", "5,1"); code != 1 {
+		t.Errorf("expected exit 1 for invalid buckets, got %d", code)
+	}
+	if _, code := run("-metrics-namespace", "bad-name"); code != 2 {
+		t.Errorf("expected exit 2 for an invalid namespace, got %d", code)
+	}
+}
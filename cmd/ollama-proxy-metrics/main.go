@@ -1,21 +1,32 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/nexusriot/ollama-proxy-metrics/internal/api"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/connlimit"
 	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/kv"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/mock"
 	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
 )
 
@@ -26,99 +37,1053 @@ func getEnv(key, def string) string {
 	return def
 }
 
-func main() {
-	var (
-		listenAddr  string
-		upstreamRaw string
-		dbPath      string
-		logPath     string
-		staticDir   string
-	)
-
-	flag.StringVar(&listenAddr, "listen", getEnv("LISTEN_ADDR", ":8080"),
+// getEnvDuration is getEnv for time.Duration values; unparsable values fall
+// back to def.
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// getEnvInt is getEnv for integers; unparsable values fall back to def.
+func getEnvInt(key string, def int) int {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// getEnvFloat is getEnv for floating point values; unparsable values fall
+// back to def.
+func getEnvFloat(key string, def float64) float64 {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// getEnvBool is getEnv for booleans ("1", "true", ...); unparsable values
+// fall back to def.
+func getEnvBool(key string, def bool) bool {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// options holds every setting the proxy reads from flags and the environment.
+type options struct {
+	listenAddr   string
+	upstreamRaw  string
+	upTokensRaw  string
+	pricesRaw    string
+	authPass     string
+	upPrefix     string
+	backendsRaw  string
+	everywhere   string
+	backendPoll  time.Duration
+	dbPath       string
+	logPath      string
+	logFormat    string
+	accessLog    string
+	summaryInt   time.Duration
+	dedupWindow  time.Duration
+	psInterval   time.Duration
+	tagsInterval time.Duration
+	staticDir    string
+	metricsNS    string
+	metricsUser  string
+	metricsPass  string
+	metricsUsers string
+	metricsToken string
+	bucketsRaw   string
+	durationMode string
+	maxLabelLen  int
+	extraRoutes  string
+	modelMode    string
+	modelAllow   string
+	apdexTarget  time.Duration
+	apdexRaw     string
+	sloFile      string
+	latencyWin   time.Duration
+	apiKeysFile  string
+	compress     bool
+	compressMin  int
+	decompress   bool
+	spillAbove   int64
+	spillDir     string
+	spillMax     int64
+	metaTTL      time.Duration
+	showTTL      time.Duration
+	embedTTL     time.Duration
+	redisAddr    string
+	redisUser    string
+	redisPass    string
+	redisDB      int
+	redisTLS     bool
+	redisTLSNoV  bool
+	rateLimit    int64
+	rateWindow   time.Duration
+	tokenBudget  int64
+	budgetWin    time.Duration
+	quotaFlush   time.Duration
+	tpmLimit     int64
+	charsPerTok  float64
+
+	contextWarn int64
+	ctxCheck    string
+	ctxMargin   float64
+
+	dupRate float64
+	dupSize int
+
+	convHeader string
+	convTTL    time.Duration
+	convMax    int
+
+	unloadOverride string
+	oomCooldown    time.Duration
+	negativeTTL    time.Duration
+	readOnly       bool
+	requirePinned  bool
+	pinnedExempt   string
+	validateUp     bool
+	noInspect      string
+
+	maxPerModel  int
+	queueTimeout time.Duration
+
+	readyMaxInFlight    int
+	readyResumeInFlight int
+	readyMaxWait        time.Duration
+	readyResumeWait     time.Duration
+	readyUpTimeout      time.Duration
+	readyUpCache        time.Duration
+
+	maxPerTenant int
+	tenantRaw    string
+	tenantHeader string
+
+	canaryModels  string
+	canaryEvery   time.Duration
+	canaryPrompt  string
+	canaryPredict int
+
+	adminToken      string
+	h2c             bool
+	tlsCert         string
+	tlsKey          string
+	serverTiming    bool
+	instanceName    string
+	servedBy        bool
+	exposeUpstreams bool
+	shutdownTimeout time.Duration
+	logServerErrs   bool
+	debugAddr       string
+
+	headerTimeout    time.Duration
+	headerTimeoutRaw string
+	nonStreamTO      time.Duration
+	retries          int
+	retryBackoff     time.Duration
+	readTimeout      time.Duration
+	maxRequestBytes  int64
+	sniffBytes       int64
+
+	dialTimeout     time.Duration
+	tlsTimeout      time.Duration
+	idleConnTimeout time.Duration
+	maxIdlePerHost  int
+
+	upstreamCA         string
+	upstreamClientCert string
+	upstreamClientKey  string
+	upstreamServerName string
+	upstreamInsecure   bool
+	preserveHost       bool
+	requestIDHeader    string
+
+	maxConns       int
+	maxConnsPerIP  int
+	trustedProxies string
+
+	mockUpstream  bool
+	mockPromptTok int
+	mockComplTok  int
+	mockTokPerSec float64
+
+	validate      bool
+	validateProbe bool
+	strictStartup bool
+
+	selfTest        bool
+	selfTestModel   string
+	selfTestSkipGen bool
+	selfTestTimeout time.Duration
+}
+
+// registerFlags defines the proxy's flags on fs, defaulting each to its
+// environment variable.
+func registerFlags(fs *flag.FlagSet) *options {
+	o := &options{}
+	fs.StringVar(&o.listenAddr, "listen", getEnv("LISTEN_ADDR", ":8080"),
 		"listen address (env: LISTEN_ADDR)")
-	flag.StringVar(&upstreamRaw, "upstream", getEnv("OLLAMA_UPSTREAM", "http://127.0.0.1:11434"),
-		"Ollama upstream base URL (env: OLLAMA_UPSTREAM)")
-	flag.StringVar(&dbPath, "db", getEnv("DB_PATH", "/data/db.sqlite"),
+	fs.StringVar(&o.upstreamRaw, "upstream", getEnv("OLLAMA_UPSTREAM", "http://127.0.0.1:11434"),
+		"Ollama upstream base URL: http://, https:// or unix:///path/to/ollama.sock (env: OLLAMA_UPSTREAM)")
+	fs.StringVar(&o.upPrefix, "upstream-path-prefix", getEnv("UPSTREAM_PATH_PREFIX", ""),
+		"path put before every forwarded endpoint, e.g. /llm/ollama for an Ollama behind a gateway (env: UPSTREAM_PATH_PREFIX)")
+	fs.StringVar(&o.upTokensRaw, "upstream-tokens", getEnv("UPSTREAM_TOKENS", ""),
+		"bearer tokens per upstream host:port, e.g. ollama.com=KEY; replaces the client's Authorization for that host (env: UPSTREAM_TOKENS)")
+	fs.StringVar(&o.authPass, "upstream-auth-passthrough", getEnv("UPSTREAM_AUTH_PASSTHROUGH", ""),
+		"comma-separated upstream host:port list that gets the client's own Authorization; other upstreams without a token get none (env: UPSTREAM_AUTH_PASSTHROUGH)")
+	fs.StringVar(&o.pricesRaw, "token-prices", getEnv("TOKEN_PRICES", ""),
+		"prompt:completion price per million tokens by upstream host:port or host:port/model, e.g. ollama.com=0.6:2.4; unpriced upstreams are free (env: TOKEN_PRICES)")
+	fs.StringVar(&o.backendsRaw, "backends", getEnv("BACKENDS", ""),
+		"comma-separated Ollama base URLs to spread requests naming a model over, each model on one backend; empty sends everything to -upstream (env: BACKENDS)")
+	fs.StringVar(&o.everywhere, "affinity-everywhere", getEnv("AFFINITY_EVERYWHERE", ""),
+		"comma-separated models served round-robin by every healthy backend instead of one (env: AFFINITY_EVERYWHERE)")
+	fs.DurationVar(&o.backendPoll, "backend-health-interval", getEnvDuration("BACKEND_HEALTH_INTERVAL", 10*time.Second),
+		"how often each backend's /api/version is probed (env: BACKEND_HEALTH_INTERVAL)")
+	fs.StringVar(&o.dbPath, "db", getEnv("DB_PATH", "/data/db.sqlite"),
 		"SQLite database path (env: DB_PATH)")
-	flag.StringVar(&logPath, "log", getEnv("LOG_PATH", "/data/logs/proxy.log"),
+	fs.StringVar(&o.logPath, "log", getEnv("LOG_PATH", "/data/logs/proxy.log"),
 		"structured JSON log file path (env: LOG_PATH)")
-	flag.StringVar(&staticDir, "static", getEnv("STATIC_DIR", ""),
+	fs.StringVar(&o.logFormat, "log-format", getEnv("LOG_FORMAT", "json"),
+		"format of the log and the access log: json or text (env: LOG_FORMAT)")
+	fs.StringVar(&o.accessLog, "access-log", getEnv("ACCESS_LOG", ""),
+		"write one access line per request to this file, - for stdout; empty disables (env: ACCESS_LOG)")
+	fs.DurationVar(&o.summaryInt, "summary-interval", getEnvDuration("SUMMARY_INTERVAL", 0),
+		"log a per-model request summary at this interval; 0 disables (env: SUMMARY_INTERVAL)")
+	fs.DurationVar(&o.dedupWindow, "log-dedup-window", getEnvDuration("LOG_DEDUP_WINDOW", 10*time.Second),
+		"log the first of repeated error lines and then one line with their count per window; 0 logs every line (env: LOG_DEDUP_WINDOW)")
+	fs.DurationVar(&o.psInterval, "ps-scrape-interval", getEnvDuration("PS_SCRAPE_INTERVAL", 0),
+		"scrape the upstream's /api/ps this often into loaded_model gauges; 0 disables (env: PS_SCRAPE_INTERVAL)")
+	fs.DurationVar(&o.tagsInterval, "tags-scrape-interval", getEnvDuration("TAGS_SCRAPE_INTERVAL", 0),
+		"scrape the upstream's /api/tags this often into model_info gauges; 0 disables (env: TAGS_SCRAPE_INTERVAL)")
+	fs.StringVar(&o.staticDir, "static", getEnv("STATIC_DIR", ""),
 		"directory of frontend static files to serve at / (env: STATIC_DIR)")
-	flag.Parse()
+	fs.StringVar(&o.metricsNS, "metrics-namespace", getEnv("METRICS_NAMESPACE", proxy.DefaultMetricsNamespace),
+		"prefix of every exported metric name (env: METRICS_NAMESPACE)")
+	fs.StringVar(&o.metricsUser, "metrics-username", getEnv("METRICS_USERNAME", ""),
+		"require HTTP basic auth with this user on /metrics, with -metrics-password (env: METRICS_USERNAME)")
+	fs.StringVar(&o.metricsPass, "metrics-password", getEnv("METRICS_PASSWORD", ""),
+		"password of -metrics-username; prefer the env var, flags show in process listings (env: METRICS_PASSWORD)")
+	fs.StringVar(&o.metricsUsers, "metrics-htpasswd", getEnv("METRICS_HTPASSWD", ""),
+		"htpasswd-style user:password entries for /metrics, newline or comma separated; {SHA} hashes accepted (env: METRICS_HTPASSWD)")
+	fs.StringVar(&o.metricsToken, "metrics-token", getEnv("METRICS_TOKEN", ""),
+		"require this bearer token on /metrics instead of basic auth (env: METRICS_TOKEN)")
+	fs.StringVar(&o.apiKeysFile, "api-keys-file", getEnv("API_KEYS_FILE", ""),
+		"file of name:key client API keys required on proxied requests, reread on SIGHUP (env: API_KEYS_FILE)")
+	fs.IntVar(&o.maxLabelLen, "max-label-length", getEnvInt("MAX_LABEL_LENGTH", 128),
+		"longest model or tenant label value taken from a request; longer ones end in a hash, 0 is no limit (env: MAX_LABEL_LENGTH)")
+	fs.StringVar(&o.extraRoutes, "extra-endpoints", getEnv("EXTRA_ENDPOINTS", ""),
+		"comma-separated routes besides Ollama's that get their own endpoint label, e.g. /api/experimental/{id}; other paths are labelled \"other\" (env: EXTRA_ENDPOINTS)")
+	fs.StringVar(&o.modelMode, "model-label-mode", getEnv("MODEL_LABEL_MODE", proxy.ModelLabelRaw),
+		"model label of request metrics: raw as sent, strip-latest without a :latest tag, or base without any tag (env: MODEL_LABEL_MODE)")
+	fs.StringVar(&o.modelAllow, "model-label-allowlist", getEnv("MODEL_LABEL_ALLOWLIST", ""),
+		"comma-separated models that get their own model label, normalized like it; others are labelled \"other\"; empty allows every model (env: MODEL_LABEL_ALLOWLIST)")
+	fs.StringVar(&o.bucketsRaw, "duration-buckets", getEnv("DURATION_BUCKETS", ""),
+		"comma-separated upper bounds in seconds of the request and upstream duration histograms; empty is 0.1 to 600 (env: DURATION_BUCKETS)")
+	fs.StringVar(&o.durationMode, "duration-mode", getEnv("DURATION_MODE", proxy.DurationClientWrite),
+		"what request_duration_seconds measures to: client-write (the client received the response), upstream-body (Ollama's body was read) or headers (Ollama's headers arrived) (env: DURATION_MODE)")
+	fs.DurationVar(&o.apdexTarget, "apdex-target", getEnvDuration("APDEX_TARGET", 5*time.Second),
+		"Apdex satisfaction threshold T; 0 disables Apdex tracking (env: APDEX_TARGET)")
+	fs.StringVar(&o.apdexRaw, "apdex-targets", getEnv("APDEX_TARGETS", ""),
+		"per endpoint class Apdex overrides, e.g. chat=8s,embed=500ms (env: APDEX_TARGETS)")
+	fs.DurationVar(&o.latencyWin, "stats-latency-window", getEnvDuration("STATS_LATENCY_WINDOW", 5*time.Minute),
+		"sliding window of the per-model p50/p95/p99 latencies in /stats; 0 disables them (env: STATS_LATENCY_WINDOW)")
+	fs.StringVar(&o.sloFile, "slo-file", getEnv("SLO_FILE", ""),
+		"JSON file of SLO targets to count requests against, reread on SIGHUP (env: SLO_FILE)")
+	fs.BoolVar(&o.compress, "compress-responses", getEnvBool("COMPRESS_RESPONSES", false),
+		"gzip uncompressed responses for clients that accept it (env: COMPRESS_RESPONSES)")
+	fs.IntVar(&o.compressMin, "compress-min-bytes", getEnvInt("COMPRESS_MIN_BYTES", 1024),
+		"minimum buffered response size to compress (env: COMPRESS_MIN_BYTES)")
+	fs.BoolVar(&o.decompress, "decompress-responses", getEnvBool("DECOMPRESS_RESPONSES", false),
+		"gunzip gzip upstream responses for clients that do not accept gzip (env: DECOMPRESS_RESPONSES)")
+	fs.Int64Var(&o.spillAbove, "spill-threshold-bytes", int64(getEnvInt("SPILL_THRESHOLD_BYTES", 0)),
+		"buffer non-stream responses larger than this on disk instead of in memory; 0 disables (env: SPILL_THRESHOLD_BYTES)")
+	fs.StringVar(&o.spillDir, "spill-dir", getEnv("SPILL_DIR", ""),
+		"directory for spilled responses; empty = system temp dir (env: SPILL_DIR)")
+	fs.Int64Var(&o.spillMax, "spill-max-bytes", int64(getEnvInt("SPILL_MAX_BYTES", 1<<30)),
+		"refuse spilled responses larger than this with 502; 0 = no ceiling (env: SPILL_MAX_BYTES)")
+	fs.DurationVar(&o.metaTTL, "metadata-cache-ttl", getEnvDuration("METADATA_CACHE_TTL", 0),
+		"cache GET /api/tags, /api/ps and /api/version for this long, e.g. 3s; 0 disables (env: METADATA_CACHE_TTL)")
+	fs.DurationVar(&o.showTTL, "show-cache-ttl", getEnvDuration("SHOW_CACHE_TTL", 0),
+		"cache /api/show responses per model for this long; 0 disables (env: SHOW_CACHE_TTL)")
+	fs.DurationVar(&o.embedTTL, "embed-cache-ttl", getEnvDuration("EMBED_CACHE_TTL", 0),
+		"cache /api/embed and /api/embeddings responses in the shared store for this long; 0 disables (env: EMBED_CACHE_TTL)")
+	fs.StringVar(&o.redisAddr, "redis-addr", getEnv("REDIS_ADDR", ""),
+		"host:port of a Redis server for state shared across replicas; empty = in-memory (env: REDIS_ADDR)")
+	fs.StringVar(&o.redisUser, "redis-username", getEnv("REDIS_USERNAME", ""),
+		"Redis ACL username (env: REDIS_USERNAME)")
+	fs.StringVar(&o.redisPass, "redis-password", getEnv("REDIS_PASSWORD", ""),
+		"Redis password; prefer the env var to keep it out of process listings (env: REDIS_PASSWORD)")
+	fs.IntVar(&o.redisDB, "redis-db", getEnvInt("REDIS_DB", 0),
+		"Redis database number (env: REDIS_DB)")
+	fs.BoolVar(&o.redisTLS, "redis-tls", getEnvBool("REDIS_TLS", false),
+		"connect to Redis over TLS (env: REDIS_TLS)")
+	fs.BoolVar(&o.redisTLSNoV, "redis-tls-insecure", getEnvBool("REDIS_TLS_INSECURE", false),
+		"skip Redis TLS certificate verification (env: REDIS_TLS_INSECURE)")
+	fs.Int64Var(&o.rateLimit, "rate-limit", int64(getEnvInt("RATE_LIMIT", 0)),
+		"max requests per client per -rate-limit-window, shared via Redis when configured; 0 disables (env: RATE_LIMIT)")
+	fs.DurationVar(&o.rateWindow, "rate-limit-window", getEnvDuration("RATE_LIMIT_WINDOW", time.Minute),
+		"rate limit window (env: RATE_LIMIT_WINDOW)")
+	fs.Int64Var(&o.tokenBudget, "token-budget", int64(getEnvInt("TOKEN_BUDGET", 0)),
+		"max prompt+completion tokens per client per -token-budget-window; 0 disables (env: TOKEN_BUDGET)")
+	fs.DurationVar(&o.budgetWin, "token-budget-window", getEnvDuration("TOKEN_BUDGET_WINDOW", 24*time.Hour),
+		"token budget window (env: TOKEN_BUDGET_WINDOW)")
+	fs.DurationVar(&o.quotaFlush, "quota-flush-interval", getEnvDuration("QUOTA_FLUSH_INTERVAL", time.Second),
+		"how often buffered token consumption is written to the shared store (env: QUOTA_FLUSH_INTERVAL)")
+	fs.Int64Var(&o.contextWarn, "context-warn-tokens", int64(getEnvInt("CONTEXT_WARN_TOKENS", 0)),
+		"log a warning when a /api/generate context array is longer than this; 0 disables (env: CONTEXT_WARN_TOKENS)")
+	fs.StringVar(&o.ctxCheck, "context-check", getEnv("CONTEXT_CHECK", proxy.ContextCheckWarn),
+		"compare estimated prompt tokens with the model's context window: off, warn (count and log) or reject (413) (env: CONTEXT_CHECK)")
+	fs.Float64Var(&o.ctxMargin, "context-overflow-margin", getEnvFloat("CONTEXT_OVERFLOW_MARGIN", 0.2),
+		"fraction by which the estimate must exceed the window for -context-check (env: CONTEXT_OVERFLOW_MARGIN)")
+	fs.Float64Var(&o.dupRate, "duplicate-sample-rate", getEnvFloat("DUPLICATE_SAMPLE_RATE", 0),
+		"fraction of generate/chat prompts checked for repeats, 0-1; 0 disables (env: DUPLICATE_SAMPLE_RATE)")
+	fs.IntVar(&o.dupSize, "duplicate-track-size", getEnvInt("DUPLICATE_TRACK_SIZE", 10000),
+		"how many sampled prompt hashes to remember (env: DUPLICATE_TRACK_SIZE)")
+	fs.StringVar(&o.convHeader, "conversation-header", getEnv("CONVERSATION_HEADER", ""),
+		"request header identifying a conversation, e.g. X-Conversation-ID; empty disables (env: CONVERSATION_HEADER)")
+	fs.DurationVar(&o.convTTL, "conversation-ttl", getEnvDuration("CONVERSATION_TTL", 30*time.Minute),
+		"idle time after which a conversation ends (env: CONVERSATION_TTL)")
+	fs.IntVar(&o.convMax, "max-conversations", getEnvInt("MAX_CONVERSATIONS", 10000),
+		"conversations tracked at once; the least recently active ends first (env: MAX_CONVERSATIONS)")
+	fs.StringVar(&o.unloadOverride, "unload-keep-alive-override", getEnv("UNLOAD_KEEP_ALIVE_OVERRIDE", ""),
+		"replace keep_alive 0 in requests with this duration, e.g. 5m, so clients cannot unload models; empty disables (env: UNLOAD_KEEP_ALIVE_OVERRIDE)")
+	fs.DurationVar(&o.oomCooldown, "oom-cooldown", getEnvDuration("OOM_COOLDOWN", 30*time.Second),
+		"answer upstream out-of-memory errors with 503 and fail the model's requests fast for this long; 0 disables (env: OOM_COOLDOWN)")
+	fs.DurationVar(&o.negativeTTL, "negative-cache-ttl", getEnvDuration("NEGATIVE_CACHE_TTL", 10*time.Second),
+		"after the upstream says a model does not exist, answer its requests with the same 404 locally for this long; 0 disables (env: NEGATIVE_CACHE_TTL)")
+	fs.BoolVar(&o.readOnly, "read-only", getEnvBool("READ_ONLY", false),
+		"refuse pulls, pushes, creates, deletes, copies and blob uploads with 403 (env: READ_ONLY)")
+	fs.BoolVar(&o.requirePinned, "require-pinned-models", getEnvBool("REQUIRE_PINNED_MODELS", false),
+		"refuse requests whose model has no tag, or is tagged latest, with 400; a digest or another tag pins it (env: REQUIRE_PINNED_MODELS)")
+	fs.StringVar(&o.pinnedExempt, "pinned-models-exempt", getEnv("PINNED_MODELS_EXEMPT", ""),
+		"comma-separated models exempt from -require-pinned-models, matched without their tag (env: PINNED_MODELS_EXEMPT)")
+	fs.BoolVar(&o.validateUp, "validate-upstream", getEnvBool("VALIDATE_UPSTREAM", false),
+		"check upstream responses against each endpoint's schema and count deviations, without changing them (env: VALIDATE_UPSTREAM)")
+	fs.StringVar(&o.noInspect, "no-inspect-endpoints", getEnv("NO_INSPECT_ENDPOINTS", ""),
+		"comma-separated paths, e.g. /api/chat, whose requests and responses are relayed unread and never stored or logged; "+
+			"their metrics have model \"uninspected\" and no token counts, and only the read-only mode and rate, budget and tokens-per-minute limits apply (env: NO_INSPECT_ENDPOINTS)")
+	fs.IntVar(&o.maxPerModel, "max-concurrent-per-model", getEnvInt("MAX_CONCURRENT_PER_MODEL", 0),
+		"max requests in flight to the upstream per model; more queue by X-Ollama-Priority; 0 disables (env: MAX_CONCURRENT_PER_MODEL)")
+	fs.DurationVar(&o.queueTimeout, "queue-timeout", getEnvDuration("QUEUE_TIMEOUT", 0),
+		"answer 503 after waiting this long in the queue; 0 waits as long as the client (env: QUEUE_TIMEOUT)")
+	fs.IntVar(&o.readyMaxInFlight, "ready-max-inflight", getEnvInt("READY_MAX_INFLIGHT", 0),
+		"/readyz answers 503 while more requests than this are in flight; 0 disables (env: READY_MAX_INFLIGHT)")
+	fs.IntVar(&o.readyResumeInFlight, "ready-resume-inflight", getEnvInt("READY_RESUME_INFLIGHT", 0),
+		"ready again once requests in flight are down to this many; 0 is three quarters of -ready-max-inflight (env: READY_RESUME_INFLIGHT)")
+	fs.DurationVar(&o.readyMaxWait, "ready-max-queue-wait", getEnvDuration("READY_MAX_QUEUE_WAIT", 0),
+		"/readyz answers 503 while a request has waited longer than this for a -max-concurrent-per-model slot; 0 disables (env: READY_MAX_QUEUE_WAIT)")
+	fs.DurationVar(&o.readyResumeWait, "ready-resume-queue-wait", getEnvDuration("READY_RESUME_QUEUE_WAIT", 0),
+		"ready again once the longest wait is down to this; 0 is three quarters of -ready-max-queue-wait (env: READY_RESUME_QUEUE_WAIT)")
+	fs.DurationVar(&o.readyUpTimeout, "ready-upstream-timeout", getEnvDuration("READY_UPSTREAM_TIMEOUT", 2*time.Second),
+		"/readyz answers 503 while the upstream does not answer /api/version within this; 0 disables (env: READY_UPSTREAM_TIMEOUT)")
+	fs.DurationVar(&o.readyUpCache, "ready-upstream-cache", getEnvDuration("READY_UPSTREAM_CACHE", 5*time.Second),
+		"reuse an upstream probe's result for this long (env: READY_UPSTREAM_CACHE)")
+	fs.IntVar(&o.maxPerTenant, "max-concurrent-per-tenant", getEnvInt("MAX_CONCURRENT_PER_TENANT", 0),
+		"max requests a tenant may have queued or in flight; more get 429; 0 disables (env: MAX_CONCURRENT_PER_TENANT)")
+	fs.StringVar(&o.tenantRaw, "tenant-concurrency", getEnv("TENANT_CONCURRENCY", ""),
+		"per-tenant overrides of -max-concurrent-per-tenant, e.g. batch=2,admin=0 (0 = unlimited) (env: TENANT_CONCURRENCY)")
+	fs.StringVar(&o.tenantHeader, "tenant-header", getEnv("TENANT_HEADER", ""),
+		"request header naming the tenant for per-tenant limits, budgets and metrics; empty uses the client IP (env: TENANT_HEADER)")
+	fs.StringVar(&o.canaryModels, "canary-models", getEnv("CANARY_MODELS", ""),
+		"comma-separated models to probe with synthetic requests; empty disables the prober (env: CANARY_MODELS)")
+	fs.DurationVar(&o.canaryEvery, "canary-interval", getEnvDuration("CANARY_INTERVAL", time.Minute),
+		"how often each canary model is probed; also the probe timeout (env: CANARY_INTERVAL)")
+	fs.StringVar(&o.canaryPrompt, "canary-prompt", getEnv("CANARY_PROMPT", "Reply with OK."),
+		"prompt sent by canary probes (env: CANARY_PROMPT)")
+	fs.IntVar(&o.canaryPredict, "canary-num-predict", getEnvInt("CANARY_NUM_PREDICT", 1),
+		"options.num_predict for canary probes (env: CANARY_NUM_PREDICT)")
+	fs.BoolVar(&o.mockUpstream, "mock-upstream", getEnvBool("MOCK_UPSTREAM", false),
+		"never contact Ollama; synthesize responses for load-testing clients (env: MOCK_UPSTREAM)")
+	fs.IntVar(&o.mockPromptTok, "mock-prompt-tokens", getEnvInt("MOCK_PROMPT_TOKENS", 0),
+		"prompt_eval_count reported by -mock-upstream; 0 estimates from the prompt (env: MOCK_PROMPT_TOKENS)")
+	fs.IntVar(&o.mockComplTok, "mock-completion-tokens", getEnvInt("MOCK_COMPLETION_TOKENS", 128),
+		"tokens generated per -mock-upstream response (env: MOCK_COMPLETION_TOKENS)")
+	fs.Float64Var(&o.mockTokPerSec, "mock-tokens-per-second", getEnvFloat("MOCK_TOKENS_PER_SECOND", 20),
+		"-mock-upstream generation speed; 0 = unpaced (env: MOCK_TOKENS_PER_SECOND)")
+	fs.Int64Var(&o.tpmLimit, "tpm-limit", int64(getEnvInt("TPM_LIMIT", 0)),
+		"max prompt+completion tokens per client per minute on generate/chat/embed; 0 disables (env: TPM_LIMIT)")
+	fs.Float64Var(&o.charsPerTok, "chars-per-token", getEnvFloat("CHARS_PER_TOKEN", 4),
+		"prompt characters per token when estimating prompts for -tpm-limit and -context-check (env: CHARS_PER_TOKEN)")
+	fs.StringVar(&o.adminToken, "admin-token", getEnv("ADMIN_TOKEN", ""),
+		"bearer token for /admin/* runtime endpoints and /debug/last-error; they are disabled without one (env: ADMIN_TOKEN)")
+	fs.BoolVar(&o.h2c, "h2c", getEnvBool("H2C", false),
+		"also accept cleartext HTTP/2 (prior knowledge) on -listen (env: H2C)")
+	fs.StringVar(&o.tlsCert, "tls-cert", getEnv("TLS_CERT", ""),
+		"PEM certificate (chain) to serve -listen over TLS with, with -tls-key; reloaded when it changes or on SIGHUP (env: TLS_CERT)")
+	fs.StringVar(&o.tlsKey, "tls-key", getEnv("TLS_KEY", ""),
+		"PEM private key of -tls-cert (env: TLS_KEY)")
+	fs.DurationVar(&o.shutdownTimeout, "shutdown-timeout", getEnvDuration("SHUTDOWN_TIMEOUT", 60*time.Second),
+		"on SIGTERM or SIGINT, how long requests in flight may take to finish before they are canceled (env: SHUTDOWN_TIMEOUT)")
+	fs.BoolVar(&o.logServerErrs, "log-server-errors", getEnvBool("LOG_SERVER_ERRORS", false),
+		"log each request the HTTP server rejects before the proxy sees it, with the client address; they are always counted (env: LOG_SERVER_ERRORS)")
+	fs.StringVar(&o.debugAddr, "debug-addr", getEnv("DEBUG_ADDR", ""),
+		"serve pprof profiles and /debug/runtime on this separate address, e.g. 127.0.0.1:6060; empty disables (env: DEBUG_ADDR)")
+	fs.DurationVar(&o.headerTimeout, "upstream-response-header-timeout", getEnvDuration("UPSTREAM_RESPONSE_HEADER_TIMEOUT", 5*time.Minute),
+		"fail with 504 when the upstream sends no response headers within this long; 0 waits forever (env: UPSTREAM_RESPONSE_HEADER_TIMEOUT)")
+	fs.StringVar(&o.headerTimeoutRaw, "upstream-response-header-timeouts", getEnv("UPSTREAM_RESPONSE_HEADER_TIMEOUTS", ""),
+		"per endpoint class overrides, e.g. generate=10m,other=30s (env: UPSTREAM_RESPONSE_HEADER_TIMEOUTS)")
+	fs.DurationVar(&o.nonStreamTO, "nonstream-timeout", getEnvDuration("NONSTREAM_TIMEOUT", 5*time.Minute),
+		"fail non-streaming requests with 504 when the full response takes longer; streams are exempt; 0 disables (env: NONSTREAM_TIMEOUT)")
+	fs.IntVar(&o.retries, "upstream-retries", getEnvInt("UPSTREAM_RETRIES", 0),
+		"send a request again up to this many times when the upstream refuses or drops the connection or answers 502 or 503 before the client got anything; 0 disables (env: UPSTREAM_RETRIES)")
+	fs.DurationVar(&o.retryBackoff, "upstream-retry-backoff", getEnvDuration("UPSTREAM_RETRY_BACKOFF", time.Second),
+		"wait before the first retry, doubled for each one after up to 30s (env: UPSTREAM_RETRY_BACKOFF)")
+	fs.DurationVar(&o.dialTimeout, "upstream-dial-timeout", getEnvDuration("UPSTREAM_DIAL_TIMEOUT", 30*time.Second),
+		"fail with 504 when a connection to the upstream takes longer to open (env: UPSTREAM_DIAL_TIMEOUT)")
+	fs.DurationVar(&o.tlsTimeout, "upstream-tls-handshake-timeout", getEnvDuration("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+		"fail with 504 when the TLS handshake with an https upstream takes longer (env: UPSTREAM_TLS_HANDSHAKE_TIMEOUT)")
+	fs.DurationVar(&o.idleConnTimeout, "upstream-idle-conn-timeout", getEnvDuration("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second),
+		"close idle keep-alive connections to the upstream after this long (env: UPSTREAM_IDLE_CONN_TIMEOUT)")
+	fs.IntVar(&o.maxIdlePerHost, "upstream-max-idle-conns-per-host", getEnvInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 16),
+		"idle keep-alive connections kept per upstream host for reuse (env: UPSTREAM_MAX_IDLE_CONNS_PER_HOST)")
+	fs.StringVar(&o.upstreamCA, "upstream-ca-file", getEnv("UPSTREAM_CA_FILE", ""),
+		"PEM bundle of CA certificates trusted for https upstreams, besides the system ones (env: UPSTREAM_CA_FILE)")
+	fs.StringVar(&o.upstreamClientCert, "upstream-client-cert", getEnv("UPSTREAM_CLIENT_CERT", ""),
+		"PEM client certificate presented to https upstreams, with -upstream-client-key (env: UPSTREAM_CLIENT_CERT)")
+	fs.StringVar(&o.upstreamClientKey, "upstream-client-key", getEnv("UPSTREAM_CLIENT_KEY", ""),
+		"PEM private key of -upstream-client-cert (env: UPSTREAM_CLIENT_KEY)")
+	fs.StringVar(&o.upstreamServerName, "upstream-tls-server-name", getEnv("UPSTREAM_TLS_SERVER_NAME", ""),
+		"name sent as SNI and verified in https upstreams' certificates instead of the URL's host (env: UPSTREAM_TLS_SERVER_NAME)")
+	fs.BoolVar(&o.preserveHost, "preserve-host", getEnvBool("PRESERVE_HOST", false),
+		"forward the client's Host header instead of the upstream's host (env: PRESERVE_HOST)")
+	fs.StringVar(&o.requestIDHeader, "request-id-header", getEnv("REQUEST_ID_HEADER", proxy.DefaultRequestIDHeader),
+		"header a client's request ID is honoured from and the ID is sent upstream and back in, e.g. X-Correlation-Id (env: REQUEST_ID_HEADER)")
+	fs.BoolVar(&o.upstreamInsecure, "upstream-insecure-skip-verify", getEnvBool("UPSTREAM_INSECURE_SKIP_VERIFY", false),
+		"do not verify https upstreams' certificates; for testing only (env: UPSTREAM_INSECURE_SKIP_VERIFY)")
+	fs.DurationVar(&o.readTimeout, "request-read-timeout", getEnvDuration("REQUEST_READ_TIMEOUT", 0),
+		"answer 408 when a client's request body takes longer to arrive; 0 waits as long as the client (env: REQUEST_READ_TIMEOUT)")
+	fs.Int64Var(&o.maxRequestBytes, "max-request-bytes", int64(getEnvInt("MAX_REQUEST_BYTES", 100<<20)),
+		"answer 413 to request bodies larger than this; generous by default for base64 images; 0 = unlimited (env: MAX_REQUEST_BYTES)")
+	fs.Int64Var(&o.sniffBytes, "request-sniff-bytes", int64(getEnvInt("REQUEST_SNIFF_BYTES", 1<<20)),
+		"stream request bodies longer than this upstream, reading only this much for model and stream; 0 = read whole bodies (env: REQUEST_SNIFF_BYTES)")
+	fs.IntVar(&o.maxConns, "max-connections", getEnvInt("MAX_CONNECTIONS", 0),
+		"max client connections held open in total; 0 = unlimited (env: MAX_CONNECTIONS)")
+	fs.IntVar(&o.maxConnsPerIP, "max-connections-per-client", getEnvInt("MAX_CONNECTIONS_PER_CLIENT", 0),
+		"max connections held open per client IP; 0 = unlimited (env: MAX_CONNECTIONS_PER_CLIENT)")
+	fs.StringVar(&o.trustedProxies, "trusted-proxies", getEnv("TRUSTED_PROXIES", ""),
+		"comma-separated IPs/CIDRs of reverse proxies in front of the proxy; exempt from -max-connections-per-client, and only their X-Forwarded-For names the client for limits (env: TRUSTED_PROXIES)")
+	fs.BoolVar(&o.serverTiming, "server-timing", getEnvBool("SERVER_TIMING", false),
+		"add a Server-Timing latency breakdown to proxied responses (env: SERVER_TIMING)")
+	fs.StringVar(&o.instanceName, "instance-name", getEnv("INSTANCE_NAME", defaultInstanceName()),
+		"name of this proxy instance in request logs, records and X-Served-By; defaults to the hostname (env: INSTANCE_NAME)")
+	fs.BoolVar(&o.servedBy, "served-by-header", getEnvBool("SERVED_BY_HEADER", false),
+		"add X-Served-By with -instance-name to proxied responses (env: SERVED_BY_HEADER)")
+	fs.BoolVar(&o.exposeUpstreams, "expose-upstream-names", getEnvBool("EXPOSE_UPSTREAM_NAMES", false),
+		"add X-Upstream with the upstream's host:port to forwarded responses; reveals topology to clients (env: EXPOSE_UPSTREAM_NAMES)")
+	fs.BoolVar(&o.validate, "validate", false,
+		"check the configuration, print a JSON report and exit (non-zero on errors)")
+	fs.BoolVar(&o.validateProbe, "validate-probe", false,
+		"with -validate, also contact the upstream and Redis")
+	fs.BoolVar(&o.strictStartup, "strict-startup", getEnvBool("STRICT_STARTUP", false),
+		"treat preflight warnings as fatal, at startup and with -validate (env: STRICT_STARTUP)")
+	fs.BoolVar(&o.selfTest, "self-test", false,
+		"send one generate through the proxy to the upstream on an ephemeral port, print a JSON report and exit (non-zero on failure)")
+	fs.StringVar(&o.selfTestModel, "self-test-model", getEnv("SELF_TEST_MODEL", ""),
+		"model -self-test generates with, using -canary-prompt and -canary-num-predict (env: SELF_TEST_MODEL)")
+	fs.BoolVar(&o.selfTestSkipGen, "self-test-skip-generate", false,
+		"with -self-test, request /api/version instead of generating, leaving the GPU alone")
+	fs.DurationVar(&o.selfTestTimeout, "self-test-timeout", 2*time.Minute,
+		"with -self-test, how long to wait for the answer, model load included")
+	return o
+}
 
-	logger := buildLogger(logPath)
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
 
-	if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
-		log.Fatalf("create db dir: %v", err)
+// contextCheckMode maps -context-check to proxy.Config.ContextCheck, where
+// off is empty.
+func contextCheckMode(s string) string {
+	if s == "off" {
+		return ""
 	}
-	store, err := db.Open(dbPath)
-	if err != nil {
-		log.Fatalf("open db: %v", err)
+	return s
+}
+
+// redisOptions returns the Redis connection settings, or false when no Redis
+// is configured.
+func (o *options) redisOptions() (kv.RedisOptions, bool) {
+	if o.redisAddr == "" {
+		return kv.RedisOptions{}, false
 	}
-	defer func() { _ = store.Close() }()
+	opts := kv.RedisOptions{Addr: o.redisAddr, Username: o.redisUser, Password: o.redisPass, DB: o.redisDB}
+	if o.redisTLS {
+		opts.TLS = &tls.Config{InsecureSkipVerify: o.redisTLSNoV}
+	}
+	return opts, true
+}
 
-	upstreamURL, err := url.Parse(upstreamRaw)
+// proxyConfig builds the proxy's configuration from the flags. The caller
+// adds the shared store.
+func (o *options) proxyConfig() (proxy.Config, error) {
+	headerTimeouts, err := proxy.ParseDurationMap(o.headerTimeoutRaw)
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("invalid -upstream-response-header-timeouts: %v", err)
+	}
+	apdexTargets, err := proxy.ParseDurationMap(o.apdexRaw)
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("invalid -apdex-targets: %v", err)
+	}
+	upstreamTokens, err := proxy.ParseStringMap(o.upTokensRaw)
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("invalid -upstream-tokens: %v", err)
+	}
+	tokenPrices, err := proxy.ParseTokenPrices(o.pricesRaw)
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("invalid -token-prices: %v", err)
+	}
+	trustedProxies, err := connlimit.ParseCIDRs(splitList(o.trustedProxies))
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("invalid -trusted-proxies: %v", err)
+	}
+	tenantConcurrency, err := proxy.ParseIntMap(o.tenantRaw)
 	if err != nil {
-		log.Fatalf("invalid upstream URL %q: %v", upstreamRaw, err)
+		return proxy.Config{}, fmt.Errorf("invalid -tenant-concurrency: %v", err)
+	}
+	backends, err := proxy.ParseBackends(o.backendsRaw)
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("invalid -backends: %v", err)
+	}
+	var sloTargets []proxy.SLOTarget
+	if o.sloFile != "" {
+		if sloTargets, err = proxy.LoadSLOTargets(o.sloFile); err != nil {
+			return proxy.Config{}, fmt.Errorf("invalid -slo-file: %v", err)
+		}
+	}
+	var apiKeys []proxy.APIKey
+	if o.apiKeysFile != "" {
+		if apiKeys, err = proxy.LoadAPIKeys(o.apiKeysFile); err != nil {
+			return proxy.Config{}, fmt.Errorf("invalid -api-keys-file: %v", err)
+		}
+	}
+	extraEndpoints, err := proxy.ParseEndpoints(o.extraRoutes)
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("invalid -extra-endpoints: %v", err)
+	}
+	if !slices.Contains(proxy.ModelLabelModes, o.modelMode) {
+		return proxy.Config{}, fmt.Errorf("invalid -model-label-mode %q: want one of %s", o.modelMode, strings.Join(proxy.ModelLabelModes, ", "))
+	}
+	upstreamTLS, err := o.upstreamTLSConfig()
+	if err != nil {
+		return proxy.Config{}, err
 	}
 
-	reg := prometheus.NewRegistry()
-	metrics := proxy.NewMetrics(reg)
+	return proxy.Config{
+		ApdexTarget:  o.apdexTarget,
+		ApdexTargets: apdexTargets,
+		SLOTargets:   sloTargets,
+		APIKeys:      apiKeys,
 
-	proxyHandler := proxy.New(upstreamURL, store, logger, metrics)
+		LatencyWindow:  o.latencyWin,
+		MaxLabelLength: o.maxLabelLen,
+		ExtraEndpoints: extraEndpoints,
 
-	mux := http.NewServeMux()
+		ModelLabelMode:      o.modelMode,
+		ModelLabelAllowlist: splitList(o.modelAllow),
+
+		CompressResponses: o.compress,
+		CompressMinBytes:  o.compressMin,
+
+		DecompressResponses: o.decompress,
+		SpillThreshold:      o.spillAbove,
+		SpillDir:            o.spillDir,
+		SpillMaxBytes:       o.spillMax,
+		MetadataCacheTTL:    o.metaTTL,
+		ShowCacheTTL:        o.showTTL,
+		EmbedCacheTTL:       o.embedTTL,
+
+		RateLimit:          o.rateLimit,
+		RateLimitWindow:    o.rateWindow,
+		TokenBudget:        o.tokenBudget,
+		TokenBudgetWindow:  o.budgetWin,
+		QuotaFlushInterval: o.quotaFlush,
+
+		CanaryModels:     splitList(o.canaryModels),
+		CanaryInterval:   o.canaryEvery,
+		CanaryPrompt:     o.canaryPrompt,
+		CanaryNumPredict: o.canaryPredict,
+
+		ContextWarnTokens:     o.contextWarn,
+		ContextCheck:          contextCheckMode(o.ctxCheck),
+		ContextOverflowMargin: o.ctxMargin,
+
+		DuplicateSampleRate: o.dupRate,
+		DuplicateTrackSize:  o.dupSize,
+
+		ConversationHeader: o.convHeader,
+		ConversationTTL:    o.convTTL,
+		ConversationMax:    o.convMax,
+
+		SummaryInterval:    o.summaryInt,
+		LogDedupWindow:     o.dedupWindow,
+		PSScrapeInterval:   o.psInterval,
+		TagsScrapeInterval: o.tagsInterval,
+
+		UnloadKeepAliveOverride: o.unloadOverride,
+		OOMCooldown:             o.oomCooldown,
+		NegativeCacheTTL:        o.negativeTTL,
+		ReadOnly:                o.readOnly,
+		RequirePinnedModels:     o.requirePinned,
+		PinnedModelsExempt:      splitList(o.pinnedExempt),
+		ValidateUpstream:        o.validateUp,
+		NoInspectEndpoints:      splitList(o.noInspect),
+
+		MaxConcurrentPerModel:  o.maxPerModel,
+		QueueTimeout:           o.queueTimeout,
+		ReadyMaxInFlight:       o.readyMaxInFlight,
+		ReadyResumeInFlight:    o.readyResumeInFlight,
+		ReadyMaxQueueWait:      o.readyMaxWait,
+		ReadyResumeQueueWait:   o.readyResumeWait,
+		ReadyUpstreamTimeout:   o.readyUpTimeout,
+		ReadyUpstreamTTL:       o.readyUpCache,
+		MaxConcurrentPerTenant: o.maxPerTenant,
+		TenantConcurrency:      tenantConcurrency,
+		TenantHeader:           o.tenantHeader,
+		TrustedProxies:         trustedProxies,
+
+		TPMLimit:      o.tpmLimit,
+		CharsPerToken: o.charsPerTok,
+
+		ResponseHeaderTimeout:  o.headerTimeout,
+		ResponseHeaderTimeouts: headerTimeouts,
+		NonStreamTimeout:       o.nonStreamTO,
+		UpstreamRetries:        o.retries,
+		UpstreamRetryBackoff:   o.retryBackoff,
+		RequestReadTimeout:     o.readTimeout,
+		MaxRequestBytes:        o.maxRequestBytes,
+		RequestSniffBytes:      o.sniffBytes,
+		PreserveHost:           o.preserveHost,
+		RequestIDHeader:        o.requestIDHeader,
+
+		DialTimeout:         o.dialTimeout,
+		TLSHandshakeTimeout: o.tlsTimeout,
+		IdleConnTimeout:     o.idleConnTimeout,
+		MaxIdleConnsPerHost: o.maxIdlePerHost,
+		UpstreamTLS:         upstreamTLS,
+
+		ServerTiming:        o.serverTiming,
+		InstanceName:        o.instanceName,
+		ServedByHeader:      o.servedBy,
+		ExposeUpstreamNames: o.exposeUpstreams,
+
+		UpstreamTokens:          upstreamTokens,
+		UpstreamAuthPassthrough: splitList(o.authPass),
+		TokenPrices:             tokenPrices,
+		UpstreamPathPrefix:      o.upPrefix,
+
+		Backends:              backends,
+		AffinityEverywhere:    splitList(o.everywhere),
+		BackendHealthInterval: o.backendPoll,
+	}, nil
+}
+
+// defaultInstanceName is the hostname, or "" when it cannot be read.
+func defaultInstanceName() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// metricsAuth returns the credentials /metrics requires, none by default.
+func (o *options) metricsAuth() (proxy.MetricsAuth, error) {
+	auth := proxy.MetricsAuth{Token: o.metricsToken}
+	users, err := proxy.ParseHtpasswd(o.metricsUsers)
+	if err != nil {
+		return auth, fmt.Errorf("invalid -metrics-htpasswd: %v", err)
+	}
+	if o.metricsUser != "" {
+		if _, dup := users[o.metricsUser]; dup {
+			return auth, fmt.Errorf("-metrics-username %q is also in -metrics-htpasswd", o.metricsUser)
+		}
+		users[o.metricsUser] = o.metricsPass
+	}
+	if len(users) > 0 {
+		auth.Users = users
+	}
+	return auth, nil
+}
 
-	// Prometheus metrics
-	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+// metricsOptions returns how the proxy's metrics are named and bucketed.
+func (o *options) metricsOptions() (proxy.MetricsOptions, error) {
+	opts := proxy.MetricsOptions{Namespace: o.metricsNS, DurationMode: o.durationMode}
+	if !slices.Contains(proxy.DurationModes, o.durationMode) {
+		return opts, fmt.Errorf("invalid -duration-mode %q: want one of %s", o.durationMode, strings.Join(proxy.DurationModes, ", "))
+	}
+	if o.bucketsRaw != "" {
+		var err error
+		if opts.DurationBuckets, err = proxy.ParseBuckets(o.bucketsRaw); err != nil {
+			return opts, fmt.Errorf("invalid -duration-buckets: %v", err)
+		}
+	}
+	return opts, nil
+}
+
+// route is an endpoint of the proxy's mux, as mounted by main and listed by
+// describe.
+type route struct {
+	Pattern string   `json:"pattern"`
+	Methods []string `json:"methods,omitempty"` // any method when empty
+	Auth    string   `json:"auth"`              // "none", "api-key", "admin-token", "metrics-bearer" or "metrics-basic"
+	handler http.Handler
+}
+
+// routes returns the endpoints of a proxy with o. The handlers only use reg,
+// h and store once a request is served, so describe passes none.
+func routes(o *options, reg prometheus.Gatherer, h *proxy.Handler, store *db.Store) []route {
+	metricsAuth, _ := o.metricsAuth() // checked by preflight and main
+	metricsRoute := route{Pattern: "/metrics", Auth: "none",
+		handler: h.MetricsAuthHandler(metricsAuth, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))}
+	if scheme := metricsAuth.Scheme(); scheme != "" {
+		metricsRoute.Auth = "metrics-" + scheme
+	}
+	proxyAuth := "none"
+	if o.apiKeysFile != "" {
+		proxyAuth = "api-key"
+	}
+	out := []route{
+		// Prometheus metrics
+		metricsRoute,
+		// Runtime state
+		{Pattern: "GET /stats", Methods: []string{http.MethodGet}, Auth: "none", handler: http.HandlerFunc(h.ServeStats)},
+		{Pattern: "GET /healthz", Methods: []string{http.MethodGet}, Auth: "none", handler: http.HandlerFunc(h.ServeHealth)},
+		{Pattern: "GET /readyz", Methods: []string{http.MethodGet}, Auth: "none", handler: http.HandlerFunc(h.ServeReady)},
+		// All Ollama API endpoints, native and OpenAI-compatible
+		{Pattern: "/api/", Auth: proxyAuth, handler: h},
+		{Pattern: "/v1/", Auth: proxyAuth, handler: h},
+	}
 
 	// Admin REST API (feeds the React dashboard)
-	apiHandler := api.New(store)
-	apiHandler.Register(mux, "/admin/api")
+	for _, rt := range api.New(store).Routes("/admin/api") {
+		out = append(out, route{Pattern: rt.Path, Methods: rt.Methods, Auth: "none", handler: rt.Handler})
+	}
+
+	// Runtime administration, only with a token
+	if o.adminToken != "" {
+		for _, rt := range h.AdminRoutes(o.adminToken) {
+			out = append(out, route{Pattern: rt.Method + " " + rt.Path, Methods: []string{rt.Method},
+				Auth: "admin-token", handler: rt.Handler})
+		}
+	}
 
 	// Optional: serve compiled React frontend from staticDir
-	if staticDir != "" {
-		mux.Handle("/", http.FileServer(http.Dir(staticDir)))
+	index := route{Pattern: "/", Auth: "none"}
+	if o.staticDir != "" {
+		index.handler = http.FileServer(http.Dir(o.staticDir))
 	} else {
-		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		index.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintln(w, "Ollama metrics proxy")
 			fmt.Fprintln(w, "  /api/*       — Ollama proxy")
+			fmt.Fprintln(w, "  /v1/*        — Ollama's OpenAI-compatible API")
 			fmt.Fprintln(w, "  /metrics     — Prometheus metrics")
 			fmt.Fprintln(w, "  /admin/api/* — metrics REST API")
+			fmt.Fprintln(w, "  /stats       — current upstream, requests in flight and latency quantiles")
+			fmt.Fprintln(w, "  /healthz     — liveness probe: 200 while the process serves")
+			fmt.Fprintln(w, "  /readyz      — readiness probe: 503 while draining, overloaded or the upstream is down")
+			fmt.Fprintln(w, "  /admin/models, /admin/upstream, /debug/last-error — runtime admin (needs -admin-token)")
 		})
 	}
+	return append(out, index)
+}
 
-	// All Ollama API endpoints
-	mux.Handle("/api/", proxyHandler)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(runBench(os.Args[2:], os.Stdout, os.Stderr))
+		case "dashboard":
+			os.Exit(runDashboard(os.Args[2:], os.Stdout, os.Stderr))
+		case "rules":
+			os.Exit(runRules(os.Args[2:], os.Stdout, os.Stderr))
+		case "describe":
+			os.Exit(runDescribe(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
 
-	log.Printf("starting ollama-proxy on %s  upstream=%s  db=%s  log=%s",
-		listenAddr, upstreamURL, dbPath, logPath)
+	o := registerFlags(flag.CommandLine)
+	flag.Parse()
 
-	if err := http.ListenAndServe(listenAddr, mux); err != nil {
+	if o.mockUpstream && !o.validate {
+		addr, err := startMockUpstream(o)
+		if err != nil {
+			log.Fatalf("mock upstream: %v", err)
+		}
+		o.upstreamRaw = "http://" + addr
+		log.Printf("!!! MOCK UPSTREAM MODE: Ollama is NOT contacted; all responses are synthetic "+
+			"(%d completion tokens at %g tokens/s) !!!", o.mockComplTok, o.mockTokPerSec)
+	}
+
+	if o.validate {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		rep := preflight(ctx, o, o.validateProbe)
+		cancel()
+		if err := rep.write(os.Stdout); err != nil {
+			log.Fatalf("write report: %v", err)
+		}
+		if !rep.passed(o.strictStartup) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	rep := preflight(ctx, o, false)
+	cancel()
+	for _, f := range rep.Findings {
+		if f.Severity != severityOK {
+			log.Printf("preflight %s: %s: %s", f.Severity, f.Check, f.Message)
+		}
+	}
+	if !rep.passed(o.strictStartup) {
+		log.Fatalf("preflight failed: %d error(s), %d warning(s)", rep.Errors, rep.Warnings)
+	}
+
+	cfg, err := o.proxyConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	metricsOpts, err := o.metricsOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := o.metricsAuth(); err != nil {
+		log.Fatal(err)
+	}
+
+	if o.logFormat != "json" && o.logFormat != "text" {
+		log.Fatalf("-log-format must be json or text, got %q", o.logFormat)
+	}
+	logger := buildLogger(o.logPath, o.logFormat)
+	if cfg.AccessLog, err = buildAccessLogger(o.accessLog, o.logFormat); err != nil {
+		log.Fatal(err)
+	}
+	if o.mockUpstream {
+		logger.Warn("mock upstream mode: responses are synthetic", "upstream", o.upstreamRaw)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(o.dbPath), 0o755); err != nil {
+		log.Fatalf("create db dir: %v", err)
+	}
+	store, err := db.Open(o.dbPath)
+	if err != nil {
+		log.Fatalf("open db: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	upstreamURL, err := proxy.ParseUpstream(o.upstreamRaw)
+	if err != nil {
+		log.Fatalf("invalid upstream URL %q: %v", o.upstreamRaw, err)
+	}
+
+	reg := prometheus.NewRegistry()
+	registerRuntimeCollectors(reg)
+	metrics := proxy.NewMetricsWithOptions(reg, metricsOpts)
+
+	var shared kv.Store
+	if opts, ok := o.redisOptions(); ok {
+		shared = kv.NewFallback(kv.NewRedis(opts), kv.NewMemory(), 10*time.Second, func(op string, err error) {
+			metrics.StoreFallbacks.WithLabelValues(op).Inc()
+			logger.Warn("redis unavailable, using local state", "op", op, "error", err)
+		})
+		defer func() { _ = shared.Close() }()
+	}
+
+	cfg.SharedStore = shared
+	proxyHandler := proxy.New(upstreamURL, store, logger, metrics, cfg)
+	defer func() { _ = proxyHandler.Close() }()
+	if o.sloFile != "" {
+		reloadSLOsOnHangup(o.sloFile, proxyHandler, logger)
+	}
+	if o.apiKeysFile != "" {
+		reloadAPIKeysOnHangup(o.apiKeysFile, proxyHandler, logger)
+	}
+
+	mux := http.NewServeMux()
+	for _, rt := range routes(o, reg, proxyHandler, store) {
+		mux.Handle(rt.Pattern, rt.handler)
+	}
+
+	if o.selfTest {
+		ctx, cancel := context.WithTimeout(context.Background(), o.selfTestTimeout)
+		rep := runSelfTest(ctx, o, mux, reg)
+		cancel()
+		if err := rep.write(os.Stdout); err != nil {
+			log.Fatalf("write report: %v", err)
+		}
+		if !rep.passed(false) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var certs *certReloader
+	if o.tlsCert != "" {
+		if certs, err = newCertReloader(o.tlsCert, o.tlsKey, logger); err != nil {
+			log.Fatalf("load -tls-cert/-tls-key: %v", err)
+		}
+		certs.reloadOnHangup()
+	}
+
+	log.Printf("starting ollama-proxy on %s  upstream=%s  db=%s  log=%s  h2c=%t",
+		o.listenAddr, proxy.DescribeUpstream(upstreamURL), o.dbPath, o.logPath, o.h2c)
+	if certs != nil {
+		log.Printf("TLS enabled: cert=%s (%s)  min_version=1.2", o.tlsCert, certs.describe())
+	} else {
+		log.Printf("TLS disabled: serving plain HTTP (set -tls-cert and -tls-key to enable)")
+	}
+	log.Printf("upstream transport: dial=%s  tls_handshake=%s  response_header=%s  idle_conn=%s  max_idle_per_host=%d",
+		o.dialTimeout, o.tlsTimeout, o.headerTimeout, o.idleConnTimeout, o.maxIdlePerHost)
+	if cfg.UpstreamTLS != nil {
+		log.Printf("upstream TLS: %s", o.describeUpstreamTLS())
+	}
+
+	ln, err := listen(o, metrics, logger)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+	if o.debugAddr != "" {
+		dln, err := net.Listen("tcp", o.debugAddr)
+		if err != nil {
+			log.Fatalf("debug listen: %v", err)
+		}
+		stopDebug := serveDebug(dln, logger)
+		defer stopDebug()
+		log.Printf("debug listener on %s: /debug/pprof/ and /debug/runtime", o.debugAddr)
+	}
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	errs := &serverErrors{metrics: metrics, logger: logger, log: o.logServerErrs}
+	srv := newServer(o, mux)
+	if certs != nil {
+		srv.TLSConfig = certs.tlsConfig()
+	}
+	errs.install(srv)
+	if err := serve(srv, errs.watch(ln), proxyHandler, o, logger, sig); err != nil {
 		log.Fatalf("server: %v", err)
 	}
 }
 
-// buildLogger creates a slog.Logger that writes JSON to both stdout and logPath.
-func buildLogger(logPath string) *slog.Logger {
+// startMockUpstream serves a synthetic Ollama on a loopback port and returns
+// its address. Requests still take the normal proxy path over the network, so
+// every metric behaves as it would against a real upstream.
+func startMockUpstream(o *options) (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	srv := mock.New(mock.Options{
+		PromptTokens:     o.mockPromptTok,
+		CompletionTokens: o.mockComplTok,
+		TokensPerSecond:  o.mockTokPerSec,
+	})
+	go func() { _ = http.Serve(ln, srv) }()
+	return ln.Addr().String(), nil
+}
+
+// reloadSLOsOnHangup rereads the SLO file on every SIGHUP and swaps in its
+// targets. A file that no longer parses is logged and the targets in use are
+// kept.
+func reloadSLOsOnHangup(path string, h *proxy.Handler, logger *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			targets, err := proxy.LoadSLOTargets(path)
+			if err != nil {
+				logger.Error("SLO reload failed, keeping the current targets", "path", path, "error", err)
+				continue
+			}
+			h.SetSLOTargets(targets)
+			logger.Info("SLO targets reloaded", "path", path, "targets", len(targets))
+		}
+	}()
+}
+
+// reloadAPIKeysOnHangup rereads the API keys file on every SIGHUP and swaps
+// in its keys. A file that no longer parses, or has no keys left, is logged
+// and the keys in use are kept: clients are never let in unauthenticated.
+func reloadAPIKeysOnHangup(path string, h *proxy.Handler, logger *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			keys, err := proxy.LoadAPIKeys(path)
+			if err != nil {
+				logger.Error("API key reload failed, keeping the current keys", "path", path, "error", err)
+				continue
+			}
+			h.SetAPIKeys(keys)
+			logger.Info("API keys reloaded", "path", path, "keys", len(keys))
+		}
+	}()
+}
+
+// buildLogger creates a slog.Logger that writes format (json or text) to both
+// stdout and logPath.
+func buildLogger(logPath, format string) *slog.Logger {
 	writers := []io.Writer{os.Stdout}
 
 	if logPath != "" {
-		if err := os.MkdirAll(filepath.Dir(logPath), 0o755); err != nil {
-			log.Printf("warn: cannot create log dir %s: %v", filepath.Dir(logPath), err)
+		if f, err := openLogFile(logPath); err != nil {
+			log.Printf("warn: %v", err)
 		} else {
-			f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-			if err != nil {
-				log.Printf("warn: cannot open log file %s: %v", logPath, err)
-			} else {
-				writers = append(writers, f)
-			}
+			writers = append(writers, f)
 		}
 	}
+	return newLogger(io.MultiWriter(writers...), format)
+}
+
+// buildAccessLogger creates the logger of -access-log: nil when path is
+// empty, stdout for "-", else the file.
+func buildAccessLogger(path, format string) (*slog.Logger, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return newLogger(os.Stdout, format), nil
+	}
+	f, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newLogger(f, format), nil
+}
 
-	w := io.MultiWriter(writers...)
-	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+func newLogger(w io.Writer, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if format == "text" {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// openLogFile opens path for appending, creating it and its directory.
+func openLogFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create log dir %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open log file %s: %w", path, err)
+	}
+	return f, nil
 }
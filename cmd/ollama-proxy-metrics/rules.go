@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promRule is one recording (record set) or alerting (alert set) rule.
+type promRule struct {
+	record, alert string
+	expr          string
+	forDur        time.Duration
+	labels        [][2]string
+	annotations   [][2]string
+}
+
+type promRuleGroup struct {
+	name  string
+	rules []promRule
+}
+
+// rulesConfig holds the thresholds of the rules subcommand.
+type rulesConfig struct {
+	window      time.Duration
+	downFor     time.Duration
+	latencyP95  time.Duration
+	errorRatio  float64
+	budgetRatio float64
+	alertFor    time.Duration
+	severity    string
+	minRPS      float64
+}
+
+// buildRules returns the recording and alerting rules for a proxy whose
+// metrics are prefixed with ns. Alerts use the recorded series, so the
+// recording group must be loaded too.
+func buildRules(ns string, c rulesConfig) []promRuleGroup {
+	m := func(name string) string { return ns + "_" + name }
+	w := "[" + promDuration(c.window) + "]"
+	p95 := "model:" + m("request_duration_seconds") + ":p95_rate" + promDuration(c.window)
+	tokensPerSec := "model:" + m("completion_tokens") + ":rate" + promDuration(c.window)
+	errRatio := "model:" + m("requests_errors") + ":ratio_rate" + promDuration(c.window)
+	burnRate := "slo:" + m("error_budget") + ":burn_rate" + promDuration(c.window)
+	sev := [][2]string{{"severity", c.severity}}
+
+	recording := promRuleGroup{name: ns + "_recording", rules: []promRule{
+		{record: p95, expr: fmt.Sprintf("histogram_quantile(0.95, sum by (le, model) (rate(%s_bucket%s)))",
+			m("request_duration_seconds"), w)},
+		{record: tokensPerSec, expr: fmt.Sprintf("sum by (model) (rate(%s%s))", m("completion_tokens_total"), w)},
+		{record: errRatio, expr: fmt.Sprintf(`sum by (model) (rate(%s{status=~"5..|timeout"}%s)) / sum by (model) (rate(%s%s))`,
+			m("requests_total"), w, m("requests_total"), w)},
+		// 1 spends the error budget exactly over the SLO period; 14.4 over a
+		// 1h window spends 2% of a 30-day budget.
+		{record: burnRate, expr: fmt.Sprintf("(sum by (slo, model) (rate(%s%s)) / sum by (slo, model) (rate(%s%s))) / on (slo) group_left (1 - %s)",
+			m("slo_violations_total"), w, m("slo_requests_total"), w, m("slo_objective"))},
+	}}
+
+	alerting := promRuleGroup{name: ns + "_alerts", rules: []promRule{
+		{
+			alert: "OllamaUpstreamDown",
+			expr: fmt.Sprintf(`sum(rate(%s{origin="proxy",status=~"502|504"}%s)) > 0 unless sum(rate(%s{origin="upstream"}%s)) > 0`,
+				m("requests_total"), w, m("requests_total"), w),
+			forDur: c.downFor,
+			labels: sev,
+			annotations: [][2]string{
+				{"summary", "Ollama upstream is not answering"},
+				{"description", "The proxy answered every recent request with 502/504 and got no response from Ollama. " +
+					"With -canary-models set, this also fires without client traffic."},
+			},
+		},
+		{
+			alert:  "OllamaProxyHighLatency",
+			expr:   fmt.Sprintf("%s > %s", p95, formatFloat(c.latencyP95.Seconds())),
+			forDur: c.alertFor,
+			labels: sev,
+			annotations: [][2]string{
+				{"summary", "p95 latency of {{ $labels.model }} is above " + c.latencyP95.String()},
+				{"description", "p95 request duration is {{ $value | humanizeDuration }}."},
+			},
+		},
+		{
+			alert: "OllamaProxyHighErrorRatio",
+			expr: fmt.Sprintf("%s > %s and on (model) sum by (model) (rate(%s%s)) > %s",
+				errRatio, formatFloat(c.errorRatio), m("requests_total"), w, formatFloat(c.minRPS)),
+			forDur: c.alertFor,
+			labels: sev,
+			annotations: [][2]string{
+				{"summary", "{{ $labels.model }} is failing more than " + formatFloat(c.errorRatio*100) + "% of requests"},
+				{"description", "5xx ratio is {{ $value | humanizePercentage }}."},
+			},
+		},
+		{
+			alert:  "OllamaProxyTokenBudgetNearlyExhausted",
+			expr:   fmt.Sprintf("%s >= %s", m("token_budget_used_ratio"), formatFloat(c.budgetRatio)),
+			labels: sev,
+			annotations: [][2]string{
+				{"summary", "{{ $labels.tenant }} has used " + formatFloat(c.budgetRatio*100) + "% of its token budget"},
+				{"description", "{{ $value | humanizePercentage }} of -token-budget used in the current window."},
+			},
+		},
+	}}
+	return []promRuleGroup{recording, alerting}
+}
+
+// writeRules writes groups as a Prometheus rule file. Strings are written as
+// JSON strings, which YAML reads as double-quoted scalars.
+func writeRules(w io.Writer, groups []promRuleGroup) error {
+	var b strings.Builder
+	q := func(s string) string {
+		var out bytes.Buffer
+		enc := json.NewEncoder(&out)
+		enc.SetEscapeHTML(false) // keep > and & readable in expressions
+		_ = enc.Encode(s)
+		return strings.TrimSuffix(out.String(), "\n")
+	}
+	b.WriteString("# Generated by `ollama-proxy-metrics rules`; regenerate instead of editing.\ngroups:\n")
+	for _, g := range groups {
+		fmt.Fprintf(&b, "  - name: %s\n    rules:\n", q(g.name))
+		for _, r := range g.rules {
+			if r.record != "" {
+				fmt.Fprintf(&b, "      - record: %s\n", q(r.record))
+			} else {
+				fmt.Fprintf(&b, "      - alert: %s\n", q(r.alert))
+			}
+			fmt.Fprintf(&b, "        expr: %s\n", q(r.expr))
+			if r.forDur > 0 {
+				fmt.Fprintf(&b, "        for: %s\n", promDuration(r.forDur))
+			}
+			for _, sec := range []struct {
+				name  string
+				pairs [][2]string
+			}{{"labels", r.labels}, {"annotations", r.annotations}} {
+				if len(sec.pairs) == 0 {
+					continue
+				}
+				fmt.Fprintf(&b, "        %s:\n", sec.name)
+				for _, kv := range sec.pairs {
+					fmt.Fprintf(&b, "          %s: %s\n", kv[0], q(kv[1]))
+				}
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// promDuration formats d in the largest whole Prometheus unit (5m, 90m, 1h).
+func promDuration(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	}
+	if d%time.Minute == 0 {
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	}
+	if d%time.Second == 0 {
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// runRules implements `ollama-proxy-metrics rules`. Like dashboard it
+// accepts the proxy's own flags, so the rules match the metrics a proxy
+// started with the same settings exports. It returns the process exit code:
+// 0 on success, 2 on usage errors.
+func runRules(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("rules", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	o := registerFlags(fs)
+	c := rulesConfig{}
+	fs.DurationVar(&c.window, "rules-window", 5*time.Minute, "rate() window of the recording rules")
+	fs.DurationVar(&c.downFor, "upstream-down-for", 5*time.Minute, "how long the upstream must fail before OllamaUpstreamDown fires")
+	fs.DurationVar(&c.latencyP95, "p95-latency-threshold", 30*time.Second, "OllamaProxyHighLatency fires above this p95")
+	fs.Float64Var(&c.errorRatio, "error-ratio-threshold", 0.05, "OllamaProxyHighErrorRatio fires above this 5xx ratio")
+	fs.Float64Var(&c.minRPS, "error-ratio-min-rps", 0.01, "ignore models with fewer requests per second in the error ratio alert")
+	fs.Float64Var(&c.budgetRatio, "token-budget-threshold", 0.9, "OllamaProxyTokenBudgetNearlyExhausted fires at this fraction of -token-budget")
+	fs.DurationVar(&c.alertFor, "alert-for", 10*time.Minute, "for: of the latency and error ratio alerts")
+	fs.StringVar(&c.severity, "severity", "warning", "severity label on the alerts")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !metricsNamespaceRE.MatchString(o.metricsNS) {
+		fmt.Fprintf(stderr, "rules: -metrics-namespace %q is not a valid metric name prefix\n", o.metricsNS)
+		return 2
+	}
+	if c.window < time.Second || c.downFor < 0 || c.alertFor < 0 || c.latencyP95 <= 0 {
+		fmt.Fprintln(stderr, "rules: -rules-window must be at least 1s, -p95-latency-threshold positive and for: durations not negative")
+		return 2
+	}
+	if c.errorRatio <= 0 || c.errorRatio > 1 || c.budgetRatio <= 0 || c.budgetRatio > 1 {
+		fmt.Fprintln(stderr, "rules: -error-ratio-threshold and -token-budget-threshold must be in (0, 1]")
+		return 2
+	}
+	if err := writeRules(stdout, buildRules(o.metricsNS, c)); err != nil {
+		fmt.Fprintf(stderr, "rules: %v\n", err)
+		return 1
+	}
+	return 0
+}
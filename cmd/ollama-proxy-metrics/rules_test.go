@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runRulesOutput(t *testing.T, args ...string) string {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	if code := runRules(args, &out, &errOut); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut.String())
+	}
+	return out.String()
+}
+
+// ruleValues returns the decoded values of every "key: <json string>" line.
+func ruleValues(t *testing.T, out, key string) []string {
+	t.Helper()
+	var vals []string
+	for _, line := range strings.Split(out, "\n") {
+		raw, ok := strings.CutPrefix(strings.TrimLeft(strings.TrimSpace(line), "- "), key+": ")
+		if !ok {
+			continue
+		}
+		var v string
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			t.Fatalf("%s value is not a quoted string: %s", key, line)
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+func TestRules_ExpressionsUseExportedMetrics(t *testing.T) {
+	for _, ns := range []string{"ollama_proxy", "llm_gateway"} {
+		out := runRulesOutput(t, "-metrics-namespace", ns)
+		records := ruleValues(t, out, "record")
+		if len(records) != 4 {
+			t.Fatalf("expected 4 recording rules, got %v", records)
+		}
+		names := registeredMetricNames(ns)
+		for _, expr := range ruleValues(t, out, "expr") {
+			for _, rec := range records {
+				expr = strings.ReplaceAll(expr, rec, "")
+			}
+			checkMetricRefs(t, ns, expr, names)
+		}
+	}
+}
+
+func TestRules_Thresholds(t *testing.T) {
+	out := runRulesOutput(t, "-p95-latency-threshold", "12s", "-error-ratio-threshold", "0.1",
+		"-token-budget-threshold", "0.8", "-upstream-down-for", "3m", "-rules-window", "2m", "-severity", "page")
+	alerts := ruleValues(t, out, "alert")
+	want := []string{"OllamaUpstreamDown", "OllamaProxyHighLatency", "OllamaProxyHighErrorRatio", "OllamaProxyTokenBudgetNearlyExhausted"}
+	if strings.Join(alerts, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected alerts %v", alerts)
+	}
+	for _, s := range []string{
+		"model:ollama_proxy_request_duration_seconds:p95_rate2m > 12",
+		"model:ollama_proxy_requests_errors:ratio_rate2m > 0.1",
+		"ollama_proxy_token_budget_used_ratio >= 0.8",
+		"for: 3m",
+		`severity: "page"`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in the rule file", s)
+		}
+	}
+}
+
+func TestRules_BadThresholds(t *testing.T) {
+	for _, args := range [][]string{
+		{"-error-ratio-threshold", "5"},
+		{"-token-budget-threshold", "0"},
+		{"-rules-window", "0"},
+		{"-metrics-namespace", "a.b"},
+	} {
+		var out, errOut bytes.Buffer
+		if code := runRules(args, &out, &errOut); code != 2 {
+			t.Errorf("%v: expected exit 2, got %d", args, code)
+		}
+	}
+}
+
+func TestPromDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		5 * time.Minute: "5m", 90 * time.Minute: "90m", 2 * time.Hour: "2h", 45 * time.Second: "45s", 1500 * time.Millisecond: "1500ms",
+	} {
+		if got := promDuration(d); got != want {
+			t.Errorf("promDuration(%s) = %s, want %s", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// runSelfTest serves h, the proxy's full mux, on an ephemeral loopback port
+// and sends one request through it to the real upstream: a tiny streamed
+// generate for -self-test-model, or GET /api/version with
+// -self-test-skip-generate. It checks the response, its token counts and
+// that the request reached the metrics in reg.
+func runSelfTest(ctx context.Context, o *options, h http.Handler, reg prometheus.Gatherer) *report {
+	r := &report{}
+	if !o.selfTestSkipGen && o.selfTestModel == "" {
+		r.fail("self_test", "-self-test-model is required unless -self-test-skip-generate is set")
+		return r
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		r.fail("self_test", "listen: %v", err)
+		return r
+	}
+	srv := newServer(o, h)
+	go func() { _ = srv.Serve(ln) }()
+	defer func() { _ = srv.Close() }()
+	base := "http://" + ln.Addr().String()
+
+	endpoint := "/api/generate"
+	if o.selfTestSkipGen {
+		endpoint = "/api/version"
+		selfTestVersion(ctx, r, base+endpoint)
+	} else {
+		selfTestGenerate(ctx, r, base+endpoint, o)
+	}
+	checkSelfTestMetrics(r, reg, o.metricsNS, endpoint)
+	return r
+}
+
+func selfTestVersion(ctx context.Context, r *report, url string) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.fail("response", "GET /api/version through the proxy: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); resp.StatusCode != http.StatusOK || err != nil || v.Version == "" {
+		r.fail("response", "GET /api/version answered %d without a version", resp.StatusCode)
+		return
+	}
+	r.ok("response", "upstream version %s through the proxy in %dms", v.Version, time.Since(start).Milliseconds())
+}
+
+func selfTestGenerate(ctx context.Context, r *report, url string, o *options) {
+	body, _ := json.Marshal(map[string]any{
+		"model":   o.selfTestModel,
+		"prompt":  o.canaryPrompt,
+		"stream":  true,
+		"options": map[string]any{"num_predict": o.canaryPredict},
+	})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.fail("response", "generate through the proxy: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		r.fail("response", "generate for %s answered %d: %s", o.selfTestModel, resp.StatusCode, e.Error)
+		return
+	}
+
+	var stats proxy.ChunkStats
+	lines, invalid := 0, 0
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64<<10), 4<<20)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		lines++
+		if !stats.Observe(sc.Bytes()) {
+			invalid++
+		}
+	}
+	took := time.Since(start)
+	switch {
+	case sc.Err() != nil:
+		r.fail("response", "stream broke off after %d lines: %v", lines, sc.Err())
+		return
+	case invalid > 0 || !stats.Done:
+		r.fail("response", "stream of %d lines had %d invalid and done=%t", lines, invalid, stats.Done)
+		return
+	}
+	r.ok("response", "%s generated %q in %dms over %d lines", o.selfTestModel, stats.Text(), took.Milliseconds(), lines)
+	if !stats.SawPrompt || !stats.SawCompletion {
+		r.fail("tokens", "the final chunk carried no token counts (prompt %t, completion %t)", stats.SawPrompt, stats.SawCompletion)
+		return
+	}
+	r.ok("tokens", "%d prompt and %d completion tokens", stats.PromptTokens, stats.CompletionTokens)
+}
+
+// checkSelfTestMetrics looks for the self-test request among the 200s of
+// requests_total.
+func checkSelfTestMetrics(r *report, reg prometheus.Gatherer, ns, endpoint string) {
+	families, err := reg.Gather()
+	if err != nil {
+		r.fail("metrics", "gather: %v", err)
+		return
+	}
+	name := ns + "_requests_total"
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["endpoint"] == endpoint && labels["status"] == "200" && m.GetCounter().GetValue() > 0 {
+				r.ok("metrics", "counted in %s{endpoint=%q,status=\"200\"}", name, endpoint)
+				return
+			}
+		}
+	}
+	r.fail("metrics", "%s has no status 200 request for %s", name, endpoint)
+}
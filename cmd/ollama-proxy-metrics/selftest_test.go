@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/mock"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// selfTest runs the self-test of a proxy for upstream with args.
+func selfTest(t *testing.T, upstream string, args ...string) *report {
+	t.Helper()
+	store, err := db.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	u, _ := url.Parse(upstream)
+	reg := prometheus.NewRegistry()
+	h := proxy.New(u, store, slog.New(slog.NewTextHandler(io.Discard, nil)), proxy.NewMetrics(reg), proxy.Config{})
+	t.Cleanup(func() { _ = h.Close() })
+	mux := http.NewServeMux()
+	mux.Handle("/api/", h)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return runSelfTest(ctx, testOptions(t, args...), mux, reg)
+}
+
+func severities(r *report) map[string]string {
+	out := map[string]string{}
+	for _, f := range r.Findings {
+		out[f.Check] = f.Severity
+	}
+	return out
+}
+
+func TestSelfTest_Generate(t *testing.T) {
+	up := httptest.NewServer(mock.New(mock.Options{PromptTokens: 5, CompletionTokens: 3, TokensPerSecond: 1000}))
+	defer up.Close()
+	rep := selfTest(t, up.URL, "-self-test-model", "llama3")
+
+	if !rep.passed(false) {
+		t.Fatalf("expected the self-test to pass, got %+v", rep.Findings)
+	}
+	got := severities(rep)
+	for _, check := range []string{"response", "tokens", "metrics"} {
+		if got[check] != severityOK {
+			t.Errorf("expected check %s ok, got %q", check, got[check])
+		}
+	}
+}
+
+func TestSelfTest_SkipGenerate(t *testing.T) {
+	generated := false
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/version" {
+			generated = true
+		}
+		_, _ = fmt.Fprint(w, `{"version":"0.5.7"}`)
+	}))
+	defer up.Close()
+	rep := selfTest(t, up.URL, "-self-test-skip-generate")
+
+	if !rep.passed(false) || generated {
+		t.Errorf("expected only /api/version requested and the self-test passed, got %+v", rep.Findings)
+	}
+}
+
+func TestSelfTest_Failures(t *testing.T) {
+	noTokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"OK","done":true}`)
+	}))
+	defer noTokens.Close()
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprint(w, `{"error":"model 'llama3' not found"}`)
+	}))
+	defer missing.Close()
+
+	for _, tc := range []struct {
+		name, upstream string
+		args           []string
+		check          string
+	}{
+		{"no model", noTokens.URL, nil, "self_test"},
+		{"no token counts", noTokens.URL, []string{"-self-test-model", "llama3"}, "tokens"},
+		{"model missing", missing.URL, []string{"-self-test-model", "llama3"}, "response"},
+		{"upstream down", "http://127.0.0.1:1", []string{"-self-test-model", "llama3"}, "response"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rep := selfTest(t, tc.upstream, tc.args...)
+			if rep.passed(false) {
+				t.Fatalf("expected the self-test to fail, got %+v", rep.Findings)
+			}
+			if got := severities(rep)[tc.check]; got != severityError {
+				t.Errorf("expected check %s to fail, got %q in %+v", tc.check, got, rep.Findings)
+			}
+		})
+	}
+}
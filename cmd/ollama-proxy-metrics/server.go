@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/connlimit"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// newServer returns the HTTP server for the proxy listener. HTTP/1.1 is
+// always served, and HTTP/2 too once serve is given a TLSConfig; with -h2c,
+// clients with prior knowledge may also speak cleartext HTTP/2 on the same
+// port.
+func newServer(o *options, h http.Handler) *http.Server {
+	var protos http.Protocols
+	protos.SetHTTP1(true)
+	protos.SetHTTP2(true)
+	protos.SetUnencryptedHTTP2(o.h2c)
+	return &http.Server{
+		Addr:      o.listenAddr,
+		Handler:   h,
+		Protocols: &protos,
+	}
+}
+
+// listen opens the proxy listener, enforcing -max-connections and
+// -max-connections-per-client on every accepted connection.
+func listen(o *options, metrics *proxy.Metrics, logger *slog.Logger) (net.Listener, error) {
+	ln, err := net.Listen("tcp", o.listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	if o.maxConns <= 0 && o.maxConnsPerIP <= 0 {
+		return ln, nil
+	}
+	exempt, err := connlimit.ParseCIDRs(splitList(o.trustedProxies))
+	if err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return connlimit.New(ln, connlimit.Options{
+		PerClient: o.maxConnsPerIP,
+		Max:       o.maxConns,
+		Exempt:    exempt,
+		OnReject: func(ip, reason string) {
+			metrics.ConnRejected.WithLabelValues(reason).Inc()
+			logger.Warn("connection refused over limit", "client_ip", ip, "limit", reason)
+		},
+	}), nil
+}
+
+// shutdownCloseTimeout bounds closing the listener and the connections left
+// after the drain.
+const shutdownCloseTimeout = 5 * time.Second
+
+// serve serves srv on ln, over TLS when srv has a TLSConfig, until a signal
+// arrives on sig, then shuts down gracefully. The listener stays open while h drains, so /metrics and
+// /stats answer the last scrapes while new proxied requests get 503s and
+// keep-alive connections are closed as their requests finish. Requests
+// still running after -shutdown-timeout, or once a second signal arrives,
+// are canceled.
+func serve(srv *http.Server, ln net.Listener, h *proxy.Handler, o *options, logger *slog.Logger, sig <-chan os.Signal) error {
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ServeTLS(ln, "", "") // the certificate comes from TLSConfig
+			return
+		}
+		errc <- srv.Serve(ln)
+	}()
+	var s os.Signal
+	select {
+	case err := <-errc:
+		return err
+	case s = <-sig:
+	}
+
+	logger.Info("shutting down, draining requests in flight", "signal", s.String(), "timeout", o.shutdownTimeout.String())
+	srv.SetKeepAlivesEnabled(false)
+	ctx, cancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	drained := h.Drain(ctx) == nil
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), shutdownCloseTimeout)
+	defer closeCancel()
+	if err := srv.Shutdown(closeCtx); err != nil {
+		_ = srv.Close()
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("shut down", "drained", drained)
+	return nil
+}
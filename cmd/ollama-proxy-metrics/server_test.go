@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// serveProxy starts newServer in front of a proxy for upstream and returns
+// its base URL.
+func serveProxy(t *testing.T, o *options, upstream string) string {
+	t.Helper()
+	store, err := db.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	u, _ := url.Parse(upstream)
+	h := proxy.New(u, store, slog.New(slog.NewTextHandler(io.Discard, nil)), proxy.NewMetrics(prometheus.NewRegistry()), proxy.Config{})
+	t.Cleanup(func() { _ = h.Close() })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(o, h)
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return "http://" + ln.Addr().String()
+}
+
+func h2cClient() *http.Client {
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: &p}}
+}
+
+func TestServer_H2CStreamsPerChunk(t *testing.T) {
+	next := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		w.(http.Flusher).Flush()
+		<-next // the client must see the first chunk before the second exists
+		_, _ = fmt.Fprintln(w, `{"response":"b","done":true,"eval_count":2}`)
+	}))
+	defer up.Close()
+	base := serveProxy(t, testOptions(t, "-h2c"), up.URL)
+
+	resp, err := h2cClient().Post(base+"/api/generate", "application/json", strings.NewReader(`{"model":"m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+	rd := bufio.NewReader(resp.Body)
+	first, err := rd.ReadString('\n')
+	if err != nil || !strings.Contains(first, `"a"`) {
+		t.Fatalf("expected the first chunk before the stream ends, got %q %v", first, err)
+	}
+	close(next)
+	if rest, _ := io.ReadAll(rd); !strings.Contains(string(rest), `"b"`) {
+		t.Errorf("expected the final chunk, got %q", rest)
+	}
+}
+
+func TestServer_HTTP1Unaffected(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"models":[]}`)
+	}))
+	defer up.Close()
+	for _, args := range [][]string{{"-h2c"}, nil} {
+		base := serveProxy(t, testOptions(t, args...), up.URL)
+		resp, err := http.Get(base + "/api/tags")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusOK {
+			t.Errorf("%v: expected HTTP/1.1 200, got %s %d", args, resp.Proto, resp.StatusCode)
+		}
+	}
+	base := serveProxy(t, testOptions(t), up.URL)
+	if _, err := h2cClient().Get(base + "/api/tags"); err == nil {
+		t.Error("expected prior-knowledge HTTP/2 to fail without -h2c")
+	}
+}
+
+func TestServe_DrainsOnSignal(t *testing.T) {
+	next := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		w.(http.Flusher).Flush()
+		<-next
+		_, _ = fmt.Fprintln(w, `{"response":"b","done":true,"eval_count":2}`)
+	}))
+	defer up.Close()
+	store, err := db.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close() }()
+	u, _ := url.Parse(up.URL)
+	reg := prometheus.NewRegistry()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := proxy.New(u, store, logger, proxy.NewMetrics(reg), proxy.Config{})
+	defer func() { _ = h.Close() }()
+	o := testOptions(t, "-shutdown-timeout", "10s")
+	mux := http.NewServeMux()
+	for _, rt := range routes(o, reg, h, store) {
+		mux.Handle(rt.Pattern, rt.handler)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + ln.Addr().String()
+	sig := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() { served <- serve(newServer(o, mux), ln, h, o, logger, sig) }()
+
+	resp, err := http.Post(base+"/api/generate", "application/json", strings.NewReader(`{"model":"m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	rd := bufio.NewReader(resp.Body)
+	if _, err := rd.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	sig <- syscall.SIGTERM
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		late, err := http.Post(base+"/api/generate", "application/json", strings.NewReader(`{"model":"m"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = late.Body.Close()
+		if late.StatusCode == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected new requests refused while draining, got %d", late.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	metrics, err := http.Get(base + "/metrics")
+	if err != nil {
+		t.Fatalf("expected /metrics to answer during the drain: %v", err)
+	}
+	_ = metrics.Body.Close()
+	if metrics.StatusCode != http.StatusOK {
+		t.Errorf("expected /metrics to answer during the drain, got %d", metrics.StatusCode)
+	}
+
+	close(next)
+	if rest, _ := io.ReadAll(rd); !strings.Contains(string(rest), `"done":true`) {
+		t.Errorf("expected the stream to finish, got %q", rest)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected serve to return once drained")
+	}
+	if _, err := http.Get(base + "/metrics"); err == nil {
+		t.Error("expected the listener closed after the drain")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// Kinds of ollama_proxy_server_errors_total.
+const (
+	serverErrTLSHandshake = "tls_handshake"
+	serverErrHeaderParse  = "header_parse"
+	serverErrTooLarge     = "too_large"
+)
+
+// tlsHandshakeErrorPrefix starts the line net/http logs for a failed TLS
+// handshake, followed by "<remote address>: <reason>".
+const tlsHandshakeErrorPrefix = "http: TLS handshake error from "
+
+// serverErrors counts the requests Go's HTTP server turns away itself, which
+// never reach a handler: TLS handshakes failing, request lines or headers it
+// cannot parse and headers over MaxHeaderBytes. The server answers the last
+// two with a response of its own, written straight to the connection; a
+// watched connection spots those writes while no handler is running, and
+// the server's ErrorLog reports the handshakes.
+type serverErrors struct {
+	metrics *proxy.Metrics
+	logger  *slog.Logger
+	log     bool // log each one with the client address (-log-server-errors)
+}
+
+// install hooks e into srv, whose connections must come from e.watch.
+func (e *serverErrors) install(srv *http.Server) {
+	srv.ErrorLog = log.New(errorLogWriter{e}, "", 0)
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, watchedConnKey{}, c)
+	}
+	srv.ConnState = func(c net.Conn, s http.ConnState) {
+		// StateIdle fires once a response is written, before the next
+		// request on a keep-alive connection is read.
+		if wc, ok := c.(*watchedConn); ok && s == http.StateIdle {
+			wc.handling.Store(false)
+		}
+	}
+	next := srv.Handler
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wc, ok := r.Context().Value(watchedConnKey{}).(*watchedConn); ok {
+			wc.handling.Store(true)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// watch wraps the connections ln accepts for e.
+func (e *serverErrors) watch(ln net.Listener) net.Listener {
+	return watchedListener{Listener: ln, errs: e}
+}
+
+func (e *serverErrors) record(kind, addr, detail string) {
+	e.metrics.ServerErrors.WithLabelValues(kind).Inc()
+	if e.log {
+		e.logger.Warn("request rejected by the HTTP server", "kind", kind, "remote_addr", addr, "detail", detail)
+	}
+}
+
+// errorLogWriter receives the lines the HTTP server logs.
+type errorLogWriter struct{ errs *serverErrors }
+
+func (w errorLogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSpace(string(p))
+	if rest, ok := strings.CutPrefix(line, tlsHandshakeErrorPrefix); ok {
+		addr, reason, _ := strings.Cut(rest, ": ")
+		w.errs.record(serverErrTLSHandshake, addr, reason)
+		return len(p), nil
+	}
+	w.errs.logger.Warn("http server error", "error", line)
+	return len(p), nil
+}
+
+type watchedConnKey struct{}
+
+type watchedListener struct {
+	net.Listener
+	errs *serverErrors
+}
+
+func (l watchedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &watchedConn{Conn: c, errs: l.errs}, nil
+}
+
+// watchedConn is a client connection whose writes outside a handler are
+// the server's own error responses.
+type watchedConn struct {
+	net.Conn
+	errs     *serverErrors
+	handling atomic.Bool // the current request reached the handler
+}
+
+func (c *watchedConn) Write(p []byte) (int, error) {
+	if !c.handling.Load() {
+		if kind := rejectedKind(p); kind != "" {
+			status, _, _ := bytes.Cut(p, []byte("\r\n"))
+			c.errs.record(kind, c.RemoteAddr().String(), string(status))
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+// rejectedKind classifies a response the server wrote without a handler:
+// 431 for headers over the limit, any other status for a request it could
+// not parse.
+func rejectedKind(p []byte) string {
+	status, ok := bytes.CutPrefix(p, []byte("HTTP/1.1 "))
+	if !ok || len(status) < 3 {
+		return ""
+	}
+	if string(status[:3]) == "431" {
+		return serverErrTooLarge
+	}
+	return serverErrHeaderParse
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// lockedBuffer collects log output written by the server's goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// serveWatched serves h behind serverErrors and returns the listener's
+// address with the metrics and log the errors are recorded in.
+func serveWatched(t *testing.T, h http.Handler) (string, *proxy.Metrics, *lockedBuffer) {
+	t.Helper()
+	metrics := proxy.NewMetrics(prometheus.NewRegistry())
+	logs := &lockedBuffer{}
+	errs := &serverErrors{metrics: metrics, logger: slog.New(slog.NewTextHandler(logs, nil)), log: true}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(testOptions(t), h)
+	errs.install(srv)
+	go func() { _ = srv.Serve(errs.watch(ln)) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return ln.Addr().String(), metrics, logs
+}
+
+// rawStatus writes reqs to a new connection to addr one after the other and
+// returns the status line of each response.
+func rawStatus(t *testing.T, addr string, reqs ...string) []string {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	rd := bufio.NewReader(c)
+	var statuses []string
+	for _, req := range reqs {
+		_, _ = io.WriteString(c, req) // the server may hang up before reading it all
+		resp, err := http.ReadResponse(rd, nil)
+		if err != nil {
+			t.Fatalf("reading the response to %.40q: %v", req, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		statuses = append(statuses, resp.Status)
+	}
+	return statuses
+}
+
+func TestServerErrors_Rejections(t *testing.T) {
+	addr, metrics, logs := serveWatched(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad model", http.StatusBadRequest) // answered by the handler: not counted
+	}))
+	count := func(kind string) float64 { return testutil.ToFloat64(metrics.ServerErrors.WithLabelValues(kind)) }
+
+	if got := rawStatus(t, addr, "GARBAGE\r\n\r\n"); got[0] != "400 Bad Request" {
+		t.Fatalf("expected the server's own 400, got %v", got)
+	}
+	if got := count(serverErrHeaderParse); got != 1 {
+		t.Errorf("expected an unparsable request counted as %s, got %v", serverErrHeaderParse, got)
+	}
+
+	big := "GET / HTTP/1.1\r\nHost: x\r\nX-Big: " + strings.Repeat("a", http.DefaultMaxHeaderBytes+8192) + "\r\n\r\n"
+	if got := rawStatus(t, addr, big); got[0] != "431 Request Header Fields Too Large" {
+		t.Fatalf("expected 431, got %v", got)
+	}
+	if got := count(serverErrTooLarge); got != 1 {
+		t.Errorf("expected oversized headers counted as %s, got %v", serverErrTooLarge, got)
+	}
+
+	got := rawStatus(t, addr, "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "GET /\x00 HTTP/1.1\r\n\r\n")
+	if got[0] != "400 Bad Request" || got[2] != "400 Bad Request" {
+		t.Fatalf("expected the handler's 400s, then the server's, got %v", got)
+	}
+	if got := count(serverErrHeaderParse); got != 2 {
+		t.Errorf("expected only the unparsable request on the keep-alive connection counted, got %v", got)
+	}
+	if !strings.Contains(logs.String(), "remote_addr=127.0.0.1:") || !strings.Contains(logs.String(), "kind="+serverErrTooLarge) {
+		t.Errorf("expected the rejections logged with the client address, got %q", logs.String())
+	}
+}
+
+func TestServerErrors_TLSHandshake(t *testing.T) {
+	metrics := proxy.NewMetrics(prometheus.NewRegistry())
+	logs := &lockedBuffer{}
+	errs := &serverErrors{metrics: metrics, logger: slog.New(slog.NewTextHandler(logs, nil))}
+	ts := httptest.NewUnstartedServer(http.NotFoundHandler())
+	errs.install(ts.Config)
+	ts.StartTLS()
+	defer ts.Close()
+
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _ = io.Copy(io.Discard, c) // until the server hangs up
+	_ = c.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(metrics.ServerErrors.WithLabelValues(serverErrTLSHandshake)) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failed handshake counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if logs.String() != "" {
+		t.Errorf("expected nothing logged without -log-server-errors, got %q", logs.String())
+	}
+
+	ts.Config.ErrorLog.Print("http: panic serving 127.0.0.1:1: boom")
+	if !strings.Contains(logs.String(), "panic serving") {
+		t.Errorf("expected other server errors logged, got %q", logs.String())
+	}
+}
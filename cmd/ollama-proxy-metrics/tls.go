@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certCheckInterval is how often a TLS handshake looks at the certificate
+// files for a renewal.
+const certCheckInterval = 30 * time.Second
+
+// certReloader serves the certificate of -tls-cert and -tls-key, loading it
+// again when either file changes on disk or on SIGHUP, so that renewals need
+// no restart. A pair that fails to load is logged and the certificate in use
+// is kept.
+type certReloader struct {
+	certPath, keyPath string
+	logger            *slog.Logger
+	checkEvery        time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // the later of the two files' when cert was loaded
+	checked time.Time
+}
+
+func newCertReloader(certPath, keyPath string, logger *slog.Logger) (*certReloader, error) {
+	c := &certReloader{certPath: certPath, keyPath: keyPath, logger: logger, checkEvery: certCheckInterval}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// filesModTime is the later modification time of the two files.
+func (c *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certPath, c.keyPath} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load reads the pair and swaps it in.
+func (c *certReloader) load() error {
+	mod, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert, c.modTime = &cert, mod
+	c.mu.Unlock()
+	return nil
+}
+
+// reload loads the pair again, keeping the certificate in use on error.
+func (c *certReloader) reload(why string) {
+	if err := c.load(); err != nil {
+		c.logger.Error("TLS certificate reload failed, keeping the current one", "cert", c.certPath, "reason", why, "error", err)
+		return
+	}
+	c.logger.Info("TLS certificate reloaded", "cert", c.certPath, "reason", why, "certificate", c.describe())
+}
+
+// checkFiles reloads the pair when a file changed since it was loaded, at
+// most every checkEvery.
+func (c *certReloader) checkFiles(now time.Time) {
+	c.mu.Lock()
+	due := now.Sub(c.checked) >= c.checkEvery
+	if due {
+		c.checked = now
+	}
+	loaded := c.modTime
+	c.mu.Unlock()
+	if !due {
+		return
+	}
+	if mod, err := c.filesModTime(); err == nil && !mod.Equal(loaded) {
+		c.reload("changed on disk")
+	}
+}
+
+// getCertificate is the tls.Config hook.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.checkFiles(time.Now())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
+
+// reloadOnHangup reloads the pair on every SIGHUP.
+func (c *certReloader) reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			c.reload("SIGHUP")
+		}
+	}()
+}
+
+// describe names the certificate in use for the logs.
+func (c *certReloader) describe() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return describeCert(c.cert)
+}
+
+func describeCert(cert *tls.Certificate) string {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return "unparsable certificate"
+		}
+	}
+	names := leaf.DNSNames
+	if len(names) == 0 {
+		names = []string{leaf.Subject.CommonName}
+	}
+	return fmt.Sprintf("%s, expires %s", strings.Join(names, ","), leaf.NotAfter.UTC().Format(time.RFC3339))
+}
+
+// upstreamTLSConfig is the TLS configuration for https upstreams from the
+// -upstream-* flags, nil when none is set so that Go's defaults apply.
+func (o *options) upstreamTLSConfig() (*tls.Config, error) {
+	if o.upstreamCA == "" && o.upstreamClientCert == "" && o.upstreamClientKey == "" && o.upstreamServerName == "" && !o.upstreamInsecure {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		ServerName:         o.upstreamServerName,
+		InsecureSkipVerify: o.upstreamInsecure,
+	}
+	if o.upstreamCA != "" {
+		pem, err := os.ReadFile(o.upstreamCA)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -upstream-ca-file: %v", err)
+		}
+		if cfg.RootCAs, err = x509.SystemCertPool(); err != nil {
+			cfg.RootCAs = x509.NewCertPool()
+		}
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid -upstream-ca-file: no PEM certificates in %s", o.upstreamCA)
+		}
+	}
+	if (o.upstreamClientCert == "") != (o.upstreamClientKey == "") {
+		return nil, fmt.Errorf("-upstream-client-cert and -upstream-client-key must be set together")
+	}
+	if o.upstreamClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(o.upstreamClientCert, o.upstreamClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -upstream-client-cert/-upstream-client-key: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// describeUpstreamTLS summarizes the -upstream-* TLS flags for the logs.
+func (o *options) describeUpstreamTLS() string {
+	var parts []string
+	if o.upstreamCA != "" {
+		parts = append(parts, "ca="+o.upstreamCA)
+	}
+	if o.upstreamClientCert != "" {
+		parts = append(parts, "client_cert="+o.upstreamClientCert)
+	}
+	if o.upstreamServerName != "" {
+		parts = append(parts, "server_name="+o.upstreamServerName)
+	}
+	if o.upstreamInsecure {
+		parts = append(parts, "verify=off")
+	}
+	return strings.Join(parts, "  ")
+}
+
+// tlsConfig is the listener's TLS configuration, TLS 1.2 at least.
+func (c *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.getCertificate,
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 named name, and
+// its key, to dir, returning the two paths and the certificate.
+func writeCert(t *testing.T, dir, name string) (certPath, keyPath string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	return certPath, keyPath, cert
+}
+
+func servedName(t *testing.T, c *certReloader) string {
+	t.Helper()
+	cert, err := c.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertReloader_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, _ := writeCert(t, dir, "old.example")
+	c, err := newCertReloader(certPath, keyPath, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.checkEvery = 0
+	if got := servedName(t, c); got != "old.example" {
+		t.Fatalf("expected the loaded certificate, got %s", got)
+	}
+
+	// A renewal writes new files; their modification time tells.
+	writeCert(t, dir, "new.example")
+	later := time.Now().Add(time.Minute)
+	for _, p := range []string{certPath, keyPath} {
+		if err := os.Chtimes(p, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := servedName(t, c); got != "new.example" {
+		t.Errorf("expected the renewed certificate, got %s", got)
+	}
+
+	// A half-written renewal keeps the certificate in use.
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	_ = os.Chtimes(keyPath, later, later)
+	if got := servedName(t, c); got != "new.example" {
+		t.Errorf("expected the current certificate kept, got %s", got)
+	}
+}
+
+func TestCertReloader_ReloadsOnHangup(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, _ := writeCert(t, dir, "old.example")
+	c, err := newCertReloader(certPath, keyPath, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.checkEvery = time.Hour // only the signal reloads
+	c.reloadOnHangup()
+	writeCert(t, dir, "new.example")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName(t, c) != "new.example" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the certificate reloaded on SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServe_TLS(t *testing.T) {
+	certPath, keyPath, cert := writeCert(t, t.TempDir(), "proxy.example")
+	c, err := newCertReloader(certPath, keyPath, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := testOptions(t)
+	srv := newServer(o, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+	srv.TLSConfig = c.tlsConfig()
+	sig := make(chan os.Signal, 1)
+	go func() { _ = serve(srv, ln, nil, o, slog.New(slog.NewTextHandler(io.Discard, nil)), sig) }() // returns once closed
+	t.Cleanup(func() { _ = srv.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, ForceAttemptHTTP2: true}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.TLS == nil || resp.TLS.Version < tls.VersionTLS12 || string(body) != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2 over TLS 1.2 or later, got %s over %+v", body, resp.TLS)
+	}
+
+	old := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS11}}}
+	if resp, err := old.Get("https://" + ln.Addr().String() + "/"); err == nil {
+		resp.Body.Close()
+		t.Error("expected a TLS 1.1 client refused")
+	}
+}
+
+func TestUpstreamTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	caPath, _, ca := writeCert(t, dir, "ollama.internal")
+	clientDir := t.TempDir()
+	clientCert, clientKey, _ := writeCert(t, clientDir, "proxy.client")
+
+	o := testOptions(t)
+	if cfg, err := o.upstreamTLSConfig(); cfg != nil || err != nil {
+		t.Fatalf("expected Go's defaults without flags, got %v, %v", cfg, err)
+	}
+	o = testOptions(t, "-upstream-ca-file", caPath, "-upstream-client-cert", clientCert, "-upstream-client-key", clientKey,
+		"-upstream-tls-server-name", "ollama.internal")
+	cfg, err := o.upstreamTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.Verify(x509.VerifyOptions{Roots: cfg.RootCAs, DNSName: "ollama.internal"}); err != nil {
+		t.Errorf("expected the CA file trusted, got %v", err)
+	}
+	if len(cfg.Certificates) != 1 || cfg.ServerName != "ollama.internal" || cfg.InsecureSkipVerify {
+		t.Errorf("expected the client certificate and server name, got %+v", cfg)
+	}
+
+	notPEM := filepath.Join(dir, "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-upstream-ca-file", notPEM},
+		{"-upstream-ca-file", filepath.Join(dir, "missing.pem")},
+		{"-upstream-client-cert", clientCert},
+		{"-upstream-client-cert", clientCert, "-upstream-client-key", caPath + ".missing"},
+	} {
+		if _, err := testOptions(t, args...).upstreamTLSConfig(); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestUpstreamTLSConfig_MutualTLS(t *testing.T) {
+	serverCert, serverKey, _ := writeCert(t, t.TempDir(), "ollama.internal")
+	clientCert, clientKey, client := writeCert(t, t.TempDir(), "proxy.client")
+	pair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := x509.NewCertPool()
+	clients.AddCert(client)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	upstream.TLS = &tls.Config{Certificates: []tls.Certificate{pair}, ClientCAs: clients, ClientAuth: tls.RequireAndVerifyClientCert}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	o := testOptions(t, "-upstream", upstream.URL, "-upstream-ca-file", serverCert,
+		"-upstream-client-cert", clientCert, "-upstream-client-key", clientKey, "-upstream-tls-server-name", "ollama.internal")
+	cfg, err := o.upstreamTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = cfg
+	resp, err := (&http.Client{Transport: tr}).Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "proxy.client" {
+		t.Errorf("expected the client certificate presented, got %q", body)
+	}
+}
@@ -0,0 +1,936 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/connlimit"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/kv"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+// Finding severities. Errors always fail preflight; warnings only with
+// -strict-startup.
+const (
+	severityOK      = "ok"
+	severityWarning = "warning"
+	severityError   = "error"
+)
+
+type finding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// report is the result of preflight: one finding per check and problem.
+type report struct {
+	Findings []finding `json:"findings"`
+	Errors   int       `json:"errors"`
+	Warnings int       `json:"warnings"`
+}
+
+func (r *report) add(check, severity, format string, args ...any) {
+	r.Findings = append(r.Findings, finding{Check: check, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	switch severity {
+	case severityError:
+		r.Errors++
+	case severityWarning:
+		r.Warnings++
+	}
+}
+
+func (r *report) ok(check, format string, args ...any) {
+	r.add(check, severityOK, format, args...)
+}
+
+func (r *report) warn(check, format string, args ...any) {
+	r.add(check, severityWarning, format, args...)
+}
+
+func (r *report) fail(check, format string, args ...any) {
+	r.add(check, severityError, format, args...)
+}
+
+// passed reports whether the proxy may start: no errors, and no warnings
+// either when strict.
+func (r *report) passed(strict bool) bool {
+	return r.Errors == 0 && (!strict || r.Warnings == 0)
+}
+
+func (r *report) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// preflight checks o for problems that would otherwise only surface once
+// traffic arrives. It never modifies state beyond creating and removing a
+// probe file in existing directories. With probe set it also contacts the
+// upstream and Redis.
+func preflight(ctx context.Context, o *options, probe bool) *report {
+	r := &report{}
+	checkListen(r, o.listenAddr)
+	checkDebugAddr(r, o)
+	checkTLS(r, o)
+	if o.mockUpstream {
+		r.ok("upstream", "mock upstream, Ollama is not contacted")
+	} else {
+		checkUpstreamTLS(r, o)
+		checkUpstream(ctx, r, o.upstreamRaw, o.upPrefix, probeClient(o), probe)
+	}
+	checkWritableFile(r, "db", o.dbPath, severityError)
+	if o.logPath == "" {
+		r.ok("log", "file logging disabled, stdout only")
+	} else {
+		checkWritableFile(r, "log", o.logPath, severityWarning)
+	}
+	if o.logFormat != "json" && o.logFormat != "text" {
+		r.fail("log-format", "-log-format must be json or text, got %q", o.logFormat)
+	}
+	if o.accessLog != "" && o.accessLog != "-" {
+		checkWritableFile(r, "access-log", o.accessLog, severityError)
+	}
+	checkStatic(r, o.staticDir)
+	checkApdex(r, o)
+	checkSLOs(r, o)
+	checkTimeouts(r, o)
+	checkRetries(r, o)
+	checkUpstreamTokens(r, o)
+	checkTokenPrices(r, o)
+	checkBackends(r, o)
+	checkTuning(r, o)
+	checkSpill(r, o)
+	checkNoInspect(r, o)
+	checkRedis(ctx, r, o, probe)
+	checkLimits(r, o)
+	checkConnections(r, o)
+	checkReadiness(r, o)
+	checkRequestBody(r, o)
+	checkCanary(r, o)
+	checkAdmin(r, o)
+	checkMetricsAuth(r, o)
+	checkAPIKeys(r, o)
+	return r
+}
+
+func checkListen(r *report, addr string) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		r.fail("listen", "invalid address %q: %v", addr, err)
+		return
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			r.fail("listen", "invalid port %q in %q", port, addr)
+			return
+		}
+	}
+	r.ok("listen", "%s", addr)
+}
+
+func checkDebugAddr(r *report, o *options) {
+	if o.debugAddr == "" {
+		r.ok("debug", "no debug listener")
+		return
+	}
+	host, _, err := net.SplitHostPort(o.debugAddr)
+	if err != nil {
+		r.fail("debug", "invalid -debug-addr %q: %v", o.debugAddr, err)
+		return
+	}
+	if o.debugAddr == o.listenAddr {
+		r.fail("debug", "-debug-addr must differ from -listen %q, or profiles would be public", o.listenAddr)
+		return
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		r.warn("debug", "-debug-addr %s is reachable beyond this host; profiles reveal memory contents", o.debugAddr)
+		return
+	}
+	r.ok("debug", "pprof on %s", o.debugAddr)
+}
+
+// probeClient is the client checkUpstream probes with, dialing and trusting
+// what the proxy's transport would.
+func probeClient(o *options) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = proxy.UpstreamDialContext(&net.Dialer{Timeout: 30 * time.Second})
+	if cfg, err := o.upstreamTLSConfig(); err == nil && cfg != nil {
+		tr.TLSClientConfig = cfg
+	}
+	return &http.Client{Transport: tr}
+}
+
+func checkUpstream(ctx context.Context, r *report, raw, prefix string, client *http.Client, probe bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		r.fail("upstream", "invalid URL %q: %v", raw, err)
+		return
+	}
+	if u.Scheme == "unix" {
+		if u, err = proxy.ParseUpstream(raw); err != nil {
+			r.fail("upstream", "invalid URL %q: %v", raw, err)
+			return
+		}
+		socket, _ := proxy.SocketPath(u)
+		if fi, err := os.Stat(socket); err != nil || fi.Mode()&os.ModeSocket == 0 {
+			r.warn("upstream", "%s is not a socket yet; is Ollama running?", socket)
+			return
+		}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		r.fail("upstream", "unsupported scheme %q in %q (want http, https or unix)", u.Scheme, raw)
+		return
+	}
+	if u.Hostname() == "" {
+		r.fail("upstream", "no host in %q", raw)
+		return
+	}
+	if _, unix := proxy.SocketPath(u); !unix && net.ParseIP(u.Hostname()) == nil {
+		if _, err := net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+			r.warn("upstream", "host %q does not resolve: %v", u.Hostname(), err)
+			return
+		}
+	}
+	if strings.ContainsAny(prefix, "?#") {
+		r.fail("upstream", "-upstream-path-prefix %q must be a path, without query or fragment", prefix)
+		return
+	}
+	if u = u.JoinPath(prefix); strings.HasSuffix(strings.TrimRight(u.Path, "/"), "/api") {
+		r.warn("upstream", "upstream path %q ends in /api, so requests go to %s/api/...; leave /api out", u.Path, strings.TrimRight(u.Path, "/"))
+	}
+	if !probe {
+		r.ok("upstream", "%s", proxy.DescribeUpstream(u))
+		return
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(probeCtx, http.MethodGet, u.JoinPath("/api/version").String(), nil)
+	if err != nil {
+		r.fail("upstream", "build probe request: %v", err)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		r.fail("upstream", "probe %s: %v", proxy.DescribeUpstream(u), err)
+		return
+	}
+	defer resp.Body.Close()
+	var v struct {
+		Version string `json:"version"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&v) != nil {
+		r.fail("upstream", "probe %s: /api/version returned %d, not an Ollama version response", proxy.DescribeUpstream(u), resp.StatusCode)
+		return
+	}
+	r.ok("upstream", "%s reachable, ollama %s", proxy.DescribeUpstream(u), v.Version)
+}
+
+// checkWritableFile verifies that path can be created or opened for writing:
+// it must not be a directory, and its parent must be a writable directory
+// or not exist yet (it is created at startup). Problems are reported at
+// severity.
+func checkWritableFile(r *report, check, path, severity string) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		r.add(check, severity, "%s is a directory", path)
+		return
+	}
+	dir := filepath.Dir(path)
+	fi, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		r.ok(check, "%s (directory %s will be created)", path, dir)
+		return
+	case err != nil:
+		r.add(check, severity, "stat %s: %v", dir, err)
+		return
+	case !fi.IsDir():
+		r.add(check, severity, "parent %s of %s is not a directory", dir, path)
+		return
+	}
+	f, err := os.CreateTemp(dir, ".preflight-*")
+	if err != nil {
+		r.add(check, severity, "directory %s is not writable: %v", dir, err)
+		return
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	r.ok(check, "%s", path)
+}
+
+func checkStatic(r *report, dir string) {
+	if dir == "" {
+		r.ok("static", "serving the built-in info page")
+		return
+	}
+	fi, err := os.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		r.fail("static", "%s is not a directory", dir)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+		r.warn("static", "%s has no index.html", dir)
+		return
+	}
+	r.ok("static", "%s", dir)
+}
+
+func checkApdex(r *report, o *options) {
+	if o.apdexTarget < 0 {
+		r.fail("apdex", "-apdex-target must not be negative, got %s", o.apdexTarget)
+		return
+	}
+	targets, err := proxy.ParseDurationMap(o.apdexRaw)
+	if err != nil {
+		r.fail("apdex", "invalid -apdex-targets: %v", err)
+		return
+	}
+	bad := false
+	for class, d := range targets {
+		switch class {
+		case "generate", "chat", "embed", "other":
+		default:
+			r.warn("apdex", "-apdex-targets class %q matches no endpoint (want generate, chat, embed or other)", class)
+			bad = true
+		}
+		if d <= 0 {
+			r.fail("apdex", "-apdex-targets %s=%s must be positive", class, d)
+			bad = true
+		}
+	}
+	if !bad {
+		r.ok("apdex", "target %s, %d override(s)", o.apdexTarget, len(targets))
+	}
+}
+
+func checkSLOs(r *report, o *options) {
+	if o.sloFile == "" {
+		return
+	}
+	targets, err := proxy.LoadSLOTargets(o.sloFile)
+	if err != nil {
+		r.fail("slo", "invalid -slo-file: %v", err)
+		return
+	}
+	if len(targets) == 0 {
+		r.warn("slo", "%s defines no SLO targets", o.sloFile)
+		return
+	}
+	names := make([]string, len(targets))
+	for i, t := range targets {
+		names[i] = t.Name
+	}
+	r.ok("slo", "%d target(s) from %s: %s", len(targets), o.sloFile, strings.Join(names, ", "))
+}
+
+func checkTimeouts(r *report, o *options) {
+	if o.headerTimeout < 0 {
+		r.fail("timeouts", "-upstream-response-header-timeout must not be negative, got %s", o.headerTimeout)
+		return
+	}
+	if o.nonStreamTO < 0 {
+		r.fail("timeouts", "-nonstream-timeout must not be negative, got %s", o.nonStreamTO)
+		return
+	}
+	if o.readTimeout < 0 {
+		r.fail("timeouts", "-request-read-timeout must not be negative, got %s", o.readTimeout)
+		return
+	}
+	for _, d := range []struct {
+		flag  string
+		value time.Duration
+	}{
+		{"-upstream-dial-timeout", o.dialTimeout},
+		{"-upstream-tls-handshake-timeout", o.tlsTimeout},
+		{"-upstream-idle-conn-timeout", o.idleConnTimeout},
+	} {
+		if d.value < 0 {
+			r.fail("timeouts", "%s must not be negative, got %s", d.flag, d.value)
+			return
+		}
+	}
+	if o.maxIdlePerHost < 0 {
+		r.fail("timeouts", "-upstream-max-idle-conns-per-host must not be negative, got %d", o.maxIdlePerHost)
+		return
+	}
+	overrides, err := proxy.ParseDurationMap(o.headerTimeoutRaw)
+	if err != nil {
+		r.fail("timeouts", "invalid -upstream-response-header-timeouts: %v", err)
+		return
+	}
+	bad := false
+	for class, d := range overrides {
+		switch class {
+		case "generate", "chat", "embed", "other":
+		default:
+			r.warn("timeouts", "-upstream-response-header-timeouts class %q matches no endpoint (want generate, chat, embed or other)", class)
+			bad = true
+		}
+		if d < 0 {
+			r.fail("timeouts", "-upstream-response-header-timeouts %s=%s must not be negative", class, d)
+			bad = true
+		}
+	}
+	if bad {
+		return
+	}
+	if o.headerTimeout > 0 && o.headerTimeout < 10*time.Second {
+		r.warn("timeouts", "-upstream-response-header-timeout %s may cut off slow prompt evaluation", o.headerTimeout)
+		return
+	}
+	r.ok("timeouts", "response headers within %s, %d override(s), non-streaming responses within %s", o.headerTimeout, len(overrides), o.nonStreamTO)
+}
+
+// maxSaneRetries is the most -upstream-retries that passes without a
+// warning; more hold a client for minutes against an upstream that is down.
+const maxSaneRetries = 10
+
+// checkRetries rejects negative retry settings and warns about retries that
+// would hammer a restarting upstream or hold clients for too long.
+func checkRetries(r *report, o *options) {
+	if o.retries < 0 || o.retryBackoff < 0 {
+		r.fail("retries", "-upstream-retries and -upstream-retry-backoff must not be negative")
+		return
+	}
+	switch {
+	case o.retries == 0:
+		r.ok("retries", "failed upstream requests are not retried")
+	case o.retries > maxSaneRetries:
+		r.warn("retries", "-upstream-retries %d is above %d; a client may wait minutes for an upstream that is down", o.retries, maxSaneRetries)
+	case o.retryBackoff == 0:
+		r.warn("retries", "-upstream-retry-backoff 0 retries at once, before a restarting upstream is back")
+	default:
+		r.ok("retries", "up to %d retries, %s apart and doubling", o.retries, o.retryBackoff)
+	}
+}
+
+// checkUpstreamTokens reports which hosts have a token, never the tokens,
+// and which get the client's own Authorization.
+func checkUpstreamTokens(r *report, o *options) {
+	for _, host := range splitList(o.authPass) {
+		if strings.Contains(host, "/") {
+			r.fail("upstream-tokens", "-upstream-auth-passthrough entry %q must be a host or host:port, not a URL", host)
+			return
+		}
+	}
+	if o.authPass != "" {
+		r.ok("upstream-tokens", "client Authorization passed to %s", strings.Join(splitList(o.authPass), ", "))
+	}
+	if o.upTokensRaw == "" {
+		return
+	}
+	tokens, err := proxy.ParseStringMap(o.upTokensRaw)
+	if err != nil {
+		r.fail("upstream-tokens", "invalid -upstream-tokens: %v", err)
+		return
+	}
+	hosts := make([]string, 0, len(tokens))
+	for host := range tokens {
+		if strings.Contains(host, "/") {
+			r.fail("upstream-tokens", "-upstream-tokens key %q must be a host or host:port, not a URL", host)
+			return
+		}
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	r.ok("upstream-tokens", "bearer tokens for %s", strings.Join(hosts, ", "))
+}
+
+func checkTokenPrices(r *report, o *options) {
+	if o.pricesRaw == "" {
+		return
+	}
+	prices, err := proxy.ParseTokenPrices(o.pricesRaw)
+	if err != nil {
+		r.fail("token-prices", "invalid -token-prices: %v", err)
+		return
+	}
+	for key := range prices {
+		if strings.Contains(key, "://") {
+			r.fail("token-prices", "-token-prices key %q must start with a host or host:port, not a URL", key)
+			return
+		}
+	}
+	r.ok("token-prices", "%d price entries", len(prices))
+}
+
+func checkBackends(r *report, o *options) {
+	if o.backendsRaw == "" {
+		if o.everywhere != "" {
+			r.warn("backends", "-affinity-everywhere has no effect without -backends")
+		}
+		return
+	}
+	backends, err := proxy.ParseBackends(o.backendsRaw)
+	if err != nil {
+		r.fail("backends", "invalid -backends: %v", err)
+		return
+	}
+	if o.backendPoll <= 0 {
+		r.fail("backends", "-backend-health-interval must be positive, got %s", o.backendPoll)
+		return
+	}
+	hosts := make([]string, len(backends))
+	for i, u := range backends {
+		hosts[i] = u.Host
+	}
+	if len(backends) == 1 {
+		r.warn("backends", "only one backend (%s): nothing to spill over to when it is down", hosts[0])
+		return
+	}
+	r.ok("backends", "%d backends with model affinity: %s", len(backends), strings.Join(hosts, ", "))
+}
+
+func checkNoInspect(r *report, o *options) {
+	paths := splitList(o.noInspect)
+	if len(paths) == 0 {
+		return
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			r.fail("no_inspect", "-no-inspect-endpoints entry %q is not a path (want e.g. /api/chat)", p)
+			return
+		}
+	}
+	r.ok("no_inspect", "relayed without inspection: %s", strings.Join(paths, ", "))
+}
+
+func checkSpill(r *report, o *options) {
+	if o.spillAbove < 0 || o.spillMax < 0 {
+		r.fail("spill", "-spill-threshold-bytes and -spill-max-bytes must not be negative")
+		return
+	}
+	if o.spillAbove == 0 {
+		r.ok("spill", "non-stream responses buffered in memory")
+		return
+	}
+	dir := o.spillDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	f, err := os.CreateTemp(dir, "ollama-proxy-preflight-*")
+	if err != nil {
+		r.fail("spill", "cannot create files in %s: %v", dir, err)
+		return
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	if o.spillMax > 0 && o.spillMax < o.spillAbove {
+		r.warn("spill", "-spill-max-bytes %d is below -spill-threshold-bytes %d, so every spilled response is refused", o.spillMax, o.spillAbove)
+		return
+	}
+	r.ok("spill", "responses over %d bytes spill to %s", o.spillAbove, dir)
+}
+
+// metricsNamespaceRE matches the metric name prefixes Prometheus accepts.
+var metricsNamespaceRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func checkTuning(r *report, o *options) {
+	bad := false
+	if !metricsNamespaceRE.MatchString(o.metricsNS) {
+		r.fail("metrics", "-metrics-namespace %q is not a valid metric name prefix", o.metricsNS)
+		bad = true
+	}
+	if o.bucketsRaw != "" {
+		if _, err := proxy.ParseBuckets(o.bucketsRaw); err != nil {
+			r.fail("metrics", "-duration-buckets: %v", err)
+			bad = true
+		}
+	}
+	if o.maxLabelLen < 0 || (o.maxLabelLen > 0 && o.maxLabelLen < proxy.MinLabelLength) {
+		r.fail("metrics", "-max-label-length must be 0 or at least %d, got %d", proxy.MinLabelLength, o.maxLabelLen)
+		bad = true
+	}
+	if _, err := proxy.ParseEndpoints(o.extraRoutes); err != nil {
+		r.fail("metrics", "-extra-endpoints: %v", err)
+		bad = true
+	}
+	if !slices.Contains(proxy.DurationModes, o.durationMode) {
+		r.fail("metrics", "-duration-mode %q is not one of %s", o.durationMode, strings.Join(proxy.DurationModes, ", "))
+		bad = true
+	}
+	if !slices.Contains(proxy.ModelLabelModes, o.modelMode) {
+		r.fail("metrics", "-model-label-mode %q is not one of %s", o.modelMode, strings.Join(proxy.ModelLabelModes, ", "))
+		bad = true
+	}
+	if o.compressMin < 0 {
+		r.fail("compression", "-compress-min-bytes must not be negative, got %d", o.compressMin)
+		bad = true
+	}
+	if o.latencyWin < 0 {
+		r.fail("stats", "-stats-latency-window must not be negative, got %s", o.latencyWin)
+		bad = true
+	}
+	if o.metaTTL < 0 {
+		r.fail("cache", "-metadata-cache-ttl must not be negative, got %s", o.metaTTL)
+		bad = true
+	}
+	if o.showTTL < 0 {
+		r.fail("cache", "-show-cache-ttl must not be negative, got %s", o.showTTL)
+		bad = true
+	}
+	if o.embedTTL < 0 {
+		r.fail("cache", "-embed-cache-ttl must not be negative, got %s", o.embedTTL)
+		bad = true
+	}
+	if o.dupRate < 0 || o.dupRate > 1 || o.dupSize <= 0 {
+		r.fail("duplicates", "-duplicate-sample-rate must be within 0-1 and -duplicate-track-size positive")
+		bad = true
+	}
+	if o.convHeader != "" && (strings.ContainsAny(o.convHeader, " :\t") || o.convTTL <= 0 || o.convMax <= 0) {
+		r.fail("conversations", "-conversation-header must be a header name, -conversation-ttl and -max-conversations positive")
+		bad = true
+	}
+	if o.requestIDHeader == "" || strings.ContainsAny(o.requestIDHeader, " :\t") {
+		r.fail("request-id", "-request-id-header must be a header name, got %q", o.requestIDHeader)
+		bad = true
+	}
+	if o.pinnedExempt != "" && !o.requirePinned {
+		r.warn("pinned", "-pinned-models-exempt has no effect without -require-pinned-models")
+	}
+	if o.unloadOverride != "" {
+		if d, err := time.ParseDuration(o.unloadOverride); err != nil || d == 0 {
+			r.fail("keep-alive", "-unload-keep-alive-override must be a non-zero duration such as 5m, got %q", o.unloadOverride)
+			bad = true
+		}
+	}
+	if o.summaryInt < 0 {
+		r.fail("summary", "-summary-interval must not be negative, got %s", o.summaryInt)
+		bad = true
+	}
+	if o.dedupWindow < 0 {
+		r.fail("log-dedup", "-log-dedup-window must not be negative, got %s", o.dedupWindow)
+		bad = true
+	}
+	if o.psInterval < 0 {
+		r.fail("ps", "-ps-scrape-interval must not be negative, got %s", o.psInterval)
+		bad = true
+	}
+	if o.tagsInterval < 0 {
+		r.fail("tags", "-tags-scrape-interval must not be negative, got %s", o.tagsInterval)
+		bad = true
+	}
+	if o.oomCooldown < 0 {
+		r.fail("oom", "-oom-cooldown must not be negative, got %s", o.oomCooldown)
+		bad = true
+	}
+	if o.negativeTTL < 0 {
+		r.fail("negative-cache", "-negative-cache-ttl must not be negative, got %s", o.negativeTTL)
+		bad = true
+	}
+	if o.contextWarn < 0 {
+		r.fail("context", "-context-warn-tokens must not be negative, got %d", o.contextWarn)
+		bad = true
+	}
+	switch o.ctxCheck {
+	case "off", proxy.ContextCheckWarn, proxy.ContextCheckReject:
+	default:
+		r.fail("context", "-context-check must be off, warn or reject, got %q", o.ctxCheck)
+		bad = true
+	}
+	if o.ctxMargin < 0 {
+		r.fail("context", "-context-overflow-margin must not be negative, got %g", o.ctxMargin)
+		bad = true
+	}
+	if o.shutdownTimeout < 0 {
+		r.fail("shutdown", "-shutdown-timeout must not be negative, got %s", o.shutdownTimeout)
+		bad = true
+	}
+	if o.mockUpstream && (o.mockComplTok <= 0 || o.mockTokPerSec < 0 || o.mockPromptTok < 0) {
+		r.fail("mock", "-mock-completion-tokens must be positive and -mock-prompt-tokens, -mock-tokens-per-second not negative")
+		bad = true
+	}
+	if !bad {
+		r.ok("tuning", "metrics, compression, cache and context settings valid")
+	}
+}
+
+func checkRedis(ctx context.Context, r *report, o *options, probe bool) {
+	opts, ok := o.redisOptions()
+	if !ok {
+		if o.redisUser != "" || o.redisPass != "" || o.redisDB != 0 || o.redisTLS || o.redisTLSNoV {
+			r.warn("redis", "Redis credentials/TLS/db are set but ignored without -redis-addr")
+			return
+		}
+		r.ok("redis", "not configured, shared state is per replica")
+		return
+	}
+	if _, _, err := net.SplitHostPort(o.redisAddr); err != nil {
+		r.fail("redis", "invalid -redis-addr %q: %v", o.redisAddr, err)
+		return
+	}
+	if o.redisDB < 0 {
+		r.fail("redis", "-redis-db must not be negative, got %d", o.redisDB)
+		return
+	}
+	if o.redisTLSNoV && !o.redisTLS {
+		r.warn("redis", "-redis-tls-insecure has no effect without -redis-tls")
+		return
+	}
+	if !probe {
+		r.ok("redis", "%s", o.redisAddr)
+		return
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	c := kv.NewRedis(opts)
+	defer c.Close()
+	if _, _, err := c.Get(probeCtx, "ollama-proxy:preflight"); err != nil {
+		r.fail("redis", "probe %s: %v", o.redisAddr, err)
+		return
+	}
+	r.ok("redis", "%s reachable", o.redisAddr)
+}
+
+func checkLimits(r *report, o *options) {
+	bad := false
+	if o.rateLimit < 0 {
+		r.fail("limits", "-rate-limit must not be negative, got %d", o.rateLimit)
+		bad = true
+	}
+	if o.rateLimit > 0 && o.rateWindow <= 0 {
+		r.fail("limits", "-rate-limit requires a positive -rate-limit-window, got %s", o.rateWindow)
+		bad = true
+	}
+	if o.tokenBudget < 0 {
+		r.fail("limits", "-token-budget must not be negative, got %d", o.tokenBudget)
+		bad = true
+	}
+	if o.tokenBudget > 0 && o.budgetWin <= 0 {
+		r.fail("limits", "-token-budget requires a positive -token-budget-window, got %s", o.budgetWin)
+		bad = true
+	}
+	if o.tokenBudget > 0 && o.quotaFlush <= 0 {
+		r.fail("limits", "-token-budget requires a positive -quota-flush-interval, got %s", o.quotaFlush)
+		bad = true
+	}
+	if o.tpmLimit < 0 || o.charsPerTok <= 0 {
+		r.fail("limits", "-tpm-limit must not be negative and -chars-per-token must be positive")
+		bad = true
+	}
+	if o.maxPerModel < 0 || o.queueTimeout < 0 {
+		r.fail("limits", "-max-concurrent-per-model and -queue-timeout must not be negative")
+		bad = true
+	}
+	if o.maxPerTenant < 0 {
+		r.fail("limits", "-max-concurrent-per-tenant must not be negative, got %d", o.maxPerTenant)
+		bad = true
+	}
+	if overrides, err := proxy.ParseIntMap(o.tenantRaw); err != nil {
+		r.fail("limits", "invalid -tenant-concurrency: %v", err)
+		bad = true
+	} else {
+		for tenant, n := range overrides {
+			if n < 0 {
+				r.fail("limits", "-tenant-concurrency for %q must not be negative, got %d", tenant, n)
+				bad = true
+			}
+		}
+	}
+	if o.queueTimeout > 0 && o.maxPerModel == 0 {
+		r.warn("limits", "-queue-timeout has no effect without -max-concurrent-per-model")
+		return
+	}
+	if bad {
+		return
+	}
+	if (o.rateLimit > 0 || o.tokenBudget > 0 || o.tpmLimit > 0) && o.redisAddr == "" {
+		r.ok("limits", "rate limit %d/%s, token budget %d/%s, %d TPM, enforced per replica",
+			o.rateLimit, o.rateWindow, o.tokenBudget, o.budgetWin, o.tpmLimit)
+		return
+	}
+	r.ok("limits", "rate limit %d/%s, token budget %d/%s, %d TPM", o.rateLimit, o.rateWindow, o.tokenBudget, o.budgetWin, o.tpmLimit)
+}
+
+func checkConnections(r *report, o *options) {
+	if o.maxConns < 0 || o.maxConnsPerIP < 0 {
+		r.fail("connections", "-max-connections and -max-connections-per-client must not be negative")
+		return
+	}
+	if _, err := connlimit.ParseCIDRs(splitList(o.trustedProxies)); err != nil {
+		r.fail("connections", "-trusted-proxies: %v", err)
+		return
+	}
+	if o.maxConns > 0 && o.maxConnsPerIP > o.maxConns {
+		r.warn("connections", "-max-connections-per-client %d is above -max-connections %d", o.maxConnsPerIP, o.maxConns)
+		return
+	}
+	r.ok("connections", "max %d total, %d per client (0 = unlimited)", o.maxConns, o.maxConnsPerIP)
+}
+
+func checkRequestBody(r *report, o *options) {
+	switch {
+	case o.maxRequestBytes < 0 || o.sniffBytes < 0:
+		r.fail("request_body", "-max-request-bytes and -request-sniff-bytes must not be negative, got %d and %d", o.maxRequestBytes, o.sniffBytes)
+	case o.maxRequestBytes == 0 && o.sniffBytes == 0:
+		r.warn("request_body", "-max-request-bytes and -request-sniff-bytes are 0, so one client can make the proxy hold a body of any size in memory")
+	case o.maxRequestBytes > 0 && o.maxRequestBytes < 1<<20:
+		r.warn("request_body", "-max-request-bytes %d is under 1 MiB, so most requests with images get 413", o.maxRequestBytes)
+	default:
+		r.ok("request_body", "request bodies over %d bytes get 413 and over %d bytes stream upstream (0 = never)", o.maxRequestBytes, o.sniffBytes)
+	}
+}
+
+func checkReadiness(r *report, o *options) {
+	if o.readyMaxInFlight < 0 || o.readyResumeInFlight < 0 || o.readyMaxWait < 0 || o.readyResumeWait < 0 {
+		r.fail("readiness", "-ready-max-inflight, -ready-max-queue-wait and their -ready-resume-* marks must not be negative")
+		return
+	}
+	if o.readyUpTimeout < 0 || o.readyUpCache < 0 {
+		r.fail("readiness", "-ready-upstream-timeout and -ready-upstream-cache must not be negative")
+		return
+	}
+	if o.readyMaxInFlight > 0 && o.readyResumeInFlight >= o.readyMaxInFlight {
+		r.fail("readiness", "-ready-resume-inflight %d must be below -ready-max-inflight %d", o.readyResumeInFlight, o.readyMaxInFlight)
+		return
+	}
+	if o.readyMaxWait > 0 && o.readyResumeWait >= o.readyMaxWait {
+		r.fail("readiness", "-ready-resume-queue-wait %s must be below -ready-max-queue-wait %s", o.readyResumeWait, o.readyMaxWait)
+		return
+	}
+	switch {
+	case o.readyResumeInFlight > 0 && o.readyMaxInFlight == 0, o.readyResumeWait > 0 && o.readyMaxWait == 0:
+		r.warn("readiness", "a -ready-resume-* mark has no effect without its -ready-max-* mark")
+	case o.readyMaxWait > 0 && o.maxPerModel == 0:
+		r.warn("readiness", "-ready-max-queue-wait has no effect without -max-concurrent-per-model")
+	case o.readyMaxInFlight == 0 && o.readyMaxWait == 0 && o.readyUpTimeout == 0:
+		r.ok("readiness", "/readyz only reports draining")
+	default:
+		r.ok("readiness", "not ready above %d in flight, %s queue wait or an upstream slower than %s (0 = off)",
+			o.readyMaxInFlight, o.readyMaxWait, o.readyUpTimeout)
+	}
+}
+
+func checkCanary(r *report, o *options) {
+	models := splitList(o.canaryModels)
+	if len(models) == 0 {
+		r.ok("canary", "disabled")
+		return
+	}
+	if o.canaryEvery <= 0 {
+		r.fail("canary", "-canary-models requires a positive -canary-interval, got %s", o.canaryEvery)
+		return
+	}
+	if o.canaryPredict <= 0 {
+		r.fail("canary", "-canary-num-predict must be positive, got %d", o.canaryPredict)
+		return
+	}
+	if o.canaryEvery < 5*time.Second {
+		r.warn("canary", "-canary-interval %s probes %d model(s) very often", o.canaryEvery, len(models))
+		return
+	}
+	r.ok("canary", "probing %d model(s) every %s", len(models), o.canaryEvery)
+}
+
+func checkAdmin(r *report, o *options) {
+	switch {
+	case o.adminToken == "":
+		r.ok("admin", "admin endpoints disabled (no -admin-token)")
+	case len(o.adminToken) < 16:
+		r.warn("admin", "-admin-token is only %d characters; use a long random token", len(o.adminToken))
+	default:
+		r.ok("admin", "admin endpoints enabled")
+	}
+}
+
+func checkMetricsAuth(r *report, o *options) {
+	if (o.metricsUser == "") != (o.metricsPass == "") {
+		r.fail("metrics_auth", "-metrics-username and -metrics-password must be set together")
+		return
+	}
+	auth, err := o.metricsAuth()
+	if err != nil {
+		r.fail("metrics_auth", "%v", err)
+		return
+	}
+	switch {
+	case auth.Token != "" && len(auth.Users) > 0:
+		r.fail("metrics_auth", "-metrics-token cannot be combined with basic auth users")
+	case auth.Token != "" && len(auth.Token) < 16:
+		r.warn("metrics_auth", "-metrics-token is only %d characters; use a long random token", len(auth.Token))
+	case auth.Token != "":
+		r.ok("metrics_auth", "/metrics requires a bearer token")
+	case len(auth.Users) > 0:
+		r.ok("metrics_auth", "/metrics requires basic auth, %d user(s)", len(auth.Users))
+	default:
+		r.ok("metrics_auth", "/metrics is open (no -metrics-token or -metrics-username)")
+	}
+}
+
+func checkAPIKeys(r *report, o *options) {
+	if o.apiKeysFile == "" {
+		r.ok("api_keys", "proxied requests need no API key (no -api-keys-file)")
+		return
+	}
+	keys, err := proxy.LoadAPIKeys(o.apiKeysFile)
+	if err != nil {
+		r.fail("api_keys", "invalid -api-keys-file: %v", err)
+		return
+	}
+	var short []string
+	for _, k := range keys {
+		if len(k.Key) < 16 {
+			short = append(short, k.Name)
+		}
+	}
+	if len(short) > 0 {
+		r.warn("api_keys", "short keys for %s; use long random keys", strings.Join(short, ", "))
+		return
+	}
+	r.ok("api_keys", "%d client(s) from %s", len(keys), o.apiKeysFile)
+}
+
+func checkUpstreamTLS(r *report, o *options) {
+	cfg, err := o.upstreamTLSConfig()
+	switch {
+	case err != nil:
+		r.fail("upstream_tls", "%v", err)
+		return
+	case cfg == nil:
+		r.ok("upstream_tls", "Go defaults (system CAs, no client certificate)")
+		return
+	case !strings.Contains(o.upstreamRaw, "https://") && !strings.Contains(o.backendsRaw, "https://"):
+		r.warn("upstream_tls", "-upstream-* TLS flags are set but no upstream or backend is https")
+		return
+	case cfg.InsecureSkipVerify:
+		r.warn("upstream_tls", "-upstream-insecure-skip-verify: upstream certificates are not verified")
+		return
+	}
+	r.ok("upstream_tls", "%s", o.describeUpstreamTLS())
+}
+
+func checkTLS(r *report, o *options) {
+	if (o.tlsCert == "") != (o.tlsKey == "") {
+		r.fail("tls", "-tls-cert and -tls-key must be set together")
+		return
+	}
+	if o.tlsCert == "" {
+		r.ok("tls", "plain HTTP (no -tls-cert)")
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(o.tlsCert, o.tlsKey)
+	if err != nil {
+		r.fail("tls", "cannot load -tls-cert/-tls-key: %v", err)
+		return
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		r.warn("tls", "%s expired on %s", o.tlsCert, cert.Leaf.NotAfter.UTC().Format(time.RFC3339))
+		return
+	}
+	r.ok("tls", "serving TLS 1.2+ with %s: %s", o.tlsCert, describeCert(&cert))
+}
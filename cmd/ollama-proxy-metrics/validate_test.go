@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testOptions parses args on a fresh flag set with db and log under a temp dir.
+func testOptions(t *testing.T, args ...string) *options {
+	t.Helper()
+	dir := t.TempDir()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o := registerFlags(fs)
+	base := []string{
+		"-upstream", "http://127.0.0.1:11434",
+		"-db", filepath.Join(dir, "db.sqlite"),
+		"-log", filepath.Join(dir, "logs", "proxy.log"),
+	}
+	if err := fs.Parse(append(base, args...)); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	return o
+}
+
+func findingsFor(r *report, check, severity string) []finding {
+	var out []finding
+	for _, f := range r.Findings {
+		if f.Check == check && f.Severity == severity {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func TestPreflight_DefaultsPass(t *testing.T) {
+	r := preflight(context.Background(), testOptions(t), false)
+	if !r.passed(true) {
+		t.Fatalf("expected defaults to pass strictly, got %+v", r.Findings)
+	}
+}
+
+func TestPreflight_Errors(t *testing.T) {
+	cases := []struct {
+		name  string
+		args  []string
+		check string
+	}{
+		{"bad listen", []string{"-listen", "8080"}, "listen"},
+		{"tls cert without key", []string{"-tls-cert", "/etc/ssl/proxy.pem"}, "tls"},
+		{"missing tls files", []string{"-tls-cert", "/nonexistent/cert.pem", "-tls-key", "/nonexistent/key.pem"}, "tls"},
+		{"bad scheme", []string{"-upstream", "ftp://ollama:11434"}, "upstream"},
+		{"no host", []string{"-upstream", "http://"}, "upstream"},
+		{"unix upstream without a path", []string{"-upstream", "unix://ollama.sock"}, "upstream"},
+		{"missing upstream ca", []string{"-upstream", "https://ollama:443", "-upstream-ca-file", "/nonexistent/ca.pem"}, "upstream_tls"},
+		{"upstream ca not pem", []string{"-upstream", "https://ollama:443", "-upstream-ca-file", "/etc/passwd"}, "upstream_tls"},
+		{"upstream client cert without key", []string{"-upstream", "https://ollama:443", "-upstream-client-cert", "/etc/ssl/client.pem"}, "upstream_tls"},
+		{"path prefix with query", []string{"-upstream-path-prefix", "/llm?x=1"}, "upstream"},
+		{"missing static", []string{"-static", "/does/not/exist"}, "static"},
+		{"bad apdex", []string{"-apdex-targets", "chat=fast"}, "apdex"},
+		{"missing slo file", []string{"-slo-file", "/nonexistent/slo.json"}, "slo"},
+		{"rate limit without window", []string{"-rate-limit", "5", "-rate-limit-window", "0"}, "limits"},
+		{"budget without flush", []string{"-token-budget", "5", "-quota-flush-interval", "0"}, "limits"},
+		{"negative tenant concurrency", []string{"-max-concurrent-per-tenant", "-1"}, "limits"},
+		{"bad tenant override", []string{"-tenant-concurrency", "batch=two"}, "limits"},
+		{"negative tenant override", []string{"-tenant-concurrency", "batch=-1"}, "limits"},
+		{"mock without tokens", []string{"-mock-upstream", "-mock-completion-tokens", "0"}, "mock"},
+		{"canary without interval", []string{"-canary-models", "llama3", "-canary-interval", "0"}, "canary"},
+		{"bad redis addr", []string{"-redis-addr", "redis"}, "redis"},
+		{"bad header timeout", []string{"-upstream-response-header-timeouts", "chat=-1s"}, "timeouts"},
+		{"negative nonstream timeout", []string{"-nonstream-timeout", "-1s"}, "timeouts"},
+		{"negative upstream retries", []string{"-upstream-retries", "-1"}, "retries"},
+		{"negative embed cache ttl", []string{"-embed-cache-ttl", "-1m"}, "cache"},
+		{"negative request read timeout", []string{"-request-read-timeout", "-1s"}, "timeouts"},
+		{"no-inspect entry not a path", []string{"-no-inspect-endpoints", "/api/chat,api/generate"}, "no_inspect"},
+		{"negative dial timeout", []string{"-upstream-dial-timeout", "-1s"}, "timeouts"},
+		{"negative max idle conns", []string{"-upstream-max-idle-conns-per-host", "-1"}, "timeouts"},
+		{"bad metrics namespace", []string{"-metrics-namespace", "ollama-proxy"}, "metrics"},
+		{"unordered duration buckets", []string{"-duration-buckets", "1,30,10"}, "metrics"},
+		{"extra endpoint not a path", []string{"-extra-endpoints", "api/experimental/{id}"}, "metrics"},
+		{"unknown model label mode", []string{"-model-label-mode", "short"}, "metrics"},
+		{"unknown duration mode", []string{"-duration-mode", "ttfb"}, "metrics"},
+		{"negative max request bytes", []string{"-max-request-bytes", "-1"}, "request_body"},
+		{"negative request sniff bytes", []string{"-request-sniff-bytes", "-1"}, "request_body"},
+		{"duplicate rate over 1", []string{"-duplicate-sample-rate", "1.5"}, "duplicates"},
+		{"bad conversation header", []string{"-conversation-header", "X Conv"}, "conversations"},
+		{"bad request id header", []string{"-request-id-header", "X-Request Id"}, "request-id"},
+		{"bad log format", []string{"-log-format", "logfmt"}, "log-format"},
+		{"access log is a directory", []string{"-access-log", os.TempDir()}, "access-log"},
+		{"zero unload override", []string{"-unload-keep-alive-override", "0s"}, "keep-alive"},
+		{"upstream token without host", []string{"-upstream-tokens", "=secret"}, "upstream-tokens"},
+		{"upstream token keyed by URL", []string{"-upstream-tokens", "https://ollama.com=secret"}, "upstream-tokens"},
+		{"passthrough host as URL", []string{"-upstream-auth-passthrough", "http://gpu-a:11434"}, "upstream-tokens"},
+		{"bad token price", []string{"-token-prices", "ollama.com=free"}, "token-prices"},
+		{"token price keyed by URL", []string{"-token-prices", "https://ollama.com=1"}, "token-prices"},
+		{"negative summary interval", []string{"-summary-interval", "-1m"}, "summary"},
+		{"negative log dedup window", []string{"-log-dedup-window", "-1s"}, "log-dedup"},
+		{"negative ps scrape interval", []string{"-ps-scrape-interval", "-1s"}, "ps"},
+		{"negative tags scrape interval", []string{"-tags-scrape-interval", "-1s"}, "tags"},
+		{"metrics user without password", []string{"-metrics-username", "prom"}, "metrics_auth"},
+		{"metrics token and basic auth", []string{"-metrics-token", "0123456789abcdef", "-metrics-htpasswd", "prom:secret"}, "metrics_auth"},
+		{"bcrypt metrics htpasswd", []string{"-metrics-htpasswd", "prom:$2y$05$abcdefghijklmnopqrstuv"}, "metrics_auth"},
+		{"short max label length", []string{"-max-label-length", "8"}, "metrics"},
+		{"negative latency window", []string{"-stats-latency-window", "-1m"}, "stats"},
+		{"missing api keys file", []string{"-api-keys-file", "/nonexistent/keys"}, "api_keys"},
+		{"negative oom cooldown", []string{"-oom-cooldown", "-1s"}, "oom"},
+		{"negative negative-cache ttl", []string{"-negative-cache-ttl", "-1s"}, "negative-cache"},
+		{"negative shutdown timeout", []string{"-shutdown-timeout", "-1s"}, "shutdown"},
+		{"bad context check", []string{"-context-check", "enforce"}, "context"},
+		{"bad backend", []string{"-backends", "http://a:11434,ftp://b"}, "backends"},
+		{"duplicate backend", []string{"-backends", "http://a:11434,http://a:11434/"}, "backends"},
+		{"negative spill threshold", []string{"-spill-threshold-bytes", "-1"}, "spill"},
+		{"missing spill dir", []string{"-spill-threshold-bytes", "1048576", "-spill-dir", "/nonexistent/spill"}, "spill"},
+		{"bad trusted proxy", []string{"-max-connections-per-client", "4", "-trusted-proxies", "10.0.0.0/33"}, "connections"},
+		{"ready resume above max", []string{"-ready-max-inflight", "8", "-ready-resume-inflight", "8"}, "readiness"},
+		{"negative upstream probe timeout", []string{"-ready-upstream-timeout", "-1s"}, "readiness"},
+		{"invalid debug addr", []string{"-debug-addr", "6060"}, "debug"},
+		{"debug addr is the listener", []string{"-listen", ":9090", "-debug-addr", ":9090"}, "debug"},
+		{"negative ready queue wait", []string{"-ready-max-queue-wait", "-1s"}, "readiness"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := preflight(context.Background(), testOptions(t, tc.args...), false)
+			if len(findingsFor(r, tc.check, severityError)) == 0 {
+				t.Errorf("expected %s error, got %+v", tc.check, r.Findings)
+			}
+			if r.passed(false) {
+				t.Error("expected preflight to fail")
+			}
+		})
+	}
+}
+
+func TestPreflight_UpstreamPathEndingInAPI(t *testing.T) {
+	r := preflight(context.Background(), testOptions(t, "-upstream", "http://127.0.0.1:11434/llm", "-upstream-path-prefix", "/ollama/api/"), false)
+	if len(findingsFor(r, "upstream", severityWarning)) != 1 {
+		t.Errorf("expected an upstream warning, got %+v", r.Findings)
+	}
+}
+
+func TestPreflight_DBPathIsDirectory(t *testing.T) {
+	o := testOptions(t)
+	o.dbPath = t.TempDir()
+	r := preflight(context.Background(), o, false)
+	if len(findingsFor(r, "db", severityError)) != 1 {
+		t.Errorf("expected db error, got %+v", r.Findings)
+	}
+}
+
+func TestPreflight_WarningsFailOnlyWhenStrict(t *testing.T) {
+	r := preflight(context.Background(), testOptions(t, "-redis-password", "x", "-apdex-targets", "chatt=1s", "-queue-timeout", "5s", "-admin-token", "short",
+		"-pinned-models-exempt", "scratch", "-upstream-retries", "50"), false)
+	if r.Warnings != 6 || r.Errors != 0 {
+		t.Fatalf("expected 6 warnings and no errors, got %+v", r.Findings)
+	}
+	if !r.passed(false) {
+		t.Error("expected warnings to pass without -strict-startup")
+	}
+	if r.passed(true) {
+		t.Error("expected warnings to fail with -strict-startup")
+	}
+}
+
+func TestPreflight_ProbeUpstream(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/version" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"version":"0.5.1"}`))
+	}))
+	defer up.Close()
+
+	r := preflight(context.Background(), testOptions(t, "-upstream", up.URL), true)
+	if oks := findingsFor(r, "upstream", severityOK); len(oks) != 1 {
+		t.Fatalf("expected upstream ok, got %+v", r.Findings)
+	}
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	r = preflight(context.Background(), testOptions(t, "-upstream", down.URL), true)
+	if len(findingsFor(r, "upstream", severityError)) != 1 {
+		t.Errorf("expected probe error for closed upstream, got %+v", r.Findings)
+	}
+}
+
+func TestPreflight_ProbeUnixUpstream(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ops") // socket paths are short
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "ollama.sock")
+	r := preflight(context.Background(), testOptions(t, "-upstream", "unix://"+sock), true)
+	if len(findingsFor(r, "upstream", severityWarning)) != 1 {
+		t.Errorf("expected a warning before the socket exists, got %+v", r.Findings)
+	}
+
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version":"0.5.1"}`))
+	}))
+	up.Listener = ln
+	up.Start()
+	defer up.Close()
+	r = preflight(context.Background(), testOptions(t, "-upstream", "unix://"+sock), true)
+	if oks := findingsFor(r, "upstream", severityOK); len(oks) != 1 || !strings.Contains(oks[0].Message, "unix://"+sock) {
+		t.Errorf("expected the socket probed, got %+v", r.Findings)
+	}
+}
+
+func TestReport_WriteJSON(t *testing.T) {
+	r := &report{}
+	r.ok("a", "fine")
+	r.fail("b", "broken %d", 1)
+	var buf bytes.Buffer
+	if err := r.write(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var got report
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("report is not JSON: %v\n%s", err, buf.String())
+	}
+	if got.Errors != 1 || len(got.Findings) != 2 || got.Findings[1].Message != "broken 1" {
+		t.Errorf("unexpected round trip: %+v", got)
+	}
+}
@@ -21,12 +21,31 @@ func New(store *db.Store) *Handler {
 
 // Register mounts all API routes under mux at the given prefix (e.g. "/admin/api").
 func (h *Handler) Register(mux *http.ServeMux, prefix string) {
-	mux.HandleFunc(prefix+"/summary", corsMiddleware(h.handleSummary))
-	mux.HandleFunc(prefix+"/requests", corsMiddleware(h.handleRequests))
-	mux.HandleFunc(prefix+"/daily", corsMiddleware(h.handleDaily))
-	mux.HandleFunc(prefix+"/sessions", corsMiddleware(h.handleSessions))
-	mux.HandleFunc(prefix+"/models", corsMiddleware(h.handleModels))
-	mux.HandleFunc(prefix+"/cleanup", corsMiddleware(h.handleCleanup))
+	for _, rt := range h.Routes(prefix) {
+		mux.HandleFunc(rt.Path, rt.Handler)
+	}
+}
+
+// Route is an endpoint Register mounts. Methods are the ones it answers,
+// OPTIONS for CORS preflight included.
+type Route struct {
+	Path    string
+	Methods []string
+	Handler http.HandlerFunc
+}
+
+// Routes returns the routes Register mounts under prefix. h is not used
+// until a route is served.
+func (h *Handler) Routes(prefix string) []Route {
+	get := []string{http.MethodGet, http.MethodOptions}
+	return []Route{
+		{prefix + "/summary", get, corsMiddleware(h.handleSummary)},
+		{prefix + "/requests", get, corsMiddleware(h.handleRequests)},
+		{prefix + "/daily", get, corsMiddleware(h.handleDaily)},
+		{prefix + "/sessions", get, corsMiddleware(h.handleSessions)},
+		{prefix + "/models", get, corsMiddleware(h.handleModels)},
+		{prefix + "/cleanup", []string{http.MethodPost, http.MethodOptions}, corsMiddleware(h.handleCleanup)},
+	}
 }
 
 // corsMiddleware adds CORS headers to support the React dev-server.
@@ -0,0 +1,146 @@
+// Package connlimit caps the TCP connections a listener keeps open, in total
+// and per client IP, so one misbehaving client cannot exhaust the process's
+// file descriptors.
+package connlimit
+
+import (
+	"net"
+	"sync"
+)
+
+// Rejection reasons passed to Options.OnReject.
+const (
+	ReasonPerClient = "per_client"
+	ReasonGlobal    = "global"
+)
+
+// Options configures a Listener. Zero limits are unlimited.
+type Options struct {
+	// PerClient is the most connections one peer IP may hold open.
+	PerClient int
+	// Max is the most connections held open in total.
+	Max int
+	// Exempt lists peers not subject to PerClient, typically reverse proxies
+	// that multiplex many clients; they still count towards Max.
+	Exempt []*net.IPNet
+	// OnReject is called, if set, for every connection closed on accept.
+	OnReject func(ip, reason string)
+}
+
+// Listener wraps a net.Listener and closes connections over the limits as
+// soon as they are accepted.
+type Listener struct {
+	net.Listener
+	opts Options
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// New wraps ln.
+func New(ln net.Listener, opts Options) *Listener {
+	return &Listener{Listener: ln, opts: opts, perIP: map[string]int{}}
+}
+
+// Accept returns the next connection within the limits.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := peerIP(c.RemoteAddr())
+		if reason := l.acquire(ip); reason != "" {
+			if l.opts.OnReject != nil {
+				l.opts.OnReject(ip, reason)
+			}
+			_ = c.Close()
+			continue
+		}
+		return &conn{Conn: c, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// Open returns the number of connections currently held open.
+func (l *Listener) Open() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total
+}
+
+// acquire counts a new connection from ip, or returns why it is refused.
+func (l *Listener) acquire(ip string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opts.Max > 0 && l.total >= l.opts.Max {
+		return ReasonGlobal
+	}
+	if l.opts.PerClient > 0 && !l.exempt(ip) && l.perIP[ip] >= l.opts.PerClient {
+		return ReasonPerClient
+	}
+	l.total++
+	l.perIP[ip]++
+	return ""
+}
+
+func (l *Listener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+func (l *Listener) exempt(ip string) bool {
+	parsed := net.ParseIP(ip)
+	for _, n := range l.opts.Exempt {
+		if parsed != nil && n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func peerIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// conn releases its slot exactly once, however often it is closed.
+type conn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *conn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// ParseCIDRs parses a list of CIDRs or bare IPs (taken as single hosts).
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, s := range list {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
@@ -0,0 +1,135 @@
+package connlimit
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dialN opens n connections to ln and returns them.
+func dialN(t *testing.T, ln net.Listener, n int) []net.Conn {
+	t.Helper()
+	var out []net.Conn
+	for range n {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		out = append(out, c)
+	}
+	return out
+}
+
+// closedByPeer reports whether the server side closed c.
+func closedByPeer(c net.Conn) bool {
+	_ = c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err := c.Read(make([]byte, 1))
+	return err == io.EOF
+}
+
+type rejections struct {
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (r *rejections) add(_, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reasons = append(r.reasons, reason)
+}
+
+func (r *rejections) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.reasons...)
+}
+
+func serve(t *testing.T, opts Options) *Listener {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(inner, opts)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, c) // hold until the client hangs up
+				_ = c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func waitOpen(t *testing.T, l *Listener, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for l.Open() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d open connections, got %d", want, l.Open())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestListener_PerClient(t *testing.T) {
+	var rej rejections
+	l := serve(t, Options{PerClient: 2, OnReject: rej.add})
+	conns := dialN(t, l, 3)
+	if !closedByPeer(conns[2]) {
+		t.Fatal("expected the third connection closed")
+	}
+	if got := rej.list(); len(got) != 1 || got[0] != ReasonPerClient {
+		t.Errorf("expected one per_client rejection, got %v", got)
+	}
+	waitOpen(t, l, 2)
+
+	_ = conns[0].Close()
+	waitOpen(t, l, 1)
+	if c := dialN(t, l, 1)[0]; closedByPeer(c) {
+		t.Error("expected a slot freed by closing a connection")
+	}
+}
+
+func TestListener_GlobalAndExempt(t *testing.T) {
+	exempt, err := ParseCIDRs([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rej rejections
+	l := serve(t, Options{PerClient: 1, Max: 3, Exempt: exempt, OnReject: rej.add})
+	conns := dialN(t, l, 4)
+	for i, c := range conns[:3] {
+		if closedByPeer(c) {
+			t.Fatalf("connection %d: expected an exempt peer to pass the per-client limit", i)
+		}
+	}
+	if !closedByPeer(conns[3]) {
+		t.Fatal("expected the fourth connection closed")
+	}
+	if got := rej.list(); len(got) != 1 || got[0] != ReasonGlobal {
+		t.Errorf("expected the global limit to still apply, got %v", got)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.5", "::1"})
+	if err != nil || len(nets) != 3 {
+		t.Fatalf("got %v %v", nets, err)
+	}
+	if !nets[1].Contains(net.ParseIP("192.168.1.5")) || nets[1].Contains(net.ParseIP("192.168.1.6")) {
+		t.Error("expected a bare IP to match only itself")
+	}
+	if _, err := ParseCIDRs([]string{"nope"}); err == nil {
+		t.Error("expected an error for an invalid entry")
+	}
+}
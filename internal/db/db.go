@@ -3,6 +3,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -31,7 +32,9 @@ CREATE TABLE IF NOT EXISTS requests (
     client_ip         TEXT    NOT NULL DEFAULT '',
     user_agent        TEXT    NOT NULL DEFAULT '',
     prompt_text       TEXT    NOT NULL DEFAULT '',
-    response_text     TEXT    NOT NULL DEFAULT ''
+    response_text     TEXT    NOT NULL DEFAULT '',
+    served_by         TEXT    NOT NULL DEFAULT '',
+    upstream          TEXT    NOT NULL DEFAULT ''
 );
 
 CREATE INDEX IF NOT EXISTS idx_requests_timestamp  ON requests(timestamp);
@@ -62,6 +65,8 @@ func Open(path string) (*Store, error) {
 	for _, col := range []string{
 		`ALTER TABLE requests ADD COLUMN prompt_text   TEXT NOT NULL DEFAULT ''`,
 		`ALTER TABLE requests ADD COLUMN response_text TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE requests ADD COLUMN served_by     TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE requests ADD COLUMN upstream      TEXT NOT NULL DEFAULT ''`,
 	} {
 		_, _ = db.Exec(col)
 	}
@@ -92,8 +97,14 @@ type RequestRecord struct {
 	UserAgent        string
 	PromptText       string
 	ResponseText     string
+	ServedBy         string // the proxy instance that served the request
+	Upstream         string // the upstream it was routed to, host:port
 }
 
+// ErrDuplicateRequestID is returned by InsertRequest for a RequestID that
+// is already stored.
+var ErrDuplicateRequestID = errors.New("duplicate request_id")
+
 // InsertRequest persists a RequestRecord.
 func (s *Store) InsertRequest(r RequestRecord) error {
 	_, err := s.db.Exec(`
@@ -102,8 +113,8 @@ func (s *Store) InsertRequest(r RequestRecord) error {
 			status_code, duration_ms, request_bytes, response_bytes,
 			prompt_tokens, completion_tokens, total_tokens,
 			error_message, client_ip, user_agent,
-			prompt_text, response_text
-		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+			prompt_text, response_text, served_by, upstream
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 		r.RequestID,
 		r.SessionID,
 		r.Timestamp.UTC().Format(time.RFC3339Nano),
@@ -123,7 +134,12 @@ func (s *Store) InsertRequest(r RequestRecord) error {
 		r.UserAgent,
 		r.PromptText,
 		r.ResponseText,
+		r.ServedBy,
+		r.Upstream,
 	)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: requests.request_id") {
+		return fmt.Errorf("%w: %s", ErrDuplicateRequestID, r.RequestID)
+	}
 	return err
 }
 
@@ -149,6 +165,8 @@ type RequestRow struct {
 	UserAgent        string    `json:"user_agent"`
 	PromptText       string    `json:"prompt_text"`
 	ResponseText     string    `json:"response_text"`
+	ServedBy         string    `json:"served_by"`
+	Upstream         string    `json:"upstream"`
 }
 
 // ListRequests returns paginated requests, newest first.
@@ -176,7 +194,7 @@ func (s *Store) ListRequests(limit, offset int, model, sessionID string) (rows [
 		       status_code, duration_ms, request_bytes, response_bytes,
 		       prompt_tokens, completion_tokens, total_tokens,
 		       error_message, client_ip, user_agent,
-		       prompt_text, response_text
+		       prompt_text, response_text, served_by, upstream
 		FROM requests WHERE ` + where + `
 		ORDER BY timestamp DESC
 		LIMIT ? OFFSET ?`
@@ -198,7 +216,7 @@ func (s *Store) ListRequests(limit, offset int, model, sessionID string) (rows [
 			&r.StatusCode, &r.DurationMS, &r.RequestBytes, &r.ResponseBytes,
 			&r.PromptTokens, &r.CompletionTokens, &r.TotalTokens,
 			&r.ErrorMessage, &r.ClientIP, &r.UserAgent,
-			&r.PromptText, &r.ResponseText,
+			&r.PromptText, &r.ResponseText, &r.ServedBy, &r.Upstream,
 		); err != nil {
 			return nil, 0, err
 		}
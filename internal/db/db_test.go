@@ -1,6 +1,7 @@
 package db
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -64,8 +65,8 @@ func TestInsertRequest_DuplicateIDReturnsError(t *testing.T) {
 	if err := s.InsertRequest(r); err != nil {
 		t.Fatalf("first insert: %v", err)
 	}
-	if err := s.InsertRequest(r); err == nil {
-		t.Fatal("expected error on duplicate request_id, got nil")
+	if err := s.InsertRequest(r); !errors.Is(err, ErrDuplicateRequestID) {
+		t.Fatalf("expected ErrDuplicateRequestID on duplicate request_id, got %v", err)
 	}
 }
 
@@ -171,6 +172,41 @@ func TestListRequests_StreamFieldRoundtrips(t *testing.T) {
 	}
 }
 
+func TestListRequests_ServedByRoundtrips(t *testing.T) {
+	s := openTestDB(t)
+	r := sampleRecord("served-req")
+	r.ServedBy, r.Upstream = "proxy-a", "gpu-1:11434"
+	_ = s.InsertRequest(r)
+
+	rows, _, _ := s.ListRequests(1, 0, "", "")
+	if rows[0].ServedBy != "proxy-a" || rows[0].Upstream != "gpu-1:11434" {
+		t.Errorf("expected served_by and upstream to round-trip, got %q %q", rows[0].ServedBy, rows[0].Upstream)
+	}
+}
+
+func TestOpen_AddsServedByToOldSchema(t *testing.T) {
+	path := t.TempDir() + "/old.db"
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, col := range []string{"served_by", "upstream"} {
+		if _, err := s.db.Exec("ALTER TABLE requests DROP COLUMN " + col); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	if err := s.InsertRequest(sampleRecord("after-upgrade")); err != nil {
+		t.Errorf("expected the columns added on open, got %v", err)
+	}
+}
+
 func TestDailyStats_Aggregates(t *testing.T) {
 	s := openTestDB(t)
 	for i, ts := range []time.Time{
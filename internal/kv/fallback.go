@@ -0,0 +1,101 @@
+package kv
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Fallback serves from a primary (shared) Store and degrades to a local one
+// when the primary fails. After a failure the primary is skipped for the
+// cooldown, so an unreachable server costs one timeout rather than one per
+// request.
+type Fallback struct {
+	primary  Store
+	local    Store
+	cooldown time.Duration
+	onError  func(op string, err error)
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// NewFallback wraps primary with local as the degraded-mode store. onError,
+// if non-nil, is called for every primary failure.
+func NewFallback(primary, local Store, cooldown time.Duration, onError func(op string, err error)) *Fallback {
+	if onError == nil {
+		onError = func(string, error) {}
+	}
+	return &Fallback{primary: primary, local: local, cooldown: cooldown, onError: onError}
+}
+
+// Remote reports whether operations currently go to the primary store.
+func (f *Fallback) Remote() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !time.Now().Before(f.downUntil)
+}
+
+func (f *Fallback) fail(op string, err error) {
+	f.mu.Lock()
+	f.downUntil = time.Now().Add(f.cooldown)
+	f.mu.Unlock()
+	f.onError(op, err)
+}
+
+// Get implements Store.
+func (f *Fallback) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if f.Remote() {
+		v, ok, err := f.primary.Get(ctx, key)
+		if err == nil {
+			return v, ok, nil
+		}
+		f.fail("get", err)
+	}
+	return f.local.Get(ctx, key)
+}
+
+// Set implements Store.
+func (f *Fallback) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if f.Remote() {
+		err := f.primary.Set(ctx, key, value, ttl)
+		if err == nil {
+			return nil
+		}
+		f.fail("set", err)
+	}
+	return f.local.Set(ctx, key, value, ttl)
+}
+
+// Delete implements Store. Keys are removed from both stores so that values
+// written while degraded don't resurface later.
+func (f *Fallback) Delete(ctx context.Context, keys ...string) error {
+	_ = f.local.Delete(ctx, keys...)
+	if f.Remote() {
+		if err := f.primary.Delete(ctx, keys...); err != nil {
+			f.fail("delete", err)
+		}
+	}
+	return nil
+}
+
+// IncrBy implements Store.
+func (f *Fallback) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	if f.Remote() {
+		v, err := f.primary.IncrBy(ctx, key, n, ttl)
+		if err == nil {
+			return v, nil
+		}
+		f.fail("incr", err)
+	}
+	return f.local.IncrBy(ctx, key, n, ttl)
+}
+
+// Close closes both stores.
+func (f *Fallback) Close() error {
+	err := f.primary.Close()
+	if lerr := f.local.Close(); err == nil {
+		err = lerr
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+// Package kv provides the small key/value abstraction that caches, rate
+// limiters and quota trackers keep their state in, with an in-memory
+// implementation and a Redis-backed one for multi-replica deployments.
+package kv
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store is a key/value store with per-key expiry and atomic counters.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored at key; ok is false when the key is absent
+	// or expired.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set stores value at key. A ttl of 0 means no expiry.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes keys; missing keys are ignored.
+	Delete(ctx context.Context, keys ...string) error
+	// IncrBy atomically adds n to the counter at key and returns the new
+	// value. When the increment creates the key, ttl is applied to it.
+	IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	// Close releases resources held by the store.
+	Close() error
+}
+
+type memEntry struct {
+	value   []byte
+	counter int64
+	expires time.Time // zero = never
+}
+
+func (e memEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// Memory is a process-local Store. Expired keys are dropped lazily on access
+// and by a periodic sweep.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memEntry
+	done    chan struct{}
+	stopped chan struct{} // closed when sweep returns
+	once    sync.Once
+}
+
+// NewMemory returns an empty in-memory Store.
+func NewMemory() *Memory {
+	m := &Memory{entries: map[string]memEntry{}, done: make(chan struct{}), stopped: make(chan struct{})}
+	go m.sweep(time.Minute)
+	return m
+}
+
+func (m *Memory) sweep(every time.Duration) {
+	defer close(m.stopped)
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-t.C:
+			m.mu.Lock()
+			for k, e := range m.entries {
+				if e.expired(now) {
+					delete(m.entries, k)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// Get implements Store.
+func (m *Memory) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || e.expired(time.Now()) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	if e.value == nil {
+		return []byte(strconv.FormatInt(e.counter, 10)), true, nil
+	}
+	return append([]byte(nil), e.value...), true, nil
+}
+
+// Set implements Store.
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	m.entries[key] = memEntry{value: append([]byte{}, value...), expires: expiry(ttl)}
+	m.mu.Unlock()
+	return nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	for _, k := range keys {
+		delete(m.entries, k)
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// IncrBy implements Store.
+func (m *Memory) IncrBy(_ context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || e.expired(time.Now()) {
+		e = memEntry{expires: expiry(ttl)}
+	}
+	e.value = nil
+	e.counter += n
+	m.entries[key] = e
+	return e.counter, nil
+}
+
+// Close stops the background sweep and waits for it to return. It is safe
+// to call more than once.
+func (m *Memory) Close() error {
+	m.once.Do(func() { close(m.done) })
+	<-m.stopped
+	return nil
+}
@@ -0,0 +1,126 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemory_GetSetDelete(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	ctx := context.Background()
+
+	if _, ok, _ := m.Get(ctx, "k"); ok {
+		t.Fatal("expected miss on empty store")
+	}
+	_ = m.Set(ctx, "k", []byte("v"), 0)
+	if v, ok, _ := m.Get(ctx, "k"); !ok || string(v) != "v" {
+		t.Fatalf("expected v, got %q ok=%v", v, ok)
+	}
+	_ = m.Delete(ctx, "k")
+	if _, ok, _ := m.Get(ctx, "k"); ok {
+		t.Error("expected key deleted")
+	}
+}
+
+func TestMemory_TTLExpires(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	ctx := context.Background()
+
+	_ = m.Set(ctx, "k", []byte("v"), 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	if _, ok, _ := m.Get(ctx, "k"); ok {
+		t.Error("expected entry to expire")
+	}
+}
+
+func TestMemory_IncrByKeepsFirstTTL(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	ctx := context.Background()
+
+	if v, _ := m.IncrBy(ctx, "c", 2, 30*time.Millisecond); v != 2 {
+		t.Fatalf("expected 2, got %d", v)
+	}
+	if v, _ := m.IncrBy(ctx, "c", 3, time.Hour); v != 5 {
+		t.Fatalf("expected 5, got %d", v)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if v, _ := m.IncrBy(ctx, "c", 1, time.Hour); v != 1 {
+		t.Errorf("expected counter to reset after the original TTL, got %d", v)
+	}
+}
+
+// failingStore fails every operation.
+type failingStore struct{}
+
+var errDown = errors.New("down")
+
+func (failingStore) Get(context.Context, string) ([]byte, bool, error) { return nil, false, errDown }
+func (failingStore) Set(context.Context, string, []byte, time.Duration) error {
+	return errDown
+}
+func (failingStore) Delete(context.Context, ...string) error { return errDown }
+func (failingStore) IncrBy(context.Context, string, int64, time.Duration) (int64, error) {
+	return 0, errDown
+}
+func (failingStore) Close() error { return nil }
+
+func TestMemory_CloseStopsSweep(t *testing.T) {
+	m := NewMemory()
+	closed := make(chan struct{})
+	go func() {
+		_ = m.Close()
+		_ = m.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	select {
+	case <-m.stopped:
+	default:
+		t.Fatal("sweep still running after Close")
+	}
+}
+
+func TestFallback_DegradesToLocal(t *testing.T) {
+	var errs []string
+	f := NewFallback(failingStore{}, NewMemory(), time.Minute, func(op string, err error) {
+		errs = append(errs, op)
+	})
+	defer f.Close()
+	ctx := context.Background()
+
+	if v, err := f.IncrBy(ctx, "c", 1, time.Minute); err != nil || v != 1 {
+		t.Fatalf("expected local increment, got %d, %v", v, err)
+	}
+	if f.Remote() {
+		t.Error("expected primary to be marked down after a failure")
+	}
+	if v, _ := f.IncrBy(ctx, "c", 1, time.Minute); v != 2 {
+		t.Errorf("expected local counter 2, got %d", v)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected primary skipped during cooldown (1 error), got %v", errs)
+	}
+}
+
+func TestFallback_RetriesPrimaryAfterCooldown(t *testing.T) {
+	var n int
+	f := NewFallback(failingStore{}, NewMemory(), 10*time.Millisecond, func(string, error) { n++ })
+	defer f.Close()
+	ctx := context.Background()
+
+	_, _, _ = f.Get(ctx, "k")
+	time.Sleep(20 * time.Millisecond)
+	_, _, _ = f.Get(ctx, "k")
+	if n != 2 {
+		t.Errorf("expected primary retried after cooldown, got %d errors", n)
+	}
+}
@@ -0,0 +1,258 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RedisOptions configures the Redis-backed Store.
+type RedisOptions struct {
+	Addr     string // host:port
+	Username string // optional, Redis 6 ACL user
+	Password string
+	DB       int
+	TLS      *tls.Config // nil = plaintext
+
+	DialTimeout time.Duration // default 2s
+	OpTimeout   time.Duration // per command when ctx has no deadline; default 1s
+	PoolSize    int           // idle connections kept; default 16
+}
+
+// Redis is a Store backed by a Redis server, speaking RESP2 over a small
+// connection pool. Counters use a server-side script so increment and expiry
+// are applied atomically.
+type Redis struct {
+	opts RedisOptions
+	pool chan *redisConn
+}
+
+// incrScript increments KEYS[1] by ARGV[1] and, when the key has no expiry
+// yet, sets it to ARGV[2] milliseconds.
+const incrScript = `local v = redis.call('INCRBY', KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call('PTTL', KEYS[1]) < 0 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return v`
+
+// NewRedis returns a Redis store. Connections are dialed lazily, so an
+// unreachable server surfaces as errors from individual operations.
+func NewRedis(opts RedisOptions) *Redis {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 2 * time.Second
+	}
+	if opts.OpTimeout <= 0 {
+		opts.OpTimeout = time.Second
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 16
+	}
+	return &Redis{opts: opts, pool: make(chan *redisConn, opts.PoolSize)}
+}
+
+// RedisError is an error reply from the server.
+type RedisError string
+
+func (e RedisError) Error() string { return "redis: " + string(e) }
+
+type redisConn struct {
+	c  net.Conn
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+func (r *Redis) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: r.opts.DialTimeout}
+	c, err := d.DialContext(ctx, "tcp", r.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if r.opts.TLS != nil {
+		tc := tls.Client(c, r.opts.TLS)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		c = tc
+	}
+	rc := &redisConn{c: c, br: bufio.NewReader(c), bw: bufio.NewWriter(c)}
+	_ = c.SetDeadline(time.Now().Add(r.opts.DialTimeout))
+	if r.opts.Password != "" {
+		args := []string{"AUTH", r.opts.Password}
+		if r.opts.Username != "" {
+			args = []string{"AUTH", r.opts.Username, r.opts.Password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+	}
+	if r.opts.DB != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(r.opts.DB)); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("select db: %w", err)
+		}
+	}
+	return rc, nil
+}
+
+// Do sends one command and returns its decoded reply: string, int64, nil,
+// []any or a RedisError.
+func (r *Redis) Do(ctx context.Context, args ...string) (any, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.opts.OpTimeout)
+		defer cancel()
+	}
+	var rc *redisConn
+	select {
+	case rc = <-r.pool:
+	default:
+		var err error
+		if rc, err = r.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	_ = rc.c.SetDeadline(deadline)
+	reply, err := rc.do(args...)
+	var rerr RedisError
+	if err != nil && !errors.As(err, &rerr) {
+		// Transport failure: the connection state is unknown, drop it.
+		_ = rc.c.Close()
+		return nil, err
+	}
+	select {
+	case r.pool <- rc:
+	default:
+		_ = rc.c.Close()
+	}
+	return reply, err
+}
+
+func (rc *redisConn) do(args ...string) (any, error) {
+	fmt.Fprintf(rc.bw, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(rc.bw, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := rc.bw.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(rc.br)
+}
+
+func readReply(br *bufio.Reader) (any, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, RedisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		out := make([]any, n)
+		for i := range out {
+			if out[i], err = readReply(br); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// Get implements Store.
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := r.Do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %T", reply)
+	}
+	return []byte(s), true, nil
+}
+
+// Set implements Store.
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", pxMillis(ttl))
+	}
+	_, err := r.Do(ctx, args...)
+	return err
+}
+
+// pxMillis formats ttl for PX and PEXPIRE in whole milliseconds, rounding a
+// positive ttl under 1ms up so it still expires (Redis rejects PX 0). A
+// non-positive ttl means no expiry.
+func pxMillis(ttl time.Duration) string {
+	if ttl <= 0 {
+		return "0"
+	}
+	return strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+}
+
+// Delete implements Store.
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := r.Do(ctx, append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+// IncrBy implements Store.
+func (r *Redis) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	reply, err := r.Do(ctx, "EVAL", incrScript, "1", key,
+		strconv.FormatInt(n, 10), pxMillis(ttl))
+	if err != nil {
+		return 0, err
+	}
+	v, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected INCRBY reply %T", reply)
+	}
+	return v, nil
+}
+
+// Close closes pooled connections.
+func (r *Redis) Close() error {
+	for {
+		select {
+		case rc := <-r.pool:
+			_ = rc.c.Close()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a tiny RESP2 server implementing the commands Redis uses.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu      sync.Mutex
+	data    map[string]string
+	expires map[string]time.Time
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{ln: ln, password: password, data: map[string]string{}, expires: map[string]time.Time{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	t.Cleanup(func() { _ = ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	authed := f.password == ""
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			fmt.Fprint(c, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		fmt.Fprint(c, f.exec(cmd, args[1:], &authed))
+	}
+}
+
+func readCommand(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		hdr, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		l, _ := strconv.Atoi(strings.TrimSpace(hdr[1:]))
+		buf := make([]byte, l+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:l])
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) live(key string) (string, bool) {
+	if exp, ok := f.expires[key]; ok && !time.Now().Before(exp) {
+		delete(f.data, key)
+		delete(f.expires, key)
+	}
+	v, ok := f.data[key]
+	return v, ok
+}
+
+func (f *fakeRedis) exec(cmd string, args []string, authed *bool) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch cmd {
+	case "AUTH":
+		if args[len(args)-1] != f.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.live(args[0])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		ms := 0
+		if len(args) == 4 && strings.EqualFold(args[2], "PX") {
+			if ms, _ = strconv.Atoi(args[3]); ms <= 0 {
+				return "-ERR invalid expire time in 'set' command\r\n"
+			}
+		}
+		f.data[args[0]] = args[1]
+		delete(f.expires, args[0])
+		if ms > 0 {
+			f.expires[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		for _, k := range args {
+			delete(f.data, k)
+			delete(f.expires, k)
+		}
+		return fmt.Sprintf(":%d\r\n", len(args))
+	case "EVAL":
+		if args[0] != incrScript {
+			return "-ERR unknown script\r\n"
+		}
+		key := args[2]
+		n, _ := strconv.ParseInt(args[3], 10, 64)
+		ms, _ := strconv.Atoi(args[4])
+		cur, ok := f.live(key)
+		v, _ := strconv.ParseInt(cur, 10, 64)
+		v += n
+		f.data[key] = strconv.FormatInt(v, 10)
+		if !ok && ms > 0 {
+			f.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return fmt.Sprintf(":%d\r\n", v)
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedis_GetSetDelete(t *testing.T) {
+	srv := startFakeRedis(t, "")
+	r := NewRedis(RedisOptions{Addr: srv.addr()})
+	defer r.Close()
+	ctx := context.Background()
+
+	if _, ok, err := r.Get(ctx, "k"); err != nil || ok {
+		t.Fatalf("expected clean miss, got ok=%v err=%v", ok, err)
+	}
+	if err := r.Set(ctx, "k", []byte("v\r\nwith crlf"), time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if v, ok, err := r.Get(ctx, "k"); err != nil || !ok || string(v) != "v\r\nwith crlf" {
+		t.Fatalf("Get = %q ok=%v err=%v", v, ok, err)
+	}
+	if err := r.Delete(ctx, "k"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok, _ := r.Get(ctx, "k"); ok {
+		t.Error("expected key deleted")
+	}
+}
+
+func TestRedis_SetSubMillisecondTTL(t *testing.T) {
+	srv := startFakeRedis(t, "")
+	r := NewRedis(RedisOptions{Addr: srv.addr()})
+	defer r.Close()
+	ctx := context.Background()
+
+	if err := r.Set(ctx, "k", []byte("v"), 500*time.Microsecond); err != nil {
+		t.Fatalf("Set with sub-millisecond ttl: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := r.Get(ctx, "k"); ok {
+		t.Error("expected key to expire")
+	}
+}
+
+func TestRedis_IncrByAppliesTTLOnCreate(t *testing.T) {
+	srv := startFakeRedis(t, "")
+	r := NewRedis(RedisOptions{Addr: srv.addr()})
+	defer r.Close()
+	ctx := context.Background()
+
+	if v, err := r.IncrBy(ctx, "c", 5, 30*time.Millisecond); err != nil || v != 5 {
+		t.Fatalf("IncrBy = %d, %v", v, err)
+	}
+	if v, _ := r.IncrBy(ctx, "c", 1, time.Hour); v != 6 {
+		t.Fatalf("expected 6, got %d", v)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if v, _ := r.IncrBy(ctx, "c", 1, time.Hour); v != 1 {
+		t.Errorf("expected counter reset after TTL, got %d", v)
+	}
+}
+
+func TestRedis_Auth(t *testing.T) {
+	srv := startFakeRedis(t, "s3cret")
+	ctx := context.Background()
+
+	bad := NewRedis(RedisOptions{Addr: srv.addr(), Password: "wrong"})
+	defer bad.Close()
+	if err := bad.Set(ctx, "k", []byte("v"), 0); err == nil {
+		t.Error("expected auth failure with wrong password")
+	}
+
+	good := NewRedis(RedisOptions{Addr: srv.addr(), Password: "s3cret"})
+	defer good.Close()
+	if err := good.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Errorf("expected success with correct password, got %v", err)
+	}
+}
+
+func TestRedis_UnreachableReturnsError(t *testing.T) {
+	r := NewRedis(RedisOptions{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+	defer r.Close()
+	if _, _, err := r.Get(context.Background(), "k"); err == nil {
+		t.Error("expected error for unreachable server")
+	}
+}
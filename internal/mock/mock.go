@@ -0,0 +1,207 @@
+// Package mock implements a synthetic Ollama upstream for load-testing
+// clients through the proxy without a GPU. Responses follow the Ollama wire
+// format closely enough that the proxy's token accounting, streaming and
+// metrics behave exactly as they do against a real server.
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Model is the name the mock reports in /api/tags and /api/ps.
+const Model = "mock:latest"
+
+// Options controls the synthesized responses.
+type Options struct {
+	// PromptTokens is reported as prompt_eval_count; 0 estimates it from the
+	// prompt length (about four bytes per token).
+	PromptTokens int
+	// CompletionTokens is the number of tokens generated per request.
+	CompletionTokens int
+	// TokensPerSecond paces generation; 0 responds as fast as possible.
+	TokensPerSecond float64
+}
+
+// Server is an http.Handler answering the Ollama API with synthetic data.
+type Server struct {
+	opts Options
+}
+
+// New returns a mock upstream. CompletionTokens defaults to 128.
+func New(opts Options) *Server {
+	if opts.CompletionTokens <= 0 {
+		opts.CompletionTokens = 128
+	}
+	return &Server{opts: opts}
+}
+
+var lorem = strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod " +
+	"tempor incididunt ut labore et dolore magna aliqua ut enim ad minim veniam quis nostrud " +
+	"exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat")
+
+// request is the subset of generate/chat/embed payloads the mock reads.
+type request struct {
+	Model    string `json:"model"`
+	Stream   *bool  `json:"stream"`
+	Prompt   string `json:"prompt"`
+	Messages []struct {
+		Content string `json:"content"`
+	} `json:"messages"`
+	Input json.RawMessage `json:"input"`
+}
+
+func (q request) model() string {
+	if q.Model == "" {
+		return Model
+	}
+	return q.Model
+}
+
+// promptBytes approximates the prompt size for the prompt token estimate.
+func (q request) promptBytes() int {
+	n := len(q.Prompt) + len(q.Input)
+	for _, m := range q.Messages {
+		n += len(m.Content)
+	}
+	return n
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var q request
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&q) // best-effort, like Ollama's defaults
+	}
+	switch r.URL.Path {
+	case "/api/generate":
+		s.generate(w, r, q, false)
+	case "/api/chat":
+		s.generate(w, r, q, true)
+	case "/api/embed", "/api/embeddings":
+		s.embed(w, r, q)
+	case "/api/version":
+		writeJSON(w, map[string]any{"version": "0.0.0-mock"})
+	case "/api/tags":
+		writeJSON(w, map[string]any{"models": []map[string]any{{
+			"name": Model, "model": Model, "size": 0, "digest": "mock",
+			"modified_at": time.Now().UTC().Format(time.RFC3339),
+		}}})
+	case "/api/ps":
+		writeJSON(w, map[string]any{"models": []map[string]any{{
+			"name": Model, "model": Model, "size_vram": 0,
+			"expires_at": time.Now().Add(5 * time.Minute).UTC().Format(time.RFC3339),
+		}}})
+	case "/api/show":
+		writeJSON(w, map[string]any{
+			"modelfile":  "FROM " + q.model(),
+			"details":    map[string]any{"family": "mock", "parameter_size": "0B"},
+			"model_info": map[string]any{"general.architecture": "mock"},
+		})
+	default:
+		writeJSON(w, map[string]any{"status": "success"})
+	}
+}
+
+// generate answers /api/generate and /api/chat, streaming one token per
+// NDJSON line unless the request set "stream": false.
+func (s *Server) generate(w http.ResponseWriter, r *http.Request, q request, chat bool) {
+	start := time.Now()
+	promptTokens := s.opts.PromptTokens
+	if promptTokens <= 0 {
+		promptTokens = max(1, q.promptBytes()/4)
+	}
+	stream := q.Stream == nil || *q.Stream
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	var text strings.Builder
+	var delay time.Duration
+	if s.opts.TokensPerSecond > 0 {
+		delay = time.Duration(float64(time.Second) / s.opts.TokensPerSecond)
+	}
+	evalStart := time.Now()
+	for i := 0; i < s.opts.CompletionTokens; i++ {
+		if delay > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+		tok := lorem[i%len(lorem)] + " "
+		if !stream {
+			text.WriteString(tok)
+			continue
+		}
+		if err := enc.Encode(chunk(q.model(), tok, chat)); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	evalDur := time.Since(evalStart)
+
+	final := chunk(q.model(), text.String(), chat)
+	final["done"] = true
+	final["done_reason"] = "stop"
+	final["total_duration"] = time.Since(start).Nanoseconds()
+	final["load_duration"] = 0
+	final["prompt_eval_count"] = promptTokens
+	final["prompt_eval_duration"] = 0
+	final["eval_count"] = s.opts.CompletionTokens
+	final["eval_duration"] = evalDur.Nanoseconds()
+	_ = enc.Encode(final)
+}
+
+// chunk builds a generate or chat response object carrying text.
+func chunk(model, text string, chat bool) map[string]any {
+	c := map[string]any{
+		"model":      model,
+		"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+		"done":       false,
+	}
+	if chat {
+		c["message"] = map[string]any{"role": "assistant", "content": text}
+	} else {
+		c["response"] = text
+	}
+	return c
+}
+
+// embed answers /api/embed (batch) and the legacy /api/embeddings.
+func (s *Server) embed(w http.ResponseWriter, r *http.Request, q request) {
+	vec := []float64{0.1, 0.2, 0.3, 0.4}
+	if r.URL.Path == "/api/embeddings" {
+		writeJSON(w, map[string]any{"embedding": vec})
+		return
+	}
+	n := 1
+	var inputs []string
+	if json.Unmarshal(q.Input, &inputs) == nil && len(inputs) > 0 {
+		n = len(inputs)
+	}
+	embeddings := make([][]float64, n)
+	for i := range embeddings {
+		embeddings[i] = vec
+	}
+	writeJSON(w, map[string]any{
+		"model":             q.model(),
+		"embeddings":        embeddings,
+		"prompt_eval_count": max(1, q.promptBytes()/4),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,88 @@
+package mock
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func post(t *testing.T, s *Server, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rr
+}
+
+func TestGenerate_NonStream(t *testing.T) {
+	s := New(Options{PromptTokens: 7, CompletionTokens: 5})
+	rr := post(t, s, "/api/generate", `{"model":"llama3","prompt":"hi","stream":false}`)
+
+	var resp struct {
+		Model           string `json:"model"`
+		Response        string `json:"response"`
+		Done            bool   `json:"done"`
+		PromptEvalCount int    `json:"prompt_eval_count"`
+		EvalCount       int    `json:"eval_count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("expected a single JSON object, got %q: %v", rr.Body.String(), err)
+	}
+	if !resp.Done || resp.Model != "llama3" || resp.PromptEvalCount != 7 || resp.EvalCount != 5 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if got := len(strings.Fields(resp.Response)); got != 5 {
+		t.Errorf("expected 5 words of text, got %d", got)
+	}
+}
+
+func TestChat_StreamEmitsOneChunkPerToken(t *testing.T) {
+	s := New(Options{CompletionTokens: 3})
+	rr := post(t, s, "/api/chat", `{"model":"llama3","messages":[{"role":"user","content":"0123456789abcdef"}]}`)
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected NDJSON content type, got %q", ct)
+	}
+	var lines []map[string]any
+	sc := bufio.NewScanner(rr.Body)
+	for sc.Scan() {
+		var m map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, m)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("expected 3 token chunks + final, got %d", len(lines))
+	}
+	if _, ok := lines[0]["message"]; !ok {
+		t.Error("expected chat chunks to carry message")
+	}
+	final := lines[3]
+	if final["done"] != true || final["eval_count"] != float64(3) || final["prompt_eval_count"] != float64(4) {
+		t.Errorf("unexpected final chunk: %v", final)
+	}
+}
+
+func TestGenerate_PacedByTokensPerSecond(t *testing.T) {
+	s := New(Options{CompletionTokens: 4, TokensPerSecond: 100})
+	start := time.Now()
+	post(t, s, "/api/generate", `{"prompt":"x"}`)
+	if d := time.Since(start); d < 35*time.Millisecond {
+		t.Errorf("expected ~40ms of pacing, took %s", d)
+	}
+}
+
+func TestEmbed_OneVectorPerInput(t *testing.T) {
+	s := New(Options{})
+	rr := post(t, s, "/api/embed", `{"model":"e","input":["a","b","c"]}`)
+	var resp struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || len(resp.Embeddings) != 3 {
+		t.Errorf("expected 3 embeddings, got %s (%v)", rr.Body.String(), err)
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+)
+
+type accessKey struct{}
+
+// accessEntry carries a request's record from persistAndLog to its access
+// line; requests refused before they are recorded have none.
+type accessEntry struct {
+	mu       sync.Mutex
+	rec      db.RequestRecord
+	recorded bool
+}
+
+// startAccess tags r for an access line when AccessLog is set, returning
+// the entry to log at the end, nil otherwise.
+func (h *Handler) startAccess(r *http.Request) (*http.Request, *accessEntry) {
+	if h.cfg.AccessLog == nil {
+		return r, nil
+	}
+	e := &accessEntry{}
+	return r.WithContext(context.WithValue(r.Context(), accessKey{}, e)), e
+}
+
+// noteAccess keeps rec for the access line of the request ctx belongs to.
+func noteAccess(ctx context.Context, rec db.RequestRecord) {
+	if e, _ := ctx.Value(accessKey{}).(*accessEntry); e != nil {
+		e.mu.Lock()
+		e.rec, e.recorded = rec, true
+		e.mu.Unlock()
+	}
+}
+
+// logAccess writes the access line of a request once its response has been
+// sent: what was asked and answered, sizes, tokens and timings, never
+// bodies or headers. The duration runs to the end of the handler, a long
+// stream's last chunk included; ttfb_ms is when the first body byte went to
+// the client.
+func (h *Handler) logAccess(cw *clientWriter, r *http.Request, e *accessEntry, start time.Time) {
+	e.mu.Lock()
+	rec, recorded := e.rec, e.recorded
+	e.mu.Unlock()
+	if !recorded {
+		rec.Endpoint, rec.ClientIP, rec.RequestID = h.routes.normalize(r.URL.Path), extractClientIP(r), RequestID(r.Context())
+	}
+	status := int(cw.status.Load())
+	if status == 0 {
+		status = http.StatusOK // nothing written; net/http sends 200
+	}
+	args := []any{
+		"request_id", rec.RequestID,
+		"method", r.Method,
+		"endpoint", rec.Endpoint,
+		"model", rec.Model,
+		"stream", rec.Stream,
+		"status_code", status,
+		"request_bytes", rec.RequestBytes,
+		"response_bytes", cw.n.Load(),
+		"duration_ms", time.Since(start).Milliseconds(),
+	}
+	if first := cw.first.Load(); first != 0 {
+		args = append(args, "ttfb_ms", time.Unix(0, first).Sub(start).Milliseconds())
+	}
+	if rec.PromptTokens > 0 || rec.CompletionTokens > 0 {
+		args = append(args, "prompt_tokens", rec.PromptTokens, "completion_tokens", rec.CompletionTokens)
+	}
+	args = append(args, "client_ip", rec.ClientIP)
+	h.cfg.AccessLog.Info("access", args...)
+}
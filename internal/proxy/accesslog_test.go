@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/ollamatest"
+)
+
+// accessLines collects the lines of an access log.
+type accessLines struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *accessLines) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *accessLines) all(t *testing.T) ([]map[string]any, string) {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []map[string]any
+	for sc := bufio.NewScanner(bytes.NewReader(l.buf.Bytes())); sc.Scan(); {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, line)
+	}
+	return out, l.buf.String()
+}
+
+func TestAccessLog_Stream(t *testing.T) {
+	const every = 50 * time.Millisecond
+	fake := ollamatest.NewServer(ollamatest.Options{Tokens: []string{"a", "b", "c"}, PromptTokens: 7, ChunkDelay: every})
+	t.Cleanup(fake.Close)
+	var access accessLines
+	h := newTestHandlerWithConfig(t, fake.URL, Config{AccessLog: slog.New(slog.NewJSONHandler(&access, nil))})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"llama3:8b","prompt":"private words"}`))
+	req.Header.Set("User-Agent", "agent-secret")
+	req.RemoteAddr = "192.0.2.9:4000"
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(rr, req)
+	elapsed := time.Since(start)
+
+	lines, raw := access.all(t)
+	if len(lines) != 1 {
+		t.Fatalf("expected one access line, got %s", raw)
+	}
+	l := lines[0]
+	if l["msg"] != "access" || l["method"] != "POST" || l["endpoint"] != "/api/generate" || l["model"] != "llama3:8b" ||
+		l["stream"] != true || l["status_code"] != float64(200) || l["client_ip"] != "192.0.2.9" ||
+		l["request_id"] != rr.Header().Get("X-Request-Id") {
+		t.Errorf("unexpected access line %v", l)
+	}
+	if l["response_bytes"] != float64(rr.Body.Len()) || l["request_bytes"].(float64) == 0 ||
+		l["prompt_tokens"] != float64(7) || l["completion_tokens"] != float64(3) {
+		t.Errorf("expected the sizes and token counts, got %v", l)
+	}
+	// The line is written once the whole stream is out.
+	duration, ttfb := time.Duration(l["duration_ms"].(float64))*time.Millisecond, time.Duration(l["ttfb_ms"].(float64))*time.Millisecond
+	if duration < 2*every || duration > elapsed || ttfb >= duration {
+		t.Errorf("expected the duration to cover the stream and the first byte before its end, got %s and %s", duration, ttfb)
+	}
+	for _, leak := range []string{"private words", "agent-secret", `"a"`} {
+		if strings.Contains(raw, leak) {
+			t.Errorf("expected no bodies or headers in the access log, found %s in %s", leak, raw)
+		}
+	}
+}
+
+func TestAccessLog_Refused(t *testing.T) {
+	var access accessLines
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{
+		AccessLog: slog.New(slog.NewJSONHandler(&access, nil)),
+		APIKeys:   []APIKey{{Name: "web", Key: "secret-web"}},
+	})
+	h.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate?x=1", strings.NewReader(`{"model":"m"}`)))
+
+	lines, raw := access.all(t)
+	if len(lines) != 1 || lines[0]["status_code"] != float64(http.StatusUnauthorized) || lines[0]["endpoint"] != "/api/generate" ||
+		lines[0]["request_id"] != rr.Header().Get("X-Request-Id") || lines[0]["model"] != "" {
+		t.Errorf("expected a line for the unrecorded 401, got %s", raw)
+	}
+	if _, ok := lines[0]["prompt_tokens"]; ok {
+		t.Errorf("expected no token counts when none are known, got %s", raw)
+	}
+}
+
+func TestAccessLog_Off(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	if r, e := h.startAccess(req); r != req || e != nil {
+		t.Error("expected no access entry without an AccessLog")
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// RegisterAdmin mounts the runtime administration endpoints on mux:
+//
+//	GET    /admin/models                       — models in maintenance
+//	PUT    /admin/models/{model}/maintenance   — put a model in maintenance
+//	DELETE /admin/models/{model}/maintenance   — clear it
+//	GET    /admin/upstream                     — current upstream
+//	PUT    /admin/upstream                     — switch upstream
+//	GET    /debug/last-error[?model=]          — latest error response per model
+//
+// Every call must carry "Authorization: Bearer <token>"; one that does not
+// gets a 401 saying why (see authFailed). Model names with a slash must be
+// path-escaped (%2F).
+func (h *Handler) RegisterAdmin(mux *http.ServeMux, token string) {
+	for _, rt := range h.AdminRoutes(token) {
+		mux.HandleFunc(rt.Method+" "+rt.Path, rt.Handler)
+	}
+}
+
+// Route is an endpoint RegisterAdmin mounts.
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+}
+
+// AdminRoutes returns the endpoints RegisterAdmin mounts, each behind the
+// token. h is not used until a route is served, so a nil Handler describes
+// them too.
+func (h *Handler) AdminRoutes(token string) []Route {
+	return []Route{
+		{http.MethodGet, "/admin/models", h.adminAuth(token, h.handleListModels)},
+		{http.MethodPut, "/admin/models/{model}/maintenance", h.adminAuth(token, h.handleSetMaintenance)},
+		{http.MethodDelete, "/admin/models/{model}/maintenance", h.adminAuth(token, h.handleClearMaintenance)},
+		{http.MethodGet, "/admin/upstream", h.adminAuth(token, h.handleGetUpstream)},
+		{http.MethodPut, "/admin/upstream", h.adminAuth(token, h.handlePutUpstream)},
+		{http.MethodGet, "/debug/last-error", h.adminAuth(token, h.handleLastError)},
+	}
+}
+
+// adminMessages explain each authentication failure mode to the caller.
+var adminMessages = map[string]string{
+	authMissing:   "admin token required",
+	authMalformed: "malformed Authorization header; expected Bearer <token>",
+	authInvalid:   "invalid admin token",
+}
+
+func (h *Handler) adminAuth(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := checkBearer(r, token)
+		if mode == "" && token == "" {
+			mode = authInvalid // without a token nothing is accepted
+		}
+		if mode != "" {
+			h.authFailed(w, mode, adminMessages[mode])
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(proxyapi.SchemaHeader, proxyapi.SchemaVersion)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
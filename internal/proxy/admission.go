@@ -0,0 +1,46 @@
+package proxy
+
+import "time"
+
+// Admission decisions, one per proxied request, logged as the admission
+// field of its request line and counted in admission_decisions_total. The
+// values are stable; rejected requests also log admission_reason, one of
+// the policy rejection reasons, which is the "reason" field of their JSON
+// error body too.
+const (
+	admissionAdmitted  = "admitted"  // forwarded without waiting
+	admissionQueued    = "queued"    // forwarded after waiting for a per-model slot
+	admissionRejected  = "rejected"  // refused by a policy; see admission_reason
+	admissionAbandoned = "abandoned" // the client went away while queued
+)
+
+const admissionDecisionsHelp = "Proxied requests by admission decision: admitted (no wait), " +
+	"queued (waited for a -max-concurrent-per-model slot), rejected (by a policy, broken down in " +
+	"policy_rejections_total) or abandoned (client gone while queued)."
+
+// admissionDecisions are pre-initialised to zero like the policy reasons.
+var admissionDecisions = []string{admissionAdmitted, admissionQueued, admissionRejected, admissionAbandoned}
+
+// admit records that a request got past the admission gate after waiting
+// wait.
+func (ri *reqInfo) admit(wait time.Duration) {
+	ri.queueWait = wait
+	ri.admission = admissionAdmitted
+	if wait > 0 {
+		ri.admission = admissionQueued
+	}
+}
+
+// admissionAttrs counts a finished request's admission decision and returns
+// the attributes that explain it on its request log line.
+func (h *Handler) admissionAttrs(ri *reqInfo) []any {
+	if ri.admission == "" {
+		return nil
+	}
+	h.metrics.AdmissionDecisions.WithLabelValues(ri.admission).Inc()
+	attrs := []any{"admission", ri.admission}
+	if ri.admissionReason != "" {
+		attrs = append(attrs, "admission_reason", ri.admissionReason)
+	}
+	return attrs
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// requestLines collects the handler's "request" log lines.
+type requestLines struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *requestLines) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+// byClient returns the request line of the request from ip.
+func (l *requestLines) byClient(t *testing.T, ip string) map[string]any {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sc := bufio.NewScanner(bytes.NewReader(l.buf.Bytes()))
+	for sc.Scan() {
+		var line map[string]any
+		if json.Unmarshal(sc.Bytes(), &line) == nil && line["msg"] == "request" && line["client_ip"] == ip {
+			return line
+		}
+	}
+	t.Fatalf("no request line for %s in %q", ip, l.buf.String())
+	return nil
+}
+
+func logRequests(h *Handler) *requestLines {
+	l := &requestLines{}
+	h.logger = slog.New(slog.NewJSONHandler(l, nil))
+	return l
+}
+
+func TestAdmission_AdmittedImmediately(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	lines := logRequests(h)
+	generate(h, "10.0.0.1")
+
+	line := lines.byClient(t, "10.0.0.1")
+	if line["admission"] != admissionAdmitted || line["queue_wait_ms"] != float64(0) {
+		t.Errorf("expected admitted without waiting, got %v", line)
+	}
+	if _, ok := line["admission_reason"]; ok {
+		t.Errorf("expected no reason for an admitted request, got %v", line["admission_reason"])
+	}
+	if got := testutil.ToFloat64(h.metrics.AdmissionDecisions.WithLabelValues(admissionAdmitted)); got != 1 {
+		t.Errorf("expected one admitted decision, got %v", got)
+	}
+}
+
+func TestAdmission_QueuedAndTimedOut(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = fmt.Fprint(w, `{"done":true}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MaxConcurrentPerModel: 1, QueueTimeout: 30 * time.Millisecond})
+	lines := logRequests(h)
+	first := make(chan struct{})
+	go func() {
+		defer close(first)
+		generate(h, "10.0.0.1")
+	}()
+	waitFor(t, "first request in flight", func() bool { return h.busy("m") })
+
+	rr := generate(h, "10.0.0.2")
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusServiceUnavailable || body.Reason != reasonQueueTimeout {
+		t.Fatalf("expected a 503 with reason %s, got %d %q", reasonQueueTimeout, rr.Code, rr.Body.String())
+	}
+	line := lines.byClient(t, "10.0.0.2")
+	if line["admission"] != admissionRejected || line["admission_reason"] != reasonQueueTimeout || line["queue_wait_ms"].(float64) < 20 {
+		t.Errorf("expected a queue_timeout rejection after waiting, got %v", line)
+	}
+
+	// The next one waits for the first to finish, then is admitted.
+	second := make(chan struct{})
+	go func() {
+		defer close(second)
+		generate(h, "10.0.0.3")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	<-first
+	<-second
+	if line := lines.byClient(t, "10.0.0.3"); line["admission"] != admissionQueued {
+		t.Errorf("expected the waiting request logged as queued, got %v", line)
+	}
+	if got := testutil.ToFloat64(h.metrics.AdmissionDecisions.WithLabelValues(admissionRejected)); got != 1 {
+		t.Errorf("expected one rejected decision, got %v", got)
+	}
+}
+
+func TestAdmission_RejectionReasonInBody(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{RateLimit: 1, RateLimitWindow: time.Minute})
+	generate(h, "10.0.0.1")
+	rr := generate(h, "10.0.0.1")
+	var body map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body["reason"] != reasonRateLimited {
+		t.Fatalf("expected reason %s in the body, got %d %q", reasonRateLimited, rr.Code, rr.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.AdmissionDecisions.WithLabelValues(admissionRejected)); got != 1 {
+		t.Errorf("expected one rejected decision, got %v", got)
+	}
+}
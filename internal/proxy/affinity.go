@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ParseBackends parses a comma-separated list of Ollama base URLs for
+// Backends. An empty string yields none.
+func ParseBackends(s string) ([]*url.URL, error) {
+	var out []*url.URL
+	seen := map[string]bool{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		u, err := ParseUpstream(item)
+		if err != nil {
+			return nil, fmt.Errorf("backend %q: %w", item, err)
+		}
+		if seen[u.Host] {
+			return nil, fmt.Errorf("backend %q listed twice", u.Host)
+		}
+		seen[u.Host] = true
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// backend is one Ollama server of a backendPool.
+type backend struct {
+	url   *url.URL
+	label string
+	down  atomic.Bool // set by the health probe
+}
+
+// backendPool places requests on Backends: each model consistently on one
+// backend, chosen by rendezvous hashing so that adding or removing a backend
+// moves only the models that hashed to it. Models in everywhere are spread
+// round-robin instead.
+type backendPool struct {
+	h          *Handler
+	backends   []*backend
+	everywhere map[string]bool // canonical model names
+	next       atomic.Uint64
+	interval   time.Duration
+}
+
+func newBackendPool(h *Handler, cfg Config) *backendPool {
+	p := &backendPool{
+		h:          h,
+		everywhere: map[string]bool{},
+		interval:   cfg.BackendHealthInterval,
+	}
+	if p.interval <= 0 {
+		p.interval = 10 * time.Second
+	}
+	for _, u := range cfg.Backends {
+		b := &backend{url: u, label: upstreamLabel(u)}
+		p.backends = append(p.backends, b)
+		h.metrics.BackendUp.WithLabelValues(b.label).Set(1)
+	}
+	for _, m := range cfg.AffinityEverywhere {
+		p.everywhere[canonicalModel(m)] = true
+	}
+	return p
+}
+
+// score is backend b's rendezvous weight for a model; the highest wins.
+func (b *backend) score(key string) uint64 {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(b.label))
+	_, _ = f.Write([]byte{0})
+	_, _ = f.Write([]byte(key))
+	return f.Sum64()
+}
+
+// pick returns the backend for model and, when that is not the model's
+// preferred one because it is down, the preferred one. With every backend
+// down the preferred one is returned anyway.
+func (p *backendPool) pick(model string) (chosen, skipped *backend) {
+	key := canonicalModel(model)
+	if p.everywhere[key] {
+		n := uint64(len(p.backends))
+		start := p.next.Add(1)
+		for i := range n {
+			if b := p.backends[(start+i)%n]; !b.down.Load() {
+				return b, nil
+			}
+		}
+		return p.backends[start%n], nil
+	}
+	var preferred, healthy *backend
+	var bestAll, bestHealthy uint64
+	for _, b := range p.backends {
+		s := b.score(key)
+		if preferred == nil || s > bestAll {
+			preferred, bestAll = b, s
+		}
+		if !b.down.Load() && (healthy == nil || s > bestHealthy) {
+			healthy, bestHealthy = b, s
+		}
+	}
+	if healthy == nil || healthy == preferred {
+		return preferred, nil
+	}
+	return healthy, preferred
+}
+
+// run probes the backends every interval until ctx is done.
+func (p *backendPool) run(ctx context.Context) {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p.probe(ctx)
+		}
+	}
+}
+
+// probe marks each backend up or down by whether it answers /api/version.
+func (p *backendPool) probe(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.h.probeUpstream(ctx, b.url)
+			if was := b.down.Swap(err != nil); was != (err != nil) {
+				if err != nil {
+					p.h.logger.Warn("backend down", "backend", b.label, "error", err)
+				} else {
+					p.h.logger.Info("backend up", "backend", b.label)
+				}
+			}
+			up := 1.0
+			if err != nil {
+				up = 0
+			}
+			p.h.metrics.BackendUp.WithLabelValues(b.label).Set(up)
+		}()
+	}
+	wg.Wait()
+}
+
+// inspectBackend sends a request naming a model to its backend, counting
+// where it went and whether it spilled over from a backend that is down.
+// Requests without a model stay on the upstream, as do those a registered
+// inspector already sent elsewhere.
+func (h *Handler) inspectBackend(_ context.Context, req *ParsedRequest) error {
+	if h.backends == nil || req.Model == modelUnknown || req.Model == modelNone || req.Upstream != req.ri.upstream {
+		return nil
+	}
+	b, skipped := h.backends.pick(req.Model)
+	req.Upstream = b.url
+	h.metrics.BackendRequests.WithLabelValues(b.label, req.Model).Inc()
+	if skipped != nil {
+		h.metrics.BackendSpillover.WithLabelValues(skipped.label, req.Model).Inc()
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// countingBackend is an Ollama stand-in that counts the generate requests it
+// served; the upstream it returns is its URL.
+func countingBackend(t *testing.T, hits *atomic.Int32) *url.URL {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/version" {
+			_, _ = fmt.Fprint(w, `{"version":"0.5.0"}`)
+			return
+		}
+		hits.Add(1)
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return u
+}
+
+func generateModel(h *Handler, model string) int {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate",
+		strings.NewReader(`{"model":"`+model+`","stream":false}`)))
+	return rr.Code
+}
+
+func TestAffinity_ModelStaysOnOneBackend(t *testing.T) {
+	var hitsA, hitsB, upstreamHits atomic.Int32
+	a, b := countingBackend(t, &hitsA), countingBackend(t, &hitsB)
+	h := newTestHandlerWithConfig(t, countingBackend(t, &upstreamHits).String(), Config{Backends: []*url.URL{a, b}})
+
+	for i := range 20 {
+		model := fmt.Sprintf("model-%d", i)
+		for range 3 {
+			if code := generateModel(h, model); code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", code)
+			}
+		}
+		onA := testutil.ToFloat64(h.metrics.BackendRequests.WithLabelValues(a.Host, model))
+		onB := testutil.ToFloat64(h.metrics.BackendRequests.WithLabelValues(b.Host, model))
+		if onA+onB != 3 || (onA != 0 && onB != 0) {
+			t.Errorf("%s: expected all 3 requests on one backend, got %v and %v", model, onA, onB)
+		}
+	}
+	if hitsA.Load() == 0 || hitsB.Load() == 0 {
+		t.Errorf("expected models spread over both backends, got %d and %d", hitsA.Load(), hitsB.Load())
+	}
+	if upstreamHits.Load() != 0 {
+		t.Errorf("expected no model requests on the upstream, got %d", upstreamHits.Load())
+	}
+}
+
+func TestAffinity_RemovingABackendMovesOnlyItsModels(t *testing.T) {
+	pool := func(hosts ...string) *backendPool {
+		p := &backendPool{everywhere: map[string]bool{}}
+		for _, host := range hosts {
+			p.backends = append(p.backends, &backend{url: &url.URL{Scheme: "http", Host: host}, label: host})
+		}
+		return p
+	}
+	full, reduced := pool("a:1", "b:1", "c:1"), pool("a:1", "c:1")
+	moved := 0
+	for i := range 300 {
+		model := fmt.Sprintf("model-%d", i)
+		before, _ := full.pick(model)
+		after, _ := reduced.pick(model)
+		if before.label != "b:1" && before.label != after.label {
+			t.Fatalf("%s moved from %s to %s though its backend stayed", model, before.label, after.label)
+		}
+		if before.label == "b:1" {
+			moved++
+		}
+	}
+	if moved == 0 || moved == 300 {
+		t.Errorf("expected some models on the removed backend, got %d of 300", moved)
+	}
+}
+
+func TestAffinity_SpillsOverWhenPreferredIsDown(t *testing.T) {
+	var hitsA, hitsB, upstreamHits atomic.Int32
+	a, b := countingBackend(t, &hitsA), countingBackend(t, &hitsB)
+	h := newTestHandlerWithConfig(t, countingBackend(t, &upstreamHits).String(), Config{Backends: []*url.URL{a, b}})
+
+	preferred, _ := h.backends.pick("m")
+	preferred.down.Store(true)
+	if code := generateModel(h, "m"); code != http.StatusOK {
+		t.Fatalf("expected 200 from the other backend, got %d", code)
+	}
+	if got := testutil.ToFloat64(h.metrics.BackendSpillover.WithLabelValues(preferred.label, "m")); got != 1 {
+		t.Errorf("expected one spillover from %s, got %v", preferred.label, got)
+	}
+	if got := testutil.ToFloat64(h.metrics.BackendRequests.WithLabelValues(preferred.label, "m")); got != 0 {
+		t.Errorf("expected nothing placed on the down backend, got %v", got)
+	}
+}
+
+func TestAffinity_EverywhereModelsRoundRobin(t *testing.T) {
+	var hitsA, hitsB, upstreamHits atomic.Int32
+	a, b := countingBackend(t, &hitsA), countingBackend(t, &hitsB)
+	h := newTestHandlerWithConfig(t, countingBackend(t, &upstreamHits).String(),
+		Config{Backends: []*url.URL{a, b}, AffinityEverywhere: []string{"embed"}})
+
+	for range 4 {
+		generateModel(h, "embed")
+	}
+	if hitsA.Load() != 2 || hitsB.Load() != 2 {
+		t.Errorf("expected 2 requests on each backend, got %d and %d", hitsA.Load(), hitsB.Load())
+	}
+}
+
+func TestAffinity_ProbeMarksBackendDown(t *testing.T) {
+	var hitsA, upstreamHits atomic.Int32
+	a := countingBackend(t, &hitsA)
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL, _ := url.Parse(dead.URL)
+	dead.Close()
+	h := newTestHandlerWithConfig(t, countingBackend(t, &upstreamHits).String(),
+		Config{Backends: []*url.URL{a, deadURL}, BackendHealthInterval: 10 * time.Millisecond})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(h.metrics.BackendUp.WithLabelValues(deadURL.Host)) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the dead backend marked down")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(h.metrics.BackendUp.WithLabelValues(a.Host)); got != 1 {
+		t.Errorf("expected the live backend up, got %v", got)
+	}
+	for i := range 10 {
+		generateModel(h, fmt.Sprintf("model-%d", i))
+	}
+	if hitsA.Load() != 10 {
+		t.Errorf("expected every model on the live backend, got %d", hitsA.Load())
+	}
+}
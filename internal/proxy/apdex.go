@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Apdex zones as used for the "zone" label of ollama_proxy_apdex_requests_total.
+const (
+	apdexSatisfied  = "satisfied"
+	apdexTolerating = "tolerating"
+	apdexFrustrated = "frustrated"
+)
+
+// endpointClass groups request paths into the coarse classes that per-class
+// settings (e.g. -apdex-targets) are keyed by: generate, chat, embed or other.
+func endpointClass(endpoint string) string {
+	switch {
+	case strings.HasSuffix(endpoint, "/api/generate"), strings.HasSuffix(endpoint, "/v1/completions"):
+		return "generate"
+	case strings.HasSuffix(endpoint, "/api/chat"), strings.HasSuffix(endpoint, "/v1/chat/completions"):
+		return "chat"
+	case strings.HasSuffix(endpoint, "/api/embed"), strings.HasSuffix(endpoint, "/api/embeddings"),
+		strings.HasSuffix(endpoint, "/v1/embeddings"):
+		return "embed"
+	default:
+		return "other"
+	}
+}
+
+// ParseDurationMap parses a comma-separated list of key=duration pairs such as
+// "chat=8s,embed=500ms". An empty string yields an empty map.
+func ParseDurationMap(s string) (map[string]time.Duration, error) {
+	out := map[string]time.Duration{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid entry %q: want key=duration", item)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration for %q: %w", k, err)
+		}
+		out[strings.TrimSpace(k)] = d
+	}
+	return out, nil
+}
+
+// apdexTarget returns the satisfaction threshold T for endpoint, or 0 when
+// Apdex tracking is disabled.
+func (h *Handler) apdexTarget(endpoint string) time.Duration {
+	if t, ok := h.cfg.ApdexTargets[endpointClass(endpoint)]; ok {
+		return t
+	}
+	return h.cfg.ApdexTarget
+}
+
+// apdexZone classifies latency against target: ≤T satisfied, ≤4T tolerating,
+// anything slower (or any failed request) frustrated.
+func apdexZone(latency, target time.Duration, failed bool) string {
+	switch {
+	case failed:
+		return apdexFrustrated
+	case latency <= target:
+		return apdexSatisfied
+	case latency <= 4*target:
+		return apdexTolerating
+	default:
+		return apdexFrustrated
+	}
+}
+
+// observeApdex records one request in the Apdex counters. latency should be
+// the user-perceived latency: total duration for buffered responses and
+// time-to-first-token for streams.
+func (h *Handler) observeApdex(endpoint, model string, latency time.Duration, failed bool) {
+	target := h.apdexTarget(endpoint)
+	if target <= 0 {
+		return
+	}
+	h.metrics.Apdex.WithLabelValues(model, apdexZone(latency, target, failed)).Inc()
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestApdexZone(t *testing.T) {
+	target := time.Second
+	cases := []struct {
+		latency time.Duration
+		failed  bool
+		want    string
+	}{
+		{500 * time.Millisecond, false, apdexSatisfied},
+		{time.Second, false, apdexSatisfied},
+		{3 * time.Second, false, apdexTolerating},
+		{4 * time.Second, false, apdexTolerating},
+		{5 * time.Second, false, apdexFrustrated},
+		{10 * time.Millisecond, true, apdexFrustrated},
+	}
+	for _, c := range cases {
+		if got := apdexZone(c.latency, target, c.failed); got != c.want {
+			t.Errorf("apdexZone(%v, failed=%v) = %q, want %q", c.latency, c.failed, got, c.want)
+		}
+	}
+}
+
+func TestParseDurationMap(t *testing.T) {
+	m, err := ParseDurationMap("chat=8s, embed=500ms")
+	if err != nil {
+		t.Fatalf("ParseDurationMap: %v", err)
+	}
+	if m["chat"] != 8*time.Second || m["embed"] != 500*time.Millisecond {
+		t.Errorf("unexpected map: %v", m)
+	}
+	if m, err := ParseDurationMap(""); err != nil || len(m) != 0 {
+		t.Errorf("expected empty map for empty input, got %v, %v", m, err)
+	}
+	for _, bad := range []string{"chat", "chat=fast", "=1s"} {
+		if _, err := ParseDurationMap(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestApdex_PerClassOverride(t *testing.T) {
+	h := newTestHandlerWithConfig(t, "http://unused", Config{
+		ApdexTarget:  5 * time.Second,
+		ApdexTargets: map[string]time.Duration{"embed": 100 * time.Millisecond},
+	})
+	if got := h.apdexTarget("/api/embed"); got != 100*time.Millisecond {
+		t.Errorf("embed target = %v, want 100ms", got)
+	}
+	if got := h.apdexTarget("/api/chat"); got != 5*time.Second {
+		t.Errorf("chat target = %v, want default 5s", got)
+	}
+}
+
+func TestApdex_NonStreamSatisfied(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"ok","done":true}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ApdexTarget: 5 * time.Second})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate",
+		strings.NewReader(`{"model":"llama3","stream":false}`))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := testutil.ToFloat64(h.metrics.Apdex.WithLabelValues("llama3", apdexSatisfied)); got != 1 {
+		t.Errorf("expected 1 satisfied request, got %v", got)
+	}
+}
+
+func TestApdex_StreamUsesTimeToFirstToken(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		flusher.Flush()
+		time.Sleep(300 * time.Millisecond)
+		_, _ = fmt.Fprintln(w, `{"response":"","done":true,"eval_count":1}`)
+	}))
+	defer upstream.Close()
+
+	// Total duration exceeds 4T, but the first token arrives well within T.
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ApdexTarget: 50 * time.Millisecond})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate",
+		strings.NewReader(`{"model":"llama3","stream":true}`))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := testutil.ToFloat64(h.metrics.Apdex.WithLabelValues("llama3", apdexSatisfied)); got != 1 {
+		t.Errorf("expected stream to be satisfied by TTFT, got %v", got)
+	}
+}
+
+func TestApdex_UpstreamFailureFrustrated(t *testing.T) {
+	h := newTestHandlerWithConfig(t, "http://127.0.0.1:1", Config{ApdexTarget: time.Minute})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate",
+		strings.NewReader(`{"model":"x","stream":false}`))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := testutil.ToFloat64(h.metrics.Apdex.WithLabelValues("x", apdexFrustrated)); got != 1 {
+		t.Errorf("expected 1 frustrated request, got %v", got)
+	}
+}
+
+func TestApdex_DisabledRecordsNothing(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"done":true}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	req := httptest.NewRequest(http.MethodPost, "/api/generate",
+		strings.NewReader(`{"model":"llama3","stream":false}`))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if n := testutil.CollectAndCount(h.metrics.Apdex); n != 0 {
+		t.Errorf("expected no Apdex series when disabled, got %d", n)
+	}
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// headerAPIKey is the header a client may present its API key in, instead
+// of Authorization: Bearer.
+const headerAPIKey = "X-Api-Key"
+
+// APIKey is a key a client presents to use the proxy, and the name its
+// requests are counted under in the client label. The key itself never
+// leaves the proxy: it is not logged, exported or forwarded upstream.
+type APIKey struct {
+	Name string
+	Key  string
+}
+
+// apiKeySet looks keys up by their SHA-256, so that a lookup takes the same
+// time whichever key, if any, it matches.
+type apiKeySet map[[sha256.Size]byte]string
+
+// ParseAPIKeys parses "name:key" lines; blank lines and lines starting with
+// # are skipped. Names and keys must be unique, and there must be at least
+// one key: an empty file would otherwise switch authentication off.
+func ParseAPIKeys(data []byte) ([]APIKey, error) {
+	var out []APIKey
+	names, keys := map[string]bool{}, map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, key, ok := strings.Cut(line, ":")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		// Keys are secrets, so errors name the line and the client only.
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("line %d: want name:key", n)
+		}
+		if strings.ContainsAny(name, " \t") || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: name and key must not contain spaces", n)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("line %d: client %q listed twice", n, name)
+		}
+		if keys[key] {
+			return nil, fmt.Errorf("line %d: client %q has the key of another client", n, name)
+		}
+		names[name], keys[key] = true, true
+		out = append(out, APIKey{Name: name, Key: key})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no keys")
+	}
+	return out, nil
+}
+
+// LoadAPIKeys reads and parses an API keys file; see ParseAPIKeys.
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseAPIKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// SetAPIKeys replaces the API keys, e.g. after the keys file changed.
+// Requests already admitted keep their client; no keys switches the check
+// off.
+func (h *Handler) SetAPIKeys(keys []APIKey) {
+	if len(keys) == 0 {
+		h.apiKeys.Store(nil)
+		return
+	}
+	set := make(apiKeySet, len(keys))
+	for _, k := range keys {
+		set[sha256.Sum256([]byte(k.Key))] = k.Name
+	}
+	h.apiKeys.Store(&set)
+}
+
+type clientKey struct{}
+
+// clientName is the API key name r was admitted with, "" without API keys.
+func clientName(r *http.Request) string {
+	name, _ := r.Context().Value(clientKey{}).(string)
+	return name
+}
+
+// presentedKey returns the API key r carries in X-Api-Key or as a bearer
+// token, the header it came in, and the failure mode when it has none.
+func presentedKey(r *http.Request) (key, header, mode string) {
+	if k := strings.TrimSpace(r.Header.Get(headerAPIKey)); k != "" {
+		return k, headerAPIKey, ""
+	}
+	token, mode := bearerToken(r)
+	return token, "Authorization", mode
+}
+
+// authenticateClient checks the API key of a request when keys are set.
+// It returns r carrying the client's name, with the key's header removed so
+// that it is not forwarded upstream, or nil after answering 401. Canary
+// probes need no key.
+func (h *Handler) authenticateClient(w http.ResponseWriter, r *http.Request) *http.Request {
+	set := h.apiKeys.Load()
+	if set == nil || isCanary(r) {
+		return r
+	}
+	key, header, mode := presentedKey(r)
+	name, ok := "", false
+	if mode == "" {
+		name, ok = (*set)[sha256.Sum256([]byte(key))]
+		if !ok {
+			mode = authInvalid
+		}
+	}
+	if !ok {
+		h.metrics.ClientAuthFailures.WithLabelValues(mode).Inc()
+		h.logger.Warn("client authentication failed", "request_id", RequestID(r.Context()), "endpoint", r.URL.Path, "reason", mode,
+			"client_ip", extractClientIP(r), "user_agent", r.UserAgent())
+		writeUnauthorized(w, bearerChallenge(mode), mode, clientAuthMessages[mode])
+		return nil
+	}
+	r = r.WithContext(context.WithValue(r.Context(), clientKey{}, name))
+	r.Header.Del(header)
+	return r
+}
+
+// clientAuthMessages explain each authentication failure mode to clients.
+var clientAuthMessages = map[string]string{
+	authMissing:   "an API key is required: send Authorization: Bearer <key> or X-Api-Key",
+	authMalformed: "malformed Authorization header: expected Bearer <key>",
+	authInvalid:   "invalid API key",
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	got, err := ParseAPIKeys([]byte("# clients\nweb: k-web\n\nbatch:k-batch\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []APIKey{{Name: "web", Key: "k-web"}, {Name: "batch", Key: "k-batch"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	for _, bad := range []string{"", "# none\n", "web", "web:", ":k", "web:k1\nweb:k2", "web:k\nbatch:k", "web:k 1"} {
+		if _, err := ParseAPIKeys([]byte(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if _, err := ParseAPIKeys([]byte("web:k-secret\nbatch:k-secret")); err == nil || strings.Contains(err.Error(), "k-secret") {
+		t.Errorf("expected a shared key rejected without echoing it, got %v", err)
+	}
+}
+
+// recordingUpstream records the client headers of the last request it got.
+func recordingUpstream(t *testing.T) (*httptest.Server, func() http.Header) {
+	t.Helper()
+	var mu sync.Mutex
+	var last http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		last = r.Header.Clone()
+		mu.Unlock()
+		_, _ = fmt.Fprintln(w, `{"response":"ok","done":true,"prompt_eval_count":2,"eval_count":3}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		return last
+	}
+}
+
+func TestAPIKeys_Refused(t *testing.T) {
+	upstream, _ := recordingUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{APIKeys: []APIKey{{Name: "web", Key: "secret-web"}}})
+	var logs bytes.Buffer
+	h.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	for _, tc := range []struct {
+		name, header, value, mode string
+	}{
+		{"missing", "", "", authMissing},
+		{"malformed", "Authorization", "Basic d2ViOnNlY3JldA==", authMalformed},
+		{"wrong bearer", "Authorization", "Bearer secret-wrong", authInvalid},
+		{"wrong header", headerAPIKey, "secret-wrong", authInvalid},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`))
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get(headerAuthError) != tc.mode {
+			t.Errorf("%s: expected 401 %s, got %d %q", tc.name, tc.mode, rr.Code, rr.Header().Get(headerAuthError))
+		}
+		if !strings.HasPrefix(rr.Header().Get(headerAuthenticate), "Bearer") {
+			t.Errorf("%s: expected a bearer challenge, got %q", tc.name, rr.Header().Get(headerAuthenticate))
+		}
+	}
+	if got := testutil.ToFloat64(h.metrics.ClientAuthFailures.WithLabelValues(authInvalid)); got != 2 {
+		t.Errorf("expected two invalid keys counted, got %v", got)
+	}
+	if got := testutil.CollectAndCount(h.metrics.ReqTotal); got != 0 {
+		t.Errorf("expected refused requests not counted as requests, got %d series", got)
+	}
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("expected no key in the logs, got %s", logs.String())
+	}
+}
+
+func TestAPIKeys_ClientLabel(t *testing.T) {
+	upstream, lastHeaders := recordingUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{APIKeys: []APIKey{
+		{Name: "web", Key: "secret-web"},
+		{Name: "batch", Key: "secret-batch"},
+	}})
+	var logs bytes.Buffer
+	h.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	for _, hdr := range []struct{ name, value string }{
+		{"Authorization", "Bearer secret-web"},
+		{headerAPIKey, "secret-batch"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+		req.Header.Set(hdr.name, hdr.value)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", hdr.name, rr.Code)
+		}
+		if got := lastHeaders().Get(hdr.name); got != "" {
+			t.Errorf("expected the key's %s header not forwarded upstream, got %q", hdr.name, got)
+		}
+	}
+
+	label := upstreamLabel(h.currentUpstream())
+	for _, client := range []string{"web", "batch"} {
+		if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "200", "false", originUpstream, label, client)); got != 1 {
+			t.Errorf("expected one request counted for %s, got %v", client, got)
+		}
+		if got := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues("/api/generate", "m", label, client)); got != 3 {
+			t.Errorf("expected %s's completion tokens counted, got %v", client, got)
+		}
+		if got := histogramCount(t, h.metrics.ReqDuration.WithLabelValues("/api/generate", "m", "false", client)); got != 1 {
+			t.Errorf("expected %s's duration observed, got %d", client, got)
+		}
+	}
+	if !strings.Contains(logs.String(), `"client":"web"`) {
+		t.Errorf("expected the client named in the request log, got %s", logs.String())
+	}
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("expected no key in the logs, got %s", logs.String())
+	}
+}
+
+func TestAPIKeys_Reload(t *testing.T) {
+	upstream, _ := recordingUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{APIKeys: []APIKey{{Name: "web", Key: "old"}}})
+	send := func(key string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`))
+		req.Header.Set(headerAPIKey, key)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	h.SetAPIKeys([]APIKey{{Name: "web", Key: "new"}})
+	if got := send("old"); got != http.StatusUnauthorized {
+		t.Errorf("expected the replaced key refused, got %d", got)
+	}
+	if got := send("new"); got != http.StatusOK {
+		t.Errorf("expected the new key accepted, got %d", got)
+	}
+	h.SetAPIKeys(nil)
+	if got := send(""); got != http.StatusOK {
+		t.Errorf("expected no key needed once the keys are cleared, got %d", got)
+	}
+}
+
+func TestTenantOf_PrefersClient(t *testing.T) {
+	h := newTestHandlerWithConfig(t, "http://127.0.0.1:1", Config{
+		TenantHeader: "X-Tenant",
+		APIKeys:      []APIKey{{Name: "web", Key: "k"}},
+	})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+	req.Header.Set("X-Tenant", "team-a")
+	req.Header.Set(headerAPIKey, "k")
+	r := h.authenticateClient(rr, req)
+	if r == nil {
+		t.Fatalf("expected the key accepted, got %d", rr.Code)
+	}
+	if got := h.tenantOf(r); got != "web" {
+		t.Errorf("expected the key's name as tenant, got %q", got)
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// Authentication failure modes: the "reason" field of a 401's JSON body,
+// its X-Auth-Error header and the mode label of auth_failures_total. They
+// are deliberately coarse: a wrong credential is invalid_credential whether
+// or not it resembles a valid one.
+const (
+	authMissing   = "missing_credential" // no Authorization header
+	authMalformed = "malformed_header"   // not "Bearer <token>"
+	authInvalid   = "invalid_credential" // a bearer token that is not accepted
+)
+
+// authFailureModes are pre-initialised to zero like the policy reasons.
+var authFailureModes = []string{authMissing, authMalformed, authInvalid}
+
+// authRealm is the realm of the proxy's WWW-Authenticate challenges.
+const authRealm = "ollama-proxy"
+
+// bearerToken returns the token of r's "Authorization: Bearer <token>"
+// header, or the failure mode when there is none. The scheme is matched
+// case-insensitively.
+func bearerToken(r *http.Request) (string, string) {
+	v := r.Header.Get("Authorization")
+	if v == "" {
+		return "", authMissing
+	}
+	scheme, token, ok := strings.Cut(v, " ")
+	token = strings.TrimSpace(token)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" || strings.ContainsAny(token, " \t") {
+		return "", authMalformed
+	}
+	return token, ""
+}
+
+// checkBearer reports the failure mode of r against want, or "" when r
+// carries it.
+func checkBearer(r *http.Request, want string) string {
+	token, mode := bearerToken(r)
+	if mode != "" {
+		return mode
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return authInvalid
+	}
+	return ""
+}
+
+// authFailed answers a request that failed authentication with 401 and a
+// WWW-Authenticate challenge in the form of RFC 6750.
+func (h *Handler) authFailed(w http.ResponseWriter, mode, message string) {
+	h.metrics.AuthFailures.WithLabelValues(mode).Inc()
+	writeUnauthorized(w, bearerChallenge(mode), mode, message)
+}
+
+// bearerChallenge is the WWW-Authenticate challenge for mode: a missing
+// credential gets a bare challenge, the others an error code.
+func bearerChallenge(mode string) string {
+	challenge := `Bearer realm="` + authRealm + `"`
+	switch mode {
+	case authMalformed:
+		challenge += `, error="invalid_request", error_description="expected Authorization: Bearer <token>"`
+	case authInvalid:
+		challenge += `, error="invalid_token"`
+	}
+	return challenge
+}
+
+// writeUnauthorized writes the 401 of a failure mode with its challenge.
+func writeUnauthorized(w http.ResponseWriter, challenge, mode, message string) {
+	w.Header().Set(headerAuthenticate, challenge)
+	w.Header().Set(headerAuthError, mode)
+	writeAdminJSON(w, http.StatusUnauthorized, proxyapi.ErrorResponse{Error: message, Reason: mode})
+}
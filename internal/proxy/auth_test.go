@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAuth_FailureModes(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	mux := http.NewServeMux()
+	h.RegisterAdmin(mux, "secret")
+
+	cases := []struct {
+		name          string
+		authorization string
+		mode          string
+		challenge     string
+	}{
+		{"missing", "", authMissing, `Bearer realm="ollama-proxy"`},
+		{"wrong scheme", "Basic c2VjcmV0", authMalformed, `error="invalid_request"`},
+		{"no token", "Bearer ", authMalformed, `error="invalid_request"`},
+		{"token with spaces", "Bearer sec ret", authMalformed, `error="invalid_request"`},
+		{"unknown token", "Bearer nope", authInvalid, `error="invalid_token"`},
+		{"prefix of the token", "Bearer secre", authInvalid, `error="invalid_token"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/models", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("expected 401, got %d", rr.Code)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body["reason"] != tc.mode || body["error"] == "" {
+				t.Errorf("expected reason %s and an error message, got %q", tc.mode, rr.Body.String())
+			}
+			if got := rr.Header().Get("X-Auth-Error"); got != tc.mode {
+				t.Errorf("expected X-Auth-Error %s, got %q", tc.mode, got)
+			}
+			if got := rr.Header().Get("WWW-Authenticate"); !strings.Contains(got, tc.challenge) {
+				t.Errorf("expected a challenge containing %s, got %q", tc.challenge, got)
+			}
+		})
+	}
+	for mode, want := range map[string]float64{authMissing: 1, authMalformed: 3, authInvalid: 2} {
+		if got := testutil.ToFloat64(h.metrics.AuthFailures.WithLabelValues(mode)); got != want {
+			t.Errorf("expected %v %s failures, got %v", want, mode, got)
+		}
+	}
+}
+
+func TestAuth_AcceptsBearerCaseInsensitively(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	mux := http.NewServeMux()
+	h.RegisterAdmin(mux, "secret")
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/models", nil)
+	req.Header.Set("Authorization", "bearer secret")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	if rr.Header().Get("WWW-Authenticate") != "" || rr.Header().Get("X-Auth-Error") != "" {
+		t.Errorf("expected no auth headers on success, got %v", rr.Header())
+	}
+}
+
+func TestAuth_EmptyTokenAcceptsNothing(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	mux := http.NewServeMux()
+	h.RegisterAdmin(mux, "")
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/models", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Reasons for ollama_proxy_requests_rejected_total: requests refused while
+// their body was read, before any policy saw them.
+const rejectBodyTooLarge = "body_too_large" // over MaxRequestBytes, answered 413
+
+var requestRejectReasons = []string{rejectBodyTooLarge}
+
+// limitBody caps r's body at MaxRequestBytes when it is set, so a client
+// cannot make readBody hold more than that in memory.
+func (h *Handler) limitBody(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.MaxRequestBytes > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, h.cfg.MaxRequestBytes)
+	}
+}
+
+func isBodyTooLarge(err error) bool {
+	var mbe *http.MaxBytesError
+	return errors.As(err, &mbe)
+}
+
+// requestTooLarge answers a body over MaxRequestBytes with 413, naming the
+// limit so that a client sending large images knows what it is up against.
+func (h *Handler) requestTooLarge(w http.ResponseWriter, r *http.Request, reqID, sessionID, endpoint, clientIP string, start time.Time, read int64) {
+	limit := formatBytes(h.cfg.MaxRequestBytes)
+	h.metrics.RequestsRejected.WithLabelValues(rejectBodyTooLarge).Inc()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":       "request body larger than the proxy's limit of " + limit,
+		"reason":      rejectBodyTooLarge,
+		"limit_bytes": h.cfg.MaxRequestBytes,
+	})
+	h.recordError(reqID, sessionID, endpoint, r, start, clientIP,
+		http.StatusRequestEntityTooLarge, read, 0, "read body: larger than "+limit)
+}
+
+// forwardTooLarge answers with 413 a request whose body went over
+// MaxRequestBytes while it was being sent upstream.
+func (h *Handler) forwardTooLarge(w http.ResponseWriter, ri *reqInfo, errMsg string) {
+	h.metrics.RequestsRejected.WithLabelValues(rejectBodyTooLarge).Inc()
+	h.upstreamFailed(w, ri, http.StatusRequestEntityTooLarge,
+		"request body larger than the proxy's limit of "+formatBytes(h.cfg.MaxRequestBytes), errMsg)
+}
+
+// formatBytes renders n in the largest binary unit it reaches, e.g.
+// 100 MiB or 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, exp := float64(n)/unit, 0
+	for ; v >= unit && exp < 3; exp++ {
+		v /= unit
+	}
+	return fmt.Sprintf("%.4g %ciB", v, "KMGT"[exp])
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMaxRequestBytes(t *testing.T) {
+	var forwarded int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		_, _ = w.Write([]byte(`{"response":"ok","done":true}`))
+	}))
+	defer upstream.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MaxRequestBytes: 1 << 10})
+
+	image := strings.Repeat("A", 900)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false,"images":["`+image+`"]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected a body under the limit forwarded, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false,"images":["`+image+image+`"]}`)))
+	var body struct {
+		Error      string `json:"error"`
+		Reason     string `json:"reason"`
+		LimitBytes int64  `json:"limit_bytes"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(body.Error, "1 KiB") || body.Reason != rejectBodyTooLarge || body.LimitBytes != 1<<10 {
+		t.Fatalf("expected 413 naming the limit, got %d %s", w.Code, w.Body)
+	}
+	if forwarded != 1 {
+		t.Errorf("expected the oversized body not forwarded, upstream saw %d requests", forwarded)
+	}
+	if got := testutil.ToFloat64(h.metrics.RequestsRejected.WithLabelValues(rejectBodyTooLarge)); got != 1 {
+		t.Errorf("expected one body_too_large rejection, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", modelUnknown, "413", "false", originProxy, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the 413 in requests_total, got %v", got)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		512:       "512 B",
+		1 << 10:   "1 KiB",
+		100 << 20: "100 MiB",
+		3 << 29:   "1.5 GiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the request
+// and upstream duration histograms unless MetricsOptions sets others. They
+// reach ten minutes: generations of 30 to 300 seconds are routine, and with
+// Prometheus' default buckets, which stop at 10s, they all land in +Inf.
+var DefaultDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600}
+
+// ParseBuckets parses a comma-separated list of histogram upper bounds in
+// seconds such as "0.5,1,5,30,120". The bounds must be positive and strictly
+// increasing.
+func ParseBuckets(s string) ([]float64, error) {
+	var out []float64
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		b, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: want seconds as a number", item)
+		}
+		if b <= 0 {
+			return nil, fmt.Errorf("invalid bucket %q: must be positive", item)
+		}
+		if n := len(out); n > 0 && b <= out[n-1] {
+			return nil, fmt.Errorf("bucket %g after %g: buckets must be strictly increasing", b, out[n-1])
+		}
+		out = append(out, b)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no buckets in %q", s)
+	}
+	return out, nil
+}
+
+// MetricsOptions tune the metrics NewMetricsWithOptions creates.
+type MetricsOptions struct {
+	// Namespace prefixes every metric name; empty is DefaultMetricsNamespace.
+	Namespace string
+	// DurationBuckets are the upper bounds of request_duration_seconds,
+	// request_duration_adjusted_seconds, request_phase_seconds and the
+	// upstream_*_duration_seconds histograms; empty is
+	// DefaultDurationBuckets.
+	DurationBuckets []float64
+	// DurationMode is what request_duration_seconds and its adjusted form
+	// measure to, one of DurationModes; empty is DurationClientWrite. Its
+	// help says which.
+	DurationMode string
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseBuckets(t *testing.T) {
+	got, err := ParseBuckets(" 0.5, 1,30 ,600")
+	if err != nil {
+		t.Fatalf("ParseBuckets: %v", err)
+	}
+	if want := []float64{0.5, 1, 30, 600}; !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	for _, bad := range []string{"", " , ", "1,fast", "0,1", "-1,5", "1,30,10", "1,1,2"} {
+		if _, err := ParseBuckets(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestDefaultDurationBucketsAreValid(t *testing.T) {
+	if !slices.IsSorted(DefaultDurationBuckets) || DefaultDurationBuckets[len(DefaultDurationBuckets)-1] < 300 {
+		t.Errorf("expected increasing buckets reaching generation times, got %v", DefaultDurationBuckets)
+	}
+}
+
+func TestNewMetricsWithOptions_DurationBuckets(t *testing.T) {
+	bounds := func(m *Metrics) []float64 {
+		m.ReqDuration.WithLabelValues("/api/generate", "m", "true", "").Observe(1)
+		var pb dto.Metric
+		_ = m.ReqDuration.WithLabelValues("/api/generate", "m", "true", "").(prometheus.Metric).Write(&pb)
+		var out []float64
+		for _, b := range pb.GetHistogram().GetBucket() {
+			out = append(out, b.GetUpperBound())
+		}
+		return out
+	}
+	if got := bounds(NewMetrics(prometheus.NewRegistry())); !slices.Equal(got, DefaultDurationBuckets) {
+		t.Errorf("expected the default buckets, got %v", got)
+	}
+	custom := []float64{1, 10, 100}
+	m := NewMetricsWithOptions(prometheus.NewRegistry(), MetricsOptions{DurationBuckets: custom})
+	if got := bounds(m); !slices.Equal(got, custom) {
+		t.Errorf("expected the configured buckets, got %v", got)
+	}
+	m.UpstreamEvalDuration.WithLabelValues("/api/generate", "m").Observe(1)
+	var pb dto.Metric
+	_ = m.UpstreamEvalDuration.WithLabelValues("/api/generate", "m").(prometheus.Metric).Write(&pb)
+	if n := len(pb.GetHistogram().GetBucket()); n != len(custom) {
+		t.Errorf("expected the upstream durations to use the configured buckets, got %d buckets", n)
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// A request has two legs, client ↔ proxy and proxy ↔ upstream, and the
+// proxy may change the bytes between them: inspectors rewrite request
+// bodies, responses are decompressed or compressed, streams gain a final
+// newline. Each leg is counted as it is on the wire, body bytes only.
+
+// clientWriter counts the body bytes written to the client, and notes the
+// status sent and when the first body byte went out for the access log.
+type clientWriter struct {
+	http.ResponseWriter
+	n      atomic.Int64
+	status atomic.Int32
+	first  atomic.Int64 // unix nanoseconds, 0 until a body byte is written
+}
+
+func (c *clientWriter) WriteHeader(code int) {
+	if code >= 200 {
+		c.status.CompareAndSwap(0, int32(code))
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *clientWriter) Write(p []byte) (int, error) {
+	c.status.CompareAndSwap(0, http.StatusOK)
+	n, err := c.ResponseWriter.Write(p)
+	if n > 0 {
+		c.first.CompareAndSwap(0, time.Now().UnixNano())
+	}
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the Flusher underneath.
+func (c *clientWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// upstreamBody counts the body bytes read from an upstream response, before
+// any decompression.
+type upstreamBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *upstreamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// servedByUpstream reports whether a response of the given cache result
+// came over the upstream leg: uncached, or the fetch that filled the cache.
+func servedByUpstream(cacheResult string) bool {
+	return cacheResult != cacheHit && cacheResult != cacheCoalesced
+}
+
+// countUpstreamLeg counts a request sent to the upstream and wraps its
+// response body to count what comes back.
+func (h *Handler) countUpstreamLeg(ri *reqInfo, sent int64, resp *http.Response) {
+	ri.upstreamOut = sent
+	ri.upstreamIn = &upstreamBody{ReadCloser: resp.Body}
+	resp.Body = ri.upstreamIn
+	h.metrics.UpstreamBytesOut.WithLabelValues(ri.endpoint, ri.modelLabel, ri.streamLabel).Add(float64(sent))
+}
+
+// countLegs counts what was read from the upstream and written to the
+// client once the request is done, however it ended.
+func (h *Handler) countLegs(ri *reqInfo) {
+	if ri.upstreamIn != nil {
+		h.metrics.UpstreamBytesIn.WithLabelValues(ri.endpoint, ri.modelLabel, ri.streamLabel).Add(float64(ri.upstreamIn.n))
+	}
+	h.metrics.ClientBytesOut.WithLabelValues(ri.endpoint, ri.modelLabel, ri.streamLabel).Add(float64(ri.client.n.Load()))
+}
+
+// legAttrs are the log attributes of how much the proxy changed a request
+// and its response: upstream minus client bytes on the way in, client minus
+// upstream bytes on the way out. Requests that never reached the upstream
+// (rejections, cache hits) have none.
+func legAttrs(ri *reqInfo) []any {
+	if ri.upstreamIn == nil {
+		return nil
+	}
+	return []any{
+		"request_bytes_delta", ri.upstreamOut - ri.reqBytes,
+		"response_bytes_delta", ri.client.n.Load() - ri.upstreamIn.n,
+	}
+}
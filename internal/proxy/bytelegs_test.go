@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// legs returns the four byte counters of endpoint and model.
+func legs(h *Handler, endpoint, model, stream string) (clientIn, upstreamOut, upstreamIn, clientOut float64) {
+	m := h.metrics
+	return testutil.ToFloat64(m.ClientBytesIn.WithLabelValues(endpoint, model, stream)),
+		testutil.ToFloat64(m.UpstreamBytesOut.WithLabelValues(endpoint, model, stream)),
+		testutil.ToFloat64(m.UpstreamBytesIn.WithLabelValues(endpoint, model, stream)),
+		testutil.ToFloat64(m.ClientBytesOut.WithLabelValues(endpoint, model, stream))
+}
+
+func TestByteLegs_RewrittenRequestAndDecompressedResponse(t *testing.T) {
+	payload := `{"response":"hi","done":true,"eval_count":3,"prompt_eval_count":2}`
+	gz := gzipBytes([]byte(payload))
+	upstream := gzipUpstream(t, gz)
+	defer upstream.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{DecompressResponses: true})
+	h.Use(Hooks{Inspectors: []RequestInspector{RequestInspectorFunc(func(_ context.Context, req *ParsedRequest) error {
+		req.Body = []byte(`{"model":"m","stream":false,"system":"be brief"}`)
+		return nil
+	})}})
+
+	body := `{"model":"m","stream":false}`
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body))
+	req.Header.Set("Accept-Encoding", "identity")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	clientIn, upstreamOut, upstreamIn, clientOut := legs(h, "/api/generate", "m", "false")
+	if clientIn != float64(len(body)) || upstreamOut != float64(len(`{"model":"m","stream":false,"system":"be brief"}`)) {
+		t.Errorf("expected the rewritten request counted on the upstream leg only, got client %v upstream %v", clientIn, upstreamOut)
+	}
+	if upstreamIn != float64(len(gz)) || clientOut != float64(len(payload)) {
+		t.Errorf("expected %d gzip bytes in and %d plain bytes out, got %v and %v", len(gz), len(payload), upstreamIn, clientOut)
+	}
+}
+
+func TestByteLegs_CompressedStream(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{CompressResponses: true})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	_, _, upstreamIn, clientOut := legs(h, "/api/generate", "m", "true")
+	if clientOut != float64(rr.Body.Len()) {
+		t.Errorf("expected the compressed bytes sent counted, %d, got %v", rr.Body.Len(), clientOut)
+	}
+	if upstreamIn == 0 || upstreamIn == clientOut {
+		t.Errorf("expected the upstream leg counted apart from the client's, got %v in and %v out", upstreamIn, clientOut)
+	}
+}
+
+func TestByteLegs_CacheHitReadsNothingUpstream(t *testing.T) {
+	upstream, _ := countingUpstream(t, 0)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MetadataCacheTTL: time.Minute})
+	first, second := getTags(h), getTags(h)
+
+	_, upstreamOut, upstreamIn, clientOut := legs(h, "/api/tags", modelNone, "false")
+	if upstreamIn != float64(first.Body.Len()) || upstreamOut != 0 {
+		t.Errorf("expected only the fill read from the upstream, got %v in and %v out", upstreamIn, upstreamOut)
+	}
+	if clientOut != float64(first.Body.Len()+second.Body.Len()) {
+		t.Errorf("expected both responses counted to clients, got %v", clientOut)
+	}
+}
+
+func TestLegAttrs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ri   *reqInfo
+		want []any
+	}{
+		{"not forwarded", &reqInfo{client: &clientWriter{}}, nil},
+		{"forwarded", func() *reqInfo {
+			ri := &reqInfo{reqBytes: 30, upstreamOut: 50, upstreamIn: &upstreamBody{n: 200}, client: &clientWriter{}}
+			ri.client.n.Store(120)
+			return ri
+		}(), []any{"request_bytes_delta", int64(20), "response_bytes_delta", int64(-80)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := legAttrs(tc.ri)
+			if len(got) != len(tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("expected %v, got %v", tc.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestByteLegs_SpilledResponse(t *testing.T) {
+	h := newTestHandlerWithConfig(t, embedUpstream(t, 4096).URL, Config{SpillThreshold: 1024, SpillDir: t.TempDir()})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model":"m","input":"x"}`)))
+
+	_, _, upstreamIn, clientOut := legs(h, "/api/embed", "m", "false")
+	if upstreamIn != float64(rr.Body.Len()) || clientOut != upstreamIn {
+		t.Errorf("expected the spilled body counted on both legs, %d bytes, got %v in and %v out", rr.Body.Len(), upstreamIn, clientOut)
+	}
+}
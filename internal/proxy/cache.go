@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache results as used for the "result" label of ollama_proxy_cache_requests_total.
+const (
+	cacheHit       = "hit"
+	cacheMiss      = "miss"
+	cacheCoalesced = "coalesced" // waited on another request's in-flight upstream call
+)
+
+// cachedResponse is a fully buffered upstream response.
+type cachedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// toHTTP rebuilds an *http.Response that can be consumed like a live one.
+func (c *cachedResponse) toHTTP(req *http.Request) *http.Response {
+	return &http.Response{
+		StatusCode:    c.status,
+		Status:        http.StatusText(c.status),
+		Header:        c.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(c.body)),
+		ContentLength: int64(len(c.body)),
+		Request:       req,
+	}
+}
+
+type cacheEntry struct {
+	resp    *cachedResponse
+	expires time.Time
+}
+
+// cacheCall is an upstream fetch that concurrent requests for the same key share.
+type cacheCall struct {
+	done chan struct{}
+	resp *cachedResponse
+	err  error
+}
+
+// responseCache is a small TTL cache with single-flight population. Only 2xx
+// responses are stored, and entries are never served past their TTL.
+type responseCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	gen     uint64 // bumped by invalidate so in-flight fetches don't store stale data
+	entries map[string]cacheEntry
+	calls   map[string]*cacheCall
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		entries: map[string]cacheEntry{},
+		calls:   map[string]*cacheCall{},
+	}
+}
+
+// get returns the cached response for key, calling fetch at most once for all
+// concurrent callers on a miss. The fetch runs on its own, so a caller whose
+// ctx ends stops waiting with ctx's error while the others still get the
+// result.
+func (c *responseCache) get(ctx context.Context, key string, fetch func() (*cachedResponse, error)) (*cachedResponse, string, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		if time.Now().Before(e.expires) {
+			c.mu.Unlock()
+			return e.resp, cacheHit, nil
+		}
+		delete(c.entries, key)
+	}
+	result := cacheCoalesced
+	call, ok := c.calls[key]
+	if !ok {
+		result = cacheMiss
+		call = &cacheCall{done: make(chan struct{})}
+		c.calls[key] = call
+		gen := c.gen
+		go func() {
+			call.resp, call.err = fetch()
+			c.mu.Lock()
+			delete(c.calls, key)
+			if call.err == nil && call.resp.status >= 200 && call.resp.status < 300 && gen == c.gen {
+				c.entries[key] = cacheEntry{resp: call.resp, expires: time.Now().Add(c.ttl)}
+			}
+			c.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.resp, result, call.err
+	case <-ctx.Done():
+		return nil, result, ctx.Err()
+	}
+}
+
+// invalidate drops every entry and prevents in-flight fetches from storing
+// their results.
+func (c *responseCache) invalidate() {
+	c.invalidateWhere(func(string) bool { return true })
+}
+
+// invalidateWhere drops the entries whose key matches. In-flight fetches are
+// not stored either, whatever their key.
+func (c *responseCache) invalidateWhere(match func(key string) bool) {
+	c.mu.Lock()
+	c.gen++
+	for k := range c.entries {
+		if match(k) {
+			delete(c.entries, k)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// cacheableEndpoint reports whether a GET to endpoint may be served from the
+// metadata cache.
+func cacheableEndpoint(method, endpoint string) bool {
+	if method != http.MethodGet {
+		return false
+	}
+	switch {
+	case strings.HasSuffix(endpoint, "/api/tags"),
+		strings.HasSuffix(endpoint, "/api/ps"),
+		strings.HasSuffix(endpoint, "/api/version"):
+		return true
+	}
+	return false
+}
+
+// mutatesModels reports whether a request to endpoint can change the set of
+// models on the upstream and must therefore invalidate cached metadata.
+func mutatesModels(endpoint string) bool {
+	for _, suffix := range []string{"/api/pull", "/api/create", "/api/delete", "/api/copy"} {
+		if strings.HasSuffix(endpoint, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalModel appends the implicit ":latest" tag so that "llama3" and
+// "llama3:latest" share cache entries.
+func canonicalModel(model string) string {
+	if model == "" {
+		return ""
+	}
+	if i := strings.LastIndex(model, "/"); !strings.Contains(model[i+1:], ":") {
+		return model + ":latest"
+	}
+	return model
+}
+
+// showKeyPrefix is the /api/show cache key prefix shared by every variant
+// (verbose, Accept-Encoding) of one model.
+func showKeyPrefix(model string) string {
+	return "show\x00" + canonicalModel(model) + "\x00"
+}
+
+// cacheFor returns the cache and key a request is served from, or a nil
+// cache when the request is not cacheable. Keys end with the upstream or
+// backend the request was placed on, so a backend spilled over to never
+// answers with another one's models.
+func (h *Handler) cacheFor(upReq *http.Request, endpoint string, p requestPayload) (*responseCache, string) {
+	vary := "ae=" + upReq.Header.Get("Accept-Encoding") + "\x00up=" + upReq.URL.Scheme + "://" + upReq.URL.Host
+	switch {
+	case h.cache != nil && cacheableEndpoint(upReq.Method, endpoint):
+		return h.cache, upReq.Method + " " + endpoint + "?" + upReq.URL.RawQuery + " " + vary
+	case h.showCache != nil && upReq.Method == http.MethodPost &&
+		strings.HasSuffix(endpoint, "/api/show") && p.modelName() != "":
+		// /api/show is a POST, so the key comes from the body, not the URL.
+		verbose := p.Verbose != nil && *p.Verbose
+		return h.showCache, showKeyPrefix(p.modelName()) + "verbose=" + strconv.FormatBool(verbose) + "\x00" + vary
+	}
+	return nil, ""
+}
+
+// invalidateModelCaches drops cached metadata made stale by a pull, create,
+// delete or copy request.
+func (h *Handler) invalidateModelCaches(endpoint string, p requestPayload) {
+	h.forgetMissingModel(endpoint, p) // may have been learned while the request ran
+	if h.cache != nil {
+		h.cache.invalidate()
+	}
+	if h.showCache == nil {
+		return
+	}
+	model := p.modelName()
+	if strings.HasSuffix(endpoint, "/api/copy") {
+		model = p.Destination
+	}
+	if model == "" {
+		h.showCache.invalidate()
+		return
+	}
+	prefix := showKeyPrefix(model)
+	h.showCache.invalidateWhere(func(k string) bool { return strings.HasPrefix(k, prefix) })
+}
+
+// roundTrip sends upReq upstream, answering cacheable requests from the
+// matching cache, or embeddings from SharedStore, when it is enabled. The cache result is "" for requests
+// that bypass the cache.
+func (h *Handler) roundTrip(upReq *http.Request, endpoint string, p requestPayload) (*http.Response, string, error) {
+	if h.embedCacheable(upReq.Method, endpoint) {
+		return h.embedRoundTrip(upReq, endpoint)
+	}
+	cache, key := h.cacheFor(upReq, endpoint, p)
+	if cache == nil {
+		resp, err := h.clientFor(endpoint).Do(upReq)
+		return resp, "", err
+	}
+	cr, result, err := cache.get(upReq.Context(), key, func() (*cachedResponse, error) {
+		// The fetch is shared, so one client disconnecting must not fail the
+		// rest; a NonStreamTimeout deadline still applies.
+		ctx := context.WithoutCancel(upReq.Context())
+		if deadline, ok := upReq.Context().Deadline(); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+		resp, err := h.clientFor(endpoint).Do(upReq.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &cachedResponse{status: resp.StatusCode, header: resp.Header.Clone(), body: body}, nil
+	})
+	h.metrics.CacheRequests.WithLabelValues(endpoint, result).Inc()
+	if err != nil {
+		return nil, result, err
+	}
+	return cr.toHTTP(upReq), result, nil
+}
@@ -0,0 +1,260 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func countingUpstream(t *testing.T, delay time.Duration) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/api/tags") {
+			calls.Add(1)
+			time.Sleep(delay)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"models":[],"call":%d}`, calls.Load())
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func getTags(h *Handler) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	return rr
+}
+
+func TestCache_ServesHitsWithinTTL(t *testing.T) {
+	upstream, calls := countingUpstream(t, 0)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MetadataCacheTTL: time.Minute})
+
+	first := getTags(h)
+	second := getTags(h)
+
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls.Load())
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("cached body differs: %q vs %q", first.Body.String(), second.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.CacheRequests.WithLabelValues("/api/tags", cacheHit)); got != 1 {
+		t.Errorf("expected 1 hit, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.CacheRequests.WithLabelValues("/api/tags", cacheMiss)); got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+}
+
+func TestCache_ExpiresAfterTTL(t *testing.T) {
+	upstream, calls := countingUpstream(t, 0)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MetadataCacheTTL: 20 * time.Millisecond})
+
+	getTags(h)
+	time.Sleep(40 * time.Millisecond)
+	getTags(h)
+
+	if calls.Load() != 2 {
+		t.Errorf("expected entry to expire and refetch, got %d calls", calls.Load())
+	}
+}
+
+func TestCache_CoalescesConcurrentMisses(t *testing.T) {
+	upstream, calls := countingUpstream(t, 100*time.Millisecond)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MetadataCacheTTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rr := getTags(h); rr.Code != http.StatusOK {
+				t.Errorf("expected 200, got %d", rr.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("expected concurrent polls to share 1 upstream call, got %d", calls.Load())
+	}
+}
+
+func TestCache_InvalidatedByPull(t *testing.T) {
+	upstream, calls := countingUpstream(t, 0)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MetadataCacheTTL: time.Minute})
+
+	getTags(h)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/pull",
+		strings.NewReader(`{"model":"llama3"}`)))
+	getTags(h)
+
+	if calls.Load() != 2 {
+		t.Errorf("expected pull to invalidate the cache, got %d calls", calls.Load())
+	}
+}
+
+func TestCache_DisabledByDefault(t *testing.T) {
+	upstream, calls := countingUpstream(t, 0)
+	h := newTestHandler(t, upstream.URL)
+
+	getTags(h)
+	getTags(h)
+
+	if calls.Load() != 2 {
+		t.Errorf("expected no caching by default, got %d calls", calls.Load())
+	}
+}
+
+func TestCache_ErrorsNotCached(t *testing.T) {
+	var calls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MetadataCacheTTL: time.Minute})
+
+	getTags(h)
+	getTags(h)
+
+	if calls.Load() != 2 {
+		t.Errorf("expected 5xx responses to bypass the cache, got %d calls", calls.Load())
+	}
+}
+
+func TestCacheableEndpoint(t *testing.T) {
+	if !cacheableEndpoint(http.MethodGet, "/api/ps") {
+		t.Error("GET /api/ps should be cacheable")
+	}
+	if cacheableEndpoint(http.MethodPost, "/api/tags") {
+		t.Error("POST requests must never be cached")
+	}
+	if cacheableEndpoint(http.MethodGet, "/api/generate") {
+		t.Error("/api/generate must not be cached")
+	}
+}
+
+func showUpstream(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/api/show") {
+			calls.Add(1)
+		}
+		_, _ = fmt.Fprint(w, `{"model_info":{"llama.context_length":8192}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func postShow(h *Handler, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/show", strings.NewReader(body)))
+	return rr
+}
+
+func TestShowCache_KeyedByModelAndVerbose(t *testing.T) {
+	upstream, calls := showUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ShowCacheTTL: time.Minute})
+
+	postShow(h, `{"model":"llama3"}`)
+	postShow(h, `{"model":"llama3:latest"}`) // same model, implicit tag
+	postShow(h, `{"model":"llama3","verbose":true}`)
+	postShow(h, `{"model":"mistral"}`)
+	postShow(h, `{"name":"mistral"}`) // legacy field name
+
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 upstream calls (llama3, llama3 verbose, mistral), got %d", calls.Load())
+	}
+	if got := testutil.ToFloat64(h.metrics.CacheRequests.WithLabelValues("/api/show", cacheHit)); got != 2 {
+		t.Errorf("expected 2 hits, got %v", got)
+	}
+}
+
+func TestShowCache_InvalidatedPerModel(t *testing.T) {
+	upstream, calls := showUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ShowCacheTTL: time.Minute})
+
+	postShow(h, `{"model":"llama3"}`)
+	postShow(h, `{"model":"mistral"}`)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/api/delete",
+		strings.NewReader(`{"model":"llama3:latest"}`)))
+	postShow(h, `{"model":"llama3"}`)  // refetched
+	postShow(h, `{"model":"mistral"}`) // still cached
+
+	if calls.Load() != 3 {
+		t.Errorf("expected only llama3 to be refetched, got %d upstream calls", calls.Load())
+	}
+}
+
+func TestShowCache_CopyInvalidatesDestination(t *testing.T) {
+	upstream, calls := showUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ShowCacheTTL: time.Minute})
+
+	postShow(h, `{"model":"mine"}`)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/copy",
+		strings.NewReader(`{"source":"llama3","destination":"mine"}`)))
+	postShow(h, `{"model":"mine"}`)
+
+	if calls.Load() != 2 {
+		t.Errorf("expected copy to invalidate the destination, got %d calls", calls.Load())
+	}
+}
+
+func TestCanonicalModel(t *testing.T) {
+	cases := map[string]string{
+		"":                          "",
+		"llama3":                    "llama3:latest",
+		"llama3:8b":                 "llama3:8b",
+		"registry.local:5000/llama": "registry.local:5000/llama:latest",
+		"library/llama3:latest":     "library/llama3:latest",
+	}
+	for in, want := range cases {
+		if got := canonicalModel(in); got != want {
+			t.Errorf("canonicalModel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestShowCache_KeyedByBackend(t *testing.T) {
+	backend := func(name string) *url.URL {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/version" {
+				_, _ = fmt.Fprint(w, `{"version":"0.5.0"}`)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"model_info":{},"backend":%q}`, name)
+		}))
+		t.Cleanup(srv.Close)
+		u, _ := url.Parse(srv.URL)
+		return u
+	}
+	a, b := backend("a"), backend("b")
+	h := newTestHandlerWithConfig(t, backend("upstream").String(), Config{ShowCacheTTL: time.Minute, Backends: []*url.URL{a, b}})
+
+	first := postShow(h, `{"model":"llama3"}`).Body.String()
+	preferred, _ := h.backends.pick("llama3")
+	preferred.down.Store(true)
+	spilled := postShow(h, `{"model":"llama3"}`).Body.String()
+	if spilled == first {
+		t.Errorf("expected the backend spilled over to answer itself, got the cached %s", first)
+	}
+	preferred.down.Store(false)
+	if again := postShow(h, `{"model":"llama3"}`).Body.String(); again != first {
+		t.Errorf("expected the preferred backend's entry again, got %s", again)
+	}
+	if got := testutil.ToFloat64(h.metrics.CacheRequests.WithLabelValues("/api/show", cacheHit)); got != 1 {
+		t.Errorf("expected one hit, got %v", got)
+	}
+}
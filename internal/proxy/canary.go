@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// canaryKey marks requests sent by the canary prober in their context.
+type canaryKey struct{}
+
+// isCanary reports whether r was sent by the canary prober rather than a
+// client; such requests are not charged to rate limits or token budgets.
+func isCanary(r *http.Request) bool {
+	return r.Context().Value(canaryKey{}) != nil
+}
+
+// beginRequest counts a request in flight for model; call the returned
+// function when it completes.
+func (h *Handler) beginRequest(model string) func() {
+	h.inflightMu.Lock()
+	h.inflight[model]++
+	h.inflightMu.Unlock()
+	return func() {
+		h.inflightMu.Lock()
+		if h.inflight[model]--; h.inflight[model] <= 0 {
+			delete(h.inflight, model)
+		}
+		h.inflightMu.Unlock()
+	}
+}
+
+// busy reports whether model has client requests in flight.
+func (h *Handler) busy(model string) bool {
+	h.inflightMu.Lock()
+	defer h.inflightMu.Unlock()
+	return h.inflight[model] > 0
+}
+
+// canaryWriter is the ResponseWriter a probe is served into. It records when
+// the first body byte arrives and keeps the (tiny) body for inspection.
+type canaryWriter struct {
+	start  time.Time
+	header http.Header
+	status int
+	first  time.Duration
+	body   bytes.Buffer
+}
+
+func (w *canaryWriter) Header() http.Header { return w.header }
+
+func (w *canaryWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *canaryWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.first == 0 && len(p) > 0 {
+		w.first = time.Since(w.start)
+	}
+	return w.body.Write(p)
+}
+
+func (w *canaryWriter) Flush() {}
+
+// canary periodically sends a tiny streaming generate request per model
+// through the handler, so latency has a baseline even without traffic.
+type canary struct {
+	h          *Handler
+	models     []string
+	every      time.Duration
+	prompt     string
+	numPredict int
+}
+
+func newCanary(h *Handler, cfg Config) *canary {
+	c := &canary{
+		h:          h,
+		models:     cfg.CanaryModels,
+		every:      cfg.CanaryInterval,
+		prompt:     cfg.CanaryPrompt,
+		numPredict: cfg.CanaryNumPredict,
+	}
+	if c.prompt == "" {
+		c.prompt = "Reply with OK."
+	}
+	if c.numPredict <= 0 {
+		c.numPredict = 1
+	}
+	return c
+}
+
+// run probes model every interval until ctx is done.
+func (c *canary) run(ctx context.Context, model string) {
+	t := time.NewTicker(c.every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, down := c.h.maintenance.get(model, time.Now()); down || c.h.busy(model) {
+				// Never add load to a model that is already serving clients,
+				// nor probe one that is down on purpose.
+				c.h.metrics.CanarySkipped.WithLabelValues(model).Inc()
+				continue
+			}
+			c.probe(ctx, model)
+		}
+	}
+}
+
+// probe sends one canary request and records its outcome. Each probe may
+// take up to one interval.
+func (c *canary) probe(ctx context.Context, model string) {
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, canaryKey{}, true), c.every)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]any{
+		"model":   model,
+		"prompt":  c.prompt,
+		"stream":  true,
+		"options": map[string]any{"num_predict": c.numPredict},
+	})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/api/generate", bytes.NewReader(body))
+	req.RemoteAddr = "canary"
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Session-ID", "canary")
+	req.Header.Set("User-Agent", "ollama-proxy-canary")
+
+	w := &canaryWriter{start: time.Now(), header: http.Header{}}
+	c.h.ServeHTTP(w, req)
+	duration := time.Since(w.start)
+
+	var stats ChunkStats
+	sc := bufio.NewScanner(&w.body)
+	for sc.Scan() {
+		stats.Observe(sc.Bytes())
+	}
+	if w.status != http.StatusOK || !stats.Done {
+		c.h.metrics.CanaryFailures.WithLabelValues(model).Inc()
+		c.h.logger.Warn("canary probe failed", "model", model, "status_code", w.status, "duration_ms", duration.Milliseconds())
+		return
+	}
+	c.h.metrics.CanaryDuration.WithLabelValues(model).Observe(duration.Seconds())
+	c.h.metrics.CanaryTTFT.WithLabelValues(model).Observe(w.first.Seconds())
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCanary_RecordsLatencyAndBypassesLimits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"O","done":false}`)
+		_, _ = fmt.Fprintln(w, `{"response":"K","done":true,"prompt_eval_count":5,"eval_count":1}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{
+		RateLimit: 1, RateLimitWindow: time.Hour,
+		TokenBudget: 1, TokenBudgetWindow: time.Hour,
+		CanaryModels: []string{"m"}, CanaryInterval: 10 * time.Millisecond,
+	})
+	waitFor(t, "two canary probes", func() bool {
+		return testutil.CollectAndCount(h.metrics.CanaryDuration) == 1 &&
+			testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "200", "true", originUpstream, upstreamLabel(h.currentUpstream()), "")) >= 2
+	})
+	if n := testutil.CollectAndCount(h.metrics.CanaryTTFT); n != 1 {
+		t.Errorf("expected canary TTFT observed, got %d series", n)
+	}
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Errorf("expected canary probes not to count against client limits, got %d", rr.Code)
+	}
+}
+
+func TestCanary_SkipsBusyModel(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.UserAgent() != "ollama-proxy-canary" {
+			<-release
+		}
+		_, _ = fmt.Fprintln(w, `{"done":true}`)
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{
+		CanaryModels: []string{"m"}, CanaryInterval: 10 * time.Millisecond,
+	})
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	waitFor(t, "a skipped probe", func() bool {
+		return testutil.ToFloat64(h.metrics.CanarySkipped.WithLabelValues("m")) > 0
+	})
+}
+
+func TestCanary_CountsFailures(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{
+		CanaryModels: []string{"missing"}, CanaryInterval: 10 * time.Millisecond,
+	})
+	waitFor(t, "a failed probe", func() bool {
+		return testutil.ToFloat64(h.metrics.CanaryFailures.WithLabelValues("missing")) > 0
+	})
+	if n := testutil.CollectAndCount(h.metrics.CanaryDuration); n != 0 {
+		t.Errorf("expected no duration for failed probes, got %d series", n)
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Phases a request was in when its client went away, the phase label of
+// client_cancellations_total and the cancel_phase field of its log line.
+const (
+	cancelQueue    = "queue"            // waiting for a -max-concurrent-per-model slot
+	cancelHeaders  = "upstream_headers" // forwarded, or waiting on a shared cache fetch
+	cancelResponse = "response"         // buffering a non-stream response
+	cancelStream   = "stream"           // relaying a stream
+)
+
+const (
+	// statusCanceled is the status label of requests whose client went
+	// away first, apart from the upstream's statuses and the proxy's own
+	// errors.
+	statusCanceled = "canceled"
+
+	// statusClientClosedRequest is the non-standard (nginx) status stored
+	// and logged for those requests.
+	statusClientClosedRequest = 499
+)
+
+// clientGone reports whether err is the client's request context ending,
+// rather than the upstream failing or a timeout of the proxy's own.
+func clientGone(ctx context.Context, err error) bool {
+	return err != nil && errors.Is(ctx.Err(), context.Canceled)
+}
+
+// clientCanceled records a request whose client went away in phase, before
+// any response reached it. Nothing is written back: nobody is listening.
+// The upstream request, if any, is aborted by its context, and the deferred
+// releases in ServeHTTP free its queue slot and in-flight count. A request
+// Drain canceled is a shutdown instead, and its client is answered.
+func (h *Handler) clientCanceled(w http.ResponseWriter, ri *reqInfo, phase string, err error) {
+	if shutDown(ri.r.Context()) {
+		h.shutdownCanceled(w, ri, phase)
+		return
+	}
+	h.metrics.ClientCancellations.WithLabelValues(ri.endpoint, phase).Inc()
+	h.metrics.ReqTotal.WithLabelValues(ri.endpoint, ri.modelLabel, statusCanceled, ri.streamLabel, originProxy, ri.upstreamLabel, ri.clientName).Inc()
+	h.observeDuration(ri.endpoint, ri.modelLabel, ri.streamLabel, ri.clientName, "", time.Since(ri.received), 0)
+	h.recordFailure(ri, statusClientClosedRequest, "client gone ("+phase+"): "+err.Error(), "cancel_phase", phase)
+}
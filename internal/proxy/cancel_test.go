@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// holdingUpstream signals reached when a request gets as far as phase
+// (before headers, after headers, or after a first stream chunk), then holds
+// it until the proxy aborts it, signalling aborted.
+func holdingUpstream(t *testing.T, phase string) (srv *httptest.Server, reached, aborted chan struct{}) {
+	t.Helper()
+	reached, aborted = make(chan struct{}, 1), make(chan struct{}, 1)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body) // the server notices a closed connection only after the body
+		switch phase {
+		case cancelResponse:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, `{"response":"par`)
+			w.(http.Flusher).Flush()
+		case cancelStream:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+			w.(http.Flusher).Flush()
+		}
+		reached <- struct{}{}
+		<-r.Context().Done()
+		aborted <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, reached, aborted
+}
+
+// serveCancelable serves body to h in the background and returns the
+// function that makes the client go away, and a channel closed once
+// ServeHTTP returned.
+func serveCancelable(h *Handler, body string) (cancel func(), done chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)).WithContext(ctx)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	return cancel, done
+}
+
+func waitChan(t *testing.T, what string, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func assertCanceled(t *testing.T, h *Handler, phase string) {
+	t.Helper()
+	if got := testutil.ToFloat64(h.metrics.ClientCancellations.WithLabelValues("/api/generate", phase)); got != 1 {
+		t.Errorf("expected one cancellation in phase %s, got %v", phase, got)
+	}
+	if got := testutil.ToFloat64(h.metrics.InFlight.WithLabelValues("/api/generate", "m")); got != 0 {
+		t.Errorf("expected nothing in flight, got %v", got)
+	}
+	if h.busy("m") {
+		t.Error("expected the model no longer busy")
+	}
+}
+
+func TestCancel_AbortsUpstream(t *testing.T) {
+	for _, tc := range []struct{ phase, body string }{
+		{cancelHeaders, `{"model":"m","stream":false}`},
+		{cancelResponse, `{"model":"m","stream":false}`},
+		{cancelStream, `{"model":"m","stream":true}`},
+	} {
+		t.Run(tc.phase, func(t *testing.T) {
+			upstream, reached, aborted := holdingUpstream(t, tc.phase)
+			h := newTestHandler(t, upstream.URL)
+			cancel, done := serveCancelable(h, tc.body)
+			waitChan(t, "upstream reached", reached)
+			switch tc.phase {
+			case cancelStream:
+				waitFor(t, "first chunk relayed", func() bool {
+					return histogramCount(t, h.metrics.TTFT.WithLabelValues("/api/generate", "m")) == 1
+				})
+			case cancelResponse:
+				time.Sleep(20 * time.Millisecond) // let the proxy read the headers
+			}
+			cancel()
+			waitChan(t, "upstream request aborted", aborted)
+			waitChan(t, "handler returned", done)
+
+			assertCanceled(t, h, tc.phase)
+			up := upstreamLabel(h.currentUpstream())
+			stream := fmt.Sprint(tc.phase == cancelStream)
+			if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", statusCanceled, stream, originProxy, up, "")); got != 1 {
+				t.Errorf("expected the request counted as canceled, got %v", got)
+			}
+			if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "502", stream, originProxy, up, "")); got != 0 {
+				t.Errorf("expected no 502 for a client that went away, got %v", got)
+			}
+		})
+	}
+}
+
+func TestCancel_WhileQueuedFreesNothing(t *testing.T) {
+	upstream, reached, aborted := holdingUpstream(t, cancelHeaders)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MaxConcurrentPerModel: 1})
+	cancelFirst, firstDone := serveCancelable(h, `{"model":"m","stream":false}`)
+	waitChan(t, "first request upstream", reached)
+
+	cancel, done := serveCancelable(h, `{"model":"m","stream":false}`)
+	waitFor(t, "second request queued", func() bool {
+		h.gate.mu.Lock()
+		defer h.gate.mu.Unlock()
+		return h.gate.models["m"].queued() == 1
+	})
+	cancel()
+	waitChan(t, "queued request returned", done)
+	if got := testutil.ToFloat64(h.metrics.ClientCancellations.WithLabelValues("/api/generate", cancelQueue)); got != 1 {
+		t.Errorf("expected one cancellation while queued, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.AdmissionDecisions.WithLabelValues(admissionAbandoned)); got != 1 {
+		t.Errorf("expected the request abandoned, got %v", got)
+	}
+
+	cancelFirst()
+	waitChan(t, "first upstream request aborted", aborted)
+	waitChan(t, "first request returned", firstDone)
+	h.gate.mu.Lock()
+	q := h.gate.models["m"]
+	h.gate.mu.Unlock()
+	if q != nil {
+		t.Errorf("expected the gate empty, got %d active and %d queued", q.active, q.queued())
+	}
+	if got := testutil.ToFloat64(h.metrics.InFlight.WithLabelValues("/api/generate", "m")); got != 0 {
+		t.Errorf("expected nothing in flight, got %v", got)
+	}
+}
+
+func TestCancel_CoalescedCacheWaiterLeaves(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = fmt.Fprint(w, `{"models":[]}`)
+	}))
+	defer upstream.Close()
+	defer close(release)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MetadataCacheTTL: time.Minute})
+
+	leader := make(chan *httptest.ResponseRecorder, 1)
+	go func() { leader <- getTags(h) }()
+	waitFor(t, "fetch started", func() bool {
+		h.cache.mu.Lock()
+		defer h.cache.mu.Unlock()
+		return len(h.cache.calls) == 1
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/tags", nil).WithContext(ctx))
+	}()
+	time.Sleep(20 * time.Millisecond) // let it join the fetch
+	cancel()
+	waitChan(t, "waiter returned while the fetch runs", done)
+	if got := testutil.ToFloat64(h.metrics.ClientCancellations.WithLabelValues("/api/tags", cancelHeaders)); got != 1 {
+		t.Errorf("expected one cancellation, got %v", got)
+	}
+
+	release <- struct{}{}
+	if rr := <-leader; rr.Code != http.StatusOK {
+		t.Errorf("expected the remaining client served, got %d", rr.Code)
+	}
+}
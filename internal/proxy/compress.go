@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsGzip reports whether the client listed gzip (or *) in Accept-Encoding
+// with a non-zero quality value.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// canCompress reports whether the proxy may gzip this response for the client:
+// the feature is enabled, the client accepts gzip and the upstream did not
+// already apply a content coding.
+func (h *Handler) canCompress(r *http.Request, upstream http.Header) bool {
+	if !h.cfg.CompressResponses || !acceptsGzip(r) {
+		return false
+	}
+	ce := upstream.Get("Content-Encoding")
+	return ce == "" || strings.EqualFold(ce, "identity")
+}
+
+// shouldDecompress reports whether a gzip-encoded upstream response must be
+// decoded before it reaches a client that cannot handle gzip. Responses that
+// cannot carry a body (HEAD, 204, 304 or an explicit zero length) pass as
+// they are: there is no gzip stream to read.
+func (h *Handler) shouldDecompress(r *http.Request, resp *http.Response) bool {
+	if r.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified || resp.ContentLength == 0 {
+		return false
+	}
+	return h.cfg.DecompressResponses &&
+		strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") &&
+		!acceptsGzip(r)
+}
+
+// setGzipHeaders rewrites response headers for a gzip-encoded body whose final
+// length is not known up front.
+func setGzipHeaders(hdr http.Header) {
+	hdr.Set("Content-Encoding", "gzip")
+	hdr.Del("Content-Length")
+	hdr.Add("Vary", "Accept-Encoding")
+}
+
+// gzipBytes compresses b in one shot.
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(b)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+// countingWriter counts bytes that pass through to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=0.5": true,
+		"gzip;q=0":            false,
+		"br":                  false,
+		"*":                   true,
+	}
+	for header, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Accept-Encoding", header)
+		}
+		if got := acceptsGzip(req); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestCompress_NonStreamGzipped(t *testing.T) {
+	payload := `{"embeddings":[[` + strings.Repeat("0.123456,", 500) + `0]],"done":true}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, payload)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{CompressResponses: true, CompressMinBytes: 100})
+	req := httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model":"e","input":"x"}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if ce := rr.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("expected Content-Encoding gzip, got %q", ce)
+	}
+	if v := rr.Header().Get("Vary"); v != "Accept-Encoding" {
+		t.Errorf("expected Vary: Accept-Encoding, got %q", v)
+	}
+	if cl := rr.Header().Get("Content-Length"); cl != fmt.Sprint(rr.Body.Len()) {
+		t.Errorf("Content-Length %q does not match body length %d", cl, rr.Body.Len())
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	got, _ := io.ReadAll(zr)
+	if string(got) != payload {
+		t.Error("decompressed body does not match upstream payload")
+	}
+	if saved := testutil.ToFloat64(h.metrics.GzipSaved.WithLabelValues("/api/embed")); saved <= 0 {
+		t.Errorf("expected positive bytes saved, got %v", saved)
+	}
+}
+
+func TestCompress_BelowThresholdUntouched(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"done":true}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{CompressResponses: true, CompressMinBytes: 1024})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected no Content-Encoding for small body, got %q", ce)
+	}
+	if rr.Body.String() != `{"done":true}` {
+		t.Errorf("unexpected body %q", rr.Body.String())
+	}
+}
+
+func TestCompress_StreamFlushesEachLine(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		for _, l := range []string{
+			`{"response":"a","done":false}`,
+			`{"response":"b","done":false}`,
+			`{"response":"","done":true,"eval_count":2}`,
+		} {
+			_, _ = fmt.Fprintln(w, l)
+			flusher.Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{CompressResponses: true})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if ce := rr.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("expected gzip stream, got %q", ce)
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	sc := bufio.NewScanner(zr)
+	var n int
+	for sc.Scan() {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("expected 3 decompressed lines, got %d", n)
+	}
+}
+
+func TestCompress_DisabledPassesThrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, strings.Repeat("x", 4096))
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"stream":false}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected compression disabled by default, got %q", ce)
+	}
+}
+
+func gzipUpstream(t *testing.T, body []byte) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		_, _ = w.Write(body)
+	}))
+}
+
+func TestDecompress_LegacyClientGetsPlainBody(t *testing.T) {
+	payload := `{"response":"hi","done":true,"eval_count":3,"prompt_eval_count":2}`
+	upstream := gzipUpstream(t, gzipBytes([]byte(payload)))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{DecompressResponses: true})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	req.Header.Set("Accept-Encoding", "identity")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected Content-Encoding removed, got %q", ce)
+	}
+	if cl := rr.Header().Get("Content-Length"); cl != fmt.Sprint(len(payload)) {
+		t.Errorf("expected the plain body's Content-Length, not the upstream's, got %q", cl)
+	}
+	if rr.Body.String() != payload {
+		t.Errorf("expected plain body, got %q", rr.Body.String())
+	}
+	rows, _, _ := h.store.ListRequests(1, 0, "", "")
+	if len(rows) == 0 || rows[0].CompletionTokens != 3 {
+		t.Errorf("expected tokens parsed from decompressed body, got %+v", rows)
+	}
+}
+
+func TestDecompress_GzipClientUntouched(t *testing.T) {
+	gz := gzipBytes([]byte(`{"done":true}`))
+	upstream := gzipUpstream(t, gz)
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{DecompressResponses: true})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"stream":false}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if ce := rr.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("expected gzip passthrough, got %q", ce)
+	}
+	if rr.Body.String() != string(gz) {
+		t.Error("expected compressed bytes forwarded unchanged")
+	}
+}
+
+func TestDecompress_CorruptBodyReturns502(t *testing.T) {
+	upstream := gzipUpstream(t, []byte("definitely not gzip"))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{DecompressResponses: true})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"stream":false}`))
+	req.Header.Set("Accept-Encoding", "identity")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected 502, got %d", rr.Code)
+	}
+	if got := testutil.ToFloat64(h.metrics.DecompressErrors.WithLabelValues("/api/generate")); got != 1 {
+		t.Errorf("expected 1 decompression error, got %v", got)
+	}
+}
+
+func TestDecompress_NoBodyPassesThrough(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		method string
+		status int
+	}{
+		{"head", http.MethodHead, http.StatusOK},
+		{"no content", http.MethodGet, http.StatusNoContent},
+		{"not modified", http.MethodGet, http.StatusNotModified},
+		{"empty body", http.MethodGet, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				if tc.status == http.StatusOK {
+					w.Header().Set("Content-Length", "0")
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer upstream.Close()
+
+			h := newTestHandlerWithConfig(t, upstream.URL, Config{DecompressResponses: true})
+			req := httptest.NewRequest(tc.method, "/api/tags", nil)
+			req.Header.Set("Accept-Encoding", "identity")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Errorf("expected %d, got %d", tc.status, rr.Code)
+			}
+			if got := testutil.ToFloat64(h.metrics.DecompressErrors.WithLabelValues("/api/tags")); got != 0 {
+				t.Errorf("expected no decompression errors, got %v", got)
+			}
+		})
+	}
+}
+
+func TestDecompress_TruncatedBodyReturns502(t *testing.T) {
+	gz := gzipBytes([]byte(strings.Repeat(`{"response":"x"}`, 200)))
+	upstream := gzipUpstream(t, gz[:len(gz)/2])
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{DecompressResponses: true})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"stream":false}`))
+	req.Header.Set("Accept-Encoding", "identity")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 for truncated gzip, got %d", rr.Code)
+	}
+}
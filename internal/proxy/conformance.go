@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// Kinds of upstream conformance violations, the kind label of
+// upstream_conformance_violations_total.
+const (
+	// violationContentType: a 2xx response of a type the endpoint does not
+	// answer with, e.g. application/json to a stream or NDJSON to
+	// "stream": false.
+	violationContentType = "content_type"
+	// violationMissingDone: a generate or chat response that ended without
+	// "done": true, or a /v1 stream without its data: [DONE] event.
+	violationMissingDone = "missing_done"
+	// violationMissingUsage: a /v1 response without a usage block where
+	// one is due: always on whole responses, on streams when the request
+	// set stream_options.include_usage.
+	violationMissingUsage = "missing_usage"
+)
+
+// expectedContentType returns the media type a 2xx response to endpoint
+// should have, "" for endpoints the proxy has no expectations of.
+func expectedContentType(endpoint string, stream bool) string {
+	openAI := strings.Contains(endpoint, "/v1/")
+	switch endpointClass(endpoint) {
+	case "generate", "chat":
+		switch {
+		case !stream:
+			return "application/json"
+		case openAI:
+			return "text/event-stream"
+		}
+		return "application/x-ndjson"
+	case "embed":
+		return "application/json"
+	}
+	return ""
+}
+
+// conformanceViolations checks a finished 2xx response against what its
+// endpoint promises. stats were accumulated over the body; a response that
+// broke off (errMsg set) is only checked for its Content-Type.
+func conformanceViolations(ri *reqInfo, header http.Header, stream bool, stats *ChunkStats, errMsg string) []string {
+	want := expectedContentType(ri.endpoint, stream)
+	if want == "" {
+		return nil
+	}
+	var out []string
+	if ct, _, _ := mime.ParseMediaType(header.Get("Content-Type")); ct != want {
+		out = append(out, violationContentType)
+	}
+	if errMsg != "" {
+		return out
+	}
+	openAI := strings.Contains(ri.endpoint, "/v1/")
+	class := endpointClass(ri.endpoint)
+	if (class == "generate" || class == "chat") && (!openAI || stream) && !stats.Done {
+		out = append(out, violationMissingDone)
+	}
+	if openAI && (!stream || ri.includeUsage) && !stats.SawPrompt && !stats.SawCompletion {
+		out = append(out, violationMissingUsage)
+	}
+	return out
+}
+
+// conformanceTracker totals violations since start for /stats.
+type conformanceTracker struct {
+	mu       sync.Mutex
+	counts   map[string]int64 // kind → violations
+	lastAt   time.Time
+	lastKind string
+	lastEnd  string
+	lastReq  string
+}
+
+func (t *conformanceTracker) add(now time.Time, kind, endpoint, requestID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts == nil {
+		t.counts = map[string]int64{}
+	}
+	t.counts[kind]++
+	t.lastAt, t.lastKind, t.lastEnd, t.lastReq = now, kind, endpoint, requestID
+}
+
+// snapshot returns the tracker's state as reported by /stats.
+func (t *conformanceTracker) snapshot() *proxyapi.Conformance {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[string]int64, len(t.counts))
+	for k, n := range t.counts {
+		counts[k] = n
+	}
+	out := &proxyapi.Conformance{Violations: counts}
+	if !t.lastAt.IsZero() {
+		at := t.lastAt.UTC().Truncate(time.Second)
+		out.LastAt, out.LastKind, out.LastEndpoint, out.LastRequestID = &at, t.lastKind, t.lastEnd, t.lastReq
+	}
+	return out
+}
+
+// checkConformance records how a 2xx response deviated from its endpoint's
+// schema when ValidateUpstream is on. It only reports: the response goes to
+// the client as the upstream sent it.
+func (h *Handler) checkConformance(ri *reqInfo, status int, header http.Header, stream bool, stats *ChunkStats, errMsg string) {
+	if !h.cfg.ValidateUpstream || status < 200 || status >= 300 {
+		return
+	}
+	kinds := conformanceViolations(ri, header, stream, stats, errMsg)
+	if len(kinds) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, kind := range kinds {
+		h.metrics.ConformanceViolations.WithLabelValues(ri.endpoint, kind).Inc()
+		h.conformance.add(now, kind, ri.endpoint, ri.id)
+	}
+	detail := fmt.Sprintf("upstream response violates %s: %s (Content-Type %q)",
+		ri.endpoint, strings.Join(kinds, ", "), header.Get("Content-Type"))
+	h.recordLastError(ri, status, []byte(detail), "conformance")
+	h.logger.Debug("upstream conformance violation", "request_id", ri.id, "endpoint", ri.endpoint,
+		"model", ri.model, "kinds", kinds)
+}
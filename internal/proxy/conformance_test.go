@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// replyUpstream answers every request with body as contentType.
+func replyUpstream(t *testing.T, contentType, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestConformance_Violations(t *testing.T) {
+	const sse = "text/event-stream"
+	for _, tc := range []struct {
+		name, endpoint, req, contentType, reply string
+		want                                    []string
+	}{
+		{"conforming stream", "/api/generate", `{"model":"m"}`, "application/x-ndjson",
+			`{"response":"a","done":false}` + "\n" + `{"done":true,"eval_count":1}` + "\n", nil},
+		{"stream without done", "/api/generate", `{"model":"m"}`, "application/x-ndjson",
+			`{"response":"a","done":false}` + "\n", []string{violationMissingDone}},
+		{"ndjson to stream false", "/api/chat", `{"model":"m","stream":false}`, "application/x-ndjson",
+			`{"message":{"content":"a"},"done":true}` + "\n", []string{violationContentType}},
+		{"v1 without usage", "/v1/chat/completions", `{"model":"m"}`, "application/json",
+			`{"choices":[{"message":{"content":"a"}}]}`, []string{violationMissingUsage}},
+		{"v1 stream usage asked, not sent", "/v1/chat/completions",
+			`{"model":"m","stream":true,"stream_options":{"include_usage":true}}`, sse,
+			"data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\ndata: [DONE]\n\n", []string{violationMissingUsage}},
+		{"v1 stream without usage, none asked", "/v1/chat/completions", `{"model":"m","stream":true}`, sse,
+			"data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\ndata: [DONE]\n\n", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHandlerWithConfig(t, replyUpstream(t, tc.contentType, tc.reply).URL, Config{ValidateUpstream: true})
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tc.endpoint, strings.NewReader(tc.req)))
+
+			if rr.Body.String() != tc.reply {
+				t.Errorf("expected the response relayed unchanged, got %q", rr.Body.String())
+			}
+			var total float64
+			for _, kind := range []string{violationContentType, violationMissingDone, violationMissingUsage} {
+				total += testutil.ToFloat64(h.metrics.ConformanceViolations.WithLabelValues(tc.endpoint, kind))
+			}
+			if total != float64(len(tc.want)) {
+				t.Errorf("expected %d violations, got %v", len(tc.want), total)
+			}
+			for _, kind := range tc.want {
+				if got := testutil.ToFloat64(h.metrics.ConformanceViolations.WithLabelValues(tc.endpoint, kind)); got != 1 {
+					t.Errorf("expected a %s violation, got %v", kind, got)
+				}
+			}
+			if errs := h.lastErrors.list("m"); (len(errs) == 1 && errs[0].Source == "conformance") != (len(tc.want) > 0) {
+				t.Errorf("expected a conformance last error only with violations, got %+v", errs)
+			}
+		})
+	}
+}
+
+func TestConformance_OffByDefault(t *testing.T) {
+	h := newTestHandler(t, replyUpstream(t, "application/x-ndjson", `{"response":"a","done":false}`+"\n").URL)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`)))
+
+	if got := testutil.ToFloat64(h.metrics.ConformanceViolations.WithLabelValues("/api/generate", violationMissingDone)); got != 0 {
+		t.Errorf("expected nothing checked without ValidateUpstream, got %v", got)
+	}
+}
+
+func TestConformance_Stats(t *testing.T) {
+	h := newTestHandlerWithConfig(t, replyUpstream(t, "text/plain", `{"embeddings":[[1]]}`).URL, Config{ValidateUpstream: true})
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model":"m","input":"x"}`)))
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeStats(rr, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var st struct {
+		Conformance struct {
+			Violations   map[string]int `json:"violations"`
+			LastEndpoint string         `json:"last_endpoint"`
+		} `json:"conformance"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&st)
+	if st.Conformance.Violations[violationContentType] != 2 || st.Conformance.LastEndpoint != "/api/embed" {
+		t.Errorf("expected two content_type violations on /api/embed in /stats, got %+v", st.Conformance)
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import "bytes"
+
+// contextLen decodes the /api/generate "context" array as its element count.
+// Clients echo this array back on every turn, so it can grow to hundreds of
+// thousands of tokens; counting separators avoids allocating it.
+type contextLen int64
+
+// UnmarshalJSON implements json.Unmarshaler. The array holds only integers,
+// so elements are the commas plus one.
+func (c *contextLen) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) < 2 || b[0] != '[' {
+		*c = 0 // null or unexpected shape; never fail the surrounding decode
+		return nil
+	}
+	if len(bytes.TrimSpace(b[1:len(b)-1])) == 0 {
+		*c = 0
+		return nil
+	}
+	*c = contextLen(bytes.Count(b, []byte(",")) + 1)
+	return nil
+}
+
+// Directions for ollama_proxy_context_tokens.
+const (
+	contextIn  = "in"
+	contextOut = "out"
+)
+
+// observeContext records the size of a context array carried in a request
+// or returned by the upstream, warning when it exceeds ContextWarnTokens.
+func (h *Handler) observeContext(ri *reqInfo, direction string, n int64) {
+	if n <= 0 {
+		return
+	}
+	h.metrics.ContextTokens.WithLabelValues(ri.modelLabel, direction).Observe(float64(n))
+	if h.cfg.ContextWarnTokens > 0 && n > h.cfg.ContextWarnTokens {
+		h.logger.Warn("large generate context",
+			"request_id", ri.id,
+			"session_id", ri.sessionID,
+			"client_ip", ri.clientIP,
+			"model", ri.model,
+			"direction", direction,
+			"context_tokens", n,
+			"limit", h.cfg.ContextWarnTokens,
+		)
+	}
+}
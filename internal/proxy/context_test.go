@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestContextLen_Unmarshal(t *testing.T) {
+	cases := map[string]int64{
+		`{"context":[1,2,3]}`:     3,
+		`{"context":[ 42 ]}`:      1,
+		`{"context":[]}`:          0,
+		`{"context":null}`:        0,
+		`{"context":"bogus"}`:     0,
+		`{"prompt":"no context"}`: 0,
+	}
+	for in, want := range cases {
+		var p requestPayload
+		if err := json.Unmarshal([]byte(in), &p); err != nil {
+			t.Errorf("%s: unexpected error %v", in, err)
+			continue
+		}
+		if int64(p.Context) != want {
+			t.Errorf("%s: got %d, want %d", in, p.Context, want)
+		}
+	}
+}
+
+func TestServeHTTP_ObservesContextInAndOut(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		_, _ = fmt.Fprintln(w, `{"response":"b","done":true,"eval_count":2,"context":[1,2,3,4,5]}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	req := httptest.NewRequest(http.MethodPost, "/api/generate",
+		strings.NewReader(`{"model":"m","prompt":"hi","context":[1,2,3]}`))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if n := testutil.CollectAndCount(h.metrics.ContextTokens); n != 2 {
+		t.Fatalf("expected in and out series, got %d", n)
+	}
+	if got := histogramSum(t, h.metrics.ContextTokens.WithLabelValues("m", contextIn)); got != 3 {
+		t.Errorf("expected 3 context tokens in, got %v", got)
+	}
+	if got := histogramSum(t, h.metrics.ContextTokens.WithLabelValues("m", contextOut)); got != 5 {
+		t.Errorf("expected 5 context tokens out, got %v", got)
+	}
+}
+
+func TestServeHTTP_NoContextOnChat(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"message":{"role":"assistant","content":"x"},"done":true,"eval_count":1}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	req := httptest.NewRequest(http.MethodPost, "/api/chat",
+		strings.NewReader(`{"model":"m","messages":[{"role":"user","content":"hi"}],"stream":false}`))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if n := testutil.CollectAndCount(h.metrics.ContextTokens); n != 0 {
+		t.Errorf("expected no context observations for chat, got %d", n)
+	}
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Modes of ContextCheck.
+const (
+	ContextCheckWarn   = "warn"
+	ContextCheckReject = "reject"
+)
+
+// contextWindowTTL is how long a model's context window, once fetched from
+// /api/show, is trusted before it is fetched again.
+const contextWindowTTL = 10 * time.Minute
+
+// numCtxOption decodes options.num_ctx of a request, leaving it 0 when
+// absent or malformed rather than failing the surrounding decode.
+type numCtxOption int64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (n *numCtxOption) UnmarshalJSON(b []byte) error {
+	var o struct {
+		NumCtx float64 `json:"num_ctx"`
+	}
+	if json.Unmarshal(b, &o) == nil && o.NumCtx > 0 {
+		*n = numCtxOption(o.NumCtx)
+	}
+	return nil
+}
+
+// contextWindow is what /api/show said about a model; tokens is 0 when it
+// named no window.
+type contextWindow struct {
+	tokens  int64
+	fetched time.Time
+}
+
+// contextWindows caches context windows per canonical model name.
+type contextWindows struct {
+	mu       sync.Mutex
+	byModel  map[string]contextWindow
+	fetching map[string]bool
+}
+
+// get returns key's cached window and whether a fetch should start: when
+// nothing fresh is cached and no fetch is running. The caller must then
+// call fetched.
+func (c *contextWindows) get(key string, now time.Time) (tokens int64, fetch bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.byModel[key]
+	if ok && now.Sub(w.fetched) < contextWindowTTL {
+		return w.tokens, false
+	}
+	if c.fetching[key] {
+		return w.tokens, false
+	}
+	c.fetching[key] = true
+	return w.tokens, true
+}
+
+func (c *contextWindows) fetched(key string, tokens int64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.fetching, key)
+	c.byModel[key] = contextWindow{tokens: tokens, fetched: now}
+}
+
+// contextWindowFor returns model's context window from the cache, 0 when it
+// is not known yet. Misses are fetched in the background, so the request
+// that finds the cache cold is never held up.
+func (h *Handler) contextWindowFor(model string) int64 {
+	key := canonicalModel(model)
+	tokens, fetch := h.contextWindows.get(key, time.Now())
+	if fetch {
+		go func() {
+			n, err := h.fetchContextWindow(model)
+			if err != nil {
+				h.logger.Debug("fetch context window", "model", model, "error", err)
+			}
+			h.contextWindows.fetched(key, n, time.Now())
+		}()
+	}
+	return tokens
+}
+
+// fetchContextWindow asks the upstream's /api/show for model's context
+// window: the num_ctx parameter of its Modelfile when set, else the
+// architecture's context_length.
+func (h *Handler) fetchContextWindow(model string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	up := h.upstreamEndpoint(h.currentUpstream(), "/api/show")
+	body, _ := json.Marshal(map[string]string{"model": model})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, up.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	h.setUpstreamAuth(req.Header, h.currentUpstream())
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("/api/show answered %s", resp.Status)
+	}
+	var show struct {
+		Parameters string                     `json:"parameters"`
+		ModelInfo  map[string]json.RawMessage `json:"model_info"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&show); err != nil {
+		return 0, err
+	}
+	sc := bufio.NewScanner(strings.NewReader(show.Parameters))
+	for sc.Scan() {
+		if f := strings.Fields(sc.Text()); len(f) == 2 && f[0] == "num_ctx" {
+			if n, err := strconv.ParseInt(f[1], 10, 64); err == nil && n > 0 {
+				return n, nil
+			}
+		}
+	}
+	for k, v := range show.ModelInfo {
+		if strings.HasSuffix(k, ".context_length") {
+			var n int64
+			if json.Unmarshal(v, &n) == nil && n > 0 {
+				return n, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// estimatePromptTokens roughly sizes everything the model reads for a
+// request: prompt, system prompt, every chat message and a generate context
+// array, which is already in tokens.
+func estimatePromptTokens(p requestPayload, charsPerToken float64) int64 {
+	chars := utf8.RuneCountInString(p.Prompt) + utf8.RuneCountInString(p.System)
+	for _, m := range p.Messages {
+		chars += utf8.RuneCountInString(m.Content)
+	}
+	return int64(math.Ceil(float64(chars)/charsPerToken)) + int64(p.Context)
+}
+
+// inspectContextWindow counts, logs and in reject mode refuses with 413
+// generate and chat requests whose estimated prompt exceeds the model's
+// context window by more than ContextOverflowMargin. The window is the
+// request's options.num_ctx, else the model's from /api/show; requests for
+// models whose window is not known yet pass.
+func (h *Handler) inspectContextWindow(_ context.Context, req *ParsedRequest) error {
+	if h.cfg.ContextCheck == "" {
+		return nil
+	}
+	if class := endpointClass(req.Endpoint); class != "generate" && class != "chat" {
+		return nil
+	}
+	p := req.payload
+	window := int64(p.NumCtx)
+	if window == 0 {
+		window = h.contextWindowFor(req.Model)
+	}
+	if window <= 0 {
+		return nil
+	}
+	est := estimatePromptTokens(p, h.charsPerToken())
+	req.ri.estimates.context = est
+	if float64(est) <= float64(window)*(1+h.cfg.ContextOverflowMargin) {
+		return nil
+	}
+	h.metrics.ContextOverflow.WithLabelValues(req.Model).Inc()
+	h.logger.Warn("estimated prompt exceeds the context window",
+		"request_id", req.ID, "model", req.Model, "estimated_tokens", est, "context_window", window)
+	if h.cfg.ContextCheck != ContextCheckReject {
+		return nil
+	}
+	return &Rejection{
+		Reason:  reasonContextOverflow,
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("prompt of about %d tokens exceeds the context window of %d tokens for model %s", est, window, req.Model),
+		Fields:  map[string]any{"model": req.Model, "estimated_tokens": est, "context_window": window},
+	}
+}
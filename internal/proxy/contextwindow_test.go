@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// windowUpstream answers /api/show with the given parameters and a 100-token
+// context_length, and everything else like tokenUpstream.
+func windowUpstream(t *testing.T, parameters string, shows *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/show" {
+			shows.Add(1)
+			_, _ = fmt.Fprintf(w, `{"parameters":%q,"model_info":{"general.architecture":"llama","llama.context_length":100}}`, parameters)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true,"prompt_eval_count":40,"eval_count":60}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// warmContextWindow makes the first request for m, which starts the
+// background /api/show fetch, and waits for it to land.
+func warmContextWindow(t *testing.T, h *Handler) {
+	t.Helper()
+	postJSON(h, "/api/generate", `{"model":"m","prompt":"hi","stream":false}`)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h.contextWindows.mu.Lock()
+		_, ok := h.contextWindows.byModel[canonicalModel("m")]
+		h.contextWindows.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("context window never fetched")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestContextWindow_RejectMode(t *testing.T) {
+	var shows atomic.Int32
+	h := newTestHandlerWithConfig(t, windowUpstream(t, "", &shows).URL, Config{ContextCheck: ContextCheckReject, ContextOverflowMargin: 0.2})
+	warmContextWindow(t, h)
+
+	// 100 tokens at 4 characters each, plus the 20% margin, is 480 characters.
+	if rr := postJSON(h, "/api/generate", `{"model":"m","prompt":"`+strings.Repeat("a", 480)+`","stream":false}`); rr.Code != http.StatusOK {
+		t.Errorf("expected a prompt within the margin forwarded, got %d", rr.Code)
+	}
+	rr := postJSON(h, "/api/generate", `{"model":"m","prompt":"`+strings.Repeat("a", 800)+`","stream":false}`)
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), `"context_window":100`) {
+		t.Fatalf("expected 413 naming the window, got %d %s", rr.Code, rr.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.ContextOverflow.WithLabelValues("m")); got != 1 {
+		t.Errorf("expected one suspected overflow, got %v", got)
+	}
+	if shows.Load() != 1 {
+		t.Errorf("expected the window fetched once, got %d", shows.Load())
+	}
+}
+
+func TestContextWindow_WarnModeForwards(t *testing.T) {
+	var shows atomic.Int32
+	h := newTestHandlerWithConfig(t, windowUpstream(t, "", &shows).URL, Config{ContextCheck: ContextCheckWarn})
+	warmContextWindow(t, h)
+
+	msgs := `[{"role":"system","content":"` + strings.Repeat("s", 300) + `"},{"role":"user","content":"` + strings.Repeat("u", 300) + `"}]`
+	if rr := postJSON(h, "/api/chat", `{"model":"m","messages":`+msgs+`,"stream":false}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected warn mode to forward, got %d", rr.Code)
+	}
+	if got := testutil.ToFloat64(h.metrics.ContextOverflow.WithLabelValues("m")); got != 1 {
+		t.Errorf("expected every message counted towards the estimate, got %v", got)
+	}
+}
+
+func TestContextWindow_NumCtxWins(t *testing.T) {
+	var shows atomic.Int32
+	h := newTestHandlerWithConfig(t, windowUpstream(t, "num_ctx                        1000\nstop \"<|eot|>\"", &shows).URL,
+		Config{ContextCheck: ContextCheckReject})
+	warmContextWindow(t, h)
+
+	long := `"prompt":"` + strings.Repeat("a", 800) + `"`
+	if rr := postJSON(h, "/api/generate", `{"model":"m",`+long+`,"stream":false}`); rr.Code != http.StatusOK {
+		t.Errorf("expected the Modelfile num_ctx to win over context_length, got %d", rr.Code)
+	}
+	if rr := postJSON(h, "/api/generate", `{"model":"m",`+long+`,"options":{"num_ctx":50},"stream":false}`); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected the request's num_ctx to win, got %d", rr.Code)
+	}
+}
+
+func TestContextWindow_OffByDefault(t *testing.T) {
+	var shows atomic.Int32
+	h := newTestHandler(t, windowUpstream(t, "", &shows).URL)
+	postJSON(h, "/api/generate", `{"model":"m","prompt":"`+strings.Repeat("a", 800)+`","stream":false}`)
+	if shows.Load() != 0 {
+		t.Errorf("expected no /api/show fetch, got %d", shows.Load())
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConversationTTL = 30 * time.Minute
+	defaultConversationMax = 10000
+)
+
+// conversation is what is known about one client conversation. It is keyed
+// by a hash of the client's ID; the ID itself is not kept.
+type conversation struct {
+	key      uint64
+	turns    int
+	tokens   int64
+	first    time.Time
+	lastSeen time.Time
+	elem     *list.Element
+}
+
+// conversationTracker follows conversations identified by a request header.
+// A conversation ends when it has been idle for ttl, or early when max
+// conversations are open and a new one starts; either way its turns, tokens
+// and duration are observed then.
+type conversationTracker struct {
+	h   *Handler
+	ttl time.Duration
+	max int
+
+	mu    sync.Mutex
+	byKey map[uint64]*conversation
+	order *list.List // of *conversation, least recently seen at the back
+}
+
+func newConversationTracker(h *Handler, ttl time.Duration, limit int) *conversationTracker {
+	if ttl <= 0 {
+		ttl = defaultConversationTTL
+	}
+	if limit <= 0 {
+		limit = defaultConversationMax
+	}
+	return &conversationTracker{
+		h: h, ttl: ttl, max: limit,
+		byKey: map[uint64]*conversation{},
+		order: list.New(),
+	}
+}
+
+// record adds one completed turn with its tokens to conversation id.
+func (t *conversationTracker) record(id string, tokens int64, now time.Time) {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(id))
+	key := f.Sum64()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.byKey[key]
+	if !ok {
+		for len(t.byKey) >= t.max {
+			t.end(t.order.Back().Value.(*conversation))
+		}
+		c = &conversation{key: key, first: now}
+		c.elem = t.order.PushFront(c)
+		t.byKey[key] = c
+	} else {
+		t.order.MoveToFront(c.elem)
+	}
+	c.turns++
+	c.tokens += tokens
+	c.lastSeen = now
+	t.h.metrics.ActiveConversations.Set(float64(len(t.byKey)))
+}
+
+// expire ends the conversations idle for longer than ttl.
+func (t *conversationTracker) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for e := t.order.Back(); e != nil; e = t.order.Back() {
+		c := e.Value.(*conversation)
+		if now.Sub(c.lastSeen) < t.ttl {
+			break
+		}
+		t.end(c)
+	}
+	t.h.metrics.ActiveConversations.Set(float64(len(t.byKey)))
+}
+
+// end observes c and forgets it. t.mu must be held.
+func (t *conversationTracker) end(c *conversation) {
+	t.order.Remove(c.elem)
+	delete(t.byKey, c.key)
+	m := t.h.metrics
+	m.ConversationTurns.Observe(float64(c.turns))
+	m.ConversationTokens.Observe(float64(c.tokens))
+	m.ConversationDuration.Observe(c.lastSeen.Sub(c.first).Seconds())
+}
+
+// run expires idle conversations until ctx is done.
+func (t *conversationTracker) run(ctx context.Context) {
+	tick := time.NewTicker(min(max(t.ttl/4, time.Millisecond), time.Minute))
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			t.expire(now)
+		}
+	}
+}
+
+// trackConversation records a completed request against the conversation
+// named by its ConversationHeader. Requests without the header are ignored.
+func (h *Handler) trackConversation(ri *reqInfo, tokens int64) {
+	if h.conversations == nil || isCanary(ri.r) {
+		return
+	}
+	if id := ri.r.Header.Get(h.cfg.ConversationHeader); id != "" {
+		h.conversations.record(id, tokens, time.Now())
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func generateInConversation(h *Handler, id string) {
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	if id != "" {
+		req.Header.Set("X-Conversation-ID", id)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestConversation_ObservedOnExpiry(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{ConversationHeader: "X-Conversation-ID", ConversationTTL: time.Hour})
+	generateInConversation(h, "a")
+	generateInConversation(h, "a")
+	generateInConversation(h, "b")
+	generateInConversation(h, "") // not tracked
+
+	if got := testutil.ToFloat64(h.metrics.ActiveConversations); got != 2 {
+		t.Fatalf("expected 2 active conversations, got %v", got)
+	}
+	if n := testutil.CollectAndCount(h.metrics.ConversationTurns); n != 1 || histogramCount(t, h.metrics.ConversationTurns) != 0 {
+		t.Fatal("expected nothing observed before the conversations end")
+	}
+
+	h.conversations.expire(time.Now().Add(2 * time.Hour))
+	if got := testutil.ToFloat64(h.metrics.ActiveConversations); got != 0 {
+		t.Errorf("expected no active conversations, got %v", got)
+	}
+	if got := histogramSum(t, h.metrics.ConversationTurns); got != 3 {
+		t.Errorf("expected 3 turns in total, got %v", got)
+	}
+	if got := histogramSum(t, h.metrics.ConversationTokens); got != 300 {
+		t.Errorf("expected 300 tokens in total, got %v", got)
+	}
+	if got := histogramCount(t, h.metrics.ConversationDuration); got != 2 {
+		t.Errorf("expected 2 durations, got %v", got)
+	}
+}
+
+func TestConversation_EvictsLeastRecentWhenFull(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{ConversationHeader: "X-Conversation-ID", ConversationMax: 2})
+	generateInConversation(h, "a")
+	generateInConversation(h, "b")
+	generateInConversation(h, "a")
+	generateInConversation(h, "c") // ends b
+
+	if got := histogramSum(t, h.metrics.ConversationTurns); got != 1 {
+		t.Errorf("expected b's single turn observed, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.ActiveConversations); got != 2 {
+		t.Errorf("expected 2 active conversations, got %v", got)
+	}
+}
+
+func TestConversation_OffByDefault(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	generateInConversation(h, "a")
+	if h.conversations != nil || testutil.ToFloat64(h.metrics.ActiveConversations) != 0 {
+		t.Error("expected no conversation tracking without ConversationHeader")
+	}
+}
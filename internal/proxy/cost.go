@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TokenPrice is what a million prompt and a million completion tokens cost,
+// in whatever currency the price table is written in.
+type TokenPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// ParseTokenPrices parses a comma-separated price table such as
+// "ollama.com=0.6:2.4,ollama.com/gpt-oss:120b=0.15:0.6". Keys are an
+// upstream host:port, as in the upstream label, optionally followed by
+// /model; values are the prompt and completion price per million tokens,
+// or one price for both. An empty string yields an empty table.
+func ParseTokenPrices(s string) (map[string]TokenPrice, error) {
+	out := map[string]TokenPrice{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(item, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" || strings.HasPrefix(k, "/") {
+			return nil, fmt.Errorf("invalid entry %q: want host[/model]=price[:price]", item)
+		}
+		in, outPrice, both := strings.Cut(strings.TrimSpace(v), ":")
+		if !both {
+			outPrice = in
+		}
+		var p TokenPrice
+		var err error
+		if p.Prompt, err = strconv.ParseFloat(strings.TrimSpace(in), 64); err != nil || p.Prompt < 0 {
+			return nil, fmt.Errorf("invalid prompt price for %q: want a non-negative number", k)
+		}
+		if p.Completion, err = strconv.ParseFloat(strings.TrimSpace(outPrice), 64); err != nil || p.Completion < 0 {
+			return nil, fmt.Errorf("invalid completion price for %q: want a non-negative number", k)
+		}
+		out[k] = p
+	}
+	return out, nil
+}
+
+// tokenPrice looks up the price of model on upstream: the upstream/model
+// entry, with an implied :latest tag, and then the upstream's own entry.
+// Upstreams without one, a local Ollama typically, are free.
+func (h *Handler) tokenPrice(upstream, model string) (TokenPrice, bool) {
+	if model != "" {
+		names := []string{model}
+		if base, ok := strings.CutSuffix(model, ":latest"); ok {
+			names = append(names, base)
+		} else if !strings.Contains(model, ":") {
+			names = append(names, model+":latest")
+		}
+		for _, name := range names {
+			if p, ok := h.cfg.TokenPrices[upstream+"/"+name]; ok {
+				return p, true
+			}
+		}
+	}
+	p, ok := h.cfg.TokenPrices[upstream]
+	return p, ok
+}
+
+// observeCost adds what a response's tokens cost on the upstream that
+// served it to token_cost_total, which shares the labels of the token
+// counters so cloud spend can be told apart from local.
+func (h *Handler) observeCost(ri *reqInfo, endpoint, modelLabel string, promptTokens, completionTokens int64) {
+	if len(h.cfg.TokenPrices) == 0 || promptTokens+completionTokens <= 0 {
+		return
+	}
+	p, ok := h.tokenPrice(ri.upstreamLabel, ri.model)
+	if !ok {
+		return
+	}
+	cost := (float64(promptTokens)*p.Prompt + float64(completionTokens)*p.Completion) / 1e6
+	h.metrics.TokenCost.WithLabelValues(endpoint, modelLabel, ri.upstreamLabel, ri.clientName).Add(cost)
+}
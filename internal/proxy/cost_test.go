@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseTokenPrices(t *testing.T) {
+	got, err := ParseTokenPrices(" ollama.com=0.6:2.4, ollama.com/gpt-oss:120b = 0.15:0.6 ,gpu-a:11434=0,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]TokenPrice{
+		"ollama.com":              {Prompt: 0.6, Completion: 2.4},
+		"ollama.com/gpt-oss:120b": {Prompt: 0.15, Completion: 0.6},
+		"gpu-a:11434":             {},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	for _, bad := range []string{"ollama.com", "=1", "/m=1", "ollama.com=cheap", "ollama.com=1:-1", "ollama.com=1:2:3"} {
+		if _, err := ParseTokenPrices(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestTokenPrice_ModelOverridesUpstream(t *testing.T) {
+	h := newTestHandlerWithConfig(t, "http://127.0.0.1:1", Config{TokenPrices: map[string]TokenPrice{
+		"ollama.com":              {Prompt: 1, Completion: 1},
+		"ollama.com/gpt-oss:120b": {Prompt: 2, Completion: 3},
+		"ollama.com/qwen3:latest": {Prompt: 4, Completion: 4},
+	}})
+	for _, tc := range []struct {
+		upstream, model string
+		want            TokenPrice
+		ok              bool
+	}{
+		{"ollama.com", "gpt-oss:120b", TokenPrice{2, 3}, true},
+		{"ollama.com", "qwen3", TokenPrice{4, 4}, true},
+		{"ollama.com", "llama3:8b", TokenPrice{1, 1}, true},
+		{"ollama.com", "", TokenPrice{1, 1}, true},
+		{"127.0.0.1:11434", "gpt-oss:120b", TokenPrice{}, false},
+	} {
+		if got, ok := h.tokenPrice(tc.upstream, tc.model); got != tc.want || ok != tc.ok {
+			t.Errorf("%s %s: expected %v %v, got %v %v", tc.upstream, tc.model, tc.want, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestTokenCost_ByUpstream(t *testing.T) {
+	var got []string
+	priced := authUpstream(t, &got)
+	host := strings.TrimPrefix(priced.URL, "http://")
+	h := newTestHandlerWithConfig(t, priced.URL, Config{TokenPrices: map[string]TokenPrice{
+		host + "/m": {Prompt: 1, Completion: 2},
+	}})
+	generateWithAuth(h, "")
+
+	// 3 prompt and 5 completion tokens at 1 and 2 per million.
+	if v := testutil.ToFloat64(h.metrics.TokenCost.WithLabelValues("/api/generate", "m", host, "")); math.Abs(v-13e-6) > 1e-12 {
+		t.Errorf("expected a cost of 13e-6, got %v", v)
+	}
+
+	local := authUpstream(t, &got)
+	h = newTestHandlerWithConfig(t, local.URL, Config{TokenPrices: map[string]TokenPrice{host: {Prompt: 1, Completion: 1}}})
+	generateWithAuth(h, "")
+	if n := testutil.CollectAndCount(h.metrics.TokenCost); n != 0 {
+		t.Errorf("expected no cost for an unpriced upstream, got %d series", n)
+	}
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Response headers the proxy adds to what the upstream sent.
+const (
+	headerQueueWait      = "X-Ollama-Queue-Wait-Ms"
+	headerAccelBuffering = "X-Accel-Buffering"
+	headerServerTiming   = "Server-Timing"
+	headerRetryAfter     = "Retry-After"
+	headerAuthError      = "X-Auth-Error"
+	headerAuthenticate   = "WWW-Authenticate"
+	headerRateLimit      = "X-RateLimit-"
+	headerServedBy       = "X-Served-By"
+	headerUpstream       = "X-Upstream"
+)
+
+// MetricDescription is one metric family the proxy exports.
+type MetricDescription struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Help    string    `json:"help"`
+	Labels  []string  `json:"labels"`
+	Buckets []float64 `json:"buckets,omitempty"`
+}
+
+// recordingRegisterer registers collectors and remembers them.
+type recordingRegisterer struct {
+	prometheus.Registerer
+	collectors []prometheus.Collector
+}
+
+func (r *recordingRegisterer) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+func (r *recordingRegisterer) MustRegister(cs ...prometheus.Collector) {
+	r.Registerer.MustRegister(cs...)
+	r.collectors = append(r.collectors, cs...)
+}
+
+// DescribeMetrics returns every metric family NewMetricsWithOptions
+// registers with opts, sorted by name. The metrics are built into a private
+// registry and each vector gets one child, so the names, types, labels and
+// buckets are the ones a proxy with opts exports.
+func DescribeMetrics(opts MetricsOptions) ([]MetricDescription, error) {
+	reg := prometheus.NewRegistry()
+	rec := &recordingRegisterer{Registerer: reg}
+	NewMetricsWithOptions(rec, opts)
+	for _, c := range rec.collectors {
+		if err := addChild(c); err != nil {
+			return nil, err
+		}
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MetricDescription, 0, len(families))
+	for _, f := range families {
+		d := MetricDescription{
+			Name:   f.GetName(),
+			Type:   strings.ToLower(f.GetType().String()),
+			Help:   f.GetHelp(),
+			Labels: []string{},
+		}
+		m := f.GetMetric()[0]
+		for _, l := range m.GetLabel() {
+			d.Labels = append(d.Labels, l.GetName())
+		}
+		for _, b := range m.GetHistogram().GetBucket() {
+			d.Buckets = append(d.Buckets, b.GetUpperBound())
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// addChild creates one child of a metric vector so that it is gathered.
+// Vectors do not tell their number of labels; it is found by trying.
+func addChild(c prometheus.Collector) error {
+	var vec *prometheus.MetricVec
+	switch v := c.(type) {
+	case *prometheus.CounterVec:
+		vec = v.MetricVec
+	case *prometheus.GaugeVec:
+		vec = v.MetricVec
+	case *prometheus.HistogramVec:
+		vec = v.MetricVec
+	case *prometheus.SummaryVec:
+		vec = v.MetricVec
+	default:
+		return nil // a single metric, always gathered
+	}
+	for n := 1; n <= 16; n++ {
+		if _, err := vec.GetMetricWithLabelValues(make([]string, n)...); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("describe %T: no label count up to 16 accepted", c)
+}
+
+// HeaderDescription is a response header the proxy may add.
+type HeaderDescription struct {
+	Name string `json:"name"`
+	When string `json:"when"`
+}
+
+// ResponseHeaders returns the response headers a Handler with cfg may add to
+// the upstream's, besides the standard framing ones.
+func ResponseHeaders(cfg Config) []HeaderDescription {
+	out := []HeaderDescription{
+		{headerQueueWait, "every admitted proxied request: time spent in the proxy queue"},
+		{headerAccelBuffering, "streamed responses, to keep buffering proxies out"},
+		{headerRetryAfter, "rejections that can be retried later"},
+	}
+	if cfg.ServerTiming {
+		out = append(out, HeaderDescription{headerServerTiming, "proxied responses, as a trailer on streams"})
+	}
+	if cfg.ServedByHeader {
+		out = append(out, HeaderDescription{headerServedBy, "every proxied response: the proxy instance that served it"})
+	}
+	if cfg.ExposeUpstreamNames {
+		out = append(out, HeaderDescription{headerUpstream, "forwarded responses: the upstream host:port the request went to"})
+	}
+	if cfg.CompressResponses {
+		out = append(out,
+			HeaderDescription{"Content-Encoding", "gzip, for clients that accept it"},
+			HeaderDescription{"Vary", "Accept-Encoding, on compressed responses"})
+	}
+	if cfg.RateLimit > 0 {
+		out = append(out, rateLimitHeaders("Requests", "requests counted by the rate limit")...)
+	}
+	if cfg.TPMLimit > 0 {
+		out = append(out, rateLimitHeaders("Tokens", "requests counted by the tokens-per-minute limit")...)
+	}
+	out = append(out,
+		HeaderDescription{headerAuthenticate, "401 responses of authenticated endpoints"},
+		HeaderDescription{headerAuthError, "401 responses of authenticated endpoints: missing, malformed or invalid"})
+	return out
+}
+
+func rateLimitHeaders(unit, when string) []HeaderDescription {
+	return []HeaderDescription{
+		{headerRateLimit + "Limit-" + unit, when},
+		{headerRateLimit + "Remaining-" + unit, when},
+		{headerRateLimit + "Reset-" + unit, when},
+	}
+}
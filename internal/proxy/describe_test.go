@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDescribeMetrics_MatchesLiveRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	u, _ := url.Parse(tokenUpstream(t).URL)
+	h := New(u, openTestDB(t), slog.New(slog.NewTextHandler(io.Discard, nil)), NewMetrics(reg),
+		Config{MaxConcurrentPerModel: 1, DuplicateSampleRate: 1, ConversationHeader: "X-Conversation-ID"})
+	t.Cleanup(func() { _ = h.Close() })
+	for _, body := range []string{`{"model":"m"}`, `{"model":"m","stream":false}`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body))
+		req.Header.Set("X-Conversation-ID", "c")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+
+	described, err := DescribeMetrics(MetricsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]MetricDescription{}
+	for _, d := range described {
+		byName[d.Name] = d
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) < 10 {
+		t.Fatalf("expected the requests to produce metrics, got %d families", len(families))
+	}
+	for _, f := range families {
+		d, ok := byName[f.GetName()]
+		if !ok {
+			t.Errorf("%s is exported but not described", f.GetName())
+			continue
+		}
+		if typ := strings.ToLower(f.GetType().String()); d.Type != typ {
+			t.Errorf("%s: described as %s, exported as %s", d.Name, d.Type, typ)
+		}
+		for _, m := range f.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetName())
+			}
+			if !slices.Equal(labels, d.Labels) {
+				t.Errorf("%s: described with labels %v, exported with %v", d.Name, d.Labels, labels)
+			}
+		}
+	}
+}
+
+func TestDescribeMetrics_Options(t *testing.T) {
+	described, err := DescribeMetrics(MetricsOptions{Namespace: "gw", DurationBuckets: []float64{1, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range described {
+		if !strings.HasPrefix(d.Name, "gw_") {
+			t.Errorf("expected every name in the namespace, got %s", d.Name)
+		}
+		if d.Name == "gw_request_duration_seconds" && !slices.Equal(d.Buckets, []float64{1, 10}) {
+			t.Errorf("expected the configured buckets, got %v", d.Buckets)
+		}
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	names := func(cfg Config) []string {
+		var out []string
+		for _, hd := range ResponseHeaders(cfg) {
+			out = append(out, hd.Name)
+		}
+		return out
+	}
+	if got := names(Config{}); slices.Contains(got, headerServerTiming) || slices.Contains(got, "X-RateLimit-Limit-Requests") {
+		t.Errorf("expected no optional headers by default, got %v", got)
+	}
+	got := names(Config{ServerTiming: true, RateLimit: 10, TPMLimit: 100})
+	for _, want := range []string{headerQueueWait, headerServerTiming, "X-RateLimit-Remaining-Requests", "X-RateLimit-Reset-Tokens"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("expected %s with its feature on, got %v", want, got)
+		}
+	}
+}
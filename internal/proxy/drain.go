@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// statusShutdown is the status label of requests canceled because the
+	// proxy shut down before they finished.
+	statusShutdown = "shutdown"
+
+	// abortedRecordTimeout bounds how long Drain waits for canceled
+	// requests to be recorded.
+	abortedRecordTimeout = 5 * time.Second
+)
+
+// errShutdown is the cause of the context of requests Drain canceled.
+var errShutdown = errors.New("proxy shutting down")
+
+// drainState tracks the proxied requests in flight for a graceful shutdown.
+type drainState struct {
+	active   atomic.Int64
+	draining atomic.Bool
+	stop     context.Context // canceled when Drain gives up waiting
+	abort    context.CancelFunc
+}
+
+func newDrainState() *drainState {
+	d := &drainState{}
+	d.stop, d.abort = context.WithCancel(context.Background())
+	return d
+}
+
+// enter counts a request in flight and returns its request with a context
+// that ends when the client goes away or Drain gives up on it, and the
+// function to call when it is done.
+func (d *drainState) enter(r *http.Request) (*http.Request, func()) {
+	d.active.Add(1)
+	ctx, cancel := context.WithCancelCause(r.Context())
+	stop := context.AfterFunc(d.stop, func() { cancel(errShutdown) })
+	return r.WithContext(ctx), func() {
+		stop()
+		cancel(nil)
+		d.active.Add(-1)
+	}
+}
+
+// wait returns once no request is in flight, or with ctx's error.
+func (d *drainState) wait(ctx context.Context) error {
+	tick := time.NewTicker(20 * time.Millisecond)
+	defer tick.Stop()
+	for d.active.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+// shutDown reports whether ctx was canceled by Drain.
+func shutDown(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errShutdown)
+}
+
+// Drain begins a graceful shutdown: new requests are refused with 503 and
+// Drain waits for those in flight, streams included, to finish. When ctx
+// ends first, the remaining requests are canceled and counted with status
+// "shutdown"; Drain returns ctx's error once they were recorded. Endpoints
+// other than the proxied API, such as /metrics, are not affected.
+func (h *Handler) Drain(ctx context.Context) error {
+	h.drain.draining.Store(true)
+	h.checkReady(time.Now())
+	err := h.drain.wait(ctx)
+	if err == nil {
+		return nil
+	}
+	h.logger.Warn("shutdown timeout reached, canceling requests in flight", "requests", h.drain.active.Load())
+	h.drain.abort()
+	recorded, cancel := context.WithTimeout(context.Background(), abortedRecordTimeout)
+	defer cancel()
+	_ = h.drain.wait(recorded)
+	return err
+}
+
+// rejectDraining refuses a request that arrived after Drain began. It
+// returns false after answering it.
+func (h *Handler) rejectDraining(w http.ResponseWriter, ri *reqInfo) bool {
+	if !h.drain.draining.Load() {
+		return true
+	}
+	w.Header().Set("Connection", "close")
+	h.reject(w, ri, reasonShuttingDown, http.StatusServiceUnavailable, time.Time{}, map[string]any{
+		"error": "proxy shutting down",
+	})
+	return false
+}
+
+// shutdownCanceled records a request Drain canceled in phase and tells its
+// client, who is still there, that the proxy is going away.
+func (h *Handler) shutdownCanceled(w http.ResponseWriter, ri *reqInfo, phase string) {
+	h.metrics.ReqTotal.WithLabelValues(ri.endpoint, ri.modelLabel, statusShutdown, ri.streamLabel, originProxy, ri.upstreamLabel, ri.clientName).Inc()
+	h.observeDuration(ri.endpoint, ri.modelLabel, ri.streamLabel, ri.clientName, statusClassError, time.Since(ri.received), 0)
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": "proxy shutting down"})
+	h.recordFailure(ri, http.StatusServiceUnavailable, "proxy shut down ("+phase+")", "cancel_phase", phase)
+}
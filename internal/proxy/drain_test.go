@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// heldUpstream streams a first chunk, then waits for release or the request
+// to end before the final one. With headers false it sends nothing at all
+// until then.
+func heldUpstream(t *testing.T, headers bool) (*httptest.Server, chan struct{}) {
+	t.Helper()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body) // so that a closed connection ends r's context
+		if headers {
+			_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = fmt.Fprintln(w, `{"response":"b","done":true,"eval_count":2}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, release
+}
+
+func TestDrain_WaitsForRequestsInFlight(t *testing.T) {
+	upstream, release := heldUpstream(t, true)
+	h := newTestHandler(t, upstream.URL)
+	rr := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`)))
+		close(served)
+	}()
+	waitFor(t, "the request in flight", func() bool { return h.drain.active.Load() == 1 })
+
+	drained := make(chan error, 1)
+	go func() { drained <- h.Drain(context.Background()) }()
+	waitFor(t, "the drain to begin", func() bool { return h.drain.draining.Load() })
+
+	late := httptest.NewRecorder()
+	h.ServeHTTP(late, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`)))
+	if late.Code != http.StatusServiceUnavailable || late.Header().Get("Connection") != "close" {
+		t.Errorf("expected a request during the drain refused with 503 and Connection: close, got %d %q", late.Code, late.Header().Get("Connection"))
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonShuttingDown)); got != 1 {
+		t.Errorf("expected the refusal counted as %s, got %v", reasonShuttingDown, got)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("expected Drain to wait for the stream, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("expected the drain to complete, got %v", err)
+	}
+	<-served
+	if !strings.Contains(rr.Body.String(), `"done":true`) {
+		t.Errorf("expected the stream to finish, got %q", rr.Body.String())
+	}
+}
+
+func TestDrain_TimeoutCancelsWithShutdownStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		headers     bool
+		body        string
+		stream      string
+		wantCode    int
+		wantPartial bool
+	}{
+		{"mid-stream", true, `{"model":"m"}`, "true", http.StatusOK, true},
+		{"waiting for headers", false, `{"model":"m","stream":false}`, "false", http.StatusServiceUnavailable, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream, _ := heldUpstream(t, tc.headers)
+			h := newTestHandler(t, upstream.URL)
+			rr := httptest.NewRecorder()
+			served := make(chan struct{})
+			go func() {
+				h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(tc.body)))
+				close(served)
+			}()
+			waitFor(t, "the request in flight", func() bool { return h.drain.active.Load() == 1 })
+			if tc.headers {
+				waitFor(t, "the first chunk", func() bool { return histogramCount(t, h.metrics.TTFT.WithLabelValues("/api/generate", "m")) == 1 })
+			} else {
+				time.Sleep(20 * time.Millisecond) // forwarded, waiting on the upstream
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := h.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected the drain to time out, got %v", err)
+			}
+			<-served
+
+			if rr.Code != tc.wantCode || strings.Contains(rr.Body.String(), "a\"") != tc.wantPartial {
+				t.Errorf("expected %d (partial stream %t), got %d %q", tc.wantCode, tc.wantPartial, rr.Code, rr.Body.String())
+			}
+			if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", statusShutdown, tc.stream, originProxy, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+				t.Errorf("expected the request counted with status %s, got %v", statusShutdown, got)
+			}
+			for _, phase := range []string{cancelHeaders, cancelStream} {
+				if got := testutil.ToFloat64(h.metrics.ClientCancellations.WithLabelValues("/api/generate", phase)); got != 0 {
+					t.Errorf("expected no client cancellation in %s, got %v", phase, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultDuplicateTrackSize is how many distinct prompt hashes are
+	// remembered when Config.DuplicateTrackSize is 0.
+	defaultDuplicateTrackSize = 10000
+	// duplicateRatioWindow is how many sampled requests the ratio gauge
+	// covers.
+	duplicateRatioWindow = 1000
+)
+
+// duplicateDetector spots repeated (model, prompt) pairs. It keeps only
+// 64-bit hashes, in a fixed-size ring with first-in first-out eviction, so
+// its memory does not depend on traffic. Sampling is decided by the hash as
+// well: a sampled prompt is sampled again every time it repeats, so the
+// duplicate ratio of the sample estimates that of the whole traffic.
+type duplicateDetector struct {
+	threshold uint64 // hashes below it are sampled
+
+	mu      sync.Mutex
+	ring    []uint64
+	next    int
+	filled  bool
+	seen    map[uint64]int // hash → occurrences in ring
+	results []bool         // last duplicateRatioWindow samples; true = duplicate
+	resNext int
+	resLen  int
+	dups    int // true entries in results
+}
+
+func newDuplicateDetector(rate float64, size int) *duplicateDetector {
+	if size <= 0 {
+		size = defaultDuplicateTrackSize
+	}
+	d := &duplicateDetector{
+		ring:    make([]uint64, size),
+		seen:    make(map[uint64]int, size),
+		results: make([]bool, duplicateRatioWindow),
+	}
+	if rate >= 1 {
+		d.threshold = math.MaxUint64
+	} else {
+		d.threshold = uint64(rate * math.MaxUint64)
+	}
+	return d
+}
+
+// promptHash hashes model and prompt, ignoring case and whitespace runs so
+// trivially reformatted retries still match.
+func promptHash(model, prompt string) uint64 {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(canonicalModel(model)))
+	_, _ = f.Write([]byte{0})
+	_, _ = f.Write([]byte(strings.ToLower(strings.Join(strings.Fields(prompt), " "))))
+	return f.Sum64()
+}
+
+// observe records hash and reports whether it was sampled and whether it had
+// been seen before, with the duplicate ratio over the recent samples.
+func (d *duplicateDetector) observe(hash uint64) (sampled, dup bool, ratio float64) {
+	if d.threshold != math.MaxUint64 && hash >= d.threshold {
+		return false, false, 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dup = d.seen[hash] > 0
+	if d.filled {
+		old := d.ring[d.next]
+		if d.seen[old]--; d.seen[old] <= 0 {
+			delete(d.seen, old)
+		}
+	}
+	d.ring[d.next] = hash
+	d.seen[hash]++
+	d.next = (d.next + 1) % len(d.ring)
+	d.filled = d.filled || d.next == 0
+
+	if d.resLen == len(d.results) && d.results[d.resNext] {
+		d.dups--
+	}
+	d.results[d.resNext] = dup
+	if dup {
+		d.dups++
+	}
+	d.resNext = (d.resNext + 1) % len(d.results)
+	d.resLen = min(d.resLen+1, len(d.results))
+	return true, dup, float64(d.dups) / float64(d.resLen)
+}
+
+// observeDuplicate feeds a generate or chat prompt to the duplicate detector.
+// Chat requests are keyed by the whole conversation, not just its last turn.
+func (h *Handler) observeDuplicate(ri *reqInfo, p requestPayload) {
+	if h.duplicates == nil || isCanary(ri.r) {
+		return
+	}
+	prompt := p.Prompt
+	switch endpointClass(ri.endpoint) {
+	case "generate":
+	case "chat":
+		var b strings.Builder
+		for _, m := range p.Messages {
+			b.WriteString(m.Role)
+			b.WriteByte(0)
+			b.WriteString(m.Content)
+			b.WriteByte(0)
+		}
+		prompt = b.String()
+	default:
+		return
+	}
+	sampled, dup, ratio := h.duplicates.observe(promptHash(ri.model, prompt))
+	if !sampled {
+		return
+	}
+	if dup {
+		h.metrics.DuplicatePrompts.WithLabelValues(ri.endpoint, ri.modelLabel).Inc()
+	}
+	h.metrics.DuplicateRatio.Set(ratio)
+}
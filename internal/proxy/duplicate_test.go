@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func postJSON(h *Handler, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestDuplicate_CountsRepeatedPrompts(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{DuplicateSampleRate: 1})
+	postJSON(h, "/api/generate", `{"model":"m","prompt":"Why is the sky blue?","stream":false}`)
+	postJSON(h, "/api/generate", `{"model":"m","prompt":"  why is the SKY   blue? ","stream":false}`)
+	postJSON(h, "/api/generate", `{"model":"other","prompt":"Why is the sky blue?","stream":false}`)
+	postJSON(h, "/api/generate", `{"model":"m","prompt":"Something else","stream":false}`)
+
+	if got := testutil.ToFloat64(h.metrics.DuplicatePrompts.WithLabelValues("/api/generate", "m")); got != 1 {
+		t.Errorf("expected 1 duplicate for m, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.DuplicateRatio); got != 0.25 {
+		t.Errorf("expected ratio 0.25, got %v", got)
+	}
+}
+
+func TestDuplicate_ChatKeyedByConversation(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{DuplicateSampleRate: 1})
+	a := `{"model":"m","stream":false,"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"A"},{"role":"user","content":"more"}]}`
+	b := `{"model":"m","stream":false,"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"B"},{"role":"user","content":"more"}]}`
+	postJSON(h, "/api/chat", a)
+	postJSON(h, "/api/chat", b)
+	if n := testutil.CollectAndCount(h.metrics.DuplicatePrompts); n != 0 {
+		t.Fatalf("different conversations must not match, got %d series", n)
+	}
+	postJSON(h, "/api/chat", a)
+	if got := testutil.ToFloat64(h.metrics.DuplicatePrompts.WithLabelValues("/api/chat", "m")); got != 1 {
+		t.Errorf("expected 1 duplicate, got %v", got)
+	}
+}
+
+func TestDuplicate_OffByDefault(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	postJSON(h, "/api/generate", `{"model":"m","prompt":"x","stream":false}`)
+	postJSON(h, "/api/generate", `{"model":"m","prompt":"x","stream":false}`)
+	if n := testutil.CollectAndCount(h.metrics.DuplicatePrompts); n != 0 {
+		t.Errorf("expected no duplicate series, got %d", n)
+	}
+}
+
+func TestDuplicateDetector_Bounded(t *testing.T) {
+	d := newDuplicateDetector(1, 4)
+	for i := range uint64(100) {
+		d.observe(i)
+	}
+	if len(d.seen) != 4 {
+		t.Errorf("expected 4 remembered hashes, got %d", len(d.seen))
+	}
+	if _, dup, _ := d.observe(0); dup {
+		t.Error("an evicted hash must not count as a duplicate")
+	}
+	if _, dup, _ := d.observe(99); !dup {
+		t.Error("a remembered hash must count as a duplicate")
+	}
+}
+
+func TestDuplicateDetector_SamplesByHash(t *testing.T) {
+	d := newDuplicateDetector(0.5, 16)
+	low, high := uint64(1), uint64(1<<63+1)
+	if sampled, _, _ := d.observe(high); sampled {
+		t.Error("expected a hash above the threshold to be skipped")
+	}
+	d.observe(low)
+	if sampled, dup, _ := d.observe(low); !sampled || !dup {
+		t.Error("expected a sampled hash to be sampled again on repeat")
+	}
+}
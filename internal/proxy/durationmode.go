@@ -0,0 +1,61 @@
+package proxy
+
+import "time"
+
+// Duration modes, for MetricsOptions.DurationMode: the point of a response
+// that request_duration_seconds measures to, from when the request body was
+// received.
+const (
+	DurationClientWrite  = "client-write"  // the response written to the client
+	DurationUpstreamBody = "upstream-body" // the upstream's body read to its end
+	DurationHeaders      = "headers"       // the upstream's response headers received
+)
+
+// DurationModes are the valid values of MetricsOptions.DurationMode.
+var DurationModes = []string{DurationClientWrite, DurationUpstreamBody, DurationHeaders}
+
+// Phases of a proxied response, the phase label of request_phase_seconds.
+const (
+	phaseHeaders      = "headers"
+	phaseUpstreamBody = "upstream_body"
+	phaseClientWrite  = "client_write"
+)
+
+// durationHelp is the help of request_duration_seconds under mode, which
+// says what it measures so that dashboards read it right.
+func durationHelp(mode string) string {
+	until := "the response was written to the client, a slow reader included"
+	switch mode {
+	case DurationUpstreamBody:
+		until = "the upstream's response body was read to its end"
+	case DurationHeaders:
+		until = "the upstream's response headers arrived"
+	default:
+		mode = DurationClientWrite
+	}
+	return "Duration of Ollama requests handled by the proxy, from when the request body was received until " +
+		until + " (duration mode " + mode + "); requests without an upstream response end when the proxy answered."
+}
+
+// observePhases records the phases of a response the upstream answered,
+// as reached by now, and returns the request's duration per DurationMode.
+func (h *Handler) observePhases(ri *reqInfo, now time.Time) time.Duration {
+	observe := func(phase string, at time.Time) time.Duration {
+		d := at.Sub(ri.received)
+		h.metrics.RequestPhase.WithLabelValues(ri.endpoint, ri.modelLabel, ri.streamLabel, phase).Observe(d.Seconds())
+		return d
+	}
+	headers := observe(phaseHeaders, ri.upstreamStart.Add(ri.upstreamTTFB))
+	body := time.Duration(-1)
+	if !ri.upstreamDone.IsZero() {
+		body = observe(phaseUpstreamBody, ri.upstreamDone)
+	}
+	written := observe(phaseClientWrite, now)
+	switch {
+	case h.metrics.durationMode == DurationHeaders:
+		return headers
+	case h.metrics.durationMode == DurationUpstreamBody && body >= 0:
+		return body
+	}
+	return written
+}
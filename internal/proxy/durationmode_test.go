@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// slowReader is a client that takes delay to receive each write.
+type slowReader struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+}
+
+func (s *slowReader) Write(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.ResponseRecorder.Write(p)
+}
+
+func TestDurationMode(t *testing.T) {
+	const step = 150 * time.Millisecond
+	// Headers at once, the body a step later, and a client that takes
+	// another step to read it.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(step)
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true,"eval_count":1}`)
+	}))
+	defer upstream.Close()
+	u, _ := ParseUpstream(upstream.URL)
+
+	for _, tc := range []struct {
+		mode     string
+		min, max time.Duration
+	}{
+		{"", 2 * step, 4 * step},
+		{DurationClientWrite, 2 * step, 4 * step},
+		{DurationUpstreamBody, step, 2 * step},
+		{DurationHeaders, 0, step},
+	} {
+		reg := prometheus.NewRegistry()
+		metrics := NewMetricsWithOptions(reg, MetricsOptions{DurationMode: tc.mode})
+		h := New(u, openTestDB(t), slog.New(slog.NewTextHandler(io.Discard, nil)), metrics, Config{})
+		t.Cleanup(func() { _ = h.Close() })
+
+		w := &slowReader{ResponseRecorder: httptest.NewRecorder(), delay: step}
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tc.mode, w.Code)
+		}
+		got := time.Duration(histogramSum(t, metrics.ReqDuration.WithLabelValues("/api/generate", "m", "false", "")) * float64(time.Second))
+		if got < tc.min || got >= tc.max {
+			t.Errorf("%q: expected a duration in [%s, %s), got %s", tc.mode, tc.min, tc.max, got)
+		}
+		for _, phase := range []string{phaseHeaders, phaseUpstreamBody, phaseClientWrite} {
+			if n := histogramCount(t, metrics.RequestPhase.WithLabelValues("/api/generate", "m", "false", phase)); n != 1 {
+				t.Errorf("%q: expected phase %s observed once whatever the mode, got %d", tc.mode, phase, n)
+			}
+		}
+
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := tc.mode
+		if want == "" {
+			want = DurationClientWrite
+		}
+		for _, f := range families {
+			if f.GetName() == "ollama_proxy_request_duration_seconds" && !strings.Contains(f.GetHelp(), "(duration mode "+want+")") {
+				t.Errorf("%q: expected the help to name the mode, got %q", tc.mode, f.GetHelp())
+			}
+		}
+	}
+}
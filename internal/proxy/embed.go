@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// embedBatchSize returns how many inputs an /api/embed request carries: 1 for
+// a string, the element count for an array of strings or token arrays. ok is
+// false when input is missing or malformed.
+func embedBatchSize(input json.RawMessage) (n int, ok bool) {
+	input = bytes.TrimSpace(input)
+	if len(input) == 0 {
+		return 0, false
+	}
+	switch input[0] {
+	case '"':
+		var s string
+		return 1, json.Unmarshal(input, &s) == nil
+	case '[':
+		var items []json.RawMessage
+		if json.Unmarshal(input, &items) != nil || len(items) == 0 {
+			return 0, false
+		}
+		return len(items), true
+	}
+	return 0, false
+}
+
+// observeEmbedBatch records the batch size of an /api/embed request.
+func (h *Handler) observeEmbedBatch(ri *reqInfo, p requestPayload) {
+	if !strings.HasSuffix(ri.endpoint, "/api/embed") {
+		return
+	}
+	n, ok := embedBatchSize(p.Input)
+	if !ok {
+		return
+	}
+	h.metrics.EmbedBatchSize.WithLabelValues(ri.modelLabel).Observe(float64(n))
+	if n == 1 {
+		h.metrics.EmbedSingleInputs.WithLabelValues(ri.modelLabel).Inc()
+	}
+}
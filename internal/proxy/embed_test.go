@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEmbedBatchSize(t *testing.T) {
+	cases := []struct {
+		input string
+		n     int
+		ok    bool
+	}{
+		{`"one"`, 1, true},
+		{`["a","b","c"]`, 3, true},
+		{`[[1,2],[3]]`, 2, true},
+		{`[]`, 0, false},
+		{`42`, 0, false},
+		{`["a",`, 0, false},
+		{``, 0, false},
+	}
+	for _, tc := range cases {
+		n, ok := embedBatchSize(json.RawMessage(tc.input))
+		if n != tc.n || ok != tc.ok {
+			t.Errorf("%q: got (%d, %v), want (%d, %v)", tc.input, n, ok, tc.n, tc.ok)
+		}
+	}
+}
+
+func TestEmbedBatch_Metrics(t *testing.T) {
+	h := newTestHandler(t, embedUpstream(t, 4).URL)
+	postJSON(h, "/api/embed", `{"model":"m","input":"hi"}`)
+	postJSON(h, "/api/embed", `{"model":"m","input":["a","b","c","d"]}`)
+	postJSON(h, "/api/embed", `{"model":"m","input":{"bad":true}}`)
+	postJSON(h, "/api/generate", `{"model":"m","input":["a","b"],"stream":false}`)
+
+	if got := histogramCount(t, h.metrics.EmbedBatchSize.WithLabelValues("m")); got != 2 {
+		t.Errorf("expected 2 observed batches, got %d", got)
+	}
+	if got := histogramSum(t, h.metrics.EmbedBatchSize.WithLabelValues("m")); got != 5 {
+		t.Errorf("expected 5 inputs in total, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.EmbedSingleInputs.WithLabelValues("m")); got != 1 {
+		t.Errorf("expected 1 single-input request, got %v", got)
+	}
+}
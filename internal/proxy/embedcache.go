@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// embedCacheMaxBytes bounds a stored embedding response; larger batches are
+// relayed as usual but not cached.
+const embedCacheMaxBytes = 4 << 20
+
+// embedEntry is an embedding response as SharedStore keeps it.
+type embedEntry struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// embedCacheable reports whether a request to endpoint is served from the
+// embedding cache.
+func (h *Handler) embedCacheable(method, endpoint string) bool {
+	return h.cfg.EmbedCacheTTL > 0 && method == http.MethodPost &&
+		(strings.HasSuffix(endpoint, "/api/embed") || strings.HasSuffix(endpoint, "/api/embeddings"))
+}
+
+// embedCacheKey is the SharedStore key of an embedding request: a hash of
+// the upstream it goes to, the endpoint, its Accept-Encoding and its body,
+// whitespace aside. Ollama's embeddings are deterministic for a model and
+// input, so replicas sharing a Redis store share the entries.
+func embedCacheKey(upReq *http.Request, endpoint string, body []byte) string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) != nil {
+		compact.Reset()
+		compact.Write(body)
+	}
+	sum := sha256.New()
+	for _, part := range []string{upReq.URL.Scheme + "://" + upReq.URL.Host, endpoint, upReq.Header.Get("Accept-Encoding")} {
+		sum.Write([]byte(part))
+		sum.Write([]byte{0})
+	}
+	sum.Write(compact.Bytes())
+	return "embed:" + hex.EncodeToString(sum.Sum(nil))
+}
+
+// embedRoundTrip answers an embedding request from SharedStore, or sends it
+// upstream and stores a 200 response for EmbedCacheTTL. A store that fails
+// counts as a miss; kv.Fallback already degrades a Redis outage to memory.
+func (h *Handler) embedRoundTrip(upReq *http.Request, endpoint string) (*http.Response, string, error) {
+	body, ok := readReplayable(upReq)
+	if !ok {
+		resp, err := h.clientFor(endpoint).Do(upReq)
+		return resp, "", err
+	}
+	ctx := upReq.Context()
+	key := embedCacheKey(upReq, endpoint, body)
+	if v, ok, err := h.shared.Get(ctx, key); err == nil && ok {
+		var e embedEntry
+		if json.Unmarshal(v, &e) == nil {
+			h.metrics.CacheRequests.WithLabelValues(endpoint, cacheHit).Inc()
+			cr := &cachedResponse{status: http.StatusOK, header: e.Header, body: e.Body}
+			return cr.toHTTP(upReq), cacheHit, nil
+		}
+	}
+	h.metrics.CacheRequests.WithLabelValues(endpoint, cacheMiss).Inc()
+	resp, err := h.clientFor(endpoint).Do(upReq)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, cacheMiss, err
+	}
+	head, err := io.ReadAll(io.LimitReader(resp.Body, embedCacheMaxBytes+1))
+	if err != nil || len(head) > embedCacheMaxBytes {
+		// Relay what arrived and the rest, or the read error, as usual.
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+		return resp, cacheMiss, nil
+	}
+	_ = resp.Body.Close()
+	header := resp.Header.Clone()
+	header.Del("Content-Length")
+	header.Del("Date")
+	if v, err := json.Marshal(embedEntry{Header: header, Body: head}); err == nil {
+		_ = h.shared.Set(ctx, key, v, h.cfg.EmbedCacheTTL)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(head))
+	resp.ContentLength = int64(len(head))
+	return resp, cacheMiss, nil
+}
+
+// readReplayable returns upReq's body without consuming it, through
+// GetBody; ok is false when it cannot be read again.
+func readReplayable(upReq *http.Request) (body []byte, ok bool) {
+	if upReq.GetBody == nil {
+		return nil, false
+	}
+	rc, err := upReq.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	defer rc.Close()
+	body, err = io.ReadAll(rc)
+	return body, err == nil
+}
+
+// readCloser reads from its Reader and closes c.
+type readCloser struct {
+	io.Reader
+	c io.Closer
+}
+
+func (rc readCloser) Close() error { return rc.c.Close() }
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/kv"
+	"github.com/nexusriot/ollama-proxy-metrics/ollamatest"
+)
+
+func postEmbed(h *Handler, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(body)))
+	return rr
+}
+
+func embedRequests(fake *ollamatest.Server) int {
+	n := 0
+	for _, r := range fake.Requests() {
+		if r.Path == "/api/embed" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestEmbedCache_ServesRepeatedInput(t *testing.T) {
+	fake := ollamatest.NewServer(ollamatest.Options{})
+	defer fake.Close()
+	h := newTestHandlerWithConfig(t, fake.URL, Config{EmbedCacheTTL: time.Minute})
+
+	first := postEmbed(h, `{"model":"llama3:8b","input":"hi"}`)
+	second := postEmbed(h, `{ "model": "llama3:8b", "input": "hi" }`)
+	postEmbed(h, `{"model":"llama3:8b","input":"bye"}`)
+
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("expected 200s, got %d and %d", first.Code, second.Code)
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Errorf("cached body differs:\n%s\n%s", second.Body.String(), first.Body.String())
+	}
+	if n := embedRequests(fake); n != 2 {
+		t.Errorf("expected 2 upstream embed requests, got %d", n)
+	}
+	if hits := testutil.ToFloat64(h.metrics.CacheRequests.WithLabelValues("/api/embed", cacheHit)); hits != 1 {
+		t.Errorf("expected 1 cache hit, got %v", hits)
+	}
+}
+
+func TestEmbedCache_SharedBetweenReplicas(t *testing.T) {
+	fake := ollamatest.NewServer(ollamatest.Options{})
+	defer fake.Close()
+	shared := kv.NewMemory()
+	defer shared.Close()
+	cfg := Config{SharedStore: shared, EmbedCacheTTL: time.Minute}
+	a := newTestHandlerWithConfig(t, fake.URL, cfg)
+	b := newTestHandlerWithConfig(t, fake.URL, cfg)
+
+	postEmbed(a, `{"model":"llama3:8b","input":"hi"}`)
+	if rr := postEmbed(b, `{"model":"llama3:8b","input":"hi"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if n := embedRequests(fake); n != 1 {
+		t.Errorf("expected the second replica to answer from the store, got %d upstream requests", n)
+	}
+}
+
+func TestEmbedCache_ErrorsNotCached(t *testing.T) {
+	fake := ollamatest.NewServer(ollamatest.Options{})
+	defer fake.Close()
+	fake.Fail("/api/embed", ollamatest.Fault{Status: http.StatusInternalServerError, Times: 1})
+	h := newTestHandlerWithConfig(t, fake.URL, Config{EmbedCacheTTL: time.Minute})
+
+	if rr := postEmbed(h, `{"model":"llama3:8b","input":"hi"}`); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rr.Code)
+	}
+	if rr := postEmbed(h, `{"model":"llama3:8b","input":"hi"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected the error not to be cached, got %d", rr.Code)
+	}
+	if n := embedRequests(fake); n != 2 {
+		t.Errorf("expected 2 upstream embed requests, got %d", n)
+	}
+}
+
+func TestEmbedCache_DisabledByDefault(t *testing.T) {
+	fake := ollamatest.NewServer(ollamatest.Options{})
+	defer fake.Close()
+	h := newTestHandlerWithConfig(t, fake.URL, Config{})
+
+	postEmbed(h, `{"model":"llama3:8b","input":"hi"}`)
+	postEmbed(h, `{"model":"llama3:8b","input":"hi"}`)
+
+	if n := embedRequests(fake); n != 2 {
+		t.Errorf("expected every request upstream, got %d", n)
+	}
+}
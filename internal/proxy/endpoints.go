@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// endpointOther is the endpoint label of every path that matches no route.
+const endpointOther = "other"
+
+// ollamaRoutes are the paths of Ollama's API, and the endpoint label each
+// gets. A {name} segment matches any one path segment and a final
+// {name...} the rest of the path, as in http.ServeMux patterns, so that
+// parameterized paths share one label. Add new Ollama endpoints here.
+var ollamaRoutes = []string{
+	"/api/generate",
+	"/api/chat",
+	"/api/embed",
+	"/api/embeddings",
+	"/api/tags",
+	"/api/ps",
+	"/api/show",
+	"/api/pull",
+	"/api/push",
+	"/api/create",
+	"/api/copy",
+	"/api/delete",
+	"/api/version",
+	"/api/blobs/{digest}",
+	"/v1/chat/completions",
+	"/v1/completions",
+	"/v1/embeddings",
+	"/v1/models",
+	"/v1/models/{model...}",
+}
+
+// endpointRoutes matches request paths against route templates.
+type endpointRoutes struct {
+	routes [][]string // templates split into segments
+}
+
+// newEndpointRoutes returns the routes of Ollama's API and extra, which
+// takes the same templates; an extra route is matched first.
+func newEndpointRoutes(extra []string) *endpointRoutes {
+	er := &endpointRoutes{}
+	for _, route := range append(append([]string(nil), extra...), ollamaRoutes...) {
+		er.routes = append(er.routes, strings.Split(route, "/"))
+	}
+	return er
+}
+
+// normalize returns the endpoint label of path: the template of the route
+// it matches, or "other". path is the URL's path, without its query.
+func (er *endpointRoutes) normalize(path string) string {
+	segs := strings.Split(path, "/")
+	for _, route := range er.routes {
+		if matchRoute(route, segs) {
+			return strings.Join(route, "/")
+		}
+	}
+	return endpointOther
+}
+
+// matchRoute reports whether the path segments segs match route's.
+func matchRoute(route, segs []string) bool {
+	for i, r := range route {
+		wildcard := strings.HasPrefix(r, "{") && strings.HasSuffix(r, "}")
+		if wildcard && strings.HasSuffix(r, "...}") {
+			return i < len(segs) && strings.Join(segs[i:], "") != ""
+		}
+		switch {
+		case i >= len(segs):
+			return false
+		case wildcard:
+			if segs[i] == "" {
+				return false
+			}
+		case r != segs[i]:
+			return false
+		}
+	}
+	return len(route) == len(segs)
+}
+
+// ParseEndpoints parses a comma-separated list of extra routes for
+// ExtraEndpoints, such as /api/experimental/{id}. Each must be an absolute
+// path without query; a {name...} wildcard may only end it.
+func ParseEndpoints(s string) ([]string, error) {
+	var out []string
+	for _, route := range strings.Split(s, ",") {
+		if route = strings.TrimSpace(route); route == "" {
+			continue
+		}
+		if !strings.HasPrefix(route, "/") || strings.ContainsAny(route, "?#") {
+			return nil, fmt.Errorf("route %q must be an absolute path without query", route)
+		}
+		segs := strings.Split(route, "/")
+		for i, seg := range segs {
+			opens, closes := strings.Contains(seg, "{"), strings.Contains(seg, "}")
+			switch {
+			case opens != closes || opens && (!strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") || len(seg) < 3):
+				return nil, fmt.Errorf("route %q: a wildcard takes a whole segment, as in {name}", route)
+			case strings.HasSuffix(seg, "...}") && i != len(segs)-1:
+				return nil, fmt.Errorf("route %q: only the last segment may be a {name...} wildcard", route)
+			}
+		}
+		out = append(out, route)
+	}
+	return out, nil
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEndpointRoutes_Normalize(t *testing.T) {
+	er := newEndpointRoutes([]string{"/api/experimental/{id}", "/api/chat/{variant}"})
+	for _, tc := range []struct{ path, want string }{
+		{"/api/chat", "/api/chat"},
+		{"/api/generate", "/api/generate"},
+		{"/v1/chat/completions", "/v1/chat/completions"},
+		{"/api/blobs/sha256:29fdb92e57cf0827ded04ae6461b5931d01fa595843f55d36f5b275a52087dd2", "/api/blobs/{digest}"},
+		{"/api/blobs/", endpointOther},
+		{"/api/blobs/sha256:aa/extra", endpointOther},
+		{"/v1/models", "/v1/models"},
+		{"/v1/models/llama3:8b", "/v1/models/{model...}"},
+		{"/v1/models/hf.co/bartowski/Llama-GGUF:Q4_K_M", "/v1/models/{model...}"},
+		{"/v1/models/", endpointOther},
+		{"/api/chat/fast", "/api/chat/{variant}"}, // an extra route, not Ollama's
+		{"/api/chat/", endpointOther},
+		{"/api/experimental/42", "/api/experimental/{id}"},
+		{"/api/experimental", endpointOther},
+		{"/api/tags/", endpointOther},
+		{"//api/tags", endpointOther},
+		{"/api/" + strings.Repeat("x", 500), endpointOther},
+		{"/", endpointOther},
+		{"", endpointOther},
+	} {
+		if got := er.normalize(tc.path); got != tc.want {
+			t.Errorf("%q: expected %q, got %q", tc.path, tc.want, got)
+		}
+	}
+}
+
+func TestParseEndpoints(t *testing.T) {
+	got, err := ParseEndpoints(" /api/experimental/{id}, ,/api/files/{path...}")
+	if err != nil || len(got) != 2 || got[1] != "/api/files/{path...}" {
+		t.Fatalf("expected two routes, got %q (%v)", got, err)
+	}
+	for _, bad := range []string{"api/x", "/api/x?y=1", "/api/{id", "/api/x{id}", "/api/{}", "/api/{rest...}/x"} {
+		if _, err := ParseEndpoints(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestServeHTTP_EndpointLabelNormalized(t *testing.T) {
+	var forwarded []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.URL.RequestURI())
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+	h := newTestHandler(t, upstream.URL)
+	lines := logRequests(h)
+
+	const digest = "sha256:29fdb92e57cf0827ded04ae6461b5931d01fa595843f55d36f5b275a52087dd2"
+	for i, target := range []string{"/api/blobs/" + digest, "/api/blobs/sha256:other", "/api/nonexistent?token=x", "/api/tags?verbose=true"} {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader("blob"))
+		req.Header.Set("X-Forwarded-For", "10.0.1."+string(rune('1'+i)))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(forwarded) != 4 || forwarded[0] != "/api/blobs/"+digest || forwarded[2] != "/api/nonexistent?token=x" {
+		t.Errorf("expected the raw paths and queries forwarded, got %q", forwarded)
+	}
+	label := upstreamLabel(h.currentUpstream())
+	for _, tc := range []struct {
+		endpoint, model, stream string
+		want                    float64
+	}{
+		{"/api/blobs/{digest}", modelUnknown, "false", 2},
+		{endpointOther, modelUnknown, "true", 1},
+		{"/api/tags", modelNone, "false", 1},
+	} {
+		if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues(tc.endpoint, tc.model, "201", tc.stream, originUpstream, label, "")); got != tc.want {
+			t.Errorf("%s: expected %v requests, got %v", tc.endpoint, tc.want, got)
+		}
+	}
+	if line := lines.byClient(t, "10.0.1.1"); line["endpoint"] != "/api/blobs/{digest}" || line["raw_endpoint"] != "/api/blobs/"+digest {
+		t.Errorf("expected the template and the raw path logged, got %q and %q", line["endpoint"], line["raw_endpoint"])
+	}
+	if line := lines.byClient(t, "10.0.1.3"); line["endpoint"] != endpointOther || strings.Contains(line["raw_endpoint"].(string), "token") {
+		t.Errorf("expected other, and no query in the raw path, got %q and %q", line["endpoint"], line["raw_endpoint"])
+	}
+	if line := lines.byClient(t, "10.0.1.4"); line["endpoint"] != "/api/tags" || line["raw_endpoint"] != nil {
+		t.Errorf("expected a known path logged as is, got %v", line)
+	}
+}
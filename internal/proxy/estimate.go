@@ -0,0 +1,51 @@
+package proxy
+
+// Estimators, the estimator label of token_estimate_ratio: which feature's
+// chars-per-token estimate of the prompt is being compared.
+const (
+	estimatorTPM     = "tpm"     // TPMLimit's admission reservation
+	estimatorContext = "context" // ContextCheck's prompt size
+)
+
+// estimateOutlierFactor is how far off, either way, an estimate has to be
+// to be logged.
+const estimateOutlierFactor = 2
+
+// charsPerToken is the divisor every prompt-token estimate uses.
+func (h *Handler) charsPerToken() float64 {
+	if h.cfg.CharsPerToken > 0 {
+		return h.cfg.CharsPerToken
+	}
+	return 4
+}
+
+// promptEstimates are the prompt-token estimates made for a request, 0 for
+// those that were not.
+type promptEstimates struct {
+	tpm     int64
+	context int64
+}
+
+// observeEstimate compares the request's prompt-token estimates with the
+// prompt_eval_count Ollama reported, as actual/estimated. Responses without
+// a prompt count are not recorded.
+func (h *Handler) observeEstimate(ri *reqInfo, stats *ChunkStats) {
+	if !stats.SawPrompt || stats.PromptTokens <= 0 {
+		return
+	}
+	for _, e := range []struct {
+		estimator string
+		tokens    int64
+	}{{estimatorTPM, ri.estimates.tpm}, {estimatorContext, ri.estimates.context}} {
+		if e.tokens <= 0 {
+			continue
+		}
+		ratio := float64(stats.PromptTokens) / float64(e.tokens)
+		h.metrics.TokenEstimateRatio.WithLabelValues(ri.modelLabel, e.estimator).Observe(ratio)
+		if ratio > estimateOutlierFactor || ratio < 1.0/estimateOutlierFactor {
+			h.logger.Debug("prompt token estimate off", "request_id", ri.id, "model", ri.model,
+				"estimator", e.estimator, "estimated_tokens", e.tokens, "prompt_tokens", stats.PromptTokens,
+				"ratio", ratio, "chars_per_token", h.charsPerToken())
+		}
+	}
+}
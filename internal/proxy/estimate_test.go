@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// generatePrompt sends a non-streaming generate request for model m with
+// prompt and num_ctx set.
+func generatePrompt(h *Handler, prompt string) int {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate",
+		strings.NewReader(`{"model":"m","stream":false,"prompt":"`+prompt+`","options":{"num_ctx":4096}}`)))
+	return rr.Code
+}
+
+func TestEstimate_RatioPerEstimator(t *testing.T) {
+	// tokenUpstream reports 40 prompt tokens; 80 characters at 4 per token
+	// are estimated as 20.
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{TPMLimit: 1000, ContextCheck: ContextCheckWarn})
+	if code := generatePrompt(h, strings.Repeat("a", 80)); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	for _, e := range []string{estimatorTPM, estimatorContext} {
+		o := h.metrics.TokenEstimateRatio.WithLabelValues("m", e)
+		if n, sum := histogramCount(t, o), histogramSum(t, o); n != 1 || sum != 2 {
+			t.Errorf("%s: expected one ratio of 2, got %d summing to %v", e, n, sum)
+		}
+	}
+}
+
+func TestEstimate_NotRecordedWithoutEstimate(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	generatePrompt(h, "hello")
+	for _, e := range []string{estimatorTPM, estimatorContext} {
+		if n := histogramCount(t, h.metrics.TokenEstimateRatio.WithLabelValues("m", e)); n != 0 {
+			t.Errorf("%s: expected nothing recorded without an estimate, got %d", e, n)
+		}
+	}
+}
+
+func TestEstimate_OutliersLoggedAtDebug(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{TPMLimit: 1000, CharsPerToken: 2})
+	var buf bytes.Buffer
+	h.logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	generatePrompt(h, strings.Repeat("a", 80)) // estimated 40: exact
+	if strings.Contains(buf.String(), "prompt token estimate off") {
+		t.Fatalf("expected an exact estimate not logged, got %q", buf.String())
+	}
+	generatePrompt(h, strings.Repeat("a", 400)) // estimated 200: 0.2
+	if !strings.Contains(buf.String(), `"msg":"prompt token estimate off"`) || !strings.Contains(buf.String(), `"estimated_tokens":200`) {
+		t.Errorf("expected the outlier logged, got %q", buf.String())
+	}
+}
+
+func TestEstimate_CharsPerTokenGauge(t *testing.T) {
+	if got := testutil.ToFloat64(newTestHandler(t, tokenUpstream(t).URL).metrics.CharsPerToken); got != 4 {
+		t.Errorf("expected the default of 4, got %v", got)
+	}
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{CharsPerToken: 3.5})
+	if got := testutil.ToFloat64(h.metrics.CharsPerToken); got != 3.5 {
+		t.Errorf("expected 3.5, got %v", got)
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wrappedWriter hides the server's Flusher behind Unwrap, as logging and
+// metrics middleware commonly do.
+type wrappedWriter struct{ http.ResponseWriter }
+
+func (w wrappedWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func TestStream_ChunksReachClientBeforeUpstreamFinishes(t *testing.T) {
+	first := `{"response":"a","done":false}`
+	second := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A length that the proxy's added final newline would break, and a
+		// buffering hint that must not reach the client.
+		body := first + "\n" + `{"response":"","done":true}`
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("X-Accel-Buffering", "yes")
+		_, _ = fmt.Fprintln(w, first)
+		w.(http.Flusher).Flush()
+		<-second
+		_, _ = io.WriteString(w, `{"response":"","done":true}`)
+	}))
+	defer upstream.Close()
+	defer close(second)
+	h := newTestHandler(t, upstream.URL)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(wrappedWriter{w}, r)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/generate", "application/json", strings.NewReader(`{"model":"m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("X-Accel-Buffering") != "no" || resp.ContentLength != -1 {
+		t.Errorf("expected a chunked response with X-Accel-Buffering: no, got length %d and %q",
+			resp.ContentLength, resp.Header.Get("X-Accel-Buffering"))
+	}
+
+	got := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		got <- line
+	}()
+	select {
+	case line := <-got:
+		if strings.TrimSpace(line) != first {
+			t.Errorf("expected the first chunk, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("first chunk not delivered while the upstream held back the second")
+	}
+}
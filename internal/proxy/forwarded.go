@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// setForwarded tells the upstream about the client of r the way
+// net/http/httputil.ReverseProxy does: the client's address is appended to
+// any X-Forwarded-For chain it sent, and X-Forwarded-Host and
+// X-Forwarded-Proto are the Host and scheme it asked for. upReq keeps the
+// upstream's host as its Host, for name-based virtual hosting, unless
+// PreserveHost is set. The request ID goes along for the upstream's logs.
+func (h *Handler) setForwarded(upReq, r *http.Request) {
+	upReq.Header.Set(h.requestIDHeader(), RequestID(r.Context()))
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		upReq.Header.Set("X-Forwarded-For", ip)
+	}
+	upReq.Header.Set("X-Forwarded-Host", r.Host)
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	upReq.Header.Set("X-Forwarded-Proto", proto)
+	if h.cfg.PreserveHost {
+		upReq.Host = r.Host
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// seenByUpstream is an upstream that keeps the last request's headers and
+// Host in got.
+func seenByUpstream(t *testing.T, got *http.Request) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*got = http.Request{Header: r.Header.Clone(), Host: r.Host}
+		_, _ = w.Write([]byte(`{"response":"ok","done":true}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	var got http.Request
+	upstream := seenByUpstream(t, &got)
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	for _, tc := range []struct {
+		name       string
+		cfg        Config
+		path       string
+		prior      []string
+		tls        bool
+		xff, proto string
+		host       string
+	}{
+		{"direct", Config{}, "/api/generate", nil, false, "192.0.2.7", "http", upstreamHost},
+		{"chain kept", Config{}, "/api/generate", []string{"198.51.100.1, 10.0.0.1", "10.0.0.2"}, false,
+			"198.51.100.1, 10.0.0.1, 10.0.0.2, 192.0.2.7", "http", upstreamHost},
+		{"tls", Config{}, "/api/generate", nil, true, "192.0.2.7", "https", upstreamHost},
+		{"preserve host", Config{PreserveHost: true}, "/api/generate", nil, false, "192.0.2.7", "http", "ollama.example.com"},
+		{"uninspected", Config{NoInspectEndpoints: []string{"/api/embed"}}, "/api/embed", []string{"10.0.0.1"}, false,
+			"10.0.0.1, 192.0.2.7", "http", upstreamHost},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHandlerWithConfig(t, upstream.URL, tc.cfg)
+			req := httptest.NewRequest(http.MethodPost, "http://ollama.example.com"+tc.path, strings.NewReader(`{"model":"m","stream":false}`))
+			req.RemoteAddr = "192.0.2.7:51234"
+			for _, v := range tc.prior {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tc.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if xff := got.Header.Values("X-Forwarded-For"); len(xff) != 1 || xff[0] != tc.xff {
+				t.Errorf("expected X-Forwarded-For %q, got %q", tc.xff, xff)
+			}
+			if p := got.Header.Get("X-Forwarded-Proto"); p != tc.proto {
+				t.Errorf("expected X-Forwarded-Proto %q, got %q", tc.proto, p)
+			}
+			if fh := got.Header.Get("X-Forwarded-Host"); fh != "ollama.example.com" {
+				t.Errorf("expected X-Forwarded-Host ollama.example.com, got %q", fh)
+			}
+			if got.Host != tc.host {
+				t.Errorf("expected Host %q, got %q", tc.host, got.Host)
+			}
+		})
+	}
+}
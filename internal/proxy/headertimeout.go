@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// errorTypeHeaderTimeout is the error_type logged for requests the upstream
+// accepted but never answered within ResponseHeaderTimeout.
+const errorTypeHeaderTimeout = "header_timeout"
+
+// newUpstreamClients returns one client per endpoint class whose transport
+// gives up when no response headers arrive within that class's timeout.
+// The timeout stops at the headers, so streaming bodies are never cut off.
+// Classes with no timeout are absent and use the default client. All share
+// the transport settings of cfg.
+func newUpstreamClients(cfg Config) map[string]*http.Client {
+	clients := map[string]*http.Client{}
+	byTimeout := map[time.Duration]*http.Client{} // classes with equal timeouts share a pool
+	for _, class := range []string{"generate", "chat", "embed", "other"} {
+		d := cfg.ResponseHeaderTimeout
+		if t, ok := cfg.ResponseHeaderTimeouts[class]; ok {
+			d = t
+		}
+		if d <= 0 {
+			continue
+		}
+		if byTimeout[d] == nil {
+			tr := newTransport(cfg)
+			tr.ResponseHeaderTimeout = d
+			byTimeout[d] = &http.Client{Transport: tr}
+		}
+		clients[class] = byTimeout[d]
+	}
+	return clients
+}
+
+// clientFor returns the upstream client for endpoint.
+func (h *Handler) clientFor(endpoint string) *http.Client {
+	if c, ok := h.upstreamClients[endpointClass(endpoint)]; ok {
+		return c
+	}
+	return h.httpClient
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHeaderTimeout_504WhenHeadersNeverArrive(t *testing.T) {
+	hang := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer upstream.Close()
+	defer close(hang)
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ResponseHeaderTimeout: 20 * time.Millisecond})
+	rr := generate(h, "10.0.0.1")
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rr.Code)
+	}
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "504", "false", originProxy, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the 504 counted, got %v", got)
+	}
+}
+
+func TestHeaderTimeout_NeverCutsStreamingBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		w.(http.Flusher).Flush()
+		time.Sleep(80 * time.Millisecond) // well past the header timeout
+		_, _ = fmt.Fprintln(w, `{"response":"b","done":true,"eval_count":2}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ResponseHeaderTimeout: 20 * time.Millisecond})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	sc := bufio.NewScanner(rr.Body)
+	lines := 0
+	for sc.Scan() {
+		lines++
+	}
+	if rr.Code != http.StatusOK || lines != 2 {
+		t.Errorf("expected the full stream, got %d with %d lines", rr.Code, lines)
+	}
+}
+
+func TestHeaderTimeout_PerClassOverride(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = fmt.Fprint(w, `{"models":[]}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{
+		ResponseHeaderTimeout:  time.Minute,
+		ResponseHeaderTimeouts: map[string]time.Duration{"other": 10 * time.Millisecond},
+	})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected the other-class override to apply, got %d", rr.Code)
+	}
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Errorf("expected generate to use the default timeout, got %d", rr.Code)
+	}
+}
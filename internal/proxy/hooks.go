@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+)
+
+// ParsedRequest is a proxied request as seen by a RequestInspector once its
+// payload has been parsed. Inspectors may replace Body and Upstream and add
+// to Header and ResponseHeader; the other fields are informational.
+type ParsedRequest struct {
+	ID        string
+	SessionID string
+	ClientIP  string
+	Method    string
+	Endpoint  string
+	Model     string // "unknown", or "-" for endpoints that take none, when the request names no model
+	Stream    bool
+
+	Header         http.Header // the client's headers, forwarded upstream
+	Body           []byte      // the body forwarded upstream
+	Truncated      bool        // Body is only the start of a body over RequestSniffBytes; the rest follows it unread
+	Upstream       *url.URL    // the upstream the request is forwarded to
+	ResponseHeader http.Header // headers of the response to the client
+
+	ri      *reqInfo
+	payload requestPayload // as parsed; not updated when Body is replaced
+}
+
+// RequestStats describes a finished request for a ResponseObserver: token
+// counts are those Ollama reported, zero when it reported none.
+type RequestStats struct {
+	ID               string
+	SessionID        string
+	ClientIP         string
+	Method           string
+	Endpoint         string
+	Model            string
+	Stream           bool
+	StatusCode       int
+	Duration         time.Duration
+	RequestBytes     int64
+	ResponseBytes    int64
+	PromptTokens     int64
+	CompletionTokens int64
+	Error            string // why the request failed, if it did
+}
+
+// RequestInspector runs after a request's payload is parsed, before it is
+// admitted. A non-nil error rejects the request: a *Rejection says how,
+// any other error is a 403 with reason hook_rejected.
+type RequestInspector interface {
+	InspectRequest(ctx context.Context, req *ParsedRequest) error
+}
+
+// ForwardInspector runs once a request has been admitted and queued, just
+// before upReq is sent upstream. Errors reject the request like those of a
+// RequestInspector. Header changes carry over to the retries of
+// Config.UpstreamRetries, which replay the original body.
+type ForwardInspector interface {
+	InspectForward(ctx context.Context, req *ParsedRequest, upReq *http.Request) error
+}
+
+// ResponseObserver runs once for every request the proxy handled, after it
+// was answered and recorded, whether it succeeded or not. ctx carries the
+// client request's values but is never cancelled.
+type ResponseObserver interface {
+	ObserveResponse(ctx context.Context, stats *RequestStats)
+}
+
+// RequestInspectorFunc adapts a function to RequestInspector.
+type RequestInspectorFunc func(ctx context.Context, req *ParsedRequest) error
+
+// InspectRequest implements RequestInspector.
+func (f RequestInspectorFunc) InspectRequest(ctx context.Context, req *ParsedRequest) error {
+	return f(ctx, req)
+}
+
+// ForwardInspectorFunc adapts a function to ForwardInspector.
+type ForwardInspectorFunc func(ctx context.Context, req *ParsedRequest, upReq *http.Request) error
+
+// InspectForward implements ForwardInspector.
+func (f ForwardInspectorFunc) InspectForward(ctx context.Context, req *ParsedRequest, upReq *http.Request) error {
+	return f(ctx, req, upReq)
+}
+
+// ResponseObserverFunc adapts a function to ResponseObserver.
+type ResponseObserverFunc func(ctx context.Context, stats *RequestStats)
+
+// ObserveResponse implements ResponseObserver.
+func (f ResponseObserverFunc) ObserveResponse(ctx context.Context, stats *RequestStats) {
+	f(ctx, stats)
+}
+
+// Hooks is a set of hooks for Handler.Use. Every hook is called from many
+// requests at once and must be safe for concurrent use.
+type Hooks struct {
+	Inspectors []RequestInspector
+	Forwarders []ForwardInspector
+	Observers  []ResponseObserver
+}
+
+func (hk Hooks) with(more Hooks) *Hooks {
+	return &Hooks{
+		Inspectors: append(slices.Clip(hk.Inspectors), more.Inspectors...),
+		Forwarders: append(slices.Clip(hk.Forwarders), more.Forwarders...),
+		Observers:  append(slices.Clip(hk.Observers), more.Observers...),
+	}
+}
+
+// Use registers hooks, which run before the built-in ones (limits, policy
+// overrides, context and maintenance checks, metrics) in the order they
+// were registered. It is safe to call while requests are served.
+func (h *Handler) Use(hooks Hooks) {
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.hooks.Store(h.hooks.Load().with(hooks))
+}
+
+// builtinHooks are the proxy's own features that fit the hook interfaces,
+// in the order they run.
+func (h *Handler) builtinHooks() Hooks {
+	hk := Hooks{
+		Inspectors: []RequestInspector{
+			RequestInspectorFunc(h.inspectPayload),
+			RequestInspectorFunc(h.inspectReadOnly),
+			RequestInspectorFunc(h.inspectPinned),
+			RequestInspectorFunc(h.inspectMaintenance),
+			RequestInspectorFunc(h.inspectOOMCooldown),
+			RequestInspectorFunc(h.inspectMissingModel),
+			RequestInspectorFunc(h.inspectContextWindow),
+			RequestInspectorFunc(h.inspectLimits), // no later check may reject after tokens were reserved
+			RequestInspectorFunc(h.inspectBackend),
+		},
+		Forwarders: []ForwardInspector{ForwardInspectorFunc(h.forwardUpstreamAuth)},
+	}
+	if h.summary != nil {
+		hk.Observers = append(hk.Observers, h.summary)
+	}
+	return hk
+}
+
+// inspectPayload feeds the payload metrics and applies the keep_alive
+// override.
+func (h *Handler) inspectPayload(_ context.Context, req *ParsedRequest) error {
+	h.observeDuplicate(req.ri, req.payload)
+	h.observeEmbedBatch(req.ri, req.payload)
+	req.Body = h.checkUnload(req.ri, req.payload, req.Body)
+	return nil
+}
+
+// forwardUpstreamAuth sets the upstream's bearer token, if it has one.
+func (h *Handler) forwardUpstreamAuth(_ context.Context, req *ParsedRequest, upReq *http.Request) error {
+	h.setUpstreamAuth(upReq.Header, req.Upstream)
+	return nil
+}
+
+// inspect runs the registered, then the built-in request inspectors. It
+// returns false after answering a rejection.
+func (h *Handler) inspect(w http.ResponseWriter, req *ParsedRequest) bool {
+	ctx := req.ri.r.Context()
+	for _, hooks := range []*Hooks{h.hooks.Load(), &h.builtin} {
+		for _, in := range hooks.Inspectors {
+			if err := in.InspectRequest(ctx, req); err != nil {
+				h.rejectHook(w, req.ri, err)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// inspectForward runs the registered, then the built-in forward inspectors.
+// It returns false after answering a rejection.
+func (h *Handler) inspectForward(w http.ResponseWriter, req *ParsedRequest, upReq *http.Request) bool {
+	ctx := req.ri.r.Context()
+	for _, hooks := range []*Hooks{h.hooks.Load(), &h.builtin} {
+		for _, f := range hooks.Forwarders {
+			if err := f.InspectForward(ctx, req, upReq); err != nil {
+				h.rejectHook(w, req.ri, err)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// observe hands a recorded request to every response observer.
+func (h *Handler) observe(ctx context.Context, rec db.RequestRecord) {
+	stats := &RequestStats{
+		ID:               rec.RequestID,
+		SessionID:        rec.SessionID,
+		ClientIP:         rec.ClientIP,
+		Method:           rec.Method,
+		Endpoint:         rec.Endpoint,
+		Model:            rec.Model,
+		Stream:           rec.Stream,
+		StatusCode:       rec.StatusCode,
+		Duration:         time.Duration(rec.DurationMS) * time.Millisecond,
+		RequestBytes:     rec.RequestBytes,
+		ResponseBytes:    rec.ResponseBytes,
+		PromptTokens:     rec.PromptTokens,
+		CompletionTokens: rec.CompletionTokens,
+		Error:            rec.ErrorMessage,
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, hooks := range []*Hooks{h.hooks.Load(), &h.builtin} {
+		for _, o := range hooks.Observers {
+			o.ObserveResponse(ctx, stats)
+		}
+	}
+}
+
+// Rejection is an error with which an inspector refuses a request. The
+// client gets Status with a JSON body of Message and Fields; it is counted
+// in ollama_proxy_policy_rejections_total under Reason.
+type Rejection struct {
+	Reason  string         // policy rejection reason; hook_rejected when empty
+	Status  int            // HTTP status; 403 when 0
+	Message string         // the JSON "error"
+	RetryAt time.Time      // sets Retry-After when not zero
+	Fields  map[string]any // more JSON fields
+}
+
+func (r *Rejection) Error() string {
+	return r.Message
+}
+
+// rejectHook answers an inspector's error.
+func (h *Handler) rejectHook(w http.ResponseWriter, ri *reqInfo, err error) {
+	rej := &Rejection{Message: err.Error()}
+	errors.As(err, &rej)
+	reason, status := rej.Reason, rej.Status
+	if reason == "" {
+		reason = reasonHookRejected
+	}
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	body := make(map[string]any, len(rej.Fields)+1)
+	for k, v := range rej.Fields {
+		body[k] = v
+	}
+	body["error"] = rej.Message
+	h.reject(w, ri, reason, status, rej.RetryAt, body)
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHooks_RejectionFromInspector(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	h.Use(Hooks{Inspectors: []RequestInspector{RequestInspectorFunc(func(_ context.Context, req *ParsedRequest) error {
+		if req.ClientIP == "10.0.0.2" {
+			return &Rejection{Reason: reasonModelDenied, Status: http.StatusUnavailableForLegalReasons, Message: "not here"}
+		}
+		return nil
+	})}})
+
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for an accepted request, got %d", rr.Code)
+	}
+	rr := generate(h, "10.0.0.2")
+	if rr.Code != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("expected the rejection's status, got %d", rr.Code)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonModelDenied)); got != 1 {
+		t.Errorf("expected the rejection counted under its reason, got %v", got)
+	}
+}
+
+func TestHooks_PlainErrorIsForbidden(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	h.Use(Hooks{Inspectors: []RequestInspector{RequestInspectorFunc(func(context.Context, *ParsedRequest) error {
+		return errors.New("no")
+	})}})
+
+	rr := generate(h, "10.0.0.1")
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rr.Code)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonHookRejected)); got != 1 {
+		t.Errorf("expected a hook_rejected rejection, got %v", got)
+	}
+}
+
+func TestHooks_InspectorReroutesUpstream(t *testing.T) {
+	var hits atomic.Int32
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	defer other.Close()
+	otherURL, _ := url.Parse(other.URL)
+
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	h.Use(Hooks{Inspectors: []RequestInspector{RequestInspectorFunc(func(_ context.Context, req *ParsedRequest) error {
+		req.Upstream = otherURL
+		return nil
+	})}})
+
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK || hits.Load() != 1 {
+		t.Fatalf("expected the request served by the other upstream, got %d with %d hits", rr.Code, hits.Load())
+	}
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "200", "false", originUpstream, upstreamLabel(otherURL), "")); got != 1 {
+		t.Errorf("expected the request labelled with the chosen upstream, got %v", got)
+	}
+}
+
+func TestHooks_ForwardInspectorSetsHeader(t *testing.T) {
+	var got atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Get("X-Tenant"))
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	h.Use(Hooks{Forwarders: []ForwardInspector{ForwardInspectorFunc(func(_ context.Context, req *ParsedRequest, upReq *http.Request) error {
+		upReq.Header.Set("X-Tenant", req.ClientIP)
+		return nil
+	})}})
+
+	generate(h, "10.0.0.7")
+	if got.Load() != "10.0.0.7" {
+		t.Errorf("expected the forwarded header, got %v", got.Load())
+	}
+}
+
+func TestHooks_ObserverSeesTokens(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	stats := make(chan *RequestStats, 1)
+	h.Use(Hooks{Observers: []ResponseObserver{ResponseObserverFunc(func(_ context.Context, s *RequestStats) {
+		stats <- s
+	})}})
+
+	generate(h, "10.0.0.1")
+	s := <-stats
+	if s.Model != "m" || s.StatusCode != http.StatusOK || s.PromptTokens != 40 || s.CompletionTokens != 60 || s.ID == "" {
+		t.Errorf("unexpected stats %+v", *s)
+	}
+}
+
+func TestHooks_UseWhileServing(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	var seen atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			generate(h, "10.0.0.1")
+		}()
+		go func() {
+			defer wg.Done()
+			h.Use(Hooks{Observers: []ResponseObserver{ResponseObserverFunc(func(context.Context, *RequestStats) {
+				seen.Add(1)
+			})}})
+		}()
+	}
+	wg.Wait()
+	if n := len(h.hooks.Load().Observers); n != 8 {
+		t.Errorf("expected 8 registered observers, got %d", n)
+	}
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// hopHeaders are the hop-by-hop headers of RFC 9110 section 7.6.1, plus the
+// obsolete ones net/http/httputil.ReverseProxy also drops. They describe one
+// connection, client to proxy or proxy to upstream, and are never forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Content-Length frames a message like Transfer-Encoding, and a copied one
+// may contradict the body actually sent: the client's, or the upstream's
+// once the proxy has re-encoded or annotated the response. It is never
+// copied; the transport sets it from an upstream request's body, and the
+// proxy sets it on a response with responseLength where it knows the length
+// it writes, leaving net/http to chunk the rest.
+const headerContentLength = "Content-Length"
+
+// copyEndToEnd adds src's headers to dst, leaving out the hop-by-hop ones,
+// those in hopHeaders and any that src's Connection header names, and
+// Content-Length.
+func copyEndToEnd(dst, src http.Header) {
+	skip := map[string]bool{}
+	for _, v := range src.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				skip[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		skip[name] = true
+	}
+	skip[headerContentLength] = true
+	for k, vals := range src {
+		if skip[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		for _, v := range vals {
+			dst.Add(k, v)
+		}
+	}
+}
+
+// responseLength is the Content-Length of a response to method with status
+// whose body is n bytes, or -1 for none. HEAD and 304 responses have no body
+// but declare the length the upstream gave, and 1xx and 204 responses none.
+func responseLength(method string, status int, upstream, n int64) int64 {
+	switch {
+	case status < 200 || status == http.StatusNoContent:
+		return -1
+	case method == http.MethodHead || status == http.StatusNotModified:
+		return upstream
+	}
+	return n
+}
+
+// setContentLength declares a response's length, when it has one.
+func setContentLength(hdr http.Header, n int64) {
+	if n >= 0 {
+		hdr.Set(headerContentLength, strconv.FormatInt(n, 10))
+	}
+}
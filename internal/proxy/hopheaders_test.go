@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCopyEndToEnd(t *testing.T) {
+	src := http.Header{}
+	src.Set("Connection", "close, x-hop")
+	src.Set("Keep-Alive", "timeout=5")
+	src.Set("Transfer-Encoding", "chunked")
+	src.Set("Te", "trailers")
+	src.Set("Upgrade", "websocket")
+	src.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	src.Set("X-Hop", "1")
+	src.Set("Content-Type", "application/json")
+	src.Set("Content-Length", "42")
+	src.Add("X-Custom", "a")
+	src.Add("X-Custom", "b")
+
+	dst := http.Header{}
+	copyEndToEnd(dst, src)
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Te", "Upgrade", "Proxy-Authorization", "X-Hop", "Content-Length"} {
+		if v, ok := dst[name]; ok {
+			t.Errorf("expected %s dropped, got %q", name, v)
+		}
+	}
+	if dst.Get("Content-Type") != "application/json" || strings.Join(dst.Values("X-Custom"), ",") != "a,b" {
+		t.Errorf("expected end-to-end headers kept, got %v", dst)
+	}
+}
+
+func TestHopHeaders_StrippedBothWays(t *testing.T) {
+	var seen http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Request-Trace", "abc")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	defer upstream.Close()
+	h := newTestHandler(t, upstream.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	req.Header.Set("Connection", "close, X-Client-Hop")
+	req.Header.Set("X-Client-Hop", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("Transfer-Encoding", "chunked")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Custom", "kept")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "X-Client-Hop"} {
+		if v := seen.Get(name); v != "" {
+			t.Errorf("expected %s not forwarded upstream, got %q", name, v)
+		}
+	}
+	if seen.Get("X-Custom") != "kept" || seen.Get("Content-Type") != "application/json" {
+		t.Errorf("expected end-to-end request headers forwarded, got %v", seen)
+	}
+	for _, name := range []string{"Connection", "Keep-Alive", "X-Upstream-Hop"} {
+		if v := rr.Header().Get(name); v != "" {
+			t.Errorf("expected %s not relayed to the client, got %q", name, v)
+		}
+	}
+	if rr.Header().Get("X-Request-Trace") != "abc" || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected end-to-end response headers relayed, got %v", rr.Header())
+	}
+}
+
+func TestResponseLength(t *testing.T) {
+	for _, tc := range []struct {
+		method            string
+		status            int
+		upstream, n, want int64
+	}{
+		{http.MethodPost, http.StatusOK, 999, 12, 12},
+		{http.MethodPost, http.StatusOK, -1, 12, 12},
+		{http.MethodHead, http.StatusOK, 42, 0, 42},
+		{http.MethodGet, http.StatusNotModified, 42, 0, 42},
+		{http.MethodPost, http.StatusNoContent, 42, 0, -1},
+		{http.MethodPost, http.StatusContinue, 42, 0, -1},
+	} {
+		if got := responseLength(tc.method, tc.status, tc.upstream, tc.n); got != tc.want {
+			t.Errorf("%s %d (upstream %d, %d written): expected %d, got %d", tc.method, tc.status, tc.upstream, tc.n, tc.want, got)
+		}
+	}
+}
+
+func TestFraming_ClientHeadersNotForwarded(t *testing.T) {
+	type seen struct {
+		length  int64
+		values  []string
+		te      []string
+		bodyLen int
+	}
+	got := make(chan seen, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- seen{r.ContentLength, r.Header.Values("Content-Length"), r.TransferEncoding, len(b)}
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	defer upstream.Close()
+
+	body := `{"model":"m","stream":false}`
+	for _, path := range []string{"/api/generate", "/api/embed"} {
+		h := newTestHandlerWithConfig(t, upstream.URL, Config{NoInspectEndpoints: []string{"/api/embed"}})
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Add("Content-Length", "999")
+		req.Header.Add("Content-Length", "7")
+		req.Header.Set("Transfer-Encoding", "chunked")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		s := <-got
+		if s.length != int64(len(body)) || s.bodyLen != len(body) || len(s.values) > 1 || len(s.te) != 0 {
+			t.Errorf("%s: expected the upstream to see one length of %d and no transfer coding, got %+v", path, len(body), s)
+		}
+	}
+}
+
+// rawUpstream answers every request with the bytes of raw, as is, and closes
+// the connection.
+func rawUpstream(t *testing.T, raw string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				_, _ = io.WriteString(conn, raw)
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func TestFraming_UpstreamAnomalies(t *testing.T) {
+	const done = `{"response":"ok","done":true}`
+	for _, tc := range []struct {
+		name, path, raw string
+		status          int
+	}{
+		{"chunked with a length", "/api/generate",
+			"HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(done), done), http.StatusOK},
+		{"conflicting lengths", "/api/generate",
+			"HTTP/1.1 200 OK\r\nContent-Length: 29\r\nContent-Length: 40\r\n\r\n" + done, http.StatusBadGateway},
+		{"body short of its length", "/api/generate",
+			"HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n" + done, http.StatusOK},
+		{"uninspected, chunked with a length", "/api/embed",
+			"HTTP/1.1 200 OK\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", len(done), done), http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHandlerWithConfig(t, rawUpstream(t, tc.raw), Config{NoInspectEndpoints: []string{"/api/embed"}})
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			resp, err := http.Post(srv.URL+tc.path, "application/json", strings.NewReader(`{"model":"m","stream":false}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("expected a well-framed response, got %v after %q", err, body)
+			}
+			if resp.StatusCode != tc.status {
+				t.Errorf("expected %d, got %d: %s", tc.status, resp.StatusCode, body)
+			}
+			if resp.ContentLength != -1 && resp.ContentLength != int64(len(body)) {
+				t.Errorf("expected the length to match the %d bytes sent, got %d", len(body), resp.ContentLength)
+			}
+			if n := len(resp.Header.Values("Content-Length")); n > 1 {
+				t.Errorf("expected at most one Content-Length, got %d", n)
+			}
+		})
+	}
+}
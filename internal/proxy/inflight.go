@@ -0,0 +1,16 @@
+package proxy
+
+// trackInFlight counts a request in inflight_requests until the returned
+// function is called. ServeHTTP defers that call, so a request leaves the
+// gauges however it ends: rejected, failed upstream, or after the last
+// chunk of a stream that ran for minutes.
+func (h *Handler) trackInFlight(endpoint, model, streamLabel string) func() {
+	g := h.metrics.InFlight.WithLabelValues(endpoint, model)
+	s := h.metrics.InFlightByStream.WithLabelValues(streamLabel)
+	g.Inc()
+	s.Inc()
+	return func() {
+		g.Dec()
+		s.Dec()
+	}
+}
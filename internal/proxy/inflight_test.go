@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInFlight_HeldForWholeStream(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		w.(http.Flusher).Flush()
+		close(started)
+		<-finish
+		_, _ = fmt.Fprintln(w, `{"response":"","done":true}`)
+	}))
+	defer upstream.Close()
+	h := newTestHandler(t, upstream.URL)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"m"}`)))
+	}()
+	<-started
+	g := h.metrics.InFlight.WithLabelValues("/api/chat", "m")
+	if got := testutil.ToFloat64(g); got != 1 {
+		t.Errorf("expected one request in flight mid-stream, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.InFlightByStream.WithLabelValues("true")); got != 1 {
+		t.Errorf("expected one streamed request in flight, got %v", got)
+	}
+	close(finish)
+	<-done
+	if got := testutil.ToFloat64(g); got != 0 {
+		t.Errorf("expected none in flight after the stream, got %v", got)
+	}
+}
+
+func TestInFlight_ReleasedOnErrorPaths(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	h := newTestHandlerWithConfig(t, dead.URL, Config{ReadOnly: true})
+
+	for _, tc := range []struct{ path, body string }{
+		{"/api/pull", `{"model":"m"}`},                    // rejected
+		{"/api/generate", `{"model":"m","stream":false}`}, // upstream unreachable
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body)))
+		if got := testutil.ToFloat64(h.metrics.InFlight.WithLabelValues(tc.path, "m")); got != 0 {
+			t.Errorf("%s answered %d: expected none in flight, got %v", tc.path, rr.Code, got)
+		}
+	}
+	for _, stream := range []string{"true", "false"} {
+		if got := testutil.ToFloat64(h.metrics.InFlightByStream.WithLabelValues(stream)); got != 0 {
+			t.Errorf("stream=%s: expected none in flight, got %v", stream, got)
+		}
+	}
+}
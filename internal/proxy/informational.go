@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+)
+
+// traceInformational returns ctx with a client trace that relays the
+// upstream's 1xx informational responses, such as 103 Early Hints, to the
+// client and counts them. 100 Continue answers the proxy's own request and
+// is not relayed; neither is anything to HTTP/1.0 clients, which do not
+// expect 1xx responses.
+func (h *Handler) traceInformational(ctx context.Context, w http.ResponseWriter, ri *reqInfo) context.Context {
+	relay := ri.r.ProtoAtLeast(1, 1)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusContinue {
+				return nil
+			}
+			h.metrics.InformationalResponses.WithLabelValues(ri.endpoint, strconv.Itoa(code)).Inc()
+			if relay {
+				writeInformational(w, code, http.Header(header))
+			}
+			return nil
+		},
+	})
+}
+
+// writeInformational sends a 1xx response with exactly header. WriteHeader
+// sends whatever w's header map holds, so the headers already set for the
+// final response are put aside meanwhile.
+func writeInformational(w http.ResponseWriter, code int, header http.Header) {
+	hdr := w.Header()
+	final := hdr.Clone()
+	clear(hdr)
+	for k, v := range header {
+		hdr[k] = v
+	}
+	w.WriteHeader(code)
+	clear(hdr)
+	for k, v := range final {
+		hdr[k] = v
+	}
+}
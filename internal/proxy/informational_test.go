@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInformational_EarlyHintsRelayed(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</v1/hint>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	defer upstream.Close()
+	h := newTestHandler(t, upstream.URL)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	var hints []string
+	trace := &httptrace.ClientTrace{Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+		hints = append(hints, fmt.Sprintf("%d %s", code, header.Get("Link")))
+		if header.Get("X-Ollama-Queue-Wait-Ms") != "" {
+			t.Errorf("expected only the upstream's headers on the 103, got %v", header)
+		}
+		return nil
+	}}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if len(hints) != 1 || hints[0] != "103 </v1/hint>; rel=preload" {
+		t.Errorf("expected the early hint relayed, got %v", hints)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"ok"`) {
+		t.Errorf("expected the final 200 intact, got %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Link") != "" || resp.Header.Get("X-Ollama-Queue-Wait-Ms") == "" {
+		t.Errorf("expected the final response's own headers, got %v", resp.Header)
+	}
+	if got := testutil.ToFloat64(h.metrics.InformationalResponses.WithLabelValues("/api/generate", "103")); got != 1 {
+		t.Errorf("expected one 103 counted, got %v", got)
+	}
+}
+
+func TestInformational_NoneByDefault(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if n := testutil.CollectAndCount(h.metrics.InformationalResponses); n != 0 {
+		t.Errorf("expected no informational responses counted, got %d series", n)
+	}
+}
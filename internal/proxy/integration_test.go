@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/ollamatest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeOllama starts the proxy in front of a fake Ollama server with opts and
+// returns both; requests go through the proxy's URL.
+func fakeOllama(t *testing.T, opts ollamatest.Options) (*Handler, *httptest.Server, *ollamatest.Server) {
+	t.Helper()
+	fake := ollamatest.NewServer(opts)
+	t.Cleanup(fake.Close)
+	h := newTestHandler(t, fake.URL)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, srv, fake
+}
+
+func postProxy(t *testing.T, srv *httptest.Server, path, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+const fakeModel = "llama3:8b" // ollamatest.DefaultModel
+
+func TestIntegration_Stream(t *testing.T) {
+	h, srv, _ := fakeOllama(t, ollamatest.Options{Tokens: []string{"one", " two", " three"}, PromptTokens: 12})
+
+	resp := postProxy(t, srv, "/api/generate", `{"model":"llama3:8b","prompt":"count"}`)
+	var text strings.Builder
+	lines := 0
+	for sc := bufio.NewScanner(resp.Body); sc.Scan(); lines++ {
+		var chunk struct {
+			Response string `json:"response"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		text.WriteString(chunk.Response)
+	}
+	if resp.StatusCode != http.StatusOK || lines != 4 || text.String() != "one two three" {
+		t.Fatalf("expected the stream relayed, got %d with %d lines: %q", resp.StatusCode, lines, text.String())
+	}
+	waitFor(t, "the request to be recorded", func() bool {
+		return testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", fakeModel, "200", "true", originUpstream, upstreamLabel(h.currentUpstream()), "")) == 1
+	})
+	label := upstreamLabel(h.currentUpstream())
+	if in, out := testutil.ToFloat64(h.metrics.TokensIn.WithLabelValues("/api/generate", fakeModel, label, "")),
+		testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues("/api/generate", fakeModel, label, "")); in != 12 || out != 3 {
+		t.Errorf("expected 12 prompt and 3 completion tokens, got %v and %v", in, out)
+	}
+}
+
+func TestIntegration_NonStream(t *testing.T) {
+	h, srv, fake := fakeOllama(t, ollamatest.Options{PromptTokens: 5})
+
+	resp := postProxy(t, srv, "/api/chat", `{"model":"llama3:8b","stream":false,"messages":[{"role":"user","content":"hi"}]}`)
+	var body struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Done bool `json:"done"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !body.Done || body.Message.Content != "Hello world!" {
+		t.Fatalf("expected the chat response relayed, got %d %+v", resp.StatusCode, body)
+	}
+	waitFor(t, "the request to be recorded", func() bool {
+		return testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/chat", fakeModel, "200", "false", originUpstream, upstreamLabel(h.currentUpstream()), "")) == 1
+	})
+	if got := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues("/api/chat", fakeModel, upstreamLabel(h.currentUpstream()), "")); got != 3 {
+		t.Errorf("expected 3 completion tokens, got %v", got)
+	}
+	if reqs := fake.Requests(); len(reqs) != 1 || !strings.Contains(string(reqs[0].Body), `"stream":false`) {
+		t.Errorf("expected the request forwarded as sent, got %+v", reqs)
+	}
+}
+
+func TestIntegration_Errors(t *testing.T) {
+	h, srv, fake := fakeOllama(t, ollamatest.Options{})
+	label := upstreamLabel(h.currentUpstream())
+
+	fake.Fail("/api/generate", ollamatest.Fault{Status: http.StatusInternalServerError, Error: "llama runner process has terminated", Times: 1})
+	resp := postProxy(t, srv, "/api/generate", `{"model":"llama3:8b","stream":false}`)
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(string(body), "llama runner") {
+		t.Errorf("expected Ollama's 500 relayed, got %d %s", resp.StatusCode, body)
+	}
+	waitFor(t, "the 500 to be recorded", func() bool {
+		return testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", fakeModel, "500", "false", originUpstream, label, "")) == 1
+	})
+
+	resp = postProxy(t, srv, "/api/chat", `{"model":"mistral","stream":false}`)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the unknown model's 404 relayed, got %d", resp.StatusCode)
+	}
+	waitFor(t, "the missing model to be counted", func() bool {
+		return testutil.ToFloat64(h.metrics.ModelNotFound.WithLabelValues(originUpstream)) == 1
+	})
+
+	// A stream Ollama cuts off ends where it was for the client too.
+	fake.Fail("/api/chat", ollamatest.Fault{AfterChunks: 2, Times: 1})
+	resp = postProxy(t, srv, "/api/chat", `{"model":"llama3:8b"}`)
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || strings.Count(string(body), "\n") != 2 || strings.Contains(string(body), `"done":true`) {
+		t.Errorf("expected the 2 chunks sent before the cut and no final object, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestIntegration_SlowChunks(t *testing.T) {
+	const first, every = 150 * time.Millisecond, 100 * time.Millisecond
+	h, srv, _ := fakeOllama(t, ollamatest.Options{FirstChunkDelay: first, ChunkDelay: every})
+
+	start := time.Now()
+	resp := postProxy(t, srv, "/api/generate", `{"model":"llama3:8b"}`)
+	r := bufio.NewReader(resp.Body)
+	var arrivals []time.Duration
+	for {
+		if _, err := r.ReadBytes('\n'); err != nil {
+			break
+		}
+		arrivals = append(arrivals, time.Since(start))
+	}
+	if len(arrivals) != 4 {
+		t.Fatalf("expected 3 chunks and the final object, got %d lines", len(arrivals))
+	}
+	// Each chunk is flushed as it comes, not held until the stream ends.
+	if arrivals[0] < first || arrivals[0] > arrivals[2]-every {
+		t.Errorf("expected the first chunk after %s and well before the last, got them at %v", first, arrivals)
+	}
+
+	waitFor(t, "the first token time to be observed", func() bool {
+		return histogramCount(t, h.metrics.TTFT.WithLabelValues("/api/generate", fakeModel)) == 1
+	})
+	if ttft := histogramSum(t, h.metrics.TTFT.WithLabelValues("/api/generate", fakeModel)); ttft < first.Seconds() || ttft >= (first+2*every).Seconds() {
+		t.Errorf("expected a time to first token of about %s, got %.3fs", first, ttft)
+	}
+}
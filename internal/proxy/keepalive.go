@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// isUnload reports whether a request's keep_alive asks Ollama to unload the
+// model right away: 0, "0" or a zero duration such as "0s" or "0m".
+func isUnload(keepAlive json.RawMessage) bool {
+	keepAlive = bytes.TrimSpace(keepAlive)
+	if len(keepAlive) == 0 || string(keepAlive) == "null" {
+		return false
+	}
+	var n float64
+	if json.Unmarshal(keepAlive, &n) == nil {
+		return n == 0
+	}
+	var s string
+	if json.Unmarshal(keepAlive, &s) != nil {
+		return false
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n == 0
+	}
+	d, err := time.ParseDuration(s)
+	return err == nil && d == 0
+}
+
+// checkUnload counts requests that unload their model and, with
+// UnloadKeepAliveOverride set, returns body with keep_alive replaced so the
+// model stays loaded. Otherwise body is returned unchanged.
+func (h *Handler) checkUnload(ri *reqInfo, p requestPayload, body []byte) []byte {
+	if !isUnload(p.KeepAlive) {
+		return body
+	}
+	h.metrics.UnloadRequests.WithLabelValues(ri.modelLabel).Inc()
+	h.logger.Debug("model unload requested",
+		"request_id", ri.id,
+		"session_id", ri.sessionID,
+		"client_ip", ri.clientIP,
+		"user_agent", ri.r.UserAgent(),
+		"endpoint", ri.endpoint,
+		"model", ri.model,
+		"overridden", h.cfg.UnloadKeepAliveOverride != "")
+	if h.cfg.UnloadKeepAliveOverride == "" {
+		return body
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return body
+	}
+	fields["keep_alive"], _ = json.Marshal(h.cfg.UnloadKeepAliveOverride)
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	h.metrics.PolicyModifications.WithLabelValues(reasonKeepAliveOverride).Inc()
+	return out
+}
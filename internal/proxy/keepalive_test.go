@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIsUnload(t *testing.T) {
+	for raw, want := range map[string]bool{
+		`0`: true, `0.0`: true, `"0"`: true, `"0s"`: true, `"0m"`: true,
+		`300`: false, `"5m"`: false, `-1`: false, `"-1m"`: false, `""`: false, `null`: false, `true`: false,
+	} {
+		if got := isUnload(json.RawMessage(raw)); got != want {
+			t.Errorf("isUnload(%s) = %v, want %v", raw, got, want)
+		}
+	}
+	if isUnload(nil) {
+		t.Error("a missing keep_alive is not an unload")
+	}
+}
+
+// keepAliveUpstream records the keep_alive each request carried.
+func keepAliveUpstream(t *testing.T, seen *[]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var p struct {
+			KeepAlive json.RawMessage `json:"keep_alive"`
+		}
+		_ = json.Unmarshal(b, &p)
+		*seen = append(*seen, string(p.KeepAlive))
+		_, _ = io.WriteString(w, `{"done":true}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUnload_CountedWithoutOverride(t *testing.T) {
+	var seen []string
+	h := newTestHandler(t, keepAliveUpstream(t, &seen).URL)
+	postJSON(h, "/api/generate", `{"model":"m","keep_alive":0}`)
+	postJSON(h, "/api/generate", `{"model":"m","keep_alive":"10m","stream":false}`)
+
+	if got := testutil.ToFloat64(h.metrics.UnloadRequests.WithLabelValues("m")); got != 1 {
+		t.Errorf("expected 1 unload, got %v", got)
+	}
+	if seen[0] != "0" {
+		t.Errorf("expected keep_alive forwarded unchanged, got %s", seen[0])
+	}
+}
+
+func TestUnload_Override(t *testing.T) {
+	var seen []string
+	h := newTestHandlerWithConfig(t, keepAliveUpstream(t, &seen).URL, Config{UnloadKeepAliveOverride: "5m"})
+	postJSON(h, "/api/chat", `{"model":"m","keep_alive":"0s","messages":[]}`)
+
+	if seen[0] != `"5m"` {
+		t.Errorf("expected keep_alive replaced, got %s", seen[0])
+	}
+	if got := testutil.ToFloat64(h.metrics.UnloadRequests.WithLabelValues("m")); got != 1 {
+		t.Errorf("expected the unload still counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyModifications.WithLabelValues(reasonKeepAliveOverride)); got != 1 {
+		t.Errorf("expected a keep_alive_override modification, got %v", got)
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Labels whose values come from request content, and so are sanitized.
+// The endpoint label is a route template instead; see endpointRoutes.
+const (
+	labelModel  = "model"
+	labelTenant = "tenant"
+)
+
+// Reasons a label value was sanitized for.
+const (
+	sanitizedInvalid = "invalid" // control characters or invalid UTF-8
+	sanitizedLength  = "length"  // longer than MaxLabelLength
+)
+
+var (
+	sanitizedLabels  = []string{labelModel, labelTenant}
+	sanitizedReasons = []string{sanitizedInvalid, sanitizedLength}
+)
+
+// labelHashLen is the length of the hash that ends a shortened label value,
+// so that long values sharing a prefix stay apart.
+const labelHashLen = 8
+
+// MinLabelLength is the smallest MaxLabelLength: a shortened value keeps at
+// least a few characters besides its hash.
+const MinLabelLength = 16
+
+// sanitizeLabel makes v safe as a label value: control characters and
+// invalid UTF-8 are dropped, and with maxLen > 0 a value longer than maxLen
+// bytes is cut, on a character boundary, to end in "~" and a hash of v. It
+// reports which of the two it did.
+func sanitizeLabel(v string, maxLen int) (out string, invalid, long bool) {
+	out = strings.Map(func(r rune) rune {
+		// Invalid bytes come as RuneError; a literal U+FFFD goes with them.
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			invalid = true
+			return -1
+		}
+		return r
+	}, v)
+	if maxLen > 0 && len(out) > maxLen {
+		long = true
+		sum := sha256.Sum256([]byte(v))
+		cut := maxLen - 1 - labelHashLen
+		for cut > 0 && !utf8.RuneStart(out[cut]) {
+			cut--
+		}
+		out = out[:cut] + "~" + hex.EncodeToString(sum[:])[:labelHashLen]
+	}
+	return out, invalid, long
+}
+
+// labelValue returns v, a value of the request-derived label, sanitized for
+// metrics and everything else that groups requests by it, counting each
+// reason it was changed for in label_values_sanitized_total.
+func (h *Handler) labelValue(label, v string) string {
+	out, invalid, long := sanitizeLabel(v, h.cfg.MaxLabelLength)
+	if invalid {
+		h.metrics.LabelsSanitized.WithLabelValues(label, sanitizedInvalid).Inc()
+	}
+	if long {
+		h.metrics.LabelsSanitized.WithLabelValues(label, sanitizedLength).Inc()
+	}
+	return out
+}
+
+// Model label modes, for ModelLabelMode.
+const (
+	ModelLabelRaw         = "raw"          // the model as the client named it
+	ModelLabelStripLatest = "strip-latest" // without a :latest tag
+	ModelLabelBase        = "base"         // without any tag or digest
+)
+
+// ModelLabelModes are the valid values of ModelLabelMode.
+var ModelLabelModes = []string{ModelLabelRaw, ModelLabelStripLatest, ModelLabelBase}
+
+// modelOther is the model label of models outside ModelLabelAllowlist.
+const modelOther = "other"
+
+// normalizeModel returns the model label of the model reference ref under
+// mode. A tag is only looked for in the last path element, so that a
+// registry port stays: base maps registry:5000/team/llama3:8b to
+// registry:5000/team/llama3. A reference that would be left empty is kept.
+func normalizeModel(mode, ref string) string {
+	slash := strings.LastIndexByte(ref, '/')
+	switch mode {
+	case ModelLabelStripLatest:
+		if colon := strings.LastIndexByte(ref, ':'); colon > slash && colon > 0 && strings.EqualFold(ref[colon+1:], "latest") {
+			return ref[:colon]
+		}
+	case ModelLabelBase:
+		if cut := strings.IndexAny(ref[slash+1:], ":@"); cut > 0 || cut == 0 && slash >= 0 {
+			return ref[:slash+1+cut]
+		}
+	}
+	return ref
+}
+
+// modelLabel returns the metrics label of model, already sanitized:
+// normalized per ModelLabelMode and, with ModelLabelAllowlist, "other"
+// unless allowed. The placeholders for requests without a model are kept.
+func (h *Handler) modelLabel(model string) string {
+	switch model {
+	case "", modelUnknown, modelNone, modelUninspected:
+		return model
+	}
+	label := normalizeModel(h.cfg.ModelLabelMode, model)
+	if h.modelAllowlist == nil {
+		return label
+	}
+	if _, ok := h.modelAllowlist[label]; !ok {
+		return modelOther
+	}
+	return label
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSanitizeLabel(t *testing.T) {
+	for _, tc := range []struct {
+		in, want      string
+		invalid, long bool
+	}{
+		{"llama3:8b", "llama3:8b", false, false},
+		{"llama3\n:8b\x00", "llama3:8b", true, false},
+		{"a\xffb\x1b[31m", "ab[31m", true, false},
+		{"qwen2.5-coder:32b-instruct", "qwen2.5-c~", false, true},
+	} {
+		got, invalid, long := sanitizeLabel(tc.in, 18)
+		if !strings.HasPrefix(got, tc.want) || invalid != tc.invalid || long != tc.long {
+			t.Errorf("%q: expected %q (invalid %t, long %t), got %q (%t, %t)", tc.in, tc.want, tc.invalid, tc.long, got, invalid, long)
+		}
+		if len(got) > 18 {
+			t.Errorf("%q: expected at most 18 bytes, got %d", tc.in, len(got))
+		}
+	}
+
+	a, _, _ := sanitizeLabel(strings.Repeat("x", 500)+"a", 64)
+	b, _, _ := sanitizeLabel(strings.Repeat("x", 500)+"b", 64)
+	if len(a) != 64 || a == b {
+		t.Errorf("expected long values cut to 64 bytes and kept apart by their hash, got %q and %q", a, b)
+	}
+	if again, _, _ := sanitizeLabel(strings.Repeat("x", 500)+"a", 64); again != a {
+		t.Errorf("expected the same value shortened the same way, got %q and %q", a, again)
+	}
+	if got, _, _ := sanitizeLabel(strings.Repeat("é", 40), 20); !utf8.ValidString(got) {
+		t.Errorf("expected a cut on a character boundary, got %q", got)
+	}
+	if got, _, long := sanitizeLabel(strings.Repeat("x", 500), 0); long || len(got) != 500 {
+		t.Errorf("expected no length limit with 0, got %d bytes", len(got))
+	}
+}
+
+func TestLabels_SanitizedConsistently(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{MaxLabelLength: 32, LatencyWindow: 5 * time.Minute})
+	lines := logRequests(h)
+	raw := "evil\nmodel-" + strings.Repeat("z", 100)
+	body, _ := json.Marshal(map[string]any{"model": raw, "stream": false})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(string(body)))
+	req.Header.Set("X-Forwarded-For", "10.0.0.3")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	label, _, _ := sanitizeLabel(raw, 32)
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", label, "200", "false", originUpstream, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the request counted under %q, got %v", label, got)
+	}
+	for _, reason := range sanitizedReasons {
+		if got := testutil.ToFloat64(h.metrics.LabelsSanitized.WithLabelValues(labelModel, reason)); got != 1 {
+			t.Errorf("expected one %s sanitization of the model, got %v", reason, got)
+		}
+	}
+
+	line := lines.byClient(t, "10.0.0.3")
+	if line["model"] != label || line["raw_model"] != raw {
+		t.Errorf("expected the label in model and the raw value in raw_model, got %q and %q", line["model"], line["raw_model"])
+	}
+	rows, _, err := h.store.ListRequests(1, 0, "", "")
+	if err != nil || len(rows) != 1 || rows[0].Model != label {
+		t.Errorf("expected the record under the label, got %+v (%v)", rows, err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeStats(rr, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if !strings.Contains(rr.Body.String(), `"`+label+`"`) || strings.Contains(rr.Body.String(), `\n`) {
+		t.Errorf("expected /stats to use the label, got %s", rr.Body.String())
+	}
+}
+
+func TestLabels_Tenant(t *testing.T) {
+	h := newTestHandlerWithConfig(t, "http://127.0.0.1:1", Config{TenantHeader: "X-Tenant", MaxLabelLength: 32})
+	r := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+	r.Header.Set("X-Tenant", "team\ta")
+	if got := h.tenantOf(r); got != "teama" {
+		t.Errorf("expected the tenant sanitized, got %q", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.LabelsSanitized.WithLabelValues(labelTenant, sanitizedInvalid)); got != 1 {
+		t.Errorf("expected the tenant's sanitization counted, got %v", got)
+	}
+}
+
+func TestNormalizeModel(t *testing.T) {
+	for _, tc := range []struct {
+		in                string
+		stripLatest, base string
+	}{
+		{"", "", ""},
+		{"llama3.1", "llama3.1", "llama3.1"},
+		{"llama3.1:latest", "llama3.1", "llama3.1"},
+		{"llama3.1:LATEST", "llama3.1", "llama3.1"},
+		{"llama3.1:8b-instruct-q4_K_M", "llama3.1:8b-instruct-q4_K_M", "llama3.1"},
+		{"llama3.1:", "llama3.1:", "llama3.1"},
+		{":latest", ":latest", ":latest"},
+		{"@sha256:1a2b", "@sha256:1a2b", "@sha256:1a2b"},
+		{"library/llama3", "library/llama3", "library/llama3"},
+		{"library/llama3:latest", "library/llama3", "library/llama3"},
+		{"registry.example.com:5000/team/llama3", "registry.example.com:5000/team/llama3", "registry.example.com:5000/team/llama3"},
+		{"registry.example.com:5000/team/llama3:latest", "registry.example.com:5000/team/llama3", "registry.example.com:5000/team/llama3"},
+		{"hf.co/bartowski/Llama-3.2-1B-Instruct-GGUF:Q4_K_M", "hf.co/bartowski/Llama-3.2-1B-Instruct-GGUF:Q4_K_M", "hf.co/bartowski/Llama-3.2-1B-Instruct-GGUF"},
+		{"llama3@sha256:1a2b", "llama3@sha256:1a2b", "llama3"},
+		{"llama3:latest@sha256:1a2b", "llama3:latest@sha256:1a2b", "llama3"},
+		{"models/", "models/", "models/"},
+	} {
+		if got := normalizeModel(ModelLabelRaw, tc.in); got != tc.in {
+			t.Errorf("raw %q: expected it unchanged, got %q", tc.in, got)
+		}
+		if got := normalizeModel("", tc.in); got != tc.in {
+			t.Errorf("empty mode %q: expected it unchanged, got %q", tc.in, got)
+		}
+		if got := normalizeModel(ModelLabelStripLatest, tc.in); got != tc.stripLatest {
+			t.Errorf("strip-latest %q: expected %q, got %q", tc.in, tc.stripLatest, got)
+		}
+		if got := normalizeModel(ModelLabelBase, tc.in); got != tc.base {
+			t.Errorf("base %q: expected %q, got %q", tc.in, tc.base, got)
+		}
+	}
+}
+
+func TestModelLabel_Metrics(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{
+		ModelLabelMode:      ModelLabelBase,
+		ModelLabelAllowlist: []string{"llama3.1:8b", "qwen2"},
+	})
+	for _, model := range []string{"llama3.1", "llama3.1:latest", "llama3.1:8b-instruct-q4_K_M", "mystery-model:7b", "qwen2"} {
+		if code := generateModel(h, model); code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", model, code)
+		}
+	}
+	label := upstreamLabel(h.currentUpstream())
+	for _, tc := range []struct {
+		model    string
+		requests float64
+	}{
+		{"llama3.1", 3},
+		{"qwen2", 1},
+		{modelOther, 1},
+		{"llama3.1:latest", 0},
+		{"mystery-model", 0},
+	} {
+		if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", tc.model, "200", "false", originUpstream, label, "")); got != tc.requests {
+			t.Errorf("%s: expected %v requests, got %v", tc.model, tc.requests, got)
+		}
+		if got := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues("/api/generate", tc.model, label, "")); got != 60*tc.requests {
+			t.Errorf("%s: expected %v completion tokens, got %v", tc.model, 60*tc.requests, got)
+		}
+	}
+
+	// The records keep the model as the client named it.
+	rows, _, err := h.store.ListRequests(10, 0, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, row := range rows {
+		seen[row.Model] = true
+	}
+	if !seen["llama3.1:8b-instruct-q4_K_M"] || !seen["mystery-model:7b"] {
+		t.Errorf("expected the records to keep the names sent, got %v", seen)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/tags", modelNone, "200", "false", originUpstream, label, "")); got != 1 {
+		t.Errorf("expected endpoints without a model kept out of other, got %v", got)
+	}
+}
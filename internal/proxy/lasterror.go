@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// lastErrorBodyBytes caps the error body kept per model.
+const lastErrorBodyBytes = 4 << 10
+
+// secretPatterns mask credentials that upstream error bodies sometimes echo
+// back (bearer tokens, key=value or "key": "value" secrets).
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)("?(?:api[_-]?key|access[_-]?token|token|password|secret)"?\s*[:=]\s*"?)[^"\s,&}]+`),
+}
+
+// redactSecrets replaces credential values in s with [REDACTED].
+func redactSecrets(s string) string {
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "${1}[REDACTED]")
+	}
+	return s
+}
+
+// lastErrors keeps one proxyapi.LastError per model; memory is bounded by the
+// number of distinct models.
+type lastErrors struct {
+	mu     sync.Mutex
+	models map[string]proxyapi.LastError
+}
+
+func (l *lastErrors) record(e proxyapi.LastError) {
+	if len(e.Body) > lastErrorBodyBytes {
+		e.Body, e.Truncated = e.Body[:lastErrorBodyBytes], true
+	}
+	e.Body = redactSecrets(e.Body)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.models == nil {
+		l.models = map[string]proxyapi.LastError{}
+	}
+	l.models[e.Model] = e
+}
+
+// list returns the kept errors ordered by model, only model's when it is
+// not empty.
+func (l *lastErrors) list(model string) []proxyapi.LastError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := []proxyapi.LastError{}
+	for m, e := range l.models {
+		if model == "" || m == model {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Model < out[j].Model })
+	return out
+}
+
+// recordLastError keeps an error response for ri's model.
+func (h *Handler) recordLastError(ri *reqInfo, status int, body []byte, source string) {
+	h.lastErrors.record(proxyapi.LastError{
+		Model:     ri.model,
+		Endpoint:  ri.endpoint,
+		Status:    status,
+		Body:      string(body),
+		At:        time.Now().UTC(),
+		RequestID: ri.id,
+		Source:    source,
+	})
+}
+
+func (h *Handler) handleLastError(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, proxyapi.LastErrors{Errors: h.lastErrors.list(r.URL.Query().Get("model"))})
+}
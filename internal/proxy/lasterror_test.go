@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	cases := map[string]string{
+		`Authorization: Bearer abc.def-123`:   `Authorization: Bearer [REDACTED]`,
+		`{"api_key":"sk-123","x":1}`:          `{"api_key":"[REDACTED]","x":1}`,
+		`bad url ?token=s3cret&model=m`:       `bad url ?token=[REDACTED]&model=m`,
+		`model "m" not found, try pulling it`: `model "m" not found, try pulling it`,
+	}
+	for in, want := range cases {
+		if got := redactSecrets(in); got != want {
+			t.Errorf("redactSecrets(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func lastErrorsFor(t *testing.T, mux *http.ServeMux, query string) []proxyapi.LastError {
+	t.Helper()
+	rr := adminDo(t, mux, http.MethodGet, "/debug/last-error"+query, "secret", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var out struct{ Errors []proxyapi.LastError }
+	_ = json.NewDecoder(rr.Body).Decode(&out)
+	return out.Errors
+}
+
+func TestLastError_PerModel(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"model not found","password":"hunter2"}` + strings.Repeat("x", 2*lastErrorBodyBytes)))
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	mux := http.NewServeMux()
+	h.RegisterAdmin(mux, "secret")
+	for _, body := range []string{`{"model":"a","stream":false}`, `{"model":"b"}`} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)))
+	}
+
+	all := lastErrorsFor(t, mux, "")
+	if len(all) != 2 || all[0].Model != "a" || all[1].Model != "b" {
+		t.Fatalf("expected one entry each for a (non-stream) and b (stream), got %+v", all)
+	}
+	for _, e := range all {
+		if e.Status != http.StatusNotFound || e.Source != "upstream" || e.RequestID == "" || !e.Truncated {
+			t.Errorf("unexpected entry %+v", e)
+		}
+		if len(e.Body) > lastErrorBodyBytes+len("[REDACTED]") || strings.Contains(e.Body, "hunter2") {
+			t.Errorf("expected a capped, redacted body, got %d bytes", len(e.Body))
+		}
+	}
+	if only := lastErrorsFor(t, mux, "?model=b"); len(only) != 1 || only[0].Model != "b" {
+		t.Errorf("expected the model filter to apply, got %+v", only)
+	}
+	if rr := adminDo(t, mux, http.MethodGet, "/debug/last-error", "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the endpoint to require the admin token, got %d", rr.Code)
+	}
+}
+
+func TestLastError_ProxySide(t *testing.T) {
+	h := newTestHandler(t, "http://127.0.0.1:1")
+	mux := http.NewServeMux()
+	h.RegisterAdmin(mux, "secret")
+	generate(h, "10.0.0.1")
+	got := lastErrorsFor(t, mux, "?model=m")
+	if len(got) != 1 || got[0].Status != http.StatusBadGateway || got[0].Source != "proxy" {
+		t.Errorf("expected the 502 recorded as a proxy-side error, got %+v", got)
+	}
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+const (
+	// latencyAccuracy is the relative error of the quantiles in /stats: a
+	// reported p99 is within 1% of a duration the sketch was given.
+	latencyAccuracy = 0.01
+	// latencyMaxBins bounds a sketch's memory. At 1% accuracy it spans
+	// 100µs to over 20 hours; larger spans fold their lowest bins together,
+	// so only the fastest requests lose precision.
+	latencyMaxBins = 1024
+	// latencyMinSeconds is the smallest duration a sketch tells apart from 0.
+	latencyMinSeconds = 1e-4
+	// latencySlots is how many steps a window slides in: the quantiles cover
+	// the last window, give or take one slot.
+	latencySlots = 6
+	// latencyMaxModels bounds how many models are tracked at once; a model
+	// seen while as many have data in the window is left out of /stats.
+	latencyMaxModels = 256
+)
+
+// latencyGamma is the ratio between the bounds of adjacent bins.
+var latencyGamma = (1 + latencyAccuracy) / (1 - latencyAccuracy)
+
+// sketch is a DDSketch of durations in seconds: values are counted in bins
+// whose bounds grow geometrically by latencyGamma, so every bin's midpoint
+// is within latencyAccuracy of any value in it. Bins are kept dense from the
+// lowest key, at most latencyMaxBins of them.
+type sketch struct {
+	offset int      // key of bins[0]
+	bins   []uint64 // counts by key
+	zeros  uint64   // values below latencyMinSeconds
+	count  uint64
+}
+
+func sketchKey(seconds float64) int {
+	return int(math.Ceil(math.Log(seconds) / math.Log(latencyGamma)))
+}
+
+// sketchValue is the value a key stands for: the midpoint, relative to the
+// error, of its bin.
+func sketchValue(key int) float64 {
+	return 2 * math.Pow(latencyGamma, float64(key)) / (latencyGamma + 1)
+}
+
+func (s *sketch) add(seconds float64) {
+	if seconds < latencyMinSeconds {
+		s.zeros++
+		s.count++
+		return
+	}
+	s.addKey(sketchKey(seconds), 1)
+}
+
+func (s *sketch) addKey(k int, n uint64) {
+	s.count += n
+	if len(s.bins) == 0 {
+		s.offset, s.bins = k, []uint64{n}
+		return
+	}
+	switch hi := s.offset + len(s.bins) - 1; {
+	case k > hi:
+		s.bins = append(s.bins, make([]uint64, k-hi)...)
+	case k < s.offset:
+		k = max(k, hi-latencyMaxBins+1) // folded into the lowest bin kept
+		if k < s.offset {
+			s.bins = append(make([]uint64, s.offset-k, s.offset-k+len(s.bins)), s.bins...)
+			s.offset = k
+		}
+	}
+	s.bins[k-s.offset] += n
+	if extra := len(s.bins) - latencyMaxBins; extra > 0 {
+		for _, c := range s.bins[:extra] {
+			s.bins[extra] += c
+		}
+		s.bins = append([]uint64(nil), s.bins[extra:]...)
+		s.offset += extra
+	}
+}
+
+// merge adds o's counts to s.
+func (s *sketch) merge(o *sketch) {
+	s.zeros += o.zeros
+	s.count += o.zeros
+	for i, c := range o.bins {
+		if c > 0 {
+			s.addKey(o.offset+i, c)
+		}
+	}
+}
+
+func (s *sketch) reset() {
+	*s = sketch{}
+}
+
+// quantile returns the q-quantile (0 to 1) of the values added, 0 when
+// there are none.
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.count-1))
+	if rank < s.zeros {
+		return 0
+	}
+	seen := s.zeros
+	for i, c := range s.bins {
+		seen += c
+		if rank < seen {
+			return sketchValue(s.offset + i)
+		}
+	}
+	return sketchValue(s.offset + len(s.bins) - 1)
+}
+
+// modelLatency holds a model's sketches, one per slot of the window; slot i
+// has the observations of the slot numbered i modulo latencySlots.
+type modelLatency struct {
+	duration [latencySlots]sketch
+	ttft     [latencySlots]sketch
+	epoch    int64 // number of the newest slot
+	last     int64 // number of the slot of the latest observation
+}
+
+// advance clears the slots that fell out of the window by slot number now.
+func (m *modelLatency) advance(now int64) {
+	if now <= m.epoch {
+		return
+	}
+	for n := m.epoch + 1; n <= now && n <= m.epoch+latencySlots; n++ {
+		m.duration[n%latencySlots].reset()
+		m.ttft[n%latencySlots].reset()
+	}
+	m.epoch = now
+}
+
+// latencyTracker keeps the request duration and time to first token of
+// each model over a sliding window for /stats, apart from the Prometheus
+// histograms, whose buckets are too coarse for a p99. The zero value, with
+// no window, tracks nothing.
+type latencyTracker struct {
+	window time.Duration
+	mu     sync.Mutex
+	models map[string]*modelLatency
+}
+
+func newLatencyTracker(window time.Duration) *latencyTracker {
+	return &latencyTracker{window: window, models: map[string]*modelLatency{}}
+}
+
+// slotOf numbers the slot now falls in.
+func (t *latencyTracker) slotOf(now time.Time) int64 {
+	return now.UnixNano() / int64(max(t.window/latencySlots, 1))
+}
+
+// model returns model's sketches advanced to now, or nil when it is not
+// tracked and no more models may be.
+func (t *latencyTracker) model(model string, now time.Time) *modelLatency {
+	slot := t.slotOf(now)
+	m := t.models[model]
+	if m == nil {
+		if len(t.models) >= latencyMaxModels {
+			t.expire(slot)
+			if len(t.models) >= latencyMaxModels {
+				return nil
+			}
+		}
+		m = &modelLatency{epoch: slot}
+		t.models[model] = m
+	}
+	m.advance(slot)
+	m.last = slot
+	return m
+}
+
+// expire forgets the models without observations in the window.
+func (t *latencyTracker) expire(slot int64) {
+	for name, m := range t.models {
+		if slot-m.last >= latencySlots {
+			delete(t.models, name)
+		}
+	}
+}
+
+func (t *latencyTracker) observe(model string, now time.Time, d time.Duration, ttft bool) {
+	if t == nil || t.window <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.model(model, now)
+	if m == nil {
+		return
+	}
+	slot := m.epoch % latencySlots
+	if ttft {
+		m.ttft[slot].add(d.Seconds())
+	} else {
+		m.duration[slot].add(d.Seconds())
+	}
+}
+
+// quantilesOf is how /stats reports a sketch.
+func quantilesOf(slots *[latencySlots]sketch) *proxyapi.Quantiles {
+	var all sketch
+	for i := range slots {
+		all.merge(&slots[i])
+	}
+	if all.count == 0 {
+		return nil
+	}
+	return &proxyapi.Quantiles{Count: all.count, P50: all.quantile(0.5), P95: all.quantile(0.95), P99: all.quantile(0.99)}
+}
+
+// snapshot returns the quantiles, in seconds, of every model with
+// observations in the window.
+func (t *latencyTracker) snapshot(now time.Time) *proxyapi.Latency {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	slot := t.slotOf(now)
+	t.expire(slot)
+	models := make(map[string]proxyapi.ModelLatency, len(t.models))
+	for name, m := range t.models {
+		m.advance(slot)
+		entry := proxyapi.ModelLatency{DurationSeconds: quantilesOf(&m.duration), TTFTSeconds: quantilesOf(&m.ttft)}
+		if entry.DurationSeconds != nil || entry.TTFTSeconds != nil {
+			models[name] = entry
+		}
+	}
+	return &proxyapi.Latency{Window: t.window.String(), RelativeError: latencyAccuracy, Models: models}
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// checkQuantiles compares the sketch of values against their exact
+// quantiles, at the same rank.
+func checkQuantiles(t *testing.T, name string, values []float64) {
+	t.Helper()
+	var s sketch
+	for _, v := range values {
+		s.add(v)
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99, 0.999} {
+		want := sorted[int(q*float64(len(sorted)-1))]
+		got := s.quantile(q)
+		if err := math.Abs(got-want) / want; err > latencyAccuracy+1e-9 {
+			t.Errorf("%s p%g: expected %g within %g, got %g (error %.4f)", name, 100*q, want, latencyAccuracy, got, err)
+		}
+	}
+}
+
+func TestSketch_Accuracy(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	const n = 100000
+	lognormal, exponential, uniform := make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range n {
+		lognormal[i] = math.Exp(rng.NormFloat64()) // median 1s, long tail
+		exponential[i] = 0.2 * rng.ExpFloat64()
+		uniform[i] = 1 + 59*rng.Float64()
+	}
+	checkQuantiles(t, "lognormal", lognormal)
+	checkQuantiles(t, "exponential", exponential[1:]) // avoid an exact 0
+	checkQuantiles(t, "uniform", uniform)
+}
+
+func TestSketch_BoundedMemory(t *testing.T) {
+	var s sketch
+	// From 100µs to about 11 days, more than latencyMaxBins can hold.
+	for v := latencyMinSeconds; v < 1e6; v *= 1.001 {
+		s.add(v)
+	}
+	s.add(1e-9)
+	if len(s.bins) > latencyMaxBins {
+		t.Fatalf("expected at most %d bins, got %d", latencyMaxBins, len(s.bins))
+	}
+	if got := s.quantile(1); math.Abs(got-1e6)/1e6 > latencyAccuracy {
+		t.Errorf("expected the maximum kept accurately, got %g", got)
+	}
+	if got := s.quantile(0); got != 0 {
+		t.Errorf("expected a value below the sketch's minimum reported as 0, got %g", got)
+	}
+}
+
+func TestSketch_Merge(t *testing.T) {
+	var low, high, all sketch
+	for i := 1; i <= 1000; i++ {
+		v := float64(i) / 100
+		all.add(v)
+		if i%2 == 0 {
+			low.add(v)
+		} else {
+			high.add(v)
+		}
+	}
+	low.merge(&high)
+	if low.count != all.count {
+		t.Fatalf("expected %d values after merging, got %d", all.count, low.count)
+	}
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		if low.quantile(q) != all.quantile(q) {
+			t.Errorf("p%g: expected the merged sketch to match, got %g and %g", 100*q, low.quantile(q), all.quantile(q))
+		}
+	}
+}
+
+func TestLatencyTracker_SlidingWindow(t *testing.T) {
+	tr := newLatencyTracker(time.Minute)
+	start := time.Unix(6000, 0) // at a slot boundary
+	for i := range 100 {
+		tr.observe("m", start, time.Duration(i+1)*10*time.Millisecond, false)
+	}
+	tr.observe("m", start, 300*time.Millisecond, true)
+	tr.observe("m", start.Add(40*time.Second), 5*time.Second, false)
+
+	entry := tr.snapshot(start.Add(50 * time.Second)).Models["m"]
+	d := entry.DurationSeconds
+	if d.Count != 101 || math.Abs(d.P99-1) > 0.01 {
+		t.Errorf("expected 101 durations with p99 near 1s, got %+v", d)
+	}
+	if ttft := entry.TTFTSeconds; ttft == nil || ttft.Count != 1 || math.Abs(ttft.P50-0.3) > 0.003 {
+		t.Errorf("expected the TTFT reported on its own, got %+v", ttft)
+	}
+
+	// A minute on, the first slot has slid out of the window.
+	entry = tr.snapshot(start.Add(65 * time.Second)).Models["m"]
+	d = entry.DurationSeconds
+	if d.Count != 1 || math.Abs(d.P50-5) > 0.05 {
+		t.Errorf("expected only the later duration left, got %+v", d)
+	}
+	if entry.TTFTSeconds != nil {
+		t.Error("expected the expired TTFT gone")
+	}
+
+	tr.snapshot(start.Add(5 * time.Minute))
+	if len(tr.models) != 0 {
+		t.Errorf("expected a model without observations in the window forgotten, got %d", len(tr.models))
+	}
+}
+
+func TestLatencyTracker_BoundedModels(t *testing.T) {
+	tr := newLatencyTracker(time.Minute)
+	now := time.Unix(6000, 0)
+	for i := range latencyMaxModels + 10 {
+		tr.observe(strings.Repeat("m", i+1), now, time.Second, false)
+	}
+	if len(tr.models) != latencyMaxModels {
+		t.Errorf("expected %d models tracked, got %d", latencyMaxModels, len(tr.models))
+	}
+	tr.observe("late", now.Add(2*time.Minute), time.Second, false)
+	if len(tr.models) != 1 {
+		t.Errorf("expected the idle models to make room, got %d", len(tr.models))
+	}
+}
+
+func TestServeStats_Latency(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{LatencyWindow: 5 * time.Minute})
+	for range 3 {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`)))
+	}
+	rr := httptest.NewRecorder()
+	h.ServeStats(rr, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var body struct {
+		Latency struct {
+			Window string `json:"window"`
+			Models map[string]struct {
+				Duration *proxyapi.Quantiles `json:"duration_seconds"`
+				TTFT     *proxyapi.Quantiles `json:"ttft_seconds"`
+			} `json:"models"`
+		} `json:"latency"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	m := body.Latency.Models["m"]
+	if body.Latency.Window != "5m0s" || m.Duration == nil || m.Duration.Count != 3 || m.TTFT == nil || m.TTFT.Count != 3 {
+		t.Errorf("expected quantiles of three requests, got %s", rr.Body.String())
+	}
+
+	h = newTestHandler(t, tokenUpstream(t).URL)
+	rr = httptest.NewRecorder()
+	h.ServeStats(rr, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if strings.Contains(rr.Body.String(), `"latency"`) {
+		t.Errorf("expected no latency without LatencyWindow, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,330 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/kv"
+)
+
+// Limiter names as used by ollama_proxy_limiter_checks_total.
+const (
+	limiterRate  = "rate"
+	limiterQuota = "quota"
+)
+
+// storeSource reports whether s is currently answering from shared (remote)
+// state or from this replica's memory.
+func storeSource(s kv.Store) string {
+	if r, ok := s.(interface{ Remote() bool }); ok && r.Remote() {
+		return "remote"
+	}
+	return "local"
+}
+
+// windowStart truncates now to the fixed window it falls in.
+func windowStart(now time.Time, window time.Duration) time.Time {
+	return now.Truncate(window)
+}
+
+// windowGauge is a per-tenant gauge of a limiter window. A tenant's series
+// is deleted once its window has ended without the tenant being seen again,
+// so tenants that come and go, one per client IP say, do not pile up.
+type windowGauge struct {
+	vec *prometheus.GaugeVec
+
+	mu   sync.Mutex
+	ends map[string]time.Time // tenant → end of the window its value is for
+}
+
+func newWindowGauge(vec *prometheus.GaugeVec) *windowGauge {
+	return &windowGauge{vec: vec, ends: map[string]time.Time{}}
+}
+
+// set records tenant's value for the window ending at end.
+func (g *windowGauge) set(tenant string, v float64, end time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if end.After(g.ends[tenant]) {
+		g.ends[tenant] = end
+	}
+	g.vec.WithLabelValues(tenant).Set(v)
+}
+
+// expire deletes the series of tenants whose window ended by now.
+func (g *windowGauge) expire(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for tenant, end := range g.ends {
+		if !now.Before(end) {
+			delete(g.ends, tenant)
+			g.vec.DeleteLabelValues(tenant)
+		}
+	}
+}
+
+// run expires series every second until ctx is done.
+func (g *windowGauge) run(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			g.expire(now)
+		}
+	}
+}
+
+// rateLimiter is a fixed-window request counter per tenant kept in a kv.Store,
+// so every replica that shares the store enforces one global limit.
+type rateLimiter struct {
+	store  kv.Store
+	limit  int64
+	window time.Duration
+}
+
+// allow counts one request for tenant and reports whether it is within the
+// limit, the window's count including it and when the window resets.
+func (l *rateLimiter) allow(ctx context.Context, tenant string, now time.Time) (ok bool, used int64, reset time.Time, err error) {
+	ws := windowStart(now, l.window)
+	reset = ws.Add(l.window)
+	key := "rl:" + tenant + ":" + strconv.FormatInt(ws.Unix(), 10)
+	used, err = l.store.IncrBy(ctx, key, 1, l.window+time.Second)
+	if err != nil {
+		return true, 0, reset, err // fail open: never block traffic on a store error
+	}
+	return used <= l.limit, used, reset, nil
+}
+
+// setRateLimitHeaders advertises a limiter's state to the client as
+// X-RateLimit-{Limit,Remaining,Reset}-<unit>. Reset is in whole seconds until
+// the window rolls over.
+func setRateLimitHeaders(hdr http.Header, unit string, limit, used int64, reset time.Time) {
+	secs := max(0, int64(math.Ceil(time.Until(reset).Seconds())))
+	hdr.Set(headerRateLimit+"Limit-"+unit, strconv.FormatInt(limit, 10))
+	hdr.Set(headerRateLimit+"Remaining-"+unit, strconv.FormatInt(max(0, limit-used), 10))
+	hdr.Set(headerRateLimit+"Reset-"+unit, strconv.FormatInt(secs, 10))
+}
+
+// quotaTracker enforces a token budget per tenant and window. Consumption is
+// buffered locally and flushed to the store in the background so the store's
+// latency stays off the response path.
+type quotaTracker struct {
+	store  kv.Store
+	budget int64
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]int64 // store key → tokens not yet flushed
+	every   time.Duration
+
+	usedRatio *windowGauge // token_budget_used_ratio
+}
+
+func newQuotaTracker(store kv.Store, budget int64, window, flushEvery time.Duration, usedRatio *prometheus.GaugeVec) *quotaTracker {
+	return &quotaTracker{
+		store:     store,
+		budget:    budget,
+		window:    window,
+		pending:   map[string]int64{},
+		every:     flushEvery,
+		usedRatio: newWindowGauge(usedRatio),
+	}
+}
+
+func (q *quotaTracker) key(tenant string, now time.Time) string {
+	return "quota:" + tenant + ":" + strconv.FormatInt(windowStart(now, q.window).Unix(), 10)
+}
+
+// used returns the tokens tenant has consumed in the current window,
+// including consumption not yet flushed from this replica.
+func (q *quotaTracker) used(ctx context.Context, tenant string, now time.Time) (int64, error) {
+	key := q.key(tenant, now)
+	q.mu.Lock()
+	n := q.pending[key]
+	q.mu.Unlock()
+	v, ok, err := q.store.Get(ctx, key)
+	if err != nil || !ok {
+		return n, err
+	}
+	remote, _ := strconv.ParseInt(string(v), 10, 64)
+	return n + remote, nil
+}
+
+// add records tokens consumed by tenant.
+func (q *quotaTracker) add(tenant string, tokens int64, now time.Time) {
+	if tokens <= 0 {
+		return
+	}
+	q.mu.Lock()
+	q.pending[q.key(tenant, now)] += tokens
+	q.mu.Unlock()
+}
+
+// flush writes buffered consumption to the store. Deltas that fail to write
+// are put back for the next attempt.
+func (q *quotaTracker) flush() {
+	q.mu.Lock()
+	batch := q.pending
+	q.pending = map[string]int64{}
+	q.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for key, n := range batch {
+		if _, err := q.store.IncrBy(ctx, key, n, q.window+time.Minute); err != nil {
+			q.mu.Lock()
+			q.pending[key] += n
+			q.mu.Unlock()
+		}
+	}
+}
+
+// run flushes every interval, and drops the used-ratio series of windows
+// that have ended, until ctx is done. The final flush is left to
+// Handler.Close, so it also happens when the worker is backing off.
+func (q *quotaTracker) run(ctx context.Context) {
+	t := time.NewTicker(q.every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			q.flush()
+			q.usedRatio.expire(now)
+		}
+	}
+}
+
+// tenantOf returns the identity that limits and quotas are accounted to:
+// the name of the request's API key, else the TenantHeader value when the
+// request has one, sanitized like a label, else the client's address as
+// limitIP finds it. It is taken once per request, into reqInfo.tenant.
+func (h *Handler) tenantOf(r *http.Request) string {
+	if name := clientName(r); name != "" {
+		return name
+	}
+	if h.cfg.TenantHeader != "" {
+		if t := r.Header.Get(h.cfg.TenantHeader); t != "" {
+			return h.labelValue(labelTenant, t)
+		}
+	}
+	return h.limitIP(r)
+}
+
+// limitIP is the client address limits are keyed on: the connection's peer,
+// unless the peer is one of TrustedProxies. Then it is the nearest
+// X-Forwarded-For hop that is not a trusted proxy, or X-Real-IP without
+// one. Forwarded headers from anyone else are ignored, so a client cannot
+// start a fresh window by sending a new X-Forwarded-For each request.
+func (h *Handler) limitIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !h.trustedProxy(peer) {
+		return peer
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop != "" && (i == 0 || !h.trustedProxy(hop)) {
+			return hop
+		}
+	}
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" {
+		return real
+	}
+	return peer
+}
+
+// trustedProxy reports whether ip is in TrustedProxies.
+func (h *Handler) trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range h.cfg.TrustedProxies {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// inspectLimits applies the rate limit, token budget and tokens-per-minute
+// limit to a request, rejecting it with 429 when it must not be forwarded.
+// Canary probes are always admitted. The rate and TPM limiters leave their
+// state in X-RateLimit-* response headers.
+func (h *Handler) inspectLimits(ctx context.Context, req *ParsedRequest) error {
+	ri := req.ri
+	if isCanary(ri.r) {
+		return nil
+	}
+	tenant := ri.tenant
+	now := time.Now()
+	if h.limiter != nil {
+		h.metrics.LimiterChecks.WithLabelValues(limiterRate, storeSource(h.shared)).Inc()
+		ok, used, reset, err := h.limiter.allow(ctx, tenant, now)
+		if err != nil {
+			h.logRepeatable(ctx, slog.LevelWarn, "rate limiter store error", ri.endpoint, err.Error(), "request_id", ri.id, "error", err)
+		} else {
+			setRateLimitHeaders(req.ResponseHeader, "Requests", h.limiter.limit, used, reset)
+		}
+		if !ok {
+			return &Rejection{
+				Reason:  reasonRateLimited,
+				Status:  http.StatusTooManyRequests,
+				Message: "rate limit exceeded",
+				RetryAt: reset,
+				Fields:  map[string]any{"limit": h.limiter.limit, "window": h.limiter.window.String()},
+			}
+		}
+	}
+	if h.quota != nil {
+		h.metrics.LimiterChecks.WithLabelValues(limiterQuota, storeSource(h.shared)).Inc()
+		used, err := h.quota.used(ctx, tenant, now)
+		if err != nil {
+			h.logRepeatable(ctx, slog.LevelWarn, "quota store error", ri.endpoint, err.Error(), "request_id", ri.id, "error", err)
+		} else {
+			h.quota.usedRatio.set(tenant, float64(used)/float64(h.quota.budget), windowStart(now, h.quota.window).Add(h.quota.window))
+		}
+		if used >= h.quota.budget {
+			return &Rejection{
+				Reason:  reasonQuotaExhausted,
+				Status:  http.StatusTooManyRequests,
+				Message: "token budget exhausted",
+				RetryAt: windowStart(now, h.quota.window).Add(h.quota.window),
+				Fields:  map[string]any{"budget": h.quota.budget, "used": used, "window": h.quota.window.String()},
+			}
+		}
+	}
+	// Last, so that no other limit can reject after tokens were reserved.
+	if h.tpm != nil && endpointClass(ri.endpoint) != "other" {
+		return h.admitTPM(ctx, req, tenant, now)
+	}
+	return nil
+}
+
+// consume charges a completed request's tokens to its tenant's budget and
+// notes them for the tokens-per-minute reconciliation. known reports whether
+// the response carried token counts at all.
+func (h *Handler) consume(ri *reqInfo, tokens int64, known bool) {
+	ri.tokens, ri.tokensKnown = tokens, known
+	h.trackConversation(ri, tokens)
+	if h.quota != nil && !isCanary(ri.r) {
+		h.quota.add(ri.tenant, tokens, time.Now())
+	}
+}
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/kv"
+)
+
+func tokenUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true,"prompt_eval_count":40,"eval_count":60}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func generate(h *Handler, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	req.RemoteAddr = ip + ":1234"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRateLimit_SharedAcrossReplicas(t *testing.T) {
+	upstream := tokenUpstream(t)
+	shared := kv.NewMemory()
+	defer shared.Close()
+	cfg := Config{SharedStore: shared, RateLimit: 3, RateLimitWindow: time.Hour}
+	a := newTestHandlerWithConfig(t, upstream.URL, cfg)
+	b := newTestHandlerWithConfig(t, upstream.URL, cfg)
+
+	for i, h := range []*Handler{a, b, a} {
+		if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rr.Code)
+		}
+	}
+	rr := generate(b, "10.0.0.1")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the global limit is hit, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+	var body map[string]any
+	_ = json.NewDecoder(rr.Body).Decode(&body)
+	if body["limit"].(float64) != 3 {
+		t.Errorf("expected limit=3 in body, got %v", body)
+	}
+	if rr := generate(b, "10.0.0.2"); rr.Code != http.StatusOK {
+		t.Errorf("expected other tenants unaffected, got %d", rr.Code)
+	}
+}
+
+func generateForwarded(h *Handler, peer, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	req.RemoteAddr = peer + ":1234"
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRateLimit_SpoofedForwardedForIgnored(t *testing.T) {
+	upstream := tokenUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{RateLimit: 2, RateLimitWindow: time.Hour})
+
+	for i := range 2 {
+		if rr := generateForwarded(h, "10.0.0.1", fmt.Sprintf("203.0.113.%d", i)); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rr.Code)
+		}
+	}
+	if rr := generateForwarded(h, "10.0.0.1", "203.0.113.99"); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected a new X-Forwarded-For not to reset the window, got %d", rr.Code)
+	}
+}
+
+func TestRateLimit_TrustedProxyForwardedFor(t *testing.T) {
+	upstream := tokenUpstream(t)
+	_, lb, _ := net.ParseCIDR("10.0.0.0/24")
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{RateLimit: 1, RateLimitWindow: time.Hour, TrustedProxies: []*net.IPNet{lb}})
+
+	// The client's own claim, before the hop the balancer appended, is not
+	// trusted.
+	if rr := generateForwarded(h, "10.0.0.1", "198.51.100.7, 203.0.113.1"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if rr := generateForwarded(h, "10.0.0.2", "198.51.100.8, 203.0.113.1"); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 203.0.113.1 limited through either balancer, got %d", rr.Code)
+	}
+	if rr := generateForwarded(h, "10.0.0.1", "203.0.113.2"); rr.Code != http.StatusOK {
+		t.Fatalf("expected another client behind the balancer admitted, got %d", rr.Code)
+	}
+}
+
+func TestTokenBudget_EnforcedAfterFlush(t *testing.T) {
+	upstream := tokenUpstream(t)
+	shared := kv.NewMemory()
+	defer shared.Close()
+	cfg := Config{SharedStore: shared, TokenBudget: 150, TokenBudgetWindow: time.Hour, QuotaFlushInterval: time.Hour}
+	a := newTestHandlerWithConfig(t, upstream.URL, cfg)
+	b := newTestHandlerWithConfig(t, upstream.URL, cfg)
+
+	generate(a, "10.0.0.1") // 100 tokens, buffered on replica a
+	if rr := generate(b, "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 before a's consumption is flushed, got %d", rr.Code)
+	}
+	a.quota.flush()
+	b.quota.flush()
+	if rr := generate(b, "10.0.0.1"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 once 200 tokens are recorded against a 150 budget, got %d", rr.Code)
+	}
+}
+
+func TestTokenBudget_LocalPendingCounts(t *testing.T) {
+	upstream := tokenUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{TokenBudget: 100, QuotaFlushInterval: time.Hour})
+
+	generate(h, "10.0.0.1")
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected unflushed local consumption to count, got %d", rr.Code)
+	}
+}
+
+func TestTokenBudget_UsedRatioGauge(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{TokenBudget: 400, QuotaFlushInterval: time.Hour})
+	generate(h, "10.0.0.1")
+	generate(h, "10.0.0.1") // admitted with the first request's 100 tokens charged
+	if got := testutil.ToFloat64(h.metrics.BudgetUsed.WithLabelValues("10.0.0.1")); got != 0.25 {
+		t.Errorf("expected 0.25 of the budget used, got %v", got)
+	}
+}
+
+func TestTokenBudget_UsedRatioExpiresWithWindow(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL,
+		Config{TokenBudget: 400, TokenBudgetWindow: time.Hour, QuotaFlushInterval: time.Hour})
+	generate(h, "10.0.0.1")
+	generate(h, "10.0.0.2")
+	if n := testutil.CollectAndCount(h.metrics.BudgetUsed); n != 2 {
+		t.Fatalf("expected a series per tenant, got %d", n)
+	}
+	h.quota.usedRatio.expire(windowStart(time.Now(), time.Hour).Add(time.Hour))
+	if n := testutil.CollectAndCount(h.metrics.BudgetUsed); n != 0 {
+		t.Errorf("expected series gone once the window passed unused, got %d", n)
+	}
+}
+
+func TestLimiterChecks_Source(t *testing.T) {
+	upstream := tokenUpstream(t)
+	remote := kv.NewFallback(kv.NewMemory(), kv.NewMemory(), time.Minute, nil)
+	defer remote.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{SharedStore: remote, RateLimit: 10})
+	generate(h, "10.0.0.1")
+	if got := testutil.ToFloat64(h.metrics.LimiterChecks.WithLabelValues(limiterRate, "remote")); got != 1 {
+		t.Errorf("expected 1 remote check, got %v", got)
+	}
+
+	local := newTestHandlerWithConfig(t, upstream.URL, Config{RateLimit: 10})
+	generate(local, "10.0.0.1")
+	if got := testutil.ToFloat64(local.metrics.LimiterChecks.WithLabelValues(limiterRate, "local")); got != 1 {
+		t.Errorf("expected 1 local check, got %v", got)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	upstream := tokenUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{RateLimit: 2, RateLimitWindow: time.Hour, TPMLimit: 1000})
+	h.tpm.window = time.Hour
+
+	rr := generate(h, "10.0.0.1")
+	want := map[string]string{
+		"X-RateLimit-Limit-Requests":     "2",
+		"X-RateLimit-Remaining-Requests": "1",
+		"X-RateLimit-Limit-Tokens":       "1000",
+		"X-RateLimit-Remaining-Tokens":   "993", // 7-token estimate reserved
+	}
+	for k, v := range want {
+		if got := rr.Header().Get(k); got != v {
+			t.Errorf("%s: got %q, want %q", k, got, v)
+		}
+	}
+	if reset := rr.Header().Get("X-RateLimit-Reset-Requests"); reset == "" || reset == "0" {
+		t.Errorf("expected seconds until the window resets, got %q", reset)
+	}
+
+	generate(h, "10.0.0.1")
+	rr = generate(h, "10.0.0.1")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("X-RateLimit-Remaining-Requests") != "0" {
+		t.Errorf("expected 429 with nothing remaining, got %d remaining=%q", rr.Code, rr.Header().Get("X-RateLimit-Remaining-Requests"))
+	}
+}
+
+func TestRateLimitHeaders_OmittedWhenDisabled(t *testing.T) {
+	rr := generate(newTestHandler(t, tokenUpstream(t).URL), "10.0.0.1")
+	for k := range rr.Header() {
+		if strings.HasPrefix(k, "X-Ratelimit-") {
+			t.Errorf("unexpected header %s without limits", k)
+		}
+	}
+}
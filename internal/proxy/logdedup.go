@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupKey identifies lines that repeat one another: the same message about
+// the same endpoint failing the same way.
+type dedupKey struct {
+	msg, endpoint, class string
+}
+
+// dedupEntry is a key's current window: when its first line was logged and
+// how many lines after it were held back.
+type dedupEntry struct {
+	first      time.Time
+	level      slog.Level
+	suppressed int64
+	lastError  string
+}
+
+// logDeduper collapses repeated error lines so that an upstream outage
+// under load logs a line per error kind per window, not one per request.
+// The first line of a key is always logged; its repeats within the window
+// are only counted, in log_lines_suppressed_total, and logged as one line
+// with a repeat count when the window ends.
+type logDeduper struct {
+	h      *Handler
+	window time.Duration
+
+	mu      sync.Mutex
+	entries map[dedupKey]*dedupEntry
+}
+
+func newLogDeduper(h *Handler, window time.Duration) *logDeduper {
+	return &logDeduper{h: h, window: window, entries: map[dedupKey]*dedupEntry{}}
+}
+
+// logRepeatable logs an error line through the deduper when
+// LogDedupWindow is set; errMsg decides its error class.
+func (h *Handler) logRepeatable(ctx context.Context, level slog.Level, msg, endpoint, errMsg string, args ...any) {
+	if h.dedup == nil || h.dedup.allow(level, msg, endpoint, errMsg) {
+		h.logger.Log(ctx, level, msg, args...)
+	}
+}
+
+// allow reports whether a line should be logged: false when it repeats one
+// logged less than a window ago, which it counts instead.
+func (d *logDeduper) allow(level slog.Level, msg, endpoint, errMsg string) bool {
+	key := dedupKey{msg: msg, endpoint: endpoint, class: errorClass(errMsg)}
+	now := time.Now()
+	d.mu.Lock()
+	e := d.entries[key]
+	if e != nil && now.Sub(e.first) < d.window {
+		e.suppressed++
+		e.lastError = errMsg
+		d.mu.Unlock()
+		d.h.metrics.LogLinesSuppressed.WithLabelValues(msg).Inc()
+		return false
+	}
+	d.entries[key] = &dedupEntry{first: now, level: level}
+	d.mu.Unlock()
+	if e != nil {
+		d.logRepeats(key, e, now)
+	}
+	return true
+}
+
+// logRepeats logs the line standing for e's held-back repeats, if any.
+func (d *logDeduper) logRepeats(key dedupKey, e *dedupEntry, now time.Time) {
+	if e.suppressed == 0 {
+		return
+	}
+	d.h.logger.Log(context.Background(), e.level, key.msg,
+		"endpoint", key.endpoint,
+		"error_class", key.class,
+		"error", e.lastError,
+		"repeated", e.suppressed,
+		"period_ms", now.Sub(e.first).Milliseconds(),
+	)
+}
+
+// flush ends the windows older than the deduper's window, or every window
+// when all is set, logging their repeat counts.
+func (d *logDeduper) flush(now time.Time, all bool) {
+	d.mu.Lock()
+	var ended map[dedupKey]*dedupEntry
+	for key, e := range d.entries {
+		if all || now.Sub(e.first) >= d.window {
+			if ended == nil {
+				ended = map[dedupKey]*dedupEntry{}
+			}
+			ended[key] = e
+			delete(d.entries, key)
+		}
+	}
+	d.mu.Unlock()
+	for key, e := range ended {
+		d.logRepeats(key, e, now)
+	}
+}
+
+// run ends windows as they expire until ctx is done, so that the repeats of
+// an error that stopped are logged too. Handler.Close logs the rest.
+func (d *logDeduper) run(ctx context.Context) {
+	tick := time.NewTicker(d.window)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			d.flush(now, false)
+		}
+	}
+}
+
+// errorClasses map text found in error messages to a stable class, so that
+// errors differing only in an address, port or byte count are one kind.
+var errorClasses = []struct{ text, class string }{
+	{"connection refused", "connection_refused"},
+	{"connection reset", "connection_reset"},
+	{"broken pipe", "broken_pipe"},
+	{"no such host", "dns"},
+	{"deadline exceeded", "timeout"},
+	{"timeout", "timeout"},
+	{"context canceled", "canceled"},
+	{"unexpected EOF", "unexpected_eof"},
+	{"EOF", "eof"},
+}
+
+// errorClass is the kind of failure errMsg describes: where it happened,
+// its first segment such as "upstream" or "write to client", and what
+// happened. Messages of an unknown kind are their own class, digits masked.
+func errorClass(errMsg string) string {
+	where, _, found := strings.Cut(errMsg, ": ")
+	if !found {
+		where = ""
+	}
+	for _, c := range errorClasses {
+		if strings.Contains(errMsg, c.text) {
+			return where + ":" + c.class
+		}
+	}
+	masked := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return '#'
+		}
+		return r
+	}, errMsg)
+	if len(masked) > 120 {
+		masked = masked[:120]
+	}
+	return masked
+}
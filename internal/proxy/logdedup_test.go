@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// lines returns the log lines with message msg.
+func (l *requestLines) lines(msg string) []map[string]any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []map[string]any
+	sc := bufio.NewScanner(bytes.NewReader(l.buf.Bytes()))
+	for sc.Scan() {
+		var line map[string]any
+		if json.Unmarshal(sc.Bytes(), &line) == nil && line["msg"] == msg {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+func TestLogDedup_UpstreamDown(t *testing.T) {
+	down := httptest.NewServer(nil)
+	down.Close()
+	h := newTestHandlerWithConfig(t, down.URL, Config{LogDedupWindow: time.Hour})
+	lines := logRequests(h)
+
+	for range 5 {
+		if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusBadGateway {
+			t.Fatalf("expected 502 with the upstream down, got %d", rr.Code)
+		}
+	}
+	got := lines.lines("request")
+	if len(got) != 1 || got[0]["status_code"] != float64(502) {
+		t.Fatalf("expected the first failure logged and its repeats held back, got %v", got)
+	}
+	if n := testutil.ToFloat64(h.metrics.LogLinesSuppressed.WithLabelValues("request")); n != 4 {
+		t.Errorf("expected 4 suppressed lines counted, got %v", n)
+	}
+	// The same failure on another endpoint is new.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/chat", nil))
+	if got := lines.lines("request"); len(got) != 2 {
+		t.Errorf("expected the first failure on /api/chat logged, got %v", got)
+	}
+
+	h.dedup.flush(time.Now(), true)
+	got = lines.lines("request")
+	if len(got) != 3 || got[2]["repeated"] != float64(4) || got[2]["endpoint"] != "/api/generate" ||
+		got[2]["error_class"] != "upstream:connection_refused" {
+		t.Errorf("expected one line with the repeat count once the window ends, got %v", got)
+	}
+}
+
+func TestLogDedup_NewWindowLogsAgain(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{LogDedupWindow: 50 * time.Millisecond})
+	lines := logRequests(h)
+	d := h.dedup
+	if !d.allow(0, "m", "/api/generate", "upstream: EOF") || d.allow(0, "m", "/api/generate", "upstream: EOF") {
+		t.Fatal("expected the first line allowed and its repeat held back")
+	}
+	if !d.allow(0, "m", "/api/generate", "upstream: dial tcp: connection refused") {
+		t.Error("expected an error of another class allowed")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !d.allow(0, "m", "/api/generate", "upstream: EOF") {
+		t.Error("expected the first line after the window allowed")
+	}
+	if got := lines.lines("m"); len(got) != 1 || got[0]["repeated"] != float64(1) {
+		t.Errorf("expected the ended window's repeat count logged, got %v", got)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	for msg, want := range map[string]string{
+		"upstream: dial tcp 127.0.0.1:11434: connect: connection refused":           "upstream:connection_refused",
+		"upstream: dial tcp 10.0.0.2:11434: connect: connection refused":            "upstream:connection_refused",
+		"write to client: write tcp 10.1.1.1:80->10.2.2.2:5123: write: broken pipe": "write to client:broken_pipe",
+		"read stream: unexpected EOF":                                               "read stream:unexpected_eof",
+		"model \"llama3:8b\" not found":                                             "model \"llama#:#b\" not found",
+	} {
+		if got := errorClass(msg); got != want {
+			t.Errorf("errorClass(%q) = %q, want %q", msg, got, want)
+		}
+	}
+}
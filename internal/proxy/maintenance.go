@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// maintenanceSet holds the models in maintenance, keyed by canonicalModel.
+// It lives on the Handler, so it lasts for the life of the process.
+type maintenanceSet struct {
+	mu     sync.Mutex
+	models map[string]proxyapi.Maintenance
+}
+
+// get returns the active maintenance entry for model, dropping it once it
+// has expired.
+func (m *maintenanceSet) get(model string, now time.Time) (proxyapi.Maintenance, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := canonicalModel(model)
+	e, ok := m.models[key]
+	if ok && e.Expired(now) {
+		delete(m.models, key)
+		return proxyapi.Maintenance{}, false
+	}
+	return e, ok
+}
+
+func (m *maintenanceSet) set(e proxyapi.Maintenance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.models[canonicalModel(e.Model)] = e
+}
+
+// clear removes model from maintenance and reports whether it was in it.
+func (m *maintenanceSet) clear(model string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := canonicalModel(model)
+	_, ok := m.models[key]
+	delete(m.models, key)
+	return ok
+}
+
+// list returns the active entries ordered by model.
+func (m *maintenanceSet) list(now time.Time) []proxyapi.Maintenance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []proxyapi.Maintenance{}
+	for key, e := range m.models {
+		if e.Expired(now) {
+			delete(m.models, key)
+			continue
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Model < out[j].Model })
+	return out
+}
+
+// inspectMaintenance rejects a request for a model in maintenance with 503.
+// The canary does not probe such models, so a planned outage is not
+// recorded as canary failures.
+func (h *Handler) inspectMaintenance(_ context.Context, req *ParsedRequest) error {
+	e, ok := h.maintenance.get(req.Model, time.Now())
+	if !ok {
+		return nil
+	}
+	msg := e.Message
+	if msg == "" {
+		msg = "model " + req.Model + " is under maintenance"
+	}
+	rej := &Rejection{
+		Reason:  reasonMaintenance,
+		Status:  http.StatusServiceUnavailable,
+		Message: msg,
+		Fields:  map[string]any{"model": req.Model, "since": e.Since.UTC().Format(time.RFC3339)},
+	}
+	if e.ExpiresAt != nil {
+		rej.RetryAt = *e.ExpiresAt
+		rej.Fields["expires_at"] = rej.RetryAt.UTC().Format(time.RFC3339)
+	}
+	return rej
+}
+
+func (h *Handler) handleListModels(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, proxyapi.MaintenanceList{Maintenance: h.maintenance.list(time.Now())})
+}
+
+func (h *Handler) handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req proxyapi.MaintenanceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAdminJSON(w, http.StatusBadRequest, proxyapi.ErrorResponse{Error: "invalid body: " + err.Error()})
+			return
+		}
+	}
+	now := time.Now()
+	e := proxyapi.Maintenance{Model: r.PathValue("model"), Message: req.Message, Since: now, ExpiresAt: req.ExpiresAt}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			writeAdminJSON(w, http.StatusBadRequest, proxyapi.ErrorResponse{Error: "expires_in must be a positive duration"})
+			return
+		}
+		at := now.Add(d)
+		e.ExpiresAt = &at
+	}
+	if e.Expired(now) {
+		writeAdminJSON(w, http.StatusBadRequest, proxyapi.ErrorResponse{Error: "expires_at is in the past"})
+		return
+	}
+	h.maintenance.set(e)
+	h.logger.Info("model maintenance set", "model", e.Model, "message", e.Message, "expires_at", e.ExpiresAt)
+	writeAdminJSON(w, http.StatusOK, e)
+}
+
+func (h *Handler) handleClearMaintenance(w http.ResponseWriter, r *http.Request) {
+	model := r.PathValue("model")
+	if !h.maintenance.clear(model) {
+		writeAdminJSON(w, http.StatusNotFound, proxyapi.ErrorResponse{Error: "model not in maintenance"})
+		return
+	}
+	h.logger.Info("model maintenance cleared", "model", model)
+	w.WriteHeader(http.StatusNoContent)
+}
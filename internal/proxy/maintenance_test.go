@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+func adminDo(t *testing.T, mux *http.ServeMux, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMaintenance_RejectsUntilCleared(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	mux := http.NewServeMux()
+	h.RegisterAdmin(mux, "secret")
+
+	if rr := adminDo(t, mux, http.MethodPut, "/admin/models/m/maintenance", "wrong", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a bad token, got %d", rr.Code)
+	}
+	rr := adminDo(t, mux, http.MethodPut, "/admin/models/m/maintenance", "secret", `{"message":"re-quantizing"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+
+	// "m" and "m:latest" are the same model.
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m:latest","stream":false}`))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	var body map[string]any
+	_ = json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusServiceUnavailable || body["error"] != "re-quantizing" {
+		t.Fatalf("expected 503 with the maintenance message, got %d %v", rr.Code, body)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonMaintenance)); got != 1 {
+		t.Errorf("expected model_maintenance rejection, got %v", got)
+	}
+
+	rr = adminDo(t, mux, http.MethodGet, "/admin/models", "secret", "")
+	var list struct{ Maintenance []proxyapi.Maintenance }
+	_ = json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Maintenance) != 1 || list.Maintenance[0].Model != "m" {
+		t.Errorf("expected m listed, got %+v", list)
+	}
+
+	if rr := adminDo(t, mux, http.MethodDelete, "/admin/models/m/maintenance", "secret", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on clear, got %d", rr.Code)
+	}
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Errorf("expected requests to pass after clearing, got %d", rr.Code)
+	}
+}
+
+func TestMaintenance_Expires(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	past := time.Now().Add(-time.Second)
+	h.maintenance.set(proxyapi.Maintenance{Model: "m", Since: past.Add(-time.Hour), ExpiresAt: &past})
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Errorf("expected expired maintenance to be ignored, got %d", rr.Code)
+	}
+	if got := h.maintenance.list(time.Now()); len(got) != 0 {
+		t.Errorf("expected expired entry dropped, got %+v", got)
+	}
+}
+
+func TestMaintenance_ExpiresInSetsRetryAfter(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	mux := http.NewServeMux()
+	h.RegisterAdmin(mux, "secret")
+	if rr := adminDo(t, mux, http.MethodPut, "/admin/models/m/maintenance", "secret", `{"expires_in":"bogus"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad duration, got %d", rr.Code)
+	}
+	adminDo(t, mux, http.MethodPut, "/admin/models/m/maintenance", "secret", `{"expires_in":"1h"}`)
+	rr := generate(h, "10.0.0.1")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After, got %d %v", rr.Code, rr.Header())
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+const (
+	// malformedSampleBytes caps the sample of a bad line written to the log.
+	malformedSampleBytes = 256
+	// malformedBurst is how many malformed lines within malformedWindow make
+	// /stats report a burst, which usually means a broken upstream.
+	malformedBurst  = 10
+	malformedWindow = time.Minute
+)
+
+// malformedTracker remembers recent malformed stream lines for /stats.
+type malformedTracker struct {
+	mu          sync.Mutex
+	recent      []time.Time // within malformedWindow, oldest first
+	lastAt      time.Time
+	lastModel   string
+	lastRequest string
+}
+
+func (t *malformedTracker) add(now time.Time, model, requestID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trim(now)
+	if len(t.recent) < 10*malformedBurst { // enough to tell a burst; bounded
+		t.recent = append(t.recent, now)
+	}
+	t.lastAt, t.lastModel, t.lastRequest = now, model, requestID
+}
+
+func (t *malformedTracker) trim(now time.Time) {
+	i := 0
+	for i < len(t.recent) && now.Sub(t.recent[i]) > malformedWindow {
+		i++
+	}
+	t.recent = t.recent[i:]
+}
+
+// snapshot returns the tracker's state as reported by /stats.
+func (t *malformedTracker) snapshot(now time.Time) proxyapi.Malformed {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trim(now)
+	out := proxyapi.Malformed{LastMinute: len(t.recent), Burst: len(t.recent) >= malformedBurst}
+	if !t.lastAt.IsZero() {
+		at := t.lastAt.UTC().Truncate(time.Second)
+		out.LastAt, out.LastModel, out.LastRequestID = &at, t.lastModel, t.lastRequest
+	}
+	return out
+}
+
+// observeMalformed counts a stream line that is not valid JSON. The line has
+// already been forwarded unmodified; this only records it.
+func (h *Handler) observeMalformed(ri *reqInfo, line []byte) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return // blank keep-alive lines are harmless
+	}
+	h.metrics.MalformedChunks.WithLabelValues(ri.endpoint, ri.modelLabel).Inc()
+	h.malformed.add(time.Now(), ri.model, ri.id)
+	sample := line
+	if len(sample) > malformedSampleBytes {
+		sample = sample[:malformedSampleBytes]
+	}
+	h.logger.Debug("malformed stream chunk", "request_id", ri.id, "endpoint", ri.endpoint,
+		"model", ri.model, "bytes", len(line), "sample", string(sample))
+}
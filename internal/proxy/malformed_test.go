@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestServeHTTP_CountsMalformedChunks(t *testing.T) {
+	const stream = "{\"response\":\"a\",\"done\":false}\n" +
+		"<html>oops</html>\n" +
+		"\n" +
+		"{\"response\":\"b\",\"done\":true,\"eval_count\":2}\n"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, stream)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","prompt":"hi"}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Body.String() != stream {
+		t.Errorf("expected the stream forwarded unmodified, got %q", rr.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.MalformedChunks.WithLabelValues("/api/generate", "m")); got != 1 {
+		t.Errorf("expected 1 malformed chunk (blank lines excluded), got %v", got)
+	}
+}
+
+func TestMalformedTracker_Burst(t *testing.T) {
+	var tr malformedTracker
+	now := time.Now()
+	for i := range malformedBurst - 1 {
+		tr.add(now.Add(time.Duration(i)*time.Millisecond), "m", "req")
+	}
+	if tr.snapshot(now).Burst {
+		t.Fatal("expected no burst below the threshold")
+	}
+	tr.add(now, "m", "req-last")
+	snap := tr.snapshot(now)
+	if !snap.Burst || snap.LastRequestID != "req-last" {
+		t.Errorf("expected a burst naming the last request, got %v", snap)
+	}
+	if snap := tr.snapshot(now.Add(2 * malformedWindow)); snap.LastMinute != 0 || snap.Burst {
+		t.Errorf("expected the burst to age out, got %v", snap)
+	}
+}
+
+func TestServeStats_ReportsMalformed(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	h.malformed.add(time.Now(), "m", "req-1")
+	rr := httptest.NewRecorder()
+	h.ServeStats(rr, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var st struct {
+		Malformed map[string]any `json:"malformed"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&st)
+	if st.Malformed["last_minute"] != float64(1) || st.Malformed["last_model"] != "m" {
+		t.Errorf("expected malformed state in /stats, got %v", st.Malformed)
+	}
+}
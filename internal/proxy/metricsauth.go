@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MetricsAuth protects /metrics with either a static bearer token or HTTP
+// basic auth. The zero value leaves it open.
+type MetricsAuth struct {
+	Token string
+	// Users maps a user name to its password, either in plain text or in
+	// htpasswd's "{SHA}" form (the base64 SHA-1 of the password).
+	Users map[string]string
+}
+
+// Enabled reports whether a credential is required.
+func (a MetricsAuth) Enabled() bool {
+	return a.Token != "" || len(a.Users) > 0
+}
+
+// Scheme is "bearer", "basic" or "" when a is not enabled.
+func (a MetricsAuth) Scheme() string {
+	switch {
+	case a.Token != "":
+		return "bearer"
+	case len(a.Users) > 0:
+		return "basic"
+	}
+	return ""
+}
+
+// shaPrefix marks an htpasswd entry hashed with SHA-1.
+const shaPrefix = "{SHA}"
+
+// ParseHtpasswd parses htpasswd-style "user:password" entries separated by
+// newlines or commas. A password may be given as "{SHA}<base64 SHA-1>", as
+// written by htpasswd -s; other hash formats such as bcrypt are refused
+// rather than compared as plain text.
+func ParseHtpasswd(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' }) {
+		item = strings.TrimSpace(item)
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+		user, pass, ok := strings.Cut(item, ":")
+		if !ok || user == "" || pass == "" {
+			// The password may be a secret, so only the user is echoed.
+			return nil, fmt.Errorf("invalid entry for %q: want user:password", user)
+		}
+		if strings.HasPrefix(pass, "$") {
+			return nil, fmt.Errorf("entry for %q: only plain and {SHA} passwords are supported", user)
+		}
+		if hash, ok := strings.CutPrefix(pass, shaPrefix); ok {
+			if b, err := base64.StdEncoding.DecodeString(hash); err != nil || len(b) != sha1.Size {
+				return nil, fmt.Errorf("entry for %q: invalid {SHA} hash", user)
+			}
+		}
+		if _, dup := out[user]; dup {
+			return nil, fmt.Errorf("user %q listed twice", user)
+		}
+		out[user] = pass
+	}
+	return out, nil
+}
+
+// checkBasic reports the failure mode of r's basic credentials against
+// users, or "" when they match an entry. Every entry is compared, in
+// constant time, so the time taken does not tell which user names exist.
+func checkBasic(r *http.Request, users map[string]string) string {
+	if r.Header.Get("Authorization") == "" {
+		return authMissing
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return authMalformed
+	}
+	sum := sha1.Sum([]byte(pass))
+	hashed := shaPrefix + base64.StdEncoding.EncodeToString(sum[:])
+	match := 0
+	for u, want := range users {
+		given := pass
+		if strings.HasPrefix(want, shaPrefix) {
+			given = hashed
+		}
+		match |= subtle.ConstantTimeCompare([]byte(u), []byte(user)) & subtle.ConstantTimeCompare([]byte(given), []byte(want))
+	}
+	if match != 1 {
+		return authInvalid
+	}
+	return ""
+}
+
+// MetricsAuthHandler wraps next, normally the Prometheus handler, so that
+// only requests carrying a's credential reach it; with a not enabled it
+// returns next. Others get a 401 like the admin endpoints' and are counted
+// in metrics_auth_failures_total. h is not used until a request is refused,
+// so a nil Handler describes the route too.
+func (h *Handler) MetricsAuthHandler(a MetricsAuth, next http.Handler) http.Handler {
+	if !a.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var mode, challenge string
+		if a.Token != "" {
+			mode = checkBearer(r, a.Token)
+			challenge = bearerChallenge(mode)
+		} else {
+			mode = checkBasic(r, a.Users)
+			challenge = `Basic realm="` + authRealm + `", charset="UTF-8"`
+		}
+		if mode != "" {
+			h.metrics.MetricsAuthFailures.WithLabelValues(mode).Inc()
+			writeUnauthorized(w, challenge, mode, metricsMessages[mode])
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// metricsMessages explain each authentication failure mode on /metrics.
+var metricsMessages = map[string]string{
+	authMissing:   "/metrics requires credentials",
+	authMalformed: "malformed Authorization header",
+	authInvalid:   "invalid credentials",
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseHtpasswd(t *testing.T) {
+	// {SHA} of "secret", as written by htpasswd -s.
+	got, err := ParseHtpasswd("prom:plain:with:colons\n# comment\n, ops:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["prom"] != "plain:with:colons" || got["ops"] != "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=" || len(got) != 2 {
+		t.Errorf("unexpected entries %v", got)
+	}
+	for _, bad := range []string{"prom", "prom:", ":secret", "prom:$2y$05$abc", "prom:{SHA}short", "a:1,a:2"} {
+		if _, err := ParseHtpasswd(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestMetricsAuth_Basic(t *testing.T) {
+	h := newTestHandler(t, "http://127.0.0.1:1")
+	users, err := ParseHtpasswd("prom:hunter2,ops:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := h.MetricsAuthHandler(MetricsAuth{Users: users}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("metrics"))
+	}))
+	scrape := func(set func(r *http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		set(r)
+		rr := httptest.NewRecorder()
+		metrics.ServeHTTP(rr, r)
+		return rr
+	}
+
+	for _, ok := range [][2]string{{"prom", "hunter2"}, {"ops", "secret"}} {
+		if rr := scrape(func(r *http.Request) { r.SetBasicAuth(ok[0], ok[1]) }); rr.Code != http.StatusOK || rr.Body.String() != "metrics" {
+			t.Errorf("expected %s admitted, got %d", ok[0], rr.Code)
+		}
+	}
+	cases := []struct {
+		name string
+		set  func(r *http.Request)
+		mode string
+	}{
+		{"no credentials", func(*http.Request) {}, authMissing},
+		{"not basic", func(r *http.Request) { r.Header.Set("Authorization", "Bearer x") }, authMalformed},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("prom", "hunter3") }, authInvalid},
+		{"another user's password", func(r *http.Request) { r.SetBasicAuth("prom", "secret") }, authInvalid},
+		{"unknown user", func(r *http.Request) { r.SetBasicAuth("eve", "hunter2") }, authInvalid},
+	}
+	for _, c := range cases {
+		rr := scrape(c.set)
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get(headerAuthError) != c.mode {
+			t.Errorf("%s: expected 401 %s, got %d %q", c.name, c.mode, rr.Code, rr.Header().Get(headerAuthError))
+		}
+		if got := rr.Header().Get(headerAuthenticate); !strings.HasPrefix(got, `Basic realm="ollama-proxy"`) {
+			t.Errorf("%s: expected a basic challenge, got %q", c.name, got)
+		}
+	}
+	if got := testutil.ToFloat64(h.metrics.MetricsAuthFailures.WithLabelValues(authInvalid)); got != 3 {
+		t.Errorf("expected 3 invalid credentials counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.AuthFailures.WithLabelValues(authInvalid)); got != 0 {
+		t.Errorf("expected the admin auth counter untouched, got %v", got)
+	}
+}
+
+func TestMetricsAuth_Bearer(t *testing.T) {
+	h := newTestHandler(t, "http://127.0.0.1:1")
+	metrics := h.MetricsAuthHandler(MetricsAuth{Token: "scrape-token"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer scrape-token")
+	rr := httptest.NewRecorder()
+	metrics.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the token admitted, got %d", rr.Code)
+	}
+
+	r.Header.Set("Authorization", "Bearer other")
+	rr = httptest.NewRecorder()
+	metrics.ServeHTTP(rr, r)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Header().Get(headerAuthenticate), `error="invalid_token"`) {
+		t.Errorf("expected 401 with an invalid_token challenge, got %d %q", rr.Code, rr.Header().Get(headerAuthenticate))
+	}
+	if got := testutil.ToFloat64(h.metrics.MetricsAuthFailures.WithLabelValues(authInvalid)); got != 1 {
+		t.Errorf("expected the failure counted, got %v", got)
+	}
+}
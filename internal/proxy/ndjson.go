@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ChunkStats accumulates response text and token counts over the chunks of
+// an Ollama generate/chat response. It is shared by the proxy and the bench
+// command so both account tokens identically.
+type ChunkStats struct {
+	PromptTokens     int64
+	CompletionTokens int64
+	// SawPrompt and SawCompletion report whether any chunk carried
+	// prompt_eval_count and eval_count respectively. Ollama only sends them
+	// on the final chunk, and on embed responses, which have no done field.
+	SawPrompt     bool
+	SawCompletion bool
+	Done          bool
+	// ContextTokens is the length of the "context" array of a generate
+	// response, 0 when absent (chat, raw mode, embeddings).
+	ContextTokens int64
+	// LoadDuration is the model load time Ollama reported, from the final
+	// chunk of a stream; TotalDuration, PromptEvalDuration and EvalDuration
+	// the rest of its timings. Each is 0 when Ollama sent none.
+	LoadDuration       time.Duration
+	TotalDuration      time.Duration
+	PromptEvalDuration time.Duration
+	EvalDuration       time.Duration
+	// ThinkingChars is the length in characters of the thinking output of
+	// reasoning models, which Text leaves out.
+	ThinkingChars int64
+
+	text strings.Builder
+}
+
+// Observe parses one JSON object (a stream line or a whole non-stream body)
+// and reports whether it was valid.
+func (s *ChunkStats) Observe(line []byte) bool {
+	var c ollamaChunk
+	if json.Unmarshal(line, &c) != nil {
+		return false
+	}
+	s.text.WriteString(responseText(c))
+	if c.Thinking != "" {
+		s.ThinkingChars += int64(utf8.RuneCountInString(c.Thinking))
+	}
+	if c.Message != nil && c.Message.Thinking != "" {
+		s.ThinkingChars += int64(utf8.RuneCountInString(c.Message.Thinking))
+	}
+	s.Done = s.Done || c.Done
+	keepDuration(&s.LoadDuration, c.LoadDuration)
+	keepDuration(&s.TotalDuration, c.TotalDuration)
+	keepDuration(&s.PromptEvalDuration, c.PromptEvalDuration)
+	keepDuration(&s.EvalDuration, c.EvalDuration)
+	if c.Context > 0 {
+		s.ContextTokens = int64(c.Context)
+	}
+	if c.PromptEvalCount != nil {
+		s.PromptTokens = *c.PromptEvalCount
+		s.SawPrompt = true
+	}
+	if c.EvalCount != nil {
+		s.CompletionTokens = *c.EvalCount
+		s.SawCompletion = true
+	}
+	if u := c.Usage; u != nil {
+		if u.PromptTokens != nil {
+			s.PromptTokens = *u.PromptTokens
+			s.SawPrompt = true
+		}
+		if u.CompletionTokens != nil {
+			s.CompletionTokens = *u.CompletionTokens
+			s.SawCompletion = true
+		}
+	}
+	return true
+}
+
+// Text returns the response text accumulated so far.
+func (s *ChunkStats) Text() string {
+	return s.text.String()
+}
+
+// keepDuration stores a duration in nanoseconds as Ollama reports them,
+// leaving d as it is when the field was absent or zero.
+func keepDuration(d *time.Duration, nanos int64) {
+	if nanos > 0 {
+		*d = time.Duration(nanos)
+	}
+}
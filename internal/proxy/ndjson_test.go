@@ -0,0 +1,35 @@
+package proxy
+
+import "testing"
+
+func TestChunkStats_Stream(t *testing.T) {
+	var s ChunkStats
+	for _, line := range []string{
+		`{"message":{"role":"assistant","content":"Hel"},"done":false}`,
+		`not json`,
+		`{"message":{"role":"assistant","content":"lo"},"done":false}`,
+		`{"done":true,"prompt_eval_count":4,"eval_count":2}`,
+	} {
+		s.Observe([]byte(line))
+	}
+	if s.Text() != "Hello" || !s.Done || s.PromptTokens != 4 || s.CompletionTokens != 2 {
+		t.Errorf("unexpected stats: text=%q %+v", s.Text(), s)
+	}
+}
+
+func TestChunkStats_EmbedHasNoDone(t *testing.T) {
+	var s ChunkStats
+	if !s.Observe([]byte(`{"embeddings":[[0.1]],"prompt_eval_count":3}`)) {
+		t.Fatal("expected embed response to parse")
+	}
+	if s.Done || !s.SawPrompt || s.PromptTokens != 3 || s.SawCompletion {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestChunkStats_InvalidJSON(t *testing.T) {
+	var s ChunkStats
+	if s.Observe([]byte("{\"done\":true}\n{\"done\":true}")) {
+		t.Error("expected multi-object input to be rejected")
+	}
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// missingModels remembers, per canonical model name, that the upstream said
+// a model does not exist, so a client retrying in a loop is answered locally
+// until NegativeCacheTTL has passed.
+type missingModels struct {
+	mu     sync.Mutex
+	byName map[string]missingModel
+}
+
+type missingModel struct {
+	message string // the upstream's error, relayed as is
+	expires time.Time
+}
+
+// get returns the upstream's error for key while it is remembered at now.
+// Expired entries are forgotten.
+func (m *missingModels) get(key string, now time.Time) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.byName[key]
+	if !ok {
+		return "", false
+	}
+	if !now.Before(e.expires) {
+		delete(m.byName, key)
+		return "", false
+	}
+	return e.message, true
+}
+
+func (m *missingModels) put(key, message string, expires time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.byName[key] = missingModel{message: message, expires: expires}
+}
+
+func (m *missingModels) forget(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.byName, key)
+}
+
+// modelNotFoundMessage returns the error of an Ollama "model not found"
+// response, such as {"error":"model \"x\" not found, try pulling it first"}.
+func modelNotFoundMessage(status int, body []byte) (string, bool) {
+	if status != http.StatusNotFound {
+		return "", false
+	}
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(bytes.TrimSpace(body), &e) != nil {
+		return "", false
+	}
+	lower := strings.ToLower(e.Error)
+	if !strings.Contains(lower, "model") || !strings.Contains(lower, "not found") {
+		return "", false
+	}
+	return e.Error, true
+}
+
+// negativeCacheable reports whether a request to endpoint may be answered
+// from, and teach, the missing-model cache: requests that use a model, not
+// those that create, copy or delete one.
+func negativeCacheable(endpoint string) bool {
+	return !takesNoModel(endpoint) && !mutatesModels(endpoint)
+}
+
+// observeNotFound counts an upstream "model not found" answer and remembers
+// it for NegativeCacheTTL.
+func (h *Handler) observeNotFound(ri *reqInfo, status int, body []byte) {
+	message, ok := modelNotFoundMessage(status, body)
+	if !ok || ri.model == modelUnknown || ri.model == modelNone {
+		return
+	}
+	h.metrics.ModelNotFound.WithLabelValues(originUpstream).Inc()
+	if h.cfg.NegativeCacheTTL <= 0 || !negativeCacheable(ri.endpoint) {
+		return
+	}
+	h.missing.put(canonicalModel(ri.model), message, time.Now().Add(h.cfg.NegativeCacheTTL))
+}
+
+// inspectMissingModel answers a request for a model the upstream recently
+// said does not exist with the same 404, without asking it again.
+func (h *Handler) inspectMissingModel(_ context.Context, req *ParsedRequest) error {
+	if h.cfg.NegativeCacheTTL <= 0 || req.Model == "" || !negativeCacheable(req.Endpoint) {
+		return nil
+	}
+	message, ok := h.missing.get(canonicalModel(req.Model), time.Now())
+	if !ok {
+		return nil
+	}
+	h.metrics.ModelNotFound.WithLabelValues(originProxy).Inc()
+	return &Rejection{
+		Reason:  reasonModelNotFound,
+		Status:  http.StatusNotFound,
+		Message: message,
+		Fields:  map[string]any{"model": req.Model},
+	}
+}
+
+// forgetMissingModel drops what is remembered about the model a pull,
+// create or copy is about to make exist, and about every model for requests
+// that do not name one.
+func (h *Handler) forgetMissingModel(endpoint string, p requestPayload) {
+	model := p.modelName()
+	if strings.HasSuffix(endpoint, "/api/copy") {
+		model = p.Destination
+	}
+	if model == "" {
+		h.missing.mu.Lock()
+		clear(h.missing.byName)
+		h.missing.mu.Unlock()
+		return
+	}
+	h.missing.forget(canonicalModel(model))
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const ghostError = `model "ghost" not found, try pulling it first`
+
+// ghostUpstream answers generate requests for "ghost" with Ollama's 404
+// until pulled is set, counting them; pulls succeed and set pulled.
+func ghostUpstream(t *testing.T, asked *atomic.Int32, pulled *atomic.Bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/pull" {
+			pulled.Store(true)
+			_, _ = fmt.Fprint(w, `{"status":"success"}`)
+			return
+		}
+		asked.Add(1)
+		if !pulled.Load() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprintf(w, `{"error":%q}`, ghostError)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func askGhost(h *Handler, stream bool) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate",
+		strings.NewReader(fmt.Sprintf(`{"model":"ghost","stream":%t}`, stream))))
+	return rr
+}
+
+func TestNegativeCache_AnswersRepeatsLocally(t *testing.T) {
+	var asked atomic.Int32
+	var pulled atomic.Bool
+	h := newTestHandlerWithConfig(t, ghostUpstream(t, &asked, &pulled).URL, Config{NegativeCacheTTL: time.Minute})
+
+	for i, stream := range []bool{true, false, true} {
+		rr := askGhost(h, stream)
+		var body map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusNotFound || body["error"] != ghostError {
+			t.Fatalf("request %d: expected the upstream's 404, got %d %q", i, rr.Code, rr.Body.String())
+		}
+	}
+	if asked.Load() != 1 {
+		t.Errorf("expected only the first request upstream, got %d", asked.Load())
+	}
+	if got := testutil.ToFloat64(h.metrics.ModelNotFound.WithLabelValues(originUpstream)); got != 1 {
+		t.Errorf("expected one upstream not-found, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.ModelNotFound.WithLabelValues(originProxy)); got != 2 {
+		t.Errorf("expected two answered locally, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonModelNotFound)); got != 2 {
+		t.Errorf("expected two model_not_found rejections, got %v", got)
+	}
+}
+
+func TestNegativeCache_PullForgetsModel(t *testing.T) {
+	var asked atomic.Int32
+	var pulled atomic.Bool
+	h := newTestHandlerWithConfig(t, ghostUpstream(t, &asked, &pulled).URL, Config{NegativeCacheTTL: time.Minute})
+	askGhost(h, false)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/pull", strings.NewReader(`{"model":"ghost:latest"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the pull forwarded, got %d", rr.Code)
+	}
+	if rr := askGhost(h, false); rr.Code != http.StatusOK {
+		t.Errorf("expected the pulled model served, got %d %q", rr.Code, rr.Body.String())
+	}
+	if asked.Load() != 2 {
+		t.Errorf("expected the request after the pull upstream, got %d upstream requests", asked.Load())
+	}
+}
+
+func TestNegativeCache_Expires(t *testing.T) {
+	var asked atomic.Int32
+	var pulled atomic.Bool
+	h := newTestHandlerWithConfig(t, ghostUpstream(t, &asked, &pulled).URL, Config{NegativeCacheTTL: 20 * time.Millisecond})
+	askGhost(h, false)
+	askGhost(h, false)
+	time.Sleep(30 * time.Millisecond)
+	askGhost(h, false)
+	if asked.Load() != 2 {
+		t.Errorf("expected the first and the post-expiry request upstream, got %d", asked.Load())
+	}
+}
+
+func TestNegativeCache_Disabled(t *testing.T) {
+	var asked atomic.Int32
+	var pulled atomic.Bool
+	h := newTestHandler(t, ghostUpstream(t, &asked, &pulled).URL)
+	askGhost(h, false)
+	askGhost(h, false)
+	if asked.Load() != 2 {
+		t.Errorf("expected every request upstream, got %d", asked.Load())
+	}
+	if got := testutil.ToFloat64(h.metrics.ModelNotFound.WithLabelValues(originUpstream)); got != 2 {
+		t.Errorf("expected upstream not-founds counted anyway, got %v", got)
+	}
+}
+
+func TestModelNotFoundMessage(t *testing.T) {
+	cases := []struct {
+		status int
+		body   string
+		ok     bool
+	}{
+		{404, `{"error":"model 'llama9' not found"}`, true},
+		{404, `{"error":"` + strings.ReplaceAll(ghostError, `"`, `\"`) + `"}` + "\n", true},
+		{404, `404 page not found`, false},
+		{404, `{"error":"blob not found"}`, false},
+		{500, `{"error":"model 'x' not found"}`, false},
+	}
+	for _, tc := range cases {
+		if _, ok := modelNotFoundMessage(tc.status, []byte(tc.body)); ok != tc.ok {
+			t.Errorf("%d %s: expected %v", tc.status, tc.body, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+)
+
+// modelUninspected is the model label of requests to NoInspectEndpoints,
+// whose body is never read for one.
+const modelUninspected = "uninspected"
+
+// uninspectedChunkBytes is the most of the response relayed per read.
+const uninspectedChunkBytes = 32 << 10
+
+// noInspect reports whether requests to endpoint are in NoInspectEndpoints.
+func (h *Handler) noInspect(endpoint string) bool {
+	return slices.Contains(h.cfg.NoInspectEndpoints, endpoint)
+}
+
+// countingReader counts the bytes read through it; the transport reads the
+// request body on its own goroutine.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// serveUninspected proxies a request to one of NoInspectEndpoints without
+// looking at its content: the client's body goes upstream as it arrives and
+// the response comes back the same way. Only the request line, headers,
+// sizes, status and timings are seen, so the request hooks, the payload
+// features and /debug/last-error are skipped and the record has no text.
+func (h *Handler) serveUninspected(w *clientWriter, r *http.Request, reqID string, start time.Time) {
+	endpoint := r.URL.Path // one of NoInspectEndpoints, so a safe label
+	ri := &reqInfo{
+		r:           r,
+		id:          reqID,
+		sessionID:   extractSessionID(r),
+		clientIP:    extractClientIP(r),
+		clientName:  clientName(r),
+		tenant:      h.tenantOf(r),
+		endpoint:    endpoint,
+		model:       modelUninspected,
+		modelLabel:  modelUninspected,
+		streamLabel: strconv.FormatBool(requestStreams(endpoint, nil)),
+		start:       start,
+		received:    start,                   // the body is read while forwarding
+		reqBytes:    max(r.ContentLength, 0), // for the tokens-per-minute estimate
+		client:      w,
+	}
+	ri.upstream = h.currentUpstream()
+	ri.upstreamLabel = upstreamLabel(ri.upstream)
+	defer h.trackInFlight(endpoint, ri.modelLabel, ri.streamLabel)()
+	defer h.countLegs(ri)
+	defer h.settleTPM(ri)
+	if h.cfg.MaxRequestBytes > 0 && r.ContentLength > h.cfg.MaxRequestBytes {
+		// Known to be over the limit, so never sent upstream.
+		h.forwardTooLarge(w, ri, "read body: Content-Length "+strconv.FormatInt(r.ContentLength, 10)+" over the limit")
+		return
+	}
+	if !h.rejectDraining(w, ri) || !h.admitUninspected(w, ri) {
+		return
+	}
+	defer h.beginRequest(ri.model)()
+	release := h.enqueue(w, ri)
+	if release == nil {
+		return
+	}
+	defer release()
+
+	body := &countingReader{r: r.Body}
+	up := h.upstreamEndpoint(ri.upstream, endpoint)
+	up.RawQuery = r.URL.RawQuery
+	upReq, err := http.NewRequestWithContext(r.Context(), r.Method, up.String(), body)
+	if err != nil {
+		h.upstreamFailed(w, ri, http.StatusInternalServerError, "failed to create upstream request", "create upstream req: "+err.Error())
+		return
+	}
+	upReq.ContentLength = r.ContentLength
+	if r.ContentLength == 0 {
+		upReq.Body = http.NoBody
+	}
+	copyEndToEnd(upReq.Header, r.Header)
+	h.setForwarded(upReq, r)
+	h.setUpstreamAuth(upReq.Header, ri.upstream)
+
+	ri.upstreamStart = time.Now()
+	resp, err := h.clientFor(endpoint).Do(upReq)
+	ri.upstreamTTFB = time.Since(ri.upstreamStart)
+	ri.reqBytes = body.n.Load()
+	if isBodyTooLarge(err) {
+		h.forwardTooLarge(w, ri, "read body: "+err.Error())
+		return
+	}
+	if clientGone(r.Context(), err) {
+		h.clientCanceled(w, ri, cancelHeaders, err)
+		return
+	}
+	if kind := upstreamTimeout(r.Context(), err); kind != "" {
+		h.upstreamTimedOut(w, ri, kind, err)
+		return
+	}
+	if err != nil {
+		h.badGateway(w, ri, "upstream: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	ri.forwarded = true
+	ri.streamLabel = strconv.FormatBool(responseStreams(resp.Header, requestStreams(endpoint, nil)))
+	h.countUpstreamLeg(ri, ri.reqBytes, resp)
+
+	copyEndToEnd(w.Header(), resp.Header)
+	h.setUpstreamHeaders(w.Header(), ri)
+	// The body goes through unchanged, so the upstream's length holds.
+	setContentLength(w.Header(), responseLength(r.Method, resp.StatusCode, resp.ContentLength, resp.ContentLength))
+	w.WriteHeader(resp.StatusCode)
+	rc := http.NewResponseController(w)
+	_ = rc.Flush()
+
+	buf := make([]byte, uninspectedChunkBytes)
+	var respBytes int64
+	var ttft time.Duration
+	errMsg := ""
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if ttft == 0 {
+				ttft = time.Since(ri.received)
+				h.observeTTFT(endpoint, ri.modelLabel, ttft)
+			}
+			respBytes += int64(n)
+			if _, err := w.Write(buf[:n]); err != nil {
+				errMsg = "write to client: " + err.Error()
+				break
+			}
+			_ = rc.Flush()
+		}
+		if readErr != nil {
+			ri.upstreamDone = time.Now()
+			if readErr != io.EOF {
+				errMsg = "read response: " + readErr.Error()
+			}
+			break
+		}
+	}
+
+	statusLabel, origin, attrs := strconv.Itoa(resp.StatusCode), originUpstream, append(h.logAttrs(ri), "inspected", false)
+	canceled := errMsg != "" && errors.Is(r.Context().Err(), context.Canceled)
+	switch {
+	case canceled && shutDown(r.Context()):
+		origin, statusLabel = originProxy, statusShutdown
+		attrs = append(attrs, "cancel_phase", cancelStream)
+	case canceled:
+		origin, statusLabel = originProxy, statusCanceled
+		attrs = append(attrs, "cancel_phase", cancelStream)
+		h.metrics.ClientCancellations.WithLabelValues(endpoint, cancelStream).Inc()
+	}
+	ri.reqBytes = body.n.Load()
+	failed := resp.StatusCode >= 500 || errMsg != ""
+	now := time.Now()
+	served := now.Sub(ri.received)
+	if ttft == 0 {
+		ttft = served
+	}
+	h.metrics.BytesIn.WithLabelValues(endpoint, ri.modelLabel, ri.streamLabel).Add(float64(ri.reqBytes))
+	h.metrics.ClientBytesIn.WithLabelValues(endpoint, ri.modelLabel, ri.streamLabel).Add(float64(ri.reqBytes))
+	h.metrics.BytesOut.WithLabelValues(endpoint, ri.modelLabel, ri.streamLabel).Add(float64(respBytes))
+	h.metrics.ReqTotal.WithLabelValues(endpoint, ri.modelLabel, statusLabel, ri.streamLabel, origin, ri.upstreamLabel, ri.clientName).Inc()
+	h.observeDuration(endpoint, ri.modelLabel, ri.streamLabel, ri.clientName, statusClass(statusLabel, errMsg), h.observePhases(ri, now), 0)
+	h.observeApdex(endpoint, ri.modelLabel, ttft, failed)
+	if !canceled {
+		h.observeSLO(ri, originUpstream, failed, served)
+	}
+	h.persistAndLog(r.Context(), db.RequestRecord{
+		RequestID:     reqID,
+		SessionID:     ri.sessionID,
+		Timestamp:     start,
+		Endpoint:      endpoint,
+		Method:        r.Method,
+		Model:         ri.model,
+		Stream:        ri.streamLabel == "true",
+		StatusCode:    resp.StatusCode,
+		DurationMS:    time.Since(start).Milliseconds(),
+		RequestBytes:  ri.reqBytes,
+		ResponseBytes: respBytes,
+		ErrorMessage:  errMsg,
+		ClientIP:      ri.clientIP,
+		UserAgent:     r.UserAgent(),
+		Upstream:      ri.upstreamLabel,
+	}, attrs...)
+}
+
+// admitUninspected applies the admission checks that need no content: the
+// read-only mode and the request rate, budget and tokens-per-minute limits.
+// It returns false after answering a rejection.
+func (h *Handler) admitUninspected(w http.ResponseWriter, ri *reqInfo) bool {
+	req := &ParsedRequest{
+		ID:             ri.id,
+		SessionID:      ri.sessionID,
+		ClientIP:       ri.clientIP,
+		Method:         ri.r.Method,
+		Endpoint:       ri.endpoint,
+		Model:          ri.model,
+		Header:         ri.r.Header,
+		Upstream:       ri.upstream,
+		ResponseHeader: w.Header(),
+		ri:             ri,
+	}
+	for _, check := range []RequestInspectorFunc{h.inspectReadOnly, h.inspectLimits} {
+		if err := check(ri.r.Context(), req); err != nil {
+			h.rejectHook(w, ri, err)
+			return false
+		}
+	}
+	return true
+}
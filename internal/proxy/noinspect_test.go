@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// countHooks registers request and forward hooks that count their calls.
+func countHooks(h *Handler) (inspected, forwarded *atomic.Int64) {
+	inspected, forwarded = &atomic.Int64{}, &atomic.Int64{}
+	h.Use(Hooks{
+		Inspectors: []RequestInspector{RequestInspectorFunc(func(context.Context, *ParsedRequest) error {
+			inspected.Add(1)
+			return nil
+		})},
+		Forwarders: []ForwardInspector{ForwardInspectorFunc(func(context.Context, *ParsedRequest, *http.Request) error {
+			forwarded.Add(1)
+			return nil
+		})},
+	})
+	return inspected, forwarded
+}
+
+func TestNoInspect_ForwardsWithoutParsing(t *testing.T) {
+	var got atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got.Store(string(body))
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = fmt.Fprintln(w, `{"response":"secret answer","done":true,"prompt_eval_count":3,"eval_count":2}`)
+	}))
+	defer upstream.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{NoInspectEndpoints: []string{"/api/generate"}})
+	inspected, forwarded := countHooks(h)
+
+	body := `{"model":"m","prompt":"secret prompt"}`
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "secret answer") {
+		t.Fatalf("expected the response relayed, got %d %q", rr.Code, rr.Body.String())
+	}
+	if got.Load() != body {
+		t.Errorf("expected the body forwarded unchanged, got %q", got.Load())
+	}
+	if inspected.Load() != 0 || forwarded.Load() != 0 {
+		t.Errorf("expected no request hooks to run, got %d inspections and %d forwards", inspected.Load(), forwarded.Load())
+	}
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", modelUninspected, "200", "true", originUpstream, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the request counted with model %s, got %v", modelUninspected, got)
+	}
+	if got := testutil.ToFloat64(h.metrics.BytesIn.WithLabelValues("/api/generate", modelUninspected, "true")); got != float64(len(body)) {
+		t.Errorf("expected the request size counted, got %v", got)
+	}
+	if got := testutil.CollectAndCount(h.metrics.TokensIn); got != 0 {
+		t.Errorf("expected no token counts read from the response, got %d series", got)
+	}
+	rows, _, err := h.store.ListRequests(10, 0, "", "")
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected one record, got %d (%v)", len(rows), err)
+	}
+	if rows[0].Model != modelUninspected || rows[0].PromptText != "" || rows[0].ResponseText != "" || rows[0].PromptTokens != 0 {
+		t.Errorf("expected a record without content, got %+v", rows[0])
+	}
+
+	other := httptest.NewRecorder()
+	h.ServeHTTP(other, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"m"}`)))
+	if inspected.Load() != 1 || forwarded.Load() != 1 {
+		t.Errorf("expected endpoints not listed inspected as usual, got %d inspections and %d forwards", inspected.Load(), forwarded.Load())
+	}
+}
+
+func TestNoInspect_StreamsRequestBody(t *testing.T) {
+	firstPart := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(r.Body, buf); err == nil {
+			close(firstPart) // while the client has not finished sending
+		}
+		rest, _ := io.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, `{"read":%d}`, len(buf)+len(rest))
+	}))
+	defer upstream.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{NoInspectEndpoints: []string{"/api/embed"}})
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = io.WriteString(pw, "first")
+		<-firstPart
+		_, _ = io.WriteString(pw, " and the rest")
+		_ = pw.Close()
+	}()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/embed", pr))
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"read":18}` {
+		t.Fatalf("expected the body streamed through, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestNoInspect_KeepsContentOutOfLastError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"bad prompt: secret prompt"}`, http.StatusBadRequest)
+	}))
+	defer upstream.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{NoInspectEndpoints: []string{"/api/generate"}})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"prompt":"secret prompt"}`)))
+	if got := h.lastErrors.list(""); len(got) != 0 {
+		t.Errorf("expected no response body kept for /debug/last-error, got %+v", got)
+	}
+}
+
+func TestNoInspect_AdmissionStillApplies(t *testing.T) {
+	upstream := tokenUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ReadOnly: true, NoInspectEndpoints: []string{"/api/pull"}})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/pull", strings.NewReader(`{"model":"m"}`)))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected the read-only mode to refuse the pull, got %d", rr.Code)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonReadOnly)); got != 1 {
+		t.Errorf("expected the rejection counted, got %v", got)
+	}
+}
+
+func TestNoInspect_MaxRequestBytes(t *testing.T) {
+	var forwarded atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = fmt.Fprint(w, `{"embeddings":[]}`)
+	}))
+	defer upstream.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{NoInspectEndpoints: []string{"/api/embed"}, MaxRequestBytes: 1 << 10})
+	big := strings.Repeat("A", 2<<10)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(big)))
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "1 KiB") {
+		t.Fatalf("expected 413 naming the limit for a known length, got %d %q", rr.Code, rr.Body.String())
+	}
+	if forwarded.Load() != 0 {
+		t.Errorf("expected a body known to be too large not forwarded, upstream saw %d", forwarded.Load())
+	}
+
+	// Without a Content-Length the limit trips while the body streams up.
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = io.WriteString(pw, big)
+		_ = pw.Close()
+	}()
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/embed", pr))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for a streamed body over the limit, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	if got := testutil.ToFloat64(h.metrics.RequestsRejected.WithLabelValues(rejectBodyTooLarge)); got != 2 {
+		t.Errorf("expected two body_too_large rejections, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/embed", modelUninspected, "413", "false", originProxy, upstreamLabel(h.currentUpstream()), "")); got != 2 {
+		t.Errorf("expected the 413s in requests_total, got %v", got)
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// errorTypeNonStreamTimeout is the error_type logged for non-streaming
+	// requests that ran past NonStreamTimeout.
+	errorTypeNonStreamTimeout = "nonstream_timeout"
+
+	// statusTimeout is the status label of those requests, so they stay
+	// apart from the upstream's own 504s and from header timeouts.
+	statusTimeout = "timeout"
+)
+
+// upstreamContext returns the context of the upstream request: the client's,
+// bounded by NonStreamTimeout unless the request streams.
+func (h *Handler) upstreamContext(ctx context.Context, stream bool) (context.Context, context.CancelFunc) {
+	if stream || h.cfg.NonStreamTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, h.cfg.NonStreamTimeout)
+}
+
+// nonStreamTimedOut reports whether err is upCtx's NonStreamTimeout expiring
+// while the client was still waiting.
+func (h *Handler) nonStreamTimedOut(client, upCtx context.Context, err error) bool {
+	if err == nil || h.cfg.NonStreamTimeout <= 0 || client.Err() != nil {
+		return false
+	}
+	// Not errors.Is(err, context.DeadlineExceeded): the transport's
+	// response header timeout matches that too.
+	return errors.Is(upCtx.Err(), context.DeadlineExceeded)
+}
+
+// nonStreamTimeout answers 504 with a JSON error. The upstream headers
+// copied for a body that never finished are dropped first.
+func (h *Handler) nonStreamTimeout(w http.ResponseWriter, ri *reqInfo, upstream http.Header, errMsg string) {
+	h.metrics.ReqTotal.WithLabelValues(ri.endpoint, ri.modelLabel, statusTimeout, ri.streamLabel, originProxy, ri.upstreamLabel, ri.clientName).Inc()
+	h.metrics.UpstreamTimeouts.WithLabelValues(ri.endpoint, timeoutNonStream).Inc()
+	h.observeDuration(ri.endpoint, ri.modelLabel, ri.streamLabel, ri.clientName, statusClassError, time.Since(ri.received), 0)
+	h.observeApdex(ri.endpoint, ri.modelLabel, time.Since(ri.received), true)
+	h.observeSLO(ri, originProxy, true, time.Since(ri.received))
+	for k := range upstream {
+		w.Header().Del(k)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": fmt.Sprintf("upstream did not complete the request within %s", h.cfg.NonStreamTimeout),
+	})
+	h.recordLastError(ri, http.StatusGatewayTimeout, []byte(errMsg), "proxy")
+	h.recordFailure(ri, http.StatusGatewayTimeout, errMsg, "error_type", errorTypeNonStreamTimeout)
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNonStreamTimeout_HeadersNeverArrive(t *testing.T) {
+	hang := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(hang)
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{NonStreamTimeout: 20 * time.Millisecond})
+	rr := generate(h, "10.0.0.1")
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rr.Code)
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.Error == "" {
+		t.Errorf("expected a JSON error, got %q", rr.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", statusTimeout, "false", originProxy, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the timeout counted, got %v", got)
+	}
+}
+
+func TestNonStreamTimeout_CoversBodyRead(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, `{"models":[`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{NonStreamTimeout: 30 * time.Millisecond})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504 for a body that never finished, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+}
+
+func TestNonStreamTimeout_StreamsExempt(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		w.(http.Flusher).Flush()
+		time.Sleep(80 * time.Millisecond) // well past the timeout
+		_, _ = fmt.Fprintln(w, `{"response":"b","done":true,"eval_count":2}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{NonStreamTimeout: 20 * time.Millisecond})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`)))
+	sc := bufio.NewScanner(rr.Body)
+	lines := 0
+	for sc.Scan() {
+		lines++
+	}
+	if rr.Code != http.StatusOK || lines != 2 {
+		t.Errorf("expected the full stream, got %d with %d lines", rr.Code, lines)
+	}
+}
+
+func TestNonStreamTimeout_AppliesToCachedFetch(t *testing.T) {
+	hang := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(hang)
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{NonStreamTimeout: 20 * time.Millisecond, MetadataCacheTTL: time.Minute})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504 from a shared fetch, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// oomPeekBytes is how much of an upstream error body is read to tell
+	// whether it is an out-of-memory error; the rest is relayed unread.
+	oomPeekBytes = 4 << 10
+
+	errorTypeUpstreamOOM = "upstream_oom"
+	oomMessage           = "model requires more memory than available"
+)
+
+// oomPatterns are lower-case fragments of the errors Ollama and its runners
+// return when a model does not fit in GPU or system memory.
+var oomPatterns = [][]byte{
+	[]byte("out of memory"),
+	[]byte("requires more system memory"),
+	[]byte("insufficient memory"),
+	[]byte("cudamalloc failed"),
+	[]byte("unable to allocate"),
+	[]byte("failed to allocate"),
+}
+
+func isOOMError(body []byte) bool {
+	lower := bytes.ToLower(body)
+	for _, p := range oomPatterns {
+		if bytes.Contains(lower, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// oomCooldowns holds, per canonical model name, until when requests fail
+// fast after an out-of-memory error.
+type oomCooldowns struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// open starts or extends key's cooldown to until.
+func (c *oomCooldowns) open(key string, until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.until[key] = until
+}
+
+// active returns when key's cooldown ends, if it is still running at now.
+// Expired cooldowns are forgotten.
+func (c *oomCooldowns) active(key string, now time.Time) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, ok := c.until[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !now.Before(until) {
+		delete(c.until, key)
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// inspectOOMCooldown fails a request fast with 503 while its model is
+// cooling down after an out-of-memory error.
+func (h *Handler) inspectOOMCooldown(_ context.Context, req *ParsedRequest) error {
+	if h.cfg.OOMCooldown <= 0 {
+		return nil
+	}
+	until, ok := h.oom.active(canonicalModel(req.Model), time.Now())
+	if !ok {
+		return nil
+	}
+	return &Rejection{
+		Reason:  reasonOOMCooldown,
+		Status:  http.StatusServiceUnavailable,
+		Message: oomMessage,
+		RetryAt: until,
+		Fields:  map[string]any{"model": req.Model},
+	}
+}
+
+// interceptOOM answers an upstream out-of-memory error with 503 and
+// Retry-After instead of relaying Ollama's 500, and opens the model's
+// cooldown. It reports whether it answered; otherwise resp is relayed as
+// usual, the peeked bytes included.
+func (h *Handler) interceptOOM(w http.ResponseWriter, ri *reqInfo, resp *http.Response) bool {
+	if h.cfg.OOMCooldown <= 0 || resp.StatusCode < 500 || resp.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, oomPeekBytes))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	if !isOOMError(head) {
+		return false
+	}
+
+	until := time.Now().Add(h.cfg.OOMCooldown)
+	key := canonicalModel(ri.model)
+	h.oom.open(key, until)
+	h.metrics.OOMEvents.WithLabelValues(ri.modelLabel).Inc()
+	h.metrics.OOMCooldown.WithLabelValues(ri.modelLabel).Set(1)
+	time.AfterFunc(h.cfg.OOMCooldown, func() {
+		if _, ok := h.oom.active(key, time.Now()); !ok {
+			h.metrics.OOMCooldown.WithLabelValues(ri.modelLabel).Set(0)
+		}
+	})
+
+	secs := int(math.Ceil(h.cfg.OOMCooldown.Seconds()))
+	w.Header().Set(headerRetryAfter, strconv.Itoa(secs))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":               oomMessage,
+		"reason":              errorTypeUpstreamOOM,
+		"model":               ri.model,
+		"retry_after_seconds": secs,
+	})
+	h.countProxyStatus(ri, http.StatusServiceUnavailable)
+	h.observeDuration(ri.endpoint, ri.modelLabel, ri.streamLabel, ri.clientName, statusClass5xx, time.Since(ri.received), 0)
+	h.observeApdex(ri.endpoint, ri.modelLabel, time.Since(ri.received), true)
+	h.recordLastError(ri, resp.StatusCode, head, "upstream")
+	h.recordFailure(ri, http.StatusServiceUnavailable, "upstream out of memory: "+string(bytes.TrimSpace(head)),
+		"error_type", errorTypeUpstreamOOM)
+	return true
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func oomUpstream(t *testing.T, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprint(w, `{"error":"model requires more system memory (12.3 GiB) than is available (7.9 GiB)"}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOOM_TranslatedAndCooledDown(t *testing.T) {
+	var hits atomic.Int32
+	h := newTestHandlerWithConfig(t, oomUpstream(t, &hits).URL, Config{OOMCooldown: time.Minute})
+
+	rr := generate(h, "10.0.0.1")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected 503 with Retry-After 60, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(rr.Body.String(), oomMessage) {
+		t.Errorf("expected a clear message, got %s", rr.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.OOMEvents.WithLabelValues("m")); got != 1 {
+		t.Errorf("expected one OOM event, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.OOMCooldown.WithLabelValues("m")); got != 1 {
+		t.Errorf("expected the cooldown gauge set, got %v", got)
+	}
+
+	rr = generate(h, "10.0.0.1")
+	if rr.Code != http.StatusServiceUnavailable || hits.Load() != 1 {
+		t.Fatalf("expected a fast 503 without reaching the upstream, got %d after %d upstream hits", rr.Code, hits.Load())
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonOOMCooldown)); got != 1 {
+		t.Errorf("expected one oom_cooldown rejection, got %v", got)
+	}
+}
+
+func TestOOM_CooldownExpires(t *testing.T) {
+	var hits atomic.Int32
+	h := newTestHandlerWithConfig(t, oomUpstream(t, &hits).URL, Config{OOMCooldown: 30 * time.Millisecond})
+	generate(h, "10.0.0.1")
+	time.Sleep(60 * time.Millisecond)
+	if got := testutil.ToFloat64(h.metrics.OOMCooldown.WithLabelValues("m")); got != 0 {
+		t.Errorf("expected the cooldown gauge cleared, got %v", got)
+	}
+	generate(h, "10.0.0.1")
+	if hits.Load() != 2 {
+		t.Errorf("expected the upstream tried again after the cooldown, got %d hits", hits.Load())
+	}
+}
+
+func TestOOM_OtherErrorsRelayed(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprint(w, `{"error":"unexpected EOF"}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{OOMCooldown: time.Minute})
+	rr := generate(h, "10.0.0.1")
+	if rr.Code != http.StatusInternalServerError || rr.Body.String() != `{"error":"unexpected EOF"}` {
+		t.Errorf("expected the upstream error relayed intact, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOOM_OffByDefault(t *testing.T) {
+	var hits atomic.Int32
+	h := newTestHandler(t, oomUpstream(t, &hits).URL)
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected the 500 relayed, got %d", rr.Code)
+	}
+}
+
+func TestIsOOMError(t *testing.T) {
+	for body, want := range map[string]bool{
+		`{"error":"llama runner process has terminated: cudaMalloc failed: out of memory"}`: true,
+		`{"error":"CUDA error: out of memory"}`:                                             true,
+		`{"error":"model not found"}`:                                                       false,
+	} {
+		if got := isOOMError([]byte(body)); got != want {
+			t.Errorf("%s: got %v", body, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import "bytes"
+
+// Ollama's OpenAI-compatible API lives under /v1: chat/completions,
+// completions, embeddings and models. Requests name the model and stream the
+// way native ones do; responses differ.
+
+// openAIChoice is one choice of a /v1 chat or completion response: message
+// on a whole response, delta on a stream chunk, text for /v1/completions.
+type openAIChoice struct {
+	Text    string       `json:"text,omitempty"`
+	Message *chatMessage `json:"message,omitempty"`
+	Delta   *chatMessage `json:"delta,omitempty"`
+}
+
+// openAIUsage is the usage block of a /v1 response. Streams carry it on a
+// final chunk only when the request set stream_options.include_usage.
+type openAIUsage struct {
+	PromptTokens     *int64 `json:"prompt_tokens,omitempty"`
+	CompletionTokens *int64 `json:"completion_tokens,omitempty"`
+}
+
+// choicesText returns the text of the first choice, the one clients show.
+func choicesText(choices []openAIChoice) string {
+	if len(choices) == 0 {
+		return ""
+	}
+	c := choices[0]
+	switch {
+	case c.Delta != nil:
+		return c.Delta.Content
+	case c.Message != nil:
+		return c.Message.Content
+	}
+	return c.Text
+}
+
+var (
+	sseDataPrefix = []byte("data:")
+	sseDone       = []byte("[DONE]")
+)
+
+// sseData strips the "data:" field name off a line of a server-sent event
+// stream; other lines are returned as they are.
+func sseData(line []byte) []byte {
+	if data, ok := bytes.CutPrefix(line, sseDataPrefix); ok {
+		return bytes.TrimLeft(data, " ")
+	}
+	return line
+}
+
+// observeLine is Observe for one line of a stream, NDJSON or the
+// "data: {...}" events of a /v1 stream ending in "data: [DONE]".
+func (s *ChunkStats) observeLine(line []byte) bool {
+	data := sseData(line)
+	if bytes.Equal(bytes.TrimSpace(data), sseDone) {
+		s.Done = true
+		return true
+	}
+	return s.Observe(data)
+}
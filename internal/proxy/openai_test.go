@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// openAIUpstream answers /v1 requests with a whole chat completion, or with
+// an event stream that carries usage only when the request asked for it.
+func openAIUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		req := string(b)
+		if !strings.Contains(req, `"stream":true`) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"llama3",`+
+				`"choices":[{"index":0,"message":{"role":"assistant","content":"Hello there"},"finish_reason":"stop"}],`+
+				`"usage":{"prompt_tokens":12,"completion_tokens":5,"total_tokens":17}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range []string{"Hello", " there"} {
+			_, _ = fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", word)
+			w.(http.Flusher).Flush()
+		}
+		if strings.Contains(req, `"include_usage":true`) {
+			_, _ = fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":2}}\n\n")
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func chatCompletion(h *Handler, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	return rr
+}
+
+func TestOpenAI_NonStreamUsage(t *testing.T) {
+	h := newTestHandler(t, openAIUpstream(t).URL)
+	rr := chatCompletion(h, `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Hello there") {
+		t.Fatalf("expected the completion relayed, got %d %q", rr.Code, rr.Body.String())
+	}
+	const ep = "/v1/chat/completions"
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues(ep, "llama3", "200", "false", originUpstream, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the request counted under its /v1 endpoint and not streamed, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.TokensIn.WithLabelValues(ep, "llama3", upstreamLabel(h.currentUpstream()), "")); got != 12 {
+		t.Errorf("expected usage.prompt_tokens as prompt tokens, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues(ep, "llama3", upstreamLabel(h.currentUpstream()), "")); got != 5 {
+		t.Errorf("expected usage.completion_tokens as completion tokens, got %v", got)
+	}
+}
+
+func TestOpenAI_StreamUsage(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		body         string
+		prompt, comp float64
+	}{
+		{"include_usage", `{"model":"llama3","stream":true,"stream_options":{"include_usage":true},"messages":[]}`, 12, 2},
+		{"no usage", `{"model":"llama3","stream":true,"messages":[]}`, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHandler(t, openAIUpstream(t).URL)
+			rr := chatCompletion(h, tc.body)
+
+			if !strings.HasSuffix(rr.Body.String(), "data: [DONE]\n\n") {
+				t.Fatalf("expected the event stream relayed, got %q", rr.Body.String())
+			}
+			const ep = "/v1/chat/completions"
+			up := upstreamLabel(h.currentUpstream())
+			if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues(ep, "llama3", "200", "true", originUpstream, up, "")); got != 1 {
+				t.Errorf("expected the request counted as streamed, got %v", got)
+			}
+			if got := testutil.ToFloat64(h.metrics.TokensIn.WithLabelValues(ep, "llama3", up, "")); got != tc.prompt {
+				t.Errorf("expected %v prompt tokens, got %v", tc.prompt, got)
+			}
+			if got := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues(ep, "llama3", up, "")); got != tc.comp {
+				t.Errorf("expected %v completion tokens, got %v", tc.comp, got)
+			}
+			if got := testutil.ToFloat64(h.metrics.MalformedChunks.WithLabelValues(ep, "llama3")); got != 0 {
+				t.Errorf("expected event lines not counted as malformed, got %v", got)
+			}
+		})
+	}
+}
+
+func TestChunkStats_ObserveLineEvents(t *testing.T) {
+	var s ChunkStats
+	for _, line := range []string{
+		`data: {"choices":[{"delta":{"content":"Hi"}}]}`,
+		`data:{"choices":[{"delta":{"content":"!"}}]}`,
+		`data: [DONE]`,
+	} {
+		if !s.observeLine([]byte(line)) {
+			t.Errorf("expected %q valid", line)
+		}
+	}
+	if s.Text() != "Hi!" || !s.Done {
+		t.Errorf("expected text %q and done, got %q done=%t", "Hi!", s.Text(), s.Done)
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// splitModelRef splits a model reference into its repository and tag,
+// ignoring a registry host and port and a digest; tag is "" when there is
+// none. digest reports whether the reference ends in "@<algorithm>:<hex>".
+func splitModelRef(ref string) (repo, tag string, digest bool) {
+	if at := strings.LastIndexByte(ref, '@'); at >= 0 {
+		alg, hex, ok := strings.Cut(ref[at+1:], ":")
+		digest = ok && alg != "" && hex != ""
+		ref = ref[:at]
+	}
+	// A colon in the last path element starts the tag; one before it is a
+	// registry port.
+	slash := strings.LastIndexByte(ref, '/')
+	if colon := strings.LastIndexByte(ref, ':'); colon > slash {
+		ref, tag = ref[:colon], ref[colon+1:]
+	}
+	return ref, tag, digest
+}
+
+// isPinned reports whether ref names an immutable model: one with a digest,
+// or with a tag other than latest.
+func isPinned(ref string) bool {
+	_, tag, digest := splitModelRef(ref)
+	return digest || (tag != "" && !strings.EqualFold(tag, "latest"))
+}
+
+// modelRepo is ref's repository in the form it is matched against
+// PinnedModelsExempt: lower case, without Ollama's default registry and
+// namespace, so that llama3 and registry.ollama.ai/library/llama3 match.
+func modelRepo(ref string) string {
+	repo, _, _ := splitModelRef(strings.ToLower(ref))
+	return strings.TrimPrefix(strings.TrimPrefix(repo, "registry.ollama.ai/"), "library/")
+}
+
+// inspectPinned refuses requests for a model without a tag, or tagged
+// latest, with 400 when RequirePinnedModels is set, unless the model is
+// exempt.
+func (h *Handler) inspectPinned(_ context.Context, req *ParsedRequest) error {
+	ref := req.payload.modelName()
+	if !h.cfg.RequirePinnedModels || ref == "" || takesNoModel(req.Endpoint) || isPinned(ref) {
+		return nil
+	}
+	if _, ok := h.pinnedExempt[modelRepo(ref)]; ok {
+		return nil
+	}
+	return &Rejection{
+		Reason: reasonModelUnpinned,
+		Status: http.StatusBadRequest,
+		Message: "model " + ref + " is not pinned: requests must name an explicit tag other than latest, " +
+			"or a digest, for reproducible results",
+		Fields: map[string]any{"model": ref, "policy": "require_pinned_models"},
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIsPinned(t *testing.T) {
+	for _, tc := range []struct {
+		ref    string
+		pinned bool
+	}{
+		{"llama3", false},
+		{"llama3:latest", false},
+		{"llama3:LATEST", false},
+		{"llama3:", false},
+		{"llama3:8b", true},
+		{"llama3@sha256:1a2b3c", true},
+		{"llama3:latest@sha256:1a2b3c", true},
+		{"llama3@", false},
+		{"registry.example.com:5000/team/llama3", false},
+		{"registry.example.com:5000/team/llama3:latest", false},
+		{"registry.example.com:5000/team/llama3:8b-q4", true},
+		{"hf.co/bartowski/Llama-3.2-1B-Instruct-GGUF:Q4_K_M", true},
+		{"hf.co/bartowski/Llama-3.2-1B-Instruct-GGUF", false},
+	} {
+		if got := isPinned(tc.ref); got != tc.pinned {
+			t.Errorf("%s: expected pinned %t, got %t", tc.ref, tc.pinned, got)
+		}
+	}
+	for _, ref := range []string{"llama3:latest", "Llama3", "registry.ollama.ai/library/llama3:8b", "llama3@sha256:1a2b"} {
+		if got := modelRepo(ref); got != "llama3" {
+			t.Errorf("%s: expected repository llama3, got %q", ref, got)
+		}
+	}
+	if got := modelRepo("registry.example.com:5000/team/llama3:8b"); got != "registry.example.com:5000/team/llama3" {
+		t.Errorf("expected another registry kept, got %q", got)
+	}
+}
+
+func TestRequirePinnedModels(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{
+		RequirePinnedModels: true,
+		PinnedModelsExempt:  []string{"scratch"},
+	})
+	for _, tc := range []struct {
+		model string
+		code  int
+	}{
+		{"llama3", http.StatusBadRequest},
+		{"llama3:latest", http.StatusBadRequest},
+		{"registry.example.com:5000/team/llama3", http.StatusBadRequest},
+		{"llama3:8b", http.StatusOK},
+		{"llama3@sha256:1a2b3c", http.StatusOK},
+		{"scratch", http.StatusOK},
+		{"registry.ollama.ai/library/scratch:latest", http.StatusOK},
+	} {
+		if code := generateModel(h, tc.model); code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.model, tc.code, code)
+		}
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonModelUnpinned)); got != 3 {
+		t.Errorf("expected 3 model_unpinned rejections, got %v", got)
+	}
+
+	// Pulls name the model in the legacy "name" field too.
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/pull", strings.NewReader(`{"name":"llama3"}`)))
+	var body map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusBadRequest || body["reason"] != reasonModelUnpinned || body["model"] != "llama3" ||
+		!strings.Contains(body["error"].(string), "not pinned") {
+		t.Errorf("expected a 400 explaining the policy, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected endpoints without a model unaffected, got %d", rr.Code)
+	}
+
+	h = newTestHandler(t, tokenUpstream(t).URL)
+	if code := generateModel(h, "llama3"); code != http.StatusOK {
+		t.Errorf("expected unpinned models allowed by default, got %d", code)
+	}
+}
@@ -0,0 +1,36 @@
+package proxy
+
+// Reasons for ollama_proxy_policy_rejections_total and
+// ollama_proxy_policy_modifications_total. Every policy feature reports
+// through this vocabulary so one pair of counters shows how often policies
+// fire; add new reasons here and to policyReasonsHelp.
+const (
+	reasonNumPredictClamp   = "num_predict_clamp"   // modification: options.num_predict lowered
+	reasonKeepAliveOverride = "keep_alive_override" // modification: keep_alive replaced
+	reasonModelDenied       = "model_denied"        // rejection: model not allowed
+	reasonPromptTooLarge    = "prompt_too_large"    // rejection: request body or prompt over the limit
+	reasonRateLimited       = "rate_limited"        // rejection: -rate-limit exceeded
+	reasonQuotaExhausted    = "quota_exhausted"     // rejection: -token-budget used up
+	reasonQueueTimeout      = "queue_timeout"       // rejection: waited -queue-timeout for a slot
+	reasonTPMLimited        = "tpm_limited"         // rejection: -tpm-limit exceeded
+	reasonMaintenance       = "model_maintenance"   // rejection: model put in maintenance via /admin/models
+	reasonOOMCooldown       = "oom_cooldown"        // rejection: model ran out of memory moments ago
+	reasonContextOverflow   = "context_overflow"    // rejection: estimated prompt over the context window
+	reasonHookRejected      = "hook_rejected"       // rejection: a registered RequestInspector refused it
+	reasonModelNotFound     = "model_not_found"     // rejection: the upstream said the model does not exist moments ago
+	reasonReadOnly          = "read_only"           // rejection: -read-only refuses pulls, pushes and model changes
+	reasonShuttingDown      = "shutting_down"       // rejection: arrived while the proxy drains for shutdown
+	reasonTenantConcurrency = "tenant_concurrency"  // rejection: the tenant has -max-concurrent-per-tenant requests active
+	reasonModelUnpinned     = "model_unpinned"      // rejection: -require-pinned-models refuses an untagged or latest model
+)
+
+const policyReasonsHelp = " Reasons: num_predict_clamp, keep_alive_override (modifications); " +
+	"model_denied, prompt_too_large, rate_limited, quota_exhausted, queue_timeout, tpm_limited, " +
+	"model_maintenance, oom_cooldown, context_overflow, hook_rejected, model_not_found, read_only, shutting_down, tenant_concurrency, model_unpinned (rejections)."
+
+// rejectionReasons and modificationReasons are pre-initialised to zero so
+// dashboards and alerts see every series before the first policy fires.
+var (
+	rejectionReasons    = []string{reasonModelDenied, reasonPromptTooLarge, reasonRateLimited, reasonQuotaExhausted, reasonQueueTimeout, reasonTPMLimited, reasonMaintenance, reasonOOMCooldown, reasonContextOverflow, reasonHookRejected, reasonModelNotFound, reasonReadOnly, reasonShuttingDown, reasonTenantConcurrency, reasonModelUnpinned}
+	modificationReasons = []string{reasonNumPredictClamp, reasonKeepAliveOverride}
+)
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPolicyCounters_PreInitialised(t *testing.T) {
+	h := newTestHandler(t, "http://127.0.0.1:1")
+	if n := testutil.CollectAndCount(h.metrics.PolicyRejections); n != len(rejectionReasons) {
+		t.Errorf("expected %d rejection series, got %d", len(rejectionReasons), n)
+	}
+	if n := testutil.CollectAndCount(h.metrics.PolicyModifications); n != len(modificationReasons) {
+		t.Errorf("expected %d modification series, got %d", len(modificationReasons), n)
+	}
+}
+
+func TestPolicyRejections_LimitsReportReason(t *testing.T) {
+	upstream := tokenUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{RateLimit: 1, RateLimitWindow: time.Hour})
+	generate(h, "10.0.0.1")
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected rate limit, got %d", rr.Code)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonRateLimited)); got != 1 {
+		t.Errorf("expected 1 rate_limited rejection, got %v", got)
+	}
+
+	h2 := newTestHandlerWithConfig(t, upstream.URL, Config{TokenBudget: 50, QuotaFlushInterval: time.Hour})
+	generate(h2, "10.0.0.1") // 100 tokens, over the budget for next time
+	generate(h2, "10.0.0.1")
+	if got := testutil.ToFloat64(h2.metrics.PolicyRejections.WithLabelValues(reasonQuotaExhausted)); got != 1 {
+		t.Errorf("expected 1 quota_exhausted rejection, got %v", got)
+	}
+}
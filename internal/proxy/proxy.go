@@ -5,21 +5,30 @@ package proxy
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/kv"
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
 )
 
 // requestPayload is the minimal incoming JSON shape we care about.
@@ -29,20 +38,56 @@ type requestPayload struct {
 	Prompt   string          `json:"prompt,omitempty"`   // /api/generate
 	Messages []chatMessage   `json:"messages,omitempty"` // /api/chat
 	Input    json.RawMessage `json:"input,omitempty"`    // /api/embed: string or []string
+
+	Name        string `json:"name,omitempty"`        // pre-"model" spelling used by older clients
+	Verbose     *bool  `json:"verbose,omitempty"`     // /api/show
+	Destination string `json:"destination,omitempty"` // /api/copy
+
+	Context contextLen   `json:"context,omitempty"` // /api/generate conversation state
+	Think   thinkOption  `json:"think,omitempty"`   // generate/chat reasoning switch
+	System  string       `json:"system,omitempty"`  // /api/generate system prompt
+	NumCtx  numCtxOption `json:"options,omitempty"` // options.num_ctx
+
+	KeepAlive json.RawMessage `json:"keep_alive,omitempty"` // number of seconds or duration string
+
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"` // /v1 streams
+}
+
+// modelName returns the model a request refers to, accepting the legacy
+// "name" field used by older clients for /api/show, /api/pull and friends.
+func (p requestPayload) modelName() string {
+	if p.Model != "" {
+		return p.Model
+	}
+	return p.Name
 }
 
 type chatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role     string `json:"role"`
+	Content  string `json:"content"`
+	Thinking string `json:"thinking,omitempty"` // reasoning of thinking models
 }
 
 // ollamaChunk covers both final non-stream responses and every streaming chunk.
 type ollamaChunk struct {
 	Done            bool         `json:"done"`
-	Response        string       `json:"response,omitempty"`  // /api/generate
-	Message         *chatMessage `json:"message,omitempty"`   // /api/chat
+	Response        string       `json:"response,omitempty"` // /api/generate
+	Thinking        string       `json:"thinking,omitempty"` // /api/generate reasoning
+	Message         *chatMessage `json:"message,omitempty"`  // /api/chat
 	EvalCount       *int64       `json:"eval_count,omitempty"`
 	PromptEvalCount *int64       `json:"prompt_eval_count,omitempty"`
+	Context         contextLen   `json:"context,omitempty"` // /api/generate final chunk
+
+	// Durations of the final object, in nanoseconds.
+	TotalDuration      int64 `json:"total_duration,omitempty"`
+	LoadDuration       int64 `json:"load_duration,omitempty"` // spent loading the model
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"`
+	EvalDuration       int64 `json:"eval_duration,omitempty"`
+
+	Choices []openAIChoice `json:"choices,omitempty"` // /v1 chat and completions
+	Usage   *openAIUsage   `json:"usage,omitempty"`   // /v1 token counts
 }
 
 // extractPromptText returns the user-facing prompt from the parsed request.
@@ -78,93 +123,1512 @@ func responseText(c ollamaChunk) string {
 	if c.Message != nil {
 		return c.Message.Content
 	}
-	return ""
+	return choicesText(c.Choices)
 }
 
 // Metrics bundles all Prometheus counters/histograms for the proxy.
 type Metrics struct {
 	ReqTotal    *prometheus.CounterVec
 	ReqDuration *prometheus.HistogramVec
-	BytesIn     *prometheus.CounterVec
-	BytesOut    *prometheus.CounterVec
-	TokensIn    *prometheus.CounterVec
-	TokensOut   *prometheus.CounterVec
+	// ReqDurationAdjusted is ReqDuration minus the upstream's load_duration.
+	ReqDurationAdjusted *prometheus.HistogramVec
+	BytesIn             *prometheus.CounterVec
+	BytesOut            *prometheus.CounterVec
+	TokensIn            *prometheus.CounterVec
+	TokensOut           *prometheus.CounterVec
+	TokenCost           *prometheus.CounterVec
+	Apdex               *prometheus.CounterVec
+	GzipSaved           *prometheus.CounterVec
+
+	DecompressErrors *prometheus.CounterVec
+	CacheRequests    *prometheus.CounterVec
+	StoreFallbacks   *prometheus.CounterVec
+	LimiterChecks    *prometheus.CounterVec
+
+	PolicyRejections    *prometheus.CounterVec
+	PolicyModifications *prometheus.CounterVec
+
+	CanaryDuration *prometheus.HistogramVec
+	CanaryTTFT     *prometheus.HistogramVec
+	TTFT           *prometheus.HistogramVec
+	CanaryFailures *prometheus.CounterVec
+	CanarySkipped  *prometheus.CounterVec
+
+	ContextTokens *prometheus.HistogramVec
+	QueueWait     *prometheus.HistogramVec
+	TPMUsed       *prometheus.GaugeVec
+	BudgetUsed    *prometheus.GaugeVec
+	UpstreamInfo  *prometheus.GaugeVec
+
+	TenantInFlight   *prometheus.GaugeVec
+	TenantRejections *prometheus.CounterVec
+
+	MalformedChunks *prometheus.CounterVec
+	ConnRejected    *prometheus.CounterVec
+	ServerErrors    *prometheus.CounterVec
+
+	ResponseSpills     *prometheus.CounterVec
+	ResponseSpillBytes *prometheus.CounterVec
+
+	DuplicatePrompts *prometheus.CounterVec
+	DuplicateRatio   prometheus.Gauge
+
+	ConversationTurns    prometheus.Histogram
+	ConversationTokens   prometheus.Histogram
+	ConversationDuration prometheus.Histogram
+	ActiveConversations  prometheus.Gauge
+
+	ThinkingRequests *prometheus.CounterVec
+	ThinkingRatio    *prometheus.HistogramVec
+	ThinkingTokens   *prometheus.CounterVec
+
+	EmbedBatchSize    *prometheus.HistogramVec
+	EmbedSingleInputs *prometheus.CounterVec
+
+	UnloadRequests *prometheus.CounterVec
+
+	UnknownModelRequests *prometheus.CounterVec
+
+	OOMEvents   *prometheus.CounterVec
+	OOMCooldown *prometheus.GaugeVec
+
+	ContextOverflow *prometheus.CounterVec
+
+	BackendRequests  *prometheus.CounterVec
+	BackendSpillover *prometheus.CounterVec
+	BackendUp        *prometheus.GaugeVec
+
+	AdmissionDecisions *prometheus.CounterVec
+
+	RequestRead *prometheus.HistogramVec
+
+	InformationalResponses *prometheus.CounterVec
+
+	BackgroundWorkers  *prometheus.GaugeVec
+	BackgroundRestarts *prometheus.CounterVec
+
+	AuthFailures        *prometheus.CounterVec
+	MetricsAuthFailures *prometheus.CounterVec
+	ClientAuthFailures  *prometheus.CounterVec
+
+	LabelsSanitized *prometheus.CounterVec
+
+	TokenEstimateRatio *prometheus.HistogramVec
+	CharsPerToken      prometheus.Gauge
+
+	ModelNotFound *prometheus.CounterVec
+
+	Transfers     *prometheus.CounterVec
+	TransferBytes *prometheus.CounterVec
+
+	InFlight         *prometheus.GaugeVec
+	InFlightByStream *prometheus.GaugeVec
+
+	SLORequests   *prometheus.CounterVec
+	SLOViolations *prometheus.CounterVec
+	SLOObjective  *prometheus.GaugeVec
+
+	ClientCancellations *prometheus.CounterVec
+	UpstreamTimeouts    *prometheus.CounterVec
+	UpstreamRetries     *prometheus.CounterVec
+
+	// Upstream* are the durations Ollama reports on a response's final
+	// object, separating model load from prompt processing and generation.
+	UpstreamTotalDuration      *prometheus.HistogramVec
+	UpstreamLoadDuration       *prometheus.HistogramVec
+	UpstreamPromptEvalDuration *prometheus.HistogramVec
+	UpstreamEvalDuration       *prometheus.HistogramVec
+
+	GenerationTPS *prometheus.HistogramVec
+	PromptTPS     *prometheus.HistogramVec
+
+	ConformanceViolations *prometheus.CounterVec
+
+	// Body bytes on each leg, as sent and received on the wire.
+	ClientBytesIn    *prometheus.CounterVec
+	ClientBytesOut   *prometheus.CounterVec
+	UpstreamBytesOut *prometheus.CounterVec
+	UpstreamBytesIn  *prometheus.CounterVec
+
+	// LoadedModel* mirror the upstream's /api/ps, scraped every
+	// PSScrapeInterval.
+	LoadedModel        *prometheus.GaugeVec
+	LoadedModelSize    *prometheus.GaugeVec
+	LoadedModelVRAM    *prometheus.GaugeVec
+	LoadedModelExpires *prometheus.GaugeVec
+	// ModelInfo and ModelSize mirror /api/tags, every TagsScrapeInterval.
+	ModelInfo       *prometheus.GaugeVec
+	ModelSize       *prometheus.GaugeVec
+	UpstreamScrapes *prometheus.CounterVec
+
+	Ready *prometheus.GaugeVec
+
+	// UpstreamUp is 1 while the upstream answers readiness probes.
+	UpstreamUp *prometheus.GaugeVec
+
+	RequestPhase *prometheus.HistogramVec
+
+	RequestsRejected *prometheus.CounterVec
+
+	LogLinesSuppressed *prometheus.CounterVec
+
+	ErrorDuration *prometheus.HistogramVec
+
+	durationMode string // see MetricsOptions.DurationMode
 }
 
+// DefaultMetricsNamespace is the prefix of every metric name unless
+// NewMetricsNamespace is given another.
+const DefaultMetricsNamespace = "ollama_proxy"
+
 // NewMetrics creates and registers a fresh set of Prometheus metrics using reg.
 func NewMetrics(reg prometheus.Registerer) *Metrics {
+	return NewMetricsNamespace(reg, DefaultMetricsNamespace)
+}
+
+// NewMetricsNamespace is NewMetrics with metric names prefixed by ns instead
+// of DefaultMetricsNamespace.
+func NewMetricsNamespace(reg prometheus.Registerer, ns string) *Metrics {
+	return NewMetricsWithOptions(reg, MetricsOptions{Namespace: ns})
+}
+
+// NewMetricsWithOptions is NewMetrics with the namespace and histogram
+// buckets of opts.
+func NewMetricsWithOptions(reg prometheus.Registerer, opts MetricsOptions) *Metrics {
+	ns := opts.Namespace
+	if ns == "" {
+		ns = DefaultMetricsNamespace
+	}
+	durationBuckets := opts.DurationBuckets
+	if len(durationBuckets) == 0 {
+		durationBuckets = DefaultDurationBuckets
+	}
 	m := &Metrics{
 		ReqTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "ollama_proxy_requests_total",
-			Help: "Total requests handled by the Ollama proxy.",
-		}, []string{"endpoint", "model", "status", "stream"}),
+			Namespace: ns,
+			Name:      "requests_total",
+			Help: "Total requests handled by the Ollama proxy. origin is \"upstream\" when status is Ollama's " +
+				"(including cached responses) and \"proxy\" when the proxy answered itself: policy rejections, " +
+				"queue timeouts, unreachable or timed-out upstreams, clients gone before a response and bad requests.",
+		}, []string{"endpoint", "model", "status", "stream", "origin", "upstream", "client"}),
 
 		ReqDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
-			Name:    "ollama_proxy_request_duration_seconds",
-			Help:    "Duration of Ollama requests handled by the proxy.",
-			Buckets: prometheus.DefBuckets,
+			Namespace: ns,
+			Name:      "request_duration_seconds",
+			Help:      durationHelp(opts.DurationMode),
+			Buckets:   durationBuckets,
+		}, []string{"endpoint", "model", "stream", "client"}),
+
+		ReqDurationAdjusted: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "request_duration_adjusted_seconds",
+			Help: "request_duration_seconds minus the model load_duration reported by Ollama, " +
+				"floored at zero, so cold starts don't distort generation latency.",
+			Buckets: durationBuckets,
 		}, []string{"endpoint", "model", "stream"}),
 
 		BytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "ollama_proxy_request_bytes_in_total",
-			Help: "Total bytes received in request bodies.",
+			Namespace: ns,
+			Name:      "request_bytes_in_total",
+			Help:      "Total bytes received in request bodies.",
 		}, []string{"endpoint", "model", "stream"}),
 
 		BytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "ollama_proxy_response_bytes_out_total",
-			Help: "Total bytes sent in response bodies.",
+			Namespace: ns,
+			Name:      "response_bytes_out_total",
+			Help:      "Total bytes sent in response bodies.",
 		}, []string{"endpoint", "model", "stream"}),
 
 		TokensIn: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "ollama_proxy_prompt_tokens_total",
-			Help: "Total prompt tokens (from Ollama eval stats).",
-		}, []string{"endpoint", "model"}),
+			Namespace: ns,
+			Name:      "prompt_tokens_total",
+			Help:      "Total prompt tokens (from Ollama eval stats).",
+		}, []string{"endpoint", "model", "upstream", "client"}),
 
 		TokensOut: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "ollama_proxy_completion_tokens_total",
-			Help: "Total completion tokens (from Ollama eval stats).",
+			Namespace: ns,
+			Name:      "completion_tokens_total",
+			Help:      "Total completion tokens (from Ollama eval stats).",
+		}, []string{"endpoint", "model", "upstream", "client"}),
+
+		TokenCost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "token_cost_total",
+			Help:      "Cost of prompt and completion tokens by the -token-prices table of their upstream and model; unpriced upstreams add nothing.",
+		}, []string{"endpoint", "model", "upstream", "client"}),
+
+		Apdex: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "apdex_requests_total",
+			Help: "Requests per Apdex zone (satisfied ≤T, tolerating ≤4T, frustrated). " +
+				"Apdex = (satisfied + tolerating/2) / total.",
+		}, []string{"model", "zone"}),
+
+		GzipSaved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "compression_saved_bytes_total",
+			Help:      "Bytes saved by gzip-compressing responses toward clients.",
+		}, []string{"endpoint"}),
+
+		DecompressErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "decompression_errors_total",
+			Help:      "Upstream gzip responses that could not be decompressed for the client.",
+		}, []string{"endpoint"}),
+
+		CacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "cache_requests_total",
+			Help:      "Cacheable requests by result (hit, miss, coalesced).",
+		}, []string{"endpoint", "result"}),
+
+		StoreFallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "shared_store_fallbacks_total",
+			Help:      "Shared store (Redis) operations that failed and fell back to local state.",
+		}, []string{"op"}),
+
+		LimiterChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "limiter_checks_total",
+			Help: "Rate limit and token budget checks, by whether shared (remote) or local state was used. " +
+				"Rejections are counted in ollama_proxy_policy_rejections_total.",
+		}, []string{"limiter", "source"}),
+
+		PolicyRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "policy_rejections_total",
+			Help:      "Requests refused by a proxy policy." + policyReasonsHelp,
+		}, []string{"reason"}),
+
+		PolicyModifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "policy_modifications_total",
+			Help:      "Requests altered by a proxy policy before forwarding." + policyReasonsHelp,
+		}, []string{"reason"}),
+
+		CanaryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "canary_duration_seconds",
+			Help:      "Duration of successful synthetic canary probes.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"model"}),
+
+		CanaryTTFT: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "canary_ttft_seconds",
+			Help:      "Time to first streamed byte of successful synthetic canary probes.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"model"}),
+
+		TTFT: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "time_to_first_token_seconds",
+			Help:      "Time from receiving the request body to the first response body bytes from the upstream: the first chunk of a stream, the whole body otherwise.",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2, 3, 5, 10, 20, 30, 60, 120},
+		}, []string{"endpoint", "model"}),
+
+		CanaryFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "canary_failures_total",
+			Help:      "Synthetic canary probes that failed or did not complete.",
+		}, []string{"model"}),
+
+		CanarySkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "canary_skipped_total",
+			Help:      "Synthetic canary probes skipped because the model was busy with client requests.",
+		}, []string{"model"}),
+
+		ContextTokens: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "context_tokens",
+			Help:      "Length of /api/generate context arrays sent by clients (direction=in) and returned by Ollama (direction=out).",
+			Buckets:   prometheus.ExponentialBuckets(256, 2, 13), // 256 … 1M
+		}, []string{"model", "direction"}),
+
+		QueueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "queue_wait_seconds",
+			Help: "Time requests waited in the proxy's admission queue before being forwarded; " +
+				"0 for requests that did not queue.",
+			Buckets: []float64{0, .005, .025, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"model", "priority"}),
+
+		TPMUsed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "tpm_used_tokens",
+			Help:      "Tokens charged to the tenant in the current tokens-per-minute window, as of its latest request; gone once a window passes without one.",
+		}, []string{"tenant"}),
+
+		BudgetUsed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "token_budget_used_ratio",
+			Help:      "Fraction of -token-budget the tenant has used in the current window, as of its latest request; gone once a window passes without one.",
+		}, []string{"tenant"}),
+
+		TenantInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "tenant_in_flight_requests",
+			Help:      "Requests the tenant has queued or in flight under -max-concurrent-per-tenant; tenants with none have no series.",
+		}, []string{"tenant"}),
+
+		TenantRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "tenant_concurrency_rejections_total",
+			Help:      "Requests refused with 429 because the tenant was at its concurrency limit; tenants known only by client IP count as anonymous.",
+		}, []string{"tenant"}),
+
+		UpstreamInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "upstream_info",
+			Help:      "Always 1; the url label is the upstream new requests are sent to.",
+		}, []string{"url"}),
+
+		MalformedChunks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "malformed_chunks_total",
+			Help:      "Non-empty streamed response lines from the upstream that were not valid JSON; they are forwarded unchanged.",
+		}, []string{"endpoint", "model"}),
+
+		ConnRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "connections_rejected_total",
+			Help:      "Client connections closed on accept by -max-connections (global) or -max-connections-per-client (per_client).",
+		}, []string{"limit"}),
+
+		ServerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "server_errors_total",
+			Help:      "Requests the HTTP server rejected before they reached the proxy: a failed TLS handshake (tls_handshake), an unparsable request (header_parse) or headers over the size limit (too_large).",
+		}, []string{"kind"}),
+
+		ResponseSpills: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "response_spills_total",
+			Help: "Non-stream responses over the spill threshold, by result: spilled (buffered on disk), " +
+				"too_large (over the ceiling, answered 502) or error (temp file unusable, buffered in memory).",
+		}, []string{"endpoint", "result"}),
+
+		ResponseSpillBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "response_spill_bytes_total",
+			Help:      "Bytes of non-stream responses buffered on disk instead of in memory.",
+		}, []string{"endpoint"}),
+
+		DuplicatePrompts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "duplicate_prompts_total",
+			Help:      "Sampled generate/chat requests whose model and prompt hash was seen recently.",
+		}, []string{"endpoint", "model"}),
+
+		DuplicateRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "duplicate_prompt_ratio",
+			Help:      "Share of duplicates among the last 1000 sampled generate/chat requests.",
+		}),
+
+		ConversationTurns: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "conversation_turns",
+			Help:      "Completed requests per conversation, observed when the conversation ends.",
+			Buckets:   []float64{1, 2, 3, 5, 8, 13, 21, 34, 55, 100},
+		}),
+
+		ConversationTokens: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "conversation_tokens",
+			Help:      "Prompt+completion tokens per conversation, observed when the conversation ends.",
+			Buckets:   prometheus.ExponentialBuckets(100, 4, 9), // 100 … 6.5M
+		}),
+
+		ConversationDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "conversation_duration_seconds",
+			Help:      "Time from a conversation's first to its last completed request, observed when it ends.",
+			Buckets:   []float64{0, 10, 30, 60, 300, 900, 1800, 3600, 7200, 14400},
+		}),
+
+		ActiveConversations: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "active_conversations",
+			Help:      "Conversations seen within the conversation TTL.",
+		}),
+
+		ThinkingRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "thinking_requests_total",
+			Help:      "Generate/chat responses that carried thinking output, by whether the request set think (true, false or unset).",
+		}, []string{"endpoint", "model", "requested"}),
+
+		ThinkingRatio: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "thinking_output_ratio",
+			Help:      "Share of thinking in the output characters of responses with thinking output.",
+			Buckets:   prometheus.LinearBuckets(0.1, 0.1, 9),
+		}, []string{"model"}),
+
+		ThinkingTokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "thinking_tokens_estimated_total",
+			Help:      "Completion tokens attributed to thinking, estimated as eval_count times the thinking share of output characters.",
+		}, []string{"endpoint", "model"}),
+
+		EmbedBatchSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "embed_batch_size",
+			Help:      "Inputs per /api/embed request.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 11), // 1 … 1024
+		}, []string{"model"}),
+
+		EmbedSingleInputs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "embed_single_input_requests_total",
+			Help:      "/api/embed requests with a single input, which could have been batched.",
+		}, []string{"model"}),
+
+		UnloadRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "unload_requests_total",
+			Help:      "Requests with keep_alive 0, which make Ollama unload the model (overridden or not).",
+		}, []string{"model"}),
+
+		UnknownModelRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "unknown_model_requests_total",
+			Help:      "Requests without a model label, by why: no_body, parse_error, beyond_sniff_window (after -request-sniff-bytes of a streamed body), field_missing or non_model_endpoint.",
+		}, []string{"endpoint", "cause"}),
+
+		OOMEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "upstream_oom_total",
+			Help:      "Upstream errors recognised as the model not fitting in memory.",
+		}, []string{"model"}),
+
+		OOMCooldown: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "oom_cooldown_active",
+			Help:      "1 while requests for the model fail fast after an out-of-memory error, else 0.",
+		}, []string{"model"}),
+
+		ContextOverflow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "context_overflow_suspected_total",
+			Help:      "Generate and chat requests whose estimated prompt exceeds the model's context window.",
+		}, []string{"model"}),
+
+		BackendRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "backend_requests_total",
+			Help:      "Requests placed on each -backends server, by model.",
+		}, []string{"backend", "model"}),
+
+		BackendSpillover: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "backend_spillover_total",
+			Help:      "Requests sent elsewhere because the model's preferred backend was down, by that backend.",
+		}, []string{"backend", "model"}),
+
+		BackendUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "backend_up",
+			Help:      "1 while the backend answered its last health probe, else 0.",
+		}, []string{"backend"}),
+
+		AdmissionDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "admission_decisions_total",
+			Help:      admissionDecisionsHelp,
+		}, []string{"decision"}),
+
+		RequestRead: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "request_read_seconds",
+			Help: "Time spent receiving client request bodies, before anything is forwarded; " +
+				"the duration metrics count from when the body was in.",
+			Buckets: []float64{.001, .005, .025, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"endpoint"}),
+
+		InformationalResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "informational_responses_total",
+			Help:      "1xx responses such as 103 Early Hints sent by the upstream before its final response, relayed to HTTP/1.1+ clients.",
+		}, []string{"endpoint", "code"}),
+
+		BackgroundWorkers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "background_workers",
+			Help:      "Background workers by component and state: running, or backoff while waiting to be restarted after a panic.",
+		}, []string{"component", "state"}),
+		BackgroundRestarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "background_worker_restarts_total",
+			Help:      "Background workers restarted after a panic, by component.",
+		}, []string{"component"}),
+
+		AuthFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "auth_failures_total",
+			Help:      "Requests refused with 401, by mode: missing_credential, malformed_header or invalid_credential.",
+		}, []string{"mode"}),
+
+		ClientAuthFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "client_auth_failures_total",
+			Help:      "Proxied requests refused with 401 for want of a valid API key, by mode like auth_failures_total.",
+		}, []string{"mode"}),
+
+		MetricsAuthFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "metrics_auth_failures_total",
+			Help:      "Scrapes of /metrics refused with 401, by mode like auth_failures_total.",
+		}, []string{"mode"}),
+
+		LabelsSanitized: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "label_values_sanitized_total",
+			Help:      "Request-derived label values changed to be safe: invalid (control characters or invalid UTF-8 dropped) or length (shortened with a hash).",
+		}, []string{"label", "reason"}),
+
+		TokenEstimateRatio: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "token_estimate_ratio",
+			Help:      "Reported prompt_eval_count divided by the chars-per-token prompt estimate, by estimator (tpm, context); 1 is exact.",
+			Buckets:   []float64{0.25, 0.5, 0.67, 0.8, 0.9, 1, 1.1, 1.25, 1.5, 2, 4},
+		}, []string{"model", "estimator"}),
+		CharsPerToken: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "chars_per_token",
+			Help:      "Characters per token assumed by prompt-token estimates (-chars-per-token).",
+		}),
+		ModelNotFound: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "model_not_found_total",
+			Help:      "404 model-not-found answers, by origin: upstream (Ollama said so) or proxy (answered from -negative-cache-ttl).",
+		}, []string{"origin"}),
+
+		Transfers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "model_transfers_total",
+			Help:      "Pulls and pushes the upstream answered, by op (pull, push), model and result (success, failure).",
+		}, []string{"op", "model", "result"}),
+		TransferBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "model_transfer_bytes_total",
+			Help:      "Bytes downloaded by pulls and uploaded by pushes, from their progress lines, by op and model.",
+		}, []string{"op", "model"}),
+
+		InFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "inflight_requests",
+			Help:      "Requests the proxy is handling, from the end of the body read until the last byte is sent, by endpoint and model.",
+		}, []string{"endpoint", "model"}),
+		InFlightByStream: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "inflight_requests_by_stream",
+			Help:      "Requests the proxy is handling, by whether the response is streamed (true) or buffered (false).",
+		}, []string{"stream"}),
+
+		SLORequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "slo_requests_total",
+			Help:      "Finished requests an SLO target applies to, by SLO and model.",
+		}, []string{"slo", "model"}),
+		SLOViolations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "slo_violations_total",
+			Help:      "Requests that missed an SLO target, by SLO, model and cause: error (5xx, timeout or broken response) or latency.",
+		}, []string{"slo", "model", "cause"}),
+		SLOObjective: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "slo_objective",
+			Help:      "Share of requests each loaded SLO target must meet.",
+		}, []string{"slo"}),
+
+		ClientCancellations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "client_cancellations_total",
+			Help:      "Requests whose client went away before they were answered, by endpoint and phase: queue, upstream_headers, response or stream.",
+		}, []string{"endpoint", "phase"}),
+
+		UpstreamTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "upstream_timeouts_total",
+			Help:      "Requests failed by an upstream timeout, by endpoint and type: dial, tls_handshake, response_header or nonstream.",
+		}, []string{"endpoint", "type"}),
+		UpstreamRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "upstream_retries_total",
+			Help:      "Upstream requests sent again after a connection error, 502 or 503, by endpoint and model.",
+		}, []string{"endpoint", "model"}),
+
+		UpstreamTotalDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "upstream_total_duration_seconds",
+			Help:      "total_duration Ollama reported for a response: its own time spent on the request.",
+			Buckets:   durationBuckets,
+		}, []string{"endpoint", "model"}),
+
+		UpstreamLoadDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "upstream_load_duration_seconds",
+			Help:      "load_duration Ollama reported for a response: time spent loading the model.",
+			Buckets:   durationBuckets,
 		}, []string{"endpoint", "model"}),
+
+		UpstreamPromptEvalDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "upstream_prompt_eval_duration_seconds",
+			Help:      "prompt_eval_duration Ollama reported for a response: time spent evaluating the prompt.",
+			Buckets:   durationBuckets,
+		}, []string{"endpoint", "model"}),
+
+		UpstreamEvalDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "upstream_eval_duration_seconds",
+			Help:      "eval_duration Ollama reported for a response: time spent generating the completion.",
+			Buckets:   durationBuckets,
+		}, []string{"endpoint", "model"}),
+
+		GenerationTPS: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "generation_tokens_per_second",
+			Help:      "Generation throughput of responses, eval_count over eval_duration as Ollama reported them.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10), // 1 … 512
+		}, []string{"model"}),
+
+		PromptTPS: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "prompt_tokens_per_second",
+			Help:      "Prompt processing throughput of responses, prompt_eval_count over prompt_eval_duration as Ollama reported them.",
+			Buckets:   prometheus.ExponentialBuckets(10, 2, 12), // 10 … 20480
+		}, []string{"model"}),
+
+		ConformanceViolations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "upstream_conformance_violations_total",
+			Help:      "2xx upstream responses that broke their endpoint's schema, by kind: content_type, missing_done or missing_usage. Only with -validate-upstream.",
+		}, []string{"endpoint", "kind"}),
+
+		ClientBytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "client_bytes_in_total",
+			Help:      "Request body bytes received from clients.",
+		}, []string{"endpoint", "model", "stream"}),
+
+		ClientBytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "client_bytes_out_total",
+			Help:      "Response body bytes written to clients, as sent: after compression, including the proxy's own answers.",
+		}, []string{"endpoint", "model", "stream"}),
+
+		UpstreamBytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "upstream_bytes_out_total",
+			Help:      "Request body bytes sent to the upstream, after any rewriting by the proxy.",
+		}, []string{"endpoint", "model", "stream"}),
+
+		UpstreamBytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "upstream_bytes_in_total",
+			Help:      "Response body bytes read from the upstream, as received: before decompression. Cache hits read none.",
+		}, []string{"endpoint", "model", "stream"}),
+
+		LoadedModel: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "loaded_model",
+			Help:      "1 for each model the upstream's /api/ps listed as loaded at the last scrape; models no longer loaded have no series.",
+		}, []string{"model"}),
+		LoadedModelSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "loaded_model_size_bytes",
+			Help:      "Memory a loaded model takes, per /api/ps size.",
+		}, []string{"model"}),
+		LoadedModelVRAM: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "loaded_model_size_vram_bytes",
+			Help:      "Part of a loaded model's memory in VRAM, per /api/ps size_vram; below size_bytes the model is partly on the CPU.",
+		}, []string{"model"}),
+		LoadedModelExpires: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "loaded_model_expires_at_seconds",
+			Help:      "Unix time at which the upstream unloads an idle model, per /api/ps expires_at.",
+		}, []string{"model"}),
+		ModelInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "model_info",
+			Help:      "1 for each model the upstream's /api/tags listed at the last scrape, with its details; deleted models have no series.",
+		}, []string{"model", "family", "parameter_size", "quantization_level"}),
+		ModelSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "model_size_bytes",
+			Help:      "Size on disk of a model the upstream has, per /api/tags.",
+		}, []string{"model"}),
+		UpstreamScrapes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "upstream_scrapes_total",
+			Help:      "Background scrapes of the upstream by endpoint (/api/ps, /api/tags) and result: success or failure. After a failure the gauges keep their last values.",
+		}, []string{"endpoint", "result"}),
+		Ready: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "ready",
+			Help:      "1 for the reason /readyz answers with, ok while ready, and 0 for the others: draining, upstream, inflight or queue_wait.",
+		}, []string{"reason"}),
+		UpstreamUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "upstream_up",
+			Help:      "1 while the upstream answered its last readiness probe of /api/version, 0 while it did not.",
+		}, []string{"upstream"}),
+		RequestPhase: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "request_phase_seconds",
+			Help: "Time from receiving the request body to each phase of a response from the upstream: headers received, " +
+				"upstream_body read to its end and client_write done, whatever request_duration_seconds measures.",
+			Buckets: durationBuckets,
+		}, []string{"endpoint", "model", "stream", "phase"}),
+		RequestsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "requests_rejected_total",
+			Help:      "Requests refused while their body was read, by reason: body_too_large (over -max-request-bytes, answered 413).",
+		}, []string{"reason"}),
+		LogLinesSuppressed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "log_lines_suppressed_total",
+			Help:      "Error log lines held back by -log-dedup-window as repeats of one logged moments before, by message.",
+		}, []string{"message"}),
+		ErrorDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "error_duration_seconds",
+			Help: "request_duration_seconds of failed requests only, by status_class: 4xx, 5xx, or error for a timeout, " +
+				"a shutdown or a response cut off midway; client cancellations are not failures.",
+			Buckets: durationBuckets,
+		}, []string{"endpoint", "model", "status_class"}),
+		durationMode: opts.DurationMode,
+	}
+	reg.MustRegister(m.ReqTotal, m.ReqDuration, m.ReqDurationAdjusted, m.BytesIn, m.BytesOut, m.TokensIn, m.TokensOut, m.TokenCost, m.Apdex, m.GzipSaved,
+		m.DecompressErrors, m.CacheRequests, m.StoreFallbacks, m.LimiterChecks,
+		m.PolicyRejections, m.PolicyModifications,
+		m.CanaryDuration, m.CanaryTTFT, m.TTFT, m.CanaryFailures, m.CanarySkipped, m.ContextTokens, m.QueueWait, m.TPMUsed, m.BudgetUsed, m.TenantInFlight, m.TenantRejections,
+		m.UpstreamInfo, m.MalformedChunks, m.ConnRejected, m.ServerErrors,
+		m.ResponseSpills, m.ResponseSpillBytes, m.DuplicatePrompts, m.DuplicateRatio,
+		m.ConversationTurns, m.ConversationTokens, m.ConversationDuration, m.ActiveConversations,
+		m.ThinkingRequests, m.ThinkingRatio, m.ThinkingTokens,
+		m.EmbedBatchSize, m.EmbedSingleInputs, m.UnloadRequests, m.UnknownModelRequests,
+		m.OOMEvents, m.OOMCooldown, m.ContextOverflow,
+		m.BackendRequests, m.BackendSpillover, m.BackendUp, m.AdmissionDecisions,
+		m.RequestRead, m.InformationalResponses, m.BackgroundWorkers, m.BackgroundRestarts,
+		m.AuthFailures, m.MetricsAuthFailures, m.ClientAuthFailures, m.LabelsSanitized, m.TokenEstimateRatio, m.CharsPerToken,
+		m.ModelNotFound, m.Transfers, m.TransferBytes, m.InFlight, m.InFlightByStream,
+		m.SLORequests, m.SLOViolations, m.SLOObjective, m.ClientCancellations, m.UpstreamTimeouts, m.UpstreamRetries,
+		m.UpstreamTotalDuration, m.UpstreamLoadDuration, m.UpstreamPromptEvalDuration, m.UpstreamEvalDuration,
+		m.GenerationTPS, m.PromptTPS, m.ConformanceViolations, m.ClientBytesIn, m.ClientBytesOut, m.UpstreamBytesOut, m.UpstreamBytesIn,
+		m.LoadedModel, m.LoadedModelSize, m.LoadedModelVRAM, m.LoadedModelExpires,
+		m.ModelInfo, m.ModelSize, m.UpstreamScrapes, m.Ready, m.UpstreamUp, m.RequestPhase, m.RequestsRejected, m.LogLinesSuppressed, m.ErrorDuration)
+	for _, reason := range rejectionReasons {
+		m.PolicyRejections.WithLabelValues(reason)
+	}
+	for _, reason := range requestRejectReasons {
+		m.RequestsRejected.WithLabelValues(reason)
+	}
+	for _, d := range admissionDecisions {
+		m.AdmissionDecisions.WithLabelValues(d)
+	}
+	for _, mode := range authFailureModes {
+		m.AuthFailures.WithLabelValues(mode)
+		m.MetricsAuthFailures.WithLabelValues(mode)
+		m.ClientAuthFailures.WithLabelValues(mode)
+	}
+	for _, label := range sanitizedLabels {
+		for _, reason := range sanitizedReasons {
+			m.LabelsSanitized.WithLabelValues(label, reason)
+		}
+	}
+	for _, origin := range []string{originUpstream, originProxy} {
+		m.ModelNotFound.WithLabelValues(origin)
+	}
+	for _, reason := range modificationReasons {
+		m.PolicyModifications.WithLabelValues(reason)
+	}
+	for _, stream := range []string{"true", "false"} {
+		m.InFlightByStream.WithLabelValues(stream)
+	}
+	for _, reason := range readyReasons {
+		m.Ready.WithLabelValues(reason)
 	}
-	reg.MustRegister(m.ReqTotal, m.ReqDuration, m.BytesIn, m.BytesOut, m.TokensIn, m.TokensOut)
 	return m
 }
 
+// Config holds optional proxy behaviour. The zero value disables every
+// optional feature.
+type Config struct {
+	// ApdexTarget is the default Apdex satisfaction threshold T; 0 disables
+	// Apdex tracking.
+	ApdexTarget time.Duration
+	// ApdexTargets overrides ApdexTarget per endpoint class (generate, chat,
+	// embed, other).
+	ApdexTargets map[string]time.Duration
+
+	// CompressResponses gzips responses for clients that accept it when the
+	// upstream response is not already encoded. Buffered responses are only
+	// compressed from CompressMinBytes on; streams use a flushing writer.
+	CompressResponses bool
+	CompressMinBytes  int
+
+	// DecompressResponses gunzips gzip-encoded upstream responses for clients
+	// that did not offer gzip in Accept-Encoding.
+	DecompressResponses bool
+
+	// MetadataCacheTTL enables a TTL cache for GET /api/tags, /api/ps and
+	// /api/version; 0 disables it.
+	MetadataCacheTTL time.Duration
+
+	// ShowCacheTTL caches /api/show responses per model and verbose flag;
+	// 0 disables it.
+	ShowCacheTTL time.Duration
+
+	// EmbedCacheTTL keeps /api/embed and /api/embeddings responses in
+	// SharedStore for this long, keyed by upstream, endpoint and body, so
+	// replicas sharing a Redis store answer a repeated input without Ollama;
+	// 0 disables it.
+	EmbedCacheTTL time.Duration
+
+	// SharedStore holds state that must be consistent across replicas
+	// (limiter counters, quotas, shared caches). nil means process-local
+	// memory.
+	SharedStore kv.Store
+
+	// RateLimit caps requests per tenant per RateLimitWindow across every
+	// replica sharing SharedStore; 0 disables it.
+	RateLimit       int64
+	RateLimitWindow time.Duration
+
+	// TokenBudget caps prompt+completion tokens per tenant per
+	// TokenBudgetWindow; 0 disables it. Consumption is flushed to
+	// SharedStore every QuotaFlushInterval.
+	TokenBudget        int64
+	TokenBudgetWindow  time.Duration
+	QuotaFlushInterval time.Duration
+
+	// CanaryModels are probed every CanaryInterval with CanaryPrompt limited
+	// to CanaryNumPredict tokens; an empty list or a zero interval disables
+	// the prober. Probes skip models that have requests in flight.
+	CanaryModels     []string
+	CanaryInterval   time.Duration
+	CanaryPrompt     string
+	CanaryNumPredict int
+
+	// ContextWarnTokens logs a warning when a /api/generate context array
+	// sent or returned is longer than this; 0 disables the warning.
+	ContextWarnTokens int64
+
+	// MaxConcurrentPerModel caps requests in flight to the upstream per
+	// model; further requests queue by X-Ollama-Priority. 0 disables the
+	// gate. QueueTimeout bounds the wait (0 = as long as the client waits).
+	MaxConcurrentPerModel int
+	QueueTimeout          time.Duration
+
+	// MaxConcurrentPerTenant caps the requests one tenant has queued or in
+	// flight; more are refused with 429. TenantConcurrency overrides it per
+	// tenant, where 0 is unlimited. Both 0/empty disable the limit.
+	MaxConcurrentPerTenant int
+	TenantConcurrency      map[string]int
+
+	// TenantHeader names a request header identifying the tenant that
+	// limits, budgets and per-tenant metrics are accounted to; requests
+	// without it, or all requests when empty, are accounted to the client IP.
+	TenantHeader string
+
+	// TrustedProxies are the reverse proxies in front of this one whose
+	// X-Forwarded-For and X-Real-IP name the client IP limits are keyed on;
+	// from any other peer those headers are ignored and its own address is
+	// the client's.
+	TrustedProxies []*net.IPNet
+
+	// TPMLimit caps prompt+completion tokens per tenant per minute on
+	// generate, chat and embed requests; 0 disables it. Prompt tokens are
+	// estimated at admission as characters / CharsPerToken (default 4) and
+	// reconciled with Ollama's counts when the response completes.
+	TPMLimit      int64
+	CharsPerToken float64
+
+	// ContextCheck, when "warn" or "reject", estimates the prompt tokens of
+	// generate and chat requests (characters / CharsPerToken, plus any
+	// context array) and compares them with the model's context window:
+	// options.num_ctx, else the Modelfile's num_ctx or the architecture's
+	// context_length from /api/show, fetched in the background and cached
+	// for 10 minutes. Estimates over the window by more than
+	// ContextOverflowMargin (a fraction, such as 0.2) are counted and
+	// logged; "reject" also answers 413. Empty disables the check.
+	ContextCheck          string
+	ContextOverflowMargin float64
+
+	// ResponseHeaderTimeout bounds how long the upstream may take to send
+	// response headers (prompt evaluation included) before the request fails
+	// with 504; 0 waits forever. ResponseHeaderTimeouts overrides it per
+	// endpoint class (generate, chat, embed, other). Bodies are never timed
+	// by it; see NonStreamTimeout.
+	ResponseHeaderTimeout  time.Duration
+	ResponseHeaderTimeouts map[string]time.Duration
+
+	// DialTimeout, TLSHandshakeTimeout, IdleConnTimeout and
+	// MaxIdleConnsPerHost tune the upstream transport: how long connecting
+	// and the TLS handshake may take (both fail with 504), how long an idle
+	// connection is kept, and how many per upstream. Zero keeps Go's
+	// defaults (30s, 10s, 90s, 2).
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	IdleConnTimeout     time.Duration
+	MaxIdleConnsPerHost int
+
+	// UpstreamTLS configures TLS to https upstreams and backends: trusted
+	// CAs, a client certificate, the server name. nil uses Go's defaults.
+	UpstreamTLS *tls.Config
+
+	// NonStreamTimeout bounds a non-streaming request from forwarding until
+	// its whole response body is read; past it the request fails with 504
+	// and status label "timeout". Streaming requests are exempt; 0 disables.
+	NonStreamTimeout time.Duration
+
+	// UpstreamRetries is how many times a request is sent again when the
+	// upstream refuses or drops the connection, or answers 502 or 503,
+	// before any response reached the client, waiting UpstreamRetryBackoff
+	// and then twice as long each time, up to 30s. Only a fully buffered body is
+	// replayed; one streamed upstream as it arrived is never retried. The
+	// request is counted and recorded with the outcome of its last
+	// attempt. 0 disables.
+	UpstreamRetries      int
+	UpstreamRetryBackoff time.Duration
+
+	// RequestReadTimeout bounds how long a client may take to send its
+	// request body; slower ones get 408. Body receive time is measured in
+	// request_read_seconds and left out of the duration metrics either way;
+	// 0 waits as long as the client.
+	RequestReadTimeout time.Duration
+
+	// PreserveHost forwards the client's Host header instead of the
+	// upstream's host; either way X-Forwarded-Host has the client's.
+	PreserveHost bool
+
+	// AccessLog, when set, gets one "access" line per request once its
+	// response has been sent: method, endpoint, model, status, sizes, token
+	// counts, duration, time to first byte, client IP and request ID.
+	AccessLog *slog.Logger
+
+	// RequestIDHeader names the header a request's ID is taken from when the
+	// client sends a usable one, and the ID is sent in to the upstream and
+	// back to the client; DefaultRequestIDHeader when empty.
+	RequestIDHeader string
+
+	// RequestSniffBytes, when positive, keeps request bodies longer than
+	// this out of memory: only this much is read, to find model and stream,
+	// and the rest is streamed upstream as it arrives. Such requests skip
+	// what needs the whole payload: prompt estimates, duplicate detection,
+	// the response cache and the keep_alive override. 0 reads whole bodies.
+	RequestSniffBytes int64
+
+	// MaxRequestBytes caps the request body the proxy reads into memory;
+	// larger ones get 413 and count in requests_rejected_total. 0 means no
+	// limit.
+	MaxRequestBytes int64
+
+	// InstanceName identifies this proxy in request records and log lines
+	// and, with ServedByHeader, in an X-Served-By response header.
+	// ExposeUpstreamNames adds X-Upstream, the host:port of the upstream a
+	// forwarded request went to; off by default, as it reveals topology.
+	InstanceName        string
+	ServedByHeader      bool
+	ExposeUpstreamNames bool
+
+	// ServerTiming adds a Server-Timing header with the latency breakdown
+	// (queue, upstream_ttfb, upstream, proxy, total); for streams the
+	// phases unknown at header time follow as a trailer.
+	ServerTiming bool
+
+	// SpillThreshold, when positive, moves non-stream response bodies larger
+	// than this many bytes from memory to a temp file in SpillDir (default
+	// os.TempDir()) while they are relayed. Spilled bodies over SpillMaxBytes
+	// are refused with 502; 0 means no ceiling.
+	SpillThreshold int64
+	SpillDir       string
+	SpillMaxBytes  int64
+
+	// DuplicateSampleRate, when positive, checks this fraction (0–1] of
+	// generate and chat prompts for repeats among the last
+	// DuplicateTrackSize sampled ones (default 10000). Only hashes of the
+	// prompts are kept.
+	DuplicateSampleRate float64
+	DuplicateTrackSize  int
+
+	// ConversationHeader, when set, names a request header (such as
+	// X-Conversation-ID) whose value groups requests into conversations
+	// for the conversation_* metrics. A conversation ends after
+	// ConversationTTL without requests (default 30m); at most
+	// ConversationMax (default 10000) are tracked, the least recently
+	// active ending first when a new one starts.
+	ConversationHeader string
+	ConversationTTL    time.Duration
+	ConversationMax    int
+
+	// SummaryInterval, when positive, logs a per-model rollup of requests,
+	// errors, p50/p95 duration, tokens and bytes at info level every
+	// interval, for deployments without Prometheus.
+	SummaryInterval time.Duration
+
+	// LogDedupWindow, when positive, collapses repeated error lines, the
+	// same message, endpoint and error class, into the first one and a line
+	// with a repeat count per window; the rest count in
+	// log_lines_suppressed_total.
+	LogDedupWindow time.Duration
+
+	// OOMCooldown, when positive, turns upstream 5xx errors saying the
+	// model does not fit in memory into 503s with Retry-After, and fails
+	// further requests for that model fast for this long.
+	OOMCooldown time.Duration
+
+	// NegativeCacheTTL, when positive, remembers for this long that the
+	// upstream answered 404 "model not found" for a model, and answers
+	// further requests for it with the same 404 locally. A pull, create or
+	// copy of the model through the proxy forgets it at once.
+	NegativeCacheTTL time.Duration
+
+	// ReadOnly refuses requests that change the upstream's models or
+	// publish one (pull, push, create, delete, copy and blob uploads) with
+	// 403.
+	ReadOnly bool
+
+	// RequirePinnedModels refuses requests whose model has no tag, or the
+	// mutable latest, with 400; a digest or any other tag pins it.
+	// PinnedModelsExempt lists models allowed unpinned, matched without
+	// their tag and registry.
+	RequirePinnedModels bool
+	PinnedModelsExempt  []string
+
+	// SLOTargets are the service-level objectives requests are counted
+	// against; SetSLOTargets replaces them at runtime.
+	SLOTargets []SLOTarget
+
+	// APIKeys, when set, are required of every proxied request, as a bearer
+	// token or in X-Api-Key; each key's name is the client label of the
+	// request and token metrics. SetAPIKeys replaces them at runtime.
+	APIKeys []APIKey
+
+	// LatencyWindow is the sliding window over which /stats reports the
+	// p50/p95/p99 request duration and time to first token of each model,
+	// from quantile sketches kept apart from the histograms. 0 disables it.
+	LatencyWindow time.Duration
+
+	// MaxLabelLength caps the length of label values taken from requests
+	// (model and tenant); longer ones are shortened and end in a
+	// hash. Control characters and invalid UTF-8 are dropped regardless.
+	// 0 leaves lengths alone.
+	MaxLabelLength int
+
+	// ValidateUpstream checks 2xx upstream responses against what their
+	// endpoint promises (Content-Type, a final done, /v1 usage) and records
+	// deviations, for testing forks and older Ollama versions. Responses
+	// are relayed unchanged either way.
+	ValidateUpstream bool
+
+	// UnloadKeepAliveOverride, when set, replaces a keep_alive of 0 in
+	// requests with this value (such as "5m"), so clients cannot unload
+	// models for everyone. Unloads are counted either way.
+	UnloadKeepAliveOverride string
+
+	// UpstreamTokens maps upstream hosts (host:port as in the upstream URL)
+	// to a bearer token sent as Authorization instead of the client's, for
+	// upstreams such as ollama.com that need an API key. It follows
+	// runtime upstream switches.
+	UpstreamTokens map[string]string
+
+	// UpstreamAuthPassthrough lists the upstream hosts (host:port) that get
+	// the client's own Authorization header; every other upstream without
+	// an UpstreamTokens entry gets none.
+	UpstreamAuthPassthrough []string
+
+	// TokenPrices prices tokens per million by upstream host:port, or by
+	// host:port/model for models priced apart, for token_cost_total; see
+	// ParseTokenPrices. Upstreams without an entry cost nothing.
+	TokenPrices map[string]TokenPrice
+
+	// UpstreamPathPrefix is put before every forwarded endpoint, after the
+	// upstream URL's own path, for an Ollama behind a gateway that serves it
+	// under a path such as /llm/ollama.
+	UpstreamPathPrefix string
+
+	// Backends, when set, are Ollama base URLs that requests naming a model
+	// are spread over instead of the upstream, each model consistently on
+	// one backend so it is loaded on one box only. When a model's backend
+	// fails its /api/version health probe (every BackendHealthInterval,
+	// default 10s) its requests go to the next backend in its order until
+	// it is back. Models in AffinityEverywhere are spread round-robin over
+	// all healthy backends instead. Requests without a model and the
+	// proxy's own metadata fetches still go to the upstream.
+	Backends              []*url.URL
+	AffinityEverywhere    []string
+	BackendHealthInterval time.Duration
+
+	// ExtraEndpoints are routes, beyond Ollama's own, whose paths get their
+	// own endpoint label; every other path is labelled "other". A {name}
+	// segment matches any one segment and a final {name...} the rest of the
+	// path; see ParseEndpoints.
+	ExtraEndpoints []string
+
+	// ModelLabelMode normalizes the model label of request metrics, so
+	// that clients naming one model differently share its series: raw (or
+	// "") keeps the name as sent, strip-latest drops a :latest tag and base
+	// any tag or digest. Behaviour keyed by model, such as queueing, the
+	// stored records and logs, keeps the name as sent. With
+	// ModelLabelAllowlist, in the same normalized form, every other model is
+	// labelled "other".
+	ModelLabelMode      string
+	ModelLabelAllowlist []string
+
+	// NoInspectEndpoints lists request paths whose content the proxy never
+	// reads: the body is streamed upstream and the response back as they
+	// come, nothing is parsed, buffered, stored or logged beyond sizes and
+	// timings, and registered request hooks do not run. Their metrics have
+	// model "uninspected", the stream label is taken from the response and
+	// no token counts exist; the read-only mode and the request rate,
+	// budget and tokens-per-minute limits still apply.
+	NoInspectEndpoints []string
+
+	// PSScrapeInterval, when positive, is how often the upstream's /api/ps
+	// is scraped into the loaded_model gauges, an exporter of what Ollama
+	// holds in memory.
+	PSScrapeInterval time.Duration
+
+	// TagsScrapeInterval, when positive, is how often the upstream's
+	// /api/tags is scraped into the model_info and model_size_bytes gauges,
+	// so that a model going missing can be alerted on.
+	TagsScrapeInterval time.Duration
+
+	// ReadyMaxInFlight and ReadyMaxQueueWait make /readyz answer 503 while
+	// more requests than ReadyMaxInFlight are in flight, or while the oldest
+	// request queued by MaxConcurrentPerModel has waited longer than
+	// ReadyMaxQueueWait; 0 disables each. The proxy is ready again once the
+	// load is at or below ReadyResumeInFlight and ReadyResumeQueueWait,
+	// three quarters of the high-water marks when 0, so that a load hovering
+	// at a mark does not take it in and out of a load balancer.
+	ReadyMaxInFlight     int
+	ReadyResumeInFlight  int
+	ReadyMaxQueueWait    time.Duration
+	ReadyResumeQueueWait time.Duration
+
+	// ReadyUpstreamTimeout, when positive, makes /readyz answer 503 while
+	// the upstream does not answer GET /api/version within it. A probe's
+	// result is reused for ReadyUpstreamTTL, so load balancer probes do not
+	// each reach Ollama; the readiness worker probes that often too, which
+	// keeps upstream_up current.
+	ReadyUpstreamTimeout time.Duration
+	ReadyUpstreamTTL     time.Duration
+}
+
 // Handler is the proxy HTTP handler.
 type Handler struct {
-	upstream   *url.URL
+	upstream   atomic.Pointer[upstreamState]
+	upstreamMu sync.Mutex // serialises setUpstream
 	httpClient *http.Client
-	store      *db.Store
-	logger     *slog.Logger
-	metrics    *Metrics
+	// upstreamClients carry ResponseHeaderTimeout per endpoint class.
+	upstreamClients map[string]*http.Client
+	store           *db.Store
+	logger          *slog.Logger
+	metrics         *Metrics
+	cfg             Config
+	cache           *responseCache // nil when MetadataCacheTTL is 0
+	showCache       *responseCache // nil when ShowCacheTTL is 0
+	shared          kv.Store
+	ownsShared      bool                 // shared was created by New and is closed by Close
+	limiter         *rateLimiter         // nil when RateLimit is 0
+	quota           *quotaTracker        // nil when TokenBudget is 0
+	canary          *canary              // nil when no canary models are configured
+	gate            *admissionGate       // nil when MaxConcurrentPerModel is 0
+	tenants         *tenantSlots         // nil when no tenant concurrency limit is set
+	tpm             *tpmLimiter          // nil when TPMLimit is 0
+	duplicates      *duplicateDetector   // nil when DuplicateSampleRate is 0
+	conversations   *conversationTracker // nil without ConversationHeader
+	summary         *summaryLogger       // nil when SummaryInterval is 0
+	dedup           *logDeduper          // nil when LogDedupWindow is 0
+	backends        *backendPool         // nil without Backends
+	scrapers        []*scraper           // of /api/ps and /api/tags, as configured
+	workers         *workers
+
+	maintenance    *maintenanceSet
+	pinnedExempt   map[string]struct{} // by modelRepo
+	routes         *endpointRoutes
+	modelAllowlist map[string]struct{} // nil without ModelLabelAllowlist
+	ready          readiness
+	upCheck        upstreamCheck
+	oom            oomCooldowns
+	missing        missingModels
+	contextWindows contextWindows
+	malformed      malformedTracker
+	lastErrors     lastErrors
+	conformance    conformanceTracker
+	slos           atomic.Pointer[[]SLOTarget]
+	apiKeys        atomic.Pointer[apiKeySet]
+	latency        *latencyTracker
+
+	inflightMu sync.Mutex
+	inflight   map[string]int // model → requests in flight
+
+	hooksMu sync.Mutex // serialises Use
+	hooks   atomic.Pointer[Hooks]
+	builtin Hooks
+
+	drain *drainState
+}
+
+// reqInfo carries the per-request facts shared by the handler's helpers once
+// the request payload has been parsed.
+type reqInfo struct {
+	r           *http.Request
+	id          string
+	sessionID   string
+	clientIP    string
+	clientName  string // name of the API key the request was admitted with
+	tenant      string // see tenantOf
+	rawLabels   []any  // log attrs of request-derived labels before sanitizing
+	endpoint    string
+	model       string
+	modelLabel  string // model as metrics label it, per ModelLabelMode
+	streamLabel string
+	start       time.Time
+	received    time.Time // when the body was read; duration metrics count from here
+	reqBytes    int64
+	promptText  string
+	think       thinkOption
+	queueWait   time.Duration
+	// includeUsage is a /v1 stream's stream_options.include_usage.
+	includeUsage bool
+
+	admission       string // admission decision, once one was made
+	admissionReason string // policy rejection reason of a rejected request
+
+	upstream      *url.URL // chosen when the request arrived
+	upstreamLabel string
+
+	upstreamStart time.Time     // when the upstream request was sent
+	upstreamTTFB  time.Duration // until its response headers arrived
+	upstreamDone  time.Time     // when its body was read to the end
+
+	tpm         *tpmReservation   // tokens-per-minute claim, settled by settleTPM
+	forwarded   bool              // an upstream (or cached) response was obtained
+	tokens      int64             // prompt+completion tokens reported by Ollama
+	tokensKnown bool              // whether the response carried token counts
+	estimates   promptEstimates   // prompt tokens estimated at admission
+	transfer    *transferProgress // progress of a pull or push
+
+	client      *clientWriter // the client leg's response writer
+	upstreamOut int64         // request body bytes sent upstream
+	upstreamIn  *upstreamBody // nil unless the response came from the upstream
 }
 
 // New creates a new proxy Handler.
-func New(upstream *url.URL, store *db.Store, logger *slog.Logger, metrics *Metrics) *Handler {
-	return &Handler{
-		upstream: upstream,
+func New(upstream *url.URL, store *db.Store, logger *slog.Logger, metrics *Metrics, cfg Config) *Handler {
+	h := &Handler{
 		httpClient: &http.Client{
 			// No overall timeout – long/streaming requests need an open connection.
-			Timeout: 0,
+			Timeout:   0,
+			Transport: newTransport(cfg),
 		},
-		store:   store,
-		logger:  logger,
-		metrics: metrics,
+		store:       store,
+		logger:      logger,
+		metrics:     metrics,
+		cfg:         cfg,
+		inflight:    map[string]int{},
+		maintenance: &maintenanceSet{models: map[string]proxyapi.Maintenance{}},
+		oom:         oomCooldowns{until: map[string]time.Time{}},
+		missing:     missingModels{byName: map[string]missingModel{}},
+		contextWindows: contextWindows{
+			byModel:  map[string]contextWindow{},
+			fetching: map[string]bool{},
+		},
+
+		upstreamClients: newUpstreamClients(cfg),
+		workers:         newWorkers(metrics, logger),
+		drain:           newDrainState(),
+	}
+	h.setUpstream(upstream)
+	h.routes = newEndpointRoutes(cfg.ExtraEndpoints)
+	if len(cfg.ModelLabelAllowlist) > 0 {
+		h.modelAllowlist = map[string]struct{}{}
+		for _, m := range cfg.ModelLabelAllowlist {
+			h.modelAllowlist[normalizeModel(cfg.ModelLabelMode, m)] = struct{}{}
+		}
+	}
+	h.ready.reason = readyOK
+	metrics.Ready.WithLabelValues(readyOK).Set(1)
+	h.pinnedExempt = map[string]struct{}{}
+	for _, m := range cfg.PinnedModelsExempt {
+		h.pinnedExempt[modelRepo(m)] = struct{}{}
+	}
+	if cfg.MetadataCacheTTL > 0 {
+		h.cache = newResponseCache(cfg.MetadataCacheTTL)
 	}
+	h.shared = cfg.SharedStore
+	if h.shared == nil {
+		h.shared = kv.NewMemory()
+		h.ownsShared = true
+	}
+	if cfg.RateLimit > 0 {
+		window := cfg.RateLimitWindow
+		if window <= 0 {
+			window = time.Minute
+		}
+		h.limiter = &rateLimiter{store: h.shared, limit: cfg.RateLimit, window: window}
+	}
+	if cfg.TokenBudget > 0 {
+		window, flushEvery := cfg.TokenBudgetWindow, cfg.QuotaFlushInterval
+		if window <= 0 {
+			window = 24 * time.Hour
+		}
+		if flushEvery <= 0 {
+			flushEvery = time.Second
+		}
+		h.quota = newQuotaTracker(h.shared, cfg.TokenBudget, window, flushEvery, metrics.BudgetUsed)
+	}
+	if cfg.ShowCacheTTL > 0 {
+		h.showCache = newResponseCache(cfg.ShowCacheTTL)
+	}
+	if cfg.ConversationHeader != "" {
+		h.conversations = newConversationTracker(h, cfg.ConversationTTL, cfg.ConversationMax)
+	}
+	if cfg.SummaryInterval > 0 {
+		h.summary = newSummaryLogger(h.logger, cfg.SummaryInterval)
+	}
+	if cfg.LogDedupWindow > 0 {
+		h.dedup = newLogDeduper(h, cfg.LogDedupWindow)
+	}
+	if cfg.DuplicateSampleRate > 0 {
+		h.duplicates = newDuplicateDetector(cfg.DuplicateSampleRate, cfg.DuplicateTrackSize)
+	}
+	metrics.CharsPerToken.Set(h.charsPerToken())
+	if cfg.TPMLimit > 0 {
+		h.tpm = &tpmLimiter{store: h.shared, limit: cfg.TPMLimit, window: time.Minute, charsPerToken: h.charsPerToken(),
+			used: newWindowGauge(metrics.TPMUsed)}
+	}
+	if cfg.MaxConcurrentPerModel > 0 {
+		h.gate = newAdmissionGate(cfg.MaxConcurrentPerModel, cfg.QueueTimeout)
+	}
+	if cfg.MaxConcurrentPerTenant > 0 || len(cfg.TenantConcurrency) > 0 {
+		h.tenants = newTenantSlots(cfg.MaxConcurrentPerTenant, cfg.TenantConcurrency, metrics.TenantInFlight)
+	}
+	if len(cfg.Backends) > 0 {
+		h.backends = newBackendPool(h, cfg)
+	}
+	if cfg.PSScrapeInterval > 0 {
+		h.scrapers = append(h.scrapers, h.psScraper(cfg.PSScrapeInterval))
+	}
+	if cfg.TagsScrapeInterval > 0 {
+		h.scrapers = append(h.scrapers, h.tagsScraper(cfg.TagsScrapeInterval))
+	}
+	h.hooks.Store(&Hooks{})
+	h.builtin = h.builtinHooks() // before the canary's first request
+	if len(cfg.CanaryModels) > 0 && cfg.CanaryInterval > 0 {
+		h.canary = newCanary(h, cfg)
+	}
+	h.SetSLOTargets(cfg.SLOTargets)
+	h.SetAPIKeys(cfg.APIKeys)
+	h.latency = newLatencyTracker(cfg.LatencyWindow)
+	h.startWorkers()
+	return h
+}
+
+// startWorkers starts the background components that are configured.
+func (h *Handler) startWorkers() {
+	if h.quota != nil {
+		h.workers.start("quota", h.quota.run)
+	}
+	if h.tpm != nil {
+		h.workers.start("tpm-gauge", h.tpm.used.run)
+	}
+	if h.conversations != nil {
+		h.workers.start("conversations", h.conversations.run)
+	}
+	if h.summary != nil {
+		h.workers.start("summary", h.summary.run)
+	}
+	if h.dedup != nil {
+		h.workers.start("log-dedup", h.dedup.run)
+	}
+	if h.backends != nil {
+		h.workers.start("backends", h.backends.run)
+	}
+	for _, s := range h.scrapers {
+		h.workers.start(s.component, s.run)
+	}
+	if h.cfg.ReadyMaxInFlight > 0 || h.cfg.ReadyMaxQueueWait > 0 || h.cfg.ReadyUpstreamTimeout > 0 {
+		h.workers.start("readiness", h.runReady)
+	}
+	if h.canary != nil {
+		for _, m := range h.canary.models {
+			h.workers.start("canary", func(ctx context.Context) { h.canary.run(ctx, m) })
+		}
+	}
+}
+
+// Close stops background work, then flushes buffered quota consumption and
+// logs the summary of what was recorded since the last one.
+func (h *Handler) Close() error {
+	h.workers.close()
+	if h.quota != nil {
+		h.quota.flush()
+	}
+	if h.summary != nil {
+		h.summary.flush(time.Now())
+	}
+	if h.dedup != nil {
+		h.dedup.flush(time.Now(), true)
+	}
+	if h.ownsShared {
+		return h.shared.Close()
+	}
+	return nil
 }
 
 // ServeHTTP implements http.Handler; proxies /api/* to the upstream Ollama.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	reqID := newRequestID()
+	r, done := h.drain.enter(r)
+	defer done()
+	cw := &clientWriter{ResponseWriter: w}
+	w = cw
+	reqID := h.requestID(r)
+	r = h.withRequestID(w, r, reqID)
+	r, access := h.startAccess(r)
+	if access != nil {
+		defer h.logAccess(cw, r, access, start)
+	}
+	h.setServedBy(w.Header())
+	if r = h.authenticateClient(w, r); r == nil {
+		return
+	}
 	sessionID := extractSessionID(r)
 	clientIP := extractClientIP(r)
-	endpoint := r.URL.Path
+	if h.noInspect(r.URL.Path) {
+		h.limitBody(w, r)
+		h.serveUninspected(cw, r, reqID, start)
+		return
+	}
+	endpoint := h.routes.normalize(r.URL.Path)
 
+	var reqBody requestBody
 	var bodyBuf []byte
 	if r.Body != nil {
 		defer r.Body.Close()
 		var err error
-		bodyBuf, err = io.ReadAll(r.Body)
+		h.limitBody(w, r)
+		reqBody, err = h.readBody(w, r)
+		bodyBuf = reqBody.head
+		h.metrics.RequestRead.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		if isReadTimeout(err) {
+			h.requestReadTimeout(w, r, reqID, sessionID, endpoint, clientIP, start, int64(len(bodyBuf)))
+			return
+		}
+		if isBodyTooLarge(err) {
+			h.requestTooLarge(w, r, reqID, sessionID, endpoint, clientIP, start, int64(len(bodyBuf)))
+			return
+		}
 		if err != nil {
 			http.Error(w, "failed to read request body", http.StatusBadRequest)
 			h.recordError(reqID, sessionID, endpoint, r, start, clientIP,
@@ -172,133 +1636,342 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	received := time.Now()
 
 	var payload requestPayload
-	_ = json.Unmarshal(bodyBuf, &payload) // best-effort
+	var parseErr error
+	if reqBody.streamed() {
+		payload, parseErr = sniffPayload(bodyBuf)
+	} else {
+		parseErr = json.Unmarshal(bodyBuf, &payload) // best-effort
+	}
 
 	promptText := extractPromptText(payload)
-	model := payload.Model
-	if model == "" {
-		model = "unknown"
-	}
-	// /api/embed and /api/embeddings never stream; default to false for those.
-	isEmbedEndpoint := strings.HasSuffix(endpoint, "/api/embed") || strings.HasSuffix(endpoint, "/api/embeddings")
-	var stream bool
-	if isEmbedEndpoint {
-		stream = payload.Stream != nil && *payload.Stream
-	} else {
-		stream = payload.Stream == nil || *payload.Stream // default: true
+	model, cause := requestModel(endpoint, bodyBuf, payload, parseErr)
+	var rawLabels []any
+	if endpoint != r.URL.Path {
+		rawLabels = append(rawLabels, "raw_endpoint", r.URL.Path)
+	}
+	if rawModel := model; rawModel != "" {
+		if model = h.labelValue(labelModel, model); model != rawModel {
+			rawLabels = append(rawLabels, "raw_model", rawModel)
+		}
 	}
+	modelLabel := h.modelLabel(model)
+	if cause != "" {
+		h.metrics.UnknownModelRequests.WithLabelValues(endpoint, cause).Inc()
+	}
+	stream := requestStreams(endpoint, payload.Stream)
 	streamLabel := strconv.FormatBool(stream)
+	defer h.trackInFlight(endpoint, modelLabel, streamLabel)()
 
-	h.metrics.BytesIn.WithLabelValues(endpoint, model, streamLabel).Add(float64(len(bodyBuf)))
+	defer func() {
+		// A streamed body is read as it is forwarded, so count once done.
+		in := float64(reqBody.bytesRead())
+		h.metrics.BytesIn.WithLabelValues(endpoint, modelLabel, streamLabel).Add(in)
+		h.metrics.ClientBytesIn.WithLabelValues(endpoint, modelLabel, streamLabel).Add(in)
+	}()
+	reqBytes := int64(len(bodyBuf))
+	if reqBody.streamed() {
+		reqBytes = max(r.ContentLength, reqBytes) // until the rest is read
+	}
 
-	up := *h.upstream
-	up.Path = strings.TrimRight(up.Path, "/") + endpoint
+	ri := &reqInfo{
+		r:            r,
+		id:           reqID,
+		sessionID:    sessionID,
+		clientIP:     clientIP,
+		clientName:   clientName(r),
+		tenant:       h.tenantOf(r),
+		rawLabels:    rawLabels,
+		endpoint:     endpoint,
+		model:        model,
+		modelLabel:   modelLabel,
+		streamLabel:  streamLabel,
+		start:        start,
+		received:     received,
+		reqBytes:     reqBytes,
+		promptText:   promptText,
+		think:        payload.Think,
+		includeUsage: payload.StreamOptions != nil && payload.StreamOptions.IncludeUsage,
+		transfer:     newTransfer(endpoint),
+		client:       cw,
+	}
+	defer h.countLegs(ri)
+	ri.upstream = h.currentUpstream()
+	ri.upstreamLabel = upstreamLabel(ri.upstream)
+	pr := &ParsedRequest{
+		ID:             reqID,
+		SessionID:      sessionID,
+		ClientIP:       clientIP,
+		Method:         r.Method,
+		Endpoint:       endpoint,
+		Model:          model,
+		Stream:         stream,
+		Header:         r.Header,
+		Body:           bodyBuf,
+		Truncated:      reqBody.streamed(),
+		Upstream:       ri.upstream,
+		ResponseHeader: w.Header(),
+		ri:             ri,
+		payload:        payload,
+	}
+	defer h.settleTPM(ri)
+	if !h.rejectDraining(w, ri) {
+		return
+	}
+	if !h.inspect(w, pr) {
+		return
+	}
+	bodyBuf = pr.Body
+	if pr.Upstream != ri.upstream {
+		ri.upstream = pr.Upstream
+		ri.upstreamLabel = upstreamLabel(ri.upstream)
+	}
+	defer h.beginRequest(model)()
+	release := h.enqueue(w, ri)
+	if release == nil {
+		return
+	}
+	defer release()
+	h.observeContext(ri, contextIn, int64(payload.Context))
+
+	up := h.upstreamEndpoint(ri.upstream, r.URL.Path) // endpoint is a template
 	up.RawQuery = r.URL.RawQuery
 
-	upReq, err := http.NewRequestWithContext(r.Context(), r.Method, up.String(), bytes.NewReader(bodyBuf))
+	upCtx, cancel := h.upstreamContext(r.Context(), stream)
+	defer cancel()
+	var upBody io.Reader = bytes.NewReader(bodyBuf)
+	var forwarded *countingReader // counts a streamed body as it goes upstream
+	if reqBody.streamed() {
+		forwarded = &countingReader{r: io.MultiReader(bytes.NewReader(bodyBuf), reqBody.rest)}
+		upBody = forwarded
+	}
+	upReq, err := http.NewRequestWithContext(h.traceInformational(upCtx, w, ri), r.Method, up.String(), upBody)
 	if err != nil {
 		http.Error(w, "failed to create upstream request", http.StatusInternalServerError)
 		h.recordError(reqID, sessionID, endpoint, r, start, clientIP,
 			http.StatusInternalServerError, int64(len(bodyBuf)), 0, "create upstream req: "+err.Error())
 		return
 	}
-	for k, vals := range r.Header {
-		for _, v := range vals {
-			upReq.Header.Add(k, v)
-		}
+	if forwarded != nil && r.ContentLength >= 0 {
+		// An inspector may have replaced the head.
+		upReq.ContentLength = r.ContentLength - int64(len(reqBody.head)) + int64(len(bodyBuf))
 	}
+	copyEndToEnd(upReq.Header, r.Header)
+	h.setForwarded(upReq, r)
 	if upReq.Header.Get("Content-Type") == "" {
 		upReq.Header.Set("Content-Type", "application/json")
 	}
+	if !h.inspectForward(w, pr, upReq) {
+		return
+	}
 
-	resp, err := h.httpClient.Do(upReq)
+	if mutatesModels(endpoint) {
+		h.forgetMissingModel(endpoint, payload)
+		defer h.invalidateModelCaches(endpoint, payload)
+	}
+
+	var resp *http.Response
+	var cacheResult string
+	for attempt := 1; ; attempt++ {
+		ri.upstreamStart = time.Now()
+		if forwarded != nil {
+			// The sniffed payload is too little to key a cache on.
+			resp, err = h.clientFor(endpoint).Do(upReq)
+		} else {
+			resp, cacheResult, err = h.roundTrip(upReq, endpoint, payload)
+		}
+		ri.upstreamTTFB = time.Since(ri.upstreamStart)
+		if forwarded != nil {
+			break // the body went upstream as it was read and cannot be replayed
+		}
+		next, retry := h.retryUpstream(ri, upReq, attempt, resp, err)
+		if !retry {
+			break
+		}
+		upReq = next
+	}
+	sentBytes := int64(len(bodyBuf))
+	if forwarded != nil {
+		sentBytes, ri.reqBytes = forwarded.n.Load(), reqBody.bytesRead()
+	}
+	if isBodyTooLarge(err) {
+		h.forwardTooLarge(w, ri, "read body: "+err.Error())
+		return
+	}
+	if clientGone(r.Context(), err) {
+		h.clientCanceled(w, ri, cancelHeaders, err)
+		return
+	}
+	if h.nonStreamTimedOut(r.Context(), upCtx, err) {
+		h.nonStreamTimeout(w, ri, nil, "upstream: "+err.Error())
+		return
+	}
+	if kind := upstreamTimeout(r.Context(), err); kind != "" {
+		h.upstreamTimedOut(w, ri, kind, err)
+		return
+	}
 	if err != nil {
-		statusCode := http.StatusBadGateway
-		h.metrics.ReqTotal.WithLabelValues(endpoint, model, strconv.Itoa(statusCode), streamLabel).Inc()
-		h.metrics.ReqDuration.WithLabelValues(endpoint, model, streamLabel).Observe(time.Since(start).Seconds())
-		http.Error(w, "upstream error", statusCode)
-		h.recordError(reqID, sessionID, endpoint, r, start, clientIP,
-			statusCode, int64(len(bodyBuf)), 0, "upstream: "+err.Error())
+		h.badGateway(w, ri, "upstream: "+err.Error())
 		return
 	}
 	defer resp.Body.Close()
+	ri.forwarded = true
+	if servedByUpstream(cacheResult) {
+		sent := upReq.ContentLength
+		if sent < 0 {
+			sent = sentBytes
+		}
+		h.countUpstreamLeg(ri, sent, resp)
+	}
+	if h.interceptOOM(w, ri, resp) {
+		return
+	}
 
-	// Copy response headers.
-	for k, vals := range resp.Header {
-		for _, v := range vals {
-			w.Header().Add(k, v)
+	decompressing := h.shouldDecompress(r, resp)
+	if decompressing {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			h.metrics.DecompressErrors.WithLabelValues(endpoint).Inc()
+			h.badGateway(w, ri, "decompress response: "+err.Error())
+			return
 		}
+		defer zr.Close()
+		resp.Body = zr
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
 	}
-	w.WriteHeader(resp.StatusCode)
+
+	copyEndToEnd(w.Header(), resp.Header)
+	h.setUpstreamHeaders(w.Header(), ri)
 
 	statusLabel := strconv.Itoa(resp.StatusCode)
 
-	if !stream {
-		respBuf, err := io.ReadAll(resp.Body)
+	if !responseStreams(resp.Header, stream) {
+		respBuf, spill, err := h.bufferResponse(reqID, endpoint, resp.Body)
+		ri.upstreamDone = time.Now()
+		if err == nil {
+			// The client gets nothing before the whole body is in.
+			h.observeTTFT(endpoint, modelLabel, time.Since(received))
+		}
+		if spill != nil {
+			defer spill.close() // also when the client goes away mid-send
+		}
 		errMsg := ""
+		if clientGone(r.Context(), err) {
+			h.clientCanceled(w, ri, cancelResponse, err)
+			return
+		}
+		if h.nonStreamTimedOut(r.Context(), upCtx, err) {
+			h.nonStreamTimeout(w, ri, resp.Header, "read response: "+err.Error())
+			return
+		}
+		if errors.Is(err, errResponseTooLarge) {
+			h.upstreamFailed(w, ri, http.StatusBadGateway, "upstream response too large",
+				"upstream: "+err.Error(), "error_type", "response_too_large")
+			return
+		}
+		if err != nil && decompressing {
+			// Never hand the client a truncated body we claim is complete.
+			h.metrics.DecompressErrors.WithLabelValues(endpoint).Inc()
+			h.badGateway(w, ri, "decompress response: "+err.Error())
+			return
+		}
+		if err != nil && respBuf == nil {
+			h.badGateway(w, ri, "read response: "+err.Error())
+			return
+		}
 		if err != nil {
 			errMsg = "read response: " + err.Error()
-			h.logger.Error("reading non-stream response", "request_id", reqID, "error", err)
+			h.logRepeatable(r.Context(), slog.LevelError, "reading non-stream response", endpoint, errMsg, "request_id", reqID, "error", err)
 		}
-
-		var promptTokens, completionTokens int64
-		var respText string
-		var chunk ollamaChunk
-		if json.Unmarshal(respBuf, &chunk) == nil {
-			respText = responseText(chunk)
-			if chunk.PromptEvalCount != nil {
-				promptTokens = *chunk.PromptEvalCount
-				h.metrics.TokensIn.WithLabelValues(endpoint, model).Add(float64(promptTokens))
-			}
-			if chunk.EvalCount != nil {
-				completionTokens = *chunk.EvalCount
-				h.metrics.TokensOut.WithLabelValues(endpoint, model).Add(float64(completionTokens))
+		respSize := int64(len(respBuf))
+		if spill != nil {
+			respSize = spill.size
+		}
+		if resp.StatusCode >= 400 {
+			errBody := respBuf
+			if spill != nil {
+				errBody = spill.head(lastErrorBodyBytes + 1)
 			}
-			if chunk.Done && chunk.PromptEvalCount == nil && chunk.EvalCount == nil {
-				h.logger.Warn("no token counts in response",
-					"request_id", reqID, "endpoint", endpoint, "model", model)
+			h.recordLastError(ri, resp.StatusCode, errBody, "upstream")
+			h.observeNotFound(ri, resp.StatusCode, errBody)
+		}
+
+		var stats ChunkStats
+		switch {
+		case spill != nil:
+			if !observeSpilled(spill.reader(), &stats) {
+				h.logger.Warn("could not extract token counts from spilled response",
+					"request_id", reqID, "endpoint", endpoint, "model", model, "response_bytes", respSize)
 			}
-		} else {
+		case !stats.Observe(respBuf):
 			// Fallback: some Ollama versions return NDJSON even for stream=false.
-			sc := bufio.NewScanner(bytes.NewReader(respBuf))
-			var sawPrompt, sawCompletion bool
-			for sc.Scan() {
-				var c ollamaChunk
-				if json.Unmarshal(sc.Bytes(), &c) != nil {
-					continue
-				}
-				respText += responseText(c)
-				if c.Done {
-					if c.PromptEvalCount != nil {
-						promptTokens = *c.PromptEvalCount
-						sawPrompt = true
-					}
-					if c.EvalCount != nil {
-						completionTokens = *c.EvalCount
-						sawCompletion = true
-					}
-				}
-			}
-			if sawPrompt {
-				h.metrics.TokensIn.WithLabelValues(endpoint, model).Add(float64(promptTokens))
-			}
-			if sawCompletion {
-				h.metrics.TokensOut.WithLabelValues(endpoint, model).Add(float64(completionTokens))
-			}
-			if !sawPrompt && !sawCompletion {
+			lines := &lineObserver{stats: &stats}
+			_, _ = lines.Write(respBuf)
+			_ = lines.Close()
+			if !stats.SawPrompt && !stats.SawCompletion {
 				h.logger.Warn("could not extract token counts from non-stream response",
 					"request_id", reqID, "endpoint", endpoint, "model", model, "response_bytes", len(respBuf))
 			}
+		case stats.Done && !stats.SawPrompt && !stats.SawCompletion:
+			h.logger.Warn("no token counts in response",
+				"request_id", reqID, "endpoint", endpoint, "model", model)
+		}
+		promptTokens, completionTokens := stats.PromptTokens, stats.CompletionTokens
+		respText := stats.Text()
+		h.observeContext(ri, contextOut, stats.ContextTokens)
+		h.observeThinking(ri, &stats)
+		h.observeEstimate(ri, &stats)
+		h.observeUpstreamDurations(ri, &stats)
+		h.checkConformance(ri, resp.StatusCode, resp.Header, stream, &stats, errMsg)
+		if spill == nil {
+			h.observeTransfer(ri, respBuf)
+		}
+		h.finishTransfer(ri, resp.StatusCode, errMsg)
+		if stats.SawPrompt {
+			h.metrics.TokensIn.WithLabelValues(endpoint, modelLabel, ri.upstreamLabel, ri.clientName).Add(float64(promptTokens))
 		}
+		if stats.SawCompletion {
+			h.metrics.TokensOut.WithLabelValues(endpoint, modelLabel, ri.upstreamLabel, ri.clientName).Add(float64(completionTokens))
+		}
+		h.observeCost(ri, endpoint, modelLabel, promptTokens, completionTokens)
 
-		_, _ = w.Write(respBuf)
+		out := respBuf
+		if spill == nil && len(respBuf) >= h.cfg.CompressMinBytes && h.canCompress(r, resp.Header) {
+			if gz := gzipBytes(respBuf); len(gz) < len(respBuf) {
+				out = gz
+				setGzipHeaders(w.Header())
+				h.metrics.GzipSaved.WithLabelValues(endpoint).Add(float64(len(respBuf) - len(gz)))
+			}
+		}
+		h.serverTimingHead(w.Header(), ri, false)
+		if h.cfg.ServerTiming {
+			w.Header().Add(headerServerTiming, serverTimingTotals(ri, time.Now()))
+		}
+		written := int64(len(out))
+		if spill != nil {
+			written = spill.size
+		}
+		setContentLength(w.Header(), responseLength(r.Method, resp.StatusCode, resp.ContentLength, written))
+		if spill != nil {
+			w.WriteHeader(resp.StatusCode)
+			if _, err := io.Copy(w, spill.reader()); err != nil && errMsg == "" {
+				errMsg = "write to client: " + err.Error()
+			}
+		} else {
+			w.WriteHeader(resp.StatusCode)
+			_, _ = w.Write(out)
+		}
 
-		duration := time.Since(start)
-		h.metrics.BytesOut.WithLabelValues(endpoint, model, streamLabel).Add(float64(len(respBuf)))
-		h.metrics.ReqTotal.WithLabelValues(endpoint, model, statusLabel, streamLabel).Inc()
-		h.metrics.ReqDuration.WithLabelValues(endpoint, model, streamLabel).Observe(duration.Seconds())
+		now := time.Now()
+		duration, served := now.Sub(start), now.Sub(received)
+		h.metrics.BytesOut.WithLabelValues(endpoint, modelLabel, streamLabel).Add(float64(respSize))
+		h.metrics.ReqTotal.WithLabelValues(endpoint, modelLabel, statusLabel, streamLabel, originUpstream, ri.upstreamLabel, ri.clientName).Inc()
+		h.observeDuration(endpoint, modelLabel, streamLabel, ri.clientName, statusClass(statusLabel, errMsg), h.observePhases(ri, now), stats.LoadDuration)
+		h.observeApdex(endpoint, modelLabel, served, resp.StatusCode >= 500 || errMsg != "")
+		h.observeSLO(ri, originUpstream, resp.StatusCode >= 500 || errMsg != "", served)
 
 		rec := db.RequestRecord{
 			RequestID:        reqID,
@@ -310,8 +1983,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			Stream:           false,
 			StatusCode:       resp.StatusCode,
 			DurationMS:       duration.Milliseconds(),
-			RequestBytes:     int64(len(bodyBuf)),
-			ResponseBytes:    int64(len(respBuf)),
+			RequestBytes:     sentBytes,
+			ResponseBytes:    respSize,
 			PromptTokens:     promptTokens,
 			CompletionTokens: completionTokens,
 			TotalTokens:      promptTokens + completionTokens,
@@ -320,66 +1993,150 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			UserAgent:        r.UserAgent(),
 			PromptText:       promptText,
 			ResponseText:     respText,
+			Upstream:         ri.upstreamLabel,
 		}
-		h.persistAndLog(rec)
+		h.persistAndLog(ri.r.Context(), rec, h.logAttrs(ri)...)
+		h.consume(ri, rec.TotalTokens, stats.SawPrompt || stats.SawCompletion)
 		return
 	}
 
 	// Read line-by-line so we can:
 	//  - forward each chunk to the client immediately (true streaming), and
 	//  - extract token counts from the final chunk (done=true).
-	flusher, canFlush := w.(http.Flusher)
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 1<<20), 1<<20) // up to 1 MB per line
+	// The ResponseController finds the Flusher of middleware-wrapped writers
+	// too; without one, chunks sit in net/http's write buffer.
+	rc := http.NewResponseController(w)
+	var out io.Writer = w
+	var zw *gzip.Writer
+	var wire *countingWriter
+	if h.canCompress(r, resp.Header) {
+		// Sync-flush after every line so compression never delays a chunk.
+		setGzipHeaders(w.Header())
+		wire = &countingWriter{w: w}
+		zw = gzip.NewWriter(wire)
+		out = zw
+	}
+	h.serverTimingHead(w.Header(), ri, true)
+	// No Content-Length: the body may gain a final newline, so chunked
+	// encoding it is. X-Accel-Buffering keeps nginx and similar reverse
+	// proxies in front from collecting chunks.
+	w.Header().Set(headerAccelBuffering, "no")
+	w.WriteHeader(resp.StatusCode)
+	_ = rc.Flush() // headers now, not with the first chunk
+
+	// Forward whatever has arrived, a line or up to a buffer of one, and
+	// accumulate response text and token counts from every line on the way.
+	body := bufio.NewReaderSize(resp.Body, 64<<10)
 
 	var totalBytes int64
-	var promptTokens, completionTokens int64
-	var respBuilder strings.Builder
+	var stats ChunkStats
+	var ttft time.Duration
+	var errBody []byte // start of the body of an error response
 	errMsg := ""
-
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		totalBytes += int64(len(line)) + 1 // +1 for the newline we re-add below
-
-		_, writeErr := w.Write(line)
-		_, _ = w.Write([]byte("\n"))
-		if writeErr != nil {
-			errMsg = "write to client: " + writeErr.Error()
-			break
+	lines := &lineObserver{stats: &stats, onLine: func(line []byte, valid bool) {
+		if resp.StatusCode >= 400 && len(errBody) <= lastErrorBodyBytes {
+			errBody = append(append(errBody, line...), '\n')
 		}
-		if canFlush {
-			flusher.Flush()
+		if !valid {
+			h.observeMalformed(ri, line)
+			return
 		}
+		h.observeTransfer(ri, line)
+	}}
 
-		// Accumulate response text and token counts from every chunk.
-		var chunk ollamaChunk
-		if json.Unmarshal(line, &chunk) == nil {
-			respBuilder.WriteString(responseText(chunk))
-			if chunk.Done {
-				if chunk.PromptEvalCount != nil {
-					promptTokens = *chunk.PromptEvalCount
-				}
-				if chunk.EvalCount != nil {
-					completionTokens = *chunk.EvalCount
+	for {
+		piece, readErr := body.ReadSlice('\n')
+		if readErr == bufio.ErrBufferFull {
+			readErr = nil // part of a long line
+		}
+		if readErr != nil && len(piece) > 0 && piece[len(piece)-1] != '\n' {
+			piece = append(piece[:len(piece):len(piece)], '\n') // terminate the final line
+		}
+		if len(piece) > 0 {
+			if ttft == 0 {
+				ttft = time.Since(received)
+				h.observeTTFT(endpoint, modelLabel, ttft)
+			}
+			totalBytes += int64(len(piece))
+			_, writeErr := out.Write(piece)
+			if zw != nil && writeErr == nil {
+				writeErr = zw.Flush()
+			}
+			if writeErr != nil {
+				errMsg = "write to client: " + writeErr.Error()
+				break
+			}
+			_ = rc.Flush()
+			_, _ = lines.Write(piece)
+		}
+		if readErr != nil {
+			ri.upstreamDone = time.Now()
+			if readErr != io.EOF {
+				errMsg = "read stream: " + readErr.Error()
+				if decompressing && !clientGone(r.Context(), readErr) {
+					h.metrics.DecompressErrors.WithLabelValues(endpoint).Inc()
 				}
 			}
+			break
+		}
+	}
+	_ = lines.Close()
+	if zw != nil {
+		_ = zw.Close()
+		if saved := totalBytes - wire.n; saved > 0 { // short streams can grow
+			h.metrics.GzipSaved.WithLabelValues(endpoint).Add(float64(saved))
 		}
 	}
-	if err := scanner.Err(); err != nil && errMsg == "" {
-		errMsg = "scan stream: " + err.Error()
+	// A client gone mid-stream ends it with a failed write, or a failed read
+	// once the canceled context aborted the upstream request.
+	origin, attrs := originUpstream, h.logAttrs(ri)
+	canceled := errMsg != "" && errors.Is(r.Context().Err(), context.Canceled)
+	switch {
+	case canceled && shutDown(r.Context()):
+		origin, statusLabel = originProxy, statusShutdown
+		attrs = append(attrs, "cancel_phase", cancelStream)
+	case canceled:
+		origin, statusLabel = originProxy, statusCanceled
+		attrs = append(attrs, "cancel_phase", cancelStream)
+		h.metrics.ClientCancellations.WithLabelValues(endpoint, cancelStream).Inc()
+	}
+	if resp.StatusCode >= 400 {
+		h.recordLastError(ri, resp.StatusCode, errBody, "upstream")
+		h.observeNotFound(ri, resp.StatusCode, errBody)
+	}
+	if h.cfg.ServerTiming {
+		w.Header().Set(headerServerTiming, serverTimingTotals(ri, time.Now())) // sent as the declared trailer
 	}
 
+	promptTokens, completionTokens := stats.PromptTokens, stats.CompletionTokens
+	h.observeContext(ri, contextOut, stats.ContextTokens)
+	h.observeThinking(ri, &stats)
+	h.observeEstimate(ri, &stats)
+	h.observeUpstreamDurations(ri, &stats)
+	if !canceled {
+		h.checkConformance(ri, resp.StatusCode, resp.Header, stream, &stats, errMsg)
+	}
+	h.finishTransfer(ri, resp.StatusCode, errMsg)
 	if promptTokens > 0 {
-		h.metrics.TokensIn.WithLabelValues(endpoint, model).Add(float64(promptTokens))
+		h.metrics.TokensIn.WithLabelValues(endpoint, modelLabel, ri.upstreamLabel, ri.clientName).Add(float64(promptTokens))
 	}
 	if completionTokens > 0 {
-		h.metrics.TokensOut.WithLabelValues(endpoint, model).Add(float64(completionTokens))
+		h.metrics.TokensOut.WithLabelValues(endpoint, modelLabel, ri.upstreamLabel, ri.clientName).Add(float64(completionTokens))
 	}
+	h.observeCost(ri, endpoint, modelLabel, promptTokens, completionTokens)
 
-	duration := time.Since(start)
-	h.metrics.BytesOut.WithLabelValues(endpoint, model, streamLabel).Add(float64(totalBytes))
-	h.metrics.ReqTotal.WithLabelValues(endpoint, model, statusLabel, streamLabel).Inc()
-	h.metrics.ReqDuration.WithLabelValues(endpoint, model, streamLabel).Observe(duration.Seconds())
+	now := time.Now()
+	duration, served := now.Sub(start), now.Sub(received)
+	h.metrics.BytesOut.WithLabelValues(endpoint, modelLabel, streamLabel).Add(float64(totalBytes))
+	h.metrics.ReqTotal.WithLabelValues(endpoint, modelLabel, statusLabel, streamLabel, origin, ri.upstreamLabel, ri.clientName).Inc()
+	h.observeDuration(endpoint, modelLabel, streamLabel, ri.clientName, statusClass(statusLabel, errMsg), h.observePhases(ri, now), stats.LoadDuration)
+	if ttft == 0 {
+		ttft = served // no chunk arrived; the user waited the whole time
+	}
+	h.observeApdex(endpoint, modelLabel, ttft, resp.StatusCode >= 500 || errMsg != "")
+	if !canceled {
+		h.observeSLO(ri, originUpstream, resp.StatusCode >= 500 || errMsg != "", served)
+	}
 
 	rec := db.RequestRecord{
 		RequestID:        reqID,
@@ -391,7 +2148,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Stream:           true,
 		StatusCode:       resp.StatusCode,
 		DurationMS:       duration.Milliseconds(),
-		RequestBytes:     int64(len(bodyBuf)),
+		RequestBytes:     sentBytes,
 		ResponseBytes:    totalBytes,
 		PromptTokens:     promptTokens,
 		CompletionTokens: completionTokens,
@@ -400,19 +2157,47 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		ClientIP:         clientIP,
 		UserAgent:        r.UserAgent(),
 		PromptText:       promptText,
-		ResponseText:     respBuilder.String(),
+		ResponseText:     stats.Text(),
+		Upstream:         ri.upstreamLabel,
+	}
+	h.persistAndLog(ri.r.Context(), rec, attrs...)
+	h.consume(ri, rec.TotalTokens, stats.SawPrompt || stats.SawCompletion)
+}
+
+// logAttrs are what a request line tells beyond the request's record: where
+// its time went and how it was admitted.
+func (h *Handler) logAttrs(ri *reqInfo) []any {
+	attrs := []any{"read_ms", ri.received.Sub(ri.start).Milliseconds(), "queue_wait_ms", ri.queueWait.Milliseconds()}
+	if ri.clientName != "" {
+		attrs = append(attrs, "client", ri.clientName)
 	}
-	h.persistAndLog(rec)
+	if !ri.upstreamStart.IsZero() {
+		attrs = append(attrs, "upstream_ms", time.Since(ri.upstreamStart).Milliseconds())
+	}
+	attrs = append(attrs, legAttrs(ri)...)
+	attrs = append(attrs, ri.rawLabels...)
+	return append(attrs, h.admissionAttrs(ri)...)
 }
 
-// persistAndLog writes the record to SQLite and emits a structured log line.
-func (h *Handler) persistAndLog(rec db.RequestRecord) {
-	if err := h.store.InsertRequest(rec); err != nil {
-		h.logger.Error("failed to persist request record",
+// persistAndLog writes the record to SQLite and emits a structured log line,
+// with attrs appended to the line.
+func (h *Handler) persistAndLog(ctx context.Context, rec db.RequestRecord, attrs ...any) {
+	rec.ServedBy = h.cfg.InstanceName
+	noteAccess(ctx, rec)
+	err := h.store.InsertRequest(rec)
+	if errors.Is(err, db.ErrDuplicateRequestID) {
+		// A client reused its request ID, on a retry say: the row gets an
+		// ID of its own, the log line keeps the client's.
+		dup := rec
+		dup.RequestID += "-" + newRequestID()[:8]
+		err = h.store.InsertRequest(dup)
+	}
+	if err != nil {
+		h.logRepeatable(ctx, slog.LevelError, "failed to persist request record", rec.Endpoint, err.Error(),
 			"request_id", rec.RequestID, "error", err)
 	}
 
-	h.logger.Info("request",
+	args := []any{
 		"request_id", rec.RequestID,
 		"session_id", rec.SessionID,
 		"endpoint", rec.Endpoint,
@@ -429,7 +2214,105 @@ func (h *Handler) persistAndLog(rec db.RequestRecord) {
 		"client_ip", rec.ClientIP,
 		"user_agent", rec.UserAgent,
 		"error", rec.ErrorMessage,
-	)
+		"served_by", rec.ServedBy,
+		"upstream", rec.Upstream,
+	}
+	if rec.ErrorMessage != "" {
+		h.logRepeatable(ctx, slog.LevelInfo, "request", rec.Endpoint, rec.ErrorMessage, append(args, attrs...)...)
+	} else {
+		h.logger.Info("request", append(args, attrs...)...)
+	}
+	h.observe(ctx, rec)
+}
+
+// observeDuration records a request's wall time in the raw duration histogram
+// and, less the model load time reported by Ollama, in the adjusted one. A
+// failed request, of a non-empty statusClass, also goes in the error one.
+func (h *Handler) observeDuration(endpoint, model, streamLabel, client, class string, d, load time.Duration) {
+	h.metrics.ReqDuration.WithLabelValues(endpoint, model, streamLabel, client).Observe(d.Seconds())
+	if class != "" {
+		h.metrics.ErrorDuration.WithLabelValues(endpoint, model, class).Observe(d.Seconds())
+	}
+	h.metrics.ReqDurationAdjusted.WithLabelValues(endpoint, model, streamLabel).Observe(max(d-load, 0).Seconds())
+	h.latency.observe(model, time.Now(), d, false)
+}
+
+// observeTTFT records the time a request waited for its first response
+// bytes.
+func (h *Handler) observeTTFT(endpoint, model string, d time.Duration) {
+	h.metrics.TTFT.WithLabelValues(endpoint, model).Observe(d.Seconds())
+	h.latency.observe(model, time.Now(), d, true)
+}
+
+// badGateway answers with 502 when no usable upstream response is available
+// and records the failed request.
+func (h *Handler) badGateway(w http.ResponseWriter, ri *reqInfo, errMsg string) {
+	h.upstreamFailed(w, ri, http.StatusBadGateway, "upstream error", errMsg)
+}
+
+// upstreamFailed answers with statusCode when the upstream gave no usable
+// response; attrs are added to the request's log line.
+func (h *Handler) upstreamFailed(w http.ResponseWriter, ri *reqInfo, statusCode int, text, errMsg string, attrs ...any) {
+	h.countProxyStatus(ri, statusCode)
+	h.observeDuration(ri.endpoint, ri.modelLabel, ri.streamLabel, ri.clientName, statusClass(strconv.Itoa(statusCode), ""), time.Since(ri.received), 0)
+	h.observeApdex(ri.endpoint, ri.modelLabel, time.Since(ri.received), true)
+	http.Error(w, text, statusCode)
+	h.recordLastError(ri, statusCode, []byte(errMsg), "proxy")
+	h.recordFailure(ri, statusCode, errMsg, attrs...)
+}
+
+// reject answers a request the proxy refuses to forward with a JSON error
+// body, counts it under the policy reason and records it. A non-zero retryAt
+// sets Retry-After.
+func (h *Handler) reject(w http.ResponseWriter, ri *reqInfo, reason string, status int, retryAt time.Time, body map[string]any) {
+	h.metrics.PolicyRejections.WithLabelValues(reason).Inc()
+	ri.admission, ri.admissionReason = admissionRejected, reason
+	body["reason"] = reason
+	if !retryAt.IsZero() {
+		secs := int(math.Ceil(time.Until(retryAt).Seconds()))
+		if secs < 1 {
+			secs = 1
+		}
+		w.Header().Set(headerRetryAfter, strconv.Itoa(secs))
+		body["retry_after_seconds"] = secs
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+	h.countProxyStatus(ri, status)
+	h.recordFailure(ri, status, fmt.Sprint(body["error"]))
+}
+
+// Values of the origin label of ollama_proxy_requests_total.
+const (
+	originUpstream = "upstream"
+	originProxy    = "proxy"
+)
+
+// countProxyStatus counts a request the proxy answered itself.
+func (h *Handler) countProxyStatus(ri *reqInfo, status int) {
+	h.metrics.ReqTotal.WithLabelValues(ri.endpoint, ri.modelLabel, strconv.Itoa(status), ri.streamLabel, originProxy, ri.upstreamLabel, ri.clientName).Inc()
+	h.observeSLO(ri, originProxy, status >= 500, time.Since(ri.received))
+}
+
+// recordFailure persists and logs a request that ended without an upstream
+// response.
+func (h *Handler) recordFailure(ri *reqInfo, statusCode int, errMsg string, attrs ...any) {
+	h.persistAndLog(ri.r.Context(), db.RequestRecord{
+		RequestID:    ri.id,
+		SessionID:    ri.sessionID,
+		Timestamp:    ri.start,
+		Endpoint:     ri.endpoint,
+		Method:       ri.r.Method,
+		Model:        ri.model,
+		StatusCode:   statusCode,
+		DurationMS:   time.Since(ri.start).Milliseconds(),
+		RequestBytes: ri.reqBytes,
+		ErrorMessage: errMsg,
+		ClientIP:     ri.clientIP,
+		UserAgent:    ri.r.UserAgent(),
+		Upstream:     ri.triedUpstream(),
+	}, append(h.logAttrs(ri), attrs...)...)
 }
 
 // recordError is a convenience helper for early-exit error paths.
@@ -449,7 +2332,7 @@ func (h *Handler) recordError(
 		Timestamp:     start,
 		Endpoint:      endpoint,
 		Method:        r.Method,
-		Model:         "unknown",
+		Model:         modelUnknown,
 		Stream:        false,
 		StatusCode:    statusCode,
 		DurationMS:    duration.Milliseconds(),
@@ -459,7 +2342,8 @@ func (h *Handler) recordError(
 		ClientIP:      clientIP,
 		UserAgent:     r.UserAgent(),
 	}
-	h.persistAndLog(rec)
+	h.metrics.ReqTotal.WithLabelValues(endpoint, rec.Model, strconv.Itoa(statusCode), "false", originProxy, upstreamLabel(h.currentUpstream()), clientName(r)).Inc()
+	h.persistAndLog(r.Context(), rec)
 }
 
 // extractSessionID returns the X-Session-ID header value, falling back to the
@@ -8,11 +8,13 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 
 	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
 )
@@ -29,7 +31,12 @@ func openTestDB(t *testing.T) *db.Store {
 
 func newTestHandler(t *testing.T, upstreamURL string) *Handler {
 	t.Helper()
-	u, err := url.Parse(upstreamURL)
+	return newTestHandlerWithConfig(t, upstreamURL, Config{})
+}
+
+func newTestHandlerWithConfig(t *testing.T, upstreamURL string, cfg Config) *Handler {
+	t.Helper()
+	u, err := ParseUpstream(upstreamURL)
 	if err != nil {
 		t.Fatalf("parse upstream url: %v", err)
 	}
@@ -37,7 +44,28 @@ func newTestHandler(t *testing.T, upstreamURL string) *Handler {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	reg := prometheus.NewRegistry()
 	metrics := NewMetrics(reg)
-	return New(u, store, logger, metrics)
+	h := New(u, store, logger, metrics, cfg)
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+// histogramSum returns the sum of observations recorded by a histogram child.
+func histogramSum(t *testing.T, o prometheus.Observer) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("read histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleSum()
+}
+
+func histogramCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("read histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
 }
 
 func TestServeHTTP_NonStream_ProxiesBody(t *testing.T) {
@@ -265,3 +293,66 @@ func TestServeHTTP_PersistsRequestAndResponseBytes(t *testing.T) {
 		t.Errorf("response_bytes mismatch: want %d got %d", len(respPayload), r.ResponseBytes)
 	}
 }
+
+func TestServeHTTP_AdjustedDurationSubtractsLoad(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		// Claims a 20ms load out of the ≥30ms the request took.
+		_, _ = fmt.Fprintln(w, `{"response":"b","done":true,"eval_count":1,"load_duration":20000000}`)
+	}))
+	defer upstream.Close()
+
+	for _, stream := range []string{"true", "false"} {
+		h := newTestHandler(t, upstream.URL)
+		req := httptest.NewRequest(http.MethodPost, "/api/generate",
+			strings.NewReader(`{"model":"m","prompt":"hi","stream":`+stream+`}`))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		raw := histogramSum(t, h.metrics.ReqDuration.WithLabelValues("/api/generate", "m", stream, ""))
+		adj := histogramSum(t, h.metrics.ReqDurationAdjusted.WithLabelValues("/api/generate", "m", stream))
+		if raw < 0.03 {
+			t.Fatalf("stream=%s: raw duration %v shorter than the upstream delay", stream, raw)
+		}
+		if diff := raw - adj; diff < 0.0199 || diff > 0.0201 {
+			t.Errorf("stream=%s: expected adjusted = raw - 20ms, got raw %v adjusted %v", stream, raw, adj)
+		}
+	}
+}
+
+func TestServeHTTP_AdjustedDurationFlooredAtZero(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"response":"a","done":true,"load_duration":3600000000000}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if adj := histogramSum(t, h.metrics.ReqDurationAdjusted.WithLabelValues("/api/generate", "m", "false")); adj != 0 {
+		t.Errorf("expected adjusted duration floored at 0, got %v", adj)
+	}
+}
+
+func TestReqTotal_OriginSeparatesProxyStatuses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway) // Ollama behind another proxy
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	generate(h, "10.0.0.1")
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "502", "false", originUpstream, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the upstream's 502 with origin=upstream, got %v", got)
+	}
+
+	down := newTestHandlerWithConfig(t, "http://127.0.0.1:1", Config{RateLimit: 1, RateLimitWindow: time.Hour})
+	generate(down, "10.0.0.1")
+	generate(down, "10.0.0.1")
+	if got := testutil.ToFloat64(down.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "502", "false", originProxy, upstreamLabel(down.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the proxy's own 502 with origin=proxy, got %v", got)
+	}
+	if got := testutil.ToFloat64(down.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "429", "false", originProxy, upstreamLabel(down.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the rejection with origin=proxy, got %v", got)
+	}
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request priorities, taken from the X-Ollama-Priority header. Queued
+// requests are admitted highest priority first, FIFO within a priority.
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+var priorities = []string{priorityHigh, priorityNormal, priorityLow}
+
+// requestPriority returns the priority a request asked for, defaulting to
+// normal for absent or unknown values.
+func requestPriority(r *http.Request) string {
+	switch p := r.Header.Get("X-Ollama-Priority"); p {
+	case priorityHigh, priorityLow:
+		return p
+	default:
+		return priorityNormal
+	}
+}
+
+// errQueueTimeout is returned by acquire when QueueTimeout elapses first.
+var errQueueTimeout = errors.New("queue timeout")
+
+// admissionGate limits concurrent upstream requests per model. Requests over
+// the limit wait in a per-model queue ordered by priority.
+type admissionGate struct {
+	limit   int
+	timeout time.Duration // 0 = wait as long as the client does
+
+	mu     sync.Mutex
+	models map[string]*modelQueue
+}
+
+type modelQueue struct {
+	active  int
+	waiters [3][]waiter // indexed like priorities
+}
+
+// waiter is a request queued for a slot; closing ready admits it.
+type waiter struct {
+	ready chan struct{}
+	since time.Time
+}
+
+func newAdmissionGate(limit int, timeout time.Duration) *admissionGate {
+	return &admissionGate{limit: limit, timeout: timeout, models: map[string]*modelQueue{}}
+}
+
+func priorityIndex(p string) int {
+	for i, q := range priorities {
+		if q == p {
+			return i
+		}
+	}
+	return 1
+}
+
+// acquire takes a slot for model, waiting if all are busy. It returns how
+// long the request waited. On error no slot is held.
+func (g *admissionGate) acquire(ctx context.Context, model, priority string) (time.Duration, error) {
+	g.mu.Lock()
+	q := g.models[model]
+	if q == nil {
+		q = &modelQueue{}
+		g.models[model] = q
+	}
+	if q.active < g.limit && q.queued() == 0 {
+		q.active++
+		g.mu.Unlock()
+		return 0, nil
+	}
+	start := time.Now()
+	ch := make(chan struct{})
+	idx := priorityIndex(priority)
+	q.waiters[idx] = append(q.waiters[idx], waiter{ready: ch, since: start})
+	g.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if g.timeout > 0 {
+		t := time.NewTimer(g.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	var err error
+	select {
+	case <-ch:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = errQueueTimeout
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, w := range q.waiters[idx] {
+		if w.ready == ch {
+			q.waiters[idx] = append(q.waiters[idx][:i], q.waiters[idx][i+1:]...)
+			return time.Since(start), err
+		}
+	}
+	// release handed us the slot just as we gave up; pass it on.
+	g.releaseLocked(model, q)
+	return time.Since(start), err
+}
+
+// release frees the slot held for model, handing it to the next waiter.
+func (g *admissionGate) release(model string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.releaseLocked(model, g.models[model])
+}
+
+func (g *admissionGate) releaseLocked(model string, q *modelQueue) {
+	for i := range q.waiters {
+		if len(q.waiters[i]) > 0 {
+			w := q.waiters[i][0]
+			q.waiters[i] = q.waiters[i][1:]
+			close(w.ready) // the slot moves to the waiter; active is unchanged
+			return
+		}
+	}
+	if q.active--; q.active == 0 {
+		delete(g.models, model)
+	}
+}
+
+// oldestWait returns how long the request queued longest, of every model,
+// has been waiting at now; 0 when none is.
+func (g *admissionGate) oldestWait(now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var oldest time.Duration
+	for _, q := range g.models {
+		for _, ws := range q.waiters {
+			if len(ws) > 0 && now.Sub(ws[0].since) > oldest {
+				oldest = now.Sub(ws[0].since)
+			}
+		}
+	}
+	return oldest
+}
+
+func (q *modelQueue) queued() int {
+	n := 0
+	for _, w := range q.waiters {
+		n += len(w)
+	}
+	return n
+}
+
+// enqueue takes the request's tenant slot, passes it through the admission
+// gate and records how long it waited, zero when it did not queue or no gate is configured. It returns
+// a release function, or nil after answering the request itself.
+func (h *Handler) enqueue(w http.ResponseWriter, ri *reqInfo) func() {
+	releaseTenant := h.admitTenant(w, ri)
+	if releaseTenant == nil {
+		return nil
+	}
+	priority := requestPriority(ri.r)
+	var wait time.Duration
+	var err error
+	if h.gate != nil {
+		wait, err = h.gate.acquire(ri.r.Context(), ri.model, priority)
+	}
+	if err != nil {
+		releaseTenant()
+	}
+	h.metrics.QueueWait.WithLabelValues(ri.modelLabel, priority).Observe(wait.Seconds())
+	w.Header().Set(headerQueueWait, strconv.FormatInt(wait.Milliseconds(), 10))
+
+	switch {
+	case errors.Is(err, errQueueTimeout):
+		ri.queueWait = wait
+		h.reject(w, ri, reasonQueueTimeout, http.StatusServiceUnavailable, time.Time{}, map[string]any{
+			"error":   "model busy: timed out waiting in the proxy queue",
+			"timeout": h.gate.timeout.String(),
+		})
+		return nil
+	case err != nil:
+		ri.queueWait, ri.admission = wait, admissionAbandoned
+		h.clientCanceled(w, ri, cancelQueue, err)
+		return nil
+	}
+	ri.admit(wait)
+	if h.gate == nil {
+		return releaseTenant
+	}
+	return func() {
+		h.gate.release(ri.model)
+		releaseTenant()
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAdmissionGate_PriorityOrder(t *testing.T) {
+	g := newAdmissionGate(1, 0)
+	ctx := context.Background()
+	if _, err := g.acquire(ctx, "m", priorityNormal); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, 2)
+	for _, p := range []string{priorityLow, priorityHigh} {
+		go func() {
+			if _, err := g.acquire(ctx, "m", p); err == nil {
+				order <- p
+				g.release("m")
+			}
+		}()
+		waitFor(t, p+" to queue", func() bool {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			return len(g.models["m"].waiters[priorityIndex(p)]) == 1
+		})
+	}
+	g.release("m")
+	if first, second := <-order, <-order; first != priorityHigh || second != priorityLow {
+		t.Errorf("expected high before low, got %s, %s", first, second)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.models) != 0 {
+		t.Errorf("expected all slots released, got %+v", g.models["m"])
+	}
+}
+
+func TestAdmissionGate_TimeoutAndCancelReleaseNothing(t *testing.T) {
+	g := newAdmissionGate(1, 10*time.Millisecond)
+	if _, err := g.acquire(context.Background(), "m", priorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.acquire(context.Background(), "m", priorityNormal); !errors.Is(err, errQueueTimeout) {
+		t.Errorf("expected queue timeout, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.acquire(ctx, "m", priorityNormal); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context error, got %v", err)
+	}
+	g.release("m")
+	if wait, err := g.acquire(context.Background(), "m", priorityNormal); err != nil || wait != 0 {
+		t.Errorf("expected free slot after release, got wait=%s err=%v", wait, err)
+	}
+}
+
+func TestServeHTTP_QueueWaitZeroWithoutGate(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	rr := generate(h, "10.0.0.1")
+	if got := rr.Header().Get("X-Ollama-Queue-Wait-Ms"); got != "0" {
+		t.Errorf("expected queue wait header 0, got %q", got)
+	}
+	if n := testutil.CollectAndCount(h.metrics.QueueWait); n != 1 {
+		t.Errorf("expected unqueued request observed, got %d series", n)
+	}
+}
+
+func TestServeHTTP_QueuesOverConcurrencyLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(40 * time.Millisecond)
+		_, _ = fmt.Fprint(w, `{"done":true}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MaxConcurrentPerModel: 1})
+	first := make(chan struct{})
+	go func() {
+		defer close(first)
+		generate(h, "10.0.0.1")
+	}()
+	waitFor(t, "first request in flight", func() bool { return h.busy("m") })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	req.Header.Set("X-Ollama-Priority", "high")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	<-first
+
+	wait, _ := strconv.Atoi(rr.Header().Get("X-Ollama-Queue-Wait-Ms"))
+	if rr.Code != http.StatusOK || wait < 10 {
+		t.Errorf("expected queued request to succeed after waiting, got %d after %dms", rr.Code, wait)
+	}
+	if got := histogramSum(t, h.metrics.QueueWait.WithLabelValues("m", priorityHigh)); got < 0.01 {
+		t.Errorf("expected queue wait recorded under priority high, got %v", got)
+	}
+}
+
+func TestServeHTTP_QueueTimeoutRejects(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = fmt.Fprint(w, `{"done":true}`)
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MaxConcurrentPerModel: 1, QueueTimeout: 10 * time.Millisecond})
+	go generate(h, "10.0.0.1")
+	waitFor(t, "first request in flight", func() bool { return h.busy("m") })
+
+	rr := generate(h, "10.0.0.2")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 on queue timeout, got %d", rr.Code)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonQueueTimeout)); got != 1 {
+		t.Errorf("expected queue_timeout rejection counted, got %v", got)
+	}
+}
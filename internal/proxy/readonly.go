@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// changesModels reports whether a request changes the upstream's models or
+// publishes one: a pull, push, create, delete or copy, or a blob upload for
+// a create.
+func changesModels(method, endpoint string) bool {
+	if mutatesModels(endpoint) || transferOp(endpoint) == transferPush {
+		return true
+	}
+	return method == http.MethodPost && strings.Contains(endpoint, "/api/blobs/")
+}
+
+// inspectReadOnly refuses requests that change models with 403 when the
+// proxy is read-only.
+func (h *Handler) inspectReadOnly(_ context.Context, req *ParsedRequest) error {
+	if !h.cfg.ReadOnly || !changesModels(req.Method, req.Endpoint) {
+		return nil
+	}
+	return &Rejection{
+		Reason:  reasonReadOnly,
+		Status:  http.StatusForbidden,
+		Message: "the proxy is read-only: " + req.Endpoint + " is disabled",
+		Fields:  map[string]any{"endpoint": req.Endpoint},
+	}
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReadOnly_RefusesModelChanges(t *testing.T) {
+	var asked atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked.Add(1)
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	defer upstream.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ReadOnly: true})
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodPost, "/api/push"},
+		{http.MethodPost, "/api/pull"},
+		{http.MethodPost, "/api/create"},
+		{http.MethodDelete, "/api/delete"},
+		{http.MethodPost, "/api/copy"},
+		{http.MethodPost, "/api/blobs/sha256:aaa"},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"model":"m"}`)))
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), reasonReadOnly) {
+			t.Errorf("%s %s: expected 403 read_only, got %d %q", tc.method, tc.path, rr.Code, rr.Body.String())
+		}
+	}
+	if asked.Load() != 0 {
+		t.Errorf("expected nothing forwarded, got %d", asked.Load())
+	}
+	if code := generateModel(h, "m"); code != http.StatusOK {
+		t.Errorf("expected generate allowed, got %d", code)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonReadOnly)); got != 6 {
+		t.Errorf("expected 6 read_only rejections, got %v", got)
+	}
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// Readiness reasons, the reason label of the ready gauge and of /readyz:
+// ok when ready, otherwise why not.
+const (
+	readyOK        = "ok"
+	readyDraining  = "draining"
+	readyUpstream  = "upstream"
+	readyInFlight  = "inflight"
+	readyQueueWait = "queue_wait"
+)
+
+var readyReasons = []string{readyOK, readyDraining, readyUpstream, readyInFlight, readyQueueWait}
+
+// readyInterval is how often the readiness worker re-evaluates the load, so
+// that the ready gauge follows it between probes.
+const readyInterval = time.Second
+
+// readiness holds the current readiness reason. Overload reasons persist
+// until the load is back at its low-water mark.
+type readiness struct {
+	mu     sync.Mutex
+	reason string
+}
+
+// upstreamCheck caches the last probe of the upstream for readiness.
+type upstreamCheck struct {
+	probe sync.Mutex // held while probing, so only one probe runs at a time
+	mu    sync.Mutex
+	at    time.Time // of the last probe, zero before the first
+	label string    // the upstream it probed
+	err   error
+}
+
+// upstreamErr returns why the current upstream failed its last probe, nil
+// when it passed or has not been probed yet.
+func (h *Handler) upstreamErr() error {
+	label := upstreamLabel(h.currentUpstream())
+	c := &h.upCheck
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.label != label {
+		return nil
+	}
+	return c.err
+}
+
+// fresh reports whether c holds a probe of label younger than ttl at now.
+func (c *upstreamCheck) fresh(label string, now time.Time, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.label == label && !c.at.IsZero() && now.Sub(c.at) < ttl
+}
+
+// refreshUpstream probes the upstream's /api/version within
+// ReadyUpstreamTimeout unless the last probe of it is younger than
+// ReadyUpstreamTTL, updating upstream_up and logging a change. While
+// another probe runs it returns at once, leaving the last result to stand,
+// so a slow upstream never stalls readiness checks behind one another.
+func (h *Handler) refreshUpstream(ctx context.Context) {
+	if h.cfg.ReadyUpstreamTimeout <= 0 {
+		return
+	}
+	u := h.currentUpstream()
+	label, c := upstreamLabel(u), &h.upCheck
+	if c.fresh(label, time.Now(), h.cfg.ReadyUpstreamTTL) {
+		return
+	}
+	if !c.probe.TryLock() {
+		return
+	}
+	defer c.probe.Unlock()
+	if c.fresh(label, time.Now(), h.cfg.ReadyUpstreamTTL) {
+		return // another probe finished in between
+	}
+	pctx, cancel := context.WithTimeout(ctx, h.cfg.ReadyUpstreamTimeout)
+	err := h.probeUpstream(pctx, u)
+	cancel()
+	if ctx.Err() != nil {
+		return // the caller went away; the probe says nothing
+	}
+
+	c.mu.Lock()
+	prevLabel, prevErr, probed := c.label, c.err, !c.at.IsZero()
+	c.at, c.label, c.err = time.Now(), label, err
+	c.mu.Unlock()
+	if probed && prevLabel != label {
+		h.metrics.UpstreamUp.DeleteLabelValues(prevLabel)
+		probed = false
+	}
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+	h.metrics.UpstreamUp.WithLabelValues(label).Set(up)
+	switch {
+	case err != nil && (!probed || prevErr == nil):
+		h.logger.Warn("upstream unreachable", "upstream", label, "error", err)
+	case err == nil && probed && prevErr != nil:
+		h.logger.Info("upstream reachable again", "upstream", label)
+	}
+}
+
+// resumeMark returns the low-water mark of the high-water mark high:
+// resume when set, else three quarters of high.
+func resumeMark[T int64 | time.Duration](high, resume T) T {
+	if resume > 0 {
+		return resume
+	}
+	return high * 3 / 4
+}
+
+// checkReady evaluates readiness at now, updating the ready gauge and
+// logging a change.
+func (h *Handler) checkReady(now time.Time) proxyapi.Ready {
+	inflight := h.drain.active.Load()
+	var wait time.Duration
+	if h.gate != nil {
+		wait = h.gate.oldestWait(now)
+	}
+	maxInFlight, maxWait := int64(h.cfg.ReadyMaxInFlight), h.cfg.ReadyMaxQueueWait
+	upErr := h.upstreamErr()
+
+	h.ready.mu.Lock()
+	defer h.ready.mu.Unlock()
+	prev, reason := h.ready.reason, readyOK
+	switch {
+	case h.drain.draining.Load():
+		reason = readyDraining
+	case upErr != nil:
+		reason = readyUpstream
+	case maxInFlight > 0 && inflight > maxInFlight:
+		reason = readyInFlight
+	case maxWait > 0 && wait > maxWait:
+		reason = readyQueueWait
+	case prev == readyInFlight && inflight > resumeMark(maxInFlight, int64(h.cfg.ReadyResumeInFlight)):
+		reason = readyInFlight
+	case prev == readyQueueWait && wait > resumeMark(maxWait, h.cfg.ReadyResumeQueueWait):
+		reason = readyQueueWait
+	}
+	if reason != prev {
+		h.ready.reason = reason
+		h.metrics.Ready.WithLabelValues(prev).Set(0)
+		h.metrics.Ready.WithLabelValues(reason).Set(1)
+		if reason == readyOK {
+			h.logger.Info("ready again", "inflight", inflight, "queue_wait", wait)
+		} else {
+			h.logger.Warn("not ready", "reason", reason, "inflight", inflight, "queue_wait", wait)
+		}
+	}
+	report := proxyapi.Ready{Ready: reason == readyOK, Reason: reason, InFlight: inflight, QueueWaitSeconds: wait.Seconds()}
+	if reason == readyUpstream {
+		report.Error = upErr.Error()
+	}
+	return report
+}
+
+// runReady re-evaluates readiness every readyInterval, probing the
+// upstream as often as ReadyUpstreamTTL allows.
+func (h *Handler) runReady(ctx context.Context) {
+	tick := time.NewTicker(readyInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			h.refreshUpstream(ctx)
+			h.checkReady(now)
+		}
+	}
+}
+
+// ServeReady is a readiness probe: 200 while the proxy takes requests, 503
+// once it drains, with ReadyUpstreamTimeout while the upstream does not
+// answer /api/version and, with ReadyMaxInFlight or ReadyMaxQueueWait,
+// while it is overloaded. The JSON body gives the reason, the load it is
+// based on and, for the upstream, what failed.
+func (h *Handler) ServeReady(w http.ResponseWriter, r *http.Request) {
+	if !h.drain.draining.Load() {
+		h.refreshUpstream(r.Context())
+	}
+	report := h.checkReady(time.Now())
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeAdminJSON(w, status, report)
+}
+
+// ServeHealth is a liveness probe: 200 whenever the process serves HTTP,
+// draining or not, whatever the upstream's state.
+func (h *Handler) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// readyz fetches /readyz from h.
+func readyz(t *testing.T, h *Handler) (int, proxyapi.Ready) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeReady(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report proxyapi.Ready
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, report
+}
+
+func TestServeReady_InFlightHysteresis(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{ReadyMaxInFlight: 4})
+	if code, report := readyz(t, h); code != http.StatusOK || !report.Ready || report.Reason != readyOK {
+		t.Fatalf("expected ready at rest, got %d %+v", code, report)
+	}
+
+	for _, tc := range []struct {
+		active int64
+		reason string
+	}{
+		{4, readyOK},       // at the high-water mark
+		{5, readyInFlight}, // above it
+		{4, readyInFlight}, // still above the low-water mark, 3
+		{3, readyOK},
+		{4, readyOK},
+	} {
+		h.drain.active.Store(tc.active)
+		code, report := readyz(t, h)
+		want := http.StatusOK
+		if tc.reason != readyOK {
+			want = http.StatusServiceUnavailable
+		}
+		if code != want || report.Reason != tc.reason || report.InFlight != tc.active {
+			t.Errorf("%d in flight: expected %d %s, got %d %+v", tc.active, want, tc.reason, code, report)
+		}
+	}
+
+	h.drain.active.Store(5)
+	h.checkReady(time.Now())
+	if got := testutil.ToFloat64(h.metrics.Ready.WithLabelValues(readyInFlight)); got != 1 {
+		t.Errorf("expected the gauge to show inflight, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.Ready.WithLabelValues(readyOK)); got != 0 {
+		t.Errorf("expected ok cleared, got %v", got)
+	}
+	h.drain.active.Store(0)
+}
+
+func TestServeReady_QueueWait(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{
+		MaxConcurrentPerModel: 1,
+		ReadyMaxQueueWait:     time.Second,
+		ReadyResumeQueueWait:  100 * time.Millisecond,
+	})
+	ctx := context.Background()
+	if _, err := h.gate.acquire(ctx, "m", priorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	admitted := make(chan struct{})
+	go func() {
+		if _, err := h.gate.acquire(ctx, "m", priorityNormal); err == nil {
+			close(admitted)
+		}
+	}()
+	waitFor(t, "a request to queue", func() bool { return h.gate.oldestWait(time.Now()) > 0 })
+
+	if report := h.checkReady(time.Now().Add(2 * time.Second)); report.Reason != readyQueueWait || report.QueueWaitSeconds < 2 {
+		t.Errorf("expected not ready after 2s in the queue, got %+v", report)
+	}
+	if report := h.checkReady(time.Now().Add(500 * time.Millisecond)); report.Reason != readyQueueWait {
+		t.Errorf("expected not ready until the wait is down to 100ms, got %+v", report)
+	}
+	h.gate.release("m")
+	<-admitted
+	if code, report := readyz(t, h); code != http.StatusOK || report.Reason != readyOK || report.QueueWaitSeconds != 0 {
+		t.Errorf("expected ready once the queue emptied, got %d %+v", code, report)
+	}
+	h.gate.release("m")
+}
+
+func TestServeReady_Draining(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	if err := h.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(h.metrics.Ready.WithLabelValues(readyDraining)); got != 1 {
+		t.Errorf("expected the gauge to show draining as Drain begins, got %v", got)
+	}
+	if code, report := readyz(t, h); code != http.StatusServiceUnavailable || report.Reason != readyDraining {
+		t.Errorf("expected 503 draining, got %d %+v", code, report)
+	}
+}
+
+func TestServeReady_Upstream(t *testing.T) {
+	var probes atomic.Int32
+	var down atomic.Bool
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/version" {
+			http.NotFound(w, r)
+			return
+		}
+		probes.Add(1)
+		if down.Load() {
+			http.Error(w, "loading", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"version":"0.5.0"}`))
+	}))
+	t.Cleanup(up.Close)
+	const ttl = 100 * time.Millisecond
+	h := newTestHandlerWithConfig(t, up.URL, Config{ReadyUpstreamTimeout: time.Second, ReadyUpstreamTTL: ttl})
+	label := upstreamLabel(h.currentUpstream())
+
+	if code, report := readyz(t, h); code != http.StatusOK || report.Reason != readyOK || report.Error != "" {
+		t.Fatalf("expected ready with the upstream up, got %d %+v", code, report)
+	}
+	if got := testutil.ToFloat64(h.metrics.UpstreamUp.WithLabelValues(label)); got != 1 {
+		t.Errorf("expected upstream_up 1, got %v", got)
+	}
+	readyz(t, h)
+	if n := probes.Load(); n != 1 {
+		t.Errorf("expected the second probe served from the cache, got %d probes", n)
+	}
+
+	down.Store(true)
+	time.Sleep(ttl)
+	code, report := readyz(t, h)
+	if code != http.StatusServiceUnavailable || report.Ready || report.Reason != readyUpstream || !strings.Contains(report.Error, "503") {
+		t.Errorf("expected 503 upstream with the probe's error, got %d %+v", code, report)
+	}
+	if got := testutil.ToFloat64(h.metrics.UpstreamUp.WithLabelValues(label)); got != 0 {
+		t.Errorf("expected upstream_up 0, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.Ready.WithLabelValues(readyUpstream)); got != 1 {
+		t.Errorf("expected the ready gauge to show upstream, got %v", got)
+	}
+
+	down.Store(false)
+	time.Sleep(ttl)
+	if code, report := readyz(t, h); code != http.StatusOK || report.Reason != readyOK {
+		t.Errorf("expected ready again once the upstream answers, got %d %+v", code, report)
+	}
+}
+
+func TestServeReady_UpstreamTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	h := newTestHandlerWithConfig(t, slow.URL, Config{ReadyUpstreamTimeout: 50 * time.Millisecond, ReadyUpstreamTTL: time.Minute})
+
+	start := time.Now()
+	code, report := readyz(t, h)
+	if code != http.StatusServiceUnavailable || report.Reason != readyUpstream || report.Error == "" {
+		t.Errorf("expected 503 once the probe timed out, got %d %+v", code, report)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the probe cut off at its timeout, took %s", elapsed)
+	}
+}
+
+func TestServeReady_UpstreamOff(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	h := newTestHandler(t, down.URL)
+	if code, report := readyz(t, h); code != http.StatusOK || report.Reason != readyOK {
+		t.Errorf("expected no upstream probe without ReadyUpstreamTimeout, got %d %+v", code, report)
+	}
+}
+
+func TestServeHealth(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	h := newTestHandlerWithConfig(t, down.URL, Config{ReadyUpstreamTimeout: time.Second})
+	if err := h.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHealth(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "ok\n" {
+		t.Errorf("expected 200 ok while draining with the upstream down, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestServeReady_UpstreamProbeInFlight(t *testing.T) {
+	var slow atomic.Bool
+	probing, release := make(chan struct{}, 1), make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			select {
+			case probing <- struct{}{}:
+			default: // the readiness worker's probe
+			}
+			<-release
+		}
+		http.Error(w, "loading", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(up.Close)
+	t.Cleanup(func() { close(release) })
+	const ttl = 50 * time.Millisecond
+	h := newTestHandlerWithConfig(t, up.URL, Config{ReadyUpstreamTimeout: 10 * time.Second, ReadyUpstreamTTL: ttl})
+	if _, report := readyz(t, h); report.Reason != readyUpstream {
+		t.Fatalf("expected the first probe to fail, got %+v", report)
+	}
+
+	slow.Store(true)
+	time.Sleep(ttl)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rr := httptest.NewRecorder()
+		h.ServeReady(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	}()
+	<-probing
+	start := time.Now()
+	code, report := readyz(t, h)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected a check during a probe to answer at once, took %s", elapsed)
+	}
+	if code != http.StatusServiceUnavailable || report.Reason != readyUpstream {
+		t.Errorf("expected the last result to stand during the probe, got %d %+v", code, report)
+	}
+	release <- struct{}{}
+	<-done
+}
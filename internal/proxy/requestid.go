@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultRequestIDHeader carries request IDs when Config.RequestIDHeader is
+// empty.
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen caps an ID a client sends; a longer one is replaced.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, "" outside one.
+// Hooks get it as ParsedRequest.ID too; middleware around the Handler finds
+// it in the response headers.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDHeader is the header request IDs are read from and sent in.
+func (h *Handler) requestIDHeader() string {
+	if h.cfg.RequestIDHeader != "" {
+		return h.cfg.RequestIDHeader
+	}
+	return DefaultRequestIDHeader
+}
+
+// requestID returns the ID of r: the one its client sent when it is usable,
+// so a caller's ID follows the request through, else a new one.
+func (h *Handler) requestID(r *http.Request) string {
+	if id := r.Header.Get(h.requestIDHeader()); validRequestID(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+// validRequestID reports whether id is safe to log, store and send on: up
+// to maxRequestIDLen visible ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID tags r with id and returns it to the client.
+func (h *Handler) withRequestID(w http.ResponseWriter, r *http.Request, id string) *http.Request {
+	w.Header().Set(h.requestIDHeader(), id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
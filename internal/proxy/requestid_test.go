@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var got http.Request
+	upstream := seenByUpstream(t, &got)
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	for _, tc := range []struct {
+		name   string
+		cfg    Config
+		header string
+		sent   string
+		want   string // "" for a generated ID
+	}{
+		{"generated", Config{}, "X-Request-Id", "", ""},
+		{"honoured", Config{}, "X-Request-Id", "client-42", "client-42"},
+		{"unusable replaced", Config{}, "X-Request-Id", "two words", ""},
+		{"too long replaced", Config{}, "X-Request-Id", strings.Repeat("a", maxRequestIDLen+1), ""},
+		{"custom header", Config{RequestIDHeader: "X-Correlation-Id"}, "X-Correlation-Id", "corr-1", "corr-1"},
+		{"uninspected", Config{NoInspectEndpoints: []string{"/api/embed"}}, "X-Request-Id", "emb-1", "emb-1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHandlerWithConfig(t, upstream.URL, tc.cfg)
+			lines := logRequests(h)
+			path := "/api/generate"
+			if tc.name == "uninspected" {
+				path = "/api/embed"
+			}
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model":"m","stream":false}`))
+			if tc.sent != "" {
+				req.Header.Set(tc.header, tc.sent)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			id := rr.Header().Get(tc.header)
+			if tc.want != "" && id != tc.want || tc.want == "" && !generated.MatchString(id) {
+				t.Fatalf("expected the response to carry ID %q, got %q", tc.want, id)
+			}
+			if up := got.Header.Values(tc.header); len(up) != 1 || up[0] != id {
+				t.Errorf("expected the upstream sent %q, got %q", id, up)
+			}
+			waitFor(t, "the request line", func() bool { return len(lines.lines("request")) == 1 })
+			if logged := lines.lines("request")[0]["request_id"]; logged != id {
+				t.Errorf("expected the request logged as %q, got %v", id, logged)
+			}
+		})
+	}
+}
+
+func TestRequestID_OnErrorsAndReused(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	h := newTestHandler(t, down.URL)
+	lines := logRequests(h)
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+		req.Header.Set("X-Request-Id", "retried")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadGateway || rr.Header().Get("X-Request-Id") != "retried" {
+			t.Fatalf("expected the 502 to carry the ID, got %d %q", rr.Code, rr.Header().Get("X-Request-Id"))
+		}
+	}
+	waitFor(t, "both request lines", func() bool { return len(lines.lines("request")) == 2 })
+	if failed := lines.lines("failed to persist request record"); len(failed) != 0 {
+		t.Errorf("expected the reused ID stored under a row ID of its own, got %v", failed)
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	var seen string
+	h.Use(Hooks{Inspectors: []RequestInspector{RequestInspectorFunc(func(ctx context.Context, req *ParsedRequest) error {
+		seen = RequestID(ctx)
+		return nil
+	})}})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`)))
+	if seen == "" || seen != rr.Header().Get("X-Request-Id") {
+		t.Errorf("expected hooks to see the request's ID %q, got %q", rr.Header().Get("X-Request-Id"), seen)
+	}
+}
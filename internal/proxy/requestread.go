@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// readBody reads the client's request body, giving up after
+// RequestReadTimeout when it is set. The deadline is cleared again once the
+// body is in, so it never cuts off the response; after a timeout it stays,
+// so closing the body does not wait for the rest of it. With
+// RequestSniffBytes set, a longer body is read only that far and the rest
+// is left to stream upstream.
+func (h *Handler) readBody(w http.ResponseWriter, r *http.Request) (requestBody, error) {
+	body := requestBody{read: &countingReader{r: r.Body}}
+	var err error
+	read := func() {
+		if h.cfg.RequestSniffBytes <= 0 {
+			body.head, err = io.ReadAll(body.read)
+			return
+		}
+		body.head, err = io.ReadAll(io.LimitReader(body.read, h.cfg.RequestSniffBytes+1))
+		if err == nil && int64(len(body.head)) > h.cfg.RequestSniffBytes {
+			body.rest = body.read
+		}
+	}
+	if h.cfg.RequestReadTimeout <= 0 {
+		read()
+		return body, err
+	}
+	rc := http.NewResponseController(w)
+	deadline := rc.SetReadDeadline(time.Now().Add(h.cfg.RequestReadTimeout)) == nil
+	read()
+	if deadline && err == nil {
+		_ = rc.SetReadDeadline(time.Time{})
+	}
+	return body, err
+}
+
+func isReadTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
+
+// requestReadTimeout answers a body that did not arrive within
+// RequestReadTimeout with 408.
+func (h *Handler) requestReadTimeout(w http.ResponseWriter, r *http.Request, reqID, sessionID, endpoint, clientIP string, start time.Time, read int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusRequestTimeout)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":        "request body not received within " + h.cfg.RequestReadTimeout.String(),
+		"bytes_read":   read,
+		"read_timeout": h.cfg.RequestReadTimeout.String(),
+	})
+	h.recordError(reqID, sessionID, endpoint, r, start, clientIP,
+		http.StatusRequestTimeout, read, 0, "read body: timed out after "+h.cfg.RequestReadTimeout.String())
+}
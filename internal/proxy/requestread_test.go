@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// slowBody sends a generate body in two halves, delay apart.
+func slowBody(delay time.Duration) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = io.WriteString(pw, `{"model":"m",`)
+		time.Sleep(delay)
+		_, _ = io.WriteString(pw, `"stream":false}`)
+		_ = pw.Close()
+	}()
+	return pr
+}
+
+func TestRequestRead_SlowBodyLeftOutOfDuration(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	lines := logRequests(h)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/generate", slowBody(150*time.Millisecond))
+	req.Header.Set("X-Forwarded-For", "10.0.0.9")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	if got := histogramSum(t, h.metrics.RequestRead.WithLabelValues("/api/generate")); got < 0.15 {
+		t.Errorf("expected the body's receive time in request_read_seconds, got %v", got)
+	}
+	if got := histogramSum(t, h.metrics.ReqDuration.WithLabelValues("/api/generate", "m", "false", "")); got >= 0.15 {
+		t.Errorf("expected the receive time left out of the request duration, got %v", got)
+	}
+	line := lines.byClient(t, "10.0.0.9")
+	if line["read_ms"].(float64) < 150 || line["duration_ms"].(float64) < 150 {
+		t.Errorf("expected read_ms and the total duration to include the slow body, got %v", line)
+	}
+	if _, ok := line["upstream_ms"]; !ok {
+		t.Errorf("expected upstream_ms on the request line, got %v", line)
+	}
+}
+
+func TestRequestRead_TimeoutAnswers408(t *testing.T) {
+	var forwarded atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Store(true)
+	}))
+	defer upstream.Close()
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{RequestReadTimeout: 50 * time.Millisecond})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = fmt.Fprint(conn, "POST /api/generate HTTP/1.1\r\nHost: proxy\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"model\":")
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("expected a response before the body was complete: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Fatalf("expected 408, got %d", resp.StatusCode)
+	}
+	if forwarded.Load() {
+		t.Error("expected nothing forwarded upstream")
+	}
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", modelUnknown, "408", "false", originProxy, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the 408 counted, got %v", got)
+	}
+}
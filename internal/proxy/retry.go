@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxRetryDrain bounds how much of a 502 or 503 body is read off before
+	// a retry, so the connection can be reused for it.
+	maxRetryDrain = 64 << 10
+	// maxRetryBackoff caps the doubling wait between retries, unless
+	// UpstreamRetryBackoff itself is longer.
+	maxRetryBackoff = 30 * time.Second
+)
+
+// retryableResponse reports whether an attempt that ended in resp or err
+// may be sent again: the upstream refused or dropped the connection, or
+// answered 502 or 503.
+func retryableResponse(client context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return !clientGone(client, err) && connectionLost(err)
+	}
+	return resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// connectionLost reports whether err is the upstream refusing the
+// connection or closing it before the response headers, as a restarting
+// Ollama does. Anything else, a TLS, DNS or timeout error say, is final.
+func connectionLost(err error) bool {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	// The transport does not export this one.
+	return strings.Contains(err.Error(), "server closed idle connection")
+}
+
+// retryBackoff is the wait before retry n, counting from 1:
+// UpstreamRetryBackoff, doubled for each retry before, up to
+// maxRetryBackoff.
+func (h *Handler) retryBackoff(n int) time.Duration {
+	base := h.cfg.UpstreamRetryBackoff
+	ceiling := max(base, maxRetryBackoff)
+	wait := base
+	for i := 1; i < n && wait < ceiling; i++ {
+		wait *= 2
+	}
+	return min(wait, ceiling)
+}
+
+// retryUpstream decides whether attempt of upReq, counting from 1, which
+// ended in resp or err, is sent again. It is while UpstreamRetries allows
+// and the body can be replayed; nothing has reached the client yet, since
+// only the upstream's headers are in. It then waits out retryBackoff and
+// returns the request to send with its body rewound, having closed resp.
+// When the client goes away or NonStreamTimeout expires during the wait,
+// the last outcome stands.
+func (h *Handler) retryUpstream(ri *reqInfo, upReq *http.Request, attempt int, resp *http.Response, err error) (*http.Request, bool) {
+	if attempt > h.cfg.UpstreamRetries || upReq.GetBody == nil ||
+		!retryableResponse(ri.r.Context(), resp, err) {
+		return nil, false
+	}
+	wait := h.retryBackoff(attempt)
+	cause := "upstream: "
+	if err != nil {
+		cause += err.Error()
+	} else {
+		cause += resp.Status
+	}
+	h.logger.Warn("retrying upstream request", "request_id", ri.id, "endpoint", ri.endpoint,
+		"model", ri.model, "attempt", attempt, "retry_in", wait.String(), "error", cause)
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-upReq.Context().Done():
+		return nil, false
+	case <-t.C:
+	}
+	body, gerr := upReq.GetBody()
+	if gerr != nil {
+		return nil, false
+	}
+	next := upReq.Clone(upReq.Context())
+	next.Body = body
+	if resp != nil {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxRetryDrain))
+		_ = resp.Body.Close()
+	}
+	h.metrics.UpstreamRetries.WithLabelValues(ri.endpoint, ri.modelLabel).Inc()
+	return next, true
+}
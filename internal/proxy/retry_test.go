@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/nexusriot/ollama-proxy-metrics/ollamatest"
+)
+
+const retryModel = "llama3:8b" // ollamatest.DefaultModel
+
+func generateOnce(h *Handler, ctx context.Context, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)).WithContext(ctx))
+	return rr
+}
+
+// droppingUpstream closes the connection of its first drops requests
+// without answering, then answers like Ollama; it returns the request count.
+func droppingUpstream(t *testing.T, drops int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if n.Add(1) <= drops {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+			return
+		}
+		_, _ = w.Write([]byte(`{"response":"ok","done":true,"eval_count":1}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &n
+}
+
+func retriesCounted(h *Handler) float64 {
+	return testutil.ToFloat64(h.metrics.UpstreamRetries.WithLabelValues("/api/generate", retryModel))
+}
+
+func TestUpstreamRetries_Status(t *testing.T) {
+	const body = `{"model":"llama3:8b","prompt":"hi","stream":false}`
+	for _, tc := range []struct {
+		name     string
+		fault    ollamatest.Fault
+		retries  int
+		status   int
+		attempts int
+	}{
+		{"503 then ok", ollamatest.Fault{Status: http.StatusServiceUnavailable, Times: 1}, 2, http.StatusOK, 2},
+		{"502 twice then ok", ollamatest.Fault{Status: http.StatusBadGateway, Times: 2}, 2, http.StatusOK, 3},
+		{"exhausted", ollamatest.Fault{Status: http.StatusServiceUnavailable}, 2, http.StatusServiceUnavailable, 3},
+		{"500 not retried", ollamatest.Fault{Status: http.StatusInternalServerError, Times: 1}, 2, http.StatusInternalServerError, 1},
+		{"off", ollamatest.Fault{Status: http.StatusServiceUnavailable, Times: 1}, 0, http.StatusServiceUnavailable, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := ollamatest.NewServer(ollamatest.Options{})
+			t.Cleanup(fake.Close)
+			fake.Fail("/api/generate", tc.fault)
+			h := newTestHandlerWithConfig(t, fake.URL, Config{UpstreamRetries: tc.retries, UpstreamRetryBackoff: 5 * time.Millisecond})
+			rr := generateOnce(h, context.Background(), body)
+			if rr.Code != tc.status {
+				t.Fatalf("expected %d, got %d %s", tc.status, rr.Code, rr.Body.String())
+			}
+			reqs := fake.Requests()
+			if len(reqs) != tc.attempts {
+				t.Fatalf("expected %d attempts, got %d", tc.attempts, len(reqs))
+			}
+			for i, req := range reqs {
+				if string(req.Body) != body {
+					t.Errorf("attempt %d: expected the body replayed, got %q", i+1, req.Body)
+				}
+			}
+			if n := retriesCounted(h); n != float64(tc.attempts-1) {
+				t.Errorf("expected %d retries counted, got %v", tc.attempts-1, n)
+			}
+			if tc.status == http.StatusOK {
+				label := upstreamLabel(h.currentUpstream())
+				if n := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", retryModel, "200", "false", originUpstream, label, "")); n != 1 {
+					t.Errorf("expected the request counted with its final status, got %v", n)
+				}
+				if n := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", retryModel, strconv.Itoa(tc.fault.Status), "false", originUpstream, label, "")); n != 0 {
+					t.Errorf("expected the failed attempts left out of requests_total, got %v", n)
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamRetries_ConnectionDropped(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		drops    int32
+		status   int
+		attempts int32
+	}{
+		{"then ok", 1, http.StatusOK, 2},
+		{"exhausted", 5, http.StatusBadGateway, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			up, n := droppingUpstream(t, tc.drops)
+			h := newTestHandlerWithConfig(t, up.URL, Config{UpstreamRetries: 1, UpstreamRetryBackoff: 5 * time.Millisecond})
+			if rr := generateOnce(h, context.Background(), `{"model":"llama3:8b","stream":false}`); rr.Code != tc.status {
+				t.Fatalf("expected %d, got %d %s", tc.status, rr.Code, rr.Body.String())
+			}
+			if got := n.Load(); got != tc.attempts {
+				t.Errorf("expected %d attempts, got %d", tc.attempts, got)
+			}
+			if got := retriesCounted(h); got != 1 {
+				t.Errorf("expected one retry counted, got %v", got)
+			}
+		})
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	post := func(err error) error {
+		return &url.Error{Op: "Post", URL: "http://ollama:11434/api/generate", Err: err}
+	}
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"refused", post(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), true},
+		{"reset", post(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}), true},
+		{"closed before headers", post(io.EOF), true},
+		{"cut short", post(io.ErrUnexpectedEOF), true},
+		{"idle connection closed", post(errors.New("http: server closed idle connection")), true},
+		{"certificate", post(x509.UnknownAuthorityError{}), false},
+		{"dns", post(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "ollama", IsNotFound: true}}), false},
+		{"bad url", post(errors.New("unsupported protocol scheme")), false},
+		{"canceled", post(context.Canceled), false},
+	} {
+		if got := connectionLost(tc.err); got != tc.want {
+			t.Errorf("%s: expected %t, got %t", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	h := newTestHandlerWithConfig(t, "http://127.0.0.1:1", Config{UpstreamRetries: 1000, UpstreamRetryBackoff: time.Second})
+	for _, tc := range []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, time.Second}, {2, 2 * time.Second}, {5, 16 * time.Second}, {6, maxRetryBackoff},
+		{35, maxRetryBackoff}, {64, maxRetryBackoff}, {1000, maxRetryBackoff},
+	} {
+		if got := h.retryBackoff(tc.n); got != tc.want {
+			t.Errorf("retry %d: expected %s, got %s", tc.n, tc.want, got)
+		}
+	}
+	h.cfg.UpstreamRetryBackoff = time.Minute
+	if got := h.retryBackoff(100); got != time.Minute {
+		t.Errorf("expected a backoff above the cap kept as it is, got %s", got)
+	}
+	h.cfg.UpstreamRetryBackoff = 0
+	if got := h.retryBackoff(100); got != 0 {
+		t.Errorf("expected no wait without a backoff, got %s", got)
+	}
+}
+
+func TestUpstreamRetries_Backoff(t *testing.T) {
+	fake := ollamatest.NewServer(ollamatest.Options{})
+	t.Cleanup(fake.Close)
+	fake.Fail("/api/generate", ollamatest.Fault{Status: http.StatusServiceUnavailable, Times: 2})
+	const backoff = 40 * time.Millisecond
+	h := newTestHandlerWithConfig(t, fake.URL, Config{UpstreamRetries: 2, UpstreamRetryBackoff: backoff})
+	start := time.Now()
+	if rr := generateOnce(h, context.Background(), `{"model":"llama3:8b","stream":false}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 3*backoff {
+		t.Errorf("expected waits of %s and %s, took %s", backoff, 2*backoff, elapsed)
+	}
+}
+
+func TestUpstreamRetries_ClientGone(t *testing.T) {
+	up, n := droppingUpstream(t, 5)
+	h := newTestHandlerWithConfig(t, up.URL, Config{UpstreamRetries: 3, UpstreamRetryBackoff: 10 * time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	generateOnce(h, ctx, `{"model":"llama3:8b","stream":false}`)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the backoff cut short when the client left, took %s", elapsed)
+	}
+	if got := n.Load(); got != 1 {
+		t.Errorf("expected no attempt after the client left, got %d", got)
+	}
+	if got := retriesCounted(h); got != 0 {
+		t.Errorf("expected no retry counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.ClientCancellations.WithLabelValues("/api/generate", cancelHeaders)); got != 1 {
+		t.Errorf("expected the request recorded as canceled, got %v", got)
+	}
+}
+
+func TestUpstreamRetries_StreamedBodyNotRetried(t *testing.T) {
+	fake := ollamatest.NewServer(ollamatest.Options{})
+	t.Cleanup(fake.Close)
+	fake.Fail("/api/generate", ollamatest.Fault{Status: http.StatusServiceUnavailable, Times: 1})
+	h := newTestHandlerWithConfig(t, fake.URL, Config{UpstreamRetries: 2, UpstreamRetryBackoff: time.Millisecond, RequestSniffBytes: 64})
+	body := `{"model":"llama3:8b","stream":false,"prompt":"` + strings.Repeat("x", 256) + `"}`
+	if rr := generateOnce(h, context.Background(), body); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the 503 relayed, got %d", rr.Code)
+	}
+	if n := len(fake.Requests()); n != 1 {
+		t.Errorf("expected a body streamed upstream sent once, got %d attempts", n)
+	}
+}
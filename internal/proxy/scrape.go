@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Results of a background scrape, the result label of upstream_scrapes_total.
+const (
+	scrapeSuccess = "success"
+	scrapeFailure = "failure"
+)
+
+const (
+	scrapeTimeout   = 10 * time.Second
+	scrapeBodyLimit = 4 << 20
+	// scrapeRetryMin is the first delay before retrying a failed scrape; it
+	// doubles on every failure up to the scrape interval.
+	scrapeRetryMin = time.Second
+)
+
+// scraper polls one of the upstream's own endpoints in the background and
+// exports its answer. It goes through the proxy's client, so it shares the
+// transport, the upstream token and any switch of the upstream at runtime.
+// A failed scrape is retried with a backoff rather than an interval later,
+// so an upstream that is down at startup is picked up once it is back.
+type scraper struct {
+	h         *Handler
+	component string // the background worker's name
+	endpoint  string
+	interval  time.Duration
+	retryMin  time.Duration
+	export    func(body io.Reader) error // decodes a 200 response and sets the gauges
+}
+
+// run scrapes once at start, then every interval until ctx is done.
+func (s *scraper) run(ctx context.Context) {
+	retry := s.retryMin
+	for {
+		wait := s.interval
+		if err := s.scrape(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.h.metrics.UpstreamScrapes.WithLabelValues(s.endpoint, scrapeFailure).Inc()
+			wait, retry = min(retry, s.interval), min(retry*2, s.interval)
+			s.h.logger.Warn("upstream scrape failed", "endpoint", s.endpoint, "error", err, "retry_in", wait.String())
+		} else {
+			s.h.metrics.UpstreamScrapes.WithLabelValues(s.endpoint, scrapeSuccess).Inc()
+			retry = s.retryMin
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// scrape fetches the endpoint once and exports it. On error the gauges are
+// left as they were.
+func (s *scraper) scrape(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, scrapeTimeout)
+	defer cancel()
+	u := s.h.currentUpstream()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.h.upstreamEndpoint(u, s.endpoint).String(), nil)
+	if err != nil {
+		return err
+	}
+	s.h.setUpstreamAuth(req.Header, u)
+	resp, err := s.h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", s.endpoint, resp.Status)
+	}
+	return s.export(io.LimitReader(resp.Body, scrapeBodyLimit))
+}
+
+// psScraper exports /api/ps into the loaded_model gauges. Models no longer
+// loaded lose their series.
+func (h *Handler) psScraper(interval time.Duration) *scraper {
+	m := h.metrics
+	loaded := map[string]bool{}
+	return &scraper{h: h, component: "ps", endpoint: "/api/ps", interval: interval, retryMin: scrapeRetryMin,
+		export: func(body io.Reader) error {
+			var ps struct {
+				Models []struct {
+					Name      string    `json:"name"`
+					Size      int64     `json:"size"`
+					SizeVRAM  int64     `json:"size_vram"`
+					ExpiresAt time.Time `json:"expires_at"`
+				} `json:"models"`
+			}
+			if err := json.NewDecoder(body).Decode(&ps); err != nil {
+				return fmt.Errorf("decode /api/ps: %w", err)
+			}
+			seen := make(map[string]bool, len(ps.Models))
+			for _, model := range ps.Models {
+				seen[model.Name] = true
+				m.LoadedModel.WithLabelValues(model.Name).Set(1)
+				m.LoadedModelSize.WithLabelValues(model.Name).Set(float64(model.Size))
+				m.LoadedModelVRAM.WithLabelValues(model.Name).Set(float64(model.SizeVRAM))
+				if model.ExpiresAt.IsZero() {
+					m.LoadedModelExpires.DeleteLabelValues(model.Name)
+				} else {
+					m.LoadedModelExpires.WithLabelValues(model.Name).Set(float64(model.ExpiresAt.Unix()))
+				}
+			}
+			for model := range loaded {
+				if !seen[model] {
+					m.LoadedModel.DeleteLabelValues(model)
+					m.LoadedModelSize.DeleteLabelValues(model)
+					m.LoadedModelVRAM.DeleteLabelValues(model)
+					m.LoadedModelExpires.DeleteLabelValues(model)
+				}
+			}
+			loaded = seen
+			return nil
+		},
+	}
+}
+
+// tagsScraper exports /api/tags into model_info and model_size_bytes.
+// Deleted models lose their series, as does a model's old model_info series
+// when its details change.
+func (h *Handler) tagsScraper(interval time.Duration) *scraper {
+	m := h.metrics
+	known := map[string]prometheus.Labels{}
+	return &scraper{h: h, component: "tags", endpoint: "/api/tags", interval: interval, retryMin: scrapeRetryMin,
+		export: func(body io.Reader) error {
+			var tags struct {
+				Models []struct {
+					Name    string `json:"name"`
+					Size    int64  `json:"size"`
+					Details struct {
+						Family            string `json:"family"`
+						ParameterSize     string `json:"parameter_size"`
+						QuantizationLevel string `json:"quantization_level"`
+					} `json:"details"`
+				} `json:"models"`
+			}
+			if err := json.NewDecoder(body).Decode(&tags); err != nil {
+				return fmt.Errorf("decode /api/tags: %w", err)
+			}
+			seen := make(map[string]prometheus.Labels, len(tags.Models))
+			for _, model := range tags.Models {
+				labels := prometheus.Labels{
+					"model":              model.Name,
+					"family":             model.Details.Family,
+					"parameter_size":     model.Details.ParameterSize,
+					"quantization_level": model.Details.QuantizationLevel,
+				}
+				if old, ok := known[model.Name]; ok && !maps.Equal(old, labels) {
+					m.ModelInfo.Delete(old)
+				}
+				seen[model.Name] = labels
+				m.ModelInfo.With(labels).Set(1)
+				m.ModelSize.WithLabelValues(model.Name).Set(float64(model.Size))
+			}
+			for model, old := range known {
+				if _, ok := seen[model]; !ok {
+					m.ModelInfo.Delete(old)
+					m.ModelSize.DeleteLabelValues(model)
+				}
+			}
+			known = seen
+			return nil
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// scrapeUpstream answers the paths in bodies with what they hold, or 500
+// when that is empty.
+func scrapeUpstream(t *testing.T, paths ...string) (*httptest.Server, map[string]*atomic.Value) {
+	t.Helper()
+	bodies := map[string]*atomic.Value{}
+	for _, p := range paths {
+		bodies[p] = &atomic.Value{}
+		bodies[p].Store("")
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, ok := bodies[r.URL.Path]
+		if !ok || v.Load().(string) == "" {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(v.Load().(string)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+func TestPSScraper_ExportsAndForgetsModels(t *testing.T) {
+	upstream, bodies := scrapeUpstream(t, "/api/ps")
+	ps := bodies["/api/ps"]
+	h := newTestHandler(t, upstream.URL)
+	s := h.psScraper(time.Hour)
+
+	ps.Store(`{"models":[
+		{"name":"llama3:8b","size":6000,"size_vram":4000,"expires_at":"2026-10-14T12:00:00Z"},
+		{"name":"qwen:7b","size":5000,"size_vram":5000,"expires_at":"2026-10-14T12:05:00Z"}]}`)
+	if err := s.scrape(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m := h.metrics
+	if got := testutil.ToFloat64(m.LoadedModel.WithLabelValues("llama3:8b")); got != 1 {
+		t.Errorf("expected llama3:8b loaded, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.LoadedModelVRAM.WithLabelValues("llama3:8b")); got != 4000 {
+		t.Errorf("expected the VRAM size, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.LoadedModelSize.WithLabelValues("qwen:7b")); got != 5000 {
+		t.Errorf("expected the size, got %v", got)
+	}
+	want := time.Date(2026, 10, 14, 12, 5, 0, 0, time.UTC).Unix()
+	if got := testutil.ToFloat64(m.LoadedModelExpires.WithLabelValues("qwen:7b")); got != float64(want) {
+		t.Errorf("expected expires_at as a unix time, got %v", got)
+	}
+
+	ps.Store(`{"models":[{"name":"qwen:7b","size":5000,"size_vram":5000,"expires_at":"2026-10-14T12:10:00Z"}]}`)
+	if err := s.scrape(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for name, vec := range map[string]interface{ DeleteLabelValues(...string) bool }{
+		"loaded_model": m.LoadedModel, "size": m.LoadedModelSize, "vram": m.LoadedModelVRAM, "expires": m.LoadedModelExpires,
+	} {
+		if vec.DeleteLabelValues("llama3:8b") {
+			t.Errorf("expected the %s series of the unloaded model removed", name)
+		}
+	}
+	if got := testutil.CollectAndCount(m.LoadedModel); got != 1 {
+		t.Errorf("expected one loaded model left, got %d", got)
+	}
+
+	ps.Store("")
+	if err := s.scrape(context.Background()); err == nil {
+		t.Fatal("expected a failed scrape to return an error")
+	}
+	if got := testutil.ToFloat64(m.LoadedModel.WithLabelValues("qwen:7b")); got != 1 {
+		t.Errorf("expected a failed scrape to keep the last values, got %v", got)
+	}
+}
+
+func TestTagsScraper_ExportsCatalog(t *testing.T) {
+	upstream, bodies := scrapeUpstream(t, "/api/tags")
+	tags := bodies["/api/tags"]
+	h := newTestHandler(t, upstream.URL)
+	s := h.tagsScraper(time.Hour)
+	m := h.metrics
+
+	tags.Store(`{"models":[
+		{"name":"llama3:8b","size":4700,"details":{"family":"llama","parameter_size":"8.0B","quantization_level":"Q4_0"}},
+		{"name":"qwen:7b","size":4100,"details":{"family":"qwen2","parameter_size":"7.6B","quantization_level":"Q4_K_M"}}]}`)
+	if err := s.scrape(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(m.ModelInfo.WithLabelValues("llama3:8b", "llama", "8.0B", "Q4_0")); got != 1 {
+		t.Errorf("expected llama3:8b in the catalog with its details, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.ModelSize.WithLabelValues("qwen:7b")); got != 4100 {
+		t.Errorf("expected the size on disk, got %v", got)
+	}
+
+	// llama3:8b re-pulled with another quantization, qwen:7b deleted.
+	tags.Store(`{"models":[{"name":"llama3:8b","size":8500,"details":{"family":"llama","parameter_size":"8.0B","quantization_level":"Q8_0"}}]}`)
+	if err := s.scrape(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.CollectAndCount(m.ModelInfo); got != 1 {
+		t.Errorf("expected only the current model_info series, got %d", got)
+	}
+	if got := testutil.ToFloat64(m.ModelInfo.WithLabelValues("llama3:8b", "llama", "8.0B", "Q8_0")); got != 1 {
+		t.Errorf("expected the new details, got %v", got)
+	}
+	if m.ModelSize.DeleteLabelValues("qwen:7b") {
+		t.Error("expected the deleted model's size series removed")
+	}
+}
+
+func TestScraper_RetriesWithBackoff(t *testing.T) {
+	upstream, bodies := scrapeUpstream(t, "/api/tags")
+	h := newTestHandler(t, upstream.URL)
+	s := h.tagsScraper(time.Hour) // only the backoff can bring the next scrape
+	s.retryMin = 5 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	failures := h.metrics.UpstreamScrapes.WithLabelValues("/api/tags", scrapeFailure)
+	waitFor(t, "failed scrapes retried", func() bool { return testutil.ToFloat64(failures) >= 2 })
+	bodies["/api/tags"].Store(`{"models":[{"name":"m:latest","size":1,"details":{}}]}`)
+	waitFor(t, "the catalog once the upstream is up", func() bool { return testutil.CollectAndCount(h.metrics.ModelInfo) == 1 })
+	if got := testutil.ToFloat64(h.metrics.UpstreamScrapes.WithLabelValues("/api/tags", scrapeSuccess)); got != 1 {
+		t.Errorf("expected one successful scrape counted, got %v", got)
+	}
+}
+
+func TestScrapers_StartWithHandler(t *testing.T) {
+	upstream, bodies := scrapeUpstream(t, "/api/ps", "/api/tags")
+	bodies["/api/ps"].Store(`{"models":[{"name":"m:latest","size":1,"size_vram":1,"expires_at":"2026-10-14T12:00:00Z"}]}`)
+	bodies["/api/tags"].Store(`{"models":[{"name":"m:latest","size":1,"details":{"family":"llama"}}]}`)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{PSScrapeInterval: time.Hour, TagsScrapeInterval: time.Hour})
+	waitFor(t, "the loaded model exported", func() bool { return testutil.CollectAndCount(h.metrics.LoadedModel) == 1 })
+	waitFor(t, "the catalog exported", func() bool { return testutil.CollectAndCount(h.metrics.ModelInfo) == 1 })
+}
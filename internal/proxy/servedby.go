@@ -0,0 +1,35 @@
+package proxy
+
+import "net/http"
+
+// setServedBy names this proxy instance on a response when ServedByHeader
+// is set. It goes on before anything else so that the proxy's own answers
+// carry it too; an upstream's X-Served-By is replaced.
+func (h *Handler) setServedBy(hdr http.Header) {
+	if h.cfg.ServedByHeader && h.cfg.InstanceName != "" {
+		hdr.Set(headerServedBy, h.cfg.InstanceName)
+	}
+}
+
+// setUpstreamHeaders annotates a forwarded response once the upstream's
+// headers are copied: X-Served-By and the request ID again, in case the
+// upstream sent its own, and with ExposeUpstreamNames the upstream the
+// request went to.
+func (h *Handler) setUpstreamHeaders(hdr http.Header, ri *reqInfo) {
+	h.setServedBy(hdr)
+	hdr.Set(h.requestIDHeader(), ri.id)
+	if h.cfg.ExposeUpstreamNames {
+		hdr.Set(headerUpstream, ri.upstreamLabel)
+	} else {
+		hdr.Del(headerUpstream)
+	}
+}
+
+// triedUpstream is the upstream of a request that failed after being sent
+// to it, or "" for one refused before it was.
+func (ri *reqInfo) triedUpstream() string {
+	if ri.upstreamStart.IsZero() {
+		return ""
+	}
+	return ri.upstreamLabel
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// annotatingUpstream answers like a proxy in front of Ollama would, with
+// annotations of its own.
+func annotatingUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerServedBy, "inner-proxy")
+		w.Header().Set(headerUpstream, "10.0.0.9:11434")
+		_, _ = fmt.Fprintln(w, `{"response":"ok","done":true,"eval_count":1}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestServedBy_Headers(t *testing.T) {
+	upstream := annotatingUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{InstanceName: "proxy-a", ServedByHeader: true, ExposeUpstreamNames: true})
+	for _, body := range []string{`{"model":"m"}`, `{"model":"m","stream":false}`} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)))
+		if got := rr.Header().Values(headerServedBy); len(got) != 1 || got[0] != "proxy-a" {
+			t.Errorf("%s: expected X-Served-By proxy-a only, got %q", body, got)
+		}
+		if got := rr.Header().Values(headerUpstream); len(got) != 1 || got[0] != upstreamLabel(h.currentUpstream()) {
+			t.Errorf("%s: expected X-Upstream to be the upstream's host:port, got %q", body, got)
+		}
+	}
+	rows, _, err := h.store.ListRequests(10, 0, "", "")
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("expected two records, got %d (%v)", len(rows), err)
+	}
+	for _, row := range rows {
+		if row.ServedBy != "proxy-a" || row.Upstream != upstreamLabel(h.currentUpstream()) {
+			t.Errorf("expected the record to name the instance and upstream, got %q %q", row.ServedBy, row.Upstream)
+		}
+	}
+}
+
+func TestServedBy_UpstreamNamesHiddenByDefault(t *testing.T) {
+	upstream := annotatingUpstream(t)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{InstanceName: "proxy-a"})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`)))
+	if got := rr.Header().Get(headerUpstream); got != "" {
+		t.Errorf("expected no X-Upstream without ExposeUpstreamNames, got %q", got)
+	}
+	if got := rr.Header().Get(headerServedBy); got != "inner-proxy" {
+		t.Errorf("expected the upstream's own X-Served-By left alone without ServedByHeader, got %q", got)
+	}
+	rows, _, _ := h.store.ListRequests(1, 0, "", "")
+	if len(rows) != 1 || rows[0].ServedBy != "proxy-a" || rows[0].Upstream == "" {
+		t.Errorf("expected the record annotated regardless of the headers, got %+v", rows)
+	}
+}
+
+func TestServedBy_ProxyAnswers(t *testing.T) {
+	h := newTestHandlerWithConfig(t, "http://127.0.0.1:1", Config{InstanceName: "proxy-a", ServedByHeader: true, ReadOnly: true})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/pull", strings.NewReader(`{"model":"m"}`)))
+	if rr.Code != http.StatusForbidden || rr.Header().Get(headerServedBy) != "proxy-a" {
+		t.Errorf("expected the refusal to carry X-Served-By, got %d %q", rr.Code, rr.Header().Get(headerServedBy))
+	}
+	rows, _, _ := h.store.ListRequests(1, 0, "", "")
+	if len(rows) != 1 || rows[0].Upstream != "" {
+		t.Errorf("expected no upstream recorded for a request never sent, got %+v", rows)
+	}
+}
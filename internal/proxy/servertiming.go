@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// timingPhase is one Server-Timing metric.
+type timingPhase struct {
+	name string
+	dur  time.Duration
+}
+
+func formatServerTiming(phases ...timingPhase) string {
+	parts := make([]string, len(phases))
+	for i, p := range phases {
+		parts[i] = fmt.Sprintf("%s;dur=%.1f", p.name, float64(p.dur)/float64(time.Millisecond))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// serverTimingHead sets the phases known when response headers are written:
+// queue wait and the upstream's time to first byte. For a stream the rest
+// follows in a trailer, which must be declared now and needs a chunked
+// response.
+func (h *Handler) serverTimingHead(hdr http.Header, ri *reqInfo, stream bool) {
+	if !h.cfg.ServerTiming {
+		return
+	}
+	hdr.Add(headerServerTiming, formatServerTiming(
+		timingPhase{"read", ri.received.Sub(ri.start)},
+		timingPhase{"queue", ri.queueWait},
+		timingPhase{"upstream_ttfb", ri.upstreamTTFB},
+	))
+	if stream {
+		hdr.Add("Trailer", headerServerTiming)
+	}
+}
+
+// serverTimingTotals returns the phases known once the upstream body has
+// been read: the upstream's total time, the proxy's own overhead (everything
+// that was neither reading the request, queueing nor upstream) and the
+// request total so far.
+func serverTimingTotals(ri *reqInfo, now time.Time) string {
+	upstream := now.Sub(ri.upstreamStart)
+	total := now.Sub(ri.start)
+	return formatServerTiming(
+		timingPhase{"upstream", upstream},
+		timingPhase{"proxy", max(0, total-upstream-ri.queueWait-ri.received.Sub(ri.start))},
+		timingPhase{"total", total},
+	)
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatServerTiming(t *testing.T) {
+	got := formatServerTiming(timingPhase{"queue", 12 * time.Millisecond}, timingPhase{"total", 1500 * time.Microsecond})
+	if want := "queue;dur=12.0, total;dur=1.5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestServerTiming_NonStream(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{ServerTiming: true})
+	rr := generate(h, "10.0.0.1")
+	got := strings.Join(rr.Header().Values("Server-Timing"), ", ")
+	for _, phase := range []string{"queue;dur=", "upstream_ttfb;dur=", "upstream;dur=", "proxy;dur=", "total;dur="} {
+		if !strings.Contains(got, phase) {
+			t.Errorf("expected %s in %q", phase, got)
+		}
+	}
+}
+
+func TestServerTiming_StreamTrailer(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		_, _ = fmt.Fprintln(w, `{"response":"b","done":true,"eval_count":2}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ServerTiming: true})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/api/generate", "application/json", strings.NewReader(`{"model":"m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	head := resp.Header.Get("Server-Timing")
+	if !strings.Contains(head, "upstream_ttfb;dur=") || strings.Contains(head, "total;dur=") {
+		t.Errorf("expected only the phases known at header time, got %q", head)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if tr := resp.Trailer.Get("Server-Timing"); !strings.Contains(tr, "total;dur=") {
+		t.Errorf("expected the totals in the trailer, got %q", tr)
+	}
+}
+
+func TestServerTiming_OffByDefault(t *testing.T) {
+	rr := generate(newTestHandler(t, tokenUpstream(t).URL), "10.0.0.1")
+	if v := rr.Header().Values("Server-Timing"); len(v) != 0 {
+		t.Errorf("expected no Server-Timing without the flag, got %v", v)
+	}
+}
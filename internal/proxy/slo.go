@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Causes of an SLO violation, the cause label of slo_violations_total.
+const (
+	sloCauseError   = "error"
+	sloCauseLatency = "latency"
+)
+
+// SLOTarget is one service-level objective: of the requests to its endpoint
+// class, Objective (e.g. 0.99) should finish within Latency and without a
+// 5xx, timeout or broken stream.
+type SLOTarget struct {
+	// Name is the slo label of the SLO's series.
+	Name string
+	// Class is the endpoint class (generate, chat, embed or other) the SLO
+	// covers; empty covers every request.
+	Class string
+	// Latency bounds the time from the request body being received to the
+	// last byte sent; 0 judges errors only.
+	Latency time.Duration
+	// Objective is the share of requests that must meet the SLO, exported
+	// as slo_objective so the burn rate is a ratio of series.
+	Objective float64
+	// ExcludeProxyErrors leaves errors the proxy answered itself (upstream
+	// unreachable, timed out or out of memory) out of the SLO altogether,
+	// for SLOs that should burn only on Ollama's own failures.
+	ExcludeProxyErrors bool
+}
+
+// ParseSLOTargets parses a JSON array of SLO targets such as
+//
+//	[{"name": "chat-30s", "class": "chat", "latency": "30s", "objective": 0.99,
+//	  "proxy_errors": "exclude"}]
+//
+// proxy_errors is "count" (the default) or "exclude".
+func ParseSLOTargets(data []byte) ([]SLOTarget, error) {
+	var raw []struct {
+		Name        string  `json:"name"`
+		Class       string  `json:"class"`
+		Latency     string  `json:"latency"`
+		Objective   float64 `json:"objective"`
+		ProxyErrors string  `json:"proxy_errors"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	out := make([]SLOTarget, 0, len(raw))
+	seen := map[string]bool{}
+	for i, r := range raw {
+		if r.Name == "" {
+			return nil, fmt.Errorf("target %d: name is required", i)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("target %q listed twice", r.Name)
+		}
+		seen[r.Name] = true
+		switch r.Class {
+		case "", "generate", "chat", "embed", "other":
+		default:
+			return nil, fmt.Errorf("target %q: class %q is not generate, chat, embed or other", r.Name, r.Class)
+		}
+		if r.Objective <= 0 || r.Objective >= 1 {
+			return nil, fmt.Errorf("target %q: objective must be between 0 and 1, got %g", r.Name, r.Objective)
+		}
+		t := SLOTarget{Name: r.Name, Class: r.Class, Objective: r.Objective}
+		if r.Latency != "" {
+			d, err := time.ParseDuration(r.Latency)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("target %q: invalid latency %q", r.Name, r.Latency)
+			}
+			t.Latency = d
+		}
+		switch r.ProxyErrors {
+		case "", "count":
+		case "exclude":
+			t.ExcludeProxyErrors = true
+		default:
+			return nil, fmt.Errorf("target %q: proxy_errors %q is not count or exclude", r.Name, r.ProxyErrors)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// LoadSLOTargets reads and parses an SLO file; see ParseSLOTargets.
+func LoadSLOTargets(path string) ([]SLOTarget, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := ParseSLOTargets(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return targets, nil
+}
+
+// SetSLOTargets replaces the SLO targets, e.g. after the SLO file changed.
+// Counters of targets that are gone stay exported with their last values;
+// only slo_objective follows the current set.
+func (h *Handler) SetSLOTargets(targets []SLOTarget) {
+	h.slos.Store(&targets)
+	h.metrics.SLOObjective.Reset()
+	for _, t := range targets {
+		h.metrics.SLOObjective.WithLabelValues(t.Name).Set(t.Objective)
+	}
+}
+
+// observeSLO counts a finished request against every SLO of its endpoint
+// class. failed is a 5xx, a timeout or a response that broke off; origin
+// says whether the upstream or the proxy answered.
+func (h *Handler) observeSLO(ri *reqInfo, origin string, failed bool, served time.Duration) {
+	targets := h.slos.Load()
+	if targets == nil {
+		return
+	}
+	class := endpointClass(ri.endpoint)
+	for _, t := range *targets {
+		if t.Class != "" && t.Class != class {
+			continue
+		}
+		if failed && origin == originProxy && t.ExcludeProxyErrors {
+			continue
+		}
+		h.metrics.SLORequests.WithLabelValues(t.Name, ri.modelLabel).Inc()
+		switch {
+		case failed:
+			h.metrics.SLOViolations.WithLabelValues(t.Name, ri.modelLabel, sloCauseError).Inc()
+		case t.Latency > 0 && served > t.Latency:
+			h.metrics.SLOViolations.WithLabelValues(t.Name, ri.modelLabel, sloCauseLatency).Inc()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseSLOTargets(t *testing.T) {
+	targets, err := ParseSLOTargets([]byte(`[
+		{"name": "chat-30s", "class": "chat", "latency": "30s", "objective": 0.99},
+		{"name": "ollama-only", "objective": 0.999, "proxy_errors": "exclude"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SLOTarget{
+		{Name: "chat-30s", Class: "chat", Latency: 30 * time.Second, Objective: 0.99},
+		{Name: "ollama-only", Objective: 0.999, ExcludeProxyErrors: true},
+	}
+	if len(targets) != len(want) || targets[0] != want[0] || targets[1] != want[1] {
+		t.Errorf("expected %+v, got %+v", want, targets)
+	}
+
+	for _, bad := range []string{
+		`{"name": "x"}`,
+		`[{"objective": 0.9}]`,
+		`[{"name": "x", "objective": 1}]`,
+		`[{"name": "x", "objective": 0.9, "class": "vision"}]`,
+		`[{"name": "x", "objective": 0.9, "latency": "soon"}]`,
+		`[{"name": "x", "objective": 0.9, "proxy_errors": "ignore"}]`,
+		`[{"name": "x", "objective": 0.9}, {"name": "x", "objective": 0.5}]`,
+	} {
+		if _, err := ParseSLOTargets([]byte(bad)); err == nil {
+			t.Errorf("expected %s rejected", bad)
+		}
+	}
+}
+
+func TestSLO_CountsViolations(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "chat"):
+			time.Sleep(30 * time.Millisecond)
+		case strings.Contains(r.URL.Path, "generate"):
+			http.Error(w, `{"error":"boom"}`, http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"done":true}`))
+	}))
+	defer up.Close()
+	h := newTestHandlerWithConfig(t, up.URL, Config{SLOTargets: []SLOTarget{
+		{Name: "chat-fast", Class: "chat", Latency: 10 * time.Millisecond, Objective: 0.99},
+		{Name: "all", Objective: 0.9},
+	}})
+
+	for _, path := range []string{"/api/chat", "/api/generate", "/api/embed"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model":"m","stream":false}`)))
+	}
+
+	checks := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"chat-fast requests", testutil.ToFloat64(h.metrics.SLORequests.WithLabelValues("chat-fast", "m")), 1},
+		{"chat-fast latency violations", testutil.ToFloat64(h.metrics.SLOViolations.WithLabelValues("chat-fast", "m", sloCauseLatency)), 1},
+		{"all requests", testutil.ToFloat64(h.metrics.SLORequests.WithLabelValues("all", "m")), 3},
+		{"all error violations", testutil.ToFloat64(h.metrics.SLOViolations.WithLabelValues("all", "m", sloCauseError)), 1},
+		{"all latency violations", testutil.ToFloat64(h.metrics.SLOViolations.WithLabelValues("all", "m", sloCauseLatency)), 0},
+		{"objective", testutil.ToFloat64(h.metrics.SLOObjective.WithLabelValues("chat-fast")), 0.99},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, c.got)
+		}
+	}
+}
+
+func TestSLO_ProxyErrorsInOrOutOfBudget(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	h := newTestHandlerWithConfig(t, dead.URL, Config{SLOTargets: []SLOTarget{
+		{Name: "counted", Objective: 0.99},
+		{Name: "excluded", Objective: 0.99, ExcludeProxyErrors: true},
+	}})
+	if code := generateModel(h, "m"); code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", code)
+	}
+	if got := testutil.ToFloat64(h.metrics.SLOViolations.WithLabelValues("counted", "m", sloCauseError)); got != 1 {
+		t.Errorf("expected the 502 to burn the counted SLO, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.SLORequests.WithLabelValues("excluded", "m")); got != 0 {
+		t.Errorf("expected the 502 left out of the excluded SLO, got %v", got)
+	}
+}
+
+func TestSLO_SetTargetsReplaces(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{SLOTargets: []SLOTarget{{Name: "old", Objective: 0.9}}})
+	h.SetSLOTargets([]SLOTarget{{Name: "new", Objective: 0.95}})
+	generate(h, "10.0.0.1")
+
+	if got := testutil.ToFloat64(h.metrics.SLORequests.WithLabelValues("old", "m")); got != 0 {
+		t.Errorf("expected the replaced target unused, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.SLORequests.WithLabelValues("new", "m")); got != 1 {
+		t.Errorf("expected the new target counted, got %v", got)
+	}
+	if n := testutil.CollectAndCount(h.metrics.SLOObjective); n != 1 {
+		t.Errorf("expected only the current objective exported, got %d series", n)
+	}
+}
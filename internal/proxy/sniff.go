@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// errBeyondSniff is sniffPayload's error when the sniffed prefix of a body
+// ends before its top-level object does.
+var errBeyondSniff = errors.New("request body continues past the sniff window")
+
+// requestBody is a client's request body as read by readBody.
+type requestBody struct {
+	head []byte          // the whole body or, when rest is set, its first bytes
+	rest io.Reader       // the unread remainder of a body over RequestSniffBytes
+	read *countingReader // everything read from the client, head and rest
+}
+
+// streamed reports whether only the head of the body is in memory.
+func (b requestBody) streamed() bool {
+	return b.rest != nil
+}
+
+// bytesRead is how much of the body was read from the client so far.
+func (b requestBody) bytesRead() int64 {
+	if b.read == nil {
+		return 0
+	}
+	return b.read.n.Load()
+}
+
+// sniffPayload finds model (or its older spelling name) and stream among
+// the top-level fields of the prefix of a larger body, skipping the values
+// of the others. It returns errBeyondSniff along with what it found when
+// the prefix ends first, as it does for a body with images before those.
+func sniffPayload(head []byte) (requestPayload, error) {
+	var p requestPayload
+	dec := json.NewDecoder(bytes.NewReader(head))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return p, errBeyondSniff
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return p, errBeyondSniff
+		}
+		var value any
+		switch tok {
+		case "model":
+			value = &p.Model
+		case "name":
+			value = &p.Name
+		case "stream":
+			value = &p.Stream
+		default:
+			value = &json.RawMessage{}
+		}
+		if err := dec.Decode(value); err != nil {
+			return p, errBeyondSniff
+		}
+	}
+	return p, nil
+}
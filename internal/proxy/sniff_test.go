@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSniffPayload(t *testing.T) {
+	for _, tc := range []struct {
+		head        string
+		model, name string
+		stream      string
+		beyond      bool
+	}{
+		{`{"model":"llava","stream":false,"images":["AAAA`, "llava", "", "false", true},
+		{`{"stream":true,"options":{"num_ctx":8192},"name":"old"}`, "", "old", "true", false},
+		{`{"images":["AAAAAAAA`, "", "", "", true},
+		{`{"model":"ll`, "", "", "", true},
+		{`not json`, "", "", "", true},
+	} {
+		p, err := sniffPayload([]byte(tc.head))
+		stream := ""
+		if p.Stream != nil {
+			stream = strconv.FormatBool(*p.Stream)
+		}
+		if p.Model != tc.model || p.Name != tc.name || stream != tc.stream || errors.Is(err, errBeyondSniff) != tc.beyond {
+			t.Errorf("sniffPayload(%q) = %q %q %q %v", tc.head, p.Model, p.Name, stream, err)
+		}
+	}
+}
+
+// bodyUpstream is an upstream that keeps the last request body in got.
+func bodyUpstream(t *testing.T, got *string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		*got = string(b)
+		_, _ = w.Write([]byte(`{"response":"ok","done":true}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRequestSniff_StreamsLargeBody(t *testing.T) {
+	var got string
+	h := newTestHandlerWithConfig(t, bodyUpstream(t, &got).URL, Config{RequestSniffBytes: 64})
+	image := strings.Repeat("A", 10<<10)
+
+	for _, tc := range []struct {
+		body, model, cause string
+	}{
+		{`{"model":"llava","stream":false,"images":["` + image + `"]}`, "llava", ""},
+		{`{"stream":false,"images":["` + image + `"],"model":"llava"}`, modelUnknown, causeBeyondSniff},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(tc.body)))
+		if w.Code != http.StatusOK || got != tc.body {
+			t.Fatalf("expected the whole body forwarded, got %d and %d of %d bytes", w.Code, len(got), len(tc.body))
+		}
+		if n := testutil.ToFloat64(h.metrics.ClientBytesIn.WithLabelValues("/api/generate", tc.model, "false")); n != float64(len(tc.body)) {
+			t.Errorf("expected %d bytes in counted for %s, got %v", len(tc.body), tc.model, n)
+		}
+		if tc.cause != "" && testutil.ToFloat64(h.metrics.UnknownModelRequests.WithLabelValues("/api/generate", tc.cause)) != 1 {
+			t.Errorf("expected the model after the sniff window counted as %s", tc.cause)
+		}
+	}
+}
+
+func TestRequestSniff_SmallBodyBuffered(t *testing.T) {
+	var got string
+	h := newTestHandlerWithConfig(t, bodyUpstream(t, &got).URL, Config{RequestSniffBytes: 1 << 10})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"prompt":"hi","model":"m","stream":false}`)))
+	if w.Code != http.StatusOK || testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "200", "false", originUpstream, upstreamLabel(h.currentUpstream()), "")) != 1 {
+		t.Errorf("expected a body within the window parsed whole, got %d", w.Code)
+	}
+}
+
+func TestRequestSniff_LimitAppliesToStreamedBody(t *testing.T) {
+	var got string
+	h := newTestHandlerWithConfig(t, bodyUpstream(t, &got).URL, Config{RequestSniffBytes: 64, MaxRequestBytes: 1 << 10})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/generate", "application/json",
+		strings.NewReader(`{"model":"llava","stream":false,"images":["`+strings.Repeat("A", 4<<10)+`"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 once the streamed body passed the limit, got %d", resp.StatusCode)
+	}
+	if n := testutil.ToFloat64(h.metrics.RequestsRejected.WithLabelValues(rejectBodyTooLarge)); n != 1 {
+		t.Errorf("expected the rejection counted, got %v", n)
+	}
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+)
+
+// Results for ollama_proxy_response_spills_total.
+const (
+	spillSpilled  = "spilled"
+	spillTooLarge = "too_large"
+	spillError    = "error" // temp file unusable; the body stayed in memory
+)
+
+// errResponseTooLarge is returned by bufferResponse when a body exceeds
+// SpillMaxBytes.
+var errResponseTooLarge = errors.New("upstream response exceeds the spill ceiling")
+
+// spillFile is a non-stream response body that outgrew SpillThreshold and
+// was written to a temp file. close removes the file.
+type spillFile struct {
+	f    *os.File
+	size int64
+}
+
+// reader returns the whole body from the start.
+func (s *spillFile) reader() io.Reader {
+	return io.NewSectionReader(s.f, 0, s.size)
+}
+
+// head returns up to n bytes from the start of the body.
+func (s *spillFile) head(n int64) []byte {
+	b, _ := io.ReadAll(io.NewSectionReader(s.f, 0, min(n, s.size)))
+	return b
+}
+
+func (s *spillFile) close() {
+	_ = s.f.Close()
+	_ = os.Remove(s.f.Name())
+}
+
+// bufferResponse reads a non-stream body. Without a SpillThreshold, or when
+// the body fits under it, the body is returned in memory; a larger body is
+// continued into a temp file in SpillDir and returned as a spillFile, which
+// the caller must close. With spilling on, bodies over SpillMaxBytes fail
+// with errResponseTooLarge. A read error while still in memory comes with
+// what was read so far; once spilling, it comes with no body at all.
+func (h *Handler) bufferResponse(reqID, endpoint string, body io.Reader) ([]byte, *spillFile, error) {
+	if h.cfg.SpillThreshold <= 0 {
+		b, err := io.ReadAll(body)
+		return b, nil, err
+	}
+	mem, err := io.ReadAll(io.LimitReader(body, h.cfg.SpillThreshold+1))
+	if err != nil || int64(len(mem)) <= h.cfg.SpillThreshold {
+		return mem, nil, err
+	}
+
+	f, err := os.CreateTemp(h.cfg.SpillDir, "ollama-proxy-spill-*")
+	if err != nil {
+		h.metrics.ResponseSpills.WithLabelValues(endpoint, spillError).Inc()
+		h.logger.Warn("cannot spill response to disk, buffering in memory", "request_id", reqID, "endpoint", endpoint, "error", err)
+		rest, err := io.ReadAll(body)
+		return append(mem, rest...), nil, err
+	}
+	s := &spillFile{f: f}
+	src := io.MultiReader(bytes.NewReader(mem), body)
+	if m := h.cfg.SpillMaxBytes; m > 0 {
+		src = io.LimitReader(src, m+1)
+	}
+	s.size, err = io.Copy(f, src)
+	switch {
+	case err != nil:
+		s.close()
+		return nil, nil, err
+	case h.cfg.SpillMaxBytes > 0 && s.size > h.cfg.SpillMaxBytes:
+		s.close()
+		h.metrics.ResponseSpills.WithLabelValues(endpoint, spillTooLarge).Inc()
+		return nil, nil, errResponseTooLarge
+	}
+	h.metrics.ResponseSpills.WithLabelValues(endpoint, spillSpilled).Inc()
+	h.metrics.ResponseSpillBytes.WithLabelValues(endpoint).Add(float64(s.size))
+	return nil, s, nil
+}
+
+// spilledFields are the response fields ChunkStats needs. Everything else in
+// a spilled body (embedding vectors, mostly) is skipped without being held
+// in memory.
+var spilledFields = map[string]bool{
+	"done": true, "response": true, "thinking": true, "message": true,
+	"eval_count": true, "prompt_eval_count": true, "load_duration": true,
+	"total_duration": true, "prompt_eval_duration": true, "eval_duration": true,
+	"choices": true, "usage": true,
+}
+
+// observeSpilled feeds the fields of one large JSON object to stats while
+// reading it incrementally, and reports whether it was a valid object.
+func observeSpilled(r io.Reader, stats *ChunkStats) bool {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return false
+	}
+	kept := map[string]json.RawMessage{}
+	var contextLen int64
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		key, _ := t.(string)
+		switch {
+		case spilledFields[key]:
+			var raw json.RawMessage
+			if dec.Decode(&raw) != nil {
+				return false
+			}
+			kept[key] = raw
+		case key == "context":
+			n, ok := skipValue(dec)
+			if !ok {
+				return false
+			}
+			contextLen = n
+		default:
+			if _, ok := skipValue(dec); !ok {
+				return false
+			}
+		}
+	}
+	small, _ := json.Marshal(kept)
+	if !stats.Observe(small) {
+		return false
+	}
+	stats.ContextTokens = contextLen
+	return true
+}
+
+// skipValue consumes the next JSON value token by token and returns how many
+// elements it had if it was an array.
+func skipValue(dec *json.Decoder) (int64, bool) {
+	var n int64
+	depth := 0
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return 0, false
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			if depth == 1 {
+				n++
+			}
+			depth++
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		default:
+			if depth == 1 {
+				n++
+			}
+		}
+		if depth == 0 {
+			return n, true
+		}
+	}
+}
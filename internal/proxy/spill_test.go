@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// embedUpstream answers /api/embed with n floats and 12 prompt tokens.
+func embedUpstream(t *testing.T, n int) *httptest.Server {
+	t.Helper()
+	vec := make([]float64, n)
+	for i := range vec {
+		vec[i] = 0.125
+	}
+	body, _ := json.Marshal(map[string]any{"model": "m", "embeddings": [][]float64{vec}, "prompt_eval_count": 12})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func embed(h *Handler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model":"m","input":"hi"}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSpill_LargeResponseServedFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	h := newTestHandlerWithConfig(t, embedUpstream(t, 4096).URL, Config{SpillThreshold: 1024, SpillDir: dir})
+	rr := embed(h)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var got struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil || len(got.Embeddings) != 1 || len(got.Embeddings[0]) != 4096 {
+		t.Fatalf("body not relayed intact: %v", err)
+	}
+	if cl := rr.Header().Get("Content-Length"); cl == "" {
+		t.Error("expected a Content-Length for a spilled body")
+	}
+	if v := testutil.ToFloat64(h.metrics.ResponseSpills.WithLabelValues("/api/embed", spillSpilled)); v != 1 {
+		t.Errorf("expected one spill, got %v", v)
+	}
+	if v := testutil.ToFloat64(h.metrics.ResponseSpillBytes.WithLabelValues("/api/embed")); v != float64(rr.Body.Len()) {
+		t.Errorf("spill bytes %v, body %d", v, rr.Body.Len())
+	}
+	if v := testutil.ToFloat64(h.metrics.TokensIn.WithLabelValues("/api/embed", "m", upstreamLabel(h.currentUpstream()), "")); v != 12 {
+		t.Errorf("expected 12 prompt tokens from the spilled body, got %v", v)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the spill file removed, found %d entries", len(entries))
+	}
+}
+
+func TestSpill_SmallResponseStaysInMemory(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{SpillThreshold: 1 << 20, SpillDir: t.TempDir()})
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if n := testutil.CollectAndCount(h.metrics.ResponseSpills); n != 0 {
+		t.Errorf("expected no spills, got %d series", n)
+	}
+}
+
+func TestSpill_OverCeilingIs502(t *testing.T) {
+	dir := t.TempDir()
+	h := newTestHandlerWithConfig(t, embedUpstream(t, 4096).URL, Config{SpillThreshold: 1024, SpillDir: dir, SpillMaxBytes: 8192})
+	rr := embed(h)
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rr.Code)
+	}
+	if v := testutil.ToFloat64(h.metrics.ResponseSpills.WithLabelValues("/api/embed", spillTooLarge)); v != 1 {
+		t.Errorf("expected one too_large, got %v", v)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the spill file removed, found %d entries", len(entries))
+	}
+}
+
+func TestSpill_UnusableDirFallsBackToMemory(t *testing.T) {
+	h := newTestHandlerWithConfig(t, embedUpstream(t, 4096).URL, Config{SpillThreshold: 1024, SpillDir: "/nonexistent/spill"})
+	if rr := embed(h); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if v := testutil.ToFloat64(h.metrics.ResponseSpills.WithLabelValues("/api/embed", spillError)); v != 1 {
+		t.Errorf("expected one error, got %v", v)
+	}
+}
+
+func TestObserveSpilled(t *testing.T) {
+	body := `{"model":"m","response":"hi","context":[1,2,3,4],"extra":{"a":[1,{"b":2}]},"done":true,"prompt_eval_count":3,"eval_count":5}`
+	var stats ChunkStats
+	if !observeSpilled(strings.NewReader(body), &stats) {
+		t.Fatal("expected a valid object")
+	}
+	if !stats.Done || stats.PromptTokens != 3 || stats.CompletionTokens != 5 || stats.ContextTokens != 4 || stats.Text() != "hi" {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if observeSpilled(strings.NewReader(`{"done":`), &stats) {
+		t.Error("expected a truncated body to be rejected")
+	}
+}
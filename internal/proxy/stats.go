@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// ServeStats reports the proxy's runtime state as JSON: the current
+// upstream, the requests in flight per model, recent malformed stream lines,
+// with ValidateUpstream, upstream conformance violations by kind and, with
+// LatencyWindow, each model's latency quantiles.
+func (h *Handler) ServeStats(w http.ResponseWriter, r *http.Request) {
+	st := h.upstream.Load()
+	h.inflightMu.Lock()
+	inflight := make(map[string]int, len(h.inflight))
+	for m, n := range h.inflight {
+		inflight[m] = n
+	}
+	h.inflightMu.Unlock()
+	out := proxyapi.Stats{
+		Upstream:      DescribeUpstream(st.url),
+		UpstreamSince: st.since.UTC().Truncate(time.Second),
+		InFlight:      inflight,
+		Malformed:     h.malformed.snapshot(time.Now()),
+	}
+	if h.cfg.ValidateUpstream {
+		out.Conformance = h.conformance.snapshot()
+	}
+	if h.cfg.LatencyWindow > 0 {
+		out.Latency = h.latency.snapshot(time.Now())
+	}
+	writeAdminJSON(w, http.StatusOK, out)
+}
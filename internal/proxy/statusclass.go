@@ -0,0 +1,31 @@
+package proxy
+
+import "strconv"
+
+// Values of the status_class label of error_duration_seconds.
+const (
+	statusClass4xx   = "4xx"
+	statusClass5xx   = "5xx"
+	statusClassError = "error" // no status to go by: a timeout, a shutdown or a response cut off midway
+)
+
+// statusClass is the class of a failed request from its status label in
+// requests_total and its error, if any, or "" for one that did not fail. A
+// client that went away is not a failure of the proxy or the upstream.
+func statusClass(status, errMsg string) string {
+	if status == statusCanceled {
+		return ""
+	}
+	code, err := strconv.Atoi(status)
+	switch {
+	case err != nil:
+		return statusClassError
+	case code >= 500:
+		return statusClass5xx
+	case code >= 400:
+		return statusClass4xx
+	case errMsg != "":
+		return statusClassError
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/nexusriot/ollama-proxy-metrics/ollamatest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStatusClass(t *testing.T) {
+	for _, tc := range []struct {
+		status, errMsg, want string
+	}{
+		{"200", "", ""},
+		{"200", "read stream: unexpected EOF", statusClassError},
+		{"404", "", statusClass4xx},
+		{"502", "upstream: connection refused", statusClass5xx},
+		{statusTimeout, "", statusClassError},
+		{statusShutdown, "", statusClassError},
+		{statusCanceled, "client gone", ""},
+	} {
+		if got := statusClass(tc.status, tc.errMsg); got != tc.want {
+			t.Errorf("statusClass(%q, %q) = %q, want %q", tc.status, tc.errMsg, got, tc.want)
+		}
+	}
+}
+
+func TestErrorDuration(t *testing.T) {
+	h, srv, fake := fakeOllama(t, ollamatest.Options{})
+
+	_, _ = io.ReadAll(postProxy(t, srv, "/api/generate", `{"model":"llama3:8b","stream":false}`).Body)
+	fake.Fail("/api/generate", ollamatest.Fault{Status: http.StatusInternalServerError, Error: "llama runner process has terminated", Times: 1})
+	_, _ = io.ReadAll(postProxy(t, srv, "/api/generate", `{"model":"llama3:8b","stream":false}`).Body)
+	_, _ = io.ReadAll(postProxy(t, srv, "/api/generate", `{"model":"mistral","stream":false}`).Body)
+	fake.Fail("/api/chat", ollamatest.Fault{AfterChunks: 1, Times: 1})
+	_, _ = io.ReadAll(postProxy(t, srv, "/api/chat", `{"model":"llama3:8b"}`).Body)
+
+	waitFor(t, "the requests to be recorded", func() bool {
+		return testutil.CollectAndCount(h.metrics.ReqTotal) == 4
+	})
+	for _, tc := range []struct {
+		endpoint, model, class string
+	}{
+		{"/api/generate", fakeModel, statusClass5xx},
+		{"/api/generate", "mistral", statusClass4xx},
+		{"/api/chat", fakeModel, statusClassError},
+	} {
+		if n := histogramCount(t, h.metrics.ErrorDuration.WithLabelValues(tc.endpoint, tc.model, tc.class)); n != 1 {
+			t.Errorf("expected one %s failure of %s on %s, got %d", tc.class, tc.model, tc.endpoint, n)
+		}
+	}
+	if n := testutil.CollectAndCount(h.metrics.ErrorDuration); n != 3 {
+		t.Errorf("expected the successful request left out, got %d series", n)
+	}
+}
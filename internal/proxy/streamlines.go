@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+)
+
+// maxHeldLine is how much of one stream line lineObserver holds to parse.
+// A longer line, such as the final chunk of a generate response over a
+// large context, is decoded incrementally as it passes instead.
+const maxHeldLine = 1 << 20
+
+// lineObserver is written the bytes of an NDJSON response as they are
+// forwarded, in pieces of any size, and feeds each complete line to stats.
+// It never holds back or fails the stream it watches: lines of any length
+// are accepted and invalid ones only reported to onLine.
+type lineObserver struct {
+	stats *ChunkStats
+	// onLine, when set, is called with each complete non-blank line (its
+	// first maxHeldLine bytes) and whether it was valid JSON.
+	onLine func(line []byte, valid bool)
+
+	line []byte
+	long *longLine // the line being decoded incrementally, if any
+}
+
+// longLine is a line past maxHeldLine being decoded by observeSpilled.
+type longLine struct {
+	w     *io.PipeWriter
+	valid chan bool
+}
+
+func newLongLine(stats *ChunkStats) *longLine {
+	pr, pw := io.Pipe()
+	l := &longLine{w: pw, valid: make(chan bool, 1)}
+	go func() {
+		l.valid <- observeSpilled(pr, stats)
+		_, _ = io.Copy(io.Discard, pr) // the rest of an invalid line
+	}()
+	return l
+}
+
+// finish ends the line and reports whether it was a valid JSON object.
+func (l *longLine) finish() bool {
+	_ = l.w.Close()
+	return <-l.valid
+}
+
+// Write implements io.Writer; it never fails.
+func (o *lineObserver) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			o.add(p)
+			break
+		}
+		o.add(p[:i])
+		o.end()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// Close observes a final line that had no newline and releases a line
+// still being decoded, e.g. when the client went away mid-line.
+func (o *lineObserver) Close() error {
+	o.end()
+	return nil
+}
+
+func (o *lineObserver) add(p []byte) {
+	if o.long != nil {
+		_, _ = o.long.w.Write(p)
+		return
+	}
+	if len(o.line)+len(p) <= maxHeldLine {
+		o.line = append(o.line, p...)
+		return
+	}
+	o.long = newLongLine(o.stats)
+	_, _ = o.long.w.Write(sseData(o.line))
+	_, _ = o.long.w.Write(p)
+}
+
+func (o *lineObserver) end() {
+	line := bytes.TrimSuffix(o.line, []byte("\r"))
+	var valid bool
+	switch {
+	case o.long != nil:
+		valid = o.long.finish()
+		o.long = nil
+	case len(bytes.TrimSpace(line)) == 0:
+		o.line = o.line[:0]
+		return
+	default:
+		valid = o.stats.observeLine(line)
+	}
+	if o.onLine != nil {
+		o.onLine(line, valid)
+	}
+	o.line = o.line[:0]
+}
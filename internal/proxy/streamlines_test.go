@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// hugeFinalChunk is a generate done chunk whose context array makes it
+// several times longer than maxHeldLine.
+func hugeFinalChunk(tokens int) string {
+	ids := strings.TrimSuffix(strings.Repeat("123456,", tokens), ",")
+	return `{"response":"","done":true,"context":[` + ids + `],"prompt_eval_count":13,"eval_count":77}`
+}
+
+func TestLineObserver_PiecesOfAnySize(t *testing.T) {
+	stream := "{\"response\":\"hel\"}\n{\"response\":\"lo\"}\r\n\n{\"done\":true,\"prompt_eval_count\":3,\"eval_count\":5}"
+	for _, size := range []int{1, 3, 7, len(stream)} {
+		var stats ChunkStats
+		o := &lineObserver{stats: &stats}
+		for p := stream; p != ""; {
+			n := min(size, len(p))
+			_, _ = o.Write([]byte(p[:n]))
+			p = p[n:]
+		}
+		_ = o.Close()
+		if stats.Text() != "hello" || !stats.Done || stats.PromptTokens != 3 || stats.CompletionTokens != 5 {
+			t.Errorf("pieces of %d: got text %q, done %v, tokens %d/%d", size, stats.Text(), stats.Done, stats.PromptTokens, stats.CompletionTokens)
+		}
+	}
+}
+
+func TestLineObserver_LongLineDecodedIncrementally(t *testing.T) {
+	line := hugeFinalChunk(500_000)
+	if len(line) <= 2*maxHeldLine {
+		t.Fatalf("test line too short: %d bytes", len(line))
+	}
+	var stats ChunkStats
+	var valid []bool
+	o := &lineObserver{stats: &stats, onLine: func(_ []byte, ok bool) { valid = append(valid, ok) }}
+	for p := line + "\n"; p != ""; {
+		n := min(64<<10, len(p))
+		_, _ = o.Write([]byte(p[:n]))
+		p = p[n:]
+	}
+	if !stats.Done || stats.PromptTokens != 13 || stats.CompletionTokens != 77 || stats.ContextTokens != 500_000 {
+		t.Errorf("expected the long line's counts, got %+v", stats)
+	}
+	if len(valid) != 1 || !valid[0] {
+		t.Errorf("expected one valid line, got %v", valid)
+	}
+}
+
+func TestLineObserver_MalformedAndAbandonedLines(t *testing.T) {
+	var stats ChunkStats
+	var invalid int
+	o := &lineObserver{stats: &stats, onLine: func(_ []byte, ok bool) {
+		if !ok {
+			invalid++
+		}
+	}}
+	_, _ = o.Write([]byte("{\"response\":\"a\"}\n{\"respo\n{\"response\":\"b\"}\n"))
+	// A long line cut off mid-way, as when the client goes away.
+	_, _ = o.Write([]byte(`{"context":[` + strings.Repeat("1,", maxHeldLine)))
+
+	closed := make(chan struct{})
+	go func() {
+		_ = o.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Close not to block on an unfinished long line")
+	}
+	if stats.Text() != "ab" || stats.Done {
+		t.Errorf("expected the valid chunks observed and no done, got %q %v", stats.Text(), stats.Done)
+	}
+	if invalid != 2 {
+		t.Errorf("expected the cut and the unfinished line reported invalid, got %d", invalid)
+	}
+}
+
+func TestStream_TokensFromLongFinalChunk(t *testing.T) {
+	final := hugeFinalChunk(500_000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "{\"response\":\"hi\",\"done\":false}\n"+final+"\n")
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":true}`)))
+
+	if rr.Code != http.StatusOK || !strings.HasSuffix(rr.Body.String(), final+"\n") {
+		t.Fatalf("expected the long chunk forwarded intact, got %d and %d bytes", rr.Code, rr.Body.Len())
+	}
+	if got := testutil.ToFloat64(h.metrics.TokensIn.WithLabelValues("/api/generate", "m", upstreamLabel(h.currentUpstream()), "")); got != 13 {
+		t.Errorf("expected 13 prompt tokens, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues("/api/generate", "m", upstreamLabel(h.currentUpstream()), "")); got != 77 {
+		t.Errorf("expected 77 completion tokens, got %v", got)
+	}
+}
+
+func TestStream_EndsWithoutDoneChunk(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "{\"response\":\"a\",\"done\":false}\n{\"response\":\"b\",\"do")
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":true}`)))
+
+	if want := "{\"response\":\"a\",\"done\":false}\n{\"response\":\"b\",\"do\n"; rr.Body.String() != want {
+		t.Errorf("expected the stream forwarded as it was, got %q", rr.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues("/api/generate", "m", upstreamLabel(h.currentUpstream()), "")); got != 0 {
+		t.Errorf("expected no completion tokens without a done chunk, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.MalformedChunks.WithLabelValues("/api/generate", "m")); got != 1 {
+		t.Errorf("expected the cut-off chunk counted as malformed, got %v", got)
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Ollama's endpoints fall into three groups as far as streaming goes: those
+// that stream unless told "stream": false, the OpenAI-compatible ones that
+// only stream when told "stream": true, and those that always answer with
+// one JSON body whatever the request says.
+var (
+	streamsByDefault = []string{"/api/generate", "/api/chat", "/api/pull", "/api/push", "/api/create"}
+	streamsOnRequest = []string{"/v1/chat/completions", "/v1/completions"}
+	neverStream      = []string{
+		"/api/embed", "/api/embeddings", "/api/tags", "/api/show", "/api/ps", "/api/version",
+		"/api/delete", "/api/copy", "/v1/embeddings", "/v1/models",
+	}
+)
+
+// hasAnySuffix reports whether endpoint ends in one of suffixes.
+func hasAnySuffix(endpoint string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(endpoint, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestStreams reports whether the upstream will stream its answer to a
+// request to endpoint whose body set stream to field (nil when absent), by
+// the endpoint's own default. Endpoints the proxy does not know follow the
+// Ollama convention of streaming unless told not to.
+func requestStreams(endpoint string, field *bool) bool {
+	switch {
+	case hasAnySuffix(endpoint, neverStream) || strings.Contains(endpoint, "/api/blobs/"):
+		return false
+	case hasAnySuffix(endpoint, streamsOnRequest):
+		return field != nil && *field
+	}
+	return field == nil || *field
+}
+
+// responseStreams reports whether to relay an upstream response line by line
+// rather than buffer it whole. The Content-Type decides: application/x-ndjson
+// (Ollama) and text/event-stream (the OpenAI endpoints) are streams, and
+// application/json is one body, which is also what Ollama sends for errors
+// to streaming requests. Only a response of another or no type falls back
+// to what the request asked for.
+func responseStreams(h http.Header, requested bool) bool {
+	ct, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch ct {
+	case "application/x-ndjson", "text/event-stream":
+		return true
+	case "application/json":
+		return false
+	}
+	return requested
+}
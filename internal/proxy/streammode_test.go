@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequestStreams(t *testing.T) {
+	yes, no := true, false
+	cases := []struct {
+		endpoint string
+		field    *bool
+		want     bool
+	}{
+		{"/api/generate", nil, true},
+		{"/api/chat", &no, false},
+		{"/api/pull", nil, true},
+		{"/api/embed", &yes, false},
+		{"/api/show", nil, false},
+		{"/api/delete", &yes, false},
+		{"/api/blobs/sha256:aaa", nil, false},
+		{"/v1/chat/completions", nil, false},
+		{"/v1/chat/completions", &yes, true},
+		{"/api/unknown", nil, true},
+	}
+	for _, tc := range cases {
+		if got := requestStreams(tc.endpoint, tc.field); got != tc.want {
+			t.Errorf("%s with stream %v: expected %v, got %v", tc.endpoint, tc.field, tc.want, got)
+		}
+	}
+}
+
+func TestResponseStreams(t *testing.T) {
+	cases := []struct {
+		contentType string
+		requested   bool
+		want        bool
+	}{
+		{"application/x-ndjson", false, true},
+		{"text/event-stream; charset=utf-8", false, true},
+		{"application/json; charset=utf-8", true, false},
+		{"text/plain", true, true},
+		{"", false, false},
+	}
+	for _, tc := range cases {
+		h := http.Header{}
+		h.Set("Content-Type", tc.contentType)
+		if got := responseStreams(h, tc.requested); got != tc.want {
+			t.Errorf("%q, requested %v: expected %v, got %v", tc.contentType, tc.requested, tc.want, got)
+		}
+	}
+}
+
+// A client that leaves stream out gets whatever the upstream sends; when that
+// is one JSON object, even spread over several lines, its token counts are
+// read from the whole body rather than lost as malformed stream lines.
+func TestStreamMode_JSONAnswerToStreamingRequest(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = fmt.Fprint(w, "{\n  \"response\": \"ok\",\n  \"done\": true,\n  \"prompt_eval_count\": 7,\n  \"eval_count\": 9\n}\n")
+	}))
+	defer upstream.Close()
+	h := newTestHandler(t, upstream.URL)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`)))
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"eval_count": 9`) {
+		t.Fatalf("expected the body relayed, got %d %q", rr.Code, rr.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues("/api/generate", "m", upstreamLabel(h.currentUpstream()), "")); got != 9 {
+		t.Errorf("expected 9 completion tokens, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.MalformedChunks.WithLabelValues("/api/generate", "m")); got != 0 {
+		t.Errorf("expected no malformed lines, got %v", got)
+	}
+}
+
+func TestStreamMode_EndpointDefaultsLabel(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	for _, tc := range []struct{ endpoint, body, stream string }{
+		{"/api/embed", `{"model":"m","input":"x","stream":true}`, "false"},
+		{"/v1/chat/completions", `{"model":"m","messages":[]}`, "false"},
+		{"/api/chat", `{"model":"m","messages":[]}`, "true"},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tc.endpoint, strings.NewReader(tc.body)))
+		c := h.metrics.ReqTotal.WithLabelValues(tc.endpoint, "m", "200", tc.stream, originUpstream, upstreamLabel(h.currentUpstream()), "")
+		if got := testutil.ToFloat64(c); got != 1 {
+			t.Errorf("%s: expected one request labelled stream=%s, got %v", tc.endpoint, tc.stream, got)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// summaryReservoirSize is how many durations per model the summary keeps to
+// estimate p50 and p95; busier models are sampled uniformly.
+const summaryReservoirSize = 1024
+
+// modelRollup accumulates one model's requests between two summaries.
+type modelRollup struct {
+	requests         int64
+	errors           int64
+	promptTokens     int64
+	completionTokens int64
+	requestBytes     int64
+	responseBytes    int64
+	durations        []int64 // milliseconds, a reservoir sample
+}
+
+func (m *modelRollup) add(s *RequestStats) {
+	m.requests++
+	if s.StatusCode >= 400 || s.Error != "" {
+		m.errors++
+	}
+	m.promptTokens += s.PromptTokens
+	m.completionTokens += s.CompletionTokens
+	m.requestBytes += s.RequestBytes
+	m.responseBytes += s.ResponseBytes
+	ms := s.Duration.Milliseconds()
+	if len(m.durations) < summaryReservoirSize {
+		m.durations = append(m.durations, ms)
+	} else if i := rand.Int64N(m.requests); i < summaryReservoirSize {
+		m.durations[i] = ms
+	}
+}
+
+// quantile returns the q-quantile of the sampled durations in milliseconds.
+func (m *modelRollup) quantile(q float64) int64 {
+	if len(m.durations) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(m.durations)
+	slices.Sort(sorted)
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// modelSummary is one model's line of a periodic summary.
+type modelSummary struct {
+	model string
+	*modelRollup
+}
+
+// summaryLogger logs a per-model rollup of the requests finished since the
+// previous one every interval.
+type summaryLogger struct {
+	logger   *slog.Logger
+	interval time.Duration
+
+	mu     sync.Mutex
+	models map[string]*modelRollup
+	since  time.Time
+}
+
+func newSummaryLogger(logger *slog.Logger, interval time.Duration) *summaryLogger {
+	return &summaryLogger{
+		logger:   logger,
+		interval: interval,
+		models:   map[string]*modelRollup{},
+		since:    time.Now(),
+	}
+}
+
+// ObserveResponse implements ResponseObserver: it adds a finished request
+// to its model's rollup.
+func (s *summaryLogger) ObserveResponse(_ context.Context, stats *RequestStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.models[stats.Model]
+	if m == nil {
+		m = &modelRollup{}
+		s.models[stats.Model] = m
+	}
+	m.add(stats)
+}
+
+// take returns the rollups by model name and starts new ones.
+func (s *summaryLogger) take(now time.Time) ([]modelSummary, time.Duration) {
+	s.mu.Lock()
+	models, since := s.models, s.since
+	s.models, s.since = map[string]*modelRollup{}, now
+	s.mu.Unlock()
+
+	out := make([]modelSummary, 0, len(models))
+	for name, m := range models {
+		out = append(out, modelSummary{model: name, modelRollup: m})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].model < out[j].model })
+	return out, now.Sub(since)
+}
+
+// flush logs one line per model that had requests since the last flush.
+func (s *summaryLogger) flush(now time.Time) {
+	summaries, period := s.take(now)
+	for _, m := range summaries {
+		s.logger.Info("summary",
+			"model", m.model,
+			"period_s", int64(period.Round(time.Second).Seconds()),
+			"requests", m.requests,
+			"errors", m.errors,
+			"p50_ms", m.quantile(0.5),
+			"p95_ms", m.quantile(0.95),
+			"prompt_tokens", m.promptTokens,
+			"completion_tokens", m.completionTokens,
+			"request_bytes", m.requestBytes,
+			"response_bytes", m.responseBytes,
+		)
+	}
+}
+
+// run logs a summary every interval until ctx is done. What was recorded
+// since the last one is logged by Handler.Close.
+func (s *summaryLogger) run(ctx context.Context) {
+	tick := time.NewTicker(s.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			s.flush(now)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSummary_RollupPerModel(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{SummaryInterval: time.Hour})
+	generate(h, "10.0.0.1")
+	generate(h, "10.0.0.2")
+
+	summaries, _ := h.summary.take(time.Now())
+	if len(summaries) != 1 || summaries[0].model != "m" {
+		t.Fatalf("expected one rollup for m, got %+v", summaries)
+	}
+	m := summaries[0]
+	if m.requests != 2 || m.errors != 0 || m.promptTokens != 80 || m.completionTokens != 120 || m.responseBytes == 0 {
+		t.Errorf("unexpected rollup %+v", *m.modelRollup)
+	}
+	if again, _ := h.summary.take(time.Now()); len(again) != 0 {
+		t.Errorf("expected the rollup reset after a summary, got %d models", len(again))
+	}
+}
+
+func TestSummary_QuantilesAndErrors(t *testing.T) {
+	var m modelRollup
+	for i := int64(1); i <= 100; i++ {
+		status := 200
+		if i%10 == 0 {
+			status = 502
+		}
+		m.add(&RequestStats{StatusCode: status, Duration: time.Duration(i) * time.Millisecond})
+	}
+	if m.errors != 10 {
+		t.Errorf("expected 10 errors, got %d", m.errors)
+	}
+	if p50, p95 := m.quantile(0.5), m.quantile(0.95); p50 != 50 || p95 != 95 {
+		t.Errorf("expected p50 50 and p95 95, got %d and %d", p50, p95)
+	}
+}
+
+func TestSummary_ReservoirIsBounded(t *testing.T) {
+	var m modelRollup
+	for i := 0; i < 10*summaryReservoirSize; i++ {
+		m.add(&RequestStats{Duration: 5 * time.Millisecond})
+	}
+	if len(m.durations) != summaryReservoirSize || m.requests != 10*summaryReservoirSize {
+		t.Errorf("expected %d samples of %d requests, got %d of %d", summaryReservoirSize, 10*summaryReservoirSize, len(m.durations), m.requests)
+	}
+}
+
+func TestSummary_LogsStructuredLines(t *testing.T) {
+	var buf bytes.Buffer
+	s := newSummaryLogger(slog.New(slog.NewJSONHandler(&buf, nil)), time.Hour)
+	s.ObserveResponse(context.Background(), &RequestStats{Model: "m", StatusCode: 200, Duration: 12 * time.Millisecond, PromptTokens: 3, CompletionTokens: 4})
+	s.flush(time.Now())
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "summary" || line["model"] != "m" || line["requests"] != float64(1) || line["p95_ms"] != float64(12) {
+		t.Errorf("unexpected summary line %v", line)
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Background worker states, the state label of background_workers.
+const (
+	workerRunning = "running"
+	workerBackoff = "backoff"
+)
+
+const (
+	workerBackoffMin = 100 * time.Millisecond
+	workerBackoffMax = 30 * time.Second
+	// A worker that ran this long before panicking starts its backoff over.
+	workerHealthyRun = time.Minute
+)
+
+// workers owns the lifecycle of the handler's background components: the
+// canary, quota flusher, conversation expiry, summary logger and backend
+// probe. Each runs until its context is cancelled; one that panics is
+// logged, counted and restarted after an exponential backoff, so a bug in
+// one component neither kills the process nor silently stops its work.
+type workers struct {
+	metrics *Metrics
+	logger  *slog.Logger
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	backoffMin, backoffMax time.Duration
+}
+
+func newWorkers(metrics *Metrics, logger *slog.Logger) *workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workers{
+		metrics:    metrics,
+		logger:     logger,
+		ctx:        ctx,
+		cancel:     cancel,
+		backoffMin: workerBackoffMin,
+		backoffMax: workerBackoffMax,
+	}
+}
+
+// start runs run as a worker of component until close. run should return
+// once its context is done; a run that returns on its own is not restarted.
+func (ws *workers) start(component string, run func(ctx context.Context)) {
+	running := ws.metrics.BackgroundWorkers.WithLabelValues(component, workerRunning)
+	running.Inc() // before the goroutine, so it is counted once start returns
+	ws.wg.Add(1)
+	go func() {
+		defer ws.wg.Done()
+		backoff := ws.backoffMin
+		for {
+			began := time.Now()
+			panicked := ws.runOnce(component, run)
+			running.Dec()
+			if !panicked || ws.ctx.Err() != nil {
+				return
+			}
+			ws.metrics.BackgroundRestarts.WithLabelValues(component).Inc()
+			if time.Since(began) >= workerHealthyRun {
+				backoff = ws.backoffMin
+			}
+			if !ws.wait(component, backoff) {
+				return
+			}
+			running.Inc()
+			backoff = min(backoff*2, ws.backoffMax)
+		}
+	}()
+}
+
+// runOnce runs run and reports whether it panicked.
+func (ws *workers) runOnce(component string, run func(ctx context.Context)) (panicked bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			panicked = true
+			ws.logger.Error("background worker panicked", "component", component, "panic", v, "stack", string(debug.Stack()))
+		}
+	}()
+	run(ws.ctx)
+	return false
+}
+
+// wait sleeps for backoff before a restart; it reports false when the
+// workers were closed meanwhile.
+func (ws *workers) wait(component string, backoff time.Duration) bool {
+	waiting := ws.metrics.BackgroundWorkers.WithLabelValues(component, workerBackoff)
+	waiting.Inc()
+	defer waiting.Dec()
+	t := time.NewTimer(backoff)
+	defer t.Stop()
+	select {
+	case <-ws.ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// close stops every worker and waits for them to return.
+func (ws *workers) close() {
+	ws.cancel()
+	ws.wg.Wait()
+}
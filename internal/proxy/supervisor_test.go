@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testWorkers(t *testing.T) *workers {
+	t.Helper()
+	ws := newWorkers(NewMetrics(prometheus.NewRegistry()), slog.New(slog.DiscardHandler))
+	ws.backoffMin, ws.backoffMax = time.Millisecond, 4*time.Millisecond
+	return ws
+}
+
+func TestWorkers_RestartsAfterPanic(t *testing.T) {
+	ws := testWorkers(t)
+	var runs atomic.Int32
+	ws.start("flaky", func(ctx context.Context) {
+		if runs.Add(1) <= 2 {
+			panic("boom")
+		}
+		<-ctx.Done()
+	})
+
+	m := ws.metrics
+	waitFor(t, "worker restarted twice", func() bool {
+		return testutil.ToFloat64(m.BackgroundWorkers.WithLabelValues("flaky", workerRunning)) == 1 && runs.Load() == 3
+	})
+	if got := testutil.ToFloat64(m.BackgroundRestarts.WithLabelValues("flaky")); got != 2 {
+		t.Errorf("expected 2 restarts, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.BackgroundWorkers.WithLabelValues("flaky", workerBackoff)); got != 0 {
+		t.Errorf("expected no worker backing off, got %v", got)
+	}
+
+	ws.close()
+	if got := testutil.ToFloat64(m.BackgroundWorkers.WithLabelValues("flaky", workerRunning)); got != 0 {
+		t.Errorf("expected no worker running after close, got %v", got)
+	}
+}
+
+func TestWorkers_CloseStopsBackoff(t *testing.T) {
+	ws := testWorkers(t)
+	ws.backoffMin = time.Hour
+	ws.start("broken", func(context.Context) { panic("boom") })
+	waitFor(t, "worker backing off", func() bool {
+		return testutil.ToFloat64(ws.metrics.BackgroundWorkers.WithLabelValues("broken", workerBackoff)) == 1
+	})
+
+	done := make(chan struct{})
+	go func() {
+		ws.close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected close not to wait out the backoff")
+	}
+	if got := testutil.ToFloat64(ws.metrics.BackgroundWorkers.WithLabelValues("broken", workerBackoff)); got != 0 {
+		t.Errorf("expected the backoff gauge cleared, got %v", got)
+	}
+}
+
+func TestWorkers_ReturnedWorkerIsNotRestarted(t *testing.T) {
+	ws := testWorkers(t)
+	var runs atomic.Int32
+	ws.start("oneshot", func(context.Context) { runs.Add(1) })
+	ws.close()
+	if runs.Load() != 1 {
+		t.Errorf("expected one run, got %d", runs.Load())
+	}
+	if got := testutil.ToFloat64(ws.metrics.BackgroundRestarts.WithLabelValues("oneshot")); got != 0 {
+		t.Errorf("expected no restarts, got %v", got)
+	}
+}
+
+func TestWorkers_HandlerRunsComponentsAndFlushesOnClose(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{SummaryInterval: time.Hour, ConversationHeader: "X-Conversation-ID"})
+	var buf bytes.Buffer
+	h.summary.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	for _, c := range []string{"summary", "conversations"} {
+		if got := testutil.ToFloat64(h.metrics.BackgroundWorkers.WithLabelValues(c, workerRunning)); got != 1 {
+			t.Errorf("expected the %s worker running, got %v", c, got)
+		}
+	}
+	generate(h, "10.0.0.1")
+
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"summary", "conversations"} {
+		if got := testutil.ToFloat64(h.metrics.BackgroundWorkers.WithLabelValues(c, workerRunning)); got != 0 {
+			t.Errorf("expected the %s worker stopped, got %v", c, got)
+		}
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"msg":"summary"`)) {
+		t.Errorf("expected the pending summary logged on close, got %q", buf.String())
+	}
+}
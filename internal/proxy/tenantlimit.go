@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ParseIntMap parses a comma-separated list of key=integer pairs such as
+// "batch=2,interactive=16". An empty string yields an empty map.
+func ParseIntMap(s string) (map[string]int, error) {
+	out := map[string]int{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid entry %q: want key=number", item)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number for %q: %w", k, err)
+		}
+		out[strings.TrimSpace(k)] = n
+	}
+	return out, nil
+}
+
+// anonymousTenant is the tenant label of requests only their client IP
+// identifies.
+const anonymousTenant = "anonymous"
+
+// tenantLabel is ri's tenant as a label of counters that live on: tenants
+// named by an API key, TenantHeader or TenantConcurrency keep their name,
+// while client IPs all count under anonymousTenant, so the series do not
+// grow with the addresses seen.
+func (h *Handler) tenantLabel(ri *reqInfo) string {
+	if clientName(ri.r) != "" || (h.cfg.TenantHeader != "" && ri.r.Header.Get(h.cfg.TenantHeader) != "") {
+		return ri.tenant
+	}
+	if _, ok := h.cfg.TenantConcurrency[ri.tenant]; ok {
+		return ri.tenant
+	}
+	return anonymousTenant
+}
+
+// tenantSlots caps the requests each tenant has in the proxy at once, queued
+// for a model slot or in flight to the upstream. Unlike the model gate it
+// never waits: a tenant at its limit is refused straight away, so one tenant
+// cannot fill the model queues for everyone else.
+type tenantSlots struct {
+	limit     int            // for tenants not in overrides; 0 = unlimited
+	overrides map[string]int // per tenant; 0 = unlimited
+	inFlight  *prometheus.GaugeVec
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func newTenantSlots(limit int, overrides map[string]int, inFlight *prometheus.GaugeVec) *tenantSlots {
+	return &tenantSlots{limit: limit, overrides: overrides, inFlight: inFlight, active: map[string]int{}}
+}
+
+// limitFor returns tenant's limit, 0 when it has none.
+func (s *tenantSlots) limitFor(tenant string) int {
+	if n, ok := s.overrides[tenant]; ok {
+		return n
+	}
+	return s.limit
+}
+
+// acquire takes a slot for tenant. It returns the tenant's limit, the
+// requests it now has active, and whether the slot was taken.
+func (s *tenantSlots) acquire(tenant string) (limit, active int, ok bool) {
+	limit = s.limitFor(tenant)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active = s.active[tenant]
+	if limit > 0 && active >= limit {
+		return limit, active, false
+	}
+	active++
+	s.active[tenant] = active
+	s.inFlight.WithLabelValues(tenant).Set(float64(active))
+	return limit, active, true
+}
+
+// release frees a slot taken by acquire. A tenant with nothing left active
+// loses its gauge series, so tenants that come and go do not pile up.
+func (s *tenantSlots) release(tenant string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.active[tenant] - 1
+	if n <= 0 {
+		delete(s.active, tenant)
+		s.inFlight.DeleteLabelValues(tenant)
+		return
+	}
+	s.active[tenant] = n
+	s.inFlight.WithLabelValues(tenant).Set(float64(n))
+}
+
+// admitTenant takes the request's tenant slot. It returns the function that
+// gives the slot back, or nil after answering the request with 429. Canary
+// probes hold no slot.
+func (h *Handler) admitTenant(w http.ResponseWriter, ri *reqInfo) func() {
+	if h.tenants == nil || isCanary(ri.r) {
+		return func() {}
+	}
+	tenant := ri.tenant
+	limit, active, ok := h.tenants.acquire(tenant)
+	if !ok {
+		h.metrics.TenantRejections.WithLabelValues(h.tenantLabel(ri)).Inc()
+		h.reject(w, ri, reasonTenantConcurrency, http.StatusTooManyRequests, time.Time{}, map[string]any{
+			"error":  "too many concurrent requests for this tenant",
+			"limit":  limit,
+			"active": active,
+		})
+		return nil
+	}
+	var once sync.Once
+	return func() { once.Do(func() { h.tenants.release(tenant) }) }
+}
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseIntMap(t *testing.T) {
+	got, err := ParseIntMap(" batch=2, interactive = 16,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"batch": 2, "interactive": 16}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	for _, bad := range []string{"batch", "=2", "batch=two"} {
+		if _, err := ParseIntMap(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+// tenantRequest sends a generate request for tenant in the background; the
+// returned channel yields its recorder once it is served.
+func tenantRequest(ctx context.Context, h *Handler, tenant string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`)).WithContext(ctx)
+	req.Header.Set("X-Tenant", tenant)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		done <- rr
+	}()
+	return done
+}
+
+func TestTenantConcurrency_RefusesOverLimit(t *testing.T) {
+	upstream, release := heldUpstream(t, true)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{
+		TenantHeader:           "X-Tenant",
+		MaxConcurrentPerTenant: 1,
+		TenantConcurrency:      map[string]int{"batch": 2, "admin": 0},
+	})
+	inFlight := func(tenant string) float64 {
+		return testutil.ToFloat64(h.metrics.TenantInFlight.WithLabelValues(tenant))
+	}
+
+	alice := tenantRequest(context.Background(), h, "alice")
+	waitFor(t, "alice's request in flight", func() bool { return inFlight("alice") == 1 })
+
+	rr := <-tenantRequest(context.Background(), h, "alice")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected alice's second request refused with 429, got %d", rr.Code)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["reason"] != reasonTenantConcurrency || body["limit"] != float64(1) {
+		t.Errorf("expected the body to name the limit, got %v", body)
+	}
+	if got := testutil.ToFloat64(h.metrics.TenantRejections.WithLabelValues("alice")); got != 1 {
+		t.Errorf("expected the rejection counted for alice, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonTenantConcurrency)); got != 1 {
+		t.Errorf("expected the policy rejection counted, got %v", got)
+	}
+
+	// Overrides: batch may have two, admin any number; a tenant not in the
+	// list, like bob, gets the default of one.
+	var others []<-chan *httptest.ResponseRecorder
+	for _, tenant := range []string{"batch", "batch", "admin", "admin", "admin", "bob"} {
+		others = append(others, tenantRequest(context.Background(), h, tenant))
+	}
+	waitFor(t, "the other tenants in flight", func() bool {
+		return inFlight("batch") == 2 && inFlight("admin") == 3 && inFlight("bob") == 1
+	})
+	if rr := <-tenantRequest(context.Background(), h, "batch"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected batch's third request refused, got %d", rr.Code)
+	}
+
+	close(release)
+	for _, done := range append(others, alice) {
+		if rr := <-done; rr.Code != http.StatusOK {
+			t.Errorf("expected the admitted requests served, got %d", rr.Code)
+		}
+	}
+	if got := testutil.CollectAndCount(h.metrics.TenantInFlight); got != 0 {
+		t.Errorf("expected no tenant series once all requests finished, got %d", got)
+	}
+}
+
+func TestTenantConcurrency_ReleasesSlotWhenCanceledInQueue(t *testing.T) {
+	upstream, release := heldUpstream(t, true)
+	defer close(release)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{
+		TenantHeader:           "X-Tenant",
+		MaxConcurrentPerTenant: 2,
+		MaxConcurrentPerModel:  1,
+	})
+	inFlight := func() float64 { return testutil.ToFloat64(h.metrics.TenantInFlight.WithLabelValues("alice")) }
+
+	tenantRequest(context.Background(), h, "alice")
+	waitFor(t, "the first request in flight", func() bool { return inFlight() == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := tenantRequest(ctx, h, "alice")
+	waitFor(t, "the second request queued", func() bool { return inFlight() == 2 })
+	cancel()
+	<-queued
+	if got := inFlight(); got != 1 {
+		t.Fatalf("expected the canceled request's slot given back, got %v in flight", got)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	tenantRequest(ctx, h, "alice")
+	waitFor(t, "a new request queued in the freed slot", func() bool { return inFlight() == 2 })
+	if got := testutil.ToFloat64(h.metrics.TenantRejections.WithLabelValues("alice")); got != 0 {
+		t.Errorf("expected no rejections, got %v", got)
+	}
+}
+
+func TestTenantConcurrency_IPTenantsCountedAsAnonymous(t *testing.T) {
+	upstream, release := heldUpstream(t, true)
+	defer close(release)
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{MaxConcurrentPerTenant: 1})
+	ipRequest := func(ip string) <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`))
+		req.RemoteAddr = ip + ":1234"
+		go func() {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			done <- rr
+		}()
+		return done
+	}
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		ipRequest(ip)
+		waitFor(t, ip+"'s request in flight", func() bool {
+			return testutil.ToFloat64(h.metrics.TenantInFlight.WithLabelValues(ip)) == 1
+		})
+		if rr := <-ipRequest(ip); rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected %s's second request refused, got %d", ip, rr.Code)
+		}
+	}
+	if n := testutil.CollectAndCount(h.metrics.TenantRejections); n != 1 {
+		t.Errorf("expected one rejection series for every client IP, got %d", n)
+	}
+	if got := testutil.ToFloat64(h.metrics.TenantRejections.WithLabelValues(anonymousTenant)); got != 2 {
+		t.Errorf("expected both rejections counted as %s, got %v", anonymousTenant, got)
+	}
+}
+
+func TestTenantOf_Header(t *testing.T) {
+	h := newTestHandlerWithConfig(t, "http://127.0.0.1:1", Config{TenantHeader: "X-Tenant"})
+	r := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+	r.RemoteAddr = "10.0.0.7:4242"
+	if got := h.tenantOf(r); got != "10.0.0.7" {
+		t.Errorf("expected the client IP without the header, got %q", got)
+	}
+	r.Header.Set("X-Tenant", "team-a")
+	if got := h.tenantOf(r); got != "team-a" {
+		t.Errorf("expected the header value, got %q", got)
+	}
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// thinkOption decodes the request's "think" option, which is a boolean or,
+// for models with thinking levels, "low", "medium" or "high". Levels count
+// as "true"; anything else leaves it unset.
+type thinkOption string
+
+// UnmarshalJSON implements json.Unmarshaler without ever failing the
+// surrounding decode.
+func (t *thinkOption) UnmarshalJSON(b []byte) error {
+	var on bool
+	if json.Unmarshal(b, &on) == nil {
+		*t = thinkOption(boolLabel(on))
+		return nil
+	}
+	var level string
+	if json.Unmarshal(b, &level) == nil && level != "" {
+		*t = "true"
+	}
+	return nil
+}
+
+// label is the requested label of ollama_proxy_thinking_requests_total.
+func (t thinkOption) label() string {
+	if t == "" {
+		return "unset"
+	}
+	return string(t)
+}
+
+func boolLabel(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// observeThinking records how much of a response was reasoning. Responses
+// without thinking text are not recorded.
+func (h *Handler) observeThinking(ri *reqInfo, stats *ChunkStats) {
+	if stats.ThinkingChars == 0 {
+		return
+	}
+	visible := int64(utf8.RuneCountInString(stats.Text()))
+	ratio := float64(stats.ThinkingChars) / float64(stats.ThinkingChars+visible)
+	h.metrics.ThinkingRequests.WithLabelValues(ri.endpoint, ri.modelLabel, ri.think.label()).Inc()
+	h.metrics.ThinkingRatio.WithLabelValues(ri.modelLabel).Observe(ratio)
+	if stats.SawCompletion {
+		h.metrics.ThinkingTokens.WithLabelValues(ri.endpoint, ri.modelLabel).Add(float64(stats.CompletionTokens) * ratio)
+	}
+}
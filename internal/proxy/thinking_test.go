@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestThinkOption(t *testing.T) {
+	for raw, want := range map[string]string{
+		`{"think":true}`: "true", `{"think":false}`: "false", `{"think":"high"}`: "true", `{}`: "unset", `{"think":{}}`: "unset",
+	} {
+		var p requestPayload
+		if err := json.Unmarshal([]byte(raw), &p); err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		if got := p.Think.label(); got != want {
+			t.Errorf("%s: got %s, want %s", raw, got, want)
+		}
+	}
+}
+
+func TestThinking_Stream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"message":{"role":"assistant","content":"","thinking":"hmm, "},"done":false}`)
+		_, _ = fmt.Fprintln(w, `{"message":{"role":"assistant","content":"","thinking":"let me see"},"done":false}`)
+		_, _ = fmt.Fprintln(w, `{"message":{"role":"assistant","content":"42"},"done":false}`)
+		_, _ = fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"eval_count":50}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	postJSON(h, "/api/chat", `{"model":"m","think":true,"messages":[{"role":"user","content":"?"}]}`)
+
+	if got := testutil.ToFloat64(h.metrics.ThinkingRequests.WithLabelValues("/api/chat", "m", "true")); got != 1 {
+		t.Errorf("expected 1 thinking request, got %v", got)
+	}
+	// 15 thinking characters, 2 visible.
+	if got := histogramSum(t, h.metrics.ThinkingRatio.WithLabelValues("m")); got != 15.0/17 {
+		t.Errorf("unexpected ratio %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.ThinkingTokens.WithLabelValues("/api/chat", "m")); got != 50*15.0/17 {
+		t.Errorf("unexpected thinking token estimate %v", got)
+	}
+}
+
+func TestThinking_NonStreamGenerate(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"response":"ok","thinking":"ab","done":true,"eval_count":4}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t, upstream.URL)
+	postJSON(h, "/api/generate", `{"model":"m","prompt":"?","stream":false}`)
+	if got := testutil.ToFloat64(h.metrics.ThinkingRequests.WithLabelValues("/api/generate", "m", "unset")); got != 1 {
+		t.Errorf("expected 1 thinking request with think unset, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.ThinkingTokens.WithLabelValues("/api/generate", "m")); got != 2 {
+		t.Errorf("expected 2 estimated thinking tokens, got %v", got)
+	}
+}
+
+func TestThinking_AbsentNotRecorded(t *testing.T) {
+	h := newTestHandler(t, tokenUpstream(t).URL)
+	generate(h, "10.0.0.1")
+	if n := testutil.CollectAndCount(h.metrics.ThinkingRequests); n != 0 {
+		t.Errorf("expected no thinking series, got %d", n)
+	}
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/kv"
+)
+
+const limiterTPM = "tpm"
+
+// tpmLimiter enforces a tokens-per-minute limit per tenant. Prompt tokens are
+// estimated and reserved at admission, then the reservation is reconciled
+// with the prompt+completion counts Ollama reports.
+type tpmLimiter struct {
+	store         kv.Store
+	limit         int64
+	window        time.Duration // a minute, except in tests
+	charsPerToken float64
+	used          *windowGauge // tpm_used_tokens
+}
+
+// tpmReservation is the part of a tenant's window a request has claimed.
+type tpmReservation struct {
+	key    string
+	tenant string
+	tokens int64
+	reset  time.Time
+}
+
+// estimate approximates the prompt tokens of a request from the prompt text,
+// or the raw body when no prompt could be extracted.
+func (l *tpmLimiter) estimate(promptText string, bodyBytes int64) int64 {
+	n := float64(utf8.RuneCountInString(promptText))
+	if n == 0 {
+		n = float64(bodyBytes)
+	}
+	return max(1, int64(math.Ceil(n/l.charsPerToken)))
+}
+
+// reserve claims tokens for tenant in the current window. It returns the
+// reservation and the window's total including it; when that exceeds the
+// limit the claim is withdrawn and ok is false.
+func (l *tpmLimiter) reserve(ctx context.Context, tenant string, tokens int64, now time.Time) (res tpmReservation, used int64, ok bool, err error) {
+	ws := windowStart(now, l.window)
+	res = tpmReservation{
+		key:    "tpm:" + tenant + ":" + strconv.FormatInt(ws.Unix(), 10),
+		tenant: tenant,
+		tokens: tokens,
+		reset:  ws.Add(l.window),
+	}
+	used, err = l.store.IncrBy(ctx, res.key, tokens, l.window+time.Second)
+	if err != nil {
+		return res, 0, true, err // fail open like the request limiter
+	}
+	if used > l.limit {
+		if back, err := l.store.IncrBy(ctx, res.key, -tokens, l.window+time.Second); err == nil {
+			used = back
+		}
+		return res, used, false, nil
+	}
+	return res, used, true, nil
+}
+
+// adjust adds delta (which may be negative) to a reservation's window and
+// returns the window's new total.
+func (l *tpmLimiter) adjust(res tpmReservation, delta int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return l.store.IncrBy(ctx, res.key, delta, l.window+time.Second)
+}
+
+// admitTPM reserves the request's estimated prompt tokens, rejecting it
+// with 429 when the tenant is over its tokens-per-minute limit.
+func (h *Handler) admitTPM(ctx context.Context, req *ParsedRequest, tenant string, now time.Time) error {
+	ri := req.ri
+	h.metrics.LimiterChecks.WithLabelValues(limiterTPM, storeSource(h.shared)).Inc()
+	est := h.tpm.estimate(ri.promptText, ri.reqBytes)
+	ri.estimates.tpm = est
+	res, used, ok, err := h.tpm.reserve(ctx, tenant, est, now)
+	if err != nil {
+		h.logRepeatable(ctx, slog.LevelWarn, "tpm limiter store error", ri.endpoint, err.Error(), "request_id", ri.id, "error", err)
+		return nil
+	}
+	h.tpm.used.set(tenant, float64(used), res.reset)
+	setRateLimitHeaders(req.ResponseHeader, "Tokens", h.tpm.limit, used, res.reset)
+	if !ok {
+		return &Rejection{
+			Reason:  reasonTPMLimited,
+			Status:  http.StatusTooManyRequests,
+			Message: "token rate limit exceeded",
+			RetryAt: res.reset,
+			Fields: map[string]any{
+				"limit":            h.tpm.limit,
+				"window":           h.tpm.window.String(),
+				"used":             used,
+				"estimated_tokens": est,
+				"reset":            res.reset.UTC().Format(time.RFC3339),
+			},
+		}
+	}
+	ri.tpm = &res
+	return nil
+}
+
+// settleTPM reconciles a request's reservation once it has finished:
+// reported token counts replace the estimate; a forwarded request without
+// counts keeps the estimate; a request that never reached the upstream is
+// refunded.
+func (h *Handler) settleTPM(ri *reqInfo) {
+	res := ri.tpm
+	if res == nil {
+		return
+	}
+	var delta int64
+	switch {
+	case ri.tokensKnown:
+		delta = ri.tokens - res.tokens
+	case ri.forwarded:
+		return
+	default:
+		delta = -res.tokens
+	}
+	if delta == 0 {
+		return
+	}
+	used, err := h.tpm.adjust(*res, delta)
+	if err != nil {
+		h.logRepeatable(context.Background(), slog.LevelWarn, "tpm reconcile failed", ri.endpoint, err.Error(), "request_id", ri.id, "delta", delta, "error", err)
+		return
+	}
+	if time.Now().Before(res.reset) {
+		h.tpm.used.set(res.tenant, float64(used), res.reset)
+	}
+}
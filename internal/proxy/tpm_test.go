@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTPMLimiter_Estimate(t *testing.T) {
+	l := &tpmLimiter{charsPerToken: 4}
+	if got := l.estimate("abcdefghi", 1000); got != 3 {
+		t.Errorf("expected ceil(9/4)=3 from prompt text, got %d", got)
+	}
+	if got := l.estimate("", 40); got != 10 {
+		t.Errorf("expected body bytes fallback 10, got %d", got)
+	}
+	if got := l.estimate("", 0); got != 1 {
+		t.Errorf("expected at least 1 token, got %d", got)
+	}
+}
+
+func tpmUsed(h *Handler, tenant string) float64 {
+	return testutil.ToFloat64(h.metrics.TPMUsed.WithLabelValues(tenant))
+}
+
+func TestTPM_ReconcilesWithActualCounts(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{TPMLimit: 200})
+	h.tpm.window = time.Hour // keep the test inside one window
+
+	generate(h, "10.0.0.1") // estimate 7 from the body, actual 100
+	if got := tpmUsed(h, "10.0.0.1"); got != 100 {
+		t.Fatalf("expected reservation reconciled to 100, got %v", got)
+	}
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusOK {
+		t.Fatalf("expected second request within limit, got %d", rr.Code)
+	}
+	rr := generate(h, "10.0.0.1")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the TPM limit, got %d", rr.Code)
+	}
+	var body map[string]any
+	_ = json.NewDecoder(rr.Body).Decode(&body)
+	if body["limit"] != float64(200) || body["reset"] == nil || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected limit and reset in the 429, got %v", body)
+	}
+	if got := tpmUsed(h, "10.0.0.1"); got != 200 {
+		t.Errorf("expected rejected estimate withdrawn, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.PolicyRejections.WithLabelValues(reasonTPMLimited)); got != 1 {
+		t.Errorf("expected tpm_limited rejection, got %v", got)
+	}
+}
+
+func TestTPM_KeepsEstimateWithoutTokenStats(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"response":"no stats here"}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{TPMLimit: 1000, CharsPerToken: 2})
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","prompt":"12345678","stream":false}`))
+	req.RemoteAddr = "10.0.0.1:1"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got := tpmUsed(h, "10.0.0.1"); got != 4 {
+		t.Errorf("expected the 4-token estimate to stand, got %v", got)
+	}
+}
+
+func TestTPM_RefundsWhenUpstreamFails(t *testing.T) {
+	h := newTestHandlerWithConfig(t, "http://127.0.0.1:1", Config{TPMLimit: 1000})
+	if rr := generate(h, "10.0.0.1"); rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rr.Code)
+	}
+	if got := tpmUsed(h, "10.0.0.1"); got != 0 {
+		t.Errorf("expected reservation refunded, got %v", got)
+	}
+}
+
+func TestTPM_IgnoresMetadataEndpoints(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{TPMLimit: 1})
+	for range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected /api/tags exempt from TPM, got %d", rr.Code)
+		}
+	}
+}
+
+func TestTPM_GaugeExpiresWithWindow(t *testing.T) {
+	h := newTestHandlerWithConfig(t, tokenUpstream(t).URL, Config{TPMLimit: 1000})
+	h.tpm.window = time.Hour
+
+	generate(h, "10.0.0.1")
+	generate(h, "10.0.0.2")
+	if n := testutil.CollectAndCount(h.metrics.TPMUsed); n != 2 {
+		t.Fatalf("expected a series per tenant, got %d", n)
+	}
+	now := time.Now()
+	h.tpm.used.expire(now)
+	if n := testutil.CollectAndCount(h.metrics.TPMUsed); n != 2 {
+		t.Fatalf("expected series kept within the window, got %d", n)
+	}
+	h.tpm.used.expire(windowStart(now, time.Hour).Add(time.Hour))
+	if n := testutil.CollectAndCount(h.metrics.TPMUsed); n != 0 {
+		t.Errorf("expected series gone once the window passed unused, got %d", n)
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Transfer operations and results, the op and result labels of
+// model_transfers_total and model_transfer_bytes_total.
+const (
+	transferPull = "pull"
+	transferPush = "push"
+
+	transferSuccess = "success"
+	transferFailure = "failure"
+)
+
+// transferOp returns the operation of a request that moves a model between
+// the upstream and a registry, or "" for other requests.
+func transferOp(endpoint string) string {
+	switch {
+	case strings.HasSuffix(endpoint, "/api/pull"):
+		return transferPull
+	case strings.HasSuffix(endpoint, "/api/push"):
+		return transferPush
+	}
+	return ""
+}
+
+// transferProgress follows the progress lines of a pull or push, such as
+// {"status":"pushing sha256:…","digest":"sha256:…","total":N,"completed":M},
+// up to {"status":"success"} or an {"error":…} line. Byte counts are
+// reported per layer and only ever grow, so each layer's increase since its
+// previous line is what moved.
+type transferProgress struct {
+	op      string
+	layers  map[string]int64 // digest → bytes completed
+	success bool
+	failed  bool
+}
+
+// newTransfer returns the progress tracker of a request to endpoint, nil
+// when it is not a pull or push.
+func newTransfer(endpoint string) *transferProgress {
+	op := transferOp(endpoint)
+	if op == "" {
+		return nil
+	}
+	return &transferProgress{op: op, layers: map[string]int64{}}
+}
+
+// observe reads one progress line and returns how many more bytes it says
+// were transferred.
+func (t *transferProgress) observe(line []byte) int64 {
+	var p struct {
+		Status    string `json:"status"`
+		Digest    string `json:"digest"`
+		Completed int64  `json:"completed"`
+		Error     string `json:"error"`
+	}
+	if json.Unmarshal(line, &p) != nil {
+		return 0
+	}
+	switch {
+	case p.Error != "":
+		t.failed = true
+	case p.Status == "success":
+		t.success = true
+	}
+	if p.Digest == "" || p.Completed <= t.layers[p.Digest] {
+		return 0
+	}
+	delta := p.Completed - t.layers[p.Digest]
+	t.layers[p.Digest] = p.Completed
+	return delta
+}
+
+// observeTransfer feeds one line of a pull or push response to its
+// progress, counting the bytes it moved as they move.
+func (h *Handler) observeTransfer(ri *reqInfo, line []byte) {
+	if ri.transfer == nil {
+		return
+	}
+	if n := ri.transfer.observe(line); n > 0 {
+		h.metrics.TransferBytes.WithLabelValues(ri.transfer.op, ri.modelLabel).Add(float64(n))
+	}
+}
+
+// finishTransfer counts a finished pull or push: a success when the
+// upstream answered 2xx and reported success without an error, a failure
+// otherwise, including a stream that ended early.
+func (h *Handler) finishTransfer(ri *reqInfo, status int, errMsg string) {
+	t := ri.transfer
+	if t == nil {
+		return
+	}
+	result := transferFailure
+	if status < 300 && t.success && !t.failed && errMsg == "" {
+		result = transferSuccess
+	}
+	h.metrics.Transfers.WithLabelValues(t.op, ri.modelLabel, result).Inc()
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// pushProgress is the progress stream of `ollama push` for a model with a
+// 3000-byte weights layer and a 200-byte template layer.
+var pushProgress = []string{
+	`{"status":"retrieving manifest"}`,
+	`{"status":"starting upload","digest":"sha256:aaa","total":3000}`,
+	`{"status":"pushing aaa","digest":"sha256:aaa","total":3000,"completed":1000}`,
+	`{"status":"pushing aaa","digest":"sha256:aaa","total":3000,"completed":2500}`,
+	`{"status":"pushing aaa","digest":"sha256:aaa","total":3000,"completed":3000}`,
+	`{"status":"pushing bbb","digest":"sha256:bbb","total":200,"completed":200}`,
+	`{"status":"pushing bbb","digest":"sha256:bbb","total":200,"completed":200}`,
+	`{"status":"pushing manifest"}`,
+	`{"status":"success"}`,
+}
+
+// progressUpstream streams lines one flush at a time.
+func progressUpstream(t *testing.T, lines []string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, l := range lines {
+			_, _ = fmt.Fprintln(w, l)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func push(h *Handler, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/push", strings.NewReader(body)))
+	return rr
+}
+
+func TestTransfer_PushProgress(t *testing.T) {
+	h := newTestHandler(t, progressUpstream(t, pushProgress).URL)
+	rr := push(h, `{"model":"me/llama3:8b"}`)
+
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "\n") != len(pushProgress) {
+		t.Fatalf("expected the progress relayed line by line, got %d %q", rr.Code, rr.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.TransferBytes.WithLabelValues(transferPush, "me/llama3:8b")); got != 3200 {
+		t.Errorf("expected 3200 bytes uploaded, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.Transfers.WithLabelValues(transferPush, "me/llama3:8b", transferSuccess)); got != 1 {
+		t.Errorf("expected one successful push, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.MalformedChunks.WithLabelValues("/api/push", "me/llama3:8b")); got != 0 {
+		t.Errorf("expected no malformed lines, got %v", got)
+	}
+}
+
+func TestTransfer_PushFailsMidStream(t *testing.T) {
+	lines := append(append([]string{}, pushProgress[:4]...), `{"error":"unauthorized: access denied"}`)
+	h := newTestHandler(t, progressUpstream(t, lines).URL)
+	push(h, `{"model":"me/llama3:8b"}`)
+
+	if got := testutil.ToFloat64(h.metrics.Transfers.WithLabelValues(transferPush, "me/llama3:8b", transferFailure)); got != 1 {
+		t.Errorf("expected one failed push, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.TransferBytes.WithLabelValues(transferPush, "me/llama3:8b")); got != 2500 {
+		t.Errorf("expected the bytes uploaded before the error, got %v", got)
+	}
+}
+
+func TestTransfer_PullNonStreaming(t *testing.T) {
+	h := newTestHandler(t, progressUpstream(t, []string{`{"status":"success"}`}).URL)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/pull", strings.NewReader(`{"model":"llama3","stream":false}`)))
+
+	if got := testutil.ToFloat64(h.metrics.Transfers.WithLabelValues(transferPull, "llama3", transferSuccess)); got != 1 {
+		t.Errorf("expected one successful pull, got %v", got)
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Kinds of upstream timeouts, the type label of upstream_timeouts_total
+// and, with a _timeout suffix, the error_type of the request's log line.
+const (
+	timeoutDial           = "dial"            // no TCP connection within DialTimeout
+	timeoutTLSHandshake   = "tls_handshake"   // no TLS session within TLSHandshakeTimeout
+	timeoutResponseHeader = "response_header" // no response headers within ResponseHeaderTimeout
+	timeoutNonStream      = "nonstream"       // no whole non-stream response within NonStreamTimeout
+)
+
+// newTransport returns the upstream transport with cfg's connection
+// settings; zero ones keep Go's defaults. It dials Unix socket upstreams
+// too.
+func newTransport(cfg Config) *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second} // as Go's default
+	if cfg.DialTimeout > 0 {
+		d.Timeout = cfg.DialTimeout
+	}
+	tr.DialContext = UpstreamDialContext(d)
+	if cfg.TLSHandshakeTimeout > 0 {
+		tr.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+	if cfg.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		tr.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.UpstreamTLS != nil {
+		tr.TLSClientConfig = cfg.UpstreamTLS.Clone()
+	}
+	return tr
+}
+
+// upstreamTimeout returns which timeout err is the transport giving up on,
+// "" when it is none or the client went away first.
+func upstreamTimeout(ctx context.Context, err error) string {
+	var ne net.Error
+	if err == nil || ctx.Err() != nil || !errors.As(err, &ne) || !ne.Timeout() {
+		return ""
+	}
+	var op *net.OpError
+	switch {
+	case errors.As(err, &op) && op.Op == "dial":
+		return timeoutDial
+	case isTLSHandshakeTimeout(err):
+		return timeoutTLSHandshake
+	}
+	return timeoutResponseHeader
+}
+
+// isTLSHandshakeTimeout reports whether err is the transport's TLS
+// handshake timeout, which net/http does not export.
+func isTLSHandshakeTimeout(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if err.Error() == "net/http: TLS handshake timeout" {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamTimedOut answers a request whose upstream connection or response
+// headers timed out with 504, and counts it by timeout kind.
+func (h *Handler) upstreamTimedOut(w http.ResponseWriter, ri *reqInfo, kind string, err error) {
+	h.metrics.UpstreamTimeouts.WithLabelValues(ri.endpoint, kind).Inc()
+	text, errorType := "upstream sent no response headers in time", errorTypeHeaderTimeout
+	if kind != timeoutResponseHeader {
+		text, errorType = "upstream connection timed out", kind+"_timeout"
+	}
+	h.upstreamFailed(w, ri, http.StatusGatewayTimeout, text, "upstream: "+err.Error(), "error_type", errorType)
+}
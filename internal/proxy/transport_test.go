@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewTransport(t *testing.T) {
+	def := newTransport(Config{})
+	if def.TLSHandshakeTimeout != 10*time.Second || def.IdleConnTimeout != 90*time.Second || def.MaxIdleConnsPerHost != 0 {
+		t.Errorf("expected Go's defaults without settings, got %s %s %d", def.TLSHandshakeTimeout, def.IdleConnTimeout, def.MaxIdleConnsPerHost)
+	}
+	tr := newTransport(Config{TLSHandshakeTimeout: time.Second, IdleConnTimeout: time.Minute, MaxIdleConnsPerHost: 32})
+	if tr.TLSHandshakeTimeout != time.Second || tr.IdleConnTimeout != time.Minute || tr.MaxIdleConnsPerHost != 32 {
+		t.Errorf("expected the settings applied, got %s %s %d", tr.TLSHandshakeTimeout, tr.IdleConnTimeout, tr.MaxIdleConnsPerHost)
+	}
+}
+
+func TestNewTransport_UpstreamTLS(t *testing.T) {
+	var sni string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sni = r.TLS.ServerName
+		_, _ = w.Write([]byte(`{"version":"0.5.0"}`))
+	}))
+	defer upstream.Close()
+
+	rr := httptest.NewRecorder()
+	newTestHandler(t, upstream.URL).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected an untrusted certificate refused with 502, got %d", rr.Code)
+	}
+
+	// httptest's certificate is for example.com, not the address dialed.
+	roots := x509.NewCertPool()
+	roots.AddCert(upstream.Certificate())
+	cfg := &tls.Config{RootCAs: roots, ServerName: "example.com"}
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{UpstreamTLS: cfg})
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	if rr.Code != http.StatusOK || sni != "example.com" {
+		t.Errorf("expected the CA trusted and the server name sent, got %d with SNI %q", rr.Code, sni)
+	}
+	if tr := newTransport(Config{UpstreamTLS: cfg}); tr.TLSClientConfig == cfg {
+		t.Error("expected the transport to own a copy of the TLS configuration")
+	}
+}
+
+func TestUpstreamTimeout_Kinds(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	read := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want string
+	}{
+		{"dial", context.Background(), dial, timeoutDial},
+		{"awaiting headers", context.Background(), read, timeoutResponseHeader},
+		{"not a timeout", context.Background(), errors.New("connection refused"), ""},
+		{"client gone", canceled, dial, ""},
+		{"no error", context.Background(), nil, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := upstreamTimeout(tc.ctx, tc.err); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestUpstreamTimeout_TLSHandshake(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() { // accepts, never speaks TLS
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	h := newTestHandlerWithConfig(t, "https://"+ln.Addr().String(), Config{TLSHandshakeTimeout: 30 * time.Millisecond})
+	rr := generate(h, "10.0.0.1")
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d %s", rr.Code, rr.Body.String())
+	}
+	if got := testutil.ToFloat64(h.metrics.UpstreamTimeouts.WithLabelValues("/api/generate", timeoutTLSHandshake)); got != 1 {
+		t.Errorf("expected a tls_handshake timeout counted, got %v", got)
+	}
+}
+
+func TestUpstreamTimeouts_Counted(t *testing.T) {
+	hang := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/chat") {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush() // headers in time, body never
+		}
+		<-hang
+	}))
+	defer upstream.Close()
+	defer close(hang)
+
+	h := newTestHandlerWithConfig(t, upstream.URL, Config{ResponseHeaderTimeout: 20 * time.Millisecond, NonStreamTimeout: 50 * time.Millisecond})
+	generate(h, "10.0.0.1")
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"m","stream":false}`)))
+
+	if got := testutil.ToFloat64(h.metrics.UpstreamTimeouts.WithLabelValues("/api/generate", timeoutResponseHeader)); got != 1 {
+		t.Errorf("expected a response_header timeout counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.UpstreamTimeouts.WithLabelValues("/api/chat", timeoutNonStream)); got != 1 {
+		t.Errorf("expected a nonstream timeout counted, got %v", got)
+	}
+}
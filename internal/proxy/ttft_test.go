@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowStreamUpstream sends its first chunk after first and the final one
+// after another rest.
+func slowStreamUpstream(t *testing.T, first, rest time.Duration) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(first)
+		_, _ = fmt.Fprintln(w, `{"response":"a","done":false}`)
+		w.(http.Flusher).Flush()
+		time.Sleep(rest)
+		_, _ = fmt.Fprintln(w, `{"response":"","done":true,"eval_count":2}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTTFT_StreamFirstChunk(t *testing.T) {
+	h := newTestHandler(t, slowStreamUpstream(t, 50*time.Millisecond, 150*time.Millisecond).URL)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":true}`)))
+
+	o := h.metrics.TTFT.WithLabelValues("/api/generate", "m")
+	if n := histogramCount(t, o); n != 1 {
+		t.Fatalf("expected one observation, got %d", n)
+	}
+	if got := histogramSum(t, o); got < 0.05 || got >= 0.2 {
+		t.Errorf("expected the time to the first chunk only, got %v", got)
+	}
+}
+
+func TestTTFT_NonStreamWholeBody(t *testing.T) {
+	h := newTestHandler(t, slowStreamUpstream(t, 0, 80*time.Millisecond).URL)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`)))
+
+	o := h.metrics.TTFT.WithLabelValues("/api/generate", "m")
+	if n, got := histogramCount(t, o), histogramSum(t, o); n != 1 || got < 0.08 {
+		t.Errorf("expected one observation covering the whole body, got %d summing to %v", n, got)
+	}
+}
+
+func TestTTFT_NothingReceivedNotObserved(t *testing.T) {
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer empty.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	for name, url := range map[string]string{"empty stream": empty.URL, "unreachable": dead.URL} {
+		h := newTestHandler(t, url)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":true}`)))
+		if n := histogramCount(t, h.metrics.TTFT.WithLabelValues("/api/generate", "m")); n != 0 {
+			t.Errorf("%s: expected no observation, got %d", name, n)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// schemeUnix is the scheme of upstreams reached over a Unix domain socket:
+// unix:///run/ollama/ollama.sock, optionally followed by a path prefix.
+const schemeUnix = "unix"
+
+// socketHosts gives each Unix socket upstream a dummy host: its requests go
+// out as http://<host>/..., and the transport's dialer connects to the
+// socket when it is asked for that host. Hosts are process-wide, like the
+// sockets they stand for.
+type socketHosts struct {
+	mu     sync.Mutex
+	byHost map[string]string // socket path by dummy host
+	byPath map[string]string // dummy host by socket path
+}
+
+var unixSockets = &socketHosts{byHost: map[string]string{}, byPath: map[string]string{}}
+
+// host returns the dummy host of the socket at p: its file name, made a
+// valid host name under .localhost, numbered when another socket has it.
+func (s *socketHosts) host(p string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.byPath[p]; ok {
+		return h
+	}
+	name := strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, path.Base(p)), ".-")
+	if name == "" {
+		name = "socket"
+	}
+	h := name + ".localhost"
+	for i := 2; s.byHost[h] != ""; i++ {
+		h = fmt.Sprintf("%s-%d.localhost", name, i)
+	}
+	s.byHost[h], s.byPath[p] = p, h
+	return h
+}
+
+// socket returns the socket path of a dummy host.
+func (s *socketHosts) socket(host string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.byHost[host]
+	return p, ok
+}
+
+// splitSocketPath splits the path of a unix:// URL into the socket and the
+// path prefix after it. The socket is the first leading part that is a
+// socket on disk, else the first ending in ".sock", else the whole path.
+func splitSocketPath(p string) (socket, prefix string) {
+	var named string
+	for i := 1; i <= len(p); i++ {
+		if i < len(p) && p[i] != '/' {
+			continue
+		}
+		if fi, err := os.Stat(p[:i]); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			return p[:i], p[i:]
+		}
+		if named == "" && strings.HasSuffix(p[:i], ".sock") {
+			named = p[:i]
+		}
+	}
+	if named != "" {
+		return named, p[len(named):]
+	}
+	return p, ""
+}
+
+// unixUpstream returns the http:// URL requests to the socket upstream u
+// are sent to.
+func unixUpstream(u *url.URL) (*url.URL, error) {
+	if u.Host != "" || u.Opaque != "" || !strings.HasPrefix(u.Path, "/") {
+		return nil, errors.New("a unix upstream names an absolute socket path, as in unix:///run/ollama/ollama.sock")
+	}
+	socket, prefix := splitSocketPath(path.Clean(u.Path))
+	return &url.URL{Scheme: "http", Host: unixSockets.host(socket), Path: prefix, RawQuery: u.RawQuery}, nil
+}
+
+// SocketPath returns the socket of u when ParseUpstream made it from a
+// unix:// URL.
+func SocketPath(u *url.URL) (string, bool) {
+	return unixSockets.socket(u.Host)
+}
+
+// DescribeUpstream is u as given for a Unix socket upstream, its redacted
+// form otherwise; logs and the upstream_info metric show it.
+func DescribeUpstream(u *url.URL) string {
+	socket, ok := SocketPath(u)
+	if !ok {
+		return u.Redacted()
+	}
+	return (&url.URL{Scheme: schemeUnix, Path: socket + u.Path}).String()
+}
+
+// UpstreamDialContext wraps d to connect to the socket of a Unix socket
+// upstream's dummy host, and to addr over network otherwise.
+func UpstreamDialContext(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if socket, ok := unixSockets.socket(host); ok {
+				return d.DialContext(ctx, "unix", socket)
+			}
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// socketDir is a short temporary directory: socket paths are limited to
+// about a hundred bytes, which t.TempDir may exceed.
+func socketDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// unixServer serves handler on a Unix socket at path.
+func unixServer(t *testing.T, path string, handler http.Handler) {
+	t.Helper()
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+}
+
+func TestSplitSocketPath(t *testing.T) {
+	dir := socketDir(t)
+	bare := filepath.Join(dir, "ollama") // a socket without the .sock suffix
+	unixServer(t, bare, http.NotFoundHandler())
+
+	for _, tc := range []struct{ in, socket, prefix string }{
+		{bare, bare, ""},
+		{bare + "/ollama/v1", bare, "/ollama/v1"},
+		{"/run/ollama/ollama.sock", "/run/ollama/ollama.sock", ""},
+		{"/run/ollama/ollama.sock/llm", "/run/ollama/ollama.sock", "/llm"},
+		{"/run/ollama/socket", "/run/ollama/socket", ""},
+	} {
+		socket, prefix := splitSocketPath(tc.in)
+		if socket != tc.socket || prefix != tc.prefix {
+			t.Errorf("%s: expected socket %s and prefix %q, got %s and %q", tc.in, tc.socket, tc.prefix, socket, prefix)
+		}
+	}
+}
+
+func TestParseUpstream_Unix(t *testing.T) {
+	u, err := ParseUpstream("unix:///run/ollama/ollama.sock/llm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "http" || u.Path != "/llm" || !strings.HasSuffix(u.Host, ".localhost") {
+		t.Errorf("expected an http URL with a dummy host and the prefix, got %s", u)
+	}
+	if got := DescribeUpstream(u); got != "unix:///run/ollama/ollama.sock/llm" {
+		t.Errorf("expected the socket URL described as given, got %s", got)
+	}
+	again, _ := ParseUpstream("unix:///run/ollama/ollama.sock")
+	other, _ := ParseUpstream("unix:///srv/other/ollama.sock")
+	if again.Host != u.Host || other.Host == u.Host {
+		t.Errorf("expected one host per socket, got %s, %s and %s", u.Host, again.Host, other.Host)
+	}
+	for _, raw := range []string{"unix://run/ollama.sock", "unix:ollama.sock"} {
+		if _, err := ParseUpstream(raw); err == nil {
+			t.Errorf("%s: expected an error without an absolute socket path", raw)
+		}
+	}
+}
+
+func TestServeHTTP_UnixSocketUpstream(t *testing.T) {
+	sock := filepath.Join(socketDir(t), "ollama.sock")
+	var host, path string
+	unixServer(t, sock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, path = r.Host, r.URL.Path
+		if r.URL.Path == "/llm/api/version" {
+			_, _ = fmt.Fprint(w, `{"version":"0.5.1"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("X-Upstream-Trace", "abc")
+		for _, tok := range []string{"he", "llo"} {
+			_, _ = fmt.Fprintf(w, `{"response":%q,"done":false}`+"\n", tok)
+			w.(http.Flusher).Flush()
+		}
+		_, _ = fmt.Fprint(w, `{"response":"","done":true,"prompt_eval_count":4,"eval_count":2}`+"\n")
+	}))
+
+	h := newTestHandler(t, "unix://"+sock+"/llm")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m"}`)))
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Upstream-Trace") != "abc" {
+		t.Fatalf("expected the stream relayed with its headers, got %d %v", rr.Code, rr.Header())
+	}
+	lines := 0
+	for sc := bufio.NewScanner(rr.Body); sc.Scan(); lines++ {
+	}
+	if lines != 3 || path != "/llm/api/generate" || !strings.HasSuffix(host, ".localhost") {
+		t.Errorf("expected 3 lines from /llm/api/generate with a dummy host, got %d from %s on %s", lines, path, host)
+	}
+	label := upstreamLabel(h.currentUpstream())
+	if got := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues("/api/generate", "m", label, "")); got != 2 {
+		t.Errorf("expected token metrics under upstream %s, got %v", label, got)
+	}
+	if got := testutil.ToFloat64(h.metrics.UpstreamInfo.WithLabelValues("unix://" + sock + "/llm")); got != 1 {
+		t.Errorf("expected upstream_info to name the socket, got %v", got)
+	}
+
+	// Features that call Ollama themselves use the same transport.
+	if err := h.probeUpstream(context.Background(), h.currentUpstream()); err != nil {
+		t.Errorf("expected the version probe to reach the socket, got %v", err)
+	}
+}
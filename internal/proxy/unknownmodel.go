@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Model labels of requests that name no model.
+const (
+	modelUnknown = "unknown" // the request should have named one
+	modelNone    = "-"       // the endpoint takes none
+)
+
+// Causes of ollama_proxy_unknown_model_requests_total.
+const (
+	causeNoBody           = "no_body"
+	causeParseError       = "parse_error"
+	causeBeyondSniff      = "beyond_sniff_window"
+	causeFieldMissing     = "field_missing"
+	causeNonModelEndpoint = "non_model_endpoint"
+)
+
+// takesNoModel reports whether endpoint is about the server rather than a
+// model, so a missing model is expected there.
+func takesNoModel(endpoint string) bool {
+	switch endpoint {
+	case "/api/tags", "/api/ps", "/api/version", "/v1/models":
+		return true
+	}
+	return false
+}
+
+// requestModel returns the model label of a request and, when it names no
+// model, why: the cause is empty for requests with a model.
+func requestModel(endpoint string, body []byte, p requestPayload, parseErr error) (model, cause string) {
+	switch {
+	case takesNoModel(endpoint):
+		return modelNone, causeNonModelEndpoint
+	case p.Model != "":
+		return p.Model, ""
+	case len(bytes.TrimSpace(body)) == 0:
+		return modelUnknown, causeNoBody
+	case errors.Is(parseErr, errBeyondSniff):
+		return modelUnknown, causeBeyondSniff
+	case parseErr != nil:
+		return modelUnknown, causeParseError
+	}
+	return modelUnknown, causeFieldMissing
+}
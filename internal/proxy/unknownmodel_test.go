@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUnknownModel_Causes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"done":true}`)
+	}))
+	defer upstream.Close()
+	h := newTestHandler(t, upstream.URL)
+
+	for _, tc := range []struct{ method, path, body, cause string }{
+		{http.MethodPost, "/api/show", "", causeNoBody},
+		{http.MethodPost, "/api/generate", `{"model":`, causeParseError},
+		{http.MethodPost, "/api/generate", `{"prompt":"hi","stream":false}`, causeFieldMissing},
+		{http.MethodGet, "/api/tags", "", causeNonModelEndpoint},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got := testutil.ToFloat64(h.metrics.UnknownModelRequests.WithLabelValues(tc.path, tc.cause)); got != 1 {
+			t.Errorf("%s %q: expected cause %s counted once, got %v", tc.path, tc.body, tc.cause, got)
+		}
+	}
+	postJSON(h, "/api/generate", `{"model":"m","stream":false}`)
+	if n := testutil.CollectAndCount(h.metrics.UnknownModelRequests); n != 4 {
+		t.Errorf("expected a request with a model not counted, got %d series", n)
+	}
+}
+
+func TestUnknownModel_NonModelEndpointsLabelledDash(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"version":"0.9.0"}`)
+	}))
+	defer upstream.Close()
+	h := newTestHandler(t, upstream.URL)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/version", modelNone, "200", "false", originUpstream, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected /api/version under model %q, got %v", modelNone, got)
+	}
+}
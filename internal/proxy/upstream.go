@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+// upstreamState is the Ollama base URL new requests are sent to. It is
+// swapped as a whole so a request sees either the old or the new upstream,
+// never a mix; requests already in flight keep the one they started with.
+type upstreamState struct {
+	url   *url.URL
+	since time.Time
+}
+
+// currentUpstream returns the upstream for a new request.
+func (h *Handler) currentUpstream() *url.URL {
+	return h.upstream.Load().url
+}
+
+// setUpstream makes u the upstream for new requests and returns the previous
+// one. Cached metadata came from the old upstream and is dropped.
+func (h *Handler) setUpstream(u *url.URL) *url.URL {
+	h.upstreamMu.Lock() // keeps UpstreamInfo to one series under racing switches
+	defer h.upstreamMu.Unlock()
+	old := h.upstream.Swap(&upstreamState{url: u, since: time.Now()})
+	if old != nil {
+		h.metrics.UpstreamInfo.DeleteLabelValues(DescribeUpstream(old.url))
+	}
+	h.metrics.UpstreamInfo.WithLabelValues(DescribeUpstream(u)).Set(1)
+	if h.cache != nil {
+		h.cache.invalidate()
+	}
+	if h.showCache != nil {
+		h.showCache.invalidate()
+	}
+	if old == nil {
+		return nil
+	}
+	return old.url
+}
+
+// upstreamEndpoint returns the URL endpoint is forwarded to on base: the
+// base URL's own path, then UpstreamPathPrefix, then endpoint, joined with
+// one slash between them however many each brings. The endpoint label is
+// always the client's path, never this one.
+func (h *Handler) upstreamEndpoint(base *url.URL, endpoint string) *url.URL {
+	return base.JoinPath(h.cfg.UpstreamPathPrefix, endpoint)
+}
+
+// ParseUpstream validates a base URL accepted for an upstream. A unix://
+// URL is returned as the http:// one its requests are sent to; see
+// DescribeUpstream.
+func ParseUpstream(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == schemeUnix {
+		return unixUpstream(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("scheme must be http, https or unix, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	return u, nil
+}
+
+// probeUpstream checks that u answers /api/version like an Ollama server.
+func (h *Handler) probeUpstream(ctx context.Context, u *url.URL) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	probe := h.upstreamEndpoint(u, "/api/version")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probe.String(), nil)
+	if err != nil {
+		return err
+	}
+	h.setUpstreamAuth(req.Header, u)
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var v struct {
+		Version string `json:"version"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/api/version answered %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&v); err != nil || v.Version == "" {
+		return errors.New("/api/version did not return an Ollama version")
+	}
+	return nil
+}
+
+func (h *Handler) handleGetUpstream(w http.ResponseWriter, r *http.Request) {
+	st := h.upstream.Load()
+	writeAdminJSON(w, http.StatusOK, proxyapi.Upstream{URL: DescribeUpstream(st.url), Since: st.since.UTC().Truncate(time.Second)})
+}
+
+func (h *Handler) handlePutUpstream(w http.ResponseWriter, r *http.Request) {
+	var req proxyapi.UpstreamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, proxyapi.ErrorResponse{Error: "invalid body: " + err.Error()})
+		return
+	}
+	req.Force = req.Force || r.URL.Query().Get("force") == "true"
+	u, err := ParseUpstream(req.URL)
+	if err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, proxyapi.ErrorResponse{Error: "invalid upstream url: " + err.Error()})
+		return
+	}
+	probeErr := h.probeUpstream(r.Context(), u)
+	if probeErr != nil && !req.Force {
+		writeAdminJSON(w, http.StatusConflict, proxyapi.ErrorResponse{
+			Error: "upstream probe failed: " + probeErr.Error() + " (pass force=true to switch anyway)",
+		})
+		return
+	}
+	old := h.setUpstream(u)
+	resp := proxyapi.UpstreamSwitch{URL: DescribeUpstream(u), Previous: DescribeUpstream(old)}
+	if probeErr != nil {
+		resp.ProbeError = probeErr.Error()
+		h.logger.Warn("upstream switched despite failed probe", "from", DescribeUpstream(old), "to", DescribeUpstream(u), "error", probeErr)
+	} else {
+		h.logger.Info("upstream switched", "from", DescribeUpstream(old), "to", DescribeUpstream(u))
+	}
+	writeAdminJSON(w, http.StatusOK, resp)
+}
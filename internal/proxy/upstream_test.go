@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// namedUpstream is an Ollama stand-in whose responses say which one it is.
+func namedUpstream(t *testing.T, name string, gate chan struct{}) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/version" {
+			_, _ = fmt.Fprint(w, `{"version":"0.5.1"}`)
+			return
+		}
+		if gate != nil {
+			<-gate
+		}
+		_, _ = fmt.Fprintf(w, `{"response":%q,"done":true}`, name)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPutUpstream_SwitchesNewRequestsOnly(t *testing.T) {
+	gate := make(chan struct{})
+	oldUp := namedUpstream(t, "old", gate)
+	newUp := namedUpstream(t, "new", nil)
+	h := newTestHandler(t, oldUp.URL)
+	mux := http.NewServeMux()
+	h.RegisterAdmin(mux, "secret")
+
+	inflight := make(chan string)
+	go func() { inflight <- generate(h, "10.0.0.1").Body.String() }()
+	waitFor(t, "request in flight", func() bool { return h.busy("m") })
+
+	rr := adminDo(t, mux, http.MethodPut, "/admin/upstream", "secret", `{"url":"`+newUp.URL+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	close(gate)
+	if body := <-inflight; !strings.Contains(body, `"old"`) {
+		t.Errorf("expected in-flight request to finish on the old upstream, got %s", body)
+	}
+	if body := generate(h, "10.0.0.1").Body.String(); !strings.Contains(body, `"new"`) {
+		t.Errorf("expected new requests on the new upstream, got %s", body)
+	}
+	if n := testutil.CollectAndCount(h.metrics.UpstreamInfo); n != 1 {
+		t.Errorf("expected one upstream_info series, got %d", n)
+	}
+	if got := testutil.ToFloat64(h.metrics.UpstreamInfo.WithLabelValues(newUp.URL)); got != 1 {
+		t.Errorf("expected upstream_info for the new url, got %v", got)
+	}
+
+	stats := httptest.NewRecorder()
+	h.ServeStats(stats, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var st map[string]any
+	_ = json.NewDecoder(stats.Body).Decode(&st)
+	if st["upstream"] != newUp.URL {
+		t.Errorf("expected /stats to show the new upstream, got %v", st)
+	}
+}
+
+func TestPutUpstream_ProbeFailure(t *testing.T) {
+	up := namedUpstream(t, "old", nil)
+	dead := httptest.NewServer(http.NotFoundHandler())
+	defer dead.Close()
+	h := newTestHandler(t, up.URL)
+	mux := http.NewServeMux()
+	h.RegisterAdmin(mux, "secret")
+
+	if rr := adminDo(t, mux, http.MethodPut, "/admin/upstream", "secret", `{"url":"ftp://x"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a non-http url, got %d", rr.Code)
+	}
+	if rr := adminDo(t, mux, http.MethodPut, "/admin/upstream", "secret", `{"url":"`+dead.URL+`"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 when the probe fails, got %d", rr.Code)
+	}
+	if h.currentUpstream().String() != up.URL {
+		t.Fatalf("expected upstream unchanged after a failed probe, got %s", h.currentUpstream())
+	}
+	rr := adminDo(t, mux, http.MethodPut, "/admin/upstream?force=true", "secret", `{"url":"`+dead.URL+`"}`)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "probe_error") {
+		t.Fatalf("expected forced switch to succeed and report the probe error, got %d %s", rr.Code, rr.Body)
+	}
+	if h.currentUpstream().String() != dead.URL {
+		t.Errorf("expected forced switch applied, got %s", h.currentUpstream())
+	}
+}
+
+func TestUpstreamEndpoint_JoinsPaths(t *testing.T) {
+	for _, tc := range []struct {
+		base, prefix, want string
+	}{
+		{"http://ollama:11434", "", "http://ollama:11434/api/generate"},
+		{"http://ollama:11434/", "", "http://ollama:11434/api/generate"},
+		{"http://gw/llm/ollama", "", "http://gw/llm/ollama/api/generate"},
+		{"http://gw/llm/ollama/", "", "http://gw/llm/ollama/api/generate"},
+		{"http://gw/llm//ollama//", "", "http://gw/llm/ollama/api/generate"},
+		{"http://gw", "/llm/ollama", "http://gw/llm/ollama/api/generate"},
+		{"http://gw/", "llm/ollama/", "http://gw/llm/ollama/api/generate"},
+		{"http://gw/llm/", "/ollama/", "http://gw/llm/ollama/api/generate"},
+	} {
+		base, err := ParseUpstream(tc.base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := &Handler{cfg: Config{UpstreamPathPrefix: tc.prefix}}
+		if got := h.upstreamEndpoint(base, "/api/generate").String(); got != tc.want {
+			t.Errorf("%s with prefix %q: got %s, want %s", tc.base, tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestServeHTTP_UpstreamPathPrefix(t *testing.T) {
+	var paths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandlerWithConfig(t, upstream.URL+"/llm/", Config{UpstreamPathPrefix: "/ollama/"})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/generate?debug=1", strings.NewReader(`{"model":"m","stream":false}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if len(paths) != 1 || paths[0] != "/llm/ollama/api/generate?debug=1" {
+		t.Errorf("expected the prefixed path with the query, got %v", paths)
+	}
+	if got := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "200", "false", originUpstream, upstreamLabel(h.currentUpstream()), "")); got != 1 {
+		t.Errorf("expected the endpoint label without the prefix, got %v", got)
+	}
+}
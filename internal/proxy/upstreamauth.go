@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ParseStringMap parses a comma-separated list of key=value pairs such as
+// "ollama.com=KEY,gpu-b:11434=OTHER". An empty string yields an empty map.
+func ParseStringMap(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(k) == "" || strings.TrimSpace(v) == "" {
+			// Values may be secrets, so the entry is not echoed.
+			return nil, errors.New("invalid entry: want key=value")
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
+
+// upstreamLabel is the upstream label of request and token metrics: the
+// upstream's host and port, never its credentials or path.
+func upstreamLabel(u *url.URL) string {
+	return u.Host
+}
+
+// setUpstreamAuth gives an upstream request the bearer token configured for
+// u's host in UpstreamTokens. Otherwise the client's own Authorization is
+// dropped, so it never reaches an upstream it was not meant for, unless u's
+// host is listed in UpstreamAuthPassthrough.
+func (h *Handler) setUpstreamAuth(hdr http.Header, u *url.URL) {
+	if token, ok := h.cfg.UpstreamTokens[u.Host]; ok {
+		hdr.Set("Authorization", "Bearer "+token)
+		return
+	}
+	if !slices.Contains(h.cfg.UpstreamAuthPassthrough, u.Host) {
+		hdr.Del("Authorization")
+	}
+}
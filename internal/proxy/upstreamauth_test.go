@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// authUpstream records the Authorization header of every request it gets.
+func authUpstream(t *testing.T, got *[]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*got = append(*got, r.Header.Get("Authorization"))
+		if r.URL.Path == "/api/version" {
+			_, _ = fmt.Fprint(w, `{"version":"0.9.0"}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"response":"ok","done":true,"prompt_eval_count":3,"eval_count":5}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func generateWithAuth(h *Handler, auth string) {
+	req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestUpstreamAuth_TokenReplacesClientHeader(t *testing.T) {
+	var got []string
+	srv := authUpstream(t, &got)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	h := newTestHandlerWithConfig(t, srv.URL, Config{UpstreamTokens: map[string]string{host: "cloud-key"}})
+
+	generateWithAuth(h, "Bearer client-key")
+	generateWithAuth(h, "")
+	if len(got) != 2 || got[0] != "Bearer cloud-key" || got[1] != "Bearer cloud-key" {
+		t.Fatalf("expected the configured token on every request, got %q", got)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	if err := h.probeUpstream(context.Background(), u); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if got[2] != "Bearer cloud-key" {
+		t.Errorf("expected the probe to send the token, got %q", got[2])
+	}
+}
+
+func TestUpstreamAuth_ClientHeaderDroppedWithoutToken(t *testing.T) {
+	var got []string
+	srv := authUpstream(t, &got)
+	h := newTestHandlerWithConfig(t, srv.URL, Config{UpstreamTokens: map[string]string{"ollama.com": "cloud-key"}})
+
+	generateWithAuth(h, "Bearer client-key")
+	if len(got) != 1 || got[0] != "" {
+		t.Fatalf("expected no credential upstream, got %q", got)
+	}
+}
+
+func TestUpstreamAuth_TokenlessBackendGetsNoCredential(t *testing.T) {
+	var cloud, local []string
+	cloudSrv, localSrv := authUpstream(t, &cloud), authUpstream(t, &local)
+	cloudHost := strings.TrimPrefix(cloudSrv.URL, "http://")
+	backend, _ := url.Parse(localSrv.URL)
+	h := newTestHandlerWithConfig(t, cloudSrv.URL, Config{
+		UpstreamTokens: map[string]string{cloudHost: "cloud-key"},
+		Backends:       []*url.URL{backend},
+	})
+
+	generateWithAuth(h, "Bearer client-key")
+	if len(local) != 1 || local[0] != "" {
+		t.Fatalf("expected the backend to get no client credential, got %q", local)
+	}
+}
+
+func TestUpstreamAuth_Passthrough(t *testing.T) {
+	var got []string
+	srv := authUpstream(t, &got)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	h := newTestHandlerWithConfig(t, srv.URL, Config{UpstreamAuthPassthrough: []string{host}})
+
+	generateWithAuth(h, "Bearer client-key")
+	if len(got) != 1 || got[0] != "Bearer client-key" {
+		t.Fatalf("expected the client's header passed to an opted-in host, got %q", got)
+	}
+}
+
+func TestUpstreamAuth_UpstreamLabel(t *testing.T) {
+	var got []string
+	srv := authUpstream(t, &got)
+	h := newTestHandler(t, srv.URL)
+	generateWithAuth(h, "")
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if v := testutil.ToFloat64(h.metrics.ReqTotal.WithLabelValues("/api/generate", "m", "200", "false", originUpstream, host, "")); v != 1 {
+		t.Errorf("expected one request labelled %s, got %v", host, v)
+	}
+	if v := testutil.ToFloat64(h.metrics.TokensIn.WithLabelValues("/api/generate", "m", host, "")); v != 3 {
+		t.Errorf("expected 3 prompt tokens labelled %s, got %v", host, v)
+	}
+	if v := testutil.ToFloat64(h.metrics.TokensOut.WithLabelValues("/api/generate", "m", host, "")); v != 5 {
+		t.Errorf("expected 5 completion tokens labelled %s, got %v", host, v)
+	}
+}
+
+func TestParseStringMap(t *testing.T) {
+	m, err := ParseStringMap(" ollama.com=KEY , gpu-b:11434=OTHER,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || m["ollama.com"] != "KEY" || m["gpu-b:11434"] != "OTHER" {
+		t.Errorf("unexpected map %v", m)
+	}
+	for _, bad := range []string{"ollama.com", "=KEY", "ollama.com="} {
+		_, err := ParseStringMap(bad)
+		if err == nil {
+			t.Errorf("%q: expected an error", bad)
+			continue
+		}
+		if strings.Contains(err.Error(), "KEY") {
+			t.Errorf("%q: error echoes the value: %v", bad, err)
+		}
+	}
+}
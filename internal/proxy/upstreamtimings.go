@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// minRateDuration is the shortest eval phase a tokens-per-second rate is
+// computed over; below it, as in the odd partial response, the rate is
+// noise.
+const minRateDuration = time.Millisecond
+
+// observeUpstreamDurations records the durations Ollama reported on the
+// final object of a response, buffered or streamed, and the generation and
+// prompt processing rates they give with the token counts. Durations it did
+// not report are not observed, so an embed response without eval_duration
+// does not pull the generation histogram towards zero.
+func (h *Handler) observeUpstreamDurations(ri *reqInfo, stats *ChunkStats) {
+	for _, d := range []struct {
+		hist *prometheus.HistogramVec
+		d    time.Duration
+	}{
+		{h.metrics.UpstreamTotalDuration, stats.TotalDuration},
+		{h.metrics.UpstreamLoadDuration, stats.LoadDuration},
+		{h.metrics.UpstreamPromptEvalDuration, stats.PromptEvalDuration},
+		{h.metrics.UpstreamEvalDuration, stats.EvalDuration},
+	} {
+		if d.d > 0 {
+			d.hist.WithLabelValues(ri.endpoint, ri.modelLabel).Observe(d.d.Seconds())
+		}
+	}
+	if stats.SawCompletion {
+		observeRate(h.metrics.GenerationTPS, ri.modelLabel, stats.CompletionTokens, stats.EvalDuration)
+	}
+	if stats.SawPrompt {
+		observeRate(h.metrics.PromptTPS, ri.modelLabel, stats.PromptTokens, stats.PromptEvalDuration)
+	}
+}
+
+// observeRate observes tokens per second of an eval phase, unless either
+// side is missing or the phase was too short to tell.
+func observeRate(hist *prometheus.HistogramVec, model string, tokens int64, d time.Duration) {
+	if tokens <= 0 || d < minRateDuration {
+		return
+	}
+	hist.WithLabelValues(model).Observe(float64(tokens) / d.Seconds())
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// finalTimings is the timing part of an Ollama final object: 2.5s in all,
+// 1s of it loading, 0.25s on the prompt and 1.2s generating.
+const finalTimings = `"total_duration":2500000000,"load_duration":1000000000,` +
+	`"prompt_eval_duration":250000000,"eval_duration":1200000000`
+
+func TestUpstreamDurations(t *testing.T) {
+	for _, tc := range []struct {
+		name, body, reply string
+	}{
+		{"non-stream", `{"model":"m","stream":false}`,
+			`{"response":"hi","done":true,"eval_count":2,` + finalTimings + `}`},
+		{"stream", `{"model":"m"}`,
+			`{"response":"h","done":false}` + "\n" + `{"response":"i","done":true,"eval_count":2,` + finalTimings + `}` + "\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprint(w, tc.reply)
+			}))
+			defer upstream.Close()
+			h := newTestHandler(t, upstream.URL)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(tc.body)))
+
+			checks := map[string]struct{ got, want float64 }{
+				"total":       {histogramSum(t, h.metrics.UpstreamTotalDuration.WithLabelValues("/api/generate", "m")), 2.5},
+				"load":        {histogramSum(t, h.metrics.UpstreamLoadDuration.WithLabelValues("/api/generate", "m")), 1},
+				"prompt eval": {histogramSum(t, h.metrics.UpstreamPromptEvalDuration.WithLabelValues("/api/generate", "m")), 0.25},
+				"eval":        {histogramSum(t, h.metrics.UpstreamEvalDuration.WithLabelValues("/api/generate", "m")), 1.2},
+			}
+			for name, c := range checks {
+				if c.got != c.want {
+					t.Errorf("expected %s duration %vs, got %v", name, c.want, c.got)
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamDurations_AbsentNotObserved(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"embeddings":[[0.1]],"total_duration":30000000,"load_duration":0}`)
+	}))
+	defer upstream.Close()
+	h := newTestHandler(t, upstream.URL)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model":"m","input":"x"}`)))
+
+	if got := histogramCount(t, h.metrics.UpstreamTotalDuration.WithLabelValues("/api/embed", "m")); got != 1 {
+		t.Errorf("expected total_duration observed, got %v observations", got)
+	}
+	for name, n := range map[string]uint64{
+		"load":        histogramCount(t, h.metrics.UpstreamLoadDuration.WithLabelValues("/api/embed", "m")),
+		"prompt eval": histogramCount(t, h.metrics.UpstreamPromptEvalDuration.WithLabelValues("/api/embed", "m")),
+		"eval":        histogramCount(t, h.metrics.UpstreamEvalDuration.WithLabelValues("/api/embed", "m")),
+	} {
+		if n != 0 {
+			t.Errorf("expected no %s duration observed for a zero or absent field, got %v", name, n)
+		}
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	for _, tc := range []struct {
+		name, reply         string
+		generation, prompt  uint64
+		genRate, promptRate float64
+	}{
+		{"both phases", `{"done":true,"prompt_eval_count":40,"eval_count":60,` + finalTimings + `}`, 1, 1, 50, 160},
+		{"no durations", `{"done":true,"prompt_eval_count":40,"eval_count":60}`, 0, 0, 0, 0},
+		{"too short to tell", `{"done":true,"eval_count":5,"eval_duration":100}`, 0, 0, 0, 0},
+		{"no tokens", `{"done":true,"eval_count":0,` + finalTimings + `}`, 0, 0, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprint(w, tc.reply)
+			}))
+			defer upstream.Close()
+			h := newTestHandler(t, upstream.URL)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"m","stream":false}`)))
+
+			gen, prompt := h.metrics.GenerationTPS.WithLabelValues("m"), h.metrics.PromptTPS.WithLabelValues("m")
+			if n, sum := histogramCount(t, gen), histogramSum(t, gen); n != tc.generation || sum != tc.genRate {
+				t.Errorf("expected %d generation rate of %v tok/s, got %d summing to %v", tc.generation, tc.genRate, n, sum)
+			}
+			if n, sum := histogramCount(t, prompt), histogramSum(t, prompt); n != tc.prompt || sum != tc.promptRate {
+				t.Errorf("expected %d prompt rate of %v tok/s, got %d summing to %v", tc.prompt, tc.promptRate, n, sum)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+// Package ollamatest provides a fake Ollama server for tests of code that
+// talks to Ollama, the proxy included: an httptest.Server answering
+// /api/generate, /api/chat, /api/embed, /api/embeddings, /api/tags, /api/ps,
+// /api/show and /api/version in Ollama's wire format, with configurable
+// chunk timing and faults injected per route.
+//
+//	srv := ollamatest.NewServer(ollamatest.Options{ChunkDelay: 50 * time.Millisecond})
+//	defer srv.Close()
+//	srv.Fail("/api/chat", ollamatest.Fault{Status: http.StatusInternalServerError, Times: 1})
+package ollamatest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Model is a model the fake has pulled: it is listed by /api/tags and
+// /api/ps, and requests for any other model get Ollama's 404.
+type Model struct {
+	Name              string // with its tag, as in llama3:8b
+	Family            string
+	ParameterSize     string
+	QuantizationLevel string
+	Size              int64 // bytes on disk and in memory
+}
+
+// DefaultModel is the only model of a fake created without Models.
+var DefaultModel = Model{Name: "llama3:8b", Family: "llama", ParameterSize: "8.0B", QuantizationLevel: "Q4_0", Size: 4661224676}
+
+// Options controls the fake's models and responses.
+type Options struct {
+	// Models are the models the fake has; DefaultModel when empty.
+	Models []Model
+	// Tokens are the generated tokens of every generate and chat response,
+	// one per streamed chunk; "Hello", " world", "!" when empty.
+	Tokens []string
+	// PromptTokens is reported as prompt_eval_count; 0 estimates it from
+	// the prompt, about four bytes per token.
+	PromptTokens int
+	// FirstChunkDelay is how long the fake waits before the first streamed
+	// chunk, or the whole non-streamed response, as a model loading or
+	// evaluating the prompt would; ChunkDelay is the wait before each
+	// further chunk.
+	FirstChunkDelay time.Duration
+	ChunkDelay      time.Duration
+	// Version is reported by /api/version; "0.5.7" when empty.
+	Version string
+}
+
+// Fault makes a route fail. With Status set the route answers it with
+// Ollama's JSON error body; with AfterChunks set instead a stream is cut
+// off, the connection dropped, after that many chunks. Delay holds the
+// response back first; a fault with only a Delay makes a route slow.
+type Fault struct {
+	Status      int
+	Error       string // the error message; the status text when empty
+	AfterChunks int
+	Delay       time.Duration
+	// Times is how many requests fail; 0 fails all of them until Clear.
+	Times int
+}
+
+// Request is a request the fake received.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Server is a fake Ollama server listening on a local address.
+type Server struct {
+	*httptest.Server
+	opts Options
+
+	mu       sync.Mutex
+	faults   map[string]*Fault
+	requests []Request
+}
+
+// NewServer starts a fake Ollama server; callers Close it when done.
+func NewServer(opts Options) *Server {
+	s := NewUnstartedServer(opts)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a fake that is not started yet, so that its
+// listener or TLS configuration can be changed first, as with
+// httptest.NewUnstartedServer.
+func NewUnstartedServer(opts Options) *Server {
+	if len(opts.Models) == 0 {
+		opts.Models = []Model{DefaultModel}
+	}
+	if len(opts.Tokens) == 0 {
+		opts.Tokens = []string{"Hello", " world", "!"}
+	}
+	if opts.Version == "" {
+		opts.Version = "0.5.7"
+	}
+	s := &Server{opts: opts, faults: map[string]*Fault{}}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Fail makes requests to path fail with f, replacing its previous fault.
+func (s *Server) Fail(path string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[path] = &f
+}
+
+// Clear removes every fault.
+func (s *Server) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = map[string]*Fault{}
+}
+
+// Requests returns the requests received so far, oldest first.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// fault returns the fault of path, counting one use of it.
+func (s *Server) fault(path string) (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.faults[path]
+	if !ok {
+		return Fault{}, false
+	}
+	if f.Times > 0 {
+		if f.Times--; f.Times == 0 {
+			delete(s.faults, path)
+		}
+	}
+	return *f, true
+}
+
+// request is the subset of request payloads the fake reads.
+type request struct {
+	Model    string `json:"model"`
+	Name     string `json:"name"` // /api/show's legacy field
+	Stream   *bool  `json:"stream"`
+	Prompt   string `json:"prompt"`
+	Messages []struct {
+		Content string `json:"content"`
+	} `json:"messages"`
+	Input json.RawMessage `json:"input"`
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+	s.mu.Unlock()
+
+	var q request
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &q); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid character in request body")
+			return
+		}
+	}
+	f, faulty := s.fault(r.URL.Path)
+	if faulty && !sleep(r, f.Delay) {
+		return
+	}
+	if faulty && f.Status != 0 {
+		msg := f.Error
+		if msg == "" {
+			msg = strings.ToLower(http.StatusText(f.Status))
+		}
+		writeError(w, f.Status, msg)
+		return
+	}
+	cutAfter := -1
+	if faulty && f.AfterChunks > 0 {
+		cutAfter = f.AfterChunks
+	}
+	name := q.Model
+	if name == "" {
+		name = q.Name
+	}
+
+	switch r.URL.Path {
+	case "/api/generate", "/api/chat":
+		if m, ok := s.model(name); ok {
+			s.generate(w, r, q, m, r.URL.Path == "/api/chat", cutAfter)
+			return
+		}
+	case "/api/embed", "/api/embeddings":
+		if m, ok := s.model(name); ok {
+			s.embed(w, r, q, m)
+			return
+		}
+	case "/api/show":
+		if m, ok := s.model(name); ok {
+			writeJSON(w, map[string]any{
+				"modelfile": "FROM " + m.Name,
+				"details":   details(m),
+				"model_info": map[string]any{
+					"general.architecture":       m.Family,
+					m.Family + ".context_length": 8192,
+				},
+			})
+			return
+		}
+	case "/api/tags":
+		models := make([]map[string]any, 0, len(s.opts.Models))
+		for _, m := range s.opts.Models {
+			models = append(models, map[string]any{
+				"name": m.Name, "model": m.Name, "size": m.Size, "digest": digest(m),
+				"modified_at": "2024-06-01T12:00:00Z", "details": details(m),
+			})
+		}
+		writeJSON(w, map[string]any{"models": models})
+		return
+	case "/api/ps":
+		models := make([]map[string]any, 0, len(s.opts.Models))
+		for _, m := range s.opts.Models {
+			models = append(models, map[string]any{
+				"name": m.Name, "model": m.Name, "size": m.Size, "size_vram": m.Size, "digest": digest(m),
+				"expires_at": time.Now().Add(5 * time.Minute).UTC().Format(time.RFC3339Nano), "details": details(m),
+			})
+		}
+		writeJSON(w, map[string]any{"models": models})
+		return
+	case "/api/version":
+		writeJSON(w, map[string]any{"version": s.opts.Version})
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("model %q not found, try pulling it first", name))
+}
+
+// model returns the model named name, which matches a model's name with
+// or without its :latest tag.
+func (s *Server) model(name string) (Model, bool) {
+	if name != "" && !strings.Contains(name[strings.LastIndexByte(name, '/')+1:], ":") {
+		name += ":latest"
+	}
+	for _, m := range s.opts.Models {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Model{}, false
+}
+
+// generate answers /api/generate and /api/chat, streaming one chunk per
+// token unless the request set "stream": false, and dropping the connection
+// after cutAfter chunks when it is not negative.
+func (s *Server) generate(w http.ResponseWriter, r *http.Request, q request, m Model, chat bool, cutAfter int) {
+	start := time.Now()
+	stream := q.Stream == nil || *q.Stream
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	var text strings.Builder
+	evalStart := start
+	for i, tok := range s.opts.Tokens {
+		delay := s.opts.ChunkDelay
+		if i == 0 {
+			delay = s.opts.FirstChunkDelay
+		}
+		if !sleep(r, delay) {
+			return
+		}
+		if i == 0 {
+			evalStart = time.Now()
+		}
+		if !stream {
+			text.WriteString(tok)
+			continue
+		}
+		if i == cutAfter {
+			panic(http.ErrAbortHandler)
+		}
+		if err := enc.Encode(chunk(m, tok, chat)); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if stream && cutAfter >= len(s.opts.Tokens) {
+		panic(http.ErrAbortHandler)
+	}
+
+	prompt := s.opts.PromptTokens
+	if prompt <= 0 {
+		prompt = max(1, q.promptBytes()/4)
+	}
+	final := chunk(m, text.String(), chat)
+	final["done"] = true
+	final["done_reason"] = "stop"
+	final["total_duration"] = time.Since(start).Nanoseconds()
+	final["load_duration"] = int64(0)
+	final["prompt_eval_count"] = prompt
+	final["prompt_eval_duration"] = evalStart.Sub(start).Nanoseconds()
+	final["eval_count"] = len(s.opts.Tokens)
+	final["eval_duration"] = time.Since(evalStart).Nanoseconds()
+	_ = enc.Encode(final)
+}
+
+// embed answers /api/embed, one vector per input, and the legacy
+// /api/embeddings.
+func (s *Server) embed(w http.ResponseWriter, r *http.Request, q request, m Model) {
+	vec := []float64{0.1, -0.2, 0.3, -0.4}
+	if r.URL.Path == "/api/embeddings" {
+		writeJSON(w, map[string]any{"embedding": vec})
+		return
+	}
+	n := 1
+	var inputs []string
+	if json.Unmarshal(q.Input, &inputs) == nil {
+		n = len(inputs)
+	}
+	embeddings := make([][]float64, n)
+	for i := range embeddings {
+		embeddings[i] = vec
+	}
+	prompt := s.opts.PromptTokens
+	if prompt <= 0 {
+		prompt = max(1, q.promptBytes()/4)
+	}
+	writeJSON(w, map[string]any{
+		"model":             m.Name,
+		"embeddings":        embeddings,
+		"total_duration":    int64(1000000),
+		"load_duration":     int64(0),
+		"prompt_eval_count": prompt,
+	})
+}
+
+func (q request) promptBytes() int {
+	n := len(q.Prompt) + len(q.Input)
+	for _, m := range q.Messages {
+		n += len(m.Content)
+	}
+	return n
+}
+
+// chunk is a generate or chat response object carrying text.
+func chunk(m Model, text string, chat bool) map[string]any {
+	c := map[string]any{
+		"model":      m.Name,
+		"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+		"done":       false,
+	}
+	if chat {
+		c["message"] = map[string]any{"role": "assistant", "content": text}
+	} else {
+		c["response"] = text
+	}
+	return c
+}
+
+func details(m Model) map[string]any {
+	return map[string]any{
+		"format": "gguf", "family": m.Family, "families": []string{m.Family},
+		"parameter_size": m.ParameterSize, "quantization_level": m.QuantizationLevel,
+	}
+}
+
+// digest is a stable stand-in for the model's digest.
+func digest(m Model) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(m.Name)))
+}
+
+// sleep waits d, and reports false when the client went away first.
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-r.Context().Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers status with Ollama's error body.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
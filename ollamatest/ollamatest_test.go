@@ -0,0 +1,152 @@
+package ollamatest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func post(t *testing.T, s *Server, path, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(s.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func decode(t *testing.T, r io.Reader) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestGenerate_StreamAndFinalStats(t *testing.T) {
+	s := NewServer(Options{Tokens: []string{"a", "b", "c", "d"}, PromptTokens: 9})
+	defer s.Close()
+
+	resp := post(t, s, "/api/generate", `{"model":"llama3:8b","prompt":"hi"}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected NDJSON, got %s", ct)
+	}
+	var lines []map[string]any
+	for sc := bufio.NewScanner(resp.Body); sc.Scan(); {
+		var v map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &v); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, v)
+	}
+	if len(lines) != 5 || lines[0]["response"] != "a" || lines[0]["done"] != false {
+		t.Fatalf("expected a chunk per token and a final object, got %v", lines)
+	}
+	final := lines[4]
+	if final["done"] != true || final["eval_count"] != float64(4) || final["prompt_eval_count"] != float64(9) ||
+		final["done_reason"] != "stop" || final["total_duration"] == nil {
+		t.Errorf("unexpected final object %v", final)
+	}
+}
+
+func TestChat_NonStream(t *testing.T) {
+	s := NewServer(Options{})
+	defer s.Close()
+
+	resp := post(t, s, "/api/chat", `{"model":"llama3:8b","stream":false,"messages":[{"role":"user","content":"hello there"}]}`)
+	v := decode(t, resp.Body)
+	msg, _ := v["message"].(map[string]any)
+	if v["done"] != true || msg["content"] != "Hello world!" || v["eval_count"] != float64(3) {
+		t.Errorf("expected one object with the whole message, got %v", v)
+	}
+	reqs := s.Requests()
+	if len(reqs) != 1 || reqs[0].Path != "/api/chat" || !strings.Contains(string(reqs[0].Body), "hello there") {
+		t.Errorf("expected the request recorded, got %+v", reqs)
+	}
+}
+
+func TestUnknownModel(t *testing.T) {
+	s := NewServer(Options{Models: []Model{{Name: "qwen2:latest", Family: "qwen2"}}})
+	defer s.Close()
+
+	if resp := post(t, s, "/api/generate", `{"model":"qwen2","stream":false}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected a name without a tag to match :latest, got %d", resp.StatusCode)
+	}
+	resp := post(t, s, "/api/chat", `{"model":"llama3"}`)
+	if v := decode(t, resp.Body); resp.StatusCode != http.StatusNotFound || !strings.Contains(v["error"].(string), "not found") {
+		t.Errorf("expected Ollama's 404, got %d %v", resp.StatusCode, v)
+	}
+}
+
+func TestEmbedTagsAndPS(t *testing.T) {
+	s := NewServer(Options{})
+	defer s.Close()
+
+	v := decode(t, post(t, s, "/api/embed", `{"model":"llama3:8b","input":["a","b","c"]}`).Body)
+	if embeddings, _ := v["embeddings"].([]any); len(embeddings) != 3 {
+		t.Errorf("expected a vector per input, got %v", v)
+	}
+	for _, path := range []string{"/api/tags", "/api/ps"} {
+		resp, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := decode(t, resp.Body)
+		_ = resp.Body.Close()
+		models, _ := v["models"].([]any)
+		if len(models) != 1 || models[0].(map[string]any)["name"] != DefaultModel.Name {
+			t.Errorf("%s: expected the default model, got %v", path, v)
+		}
+	}
+}
+
+func TestFault(t *testing.T) {
+	s := NewServer(Options{})
+	defer s.Close()
+
+	s.Fail("/api/generate", Fault{Status: http.StatusServiceUnavailable, Error: "server busy", Times: 1})
+	resp := post(t, s, "/api/generate", `{"model":"llama3:8b"}`)
+	if v := decode(t, resp.Body); resp.StatusCode != http.StatusServiceUnavailable || v["error"] != "server busy" {
+		t.Errorf("expected the injected error, got %d %v", resp.StatusCode, v)
+	}
+	if resp := post(t, s, "/api/generate", `{"model":"llama3:8b","stream":false}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the fault used up after one request, got %d", resp.StatusCode)
+	}
+
+	s.Fail("/api/chat", Fault{AfterChunks: 2})
+	resp = post(t, s, "/api/chat", `{"model":"llama3:8b"}`)
+	body, err := io.ReadAll(resp.Body)
+	if err == nil || errors.Is(err, io.EOF) || strings.Count(string(body), "\n") != 2 {
+		t.Errorf("expected the stream cut after 2 chunks, got %q, %v", body, err)
+	}
+	s.Clear()
+	if resp := post(t, s, "/api/chat", `{"model":"llama3:8b","stream":false}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected Clear to remove the fault, got %d", resp.StatusCode)
+	}
+}
+
+func TestChunkTiming(t *testing.T) {
+	s := NewServer(Options{FirstChunkDelay: 60 * time.Millisecond, ChunkDelay: 30 * time.Millisecond})
+	defer s.Close()
+
+	start := time.Now()
+	resp := post(t, s, "/api/generate", `{"model":"llama3:8b"}`)
+	r := bufio.NewReader(resp.Body)
+	if _, err := r.ReadBytes('\n'); err != nil {
+		t.Fatal(err)
+	}
+	if first := time.Since(start); first < 60*time.Millisecond {
+		t.Errorf("expected the first chunk after 60ms, got it after %s", first)
+	}
+	_, _ = io.Copy(io.Discard, r)
+	if total := time.Since(start); total < 120*time.Millisecond {
+		t.Errorf("expected 30ms between chunks, the stream took %s", total)
+	}
+}
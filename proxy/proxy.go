@@ -0,0 +1,88 @@
+// Package proxy is the Ollama metrics proxy for programs that embed it in
+// their own gateway: the handler, its configuration and the hooks that
+// extend it without a fork. The implementation lives in internal/proxy;
+// the types here are aliases of it, so a *Handler from New is the same
+// handler the ollama-proxy-metrics command serves.
+//
+//	store, _ := proxy.OpenStore("proxy.sqlite")
+//	upstream, _ := proxy.ParseUpstream("http://127.0.0.1:11434")
+//	h := proxy.New(upstream, store, slog.Default(), proxy.NewMetrics(prometheus.DefaultRegisterer), proxy.Config{})
+//	defer h.Close()
+//	h.Use(proxy.Hooks{Observers: []proxy.ResponseObserver{billing}})
+package proxy
+
+import (
+	"log/slog"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/kv"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+)
+
+type (
+	// Handler is the proxy, an http.Handler for Ollama's API.
+	Handler = proxy.Handler
+	// Config holds the optional features of a Handler; the zero value
+	// turns them all off.
+	Config = proxy.Config
+	// Metrics are the Prometheus families a Handler records into.
+	Metrics = proxy.Metrics
+	// MetricsOptions shape the families of NewMetricsWithOptions.
+	MetricsOptions = proxy.MetricsOptions
+
+	// Hooks are registered on a Handler with Handler.Use.
+	Hooks                = proxy.Hooks
+	RequestInspector     = proxy.RequestInspector
+	RequestInspectorFunc = proxy.RequestInspectorFunc
+	ForwardInspector     = proxy.ForwardInspector
+	ForwardInspectorFunc = proxy.ForwardInspectorFunc
+	ResponseObserver     = proxy.ResponseObserver
+	ResponseObserverFunc = proxy.ResponseObserverFunc
+	ParsedRequest        = proxy.ParsedRequest
+	Rejection            = proxy.Rejection
+	RequestStats         = proxy.RequestStats
+
+	// APIKey, SLOTarget and TokenPrice are the element types of Config's
+	// APIKeys, SLOTargets and TokenPrices.
+	APIKey     = proxy.APIKey
+	SLOTarget  = proxy.SLOTarget
+	TokenPrice = proxy.TokenPrice
+
+	// Store is the SQLite database a Handler persists requests to.
+	Store = db.Store
+	// SharedStore is the state shared by replicas, Config.SharedStore;
+	// nil keeps it in memory.
+	SharedStore = kv.Store
+)
+
+// New returns a Handler forwarding to upstream. Close it when done to stop
+// its background workers and flush pending writes.
+func New(upstream *url.URL, store *Store, logger *slog.Logger, metrics *Metrics, cfg Config) *Handler {
+	return proxy.New(upstream, store, logger, metrics, cfg)
+}
+
+// OpenStore opens, creating it when missing, the SQLite database at path;
+// ":memory:" keeps it in memory.
+func OpenStore(path string) (*Store, error) {
+	return db.Open(path)
+}
+
+// ParseUpstream parses an upstream base URL: http://, https:// or
+// unix:///path/to/ollama.sock.
+func ParseUpstream(raw string) (*url.URL, error) {
+	return proxy.ParseUpstream(raw)
+}
+
+// NewMetrics registers the proxy's metric families on reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	return proxy.NewMetrics(reg)
+}
+
+// NewMetricsWithOptions registers the proxy's metric families on reg,
+// shaped by opts.
+func NewMetricsWithOptions(reg prometheus.Registerer, opts MetricsOptions) *Metrics {
+	return proxy.NewMetricsWithOptions(reg, opts)
+}
@@ -0,0 +1,71 @@
+package proxy_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nexusriot/ollama-proxy-metrics/ollamatest"
+	"github.com/nexusriot/ollama-proxy-metrics/proxy"
+)
+
+// TestEmbedded drives the proxy through its public API only, as another
+// module embedding it would.
+func TestEmbedded(t *testing.T) {
+	fake := ollamatest.NewServer(ollamatest.Options{})
+	defer fake.Close()
+	store, err := proxy.OpenStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	upstream, err := proxy.ParseUpstream(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := proxy.New(upstream, store, logger, proxy.NewMetrics(prometheus.NewRegistry()), proxy.Config{})
+	defer h.Close()
+
+	observed := make(chan proxy.RequestStats, 2)
+	h.Use(proxy.Hooks{
+		Inspectors: []proxy.RequestInspector{proxy.RequestInspectorFunc(
+			func(_ context.Context, req *proxy.ParsedRequest) error {
+				if req.Header.Get("X-Tenant") == "" {
+					return &proxy.Rejection{Status: http.StatusUnauthorized, Message: "missing tenant"}
+				}
+				return nil
+			})},
+		Observers: []proxy.ResponseObserver{proxy.ResponseObserverFunc(
+			func(_ context.Context, stats *proxy.RequestStats) { observed <- *stats })},
+	})
+
+	generate := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/generate",
+			strings.NewReader(`{"model":"llama3:8b","prompt":"hi","stream":false}`))
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := generate(""); rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "missing tenant") {
+		t.Fatalf("expected the inspector's 401, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := generate("acme"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	for _, want := range []int{http.StatusUnauthorized, http.StatusOK} {
+		if stats := <-observed; stats.StatusCode != want {
+			t.Errorf("expected an observed %d, got %d", want, stats.StatusCode)
+		}
+	}
+}
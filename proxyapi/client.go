@@ -0,0 +1,202 @@
+package proxyapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls a proxy's JSON endpoints. /stats and /readyz need no token;
+// the admin endpoints need the proxy's -admin-token.
+type Client struct {
+	// HTTPClient sends the requests; http.DefaultClient when nil.
+	HTTPClient *http.Client
+
+	base  string
+	token string
+}
+
+// NewClient returns a client of the proxy at baseURL (e.g.
+// "http://localhost:8080") that authenticates admin calls with authToken.
+func NewClient(baseURL, authToken string) *Client {
+	return &Client{base: strings.TrimRight(baseURL, "/"), token: authToken}
+}
+
+// StatusError is a call the proxy answered with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	// Message and Reason are the ErrorResponse the proxy sent, if any.
+	Message string
+	Reason  string
+}
+
+func (e *StatusError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("proxyapi: %d: %s", e.StatusCode, msg)
+}
+
+// SchemaError is a response of another SchemaVersion than this package's.
+type SchemaError struct {
+	Got string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("proxyapi: response schema version %q, expected %q", e.Got, SchemaVersion)
+}
+
+// Stats returns the proxy's runtime state.
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var out Stats
+	if err := c.do(ctx, http.MethodGet, "/stats", nil, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Inflight returns the requests in flight per model, from /stats.
+func (c *Client) Inflight(ctx context.Context) (map[string]int, error) {
+	st, err := c.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.InFlight, nil
+}
+
+// Ready returns the proxy's readiness. A proxy that is not ready is not an
+// error: its report says why.
+func (c *Client) Ready(ctx context.Context) (*Ready, error) {
+	var out Ready
+	if err := c.do(ctx, http.MethodGet, "/readyz", nil, &out, http.StatusOK, http.StatusServiceUnavailable); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Maintenance returns the models in maintenance, ordered by model.
+func (c *Client) Maintenance(ctx context.Context) ([]Maintenance, error) {
+	var out MaintenanceList
+	if err := c.do(ctx, http.MethodGet, "/admin/models", nil, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return out.Maintenance, nil
+}
+
+// SetMaintenance puts model in maintenance with msg as the message its
+// requests are rejected with (a default one when empty), for expiresIn or,
+// when it is 0, until cleared.
+func (c *Client) SetMaintenance(ctx context.Context, model, msg string, expiresIn time.Duration) (*Maintenance, error) {
+	req := MaintenanceRequest{Message: msg}
+	if expiresIn > 0 {
+		req.ExpiresIn = expiresIn.String()
+	}
+	var out Maintenance
+	if err := c.do(ctx, http.MethodPut, maintenancePath(model), req, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ClearMaintenance takes model out of maintenance. A model that was not in
+// it is a *StatusError with status 404.
+func (c *Client) ClearMaintenance(ctx context.Context, model string) error {
+	return c.do(ctx, http.MethodDelete, maintenancePath(model), nil, nil, http.StatusNoContent)
+}
+
+// Upstream returns the current upstream.
+func (c *Client) Upstream(ctx context.Context) (*Upstream, error) {
+	var out Upstream
+	if err := c.do(ctx, http.MethodGet, "/admin/upstream", nil, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetUpstream switches the proxy to rawURL. The proxy probes it first and
+// refuses, with a *StatusError of status 409, one that does not answer
+// like Ollama unless force is set.
+func (c *Client) SetUpstream(ctx context.Context, rawURL string, force bool) (*UpstreamSwitch, error) {
+	var out UpstreamSwitch
+	if err := c.do(ctx, http.MethodPut, "/admin/upstream", UpstreamRequest{URL: rawURL, Force: force}, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// LastErrors returns the latest error response per model, only model's
+// when it is not empty.
+func (c *Client) LastErrors(ctx context.Context, model string) ([]LastError, error) {
+	path := "/debug/last-error"
+	if model != "" {
+		path += "?model=" + url.QueryEscape(model)
+	}
+	var out LastErrors
+	if err := c.do(ctx, http.MethodGet, path, nil, &out, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return out.Errors, nil
+}
+
+// maintenancePath escapes model, whose name may hold a slash.
+func maintenancePath(model string) string {
+	return "/admin/models/" + url.PathEscape(model) + "/maintenance"
+}
+
+// do sends body, when not nil, as JSON and decodes a response of one of the
+// ok statuses into out, when not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out any, ok ...int) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if v := resp.Header.Get(SchemaHeader); v != "" && v != SchemaVersion {
+		return &SchemaError{Got: v}
+	}
+	for _, status := range ok {
+		if resp.StatusCode != status {
+			continue
+		}
+		if out == nil {
+			return nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("proxyapi: decoding %s %s: %w", method, path, err)
+		}
+		return nil
+	}
+	var e ErrorResponse
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	return &StatusError{StatusCode: resp.StatusCode, Message: e.Error, Reason: e.Reason}
+}
@@ -0,0 +1,229 @@
+package proxyapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nexusriot/ollama-proxy-metrics/internal/db"
+	"github.com/nexusriot/ollama-proxy-metrics/internal/proxy"
+	"github.com/nexusriot/ollama-proxy-metrics/ollamatest"
+	"github.com/nexusriot/ollama-proxy-metrics/proxyapi"
+)
+
+const (
+	testToken = "secret"
+	model     = "llama3:8b" // ollamatest.DefaultModel
+)
+
+// liveProxy starts a proxy with its JSON endpoints mounted as the binary
+// mounts them, in front of a fake Ollama.
+func liveProxy(t *testing.T, opts ollamatest.Options) (*httptest.Server, *ollamatest.Server) {
+	t.Helper()
+	fake := ollamatest.NewServer(opts)
+	t.Cleanup(fake.Close)
+	u, err := proxy.ParseUpstream(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := db.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	h := proxy.New(u, store, slog.New(slog.NewTextHandler(io.Discard, nil)), proxy.NewMetrics(prometheus.NewRegistry()),
+		proxy.Config{ValidateUpstream: true, LatencyWindow: time.Minute})
+	t.Cleanup(func() { _ = h.Close() })
+
+	mux := http.NewServeMux()
+	mux.Handle("/", h)
+	mux.HandleFunc("GET /stats", h.ServeStats)
+	mux.HandleFunc("GET /readyz", h.ServeReady)
+	h.RegisterAdmin(mux, testToken)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, fake
+}
+
+func generate(t *testing.T, srv *httptest.Server) int {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/api/generate", "application/json", strings.NewReader(`{"model":"llama3:8b","stream":false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+// strict fetches path and decodes it into v refusing unknown fields, so a
+// handler sending a field the types lack fails the test.
+func strict(t *testing.T, srv *httptest.Server, path string, v any) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if got := resp.Header.Get(proxyapi.SchemaHeader); got != proxyapi.SchemaVersion {
+		t.Errorf("%s: expected schema version %s, got %q", path, proxyapi.SchemaVersion, got)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		t.Errorf("%s: %s does not match the types: %v", path, body, err)
+	}
+}
+
+func TestClient_StatsAndReady(t *testing.T) {
+	srv, _ := liveProxy(t, ollamatest.Options{})
+	c := proxyapi.NewClient(srv.URL+"/", "")
+	ctx := context.Background()
+	if status := generate(t, srv); status != http.StatusOK {
+		t.Fatalf("expected the request proxied, got %d", status)
+	}
+
+	st, err := c.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Upstream == "" || st.UpstreamSince.IsZero() || st.Malformed.Burst || st.Conformance == nil {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if l := st.Latency; l == nil || l.Window != "1m0s" || l.Models[model].DurationSeconds == nil || l.Models[model].DurationSeconds.Count != 1 {
+		t.Errorf("expected one duration in the latency window, got %+v", l)
+	}
+	if inflight, err := c.Inflight(ctx); err != nil || inflight[model] != 0 {
+		t.Errorf("expected nothing in flight, got %v, %v", inflight, err)
+	}
+	if r, err := c.Ready(ctx); err != nil || !r.Ready || r.Reason != "ok" {
+		t.Errorf("expected the proxy ready, got %+v, %v", r, err)
+	}
+
+	strict(t, srv, "/stats", &proxyapi.Stats{})
+	strict(t, srv, "/readyz", &proxyapi.Ready{})
+}
+
+func TestClient_Inflight(t *testing.T) {
+	srv, _ := liveProxy(t, ollamatest.Options{FirstChunkDelay: 300 * time.Millisecond})
+	c := proxyapi.NewClient(srv.URL, "")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Post(srv.URL+"/api/generate", "application/json", strings.NewReader(`{"model":"llama3:8b","stream":false}`))
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		inflight, err := c.Inflight(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inflight[model] == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the request in flight, got %v", inflight)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	<-done
+}
+
+func TestClient_Maintenance(t *testing.T) {
+	srv, _ := liveProxy(t, ollamatest.Options{})
+	c := proxyapi.NewClient(srv.URL, testToken)
+	ctx := context.Background()
+
+	m, err := c.SetMaintenance(ctx, model, "upgrading", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Model != model || m.Message != "upgrading" || m.ExpiresAt == nil || m.Expired(time.Now()) {
+		t.Errorf("unexpected entry %+v", m)
+	}
+	if status := generate(t, srv); status != http.StatusServiceUnavailable {
+		t.Errorf("expected the model rejected in maintenance, got %d", status)
+	}
+	if list, err := c.Maintenance(ctx); err != nil || len(list) != 1 || list[0].Model != model {
+		t.Errorf("expected the model listed, got %+v, %v", list, err)
+	}
+	strict(t, srv, "/admin/models", &proxyapi.MaintenanceList{})
+
+	if err := c.ClearMaintenance(ctx, model); err != nil {
+		t.Fatal(err)
+	}
+	var se *proxyapi.StatusError
+	if err := c.ClearMaintenance(ctx, model); !errors.As(err, &se) || se.StatusCode != http.StatusNotFound || se.Message != "model not in maintenance" {
+		t.Errorf("expected a 404 clearing it twice, got %v", err)
+	}
+	if _, err := proxyapi.NewClient(srv.URL, "wrong").Maintenance(ctx); !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized || se.Reason != "invalid_credential" {
+		t.Errorf("expected a 401 with a wrong token, got %v", err)
+	}
+}
+
+func TestClient_UpstreamAndLastErrors(t *testing.T) {
+	srv, fake := liveProxy(t, ollamatest.Options{})
+	c := proxyapi.NewClient(srv.URL, testToken)
+	ctx := context.Background()
+
+	up, err := c.Upstream(ctx)
+	if err != nil || up.URL == "" || up.Since.IsZero() {
+		t.Fatalf("unexpected upstream %+v, %v", up, err)
+	}
+	strict(t, srv, "/admin/upstream", &proxyapi.Upstream{})
+
+	notOllama := httptest.NewServer(http.NotFoundHandler())
+	defer notOllama.Close()
+	var se *proxyapi.StatusError
+	if _, err := c.SetUpstream(ctx, notOllama.URL, false); !errors.As(err, &se) || se.StatusCode != http.StatusConflict {
+		t.Errorf("expected the failed probe refused, got %v", err)
+	}
+	sw, err := c.SetUpstream(ctx, notOllama.URL, true)
+	if err != nil || sw.Previous != up.URL || sw.ProbeError == "" {
+		t.Errorf("expected a forced switch reporting the probe error, got %+v, %v", sw, err)
+	}
+	if sw, err := c.SetUpstream(ctx, fake.URL, false); err != nil || sw.ProbeError != "" {
+		t.Fatalf("expected the switch back, got %+v, %v", sw, err)
+	}
+
+	fake.Fail("/api/generate", ollamatest.Fault{Status: http.StatusInternalServerError, Error: "boom", Times: 1})
+	generate(t, srv)
+	errs, err := c.LastErrors(ctx, model)
+	if err != nil || len(errs) != 1 || errs[0].Status != http.StatusInternalServerError || errs[0].Source != "upstream" {
+		t.Errorf("expected the 500 kept, got %+v, %v", errs, err)
+	}
+	if errs, err := c.LastErrors(ctx, "other"); err != nil || len(errs) != 0 {
+		t.Errorf("expected no errors for another model, got %+v, %v", errs, err)
+	}
+	strict(t, srv, "/debug/last-error", &proxyapi.LastErrors{})
+}
+
+func TestClient_SchemaMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(proxyapi.SchemaHeader, "2")
+		_, _ = io.WriteString(w, `{"ready":true}`)
+	}))
+	defer srv.Close()
+	var se *proxyapi.SchemaError
+	if _, err := proxyapi.NewClient(srv.URL, "").Ready(context.Background()); !errors.As(err, &se) || se.Got != "2" {
+		t.Errorf("expected a schema error, got %v", err)
+	}
+}
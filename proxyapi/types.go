@@ -0,0 +1,162 @@
+// Package proxyapi holds the request and response types of the proxy's JSON
+// endpoints — /stats, /readyz and the token-protected admin API — and a thin
+// typed client for them. The handlers encode these same types, so what the
+// client decodes is what the proxy sends.
+//
+// The schema is versioned: every response carries SchemaHeader, and the
+// client refuses a response of another SchemaVersion rather than decode it
+// into the wrong shape. Fields are only ever added within a version; a
+// rename, removal or change of meaning bumps it.
+package proxyapi
+
+import "time"
+
+// SchemaVersion is the version of the types in this package.
+const SchemaVersion = "1"
+
+// SchemaHeader is the response header giving the SchemaVersion a JSON
+// response was encoded with.
+const SchemaHeader = "X-Ollama-Proxy-Schema"
+
+// Stats is the body of GET /stats.
+type Stats struct {
+	// Upstream is the current upstream as DescribeUpstream shows it, and
+	// UpstreamSince when it became current.
+	Upstream      string    `json:"upstream"`
+	UpstreamSince time.Time `json:"upstream_since"`
+	// InFlight counts the requests in flight per model.
+	InFlight  map[string]int `json:"inflight"`
+	Malformed Malformed      `json:"malformed"`
+	// Conformance is set when the proxy validates upstream responses.
+	Conformance *Conformance `json:"conformance,omitempty"`
+	// Latency is set when the proxy keeps a latency window.
+	Latency *Latency `json:"latency,omitempty"`
+}
+
+// Malformed reports the stream lines that were not valid JSON in the last
+// minute; the Last fields are set once one was seen.
+type Malformed struct {
+	LastMinute    int        `json:"last_minute"`
+	Burst         bool       `json:"burst"`
+	LastAt        *time.Time `json:"last_at,omitempty"`
+	LastModel     string     `json:"last_model,omitempty"`
+	LastRequestID string     `json:"last_request_id,omitempty"`
+}
+
+// Conformance totals the upstream responses that broke their endpoint's
+// schema by violation kind; the Last fields are set once one was seen.
+type Conformance struct {
+	Violations    map[string]int64 `json:"violations"`
+	LastAt        *time.Time       `json:"last_at,omitempty"`
+	LastKind      string           `json:"last_kind,omitempty"`
+	LastEndpoint  string           `json:"last_endpoint,omitempty"`
+	LastRequestID string           `json:"last_request_id,omitempty"`
+}
+
+// Latency holds each model's latency quantiles over the sliding Window (a
+// Go duration), estimated to within RelativeError.
+type Latency struct {
+	Window        string                  `json:"window"`
+	RelativeError float64                 `json:"relative_error"`
+	Models        map[string]ModelLatency `json:"models"`
+}
+
+// ModelLatency is one model's entry in Latency; a quantile set is nil when
+// nothing of its kind was observed in the window.
+type ModelLatency struct {
+	DurationSeconds *Quantiles `json:"duration_seconds,omitempty"`
+	TTFTSeconds     *Quantiles `json:"ttft_seconds,omitempty"`
+}
+
+// Quantiles summarises Count observations, in seconds.
+type Quantiles struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+// Ready is the body of GET /readyz, sent with 200 when Ready and 503 when
+// not.
+type Ready struct {
+	Ready            bool    `json:"ready"`
+	Reason           string  `json:"reason"`
+	InFlight         int64   `json:"inflight"`
+	QueueWaitSeconds float64 `json:"queue_wait_seconds"`
+	// Error says why the upstream probe failed when Reason is "upstream".
+	Error string `json:"error,omitempty"`
+}
+
+// Maintenance marks one model as unavailable on purpose. It is the response
+// of PUT /admin/models/{model}/maintenance.
+type Maintenance struct {
+	Model     string     `json:"model"`
+	Message   string     `json:"message,omitempty"`
+	Since     time.Time  `json:"since"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the maintenance is over at now.
+func (m Maintenance) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+// MaintenanceList is the body of GET /admin/models, ordered by model.
+type MaintenanceList struct {
+	Maintenance []Maintenance `json:"maintenance"`
+}
+
+// MaintenanceRequest is the body of PUT /admin/models/{model}/maintenance.
+// Both expiry fields are optional; ExpiresIn wins when both are set.
+type MaintenanceRequest struct {
+	Message   string     `json:"message,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn string     `json:"expires_in,omitempty"` // Go duration, e.g. "2h"
+}
+
+// Upstream is the body of GET /admin/upstream.
+type Upstream struct {
+	URL   string    `json:"url"`
+	Since time.Time `json:"since"`
+}
+
+// UpstreamRequest is the body of PUT /admin/upstream.
+type UpstreamRequest struct {
+	URL   string `json:"url"`
+	Force bool   `json:"force,omitempty"` // accept even if the probe fails
+}
+
+// UpstreamSwitch is the response of PUT /admin/upstream. ProbeError is set
+// when a forced switch went ahead despite a failed probe.
+type UpstreamSwitch struct {
+	URL        string `json:"url"`
+	Previous   string `json:"previous"`
+	ProbeError string `json:"probe_error,omitempty"`
+}
+
+// LastError is the most recent error response seen for one model.
+type LastError struct {
+	Model     string    `json:"model"`
+	Endpoint  string    `json:"endpoint"`
+	Status    int       `json:"status"`
+	Body      string    `json:"body"`
+	Truncated bool      `json:"truncated,omitempty"`
+	At        time.Time `json:"at"`
+	RequestID string    `json:"request_id"`
+	// Source is "upstream" for responses from Ollama, "proxy" when no
+	// upstream response was obtained (connection errors and the like) and
+	// "conformance" for a 2xx response that broke its endpoint's schema.
+	Source string `json:"source"`
+}
+
+// LastErrors is the body of GET /debug/last-error, ordered by model.
+type LastErrors struct {
+	Errors []LastError `json:"errors"`
+}
+
+// ErrorResponse is the body of a failed call. Reason is set on a 401 and
+// names the authentication failure mode.
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason,omitempty"`
+}